@@ -1,39 +1,99 @@
 // Command attribute_index is a command-line interface for testing the attribute
 // embedding index.
-// It prints the names of the 20 most similar datasets to the query attribute.
+// It prints the names of the 20 datasets with the most similar attributes to
+// the query attribute name, combining the similarities of each dataset's
+// attributes as given by the -agg flag (max, mean, or count), followed by the
+// matching attributes.
+// With -values, the query is matched against the embeddings of the columns'
+// sampled values (see process_values) instead of their names.
 // chem
 package main
 
 import (
-	"database/sql"
+	"flag"
 	"fmt"
+	"log"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/attributeembedding"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func randomDataset(db *database.DB) string {
-	var datasetID string
-	err := db.QueryRow(`
-	SELECT dataset_id
-	FROM attribute_vectors
-	ORDER BY RANDOM() LIMIT 1`).Scan(&datasetID)
+var (
+	aggName = flag.String("agg", "max", "how to combine attribute similarities: max, mean, or count")
+	values  = flag.Bool("values", false, "match the query against the column value embeddings")
+)
+
+func randomAttribute(db *database.DB) string {
+	query := `SELECT attribute_name FROM attribute_vectors ORDER BY RANDOM() LIMIT 1`
+	if *values {
+		query = `SELECT column_name FROM value_vectors ORDER BY RANDOM() LIMIT 1`
+	}
+	var name string
+	err := db.QueryRow(query).Scan(&name)
 	if err != nil {
 		panic(err)
 	}
-	return datasetID
+	return name
 }
 
 func main() {
+	config.ParseFlags()
+
+	agg, err := index.ParseAggregation(*aggName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	db, err := database.New(config.DatabasePath())
 	if err != nil {
 		panic(err)
 	}
 	defer db.Close()
 
-	idx, err := index.BuildMetadataEmbeddingIndex(db)
+	embeddingConfig, err := config.Embedding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	categoryModels, err := config.CategoryModels()
+	if err != nil {
+		log.Fatal(err)
+	}
+	embedder, versions, err := embedding.OpenWithVersions(embeddingConfig, categoryModels)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer embedder.Close()
+	vectors := "attribute_vectors"
+	if *values {
+		vectors = "value_vectors"
+	}
+	if err := index.CheckVersions(db, vectors, versions); err != nil {
+		log.Fatal(err)
+	}
+
+	params, err := config.IndexParameters()
+	if err != nil {
+		panic(err)
+	}
+	if err := embedding.CheckDim(embedder, params.EmbeddingDim); err != nil {
+		panic(err)
+	}
+	tokenizer, err := config.AttributeTokenizer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var idx *index.AttributeIndex
+	if *values {
+		idx, err = index.BuildValueEmbeddingIndex(db, params.EmbeddingDim)
+	} else if path := config.AttributeIndexPath(); path != "" {
+		idx, err = index.BuildOnDiskAttributeIndex(db, params.EmbeddingDim, path)
+	} else {
+		idx, err = index.BuildAttributeEmbeddingIndex(db, params.EmbeddingDim)
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -41,44 +101,38 @@ func main() {
 
 	for {
 		var query string
-		fmt.Print("query dataset (random if empty): ")
+		fmt.Print("query attribute (random if empty): ")
 		fmt.Scanln(&query)
 
 		if query == "" {
-			query = randomDataset(db)
+			query = randomAttribute(db)
+			fmt.Println("query attribute:", query)
 		}
+		fmt.Println()
 
-		vec, err := db.MetadataVector(query)
+		vec, err := tokenizer.Vector(embedder, query)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				fmt.Println("no such dataset:", query)
+			if err == attributeembedding.ErrNoEmb {
+				fmt.Println("no embedding for attribute:", query)
 				continue
 			}
 			panic(err)
 		}
 
-		queryName, err := db.DatasetName(query)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("query name:", queryName)
-		fmt.Println()
-
-		ids, cos, err := idx.Query(vec, 21)
+		matches, err := idx.QueryDatasets(vec, 20, agg)
 		if err != nil {
 			panic(err)
 		}
 
-		for i, datasetID := range ids {
-			// Skip query dataset
-			if i == 0 {
-				continue
-			}
-			name, err := db.DatasetName(datasetID)
+		for _, m := range matches {
+			name, err := db.DatasetName(m.DatasetID)
 			if err != nil {
 				panic(err)
 			}
-			fmt.Printf("%.3f %v\n", cos[i], name)
+			fmt.Printf("%.3f %v\n", m.Score, name)
+			for _, a := range m.Attributes {
+				fmt.Printf("      %.3f %v\n", a.Similarity, a.AttributeName)
+			}
 		}
 		fmt.Println()
 	}
@@ -9,9 +9,9 @@ import (
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
-	"github.com/ekzhu/go-fasttext"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -28,7 +28,7 @@ func parseFlags() {
 }
 
 func main() {
-	flag.Parse()
+	config.ParseFlags()
 	if *orgGamma != "" {
 		tmp, err := strconv.Atoi(*orgGamma)
 		if err == nil {
@@ -60,10 +60,24 @@ func main() {
 	}
 	defer db.Close()
 
-	ft := fasttext.NewFastText(config.FasttextPath())
-	defer ft.Close()
+	embeddingConfig, err := config.Embedding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	embedder, err := embedding.Open(embeddingConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer embedder.Close()
 
-	metadataIndex, err := index.BuildMetadataEmbeddingIndex(db)
+	params, err := config.IndexParameters()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := embedding.CheckDim(embedder, params.EmbeddingDim); err != nil {
+		log.Fatal(err)
+	}
+	metadataIndex, err := index.BuildMetadataEmbeddingIndex(db, params.EmbeddingDim)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -91,7 +105,7 @@ func main() {
 	}
 
 	start := time.Now()
-	organization, _ := navigation.BuildOrganization(db, ft, orgConf, orgIds)
+	organization, _ := navigation.BuildOrganization(db, embedder, orgConf, orgIds)
 	_ = organization
 	t := time.Now()
 	fmt.Printf("Time:%0.9f\n", t.Sub(start).Seconds())
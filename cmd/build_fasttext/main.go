@@ -1,16 +1,20 @@
-// Command build_fasttext builds a fastText SQLite database.
+// Command build_fasttext builds a fastText SQLite database, at the path given
+// by -fasttext (see config.FasttextPath), from the vectors read from standard
+// input.
 package main
 
 import (
 	"log"
 	"os"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
 	"github.com/ekzhu/go-fasttext"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
-	ft := fasttext.NewFastText("fasttext.sqlite")
+	config.ParseFlags()
+	ft := fasttext.NewFastText(config.FasttextPath())
 	defer ft.Close()
 
 	if err := ft.BuildDB(os.Stdin); err != nil {
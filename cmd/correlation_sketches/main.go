@@ -0,0 +1,373 @@
+// Command correlation_sketches computes the correlation sketches of the pairs
+// of key columns and numeric columns of the datasets in the Open Data Link
+// database (see corrsketch), for finding the columns of other datasets that
+// correlate with a column once the datasets are joined.
+//
+// The column types inferred by sketch_columns select the columns: the key
+// columns of a dataset are its (up to -maxkeys) string, int, and date columns
+// with the most distinct values, and its numeric columns are its int and float
+// columns. The dataset files are read from datasets/<id>/rows.csv or its
+// compressed version, from the CSV files listed in the manifest, or from the
+// partitions of partitioned datasets, as by sketch_columns.
+//
+// If the database has a dataset_checksums table, the checksums of the files
+// of the datasets sketched are recorded, and with -changed-only only the
+// datasets whose files changed since they were last sketched are sketched
+// again (see package checksum). The sketches are committed in chunks of
+// datasets, retried while the database is locked by another connection, such
+// as a running server (see database.Chunker).
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/checksum"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/corrsketch"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/hooks"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/layout"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/partition"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Layout of the datasets (see config.DatasetLayout), set by main.
+var datasets *layout.Layout
+
+// Ingestion hooks (see package hooks), set by main.
+var datasetHooks *hooks.Hooks
+
+// Checksums of the dataset files (see package checksum), set by main.
+var checksums *checksum.Tracker
+
+const (
+	// Number of worker goroutines
+	numWorkers = 16
+	// Minimum number of distinct values of the key columns
+	minKeyDistinct = 10
+	// Number of datasets whose sketches are committed together
+	datasetsPerCommit = 100
+)
+
+var (
+	size    = flag.Int("size", 256, "maximum number of keys of each sketch")
+	maxKeys = flag.Int("maxkeys", 3, "maximum number of key columns of each dataset")
+)
+
+// column is a column of a dataset and its position in the dataset file.
+type column struct {
+	id       string
+	index    int
+	distinct int
+}
+
+// plan is the key and numeric columns of a dataset to sketch.
+type plan struct {
+	datasetID     string
+	keys, numeric []*column
+}
+
+// pairSketch is the sketch of a pair of a key column and a numeric column.
+type pairSketch struct {
+	key, value *column
+	builder    *corrsketch.Builder
+}
+
+type result struct {
+	plan     *plan
+	sketches []*pairSketch
+}
+
+// plans returns the plans of the datasets with at least one key column and one
+// numeric column.
+func plans(db *database.DB) ([]*plan, error) {
+	types, err := db.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+	SELECT column_id, dataset_id, distinct_count FROM column_sketches ORDER BY dataset_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byDataset := make(map[string]*plan)
+	var ps []*plan
+	for rows.Next() {
+		var c column
+		var datasetID string
+		if err := rows.Scan(&c.id, &datasetID, &c.distinct); err != nil {
+			return nil, err
+		}
+		// Column IDs are "<dataset ID>-<column number>".
+		if c.index, err = strconv.Atoi(c.id[strings.LastIndex(c.id, "-")+1:]); err != nil {
+			return nil, fmt.Errorf("invalid column ID %q", c.id)
+		}
+		p := byDataset[datasetID]
+		if p == nil {
+			p = &plan{datasetID: datasetID}
+			byDataset[datasetID] = p
+			ps = append(ps, p)
+		}
+		switch t := types[c.id]; {
+		case t.Numeric():
+			p.numeric = append(p.numeric, &c)
+			if t == coltype.Int && c.distinct >= minKeyDistinct {
+				p.keys = append(p.keys, &c)
+			}
+		case t == coltype.String || t == coltype.Date:
+			if c.distinct >= minKeyDistinct {
+				p.keys = append(p.keys, &c)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var selected []*plan
+	for _, p := range ps {
+		sort.SliceStable(p.keys, func(i, j int) bool {
+			return p.keys[i].distinct > p.keys[j].distinct
+		})
+		if len(p.keys) > *maxKeys {
+			p.keys = p.keys[:*maxKeys]
+		}
+		if len(p.keys) > 0 && len(p.numeric) > 0 {
+			selected = append(selected, p)
+		}
+	}
+	return selected, nil
+}
+
+// openRows opens the rows.csv file of the dataset with the given ID, or merges
+// its partitions in the order in which sketch_columns numbers their columns if
+// it is partitioned.
+func openRows(datasetID string) (io.ReadCloser, error) {
+	path, err := datasets.Path(datasetID, "rows.csv")
+	if err == nil {
+		if path, err = datafile.Find(path); err == nil {
+			return datasetHooks.Open(datasetID, "rows.csv", path)
+		}
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	dir, derr := datasets.Path(datasetID, "rows")
+	if derr != nil {
+		return nil, err
+	}
+	if info, derr := os.Stat(dir); derr != nil || !info.IsDir() {
+		return nil, err
+	}
+	parts, err := partition.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	return partition.CSV(parts, func(p *partition.Part) (io.ReadCloser, error) {
+		return datasetHooks.Open(datasetID, p.Name, p.Path)
+	})
+}
+
+// sketchDataset computes the sketches of the pairs of key and numeric columns
+// of the dataset of p.
+func sketchDataset(p *plan) ([]*pairSketch, error) {
+	var sketches []*pairSketch
+	for _, k := range p.keys {
+		for _, v := range p.numeric {
+			if k != v {
+				sketches = append(sketches, &pairSketch{k, v, corrsketch.NewBuilder(*size)})
+			}
+		}
+	}
+	f, err := openRows(p.datasetID)
+	if err != nil {
+		return nil, fmt.Errorf("error sketching %v: %w", p.datasetID, err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.LazyQuotes = true
+	r.ReuseRecord = true
+	// The header has no values.
+	if _, err := r.Read(); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error sketching %v: %w", p.datasetID, err)
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error sketching %v: %w", p.datasetID, err)
+		}
+		for _, s := range sketches {
+			if s.key.index < len(record) && s.value.index < len(record) {
+				s.builder.Add(record[s.key.index], record[s.value.index])
+			}
+		}
+	}
+	return sketches, nil
+}
+
+// worker sketches the datasets of the plans received from jobs, recording the
+// datasets whose files exist but cannot be read in fails.
+func worker(jobs <-chan *plan, out chan<- *result, fails *failures.Recorder) {
+	for p := range jobs {
+		if changed, err := checksums.Changed(p.datasetID); err != nil || !changed {
+			if err != nil {
+				fails.Fail(p.datasetID, err)
+			}
+			out <- &result{plan: p}
+			continue
+		}
+		log.Println("sketching", p.datasetID)
+		sketches, err := sketchDataset(p)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				log.Println(err)
+			} else {
+				fails.Fail(p.datasetID, err)
+			}
+		}
+		out <- &result{p, sketches}
+	}
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("correlation_sketches", "datasets", false)
+	var err error
+	if datasets, err = config.DatasetLayout(); err != nil {
+		log.Fatal(err)
+	}
+	if *size < 2 || *maxKeys < 1 {
+		log.Fatal("-size must be at least 2 and -maxkeys at least 1")
+	}
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"correlation_sketches", "column_types"} {
+		if ok, err := database.HasTable(db, table); err != nil {
+			log.Fatal(err)
+		} else if !ok {
+			log.Fatalf("no %v table (see sql/create_%[1]v_table.sql)", table)
+		}
+	}
+	ps, err := plans(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fails, err := failures.Start("correlation_sketches")
+	if err != nil {
+		log.Fatal(err)
+	}
+	datasetHooks = hooks.Start("correlation_sketches", fails, false)
+	if checksums, err = checksum.Start(db.DB, datasets, "correlation_sketches", "rows.csv", "rows"); err != nil {
+		log.Fatal(err)
+	}
+	jobs := make(chan *plan, len(ps))
+	out := make(chan *result, len(ps))
+
+	for i := 0; i < numWorkers; i++ {
+		go worker(jobs, out, fails)
+	}
+	for _, p := range ps {
+		jobs <- p
+	}
+	close(jobs)
+
+	// The sketches are committed in chunks of datasets, so that a running
+	// server is not locked out of the database.
+	var deleteStmt, insertStmt *sql.Stmt
+	chunk := &database.Chunker{
+		DB:   db.DB,
+		Size: datasetsPerCommit,
+		Prepare: func(tx *sql.Tx) (err error) {
+			if deleteStmt, err = tx.Prepare(`DELETE FROM correlation_sketches WHERE dataset_id = ?`); err != nil {
+				return err
+			}
+			insertStmt, err = tx.Prepare(`
+			INSERT INTO correlation_sketches
+			(key_column_id, value_column_id, dataset_id, size, entries)
+			VALUES (?, ?, ?, ?, ?)
+			`)
+			return err
+		},
+		Committed: datasetHooks.Committed,
+	}
+
+	var written int
+	for range ps {
+		res := <-out
+		if res.sketches == nil {
+			continue
+		}
+		// The sketches of a dataset are written in a savepoint, so that a
+		// dataset that cannot be written is skipped.
+		var n int
+		err := chunk.Write(func(tx *sql.Tx) error {
+			n = 0
+			if _, err := deleteStmt.Exec(res.plan.datasetID); err != nil {
+				return err
+			}
+			for _, s := range res.sketches {
+				sketch := s.builder.Sketch()
+				if sketch == nil {
+					continue
+				}
+				entries, err := json.Marshal(sketch.Entries)
+				if err != nil {
+					return err
+				}
+				if _, err := insertStmt.Exec(s.key.id, s.value.id, res.plan.datasetID, sketch.Size, entries); err != nil {
+					return err
+				}
+				n++
+			}
+			return checksums.Store(tx, res.plan.datasetID)
+		})
+		if err != nil {
+			fails.Fail(res.plan.datasetID, err)
+		} else {
+			written += n
+			datasetHooks.Stored(res.plan.datasetID)
+		}
+		if err := chunk.Done(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := chunk.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d correlation sketches of %d datasets", written, len(ps)-checksums.Unchanged())
+	if err := fails.Finish(db.DB, false); err != nil {
+		log.Fatal(err)
+	}
+	// There is no progress report: every dataset planned is processed.
+	summary.Processed, summary.Total = int64(len(ps)), int64(len(ps))
+	summary.Skipped = checksums.Unchanged()
+	summary.Count("sketches", written)
+	if err := summary.Finish(db.DB, nil, fails); err != nil {
+		log.Fatal(err)
+	}
+}
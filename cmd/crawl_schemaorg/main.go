@@ -0,0 +1,491 @@
+// Command crawl_schemaorg crawls web sites for the schema.org Dataset markup
+// of their pages, which describes datasets published outside of data
+// catalogs, for the ingestion pipeline.
+//
+// The pages of each of the -seeds sites are crawled from the seed URL, and
+// from the sitemaps listed by the robots.txt of the site, following the links
+// to the pages of the same host up to -depth links away from the seed, and up
+// to -max pages per site. The crawl is polite: it identifies itself with the
+// -useragent, obeys the rules and the crawl delay of robots.txt for that
+// agent, and the robots meta tags of the pages, waits -delay between the
+// requests to a site, and retries the requests throttled or failed by the
+// server with backoff (see package crawl).
+//
+// The schema.org Dataset resources of the JSON-LD scripts of each page are
+// mapped into metadata (see dcat.SchemaOrgDatasets): the name, description,
+// publisher or creator, modification date, keywords, and url of each dataset
+// become its name, description, attribution, update time, tags, and
+// permalink, and its variables and distributions the columns and the files of
+// the dataset. The metadata is written to datasets/<id>/metadata.json (see
+// config.DatasetsDir), and stored in the metadata, source_columns, and
+// source_distributions tables, and the datasets whose metadata changed are
+// marked for processing (see package harvest):
+//
+//	crawl_schemaorg -seeds https://data.example.org/datasets
+//	process_metadata
+//
+// The pages that cannot be fetched are skipped and counted in the run
+// summary; the datasets that cannot be written are skipped, and their
+// failures reported (see package failures).
+package main
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/crawl"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/dcat"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	seeds    = flag.String("seeds", "", "comma-separated URLs of the pages from which the sites are crawled, such as https://data.example.org/datasets")
+	maxDepth = flag.Int("depth", 3, "maximum number of links followed from the seed of each site")
+	maxPages = flag.Int("max", 1000, "maximum number of pages crawled from each site")
+	delay    = flag.Duration("delay", time.Second, "delay between the requests to a site, unless its robots.txt or -hostdelay asks for a longer one")
+	lang     = flag.String("lang", "en", "language of the literals preferred, of the pages in several languages")
+	sitemaps = flag.Bool("sitemaps", true, "also crawl the pages listed by the sitemaps of robots.txt")
+)
+
+const (
+	// Maximum size of a page read, and of a sitemap
+	maxPageSize = 10 << 20
+	// Maximum number of sitemaps read of a site, with those of sitemap
+	// indexes
+	maxSitemaps = 100
+	// Timeout of each request
+	requestTimeout = 30 * time.Second
+)
+
+// Client of the requests, set by main.
+var client *crawl.Client
+
+// site is a site being crawled.
+type site struct {
+	host   string
+	robots *crawl.Robots
+}
+
+// startSite returns the site of the seed, with the rules of its robots.txt,
+// which allow all if it has none.
+func startSite(seed *url.URL) (*site, error) {
+	r, err := client.Robots(seed)
+	if err != nil {
+		return nil, err
+	}
+	return &site{host: seed.Host, robots: r}, nil
+}
+
+// sitemap is a sitemap, or a sitemap index.
+type sitemap struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// sitemapPages returns the URLs of the pages listed by the sitemaps of s, and
+// by the sitemaps of their indexes, up to max.
+func (s *site) sitemapPages(max int) []string {
+	var pages []string
+	queue := append([]string(nil), s.robots.Sitemaps...)
+	for read := 0; len(queue) > 0 && read < maxSitemaps && len(pages) < max; read++ {
+		u := queue[0]
+		queue = queue[1:]
+		var sm sitemap
+		if err := s.readSitemap(u, &sm); err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, p := range sm.URLs {
+			pages = append(pages, strings.TrimSpace(p.Loc))
+		}
+		for _, index := range sm.Sitemaps {
+			queue = append(queue, strings.TrimSpace(index.Loc))
+		}
+	}
+	if len(pages) > max {
+		pages = pages[:max]
+	}
+	return pages
+}
+
+func (s *site) readSitemap(u string, sm *sitemap) error {
+	res, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var r io.Reader = io.LimitReader(res.Body, maxPageSize)
+	if strings.HasSuffix(u, ".gz") {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("%v: %w", u, err)
+		}
+		r = io.LimitReader(zr, maxPageSize)
+	}
+	if err := xml.NewDecoder(r).Decode(sm); err != nil {
+		return fmt.Errorf("%v: %w", u, err)
+	}
+	return nil
+}
+
+// page is what the crawler reads of an HTML page.
+type page struct {
+	// Contents of the JSON-LD scripts.
+	scripts []string
+	// Targets of the links, as written.
+	links []string
+	// Base URL of the links, if set.
+	base string
+	// Whether the robots meta tags forbid indexing the page, or following
+	// its links.
+	noIndex, noFollow bool
+}
+
+// Elements whose contents are not HTML
+var rawElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// scanHTML scans the HTML document s for the scripts, links, base URL, and
+// robots meta tags read by the crawler.
+func scanHTML(s string) *page {
+	p := &page{}
+	lower := strings.ToLower(s)
+	for i := 0; i < len(s); {
+		j := strings.IndexByte(s[i:], '<')
+		if j < 0 {
+			break
+		}
+		i += j + 1
+		if strings.HasPrefix(s[i:], "!--") {
+			end := strings.Index(s[i:], "-->")
+			if end < 0 {
+				break
+			}
+			i += end + 3
+			continue
+		}
+		name, attrs, n := scanTag(s[i:])
+		i += n
+		switch name {
+		case "a", "area", "link":
+			if href, ok := attrs["href"]; ok && !strings.Contains(" "+attrs["rel"]+" ", " nofollow ") &&
+				(name != "link" || attrs["rel"] == "alternate") {
+				p.links = append(p.links, href)
+			}
+		case "base":
+			if href, ok := attrs["href"]; ok && p.base == "" {
+				p.base = href
+			}
+		case "meta":
+			if agent := strings.ToLower(attrs["name"]); agent == "robots" || agent == strings.ToLower(client.Agent()) {
+				for _, d := range strings.Split(strings.ToLower(attrs["content"]), ",") {
+					switch strings.TrimSpace(d) {
+					case "noindex":
+						p.noIndex = true
+					case "nofollow":
+						p.noFollow = true
+					case "none":
+						p.noIndex, p.noFollow = true, true
+					}
+				}
+			}
+		}
+		if rawElements[name] {
+			end := strings.Index(lower[i:], "</"+name)
+			if end < 0 {
+				end = len(s) - i
+			}
+			if name == "script" && strings.EqualFold(strings.TrimSpace(strings.SplitN(attrs["type"], ";", 2)[0]), "application/ld+json") {
+				p.scripts = append(p.scripts, s[i:i+end])
+			}
+			i += end
+		}
+	}
+	return p
+}
+
+// scanTag scans the tag at the start of s, after its <, returning its name and
+// attributes in lower case, and its length. The name of an end tag, or a
+// declaration, is empty.
+func scanTag(s string) (name string, attrs map[string]string, n int) {
+	i := 0
+	for i < len(s) && isTagChar(s[i]) {
+		i++
+	}
+	name = strings.ToLower(s[:i])
+	attrs = make(map[string]string)
+	for i < len(s) && s[i] != '>' {
+		if c := s[i]; c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '/' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && !strings.ContainsRune(" \t\n\r/>=", rune(s[i])) {
+			i++
+		}
+		key := strings.ToLower(s[start:i])
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+			i++
+		}
+		var value string
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				q := s[i]
+				end := strings.IndexByte(s[i+1:], q)
+				if end < 0 {
+					return name, attrs, len(s)
+				}
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start := i
+				for i < len(s) && !strings.ContainsRune(" \t\n\r>", rune(s[i])) {
+					i++
+				}
+				value = s[start:i]
+			}
+		}
+		if key != "" {
+			if _, ok := attrs[key]; !ok {
+				attrs[key] = html.UnescapeString(value)
+			}
+		}
+	}
+	if i < len(s) {
+		i++
+	}
+	return name, attrs, i
+}
+
+func isTagChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// pageURL is a URL to crawl, at a depth of links from the seed.
+type pageURL struct {
+	url   string
+	depth int
+}
+
+// crawler crawls the sites, writing the datasets of their pages.
+type crawler struct {
+	w     *harvest.Writer
+	fails *failures.Recorder
+	// IDs of the datasets written, as the pages of a site may describe a
+	// dataset more than once.
+	datasets map[string]bool
+	// Numbers of pages crawled and that could not be, and of datasets
+	// found, written, and changed.
+	pages, pageErrors        int
+	listed, written, changed int
+}
+
+// normalize returns the URL of the link target ref of the page at base, or ""
+// if it is not of the host, without its fragment.
+func normalize(base *url.URL, ref, host string) string {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Host != host || u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// crawlSite crawls the site of the seed.
+func (c *crawler) crawlSite(seed string) error {
+	seedURL, err := url.Parse(seed)
+	if err != nil {
+		return err
+	}
+	if seedURL.Scheme != "http" && seedURL.Scheme != "https" {
+		return fmt.Errorf("seed %v is not an http or https URL", seed)
+	}
+	s, err := startSite(seedURL)
+	if err != nil {
+		return err
+	}
+	queue := []pageURL{{seedURL.String(), 0}}
+	if *sitemaps {
+		// The links of the pages of sitemaps are not followed.
+		for _, p := range s.sitemapPages(*maxPages) {
+			if u := normalize(seedURL, p, s.host); u != "" {
+				queue = append(queue, pageURL{u, *maxDepth})
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	for _, p := range queue {
+		seen[p.url] = true
+	}
+	for crawled := 0; len(queue) > 0 && crawled < *maxPages; {
+		p := queue[0]
+		queue = queue[1:]
+		u, err := url.Parse(p.url)
+		if err != nil || !s.robots.Allowed(u.RequestURI()) {
+			continue
+		}
+		crawled++
+		links, err := c.crawlPage(s, u)
+		if err != nil {
+			log.Println(err)
+			c.pageErrors++
+			continue
+		}
+		if p.depth == *maxDepth {
+			continue
+		}
+		for _, l := range links {
+			if !seen[l] {
+				seen[l] = true
+				queue = append(queue, pageURL{l, p.depth + 1})
+			}
+		}
+	}
+	// The datasets of the site are committed before the next site is
+	// crawled.
+	return c.w.Close()
+}
+
+// crawlPage crawls the page at u of the site, writing its datasets, and
+// returns the URLs of the pages of the site it links to.
+func (c *crawler) crawlPage(s *site, u *url.URL) ([]string, error) {
+	res, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	c.pages++
+	if ct := res.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxPageSize))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", u, err)
+	}
+	// The links are relative to the URL redirected to.
+	u = res.Request.URL
+	p := scanHTML(string(body))
+	if !p.noIndex {
+		for _, script := range p.scripts {
+			c.writeDatasets(s, u.String(), script)
+		}
+	}
+	if p.noFollow {
+		return nil, nil
+	}
+	base := u
+	if p.base != "" {
+		if b, err := u.Parse(p.base); err == nil {
+			base = b
+		}
+	}
+	var links []string
+	for _, l := range p.links {
+		if target := normalize(base, l, s.host); target != "" {
+			links = append(links, target)
+		}
+	}
+	return links, nil
+}
+
+// writeDatasets writes the datasets of the JSON-LD script of the page of the
+// site.
+func (c *crawler) writeDatasets(s *site, page, script string) {
+	g, err := dcat.ParseJSONLD(strings.NewReader(script), page)
+	if err != nil {
+		log.Printf("%v: %v", page, err)
+		return
+	}
+	for _, d := range dcat.SchemaOrgDatasets(g, page, *lang) {
+		if c.datasets[d.DatasetID] {
+			continue
+		}
+		c.datasets[d.DatasetID] = true
+		c.listed++
+		d.Source = "schemaorg:" + s.host
+		log.Println("writing", d.DatasetID, "of", page)
+		ok, err := c.w.Write(d, nil)
+		if err != nil {
+			c.fails.Fail(d.DatasetID, err)
+			continue
+		}
+		c.written++
+		if ok {
+			c.changed++
+		}
+	}
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("crawl_schemaorg", "datasets", false)
+	if *seeds == "" {
+		log.Fatal("no -seeds to crawl")
+	}
+	client = crawl.NewClient()
+	client.HTTP = &http.Client{Timeout: requestTimeout}
+	client.ObeyRobots = true
+	if *delay > client.Delay {
+		client.Delay = *delay
+	}
+	if strings.TrimSpace(client.UserAgent) == "" {
+		log.Fatal("no -useragent to identify the crawler")
+	}
+	if *maxDepth < 0 || *maxPages < 1 {
+		log.Fatal("-depth must not be negative and -max must be positive")
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	w, err := harvest.Start(db, config.DatasetsDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fails, err := failures.Start("crawl_schemaorg")
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := &crawler{w: w, fails: fails, datasets: make(map[string]bool)}
+	for _, seed := range strings.Split(*seeds, ",") {
+		if err := c.crawlSite(strings.TrimSpace(seed)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("crawled %d pages (%d errors); wrote %d of %d datasets; %d changed",
+		c.pages, c.pageErrors, c.written, c.listed, c.changed)
+	if err := fails.Finish(db, false); err != nil {
+		log.Fatal(err)
+	}
+	summary.Processed, summary.Total = int64(c.written), int64(c.listed)
+	summary.Count("pages", c.pages)
+	summary.Count("page_errors", c.pageErrors)
+	summary.Count("changed", c.changed)
+	if err := summary.Finish(db, nil, fails); err != nil {
+		log.Fatal(err)
+	}
+}
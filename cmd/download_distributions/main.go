@@ -0,0 +1,378 @@
+// Command download_distributions downloads the tables of the harvested
+// datasets, the files listed by their catalogs in the source_distributions
+// table (see package harvest), for the ingestion pipeline.
+//
+// The first distribution of each dataset of one of the -formats, by its format
+// or else by the extension of its URL, is downloaded to the -cache directory
+// (see package download), and linked into the directory of the dataset with
+// the conventional name of its format, such as rows.csv, or rows.csv.gz for a
+// compressed file (see package layout). The downloads are recorded in the
+// distribution_downloads table (see
+// sql/create_distribution_downloads_table.sql), with the checksums and the
+// validators of their files, so that the next downloads only download the
+// files that changed since, with conditional requests, and resume the
+// downloads interrupted before, with range requests. The datasets whose files
+// changed are marked for processing, as by the harvesters:
+//
+//	harvest_ckan -portals https://catalog.data.gov -samplerows 0
+//	download_distributions
+//	process_datasets -changed-only
+//
+// The datasets that already have a table not downloaded by
+// download_distributions, such as the sample of a harvester, are skipped,
+// unless -replace is set; the next harvest of such a dataset writes its
+// sample back. The files of the cache that no dataset links to any longer are
+// removed at the end of each run.
+//
+// The datasets whose files cannot be downloaded are skipped, and their
+// failures reported (see package failures).
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/crawl"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/download"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	formats     = flag.String("formats", "csv,jsonl,parquet,xlsx", "comma-separated formats of the distributions downloaded, of csv, jsonl, json, parquet, and xlsx")
+	cacheDir    = flag.String("cache", "", "directory of the downloaded files, download-cache next to the datasets directory if empty")
+	maxSize     = flag.Int64("maxsize", 1<<30, "maximum size of the files downloaded, in bytes, or 0 for no limit")
+	replace     = flag.Bool("replace", false, "replace the tables of the datasets not downloaded by download_distributions, such as the samples of the harvesters")
+	maxDatasets = flag.Int("max", 0, "maximum number of datasets downloaded, or 0 for all")
+)
+
+// Conventional names of the files of the tables of the formats
+var rowsFiles = map[string]string{
+	"csv":     "rows.csv",
+	"jsonl":   "rows.jsonl",
+	"json":    "rows.json",
+	"parquet": "rows.parquet",
+	"xlsx":    "rows.xlsx",
+}
+
+// Formats of the distributions by the names and the media types the catalogs
+// give them, in lower case, and by the extensions of their files
+var formatNames = map[string]string{
+	"csv":                            "csv",
+	"text/csv":                       "csv",
+	"jsonl":                          "jsonl",
+	"ndjson":                         "jsonl",
+	"json lines":                     "jsonl",
+	"application/x-ndjson":           "jsonl",
+	"application/jsonl":              "jsonl",
+	"json":                           "json",
+	"application/json":               "json",
+	"parquet":                        "parquet",
+	"application/vnd.apache.parquet": "parquet",
+	"application/x-parquet":          "parquet",
+	"xlsx":                           "xlsx",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": "xlsx",
+}
+
+// distribution is the distribution of a dataset downloaded.
+type distribution struct {
+	datasetID, url string
+	// Name of the file of the table in the directory of the dataset.
+	file string
+}
+
+// tableFile returns the name of the file of the table of the distribution of
+// the format and URL in the directory of its dataset, or "" if it is not a
+// table of the -formats. The compression of the file is that of the extension
+// of its URL.
+func tableFile(format, rawURL string, enabled map[string]bool) string {
+	var name string
+	if u, err := url.Parse(rawURL); err == nil {
+		name = strings.ToLower(path.Base(u.Path))
+	}
+	var suffix string
+	for _, s := range []string{".gz", ".zip"} {
+		if strings.HasSuffix(name, s) {
+			name, suffix = strings.TrimSuffix(name, s), s
+		}
+	}
+	f := formatNames[strings.ToLower(strings.TrimSpace(format))]
+	if f == "" {
+		f = formatNames[strings.TrimPrefix(path.Ext(name), ".")]
+	}
+	if f == "" || !enabled[f] {
+		return ""
+	}
+	return rowsFiles[f] + suffix
+}
+
+// distributions returns the distributions of the datasets to download, those
+// of the first table of each dataset, in the order of the IDs of the datasets.
+func distributions(db *sql.DB, enabled map[string]bool) ([]*distribution, error) {
+	rows, err := db.Query(`SELECT dataset_id, url, format FROM source_distributions ORDER BY dataset_id, position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ds []*distribution
+	for rows.Next() {
+		d := &distribution{}
+		var format string
+		if err := rows.Scan(&d.datasetID, &d.url, &format); err != nil {
+			return nil, err
+		}
+		if len(ds) > 0 && ds[len(ds)-1].datasetID == d.datasetID {
+			continue
+		}
+		if d.file = tableFile(format, d.url, enabled); d.file != "" {
+			ds = append(ds, d)
+		}
+	}
+	return ds, rows.Err()
+}
+
+// downloads returns the files downloaded before, and their names in the
+// directories of the datasets, by dataset ID.
+func downloads(db *sql.DB) (map[string]*download.File, map[string]string, error) {
+	rows, err := db.Query(`SELECT dataset_id, url, file, sha256, size, etag, last_modified FROM distribution_downloads`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	files := make(map[string]*download.File)
+	names := make(map[string]string)
+	for rows.Next() {
+		var id, name string
+		f := &download.File{}
+		if err := rows.Scan(&id, &f.URL, &name, &f.Hash, &f.Size, &f.ETag, &f.LastModified); err != nil {
+			return nil, nil, err
+		}
+		files[id], names[id] = f, name
+	}
+	return files, names, rows.Err()
+}
+
+// hasTable reports whether the directory of a dataset has a table, other than
+// the file of the name.
+func hasTable(dir, name string) (bool, error) {
+	for _, f := range rowsFiles {
+		path, err := datafile.Find(filepath.Join(dir, f))
+		if err == nil && filepath.Base(path) != name {
+			return true, nil
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+	}
+	// The partitions of a table are in its rows directory.
+	if _, err := os.Stat(filepath.Join(dir, "rows")); err == nil {
+		return true, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return false, nil
+}
+
+// link links the file of the cache at src to the path, or copies it if it
+// cannot be linked, through a temporary file, so that the dataset keeps its
+// previous file if it fails.
+func link(src, dest string) error {
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	if err := os.Link(src, tmp); err != nil {
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return err
+		}
+		if err := out.Close(); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, dest)
+}
+
+// record records the download of the file of the dataset, and marks the
+// dataset for processing if its file changed.
+func record(db *sql.DB, d *distribution, f *download.File, changed, checksums bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+	INSERT OR REPLACE INTO distribution_downloads (dataset_id, url, file, sha256, size, etag, last_modified)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`, d.datasetID, d.url, d.file, f.Hash, f.Size, f.ETag, f.LastModified)
+	if err != nil {
+		return err
+	}
+	if changed && checksums {
+		if _, err := tx.Exec(`DELETE FROM dataset_checksums WHERE dataset_id = ?`, d.datasetID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("download_distributions", "datasets", false)
+	enabled := make(map[string]bool)
+	for _, f := range strings.Split(*formats, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if rowsFiles[f] == "" {
+			log.Fatalf("unknown format %q of -formats", f)
+		}
+		enabled[f] = true
+	}
+	if *maxSize < 0 {
+		log.Fatal("-maxsize must not be negative")
+	}
+	datasetsDir := config.DatasetsDir()
+	if *cacheDir == "" {
+		*cacheDir = filepath.Join(filepath.Dir(filepath.Clean(datasetsDir)), "download-cache")
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"source_distributions", "distribution_downloads"} {
+		if ok, err := database.HasTable(db, table); err != nil {
+			log.Fatal(err)
+		} else if !ok {
+			file := "create_distribution_downloads_table.sql"
+			if table == "source_distributions" {
+				file = "create_harvest_tables.sql"
+			}
+			log.Fatalf("no %v table (see sql/%v)", table, file)
+		}
+	}
+	checksums, err := database.HasTable(db, "dataset_checksums")
+	if err != nil {
+		log.Fatal(err)
+	}
+	ds, err := distributions(db, enabled)
+	if err != nil {
+		log.Fatal(err)
+	}
+	prev, names, err := downloads(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fails, err := failures.Start("download_distributions")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cache := &download.Cache{Dir: *cacheDir, Client: crawl.NewClient(), MaxSize: *maxSize}
+	var listed, downloaded, changed, unchanged, skipped int
+	for _, d := range ds {
+		if *maxDatasets > 0 && listed == *maxDatasets {
+			break
+		}
+		if !harvest.ValidID(d.datasetID) {
+			fails.Fail(d.datasetID, fmt.Errorf("invalid dataset ID %q", d.datasetID))
+			continue
+		}
+		dir := filepath.Join(datasetsDir, d.datasetID)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			// The dataset was removed from the datasets directory.
+			continue
+		}
+		listed++
+		if !*replace {
+			if ok, err := hasTable(dir, names[d.datasetID]); err != nil {
+				fails.Fail(d.datasetID, err)
+				continue
+			} else if ok {
+				skipped++
+				continue
+			}
+		}
+		log.Println("downloading", d.datasetID, "from", d.url)
+		f, err := cache.Fetch(d.url, prev[d.datasetID])
+		if err != nil {
+			fails.Fail(d.datasetID, err)
+			continue
+		}
+		downloaded++
+		dest := filepath.Join(dir, d.file)
+		p := prev[d.datasetID]
+		if _, err := os.Stat(dest); err == nil && p != nil && p.Hash == f.Hash && names[d.datasetID] == d.file {
+			unchanged++
+		} else {
+			if err := link(cache.Path(f.Hash), dest); err != nil {
+				fails.Fail(d.datasetID, err)
+				continue
+			}
+			// The table of another format downloaded before is
+			// replaced.
+			if old := names[d.datasetID]; old != "" && old != d.file {
+				os.Remove(filepath.Join(dir, old))
+			}
+			changed++
+		}
+		if err := record(db, d, f, p == nil || p.Hash != f.Hash || names[d.datasetID] != d.file, checksums); err != nil {
+			log.Fatal(err)
+		}
+		// The hash is kept by the cache even if the dataset is listed
+		// again with another file.
+		prev[d.datasetID], names[d.datasetID] = f, d.file
+	}
+
+	keep := make(map[string]bool)
+	rows, err := db.Query(`SELECT sha256 FROM distribution_downloads`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			log.Fatal(err)
+		}
+		keep[hash] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if err := cache.Clean(keep); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("downloaded %d of %d datasets; %d changed, %d unchanged, %d with other tables", downloaded, listed, changed, unchanged, skipped)
+	if err := fails.Finish(db, false); err != nil {
+		log.Fatal(err)
+	}
+	summary.Processed, summary.Total = int64(downloaded), int64(listed)
+	summary.Skipped = unchanged + skipped
+	summary.Count("changed", changed)
+	if err := summary.Finish(db, nil, fails); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,141 @@
+// Command ensemble_search is a command-line interface for testing search over
+// both the metadata and attribute embedding indexes.
+// It prints the names of the 20 best datasets for the query, fusing the
+// rankings of both indexes as given by the -fusion flag (rrf or weighted),
+// with each dataset's ranks in the metadata and attribute results.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	cfg        = index.DefaultFusionConfig()
+	fusionName = flag.String("fusion", "rrf", "how to fuse the index rankings: rrf or weighted")
+	aggName    = flag.String("agg", "max", "how to combine attribute similarities: max, mean, or count")
+	mweight    = flag.Float64("mweight", float64(cfg.MetadataWeight), "metadata similarity weight with -fusion weighted")
+	aweight    = flag.Float64("aweight", float64(cfg.AttributeWeight), "attribute score weight with -fusion weighted")
+)
+
+func init() {
+	flag.IntVar(&cfg.RRFK, "rrfk", cfg.RRFK, "rank offset with -fusion rrf")
+	flag.Int64Var(&cfg.Oversample, "oversample", cfg.Oversample, "candidates fetched from each index per result")
+}
+
+func main() {
+	config.ParseFlags()
+	cfg.MetadataWeight, cfg.AttributeWeight = float32(*mweight), float32(*aweight)
+
+	var err error
+	if cfg.Method, err = index.ParseFusion(*fusionName); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Aggregation, err = index.ParseAggregation(*aggName); err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	embeddingConfig, err := config.Embedding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	categoryModels, err := config.CategoryModels()
+	if err != nil {
+		log.Fatal(err)
+	}
+	embedder, versions, err := embedding.OpenWithVersions(embeddingConfig, categoryModels)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer embedder.Close()
+	for _, vectors := range []string{"metadata_vectors", "attribute_vectors"} {
+		if err := index.CheckVersions(db, vectors, versions); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	params, err := config.IndexParameters()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := embedding.CheckDim(embedder, params.EmbeddingDim); err != nil {
+		log.Fatal(err)
+	}
+	metadataIndex, err := index.BuildMetadataIndex(db, config.IndexBackend(), params.EmbeddingDim)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer metadataIndex.Delete()
+
+	var attributeIndex *index.AttributeIndex
+	if path := config.AttributeIndexPath(); path != "" {
+		attributeIndex, err = index.BuildOnDiskAttributeIndex(db, params.EmbeddingDim, path)
+	} else {
+		attributeIndex, err = index.BuildAttributeEmbeddingIndex(db, params.EmbeddingDim)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer attributeIndex.Delete()
+
+	if cfg.AttributeTokenizer, err = config.AttributeTokenizer(); err != nil {
+		log.Fatal(err)
+	}
+	searcher := index.NewMultiIndexSearcher(metadataIndex, attributeIndex, embedder, cfg)
+	in := bufio.NewScanner(os.Stdin)
+
+	for {
+		fmt.Print("query: ")
+		if !in.Scan() {
+			return
+		}
+		query := strings.TrimSpace(in.Text())
+		if query == "" {
+			continue
+		}
+		fmt.Println()
+
+		matches, err := searcher.Search(query, 20)
+		if err != nil {
+			if err == wordemb.ErrNoEmb {
+				fmt.Println("no embedding for query:", query)
+				continue
+			}
+			log.Fatal(err)
+		}
+
+		for _, m := range matches {
+			name, err := db.DatasetName(m.DatasetID)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("%.4f %v (metadata rank %s, attribute rank %s)\n",
+				m.Score, name, rank(m.MetadataRank), rank(m.AttributeRank))
+		}
+		fmt.Println()
+	}
+}
+
+func rank(r int) string {
+	if r == 0 {
+		return "-"
+	}
+	return fmt.Sprint(r)
+}
@@ -0,0 +1,242 @@
+// Command export_join_graph writes the joinability graph of the Open Data Link
+// database to standard output, in GraphML (-format graphml) for Gephi or DOT
+// (-format dot) for Graphviz.
+//
+// The nodes of the graph are the datasets with sketched columns, and there is
+// an edge from a dataset to another if a column of the first has at least
+// -mincontainment of its distinct values, and at least -mindistinct of them,
+// contained in a column of the second, as estimated from their sketches by the
+// joinability index. The weight of the edge is the largest containment of the
+// columns of the datasets.
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	format         = flag.String("format", "graphml", "output format: graphml or dot")
+	minContainment = flag.Float64("mincontainment", 0.5, "minimum fraction of the values of a column contained in the column it joins")
+	minDistinct    = flag.Int("mindistinct", 10, "minimum number of distinct values of the joined columns")
+)
+
+type node struct {
+	id, name string
+}
+
+// edge is the edge from the dataset of column to the dataset of key.
+type edge struct {
+	from, to string
+	// The columns with the largest containment, and the number of pairs of
+	// joinable columns of the datasets.
+	column, key string
+	weight      float64
+	columns     int
+}
+
+// columns returns the sketches of all sketched columns.
+func columns(db *database.DB) ([]*database.ColumnSketch, error) {
+	rows, err := db.Query(`SELECT column_id FROM column_sketches ORDER BY column_id`)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sketches := make([]*database.ColumnSketch, len(ids))
+	for i, id := range ids {
+		if sketches[i], err = db.ColumnSketch(id); err != nil {
+			return nil, err
+		}
+	}
+	return sketches, nil
+}
+
+// nodes returns the datasets of the columns, sorted by ID. Datasets without
+// metadata are named by their IDs.
+func nodes(db *database.DB, sketches []*database.ColumnSketch) []*node {
+	var nodes []*node
+	seen := make(map[string]bool)
+	for _, c := range sketches {
+		if seen[c.DatasetID] {
+			continue
+		}
+		seen[c.DatasetID] = true
+		name, err := db.DatasetName(c.DatasetID)
+		if err != nil || name == "" {
+			name = c.DatasetID
+		}
+		nodes = append(nodes, &node{c.DatasetID, name})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	return nodes
+}
+
+// edges returns the edges of the graph, sorted by their datasets.
+func edges(idx *index.JoinabilityIndex, sketches []*database.ColumnSketch) ([]*edge, error) {
+	byPair := make(map[[2]string]*edge)
+	for i, query := range sketches {
+		if query.DistinctCount < *minDistinct {
+			continue
+		}
+		candidates, err := idx.Joinable(query, *minContainment)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range candidates {
+			if c.DatasetID == query.DatasetID || c.DistinctCount < *minDistinct {
+				continue
+			}
+			pair := [2]string{query.DatasetID, c.DatasetID}
+			e := byPair[pair]
+			if e == nil {
+				e = &edge{from: query.DatasetID, to: c.DatasetID}
+				byPair[pair] = e
+			}
+			e.columns++
+			if c.Containment > e.weight {
+				e.column, e.key, e.weight = query.ColumnName, c.ColumnName, c.Containment
+			}
+		}
+		if (i+1)%1000 == 0 {
+			log.Printf("checked %d of %d columns", i+1, len(sketches))
+		}
+	}
+
+	edges := make([]*edge, 0, len(byPair))
+	for _, e := range byPair {
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].from != edges[j].from {
+			return edges[i].from < edges[j].from
+		}
+		return edges[i].to < edges[j].to
+	})
+	return edges, nil
+}
+
+// xmlEscape returns s escaped for XML character data and attribute values.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func writeGraphML(w io.Writer, nodes []*node, edges []*edge) {
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="name" for="node" attr.name="name" attr.type="string"/>
+  <key id="weight" for="edge" attr.name="weight" attr.type="double"/>
+  <key id="columns" for="edge" attr.name="columns" attr.type="int"/>
+  <key id="column" for="edge" attr.name="column" attr.type="string"/>
+  <key id="key_column" for="edge" attr.name="key_column" attr.type="string"/>
+  <graph id="joinability" edgedefault="directed">
+`)
+	for _, n := range nodes {
+		fmt.Fprintf(w, "    <node id=\"%s\"><data key=\"name\">%s</data></node>\n",
+			xmlEscape(n.id), xmlEscape(n.name))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(w, "    <edge source=\"%s\" target=\"%s\">", xmlEscape(e.from), xmlEscape(e.to))
+		fmt.Fprintf(w, "<data key=\"weight\">%.4f</data><data key=\"columns\">%d</data>", e.weight, e.columns)
+		fmt.Fprintf(w, "<data key=\"column\">%s</data><data key=\"key_column\">%s</data></edge>\n",
+			xmlEscape(e.column), xmlEscape(e.key))
+	}
+	fmt.Fprint(w, "  </graph>\n</graphml>\n")
+}
+
+var dotReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", "")
+
+// dotQuote returns s as a quoted DOT identifier.
+func dotQuote(s string) string {
+	return `"` + dotReplacer.Replace(s) + `"`
+}
+
+func writeDOT(w io.Writer, nodes []*node, edges []*edge) {
+	fmt.Fprint(w, "digraph joinability {\n")
+	for _, n := range nodes {
+		fmt.Fprintf(w, "  %s [label=%s];\n", dotQuote(n.id), dotQuote(n.name))
+	}
+	for _, e := range edges {
+		// Graphviz requires integer weights, so the containment is also given
+		// as a percentage.
+		fmt.Fprintf(w, "  %s -> %s [weight=%d, containment=%.4f, columns=%d, label=%s];\n",
+			dotQuote(e.from), dotQuote(e.to), int(math.Round(100*e.weight)), e.weight, e.columns,
+			dotQuote(e.column+" -> "+e.key))
+	}
+	fmt.Fprint(w, "}\n")
+}
+
+func main() {
+	config.ParseFlags()
+	var write func(io.Writer, []*node, []*edge)
+	switch *format {
+	case "graphml":
+		write = writeGraphML
+	case "dot":
+		write = writeDOT
+	default:
+		log.Fatalf("unknown format %q (want graphml or dot)", *format)
+	}
+	if *minContainment <= 0 || *minContainment > 1 {
+		log.Fatal("-mincontainment must be in (0, 1]")
+	}
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	params, err := config.IndexParameters()
+	if err != nil {
+		log.Fatal(err)
+	}
+	joinabilityIndex, err := index.BuildJoinabilityIndex(db, params.LSHPartitions, params.LSHMaxK)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("built joinability index")
+
+	sketches, err := columns(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ns := nodes(db, sketches)
+	es, err := edges(joinabilityIndex, sketches)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	write(w, ns, es)
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("exported %d datasets and %d edges", len(ns), len(es))
+}
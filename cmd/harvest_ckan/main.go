@@ -0,0 +1,603 @@
+// Command harvest_ckan harvests the datasets of CKAN portals, such as
+// catalog.data.gov or open.canada.ca/data, through the CKAN Action API, for
+// the ingestion pipeline.
+//
+// The packages of each of the -portals matching the -q and -fq queries, and
+// of the -organizations, if any, are paged through with package_search, or the
+// packages named by -packages are fetched with package_show, and mapped into
+// metadata: the title, notes,
+// organization, maintainer or author email, modification time, groups, and
+// tags of each package become the name, description, attribution, contact
+// email, update time, categories, and tags of its dataset, identified by the
+// package ID. A sample of the first -samplerows rows of its table, or the
+// whole table if -samplerows is 0, is read from the first of its resources in
+// the DataStore, with the schema of its DataStore fields, or else downloaded
+// from its first CSV resource. The metadata and the sample are written to
+// datasets/<id>/metadata.json and datasets/<id>/rows.csv (see
+// config.DatasetsDir), and the metadata is also stored in the metadata table,
+// the schema and the resources of the package in the source_columns and
+// source_distributions tables, and the datasets whose files changed are
+// marked for processing (see package harvest). The harvested datasets are then
+// ingested by the pipeline:
+//
+//	harvest_ckan -portals https://catalog.data.gov -fq organization:nasa-gov
+//	process_datasets -changed-only
+//
+// The datasets whose tables cannot be read are skipped, and their failures
+// reported (see package failures). Packages without a DataStore or CSV
+// resource are harvested without a table.
+//
+// The packages are paged through by modification time. With -checkpoint, the
+// position of the harvest of each portal is saved to a file after each page,
+// so that a harvest of a large catalog such as data.gov that is interrupted
+// resumes from its last page, and a harvest run again with the same queries
+// harvests the packages modified since. With -incremental, the packages whose
+// modification time is the same as when they were last harvested are skipped
+// without reading their tables again, as when the queries of a checkpoint
+// change.
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/crawl"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	portals        = flag.String("portals", "", "comma-separated URLs of the CKAN portals to harvest, such as https://catalog.data.gov")
+	packages       = flag.String("packages", "", "comma-separated names or IDs of the packages to harvest, instead of searching the portals")
+	query          = flag.String("q", "", "package_search query of the packages to harvest")
+	filter         = flag.String("fq", "", "package_search filter query of the packages to harvest, such as organization:nasa-gov")
+	organizations  = flag.String("organizations", "", "comma-separated names of the organizations whose packages are harvested, such as nasa-gov")
+	apiKeyFile     = flag.String("apikey", "", "file of a CKAN API key, for private packages")
+	sampleRows     = flag.Int("samplerows", 1000, "number of rows of the sample of each table, or 0 to read the whole tables")
+	maxPackages    = flag.Int("max", 0, "maximum number of packages harvested from each portal, or 0 for all")
+	checkpointFile = flag.String("checkpoint", "", "file of the position of the harvest, from which an interrupted harvest resumes")
+	incremental    = flag.Bool("incremental", false, "skip the packages whose modification time did not change since they were harvested")
+)
+
+const (
+	// Number of packages per page of package_search
+	pageSize = 100
+	// Number of rows per page of datastore_search, when reading whole
+	// tables
+	datastorePageSize = 10000
+)
+
+// API key sent with the requests, if any, and client of the requests, set by
+// main.
+var (
+	apiKey string
+	client *crawl.Client
+)
+
+// Number of packages skipped by -incremental
+var unchanged int
+
+// ckanPackage is a CKAN package, as returned by package_search and
+// package_show.
+type ckanPackage struct {
+	ID               string
+	Name             string
+	Title            string
+	Notes            string
+	Author           string
+	AuthorEmail      string `json:"author_email"`
+	Maintainer       string
+	MaintainerEmail  string `json:"maintainer_email"`
+	MetadataModified string `json:"metadata_modified"`
+	Organization     *struct {
+		Title string
+	}
+	Groups    []*ckanName
+	Tags      []*ckanName
+	Resources []*ckanResource
+}
+
+// ckanName is a group or tag of a package.
+type ckanName struct {
+	Name        string
+	Title       string
+	DisplayName string `json:"display_name"`
+}
+
+func (n *ckanName) String() string {
+	for _, s := range []string{n.DisplayName, n.Title} {
+		if s != "" {
+			return s
+		}
+	}
+	return n.Name
+}
+
+// ckanResource is a resource of a package.
+type ckanResource struct {
+	ID              string
+	Name            string
+	Description     string
+	URL             string
+	Format          string
+	DatastoreActive bool `json:"datastore_active"`
+}
+
+// dataset returns the harvested dataset of p, from the portal.
+func (p *ckanPackage) dataset(portal string) *harvest.Dataset {
+	m := &database.Metadata{
+		DatasetID:    p.ID,
+		Name:         p.Title,
+		Description:  p.Notes,
+		Attribution:  p.Author,
+		ContactEmail: p.MaintainerEmail,
+		UpdatedAt:    p.MetadataModified,
+		Permalink:    portal + "/dataset/" + p.Name,
+	}
+	if m.Name == "" {
+		m.Name = p.Name
+	}
+	if p.Organization != nil && p.Organization.Title != "" {
+		m.Attribution = p.Organization.Title
+	} else if m.Attribution == "" {
+		m.Attribution = p.Maintainer
+	}
+	if m.ContactEmail == "" {
+		m.ContactEmail = p.AuthorEmail
+	}
+	var categories, tags []string
+	for _, g := range p.Groups {
+		categories = append(categories, g.String())
+	}
+	for _, t := range p.Tags {
+		tags = append(tags, t.String())
+	}
+	m.Categories, m.Tags = harvest.Unique(categories), harvest.Unique(tags)
+
+	d := &harvest.Dataset{Metadata: m, Source: "ckan:" + portal}
+	for _, r := range p.Resources {
+		d.Distributions = append(d.Distributions, &harvest.Distribution{
+			Name:        r.Name,
+			URL:         r.URL,
+			Format:      r.Format,
+			Description: r.Description,
+		})
+	}
+	return d
+}
+
+// table returns the resource of p whose table is sampled, the first in the
+// DataStore, or else the first CSV resource, or nil if it has neither.
+func (p *ckanPackage) table() *ckanResource {
+	var csvResource *ckanResource
+	for _, r := range p.Resources {
+		if r.DatastoreActive {
+			return r
+		}
+		if csvResource == nil && strings.EqualFold(strings.TrimSpace(r.Format), "csv") {
+			csvResource = r
+		}
+	}
+	return csvResource
+}
+
+// get gets the resource at u, with the API key if key is set (see package
+// crawl).
+func get(u string, key bool) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if key && apiKey != "" {
+		req.Header.Set("Authorization", apiKey)
+	}
+	return client.Do(req)
+}
+
+// action calls the action of the Action API of the portal with the
+// parameters, decoding its result into v.
+func action(portal, name string, params url.Values, v interface{}) error {
+	res, err := get(portal+"/api/3/action/"+name+"?"+params.Encode(), true)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	dec := json.NewDecoder(res.Body)
+	dec.UseNumber()
+	var body struct {
+		Success bool
+		Result  json.RawMessage
+		Error   *struct {
+			Message string
+		}
+	}
+	if err := dec.Decode(&body); err != nil {
+		return fmt.Errorf("%v of %v: %w", name, portal, err)
+	}
+	if !body.Success {
+		msg := "unsuccessful"
+		if body.Error != nil {
+			msg = body.Error.Message
+		}
+		return fmt.Errorf("%v of %v: %v", name, portal, msg)
+	}
+	dec = json.NewDecoder(bytes.NewReader(body.Result))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// datastoreField is a field of a DataStore table.
+type datastoreField struct {
+	ID   string
+	Type string
+	Info *struct {
+		Label string
+		Notes string
+	}
+}
+
+// datastoreSample returns the columns of the DataStore table of the resource
+// and a sample of its rows in CSV, or all its rows, read by pages, if
+// -samplerows is 0.
+func datastoreSample(portal, resourceID string) ([]*harvest.Column, []byte, error) {
+	limit := *sampleRows
+	if limit == 0 {
+		limit = datastorePageSize
+	}
+	var cols []*harvest.Column
+	var header []string
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for offset := 0; ; offset += limit {
+		params := url.Values{
+			"resource_id": {resourceID},
+			"limit":       {strconv.Itoa(limit)},
+			"offset":      {strconv.Itoa(offset)},
+		}
+		var result struct {
+			Fields  []*datastoreField
+			Records []map[string]interface{}
+		}
+		if err := action(portal, "datastore_search", params, &result); err != nil {
+			return nil, nil, err
+		}
+		if offset == 0 {
+			for _, f := range result.Fields {
+				// _id is the row number added by the DataStore.
+				if f.ID == "_id" {
+					continue
+				}
+				col := &harvest.Column{Name: f.ID, Type: f.Type}
+				if f.Info != nil {
+					if f.Info.Label != "" && f.Info.Label != f.ID {
+						col.Name, col.FieldName = f.Info.Label, f.ID
+					}
+					col.Description = f.Info.Notes
+				}
+				cols = append(cols, col)
+				header = append(header, f.ID)
+			}
+			if err := w.Write(header); err != nil {
+				return nil, nil, err
+			}
+		}
+		record := make([]string, len(header))
+		for _, r := range result.Records {
+			for i, id := range header {
+				record[i] = formatValue(r[id])
+			}
+			if err := w.Write(record); err != nil {
+				return nil, nil, err
+			}
+		}
+		if *sampleRows > 0 || len(result.Records) < limit {
+			break
+		}
+	}
+	w.Flush()
+	return cols, buf.Bytes(), w.Error()
+}
+
+// formatValue formats a value of a DataStore record.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// sameHost reports whether the URLs a and b have the same host.
+func sameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	return err == nil && ua.Host == ub.Host
+}
+
+// harvestPackage harvests the package p of the portal, reporting whether the
+// files of its dataset changed.
+func harvestPackage(w *harvest.Writer, portal string, p *ckanPackage) (bool, error) {
+	if p.ID == "" {
+		return false, errors.New("package has no ID")
+	}
+	d := p.dataset(portal)
+	var rows io.Reader
+	if r := p.table(); r != nil && r.DatastoreActive {
+		cols, sample, err := datastoreSample(portal, r.ID)
+		if err != nil {
+			return false, err
+		}
+		d.Columns, rows = cols, bytes.NewReader(sample)
+	} else if r != nil {
+		// The API key is only sent to the portal, not to the other
+		// hosts of the resources.
+		res, err := get(r.URL, sameHost(r.URL, portal))
+		if err != nil {
+			return false, err
+		}
+		defer res.Body.Close()
+		sample, err := harvest.SampleCSV(res.Body, *sampleRows)
+		if err != nil {
+			return false, fmt.Errorf("%v: %w", r.URL, err)
+		}
+		rows = bytes.NewReader(sample)
+	}
+	return w.Write(d, rows)
+}
+
+// harvestPortal harvests the packages of the portal, returning the numbers of
+// packages listed, harvested, and changed.
+func harvestPortal(w *harvest.Writer, portal string, fails *failures.Recorder) (listed, harvested, changed int, err error) {
+	harvestOne := func(p *ckanPackage) {
+		listed++
+		if w.Unchanged(p.ID, p.MetadataModified) {
+			unchanged++
+			return
+		}
+		log.Println("harvesting", p.Name)
+		ok, err := harvestPackage(w, portal, p)
+		if err != nil {
+			fails.Fail(p.ID, err)
+			return
+		}
+		harvested++
+		if ok {
+			changed++
+		}
+	}
+	if *packages != "" {
+		for _, name := range strings.Split(*packages, ",") {
+			name = strings.TrimSpace(name)
+			var p ckanPackage
+			if err := action(portal, "package_show", url.Values{"id": {name}}, &p); err != nil {
+				listed++
+				fails.Fail(name, err)
+				continue
+			}
+			harvestOne(&p)
+		}
+		return listed, harvested, changed, nil
+	}
+	fq := filterQuery()
+	cp := checkpoints[portal]
+	if cp == nil {
+		cp = &checkpoint{Filter: fq}
+		checkpoints[portal] = cp
+	} else if cp.Filter != fq || cp.Query != *query {
+		return 0, 0, 0, fmt.Errorf("checkpoint of %v is of other queries (%q, %q); delete it to harvest these", portal, cp.Query, cp.Filter)
+	}
+	cp.Query = *query
+	// The packages are paged through by modification time, from that of the
+	// last package harvested, skipping the packages harvested with that time,
+	// so that the pages are not shifted by the packages modified during the
+	// harvest, which come later.
+	for *maxPackages == 0 || listed < *maxPackages {
+		pageFilter := fq
+		if cp.Modified != "" {
+			pageFilter = strings.TrimSpace(fq + " metadata_modified:[" + cp.Modified + " TO *]")
+		}
+		params := url.Values{
+			"q":     {*query},
+			"fq":    {pageFilter},
+			"rows":  {strconv.Itoa(pageSize)},
+			"start": {strconv.Itoa(len(cp.IDs))},
+			"sort":  {"metadata_modified asc, id asc"},
+		}
+		var result struct {
+			Results []*ckanPackage
+		}
+		if err := action(portal, "package_search", params, &result); err != nil {
+			return listed, harvested, changed, err
+		}
+		if len(result.Results) == 0 {
+			break
+		}
+		for _, p := range result.Results {
+			if *maxPackages > 0 && listed == *maxPackages {
+				break
+			}
+			harvestOne(p)
+			if modified := solrTime(p.MetadataModified); modified != cp.Modified {
+				cp.Modified, cp.IDs = modified, nil
+			}
+			cp.IDs = append(cp.IDs, p.ID)
+		}
+		// The packages of the page are committed before the checkpoint
+		// moves past them.
+		if err := w.Close(); err != nil {
+			return listed, harvested, changed, err
+		}
+		if err := saveCheckpoints(); err != nil {
+			return listed, harvested, changed, err
+		}
+	}
+	return listed, harvested, changed, nil
+}
+
+// filterQuery returns the filter query of the packages to harvest, of -fq and
+// -organizations.
+func filterQuery() string {
+	fq := strings.TrimSpace(*filter)
+	var orgs []string
+	for _, org := range strings.Split(*organizations, ",") {
+		if org = strings.TrimSpace(org); org != "" {
+			orgs = append(orgs, strconv.Quote(org))
+		}
+	}
+	if len(orgs) > 0 {
+		fq = strings.TrimSpace(fq + " organization:(" + strings.Join(orgs, " OR ") + ")")
+	}
+	return fq
+}
+
+// solrTime returns the CKAN time t, such as 2021-06-01T12:30:00.123456, in the
+// date format of Solr, which has millisecond precision.
+func solrTime(t string) string {
+	if i := strings.IndexByte(t, '.'); i >= 0 && len(t) > i+4 {
+		t = t[:i+4]
+	}
+	return strings.TrimSuffix(t, "Z") + "Z"
+}
+
+// checkpoint is the position of the harvest of the packages of a portal
+// matching a search.
+type checkpoint struct {
+	Query  string `json:"query"`
+	Filter string `json:"filter"`
+	// Modification time of the last package harvested, in the format of
+	// Solr, and the IDs of the packages harvested with that time.
+	Modified string   `json:"modified"`
+	IDs      []string `json:"ids"`
+}
+
+// Checkpoints of the portals, by portal URL, set by main.
+var checkpoints = make(map[string]*checkpoint)
+
+// loadCheckpoints loads the checkpoints of -checkpoint, if it exists.
+func loadCheckpoints() error {
+	data, err := ioutil.ReadFile(*checkpointFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return fmt.Errorf("%v: %w", *checkpointFile, err)
+	}
+	return nil
+}
+
+// saveCheckpoints saves the checkpoints to -checkpoint, if set, through a
+// temporary file, so that an interrupted save keeps the previous ones.
+func saveCheckpoints() error {
+	if *checkpointFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := *checkpointFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, *checkpointFile)
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("harvest_ckan", "datasets", false)
+	if *portals == "" {
+		log.Fatal("no -portals to harvest")
+	}
+	if *sampleRows < 0 {
+		log.Fatal("-samplerows must not be negative")
+	}
+	if *apiKeyFile != "" {
+		key, err := ioutil.ReadFile(*apiKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		apiKey = strings.TrimSpace(strings.SplitN(string(key), "\n", 2)[0])
+	}
+	if *checkpointFile != "" {
+		if err := loadCheckpoints(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	client = crawl.NewClient()
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	w, err := harvest.Start(db, config.DatasetsDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *incremental {
+		if err := w.Incremental(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fails, err := failures.Start("harvest_ckan")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The datasets harvested before a portal fails are kept.
+	var listed, harvested, changed int
+	var harvestErr error
+	for _, portal := range strings.Split(*portals, ",") {
+		var l, h, c int
+		l, h, c, harvestErr = harvestPortal(w, strings.TrimSuffix(strings.TrimSpace(portal), "/"), fails)
+		listed, harvested, changed = listed+l, harvested+h, changed+c
+		if harvestErr != nil {
+			break
+		}
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if harvestErr != nil {
+		log.Fatal(harvestErr)
+	}
+	log.Printf("harvested %d of %d packages; %d changed, %d unchanged", harvested, listed, changed, unchanged)
+	if err := fails.Finish(db, false); err != nil {
+		log.Fatal(err)
+	}
+	summary.Processed, summary.Total = int64(harvested), int64(listed)
+	summary.Skipped = unchanged
+	summary.Count("changed", changed)
+	if err := summary.Finish(db, nil, fails); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,391 @@
+// Command harvest_kaggle harvests the metadata of Kaggle datasets through the
+// Kaggle API, for the ingestion pipeline, so that they are found alongside the
+// datasets of open data portals.
+//
+// The datasets matching -search, -user, -tags, and -filetype are paged
+// through, or the datasets of the references of -refs, such as owner/dataset,
+// are fetched, and mapped into metadata: the title, description, owner, update
+// time, and tags and keywords of each dataset become the name, description,
+// attribution, update time, and tags of its dataset, identified by its Kaggle
+// ID, such as kaggle-12345. The metadata is written to
+// datasets/<id>/metadata.json (see config.DatasetsDir) and stored in the
+// metadata table, the schema of the first file of the dataset with one in the
+// source_columns table, and its files in the source_distributions table (see
+// package harvest). The tables of the datasets are not downloaded:
+//
+//	harvest_kaggle -search "air quality" -max 200
+//	process_metadata
+//
+// The requests are authenticated with the username and API key of the
+// KAGGLE_USERNAME and KAGGLE_KEY environment variables, or else of the
+// kaggle.json file of the directory of KAGGLE_CONFIG_DIR, or of ~/.kaggle, as
+// the Kaggle command line tool is.
+//
+// The datasets that cannot be read are skipped, and their failures reported
+// (see package failures). With -incremental, the datasets last updated when
+// they were last harvested are skipped without reading their metadata and
+// files again.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/crawl"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	refs        = flag.String("refs", "", "comma-separated references of the datasets to harvest, such as owner/dataset, instead of searching them")
+	search      = flag.String("search", "", "search terms of the datasets to harvest")
+	user        = flag.String("user", "", "user or organization whose datasets are harvested")
+	tags        = flag.String("tags", "", "comma-separated IDs of the tags of the datasets to harvest, such as finance")
+	fileType    = flag.String("filetype", "", "type of the files of the datasets to harvest, such as csv, json, or sqlite, or empty for all")
+	sortBy      = flag.String("sort", "updated", "order of the datasets searched: hottest, votes, updated, active, or published")
+	apiURL      = flag.String("api", "https://www.kaggle.com/api/v1", "URL of the Kaggle API")
+	maxDatasets = flag.Int("max", 0, "maximum number of datasets harvested, or 0 for all")
+	incremental = flag.Bool("incremental", false, "skip the datasets whose update time did not change since they were harvested")
+)
+
+// Username and API key the requests are authenticated with, and client of
+// the requests, set by main.
+var (
+	username, apiKey string
+	client           *crawl.Client
+)
+
+// Number of datasets skipped by -incremental
+var unchanged int
+
+// kaggleDataset is a dataset, as returned by datasets/list and datasets/view.
+type kaggleDataset struct {
+	ID          int64
+	Ref         string
+	Title       string
+	Subtitle    string
+	Description string
+	OwnerName   string
+	OwnerRef    string
+	CreatorName string
+	LastUpdated string
+	URL         string
+	LicenseName string
+	Tags        []*struct {
+		Ref  string
+		Name string
+	}
+}
+
+// kaggleMetadata is the metadata of a dataset, as returned by
+// datasets/metadata, which holds its whole description.
+type kaggleMetadata struct {
+	Info *struct {
+		Title       string
+		Subtitle    string
+		Description string
+		Keywords    []string
+	}
+	ErrorMessage string
+}
+
+// kaggleFile is a file of a dataset, as returned by datasets/list/<ref>.
+type kaggleFile struct {
+	Name        string
+	Description string
+	FileType    string
+	URL         string
+	Columns     []*struct {
+		Order       int
+		Name        string
+		Type        string
+		Description string
+	}
+}
+
+// get gets the resource at u, decoding its JSON into v (see package crawl).
+func get(u string, v interface{}) error {
+	res, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("GET %v: %w", u, err)
+	}
+	return nil
+}
+
+// escapeRef escapes the path segments of the reference of a dataset,
+// owner/dataset.
+func escapeRef(ref string) string {
+	parts := strings.Split(ref, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// files returns the files of the dataset of the reference, paging through
+// them.
+func files(ref string) ([]*kaggleFile, error) {
+	var all []*kaggleFile
+	params := url.Values{}
+	for {
+		var page struct {
+			DatasetFiles  []*kaggleFile
+			NextPageToken string
+			ErrorMessage  string
+		}
+		u := *apiURL + "/datasets/list/" + escapeRef(ref)
+		if len(params) > 0 {
+			u += "?" + params.Encode()
+		}
+		if err := get(u, &page); err != nil {
+			return nil, err
+		}
+		if page.ErrorMessage != "" {
+			return nil, fmt.Errorf("files of %v: %v", ref, page.ErrorMessage)
+		}
+		all = append(all, page.DatasetFiles...)
+		if page.NextPageToken == "" {
+			return all, nil
+		}
+		params.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// dataset returns the harvested dataset of k, with its metadata and files.
+func (k *kaggleDataset) dataset(meta *kaggleMetadata, files []*kaggleFile) *harvest.Dataset {
+	m := &database.Metadata{
+		DatasetID:   "kaggle-" + strconv.FormatInt(k.ID, 10),
+		Name:        k.Title,
+		Description: k.Description,
+		Attribution: k.OwnerName,
+		UpdatedAt:   k.LastUpdated,
+		Permalink:   k.URL,
+	}
+	if m.Attribution == "" {
+		m.Attribution = k.OwnerRef
+	}
+	if m.Permalink == "" {
+		m.Permalink = "https://www.kaggle.com/datasets/" + k.Ref
+	}
+	var keywords []string
+	for _, t := range k.Tags {
+		keywords = append(keywords, t.Name)
+	}
+	if info := meta.Info; info != nil {
+		if info.Description != "" {
+			m.Description = info.Description
+		}
+		keywords = append(keywords, info.Keywords...)
+	}
+	if m.Description == "" {
+		m.Description = k.Subtitle
+	}
+	m.Tags = harvest.Unique(keywords)
+
+	d := &harvest.Dataset{Metadata: m, Source: "kaggle:" + k.OwnerRef}
+	for _, f := range files {
+		d.Distributions = append(d.Distributions, &harvest.Distribution{
+			Name:        f.Name,
+			URL:         f.URL,
+			Format:      strings.ToUpper(strings.TrimPrefix(f.FileType, ".")),
+			Description: f.Description,
+		})
+		if d.Columns != nil || len(f.Columns) == 0 {
+			continue
+		}
+		columns := f.Columns
+		sort.SliceStable(columns, func(i, j int) bool { return columns[i].Order < columns[j].Order })
+		for _, c := range columns {
+			d.Columns = append(d.Columns, &harvest.Column{Name: c.Name, Type: c.Type, Description: c.Description})
+		}
+	}
+	return d
+}
+
+// harvestDataset harvests the dataset k, reporting whether its files changed.
+func harvestDataset(w *harvest.Writer, k *kaggleDataset) (bool, error) {
+	if k.ID == 0 || k.Ref == "" {
+		return false, errors.New("dataset has no ID")
+	}
+	var meta kaggleMetadata
+	if err := get(*apiURL+"/datasets/metadata/"+escapeRef(k.Ref), &meta); err != nil {
+		return false, err
+	}
+	if meta.ErrorMessage != "" {
+		return false, fmt.Errorf("metadata of %v: %v", k.Ref, meta.ErrorMessage)
+	}
+	fs, err := files(k.Ref)
+	if err != nil {
+		return false, err
+	}
+	return w.Write(k.dataset(&meta, fs), nil)
+}
+
+// harvestDatasets harvests the datasets, returning the numbers of datasets
+// listed, harvested, and changed.
+func harvestDatasets(w *harvest.Writer, fails *failures.Recorder) (listed, harvested, changed int, err error) {
+	harvestOne := func(k *kaggleDataset) {
+		listed++
+		if w.Unchanged("kaggle-"+strconv.FormatInt(k.ID, 10), k.LastUpdated) {
+			unchanged++
+			return
+		}
+		log.Println("harvesting", k.Ref)
+		ok, err := harvestDataset(w, k)
+		if err != nil {
+			id := k.Ref
+			if k.ID != 0 {
+				id = "kaggle-" + strconv.FormatInt(k.ID, 10)
+			}
+			fails.Fail(id, err)
+			return
+		}
+		harvested++
+		if ok {
+			changed++
+		}
+	}
+	if *refs != "" {
+		for _, ref := range strings.Split(*refs, ",") {
+			ref = strings.Trim(strings.TrimSpace(ref), "/")
+			var k kaggleDataset
+			if err := get(*apiURL+"/datasets/view/"+escapeRef(ref), &k); err != nil {
+				listed++
+				fails.Fail(ref, err)
+				continue
+			}
+			harvestOne(&k)
+		}
+		return listed, harvested, changed, nil
+	}
+	params := url.Values{"sortBy": {*sortBy}}
+	for name, v := range map[string]string{"search": *search, "user": *user, "tagids": *tags, "filetype": *fileType} {
+		if v != "" {
+			params.Set(name, v)
+		}
+	}
+	for page := 1; *maxDatasets == 0 || listed < *maxDatasets; page++ {
+		params.Set("page", strconv.Itoa(page))
+		var results []*kaggleDataset
+		if err := get(*apiURL+"/datasets/list?"+params.Encode(), &results); err != nil {
+			return listed, harvested, changed, err
+		}
+		if len(results) == 0 {
+			break
+		}
+		for _, k := range results {
+			if *maxDatasets > 0 && listed == *maxDatasets {
+				break
+			}
+			harvestOne(k)
+		}
+	}
+	return listed, harvested, changed, nil
+}
+
+// credentials returns the username and API key of the environment, or of
+// the kaggle.json file of the Kaggle command line tool.
+func credentials() (string, string, error) {
+	if u, k := os.Getenv("KAGGLE_USERNAME"), os.Getenv("KAGGLE_KEY"); u != "" && k != "" {
+		return u, k, nil
+	}
+	dir := os.Getenv("KAGGLE_CONFIG_DIR")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", err
+		}
+		dir = filepath.Join(home, ".kaggle")
+	}
+	path := filepath.Join(dir, "kaggle.json")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", "", fmt.Errorf("no Kaggle credentials: set KAGGLE_USERNAME and KAGGLE_KEY, or create %v", path)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	var c struct {
+		Username string
+		Key      string
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return "", "", fmt.Errorf("%v: %w", path, err)
+	}
+	if c.Username == "" || c.Key == "" {
+		return "", "", fmt.Errorf("%v: no username or key", path)
+	}
+	return c.Username, c.Key, nil
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("harvest_kaggle", "datasets", false)
+	var err error
+	if username, apiKey, err = credentials(); err != nil {
+		log.Fatal(err)
+	}
+	*apiURL = strings.TrimSuffix(*apiURL, "/")
+	client = crawl.NewClient()
+	client.Authorize = func(req *http.Request) error {
+		req.SetBasicAuth(username, apiKey)
+		return nil
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	w, err := harvest.Start(db, config.DatasetsDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *incremental {
+		if err := w.Incremental(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fails, err := failures.Start("harvest_kaggle")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The datasets harvested before the search fails are kept.
+	listed, harvested, changed, harvestErr := harvestDatasets(w, fails)
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if harvestErr != nil {
+		log.Fatal(harvestErr)
+	}
+	log.Printf("harvested %d of %d datasets; %d changed, %d unchanged", harvested, listed, changed, unchanged)
+	if err := fails.Finish(db, false); err != nil {
+		log.Fatal(err)
+	}
+	summary.Processed, summary.Total = int64(harvested), int64(listed)
+	summary.Skipped = unchanged
+	summary.Count("changed", changed)
+	if err := summary.Finish(db, nil, fails); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,429 @@
+// Command harvest_opendatasoft harvests the datasets of OpenDataSoft portals,
+// such as data.opendatasoft.com or opendata.paris.fr, through the Explore API
+// v2.1, for the ingestion pipeline.
+//
+// The datasets of each of the -portals matching the -where query and the
+// -refine facets, if any, are read from the export of its catalog, and mapped
+// into metadata: the title, description, publisher, contact email,
+// modification time, themes, and keywords of each dataset become the name,
+// description, attribution, contact email, update time, categories, and tags
+// of its dataset, identified by its OpenDataSoft UID, such as da_abcd1234. A
+// sample of the first -samplerows rows of its records, or all of them if
+// -samplerows is 0, is downloaded from its CSV export. The metadata and the
+// sample are written to datasets/<id>/metadata.json and datasets/<id>/rows.csv
+// (see config.DatasetsDir), and the metadata is also stored in the metadata
+// table, the fields of the dataset, with their labels, types, and
+// descriptions, and its exports and attachments in the source_columns and
+// source_distributions tables (see package harvest). The harvested datasets
+// are then ingested by the pipeline:
+//
+//	harvest_opendatasoft -portals https://data.opendatasoft.com -refine theme:Transport
+//	process_datasets -changed-only
+//
+// The datasets whose records cannot be downloaded are skipped, and their
+// failures reported (see package failures). Datasets without records, such as
+// those of files only, are harvested without a table. With -incremental, the
+// datasets whose modification time is the same as when they were last
+// harvested are skipped without downloading their records again, and so are
+// the portals whose catalog export is not modified since the last harvest, if
+// the database has an http_validators table (see package crawl).
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/crawl"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	portals     = flag.String("portals", "", "comma-separated URLs of the OpenDataSoft portals to harvest, such as https://data.opendatasoft.com")
+	where       = flag.String("where", "", "ODSQL query of the datasets to harvest, such as records_count > 1000")
+	refine      = flag.String("refine", "", "comma-separated facets of the datasets to harvest, such as theme:Transport or keyword:bus")
+	apiKeyFile  = flag.String("apikey", "", "file of an OpenDataSoft API key, for private datasets")
+	sampleRows  = flag.Int("samplerows", 1000, "number of rows of the sample of each table, or 0 to download the whole tables")
+	maxDatasets = flag.Int("max", 0, "maximum number of datasets harvested from each portal, or 0 for all")
+	incremental = flag.Bool("incremental", false, "skip the datasets whose modification time did not change since they were harvested")
+)
+
+const (
+	// Path of the Explore API v2.1
+	apiPath = "/api/explore/v2.1"
+)
+
+// API key sent with the requests, if any, and client of the requests, set by
+// main.
+var (
+	apiKey string
+	client *crawl.Client
+)
+
+// Number of datasets skipped by -incremental
+var unchanged int
+
+// Validators of the catalogs harvested by -incremental without failures, by
+// URL, recorded once their datasets are committed
+var validators = make(map[string]*crawl.Validators)
+
+// odsDataset is a dataset of the catalog of a portal.
+type odsDataset struct {
+	DatasetID  string `json:"dataset_id"`
+	DatasetUID string `json:"dataset_uid"`
+	HasRecords bool   `json:"has_records"`
+	Fields     []*struct {
+		Name        string
+		Label       string
+		Type        string
+		Description string
+	}
+	Attachments []*struct {
+		ID    string
+		Title string
+		URL   string
+		Href  string
+	}
+	Metas struct {
+		Default odsMetas
+		DCAT    *struct {
+			ContactEmail string `json:"contact_email"`
+			Creator      string
+		} `json:"dcat"`
+	}
+}
+
+// odsMetas is the default metadata template of a dataset.
+type odsMetas struct {
+	Title       string
+	Description string
+	Publisher   string
+	Modified    string
+	// Themes and keywords, facets of the catalog.
+	Theme   odsList
+	Keyword odsList
+}
+
+// odsList is a list of metadata values, each possibly null, or a single
+// value.
+type odsList []string
+
+func (l *odsList) UnmarshalJSON(data []byte) error {
+	var s *string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = nil
+		if s != nil {
+			*l = odsList{*s}
+		}
+		return nil
+	}
+	var values []*string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*l = nil
+	for _, v := range values {
+		if v != nil {
+			*l = append(*l, *v)
+		}
+	}
+	return nil
+}
+
+// dataset returns the harvested dataset of ds, from the portal.
+func (ds *odsDataset) dataset(portal string) *harvest.Dataset {
+	meta := ds.Metas.Default
+	m := &database.Metadata{
+		DatasetID:   ds.DatasetUID,
+		Name:        meta.Title,
+		Description: htmlText(meta.Description),
+		Attribution: meta.Publisher,
+		UpdatedAt:   meta.Modified,
+		Categories:  harvest.Unique(meta.Theme),
+		Tags:        harvest.Unique(meta.Keyword),
+		Permalink:   portal + "/explore/dataset/" + url.PathEscape(ds.DatasetID) + "/",
+	}
+	if m.Name == "" {
+		m.Name = ds.DatasetID
+	}
+	if dcat := ds.Metas.DCAT; dcat != nil {
+		m.ContactEmail = dcat.ContactEmail
+		if m.Attribution == "" {
+			m.Attribution = dcat.Creator
+		}
+	}
+
+	d := &harvest.Dataset{Metadata: m, Source: "opendatasoft:" + portal}
+	for _, f := range ds.Fields {
+		col := &harvest.Column{Name: f.Label, Type: f.Type, Description: htmlText(f.Description)}
+		if col.Name == "" {
+			col.Name = f.Name
+		} else if col.Name != f.Name {
+			col.FieldName = f.Name
+		}
+		d.Columns = append(d.Columns, col)
+	}
+	if ds.HasRecords {
+		for _, format := range []string{"csv", "json", "geojson"} {
+			d.Distributions = append(d.Distributions, &harvest.Distribution{
+				Name:   ds.DatasetID + "." + format,
+				URL:    exportURL(portal, ds.DatasetID, format, nil),
+				Format: strings.ToUpper(format),
+			})
+		}
+	}
+	for _, a := range ds.Attachments {
+		u := a.URL
+		if u == "" {
+			u = a.Href
+		}
+		d.Distributions = append(d.Distributions, &harvest.Distribution{Name: a.Title, URL: u})
+	}
+	return d
+}
+
+// exportURL returns the URL of the export of the records of the dataset of
+// the portal in the format, with the parameters.
+func exportURL(portal, datasetID, format string, params url.Values) string {
+	u := portal + apiPath + "/catalog/datasets/" + url.PathEscape(datasetID) + "/exports/" + format
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	return u
+}
+
+var (
+	tagPattern        = regexp.MustCompile(`<[/!A-Za-z][^>]*>`)
+	blockTagPattern   = regexp.MustCompile(`(?i)^(<(p|div|br|li|h[1-6]|tr)|</(p|div|h[1-6]))\b`)
+	blankLinesPattern = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// htmlText returns the text of the HTML description s, with its paragraphs
+// and list items on lines of their own.
+func htmlText(s string) string {
+	s = tagPattern.ReplaceAllStringFunc(s, func(tag string) string {
+		if blockTagPattern.MatchString(tag) {
+			return "\n"
+		}
+		return ""
+	})
+	var lines []string
+	for _, line := range strings.Split(html.UnescapeString(s), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// get gets the resource at u, with the API key (see package crawl).
+func get(u string) (*http.Response, error) {
+	return client.Get(u)
+}
+
+// harvestDataset harvests the dataset ds of the portal, reporting whether its
+// files changed.
+func harvestDataset(w *harvest.Writer, portal string, ds *odsDataset) (bool, error) {
+	if ds.DatasetUID == "" {
+		return false, errors.New("dataset has no UID")
+	}
+	d := ds.dataset(portal)
+	var rows io.Reader
+	if ds.HasRecords {
+		limit := *sampleRows
+		if limit == 0 {
+			limit = -1
+		}
+		params := url.Values{"limit": {strconv.Itoa(limit)}, "delimiter": {","}, "with_bom": {"false"}}
+		u := exportURL(portal, ds.DatasetID, "csv", params)
+		res, err := get(u)
+		if err != nil {
+			return false, err
+		}
+		defer res.Body.Close()
+		sample, err := harvest.SampleCSV(res.Body, *sampleRows)
+		if err != nil {
+			return false, fmt.Errorf("%v: %w", u, err)
+		}
+		rows = bytes.NewReader(sample)
+	}
+	return w.Write(d, rows)
+}
+
+// harvestPortal harvests the datasets of the portal, read from the export of
+// its catalog as they are decoded, returning the numbers of datasets listed,
+// harvested, and changed.
+func harvestPortal(db *sql.DB, w *harvest.Writer, portal string, fails *failures.Recorder) (listed, harvested, changed int, err error) {
+	params := url.Values{}
+	if *where != "" {
+		params.Set("where", *where)
+	}
+	for _, facet := range strings.Split(*refine, ",") {
+		if facet = strings.TrimSpace(facet); facet != "" {
+			params.Add("refine", facet)
+		}
+	}
+	if *maxDatasets > 0 {
+		params.Set("limit", strconv.Itoa(*maxDatasets))
+	}
+	u := portal + apiPath + "/catalog/exports/json"
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	var res *http.Response
+	var v *crawl.Validators
+	if *incremental {
+		if v, err = crawl.ReadValidators(db, u); err != nil {
+			return 0, 0, 0, err
+		}
+		res, err = client.GetIfChanged(u, v)
+	} else {
+		res, err = get(u)
+	}
+	if errors.Is(err, crawl.ErrNotModified) {
+		log.Printf("catalog of %v not modified since its last harvest", portal)
+		return 0, 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer res.Body.Close()
+	failed := fails.Datasets()
+	dec := json.NewDecoder(res.Body)
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return 0, 0, 0, fmt.Errorf("catalog of %v is not a JSON array of datasets (%v)", portal, err)
+	}
+	for dec.More() && (*maxDatasets == 0 || listed < *maxDatasets) {
+		var ds odsDataset
+		if err := dec.Decode(&ds); err != nil {
+			return listed, harvested, changed, fmt.Errorf("catalog of %v: %w", portal, err)
+		}
+		listed++
+		if w.Unchanged(ds.DatasetUID, ds.Metas.Default.Modified) {
+			unchanged++
+			continue
+		}
+		log.Println("harvesting", ds.DatasetID)
+		ok, err := harvestDataset(w, portal, &ds)
+		if err != nil {
+			id := ds.DatasetUID
+			if id == "" {
+				id = ds.DatasetID
+			}
+			fails.Fail(id, err)
+			continue
+		}
+		harvested++
+		if ok {
+			changed++
+		}
+	}
+	// The datasets that failed are retried with the whole catalog.
+	if v != nil && fails.Datasets() == failed {
+		validators[u] = v
+	}
+	return listed, harvested, changed, nil
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("harvest_opendatasoft", "datasets", false)
+	if *portals == "" {
+		log.Fatal("no -portals to harvest")
+	}
+	if *sampleRows < 0 {
+		log.Fatal("-samplerows must not be negative")
+	}
+	if *apiKeyFile != "" {
+		key, err := ioutil.ReadFile(*apiKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		apiKey = strings.TrimSpace(strings.SplitN(string(key), "\n", 2)[0])
+	}
+	client = crawl.NewClient()
+	if apiKey != "" {
+		client.Authorize = func(req *http.Request) error {
+			req.Header.Set("Authorization", "Apikey "+apiKey)
+			return nil
+		}
+	}
+	// The errors of the API have a message.
+	client.Message = func(body []byte) string {
+		var e struct {
+			Message string
+		}
+		json.Unmarshal(body, &e)
+		return e.Message
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	w, err := harvest.Start(db, config.DatasetsDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *incremental {
+		if err := w.Incremental(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fails, err := failures.Start("harvest_opendatasoft")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The datasets harvested before a portal fails are kept.
+	var listed, harvested, changed int
+	var harvestErr error
+	for _, portal := range strings.Split(*portals, ",") {
+		portal = strings.TrimSuffix(strings.TrimSpace(portal), "/")
+		if !strings.Contains(portal, "://") {
+			portal = "https://" + portal
+		}
+		var l, h, c int
+		l, h, c, harvestErr = harvestPortal(db, w, portal, fails)
+		listed, harvested, changed = listed+l, harvested+h, changed+c
+		if harvestErr != nil {
+			break
+		}
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if harvestErr != nil {
+		log.Fatal(harvestErr)
+	}
+	for u, v := range validators {
+		if err := crawl.WriteValidators(db, u, v); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("harvested %d of %d datasets; %d changed, %d unchanged", harvested, listed, changed, unchanged)
+	if err := fails.Finish(db, false); err != nil {
+		log.Fatal(err)
+	}
+	summary.Processed, summary.Total = int64(harvested), int64(listed)
+	summary.Skipped = unchanged
+	summary.Count("changed", changed)
+	if err := summary.Finish(db, nil, fails); err != nil {
+		log.Fatal(err)
+	}
+}
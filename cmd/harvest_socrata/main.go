@@ -0,0 +1,324 @@
+// Command harvest_socrata harvests the datasets of Socrata portals, such as
+// NYC Open Data (data.cityofnewyork.us), through the Socrata Discovery API,
+// for the ingestion pipeline, replacing scripts/download_socrata_datasets.sh.
+//
+// The datasets of each of the -domains are paged through, and for each, its
+// metadata, the Discovery API result read by process_metadata, and a sample of
+// the first -samplerows rows of its table, or the whole table if -samplerows
+// is 0, are written to datasets/<id>/metadata.json and datasets/<id>/rows.csv
+// (see config.DatasetsDir). The metadata is also stored in the metadata
+// table, the columns declared by the portal and the URL of the CSV export of
+// the table in the source_columns and source_distributions tables, and the
+// datasets whose files changed are marked for processing (see package
+// harvest). The harvested datasets are then ingested by the pipeline:
+//
+//	harvest_socrata -domains data.cityofnewyork.us
+//	process_datasets -changed-only
+//
+// The datasets whose tables cannot be downloaded are skipped, and their
+// failures reported (see package failures). With -incremental, the datasets
+// whose update time is the same as when they were last harvested are skipped
+// without downloading their tables again.
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/crawl"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	domains      = flag.String("domains", "", "comma-separated domains of the Socrata portals to harvest, such as data.cityofnewyork.us")
+	apiURL       = flag.String("api", "https://api.us.socrata.com/api/catalog/v1", "URL of the Socrata Discovery API (api.eu.socrata.com for European portals)")
+	appTokenFile = flag.String("apptoken", "", "file of a Socrata app token, which raises the rate limits")
+	sampleRows   = flag.Int("samplerows", 1000, "number of rows of the sample of each table, or 0 to download the whole tables")
+	maxDatasets  = flag.Int("max", 0, "maximum number of datasets harvested from each domain, or 0 for all")
+	provenance   = flag.String("provenance", "official", "provenance of the datasets harvested, official or community, or empty for both")
+	incremental  = flag.Bool("incremental", false, "skip the datasets whose update time did not change since they were harvested")
+)
+
+const (
+	// Number of results per page of the Discovery API
+	pageSize = 100
+)
+
+// App token sent with the requests, if any, and client of the requests, set
+// by main.
+var (
+	appToken string
+	client   *crawl.Client
+)
+
+// Number of datasets skipped by -incremental
+var unchanged int
+
+// result is a result of the Discovery API.
+type result struct {
+	Resource struct {
+		ID                 string
+		Name               string
+		Description        string
+		Attribution        string
+		ContactEmail       string `json:"contact_email"`
+		UpdatedAt          string
+		ColumnsName        []string `json:"columns_name"`
+		ColumnsFieldName   []string `json:"columns_field_name"`
+		ColumnsDatatype    []string `json:"columns_datatype"`
+		ColumnsDescription []string `json:"columns_description"`
+	}
+	Classification struct {
+		Categories     []string
+		Tags           []string
+		DomainCategory string   `json:"domain_category"`
+		DomainTags     []string `json:"domain_tags"`
+	}
+	Metadata struct {
+		Domain string
+	}
+	Permalink string
+}
+
+// dataset returns the harvested dataset of r, whose metadata file is raw.
+func (r *result) dataset(raw []byte) (*harvest.Dataset, error) {
+	var file bytes.Buffer
+	if err := json.Indent(&file, raw, "", "  "); err != nil {
+		return nil, err
+	}
+	c := r.Classification
+	d := &harvest.Dataset{
+		Metadata: &database.Metadata{
+			DatasetID:    r.Resource.ID,
+			Name:         r.Resource.Name,
+			Description:  r.Resource.Description,
+			Attribution:  r.Resource.Attribution,
+			ContactEmail: r.Resource.ContactEmail,
+			UpdatedAt:    r.Resource.UpdatedAt,
+			Categories:   harvest.Unique(append(append([]string{}, c.Categories...), c.DomainCategory)),
+			Tags:         harvest.Unique(append(append([]string{}, c.Tags...), c.DomainTags...)),
+			Permalink:    r.Permalink,
+		},
+		Source: "socrata:" + r.Metadata.Domain,
+		Distributions: []*harvest.Distribution{
+			{Name: "rows.csv", URL: exportURL(r.Metadata.Domain, r.Resource.ID), Format: "CSV"},
+		},
+		MetadataFile: file.Bytes(),
+	}
+	for i, name := range r.Resource.ColumnsName {
+		col := &harvest.Column{Name: name}
+		col.FieldName = index(r.Resource.ColumnsFieldName, i)
+		if col.FieldName == name {
+			col.FieldName = ""
+		}
+		col.Type = index(r.Resource.ColumnsDatatype, i)
+		col.Description = index(r.Resource.ColumnsDescription, i)
+		d.Columns = append(d.Columns, col)
+	}
+	return d, nil
+}
+
+// index returns the ith value, or "" if there are not as many values.
+func index(values []string, i int) string {
+	if i < len(values) {
+		return values[i]
+	}
+	return ""
+}
+
+// get gets the resource at u, with the app token (see package crawl).
+func get(u string) (*http.Response, error) {
+	return client.Get(u)
+}
+
+// page returns a page of the results of the Discovery API for domain, after
+// the dataset with the ID scrollID, if not empty.
+func page(domain, scrollID string) ([]json.RawMessage, error) {
+	q := url.Values{
+		"domains":        {domain},
+		"search_context": {domain},
+		"only":           {"datasets"},
+		"limit":          {strconv.Itoa(pageSize)},
+		"scroll_id":      {scrollID},
+	}
+	if *provenance != "" {
+		q.Set("provenance", *provenance)
+	}
+	res, err := get(*apiURL + "?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var body struct {
+		Results []json.RawMessage
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("results of %v: %w", domain, err)
+	}
+	return body.Results, nil
+}
+
+// exportURL returns the URL of the CSV export of the whole table of the
+// dataset.
+func exportURL(domain, datasetID string) string {
+	return fmt.Sprintf("https://%v/api/views/%v/rows.csv?accessType=DOWNLOAD", domain, datasetID)
+}
+
+// rows opens the sample of the rows of the dataset, or its whole table.
+func rows(domain, datasetID string) (io.ReadCloser, error) {
+	u := exportURL(domain, datasetID)
+	if *sampleRows > 0 {
+		u = fmt.Sprintf("https://%v/resource/%v.csv?%v", domain, datasetID,
+			url.Values{"$limit": {strconv.Itoa(*sampleRows)}}.Encode())
+	}
+	res, err := get(u)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// harvestDomain harvests the datasets of domain, returning the numbers of
+// datasets listed, harvested, and changed.
+func harvestDomain(w *harvest.Writer, domain string, fails *failures.Recorder) (listed, harvested, changed int, err error) {
+	var scrollID string
+	for *maxDatasets == 0 || listed < *maxDatasets {
+		results, err := page(domain, scrollID)
+		if err != nil {
+			return listed, harvested, changed, err
+		}
+		if len(results) == 0 {
+			break
+		}
+		for _, raw := range results {
+			var r result
+			if err := json.Unmarshal(raw, &r); err != nil {
+				return listed, harvested, changed, fmt.Errorf("results of %v: %w", domain, err)
+			}
+			scrollID = r.Resource.ID
+			if *maxDatasets > 0 && listed == *maxDatasets {
+				break
+			}
+			listed++
+			if r.Metadata.Domain == "" {
+				r.Metadata.Domain = domain
+			}
+			if w.Unchanged(r.Resource.ID, r.Resource.UpdatedAt) {
+				unchanged++
+				continue
+			}
+			log.Println("harvesting", r.Resource.ID)
+			ok, err := harvestDataset(w, &r, raw)
+			if err != nil {
+				fails.Fail(r.Resource.ID, err)
+				continue
+			}
+			harvested++
+			if ok {
+				changed++
+			}
+		}
+	}
+	return listed, harvested, changed, nil
+}
+
+// harvestDataset harvests the dataset of the result r, whose JSON is raw,
+// reporting whether its files changed.
+func harvestDataset(w *harvest.Writer, r *result, raw []byte) (bool, error) {
+	d, err := r.dataset(raw)
+	if err != nil {
+		return false, err
+	}
+	f, err := rows(r.Metadata.Domain, d.DatasetID)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return w.Write(d, f)
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("harvest_socrata", "datasets", false)
+	if *domains == "" {
+		log.Fatal("no -domains to harvest")
+	}
+	if *sampleRows < 0 {
+		log.Fatal("-samplerows must not be negative")
+	}
+	if *appTokenFile != "" {
+		token, err := ioutil.ReadFile(*appTokenFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		appToken = strings.TrimSpace(strings.SplitN(string(token), "\n", 2)[0])
+	}
+	client = crawl.NewClient()
+	if appToken != "" {
+		client.Authorize = func(req *http.Request) error {
+			req.Header.Set("X-App-Token", appToken)
+			return nil
+		}
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	w, err := harvest.Start(db, config.DatasetsDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *incremental {
+		if err := w.Incremental(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fails, err := failures.Start("harvest_socrata")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The datasets harvested before a domain fails are kept.
+	var listed, harvested, changed int
+	var harvestErr error
+	for _, domain := range strings.Split(*domains, ",") {
+		var l, h, c int
+		l, h, c, harvestErr = harvestDomain(w, strings.TrimSpace(domain), fails)
+		listed, harvested, changed = listed+l, harvested+h, changed+c
+		if harvestErr != nil {
+			break
+		}
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if harvestErr != nil {
+		log.Fatal(harvestErr)
+	}
+	log.Printf("harvested %d of %d datasets; %d changed, %d unchanged", harvested, listed, changed, unchanged)
+	if err := fails.Finish(db, false); err != nil {
+		log.Fatal(err)
+	}
+	summary.Processed, summary.Total = int64(harvested), int64(listed)
+	summary.Skipped = unchanged
+	summary.Count("changed", changed)
+	if err := summary.Finish(db, nil, fails); err != nil {
+		log.Fatal(err)
+	}
+}
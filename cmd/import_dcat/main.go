@@ -0,0 +1,134 @@
+// Command import_dcat imports the datasets of DCAT catalog dumps, such as the
+// DCAT-AP catalogs of European open data portals, for the ingestion pipeline.
+//
+// Each file argument is an RDF document, in Turtle (.ttl), RDF/XML (.rdf,
+// .xml), or JSON-LD (.jsonld, .json) by its extension, or in the -format, and
+// may be compressed with gzip or zip. Its dcat:Dataset resources are mapped
+// into metadata, with the literals in the -lang language preferred (see
+// package dcat), and their dcat:Distribution resources into the files of the
+// datasets. The metadata is written to datasets/<id>/metadata.json (see
+// config.DatasetsDir), and stored in the metadata table, the distributions in
+// the source_distributions table, and the datasets whose metadata changed are
+// marked for processing (see package harvest). The catalogs list the files of
+// the datasets but do not contain their rows, so the datasets are imported
+// without a table:
+//
+//	import_dcat -lang de catalog.ttl.gz
+//	process_metadata
+//
+// The files that cannot be parsed are skipped, and their failures reported
+// (see package failures).
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/dcat"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	format = flag.String("format", "", "format of the catalogs, turtle, rdfxml, or jsonld, instead of that of their extensions")
+	base   = flag.String("base", "", "IRI against which the relative IRIs of the catalogs are resolved")
+	source = flag.String("source", "", "name of the catalogs the datasets are harvested from, instead of the names of their files")
+	lang   = flag.String("lang", "en", "language of the literals preferred, of the catalogs in several languages")
+)
+
+// importFile imports the datasets of the catalog at path, returning the
+// numbers of datasets listed, imported, and changed.
+func importFile(w *harvest.Writer, path string, seen map[string]bool, fails *failures.Recorder) (listed, imported, changed int, err error) {
+	f := *format
+	if f == "" {
+		if f = dcat.Format(datafile.Uncompressed(path)); f == "" {
+			return 0, 0, 0, fmt.Errorf("unknown format of %v; use -format", path)
+		}
+	}
+	r, err := datafile.Open(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer r.Close()
+	g, err := dcat.Parse(r, f, *base)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%v: %w", path, err)
+	}
+	src := *source
+	if src == "" {
+		src = filepath.Base(datafile.Uncompressed(path))
+	}
+	for _, d := range dcat.Datasets(g, *lang) {
+		listed++
+		if seen[d.DatasetID] {
+			fails.Fail(d.DatasetID, fmt.Errorf("%v: duplicate dataset ID", path))
+			continue
+		}
+		seen[d.DatasetID] = true
+		d.Source = "dcat:" + src
+		log.Println("importing", d.DatasetID)
+		ok, err := w.Write(d, nil)
+		if err != nil {
+			fails.Fail(d.DatasetID, err)
+			continue
+		}
+		imported++
+		if ok {
+			changed++
+		}
+	}
+	return listed, imported, changed, nil
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("import_dcat", "datasets", false)
+	if flag.NArg() == 0 {
+		log.Fatal("no catalogs to import")
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	w, err := harvest.Start(db, config.DatasetsDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fails, err := failures.Start("import_dcat")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The IDs of the datasets imported, as the catalogs may list a dataset
+	// more than once.
+	seen := make(map[string]bool)
+	var listed, imported, changed int
+	for _, path := range flag.Args() {
+		l, i, c, err := importFile(w, path, seen, fails)
+		if err != nil {
+			fails.Fail(filepath.Base(path), err)
+			continue
+		}
+		listed, imported, changed = listed+l, imported+i, changed+c
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("imported %d of %d datasets; %d changed", imported, listed, changed)
+	if err := fails.Finish(db, false); err != nil {
+		log.Fatal(err)
+	}
+	summary.Processed, summary.Total = int64(imported), int64(listed)
+	summary.Count("changed", changed)
+	if err := summary.Finish(db, nil, fails); err != nil {
+		log.Fatal(err)
+	}
+}
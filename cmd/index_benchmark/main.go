@@ -0,0 +1,63 @@
+// Command index_benchmark benchmarks the metadata index backends.
+//
+// It samples the stored metadata vectors, builds each backend over the sample,
+// and reports its recall@k against exact search of held-out query vectors,
+// query latency percentiles, build time, and memory use.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index/benchmark"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	sampleSize = flag.Int("sample", 100000, "number of vectors to sample")
+	numQueries = flag.Int("queries", 1000, "number of sampled vectors to hold out as queries")
+	k          = flag.Int64("k", 20, "number of nearest neighbors per query")
+	backends   = flag.String("backends", "", "comma-separated backend specifications (default: flat, sharded, lshforest, and ivf)")
+	csvOut     = flag.Bool("csv", false, "write the report as CSV")
+)
+
+func main() {
+	config.ParseFlags()
+
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	params, err := config.IndexParameters()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := index.SampleMetadataVectors(db, params.EmbeddingDim, *sampleSize, *numQueries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	specs := benchmark.DefaultBackends(len(s.IDs))
+	if *backends != "" {
+		specs = strings.Split(*backends, ",")
+	}
+
+	report, err := benchmark.Run(s, *k, specs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *csvOut {
+		err = report.WriteCSV(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
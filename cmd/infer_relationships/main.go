@@ -0,0 +1,106 @@
+// Command infer_relationships infers probable foreign key relationships
+// between the datasets of the Open Data Link database and stores them in the
+// relationships table, replacing those inferred before.
+//
+// A column references a column of another dataset if the other column is a
+// candidate key of its table (see sketch_columns) and contains at least
+// -mincontainment of the column's distinct values, as estimated from their
+// sketches by the joinability index.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	minContainment = flag.Float64("mincontainment", 0.9, "minimum fraction of the values of a column contained in the key it references")
+	minDistinct    = flag.Int("mindistinct", 10, "minimum number of distinct values of the related columns")
+)
+
+// columnIDs returns the IDs of all sketched columns.
+func columnIDs(db *database.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT column_id FROM column_sketches ORDER BY column_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func main() {
+	config.ParseFlags()
+	if *minContainment <= 0 || *minContainment > 1 {
+		log.Fatal("-mincontainment must be in (0, 1]")
+	}
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"relationships", "column_uniqueness"} {
+		if ok, err := database.HasTable(db, table); err != nil {
+			log.Fatal(err)
+		} else if !ok {
+			log.Fatalf("no %v table (see sql/create_%[1]v_table.sql)", table)
+		}
+	}
+	params, err := config.IndexParameters()
+	if err != nil {
+		log.Fatal(err)
+	}
+	joinabilityIndex, err := index.BuildJoinabilityIndex(db, params.LSHPartitions, params.LSHMaxK)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("built joinability index")
+
+	ids, err := columnIDs(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var rels []*database.Relationship
+
+	for i, id := range ids {
+		query, err := db.ColumnSketch(id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys, err := joinabilityIndex.ForeignKeys(query, *minContainment, *minDistinct)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, k := range keys {
+			rels = append(rels, &database.Relationship{
+				ColumnID:     query.ColumnID,
+				DatasetID:    query.DatasetID,
+				KeyColumnID:  k.ColumnID,
+				KeyDatasetID: k.DatasetID,
+				Containment:  k.Containment,
+				SharedValues: k.Intersection,
+			})
+		}
+		if (i+1)%1000 == 0 {
+			log.Printf("checked %d of %d columns", i+1, len(ids))
+		}
+	}
+	if err := db.SetRelationships(rels); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("inferred %d relationships of %d columns", len(rels), len(ids))
+}
@@ -26,13 +26,18 @@ func randomDataset(db *database.DB) string {
 }
 
 func main() {
+	config.ParseFlags()
 	db, err := database.New(config.DatabasePath())
 	if err != nil {
 		panic(err)
 	}
 	defer db.Close()
 
-	idx, err := index.BuildMetadataEmbeddingIndex(db)
+	params, err := config.IndexParameters()
+	if err != nil {
+		panic(err)
+	}
+	idx, err := index.BuildMetadataEmbeddingIndex(db, params.EmbeddingDim)
 	if err != nil {
 		panic(err)
 	}
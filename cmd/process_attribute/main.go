@@ -1,108 +1,1100 @@
 // Command process_attribute creates attribute embedding vectors and stores the
 // attribute and the vectors in the Open Data Link database.
+//
+// The attributes of a dataset are read from its attribute.json file, which
+// holds an attribute or a list of them, or, if it has none, they are the
+// columns of its rows.csv, rows.parquet, rows.xlsx, rows.jsonl, or rows.json
+// file, whose types are inferred from and values sampled from its first
+// -samplerows rows and stored in the attribute_profiles table if it exists.
+// The columns of workbooks with several sheets are named by their sheet and
+// column, as in "Schools.name". The columns of a partitioned dataset are
+// those of the files of its rows directory, merged, and its partition
+// columns (see package partition), profiled from -samplerows rows spread
+// over its files.
+//
+// With -stdin, the datasets are instead read from standard input, as JSON
+// lines of dataset records holding the attributes of a dataset or its rows
+// (see streamRecord), so that the output of a crawler can be piped to
+// process_attribute without being stored. The records are processed as they
+// are read, and those whose lines are unchanged since they were processed are
+// skipped if ingestions are recorded.
+//
+// The attribute.json files are validated strictly (see
+// datafile.ReadAttributeJSON). The datasets with invalid files are skipped,
+// as are the datasets that cannot otherwise be read or written (see package
+// failures); with -report, the errors of the invalid files are also written to
+// a report with a line per error.
+//
+// The attributes are read and embedded by -workers goroutines (the number of
+// CPUs by default), and the embedded batches are written by a single goroutine
+// in a transaction per batch, which is retried if the database is busy, as
+// when a server is running on it (see database.Chunker).
+//
+// If the database has an attribute_ingestion table, the hash of each dataset's
+// attribute file is recorded with the status of its processing, and only the
+// datasets whose files are new or changed, or whose processing was interrupted,
+// are processed again, replacing their previous attributes and vectors. If it
+// has a dataset_checksums table, the checksums of the files of each dataset
+// processed are recorded, and with -changed-only only the datasets whose files
+// changed since they were last processed are read (see package checksum),
+// which does not apply to -stdin.
+//
+// With -dry-run, the datasets are read, embedded, and written in transactions
+// that are rolled back, so that they are validated, and what would be stored
+// reported, without changing the database.
 // chem
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/attributeembedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/checksum"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/hooks"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/layout"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/partition"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/progress"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
-	"github.com/ekzhu/go-fasttext"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-const datasetsDir = "datasets"
+var (
+	numWorkers = flag.Int("workers", runtime.NumCPU(), "number of datasets read and embedded concurrently")
+	dryRun     = flag.Bool("dry-run", false, "process the datasets without writing to the database, reporting what would be stored")
+	sampleRows = flag.Int("samplerows", 1000, "number of rows of CSV, Parquet, Excel, and JSON files read to infer the types of their columns and sample their values")
+	reportPath = flag.String("report", "", "file to write the errors of the invalid attribute.json files to")
+	stdin      = flag.Bool("stdin", false, "read the datasets from standard input, as JSON lines of dataset records, instead of from their files")
+)
+
+// Layout of the datasets (see config.DatasetLayout), set by main.
+var datasets *layout.Layout
+
+// Ingestion hooks (see package hooks), set by main.
+var datasetHooks *hooks.Hooks
+
+// Checksums of the dataset files (see package checksum), set by main.
+var checksums *checksum.Tracker
+
+const (
+	// Number of attribute names embedded at once.
+	embedBatchSize = 1024
+	// Maximum size of a dataset record read from standard input.
+	maxRecordSize = 64 << 20
+)
 
 type attributeNode struct {
 	AttributeName string
 	DatasetID     string
+	// ID of the dataset in the layout of the datasets.
+	dir string
+	// Hash of the dataset's attribute file or record, if ingestions are
+	// recorded.
+	hash string
+	// Profile of the attribute, if it is a column of the dataset's rows file.
+	profile *datafile.Attribute
 }
 
-func attributeVector(ft *fasttext.FastText, m *attributeNode) ([]float32, error) {
-	return attributeembedding.Vector(ft, m.AttributeName)
+// embeddedBatch is a batch of attributes embedded with the same model, to be
+// stored by the writer.
+type embeddedBatch struct {
+	attributes []*attributeNode
+	embs       [][]float32
+	errs       []error
+	version    embedding.Version
 }
 
-func main() {
-	db, err := sql.Open("sqlite3", config.DatabasePath())
+// datasets returns the number of datasets of the attributes of the batch.
+func (b *embeddedBatch) datasets() int {
+	dirs := make(map[string]bool)
+	for _, m := range b.attributes {
+		dirs[m.dir] = true
+	}
+	return len(dirs)
+}
+
+// attributeEmbedder embeds the attributes of datasets with the model of their
+// metadata. It is safe for concurrent use.
+type attributeEmbedder struct {
+	tokenizer *attributeembedding.Tokenizer
+	embedder  embedding.Model
+	version   embedding.Version
+	// The category models and the names of the models of the datasets'
+	// metadata vectors, if there are category models.
+	router *embedding.Router
+	models map[string]string
+}
+
+// model returns the name of the model of the dataset's metadata vector
+// recorded by process_metadata, or the default model if none is recorded.
+func (a *attributeEmbedder) model(datasetID string) (string, error) {
+	if a.router == nil {
+		return "", nil
+	}
+	name := a.models[datasetID]
+	if a.router.Model(name) == nil {
+		return "", fmt.Errorf("metadata embedded with category model %q, which is not configured", name)
+	}
+	return name, nil
+}
+
+// embed embeds the attributes with the model named name.
+func (a *attributeEmbedder) embed(name string, ms []*attributeNode) (*embeddedBatch, error) {
+	var e embedding.Embedder = a.embedder
+	v := a.version
+	if a.router != nil {
+		e, v = a.router.Model(name), a.router.Version(name)
+	}
+	names := make([]string, len(ms))
+	for i, m := range ms {
+		names[i] = m.AttributeName
+	}
+	embs, errs, err := a.tokenizer.Vectors(e, names)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer db.Close()
+	return &embeddedBatch{ms, embs, errs, v}, nil
+}
 
-	ft := fasttext.NewFastText(config.FasttextPath())
-	defer ft.Close()
+// datasetModels returns the names of the models of the datasets' metadata
+// vectors by dataset ID.
+func datasetModels(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query(`SELECT dataset_id, model FROM vector_models`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	tx, err := db.Begin()
+	models := make(map[string]string)
+	for rows.Next() {
+		var datasetID, name string
+		if err := rows.Scan(&datasetID, &name); err != nil {
+			return nil, err
+		}
+		models[datasetID] = name
+	}
+	return models, rows.Err()
+}
+
+// attributeFile returns the path of the file the attributes of the dataset
+// with the given ID are read from, its attribute.json file if it has one, and
+// otherwise its rows.csv, rows.parquet, rows.xlsx, rows.jsonl, or rows.json
+// file, or its rows directory if it is partitioned, or the file of the same
+// format listed in the manifest (see layout.Layout.Path), and the conventional
+// name of the file. Except for the Parquet and Excel files, which are read at
+// random, the files may be gzip or zip compressed (see datafile.Find).
+func attributeFile(datasetID string) (path, name string, err error) {
+	for _, name := range []string{"attribute.json", "rows.csv", "rows.parquet", "rows.xlsx", "rows.jsonl", "rows.json", "rows"} {
+		path, err := datasets.Path(datasetID, name)
+		if err == nil {
+			switch {
+			case name == "rows":
+				var info os.FileInfo
+				if info, err = os.Stat(path); err == nil && !info.IsDir() {
+					err = fmt.Errorf("%v is not a directory: %w", path, os.ErrNotExist)
+				}
+			case randomAccess(name):
+				_, err = os.Stat(path)
+			default:
+				path, err = datafile.Find(path)
+			}
+		}
+		if err == nil {
+			return path, name, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", "", err
+		}
+	}
+	return "", "", fmt.Errorf("dataset %v has no attribute.json or rows file: %w", datasetID, os.ErrNotExist)
+}
+
+// randomAccess reports whether the attribute file of the given conventional
+// name is read at random rather than streamed.
+func randomAccess(name string) bool {
+	return name == "rows.parquet" || name == "rows.xlsx"
+}
+
+// readAttributes reads the attributes of the dataset with the given ID from
+// its attribute file.
+func readAttributes(datasetID string) ([]*attributeNode, error) {
+	path, name, err := attributeFile(datasetID)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	switch name {
+	case "attribute.json":
+		return readAttributeJSON(datasetID, path)
+	case "rows":
+		return readPartitions(datasetID, path)
+	}
+	attrs, err := readRows(datasetID, path, name, *sampleRows)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return profiledAttributes(datasetID, attrs), nil
+}
+
+// readRows profiles the attributes of a rows file of the dataset with the
+// given ID, whose conventional name is name, from its first maxRows rows.
+func readRows(datasetID, path, name string, maxRows int) ([]*datafile.Attribute, error) {
+	if randomAccess(name) {
+		return readRandomAccess(datasetID, path, name, maxRows)
 	}
+	return readStreamed(datasetID, path, name, maxRows)
+}
 
-	attributedataStmt, err := tx.Prepare(`
-	INSERT INTO attributedata (
-		attributename,
-		dataset_id,
-	)
-	VALUES (?, ?)
-	`)
+// readPartitions profiles the attributes of the partitioned dataset with the
+// given ID whose partitions are in the directory dir, from as many rows of
+// each of its files as make up -samplerows rows in total.
+func readPartitions(datasetID, dir string) ([]*attributeNode, error) {
+	parts, err := partition.List(dir)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer attributedataStmt.Close()
+	maxRows := (*sampleRows + len(parts) - 1) / len(parts)
+	profiles := make([][]*datafile.Attribute, len(parts))
+	for i, p := range parts {
+		if profiles[i], err = readRows(datasetID, p.Path, p.Name, maxRows); err != nil {
+			return nil, fmt.Errorf("%v: %w", p.Path, err)
+		}
+	}
+	return profiledAttributes(datasetID, partition.Attributes(parts, profiles)), nil
+}
 
-	vectorStmt, err := tx.Prepare(`
-	INSERT INTO attribute_vectors (dataset_id, attribute_name, emb) VALUES (?, ?, ?)`)
+// hashAttributeFile returns the SHA-256 hash in hex of the attribute file at
+// path, whose conventional name is name, or of its partitions if it is the
+// rows directory of a partitioned dataset.
+func hashAttributeFile(path, name string) (string, error) {
+	if name != "rows" {
+		return checksum.File(path)
+	}
+	parts, err := partition.List(path)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	defer vectorStmt.Close()
+	return partition.Hash(path, parts)
+}
 
-	files, err := ioutil.ReadDir(datasetsDir)
+// profiledAttributes returns the attributes of the dataset with the given ID
+// profiled from its rows.
+func profiledAttributes(datasetID string, attrs []*datafile.Attribute) []*attributeNode {
+	ms := make([]*attributeNode, len(attrs))
+	for i, a := range attrs {
+		ms[i] = &attributeNode{AttributeName: a.Name, DatasetID: datasetID, dir: datasetID, profile: a}
+	}
+	return ms
+}
+
+// readAttributeJSON reads the attributes of the dataset with the given ID from
+// its attribute.json file, returning a *schemaError if the file is invalid.
+func readAttributeJSON(datasetID, path string) ([]*attributeNode, error) {
+	file, err := datasetHooks.Open(datasetID, "attribute.json", path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	defer file.Close()
+	return parseAttributeJSON(file, datasetID, path)
+}
+
+// parseAttributeJSON reads the attributes of the dataset with the given ID from
+// the attribute.json contents read from r, returning a *schemaError for the
+// file at path if they are invalid.
+func parseAttributeJSON(r io.Reader, datasetID, path string) ([]*attributeNode, error) {
+	records, err := datafile.ReadAttributeJSON(r, datasetID)
+	var errs datafile.SchemaErrors
+	if errors.As(err, &errs) {
+		return nil, &schemaError{path, errs}
+	}
+	if err != nil {
+		return nil, err
+	}
+	ms := make([]*attributeNode, len(records))
+	for i, r := range records {
+		ms[i] = &attributeNode{AttributeName: r.AttributeName, DatasetID: r.DatasetID, dir: datasetID}
+	}
+	return ms, nil
+}
+
+// schemaError is the error of an invalid attribute.json file.
+type schemaError struct {
+	path string
+	errs datafile.SchemaErrors
+}
+
+func (e *schemaError) Error() string {
+	return fmt.Sprintf("invalid attribute file %v: %v", e.path, e.errs)
+}
+
+// validationReport collects the errors of the invalid attribute.json files
+// found by the workers.
+type validationReport struct {
+	mu    sync.Mutex
+	files []*schemaError
+}
+
+func (r *validationReport) add(e *schemaError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, e)
+}
+
+// numErrors returns the number of errors in the report.
+func (r *validationReport) numErrors() int {
+	n := 0
+	for _, e := range r.files {
+		n += len(e.errs)
+	}
+	return n
+}
+
+// write writes the errors of the report to the file at path, a line per
+// error of the form path:line:column: field: message, ordered by file.
+func (r *validationReport) write(path string) error {
+	sort.Slice(r.files, func(i, j int) bool { return r.files[i].path < r.files[j].path })
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range r.files {
+		for _, se := range e.errs {
+			fmt.Fprintf(w, "%v:%v\n", e.path, se)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readRandomAccess profiles the attributes of a Parquet or Excel file of the
+// dataset with the given ID, whose conventional name is name.
+func readRandomAccess(datasetID, path, name string, maxRows int) ([]*datafile.Attribute, error) {
+	file, err := datasetHooks.OpenRandom(datasetID, name, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if name == "rows.parquet" {
+		return datafile.ReadParquet(file, info.Size(), maxRows)
+	}
+	sheets, err := datafile.ReadXLSX(file, info.Size(), maxRows)
+	if err != nil {
+		return nil, err
+	}
+	return sheetAttributes(sheets), nil
+}
+
+// readStreamed profiles the attributes of a CSV or JSON file of the dataset
+// with the given ID, whose conventional name is name, decompressing it if it is
+// compressed.
+func readStreamed(datasetID, path, name string, maxRows int) ([]*datafile.Attribute, error) {
+	file, err := datasetHooks.Open(datasetID, name, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if name == "rows.csv" {
+		return datafile.ReadCSV(file, maxRows)
+	}
+	return datafile.ReadJSON(file, maxRows)
+}
+
+// sheetAttributes returns the attributes of the sheets of a workbook, named by
+// their sheet and column if more than one sheet has attributes.
+func sheetAttributes(sheets []*datafile.Sheet) []*datafile.Attribute {
+	var attrs []*datafile.Attribute
+	n := 0
+	for _, s := range sheets {
+		if len(s.Attributes) > 0 {
+			n++
+		}
+	}
+	for _, s := range sheets {
+		for _, a := range s.Attributes {
+			if n > 1 {
+				a.Name = s.Name + "." + a.Name
+			}
+			attrs = append(attrs, a)
+		}
+	}
+	return attrs
+}
+
+// job is a dataset whose attributes are to be read and embedded.
+type job struct {
+	datasetID string
+	// Hash of the dataset's attribute file or record, if ingestions are
+	// recorded.
+	hash string
+	// The dataset's record and its name, as in "stdin:12" for the record on
+	// line 12, if the datasets are read from standard input.
+	record *streamRecord
+	name   string
+}
+
+// read reads the attributes of the job's dataset from its record or its
+// attribute file.
+func (j *job) read() ([]*attributeNode, error) {
+	if j.record == nil {
+		return readAttributes(j.datasetID)
+	}
+	return j.record.attributes(j.name)
+}
+
+// streamRecord is a dataset record read from standard input with -stdin, a
+// JSON line holding the ID of the dataset and either its attributes, as in an
+// attribute.json file, or its rows, as in a rows.json file:
+//
+//	{"id": "abcd-1234", "attributes": [{"AttributeName": "school_name"}]}
+//	{"id": "efgh-5678", "rows": [{"name": "P.S. 1", "zip": "10001"}]}
+type streamRecord struct {
+	ID         string          `json:"id"`
+	Attributes json.RawMessage `json:"attributes"`
+	Rows       json.RawMessage `json:"rows"`
+}
+
+// attributes returns the attributes of the record named name, returning a
+// *schemaError if its attributes are invalid.
+func (r *streamRecord) attributes(name string) ([]*attributeNode, error) {
+	hasAttributes := len(r.Attributes) > 0 && string(r.Attributes) != "null"
+	hasRows := len(r.Rows) > 0 && string(r.Rows) != "null"
+	switch {
+	case hasAttributes && hasRows:
+		return nil, fmt.Errorf("%v: record has both attributes and rows", name)
+	case hasAttributes:
+		f, err := datasetHooks.Filter(r.ID, "attribute.json", bytes.NewReader(r.Attributes))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseAttributeJSON(f, r.ID, name)
+	case hasRows:
+		f, err := datasetHooks.Filter(r.ID, "rows.json", bytes.NewReader(r.Rows))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		attrs, err := datafile.ReadJSON(f, *sampleRows)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		return profiledAttributes(r.ID, attrs), nil
+	}
+	return nil, fmt.Errorf("%v: record has no attributes or rows", name)
+}
+
+// readStream sends the datasets of the records read from r to jobs, adding
+// them to p. Invalid records are recorded in fails under their IDs, or their
+// names if they have none. If ingestions are recorded, the datasets whose
+// records are unchanged since they were processed are skipped.
+func readStream(r io.Reader, jobs chan<- *job, previous map[string]ingestion, p *progress.Reporter, fails *failures.Recorder) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxRecordSize)
+	var records, unchanged int
+	for line := 1; sc.Scan(); line++ {
+		data := bytes.TrimSpace(sc.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		records++
+		p.Add(1)
+		name := fmt.Sprintf("stdin:%d", line)
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		var rec streamRecord
+		err := dec.Decode(&rec)
+		if err == nil && rec.ID == "" {
+			err = errors.New("record has no id")
+		}
+		if err != nil {
+			if rec.ID == "" {
+				fails.Fail(name, err)
+			} else {
+				fails.Fail(rec.ID, fmt.Errorf("%v: %v", name, err))
+			}
+			p.Error()
+			p.Done(1)
+			continue
+		}
+		j := &job{datasetID: rec.ID, record: &rec, name: name}
+		if previous != nil {
+			sum := sha256.Sum256(data)
+			j.hash = hex.EncodeToString(sum[:])
+			if in, ok := previous[rec.ID]; ok && in.status == statusDone && in.contentHash == j.hash {
+				unchanged++
+				p.Done(1)
+				continue
+			}
+		}
+		jobs <- j
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("reading standard input: %v", err)
+	}
+	log.Printf("read %d dataset records from standard input; %d are unchanged", records, unchanged)
+	return nil
+}
+
+// embedWorker reads and embeds the attributes of the datasets received from
+// jobs, in batches of up to embedBatchSize attributes, and sends the batches to
+// out. The datasets without attribute files or that cannot be read or
+// embedded are counted in p, and the latter recorded in fails, as well as in
+// report if their attributes are invalid.
+func embedWorker(a *attributeEmbedder, jobs <-chan *job, out chan<- *embeddedBatch, p *progress.Reporter, fails *failures.Recorder, report *validationReport) {
+	// Attributes whose vectors have not been created yet, by the name of the
+	// model of their dataset.
+	pending := make(map[string][]*attributeNode)
+	npending := 0
+	embedPending := func() {
+		for name, ms := range pending {
+			b, err := a.embed(name, ms)
+			if err != nil {
+				// The datasets of the batch are skipped.
+				failed := make(map[string]bool)
+				for _, m := range ms {
+					if !failed[m.DatasetID] {
+						failed[m.DatasetID] = true
+						fails.Fail(m.DatasetID, err)
+						p.Error()
+					}
+				}
+				p.Done(len(failed))
+				continue
+			}
+			out <- b
+		}
+		pending = make(map[string][]*attributeNode)
+		npending = 0
+	}
+
+	for j := range jobs {
+		datasetID := j.datasetID
+		ms, err := j.read()
+		if errors.Is(err, os.ErrNotExist) {
+			log.Print(err)
+			p.Error()
+			p.Done(1)
+			continue
+		}
+		if err != nil {
+			var serr *schemaError
+			if errors.As(err, &serr) {
+				report.add(serr)
+			}
+			fails.Fail(datasetID, err)
+			p.Error()
+			p.Done(1)
+			continue
+		}
+		if len(ms) == 0 {
+			log.Printf("dataset %v has no attributes", datasetID)
+			p.Done(1)
+			continue
+		}
+		name, err := a.model(ms[0].DatasetID)
+		if err != nil {
+			fails.Fail(datasetID, err)
+			p.Error()
+			p.Done(1)
+			continue
+		}
+		for _, m := range ms {
+			m.hash = j.hash
+		}
+		// The attributes of a dataset are embedded in the same batch.
+		pending[name] = append(pending[name], ms...)
+		if npending += len(ms); npending >= embedBatchSize {
+			embedPending()
+		}
 	}
+	embedPending()
+}
+
+// Statuses of the ingestion of attribute files.
+const (
+	statusProcessing = "processing"
+	statusDone       = "done"
+)
 
-	for _, f := range files {
-		datasetID := f.Name()
-		path := filepath.Join(datasetsDir, datasetID, "attribute.json")
+// ingestion is the recorded ingestion of the attribute file of a dataset.
+type ingestion struct {
+	contentHash, status string
+}
 
-		file, err := os.Open(path)
+// loadIngestions returns the recorded ingestions of the datasets' attribute
+// files by dataset ID, or nil if the database has no attribute_ingestion
+// table.
+func loadIngestions(db *sql.DB) (map[string]ingestion, error) {
+	if ok, err := database.HasTable(db, "attribute_ingestion"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT dataset_id, content_hash, status FROM attribute_ingestion`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ingestions := make(map[string]ingestion)
+	for rows.Next() {
+		var datasetID string
+		var in ingestion
+		if err := rows.Scan(&datasetID, &in.contentHash, &in.status); err != nil {
+			return nil, err
+		}
+		ingestions[datasetID] = in
+	}
+	return ingestions, rows.Err()
+}
+
+// planIngestion returns the IDs of the datasets whose attribute files must be
+// processed, those that are new, changed since they were processed, or were
+// left processing by an interrupted run, and the hashes of their files, which
+// it records with the processing status unless -dry-run is set.
+func planIngestion(db *sql.DB, ids []string, previous map[string]ingestion) ([]string, map[string]string, error) {
+	var planned []string
+	hashes := make(map[string]string)
+	var added, resumed int
+	for _, id := range ids {
+		var hash string
+		path, name, err := attributeFile(id)
+		if err == nil {
+			hash, err = hashAttributeFile(path, name)
+		}
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
 				log.Print(err)
 				continue
 			}
-			log.Fatalf("dataset %v: %v", datasetID, err)
+			return nil, nil, err
+		}
+		in, ok := previous[id]
+		if ok && in.status == statusDone && in.contentHash == hash {
+			continue
+		}
+		if !ok {
+			added++
+		} else if in.status == statusProcessing {
+			resumed++
+		}
+		planned = append(planned, id)
+		hashes[id] = hash
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	stmt, err := tx.Prepare(`
+	INSERT OR REPLACE INTO attribute_ingestion (dataset_id, content_hash, status) VALUES (?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	defer stmt.Close()
+	for _, id := range planned {
+		if _, err := stmt.Exec(id, hashes[id], statusProcessing); err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+	}
+	if err := finish(tx); err != nil {
+		return nil, nil, err
+	}
+	log.Printf("processing %d datasets: %d new, %d changed, and %d resumed; %d are unchanged or missing",
+		len(planned), added, len(planned)-added-resumed, resumed, len(ids)-len(planned))
+	return planned, hashes, nil
+}
+
+// changedDatasets returns the IDs of ids of the datasets to process (see
+// checksum.Tracker.Changed), recording in fails the datasets whose files
+// cannot be read.
+func changedDatasets(ids []string, fails *failures.Recorder) []string {
+	if checksums == nil {
+		return ids
+	}
+	var changed []string
+	for _, id := range ids {
+		ok, err := checksums.Changed(id)
+		if err != nil {
+			fails.Fail(id, err)
+		} else if ok {
+			changed = append(changed, id)
+		}
+	}
+	if checksum.ChangedOnly() {
+		log.Printf("%d datasets are new or changed; %d are unchanged or failed", len(changed), len(ids)-len(changed))
+	}
+	return changed
+}
+
+// finish commits tx, or rolls it back if -dry-run is set.
+func finish(tx *sql.Tx) error {
+	if *dryRun {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
+// attributeWriter writes embedded batches to the database, each in its own
+// transaction, so that an interrupted run keeps the batches it wrote.
+type attributeWriter struct {
+	db        *sql.DB
+	encoding  vec32.Encoding
+	policy    embedding.NoEmbPolicy
+	tokenizer *attributeembedding.Tokenizer
+	noEmb     embedding.NoEmbCounts
+	// Numbers of attributes and vectors written.
+	attributes, vectors int
+	// Whether ingestions are recorded, and the statements deleting the
+	// previous rows of a dataset if they are.
+	ingestions bool
+	deletes    []string
+	// Whether the profiles of attributes read from rows files are written.
+	profiles bool
+	// Datasets that cannot be written are recorded in fails and counted in
+	// p.
+	fails *failures.Recorder
+	p     *progress.Reporter
+}
+
+// write writes the attributes of the batch and their vectors, and records
+// their datasets as done if ingestions are recorded. The datasets are written
+// in savepoints of the batch's transaction, so that a dataset that cannot be
+// written is skipped, and recorded as failed, without the others, and the
+// transaction is done again if the database is busy.
+func (w *attributeWriter) write(b *embeddedBatch) error {
+	var attributedataStmt, vectorStmt *sql.Stmt
+	var versions *database.VersionRecorder
+	chunk := &database.Chunker{
+		DB: w.db,
+		Prepare: func(tx *sql.Tx) (err error) {
+			attributedataStmt, err = tx.Prepare(`
+			INSERT INTO attributedata (
+				attributename,
+				dataset_id
+			)
+			VALUES (?, ?)
+			`)
+			if err != nil {
+				return err
+			}
+			vectorStmt, err = tx.Prepare(`
+			INSERT INTO attribute_vectors (dataset_id, attribute_name, emb) VALUES (?, ?, ?)`)
+			if err != nil {
+				return err
+			}
+			versions, err = database.PrepareVersionRecorder(tx)
+			return err
+		},
+		Rollback:  *dryRun,
+		Committed: datasetHooks.Committed,
+	}
+
+	for start := 0; start < len(b.attributes); {
+		dir := b.attributes[start].dir
+		end := start + 1
+		for end < len(b.attributes) && b.attributes[end].dir == dir {
+			end++
 		}
-		var m attributeNode
-		if err := json.NewDecoder(file).Decode(&m); err != nil {
-			log.Fatalf("dataset %v: %v", datasetID, err)
+		// The write may be done again if the database is busy, after start
+		// has moved on.
+		first := start
+		var attributes, vectors int
+		err := chunk.Write(func(tx *sql.Tx) error {
+			var err error
+			attributes, vectors, err = w.writeDataset(tx, b, first, end, attributedataStmt, vectorStmt, versions)
+			if err != nil {
+				return err
+			}
+			return checksums.Store(tx, dir)
+		})
+		if err != nil {
+			w.fails.Fail(dir, err)
+			w.p.Error()
+		} else {
+			w.attributes += attributes
+			w.vectors += vectors
+			datasetHooks.Stored(dir)
 		}
-		file.Close()
+		start = end
+	}
+	return chunk.Close()
+}
 
-		_, err = attributedataStmt.Exec(
-			m.AttributeName,
-			m.DatasetID,
-		)
+// writeDataset writes the attributes b.attributes[start:end] of a dataset, and
+// returns the numbers of attributes and vectors written.
+func (w *attributeWriter) writeDataset(tx *sql.Tx, b *embeddedBatch, start, end int, attributedataStmt, vectorStmt *sql.Stmt, versions *database.VersionRecorder) (attributes, vectors int, err error) {
+	v := b.version
+	dir := b.attributes[start].dir
+	for _, query := range w.deletes {
+		if _, err := tx.Exec(query, dir); err != nil {
+			return 0, 0, err
+		}
+	}
+	for i := start; i < end; i++ {
+		m := b.attributes[i]
+		if _, err := attributedataStmt.Exec(m.AttributeName, m.DatasetID); err != nil {
+			return 0, 0, err
+		}
+		attributes++
+		if w.profiles && m.profile != nil {
+			sample, err := json.Marshal(m.profile.Sample)
+			if err != nil {
+				return 0, 0, err
+			}
+			_, err = tx.Exec(`
+			INSERT OR REPLACE INTO attribute_profiles (dataset_id, attribute_name, attribute_type, sample)
+			VALUES (?, ?, ?, ?)`, m.DatasetID, m.AttributeName, string(m.profile.Type), string(sample))
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		vec, err := w.policy.Apply(b.embs[i], b.errs[i], func(e embedding.Embedder) ([]float32, error) {
+			return w.tokenizer.Vector(e, m.AttributeName)
+		}, &w.noEmb)
 		if err != nil {
-			log.Fatalf("dataset %v: %v", datasetID, err)
+			return 0, 0, fmt.Errorf("attribute %q: %v", m.AttributeName, err)
+		}
+		if vec != nil {
+			_, err = vectorStmt.Exec(m.DatasetID, m.AttributeName, vec32.Encode(vec, w.encoding))
+			if err != nil {
+				return 0, 0, err
+			}
+			err = versions.Record("attribute_vectors", m.DatasetID, m.AttributeName, v.Model, v.Version, v.Dim)
+			if err != nil {
+				return 0, 0, err
+			}
+			vectors++
+		}
+	}
+	if w.ingestions {
+		_, err := tx.Exec(`
+		INSERT OR REPLACE INTO attribute_ingestion (dataset_id, content_hash, status) VALUES (?, ?, ?)`,
+			dir, b.attributes[start].hash, statusDone)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return attributes, vectors, nil
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("process_attribute", "datasets", *dryRun)
+	var err error
+	if datasets, err = config.DatasetLayout(); err != nil {
+		log.Fatal(err)
+	}
+	if *numWorkers < 1 {
+		log.Fatal("-workers must be positive")
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	embeddingConfig, err := config.Embedding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoding, err := config.VectorEncoding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	noEmbPolicy, err := config.NoEmbPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	categoryModels, err := config.CategoryModels()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if embeddingConfig, err = embeddingConfig.Resolve(); err != nil {
+		log.Fatal(err)
+	}
+	// The attributes of a dataset are embedded with the model of its
+	// metadata if there are category models.
+	var embedder embedding.Model
+	var router *embedding.Router
+	if categoryModels != nil {
+		router, err = embedding.OpenRouter(embeddingConfig, categoryModels)
+		embedder = router
+	} else {
+		embedder, err = embedding.Open(embeddingConfig)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer embedder.Close()
+	version := embeddingConfig.ModelVersion(embedder.Dim())
+
+	tokenizer, err := config.AttributeTokenizer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	a := &attributeEmbedder{tokenizer: tokenizer, embedder: embedder, version: version, router: router}
+	if router != nil {
+		// The models are read before the writes begin, so the workers
+		// do not read the database.
+		if a.models, err = datasetModels(db); err != nil {
+			log.Fatal(err)
 		}
+	}
 
-		emb, err := attributeVector(ft, &m)
-		if err != nil && err != attributeembedding.ErrNoEmb {
-			log.Fatalf("dataset %v: %v", datasetID, err)
+	previous, err := loadIngestions(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fails, err := failures.Start("process_attribute")
+	if err != nil {
+		log.Fatal(err)
+	}
+	datasetHooks = hooks.Start("process_attribute", fails, *dryRun)
+	// The datasets are read from standard input as the workers process
+	// them, or listed before they begin.
+	var ids []string
+	var hashes map[string]string
+	if *stdin && checksum.ChangedOnly() {
+		log.Fatal("-changed-only does not apply to -stdin")
+	}
+	if !*stdin {
+		if ids, err = datasets.IDs(); err != nil {
+			log.Fatal(err)
 		}
-		_, err = vectorStmt.Exec(m.AttributeName, vec32.Bytes(emb))
+		listed := len(ids)
+		checksums, err = checksum.Start(db, datasets, "process_attribute",
+			"attribute.json", "rows.csv", "rows.parquet", "rows.xlsx", "rows.jsonl", "rows.json", "rows")
 		if err != nil {
-			log.Fatalf("dataset %v: %v", datasetID, err)
+			log.Fatal(err)
+		}
+		ids = changedDatasets(ids, fails)
+		if previous != nil {
+			if ids, hashes, err = planIngestion(db, ids, previous); err != nil {
+				log.Fatal(err)
+			}
+		}
+		summary.Skipped = listed - len(ids)
+	}
+	jobs := make(chan *job, *numWorkers)
+	out := make(chan *embeddedBatch, *numWorkers)
+	var wg sync.WaitGroup
+	report := &validationReport{}
+	p, err := progress.Start("datasets", len(ids))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i := 0; i < *numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			embedWorker(a, jobs, out, p, fails, report)
+		}()
+	}
+	go func() {
+		if *stdin {
+			if err := readStream(os.Stdin, jobs, previous, p, fails); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			for _, id := range ids {
+				jobs <- &job{datasetID: id, hash: hashes[id]}
+			}
 		}
+		close(jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	w := &attributeWriter{
+		db:         db,
+		encoding:   encoding,
+		policy:     noEmbPolicy,
+		tokenizer:  tokenizer,
+		ingestions: previous != nil,
+		fails:      fails,
+		p:          p,
+	}
+	if w.profiles, err = database.HasTable(db, "attribute_profiles"); err != nil {
+		log.Fatal(err)
+	}
+	if previous != nil || checksums != nil {
+		// The previous rows of the datasets processed again are deleted.
+		w.deletes = []string{
+			`DELETE FROM attributedata WHERE dataset_id = ?`,
+			`DELETE FROM attribute_vectors WHERE dataset_id = ?`,
+		}
+		if ok, err := database.HasTable(db, "vector_versions"); err != nil {
+			log.Fatal(err)
+		} else if ok {
+			w.deletes = append(w.deletes,
+				`DELETE FROM vector_versions WHERE vectors = 'attribute_vectors' AND dataset_id = ?`)
+		}
+		if w.profiles {
+			w.deletes = append(w.deletes, `DELETE FROM attribute_profiles WHERE dataset_id = ?`)
+		}
+	}
+	// The batches are written by this goroutine only.
+	for b := range out {
+		if err := w.write(b); err != nil {
+			log.Fatal(err)
+		}
+		p.Done(b.datasets())
+	}
+	rep := p.Stop()
+	if *dryRun {
+		log.Printf("dry run: would store %d attributes and %d vectors", w.attributes, w.vectors)
+	} else {
+		log.Printf("stored %d attributes and %d vectors", w.attributes, w.vectors)
+	}
+	log.Println("attribute names without words to embed:", &w.noEmb)
+	if len(report.files) > 0 {
+		log.Printf("%d attribute.json files are invalid, with %d errors", len(report.files), report.numErrors())
+	}
+	if *reportPath != "" {
+		if err := report.write(*reportPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := fails.Finish(db, *dryRun); err != nil {
+		log.Fatal(err)
+	}
+	if stats := embedding.CacheStatsOf(embedder); stats != nil {
+		log.Println("embedding cache:", stats)
+	}
+	summary.Embeddings(w.attributes, &w.noEmb)
+	summary.Count("attributes", w.attributes)
+	summary.Count("vectors", w.vectors)
+	if err := summary.Finish(db, rep, fails); err != nil {
+		log.Fatal(err)
 	}
-	tx.Commit()
 }
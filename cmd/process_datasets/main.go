@@ -0,0 +1,241 @@
+// Command process_datasets runs the ingestion pipeline of the Open Data Link
+// database: it runs the ingestion commands in the order in which they depend
+// on each other's results,
+//
+//	sketch_columns        column sketches, types, and profiles (-sketch)
+//	process_metadata      metadata and metadata vectors (-metadata)
+//	process_attribute     attributes and attribute vectors (-attributes)
+//	process_values        column value vectors (-values)
+//	correlation_sketches  sketches of correlated columns (-correlations)
+//
+// each over all the datasets, since a stage may use the results of the
+// previous ones for any dataset, e.g. process_attribute the models of the
+// metadata vectors and correlation_sketches the column types of all datasets.
+// A stage is skipped if it is disabled by its flag, or, unless the flag is set
+// explicitly, if the database lacks the tables it writes. The pipeline stops
+// at the first stage that fails.
+//
+// The stage commands are looked for next to the executable of process_datasets
+// and then in PATH, so they are best installed together:
+//
+//	go install ./cmd/...
+//
+// The stages inherit the shared settings (see config.ParseFlags), -dry-run,
+// which skips correlation_sketches, and the -on-error, -progress, -pre-hook,
+// -post-hook, and -changed-only flags, the latter for the stages that have
+// them (all but process_values, which reads no dataset files). The
+// -progress-json, -errors-file, and -summary-json files of a stage are named
+// after it, e.g. errors.process_values.jsonl for -errors-file errors.jsonl.
+// Other flags are passed to a stage with -args, as in
+// -args 'process_attribute -samplerows 500'.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	// The checksum, failures, hooks, and progress flags are passed to the
+	// stages.
+	_ "github.com/DataIntelligenceCrew/OpenDataLink/internal/checksum"
+	_ "github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	_ "github.com/DataIntelligenceCrew/OpenDataLink/internal/hooks"
+	_ "github.com/DataIntelligenceCrew/OpenDataLink/internal/progress"
+	_ "github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var dryRun = flag.Bool("dry-run", false, "run the stages that support it without writing to the database")
+
+// stage is a command of the pipeline.
+type stage struct {
+	command string
+	// Name of the flag enabling the stage, and its usage.
+	flag, usage string
+	// Tables the stage writes to, which the database must have.
+	tables []string
+	// Whether the command has a -dry-run flag, the progress flags, the hooks
+	// flags, and the checksum flags.
+	dryRun, progress, hooks, checksums bool
+
+	enabled *bool
+	// Flags passed to the stage with -args.
+	args []string
+}
+
+var stages = []*stage{
+	{command: "sketch_columns", flag: "sketch", usage: "sketch the columns of the datasets", tables: []string{"column_sketches"}, dryRun: true, progress: true, hooks: true, checksums: true},
+	{command: "process_metadata", flag: "metadata", usage: "store and embed the metadata of the datasets", tables: []string{"metadata", "metadata_vectors"}, dryRun: true, progress: true, hooks: true, checksums: true},
+	{command: "process_attribute", flag: "attributes", usage: "store and embed the attributes of the datasets", tables: []string{"attribute_vectors"}, dryRun: true, progress: true, hooks: true, checksums: true},
+	{command: "process_values", flag: "values", usage: "embed the sampled values of the columns", tables: []string{"value_vectors", "column_sketches"}, dryRun: true, progress: true},
+	{command: "correlation_sketches", flag: "correlations", usage: "sketch the pairs of key and numeric columns of the datasets", tables: []string{"correlation_sketches", "column_types"}, hooks: true, checksums: true},
+}
+
+// stageArgs is the value of the -args flag, which may be repeated.
+type stageArgs struct{}
+
+func (stageArgs) String() string { return "" }
+
+func (stageArgs) Set(v string) error {
+	fields := strings.Fields(v)
+	if len(fields) == 0 {
+		return fmt.Errorf("no stage")
+	}
+	for _, s := range stages {
+		if s.command == fields[0] {
+			s.args = append(s.args, fields[1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown stage %q", fields[0])
+}
+
+// Flags of the progress, failures, hooks, and checksum packages passed to the
+// stages that have them, by package, and whether their values are files named
+// after the stage.
+var forwardedFlags = []struct {
+	name, pkg string
+	file      bool
+}{
+	{"progress", "progress", false},
+	{"progress-json", "progress", true},
+	{"on-error", "failures", false},
+	{"errors-file", "failures", true},
+	{"pre-hook", "hooks", false},
+	{"post-hook", "hooks", false},
+	{"changed-only", "checksum", false},
+	{"summary-json", "runsummary", true},
+}
+
+// hasFlags reports whether the stage's command has the flags of the package.
+func (s *stage) hasFlags(pkg string) bool {
+	switch pkg {
+	case "progress":
+		return s.progress
+	case "hooks":
+		return s.hooks
+	case "checksum":
+		return s.checksums
+	}
+	return true
+}
+
+// stageFile returns the name of the file of a stage for the file named name,
+// with the stage's command inserted before its extension.
+func stageFile(name, command string) string {
+	if name == "-" {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + command + ext
+}
+
+// commandPath returns the path of the executable of a stage's command.
+func commandPath(command string) (string, error) {
+	if exe, err := os.Executable(); err == nil {
+		path := filepath.Join(filepath.Dir(exe), command)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return "", fmt.Errorf("%v (install the commands with go install ./cmd/...)", err)
+	}
+	return path, nil
+}
+
+// missingTable returns the first of the tables that the database lacks, or "".
+func missingTable(db *sql.DB, tables []string) (string, error) {
+	for _, table := range tables {
+		if ok, err := database.HasTable(db, table); err != nil || !ok {
+			return table, err
+		}
+	}
+	return "", nil
+}
+
+func main() {
+	for _, s := range stages {
+		s.enabled = flag.Bool(s.flag, true, s.usage)
+	}
+	flag.Var(stageArgs{}, "args", "`stage flags...` to pass to a stage, such as 'process_attribute -samplerows 500' (may be repeated)")
+	config.ParseFlags()
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	var run []*stage
+	for _, s := range stages {
+		if !*s.enabled {
+			continue
+		}
+		if *dryRun && !s.dryRun {
+			log.Printf("skipping %v, which has no dry run", s.command)
+			continue
+		}
+		if !explicit[s.flag] {
+			table, err := missingTable(db, s.tables)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if table != "" {
+				log.Printf("skipping %v: no %v table (see sql/create_%[2]v_table.sql)", s.command, table)
+				continue
+			}
+		}
+		run = append(run, s)
+	}
+	// The stages are looked up before any is run, so that a missing
+	// command does not stop the pipeline halfway.
+	paths := make([]string, len(run))
+	for i, s := range run {
+		if paths[i], err = commandPath(s.command); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	for i, s := range run {
+		var args []string
+		for _, f := range forwardedFlags {
+			if !explicit[f.name] || !s.hasFlags(f.pkg) {
+				continue
+			}
+			v := flag.Lookup(f.name).Value.String()
+			if f.file && v != "" {
+				v = stageFile(v, s.command)
+			}
+			args = append(args, "-"+f.name+"="+v)
+		}
+		if *dryRun {
+			args = append(args, "-dry-run")
+		}
+		args = append(args, s.args...)
+
+		log.Printf("running %v %v", s.command, strings.Join(args, " "))
+		stageStart := time.Now()
+		cmd := exec.Command(paths[i], args...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Fatalf("%v failed: %v", s.command, err)
+		}
+		log.Printf("%v finished in %v", s.command, time.Since(stageStart).Round(time.Millisecond))
+	}
+	log.Printf("ran %d stages in %v", len(run), time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,38 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzParseMetadata(f *testing.F) {
+	f.Add(`{"resource": {"name": "Trees", "id": "abcd-1234", "description": "Street trees",
+		"attribution": "Parks", "contact_email": "parks@example.org",
+		"updatedAt": "2020-01-01T00:00:00.000Z"},
+		"classification": {"categories": ["Environment"], "tags": ["trees"],
+		"domain_category": "Environment", "domain_tags": ["Trees", "parks"]},
+		"permalink": "https://data.example.org/d/abcd-1234"}`)
+	f.Add(`{"resource": {"id": "abcd-1234"}}`)
+	f.Add(`{"resource": null, "classification": null}`)
+	f.Add(`{}`)
+	f.Fuzz(func(t *testing.T, data string) {
+		m, err := parseMetadata(strings.NewReader(data))
+		if err != nil {
+			return
+		}
+		meta := m.toDB()
+		if meta.DatasetID == "" {
+			t.Error("parsed metadata has no dataset ID")
+		}
+		seen := make(map[string]bool)
+		for _, tag := range meta.Tags {
+			if seen[strings.ToLower(tag)] {
+				t.Errorf("duplicate tag %q in %q", tag, meta.Tags)
+			}
+			seen[strings.ToLower(tag)] = true
+		}
+	})
+}
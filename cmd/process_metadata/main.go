@@ -1,25 +1,66 @@
 // Command process_metadata creates metadata embedding vectors and stores the
 // metadata and the vectors in the Open Data Link database.
+//
+// The datasets whose metadata cannot be read or stored are skipped, and their
+// failures reported (see package failures). The metadata of a dataset whose
+// vector cannot be stored is kept without it.
+//
+// If the database has a dataset_checksums table, the checksums of the
+// metadata files are recorded, a dataset processed again replaces its
+// previous metadata and vector, and with -changed-only only the datasets
+// whose metadata files changed since they were last processed are embedded
+// again (see package checksum). The document frequencies of the idf
+// weighting are still those of all the datasets; -changed-only does not
+// support the sif weighting, whose common component is that of all the
+// vectors.
+//
+// The metadata and vectors are committed in chunks, retried while the
+// database is locked by another connection, such as a running server (see
+// database.Chunker). With -dry-run, the datasets are read, embedded, and
+// written in transactions that are rolled back, so that they are validated,
+// and what would be stored reported, without changing the database.
 package main
 
 import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"flag"
+	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/checksum"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/hooks"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/layout"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/progress"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
-	"github.com/ekzhu/go-fasttext"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-const datasetsDir = "datasets"
+// Layout of the datasets (see config.DatasetLayout), set by main.
+var datasets *layout.Layout
+
+// Ingestion hooks (see package hooks), set by main.
+var datasetHooks *hooks.Hooks
+
+// Checksums of the dataset files (see package checksum), set by main.
+var checksums *checksum.Tracker
+
+var dryRun = flag.Bool("dry-run", false, "process the datasets without writing to the database, reporting what would be stored")
+
+// Number of datasets embedded at once.
+const embedBatchSize = 256
+
+// Number of writes of metadata and vectors committed together.
+const writesPerCommit = 500
 
 type metadata struct {
 	Resource *struct {
@@ -30,13 +71,30 @@ type metadata struct {
 		ContactEmail string `json:"contact_email"`
 		UpdatedAt    string
 	}
-	Classification *struct {
-		Categories     []string
-		Tags           []string
-		DomainCategory string   `json:"domain_category"`
-		DomainTags     []string `json:"domain_tags"`
+	Classification *classification
+	Permalink      string
+}
+
+type classification struct {
+	Categories     []string
+	Tags           []string
+	DomainCategory string   `json:"domain_category"`
+	DomainTags     []string `json:"domain_tags"`
+}
+
+// parseMetadata decodes a Socrata metadata.json file.
+func parseMetadata(r io.Reader) (*metadata, error) {
+	var m metadata
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	if m.Resource == nil || m.Resource.ID == "" {
+		return nil, errors.New("metadata has no resource ID")
+	}
+	if m.Classification == nil {
+		m.Classification = &classification{}
 	}
-	Permalink string
+	return &m, nil
 }
 
 func (m *metadata) categories() []string {
@@ -66,104 +124,422 @@ func removeDuplicates(s []string) []string {
 	return s[:i]
 }
 
-func metadataVector(ft *fasttext.FastText, m *metadata) ([]float32, error) {
-	return wordemb.Vector(ft, []string{
-		m.Resource.Name,
-		m.Resource.Description,
-		m.Resource.Attribution,
-		strings.Join(m.Classification.Categories, " "),
-		strings.Join(m.Classification.Tags, " "),
-		m.Classification.DomainCategory,
-		strings.Join(m.Classification.DomainTags, " "),
-	})
+// toDB converts m to a row of the metadata table.
+func (m *metadata) toDB() *database.Metadata {
+	return &database.Metadata{
+		DatasetID:    m.Resource.ID,
+		Name:         m.Resource.Name,
+		Description:  m.Resource.Description,
+		Attribution:  m.Resource.Attribution,
+		ContactEmail: m.Resource.ContactEmail,
+		UpdatedAt:    m.Resource.UpdatedAt,
+		Categories:   m.categories(),
+		Tags:         m.tags(),
+		Permalink:    m.Permalink,
+	}
+}
+
+// computeIDF returns the document frequencies of the words of the datasets'
+// metadata texts, normalized by n, for the idf and sif embedding weightings.
+// Datasets whose metadata cannot be read are skipped; they are reported when
+// they are processed.
+func computeIDF(ids []string, n *embedding.Normalizer) *embedding.IDF {
+	idf := embedding.NewIDF()
+	for _, id := range ids {
+		path, err := datasets.Path(id, "metadata.json")
+		if err != nil {
+			continue
+		}
+		file, err := datasetHooks.Open(id, "metadata.json", path)
+		if err != nil {
+			continue
+		}
+		m, err := parseMetadata(file)
+		file.Close()
+		if err != nil {
+			continue
+		}
+		idf.AddDocument(n.Normalize(wordemb.MetadataText(m.toDB())))
+	}
+	return idf
+}
+
+// embedMetadata embeds the metadata texts of the datasets with e or, if router
+// is not nil, with the model of each dataset's categories, whose names it
+// returns.
+func embedMetadata(e embedding.Embedder, router *embedding.Router, datasets []*database.Metadata) (embs [][]float32, errs []error, models []string, err error) {
+	texts := make([]string, len(datasets))
+	for i, meta := range datasets {
+		// The embedding is created from the same fields the server uses
+		// when metadata is edited, so edited and ingested vectors are
+		// comparable.
+		texts[i] = wordemb.MetadataText(meta)
+	}
+	if router == nil {
+		embs, errs, err = embedding.EmbedAll(e, texts)
+		return embs, errs, nil, err
+	}
+
+	models = make([]string, len(datasets))
+	groups := make(map[string][]int)
+	for i, meta := range datasets {
+		models[i], _ = router.Route(meta.Categories)
+		groups[models[i]] = append(groups[models[i]], i)
+	}
+	embs = make([][]float32, len(datasets))
+	errs = make([]error, len(datasets))
+	for name, group := range groups {
+		groupTexts := make([]string, len(group))
+		for j, i := range group {
+			groupTexts[j] = texts[i]
+		}
+		vecs, vecErrs, err := embedding.EmbedAll(router.Model(name), groupTexts)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for j, i := range group {
+			embs[i], errs[i] = vecs[j], vecErrs[j]
+		}
+	}
+	return embs, errs, models, nil
 }
 
 func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("process_metadata", "datasets", *dryRun)
+	var err error
+	if datasets, err = config.DatasetLayout(); err != nil {
+		log.Fatal(err)
+	}
 	db, err := sql.Open("sqlite3", config.DatabasePath())
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	ft := fasttext.NewFastText(config.FasttextPath())
-	defer ft.Close()
-
-	tx, err := db.Begin()
+	ids, err := datasets.IDs()
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	metadataStmt, err := tx.Prepare(`
-	INSERT INTO metadata (
-		dataset_id,
-		name,
-		description,
-		attribution,
-		contact_email,
-		updated_at,
-		categories,
-		tags,
-		permalink
-	)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
+	fails, err := failures.Start("process_metadata")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer metadataStmt.Close()
+	datasetHooks = hooks.Start("process_metadata", fails, *dryRun)
+	if checksums, err = checksum.Start(db, datasets, "process_metadata", "metadata.json"); err != nil {
+		log.Fatal(err)
+	}
 
-	vectorStmt, err := tx.Prepare(`
-	INSERT INTO metadata_vectors (dataset_id, emb) VALUES (?, ?)`)
+	embeddingConfig, err := config.Embedding()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer vectorStmt.Close()
-
-	files, err := ioutil.ReadDir(datasetsDir)
+	encoding, err := config.VectorEncoding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	noEmbPolicy, err := config.NoEmbPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	categoryModels, err := config.CategoryModels()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sif := embeddingConfig.Weighting == "sif"
+	if sif && categoryModels != nil {
+		log.Fatal("category models do not support sif weighting")
+	}
+	if sif && checksum.ChangedOnly() {
+		log.Fatal("-changed-only does not support sif weighting")
+	}
+	if embeddingConfig.Weighting != "" {
+		// The vectors are weighted by the document frequencies of this
+		// corpus, computed in a first pass over the datasets.
+		normalizer, err := embedding.NewNormalizer(&embeddingConfig.Normalize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		embeddingConfig.IDF = computeIDF(ids, normalizer)
+	}
+	var embedder embedding.Model
+	var router *embedding.Router
+	if categoryModels != nil {
+		router, err = embedding.OpenRouter(embeddingConfig, categoryModels)
+		embedder = router
+	} else {
+		embedder, err = embedding.Open(embeddingConfig)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer embedder.Close()
+	// Version of the vectors of the default model.
+	version := embeddingConfig.ModelVersion(embedder.Dim())
 
-	for _, f := range files {
-		datasetID := f.Name()
-		path := filepath.Join(datasetsDir, datasetID, "metadata.json")
+	// The datasets processed again replace their previous metadata if the
+	// checksums of their files are recorded.
+	insert := "INSERT"
+	if checksums != nil {
+		insert = "INSERT OR REPLACE"
+	}
+	// The metadata and vectors are committed in chunks, so that a running
+	// server is not locked out of the database, with their statements
+	// prepared for each chunk. The model of each vector is recorded if there
+	// are category models.
+	var metadataStmt, vectorStmt, deleteVectorStmt, modelStmt *sql.Stmt
+	var versions *database.VersionRecorder
+	chunk := &database.Chunker{
+		DB:   db,
+		Size: writesPerCommit,
+		Prepare: func(tx *sql.Tx) (err error) {
+			metadataStmt, err = tx.Prepare(insert + ` INTO metadata (
+				dataset_id,
+				name,
+				description,
+				attribution,
+				contact_email,
+				updated_at,
+				categories,
+				tags,
+				permalink
+			)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`)
+			if err != nil {
+				return err
+			}
+			vectorStmt, err = tx.Prepare(`
+			INSERT INTO metadata_vectors (dataset_id, emb) VALUES (?, ?)`)
+			if err != nil {
+				return err
+			}
+			if checksums != nil {
+				deleteVectorStmt, err = tx.Prepare(`DELETE FROM metadata_vectors WHERE dataset_id = ?`)
+				if err != nil {
+					return err
+				}
+			}
+			if router != nil {
+				modelStmt, err = tx.Prepare(`
+				INSERT OR REPLACE INTO vector_models (dataset_id, model, dim) VALUES (?, ?, ?)`)
+				if err != nil {
+					return err
+				}
+			}
+			versions, err = database.PrepareVersionRecorder(tx)
+			return err
+		},
+		Rollback:  *dryRun,
+		Committed: datasetHooks.Committed,
+	}
+	// write writes with chunk, committing the chunk if it holds
+	// writesPerCommit writes.
+	write := func(f func(tx *sql.Tx) error) error {
+		err := chunk.Write(f)
+		if cerr := chunk.Done(); cerr != nil {
+			log.Fatal(cerr)
+		}
+		return err
+	}
+	if embeddingConfig.IDF != nil {
+		if err := write(embeddingConfig.IDF.Save); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Datasets whose vectors have not been created yet. They are embedded in
+	// batches, which is faster with embedding backends that support it.
+	var pending []*database.Metadata
+	// With sif weighting, the vectors are written once the common component
+	// of all of them is removed.
+	var sifIDs []string
+	var sifVecs [][]float32
+	var noEmb embedding.NoEmbCounts
+	// Numbers of datasets and vectors written.
+	var stored, vectors int
+	p, err := progress.Start("datasets", len(ids))
+	if err != nil {
+		log.Fatal(err)
+	}
+	embedPending := func() {
+		embs, errs, models, err := embedMetadata(embedder, router, pending)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for i, meta := range pending {
+			// The writes of a chunk may be done again (see
+			// database.Chunker).
+			i, meta := i, meta
+			vec, err := noEmbPolicy.Apply(embs[i], errs[i], func(e embedding.Embedder) ([]float32, error) {
+				return wordemb.MetadataVector(e, meta)
+			}, &noEmb)
+			if err != nil {
+				fails.Fail(meta.DatasetID, err)
+				p.Error()
+				continue
+			}
+			if vec == nil {
+				continue
+			}
+			// The vector of a dataset is written in a savepoint, so that
+			// the dataset's metadata is kept without it if it cannot be.
+			err = write(func(*sql.Tx) error {
+				if modelStmt != nil {
+					if _, err := modelStmt.Exec(meta.DatasetID, models[i], len(vec)); err != nil {
+						return err
+					}
+				}
+				if sif {
+					return nil
+				}
+				if _, err := vectorStmt.Exec(meta.DatasetID, vec32.Encode(vec, encoding)); err != nil {
+					return err
+				}
+				v := version
+				if router != nil {
+					v = router.Version(models[i])
+				}
+				return versions.Record("metadata_vectors", meta.DatasetID, "", v.Model, v.Version, v.Dim)
+			})
+			if err != nil {
+				fails.Fail(meta.DatasetID, err)
+				p.Error()
+				continue
+			}
+			if sif {
+				sifIDs = append(sifIDs, meta.DatasetID)
+				sifVecs = append(sifVecs, vec)
+				continue
+			}
+			vectors++
+		}
+		p.Done(len(pending))
+		pending = pending[:0]
+	}
 
-		file, err := os.Open(path)
+	for _, datasetID := range ids {
+		datasetID := datasetID
+		if changed, err := checksums.Changed(datasetID); err != nil || !changed {
+			if err != nil {
+				fails.Fail(datasetID, err)
+				p.Error()
+			}
+			p.Done(1)
+			continue
+		}
+		var file io.ReadCloser
+		path, err := datasets.Path(datasetID, "metadata.json")
+		if err == nil {
+			file, err = datasetHooks.Open(datasetID, "metadata.json", path)
+		}
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
 				log.Print(err)
+				p.Error()
+				p.Done(1)
 				continue
 			}
-			log.Fatalf("dataset %v: %v", datasetID, err)
-		}
-		var m metadata
-		if err := json.NewDecoder(file).Decode(&m); err != nil {
-			log.Fatalf("dataset %v: %v", datasetID, err)
+			fails.Fail(datasetID, err)
+			p.Error()
+			p.Done(1)
+			continue
 		}
+		m, err := parseMetadata(file)
 		file.Close()
+		if err != nil {
+			fails.Fail(datasetID, err)
+			p.Error()
+			p.Done(1)
+			continue
+		}
 
-		_, err = metadataStmt.Exec(
-			m.Resource.ID,
-			m.Resource.Name,
-			m.Resource.Description,
-			m.Resource.Attribution,
-			m.Resource.ContactEmail,
-			m.Resource.UpdatedAt,
-			strings.Join(m.categories(), ","),
-			strings.Join(m.tags(), ","),
-			m.Permalink)
+		meta := m.toDB()
+		err = write(func(tx *sql.Tx) error {
+			_, err := metadataStmt.Exec(
+				meta.DatasetID,
+				meta.Name,
+				meta.Description,
+				meta.Attribution,
+				meta.ContactEmail,
+				meta.UpdatedAt,
+				strings.Join(meta.Categories, ","),
+				strings.Join(meta.Tags, ","),
+				meta.Permalink)
+			if err != nil {
+				return err
+			}
+			if deleteVectorStmt != nil {
+				if _, err := deleteVectorStmt.Exec(meta.DatasetID); err != nil {
+					return err
+				}
+			}
+			return checksums.Store(tx, datasetID)
+		})
 		if err != nil {
-			log.Fatalf("dataset %v: %v", datasetID, err)
+			fails.Fail(datasetID, err)
+			p.Error()
+			p.Done(1)
+			continue
 		}
+		stored++
+		datasetHooks.Stored(datasetID)
 
-		emb, err := metadataVector(ft, &m)
-		if err != nil && err != wordemb.ErrNoEmb {
-			log.Fatalf("dataset %v: %v", datasetID, err)
+		pending = append(pending, meta)
+		if len(pending) == embedBatchSize {
+			embedPending()
 		}
-		_, err = vectorStmt.Exec(m.Resource.ID, vec32.Bytes(emb))
+	}
+	embedPending()
+	if sif {
+		component := embedding.FirstComponent(sifVecs, embedder.Dim())
+		err := write(func(tx *sql.Tx) error { return embedding.SaveComponent(tx, component) })
 		if err != nil {
-			log.Fatalf("dataset %v: %v", datasetID, err)
+			log.Fatal(err)
 		}
+		// The vectors are those of the model removing the component.
+		embeddingConfig.Component = component
+		version = embeddingConfig.ModelVersion(embedder.Dim())
+		for i, vec := range sifVecs {
+			i, vec := i, vec
+			embedding.RemoveComponent(vec, component)
+			vec32.Normalize(vec)
+			err := write(func(*sql.Tx) error {
+				if _, err := vectorStmt.Exec(sifIDs[i], vec32.Encode(vec, encoding)); err != nil {
+					return err
+				}
+				return versions.Record("metadata_vectors", sifIDs[i], "", version.Model, version.Version, version.Dim)
+			})
+			if err != nil {
+				fails.Fail(sifIDs[i], err)
+				p.Error()
+				continue
+			}
+			vectors++
+		}
+	}
+	if err := chunk.Close(); err != nil {
+		log.Fatal(err)
+	}
+	rep := p.Stop()
+	if *dryRun {
+		log.Printf("dry run: would store the metadata of %d datasets and %d vectors", stored, vectors)
+	} else {
+		log.Printf("stored the metadata of %d datasets and %d vectors", stored, vectors)
+	}
+	log.Println("datasets without metadata embedding:", &noEmb)
+	if err := fails.Finish(db, *dryRun); err != nil {
+		log.Fatal(err)
+	}
+	if stats := embedding.CacheStatsOf(embedder); stats != nil {
+		log.Println("embedding cache:", stats)
+	}
+	// The metadata of every dataset stored is embedded.
+	summary.Embeddings(stored, &noEmb)
+	summary.Skipped = checksums.Unchanged()
+	summary.Count("metadata", stored)
+	summary.Count("vectors", vectors)
+	if err := summary.Finish(db, rep, fails); err != nil {
+		log.Fatal(err)
 	}
-	tx.Commit()
 }
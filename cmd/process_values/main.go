@@ -0,0 +1,199 @@
+// Command process_values creates column value embedding vectors from the sampled
+// values of the column sketches and stores them in the Open Data Link database.
+//
+// The columns whose vectors cannot be created or stored are skipped, and the
+// failures reported by dataset (see package failures).
+//
+// The vectors are committed in chunks of columns, retried while the database
+// is locked by another connection, such as a running server (see
+// database.Chunker). With -dry-run, the vectors are created and written in
+// transactions that are rolled back, reporting how many would be stored
+// without changing the database.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/progress"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/valueembedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Number of columns whose vectors are committed together.
+const columnsPerCommit = 1000
+
+var dryRun = flag.Bool("dry-run", false, "create the vectors without writing them to the database, reporting how many would be stored")
+
+type column struct {
+	columnID   string
+	datasetID  string
+	columnName string
+	sample     []string
+}
+
+// readColumns reads the column sketches, before the value vectors are written.
+func readColumns(db *sql.DB) ([]*column, error) {
+	rows, err := db.Query(`
+	SELECT column_id, dataset_id, column_name, sample
+	FROM column_sketches`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []*column
+	for rows.Next() {
+		var c column
+		var sample []byte
+		if err := rows.Scan(&c.columnID, &c.datasetID, &c.columnName, &sample); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(sample, &c.sample); err != nil {
+			return nil, err
+		}
+		cols = append(cols, &c)
+	}
+	return cols, rows.Err()
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("process_values", "columns", *dryRun)
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	embeddingConfig, err := config.Embedding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoding, err := config.VectorEncoding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	noEmbPolicy, err := config.NoEmbPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if embeddingConfig, err = embeddingConfig.Resolve(); err != nil {
+		log.Fatal(err)
+	}
+	embedder, err := embedding.Open(embeddingConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer embedder.Close()
+	version := embeddingConfig.ModelVersion(embedder.Dim())
+
+	params, err := config.IndexParameters()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := embedding.CheckDim(embedder, params.EmbeddingDim); err != nil {
+		log.Fatal(err)
+	}
+
+	cols, err := readColumns(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The vectors are committed in chunks of columns, so that a running
+	// server is not locked out of the database.
+	var vectorStmt *sql.Stmt
+	var versions *database.VersionRecorder
+	chunk := &database.Chunker{
+		DB:   db,
+		Size: columnsPerCommit,
+		Prepare: func(tx *sql.Tx) (err error) {
+			vectorStmt, err = tx.Prepare(`
+			INSERT OR REPLACE INTO value_vectors (column_id, dataset_id, column_name, emb)
+			VALUES (?, ?, ?, ?)
+			`)
+			if err != nil {
+				return err
+			}
+			versions, err = database.PrepareVersionRecorder(tx)
+			return err
+		},
+		Rollback: *dryRun,
+	}
+
+	var noEmb embedding.NoEmbCounts
+	stored := 0
+	fails, err := failures.Start("process_values")
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := progress.Start("columns", len(cols))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, c := range cols {
+		// The writes of a chunk may be done again (see database.Chunker).
+		c := c
+		p.Done(1)
+		emb, err := valueembedding.Vector(embedder, c.sample)
+		emb, err = noEmbPolicy.Apply(emb, err, func(e embedding.Embedder) ([]float32, error) {
+			return valueembedding.Vector(e, c.sample)
+		}, &noEmb)
+		if err != nil {
+			fails.Fail(c.datasetID, fmt.Errorf("column %v: %v", c.columnID, err))
+			p.Error()
+			continue
+		}
+		if emb == nil {
+			continue
+		}
+		err = chunk.Write(func(*sql.Tx) error {
+			_, err := vectorStmt.Exec(c.columnID, c.datasetID, c.columnName, vec32.Encode(emb, encoding))
+			if err != nil {
+				return err
+			}
+			return versions.Record("value_vectors", c.datasetID, c.columnID, version.Model, version.Version, version.Dim)
+		})
+		if err != nil {
+			fails.Fail(c.datasetID, fmt.Errorf("column %v: %v", c.columnID, err))
+			p.Error()
+		} else {
+			stored++
+		}
+		if err := chunk.Done(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := chunk.Close(); err != nil {
+		log.Fatal(err)
+	}
+	rep := p.Stop()
+	if *dryRun {
+		log.Printf("dry run: would store the value vectors of %d columns; columns without embeddable values: %v",
+			stored, &noEmb)
+	} else {
+		log.Printf("stored the value vectors of %d columns; columns without embeddable values: %v",
+			stored, &noEmb)
+	}
+	if err := fails.Finish(db, *dryRun); err != nil {
+		log.Fatal(err)
+	}
+	if stats := embedding.CacheStatsOf(embedder); stats != nil {
+		log.Println("embedding cache:", stats)
+	}
+	summary.Embeddings(len(cols), &noEmb)
+	summary.Count("vectors", stored)
+	if err := summary.Finish(db, rep, fails); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,83 +1,401 @@
-// Serves the OpenDataLink frontend with CPU profiling enabled
-// Command server serves the Open Data Link frontend.
+// Command profile computes statistics of the datasets and their columns and
+// stores them in the dataset_profiles and column_profiles tables of the Open
+// Data Link database (see sql/create_profile_tables.sql): the number of rows
+// of each dataset and, for each column, its inferred type, the number and
+// fraction of null values, the approximate number of distinct values, the
+// -topk most frequent values, and the minimum, maximum, mean, and standard
+// deviation of its numeric values. The profiles are shown on the dataset
+// pages, and may be used to rank datasets or to judge their columns as join
+// keys.
+//
+// The dataset files are read from datasets/<id>/rows.csv or its compressed
+// version, from the CSV files listed in the manifest, or from the partitions
+// of partitioned datasets, as by sketch_columns, whose column IDs the column
+// profiles share. Blank values and the markers NULL, NA, N/A, None, and NaN,
+// in any case, are null. The distinct counts are estimated with HyperLogLog,
+// and the most frequent values found with the Space-Saving algorithm (see
+// package topk), so that large tables are profiled in bounded memory.
+//
+// The profiles of a dataset profiled again replace its previous ones. If the
+// database has a dataset_checksums table, the checksums of the files of the
+// datasets profiled are recorded, and with -changed-only only the datasets
+// whose files changed since they were last profiled are profiled again (see
+// package checksum). The datasets that cannot be read or written are skipped,
+// and their failures reported (see package failures). The profiles are
+// committed in chunks of datasets, retried while the database is locked by
+// another connection, such as a running server (see database.Chunker).
 package main
 
 import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
-	"net/http"
-	_ "net/http/pprof"
+	"math"
 	"os"
-	"runtime/pprof"
+	"strconv"
+	"strings"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/checksum"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
-	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
-	"github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
-	"github.com/DataIntelligenceCrew/OpenDataLink/internal/server"
-	"github.com/ekzhu/go-fasttext"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/hooks"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/layout"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/partition"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/progress"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/topk"
+	"github.com/axiomhq/hyperloglog"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Layout of the datasets (see config.DatasetLayout), set by main.
+var datasets *layout.Layout
+
+// Ingestion hooks (see package hooks), set by main.
+var datasetHooks *hooks.Hooks
+
+// Checksums of the dataset files (see package checksum), set by main.
+var checksums *checksum.Tracker
+
 const (
-	// Containment threshold for joinability index
-	joinabilityThreshold = 0.5
+	// Number of worker goroutines
+	numWorkers = 16
+	// Number of datasets whose profiles are committed together
+	datasetsPerCommit = 100
+	// Number of values whose counts are kept for each of the -topk most
+	// frequent values of a column
+	countersPerTopValue = 20
 )
 
-var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
+var topK = flag.Int("topk", 10, "number of most frequent values of each column")
 
-func main() {
-	flag.Parse()
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
+// isNull reports whether v is a null value.
+func isNull(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "null", "na", "n/a", "none", "nan":
+		return true
+	}
+	return false
+}
+
+// columnProfile computes the profile of a column.
+type columnProfile struct {
+	name     string
+	nulls    int64
+	distinct *hyperloglog.Sketch
+	top      *topk.Counter
+	types    coltype.Inferrer
+	// Number, minimum, and maximum of the numeric values, and their mean and
+	// sum of squared differences from it, by Welford's algorithm.
+	numeric  int64
+	min, max float64
+	mean, m2 float64
+}
+
+func newColumnProfile(name string) *columnProfile {
+	return &columnProfile{
+		name:     name,
+		distinct: hyperloglog.New(),
+		top:      topk.New(countersPerTopValue * *topK),
+	}
+}
+
+func (c *columnProfile) add(v string) {
+	if isNull(v) {
+		c.nulls++
+		return
+	}
+	c.distinct.Insert([]byte(v))
+	c.top.Add(v)
+	c.types.Add(v)
+	x, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+		return
+	}
+	c.numeric++
+	if c.numeric == 1 || x < c.min {
+		c.min = x
+	}
+	if c.numeric == 1 || x > c.max {
+		c.max = x
+	}
+	d := x - c.mean
+	c.mean += d / float64(c.numeric)
+	c.m2 += d * (x - c.mean)
+}
+
+// stddev returns the population standard deviation of the numeric values.
+func (c *columnProfile) stddev() float64 {
+	return math.Sqrt(c.m2 / float64(c.numeric))
+}
+
+// tableProfile is the profile of a dataset.
+type tableProfile struct {
+	datasetID string
+	rows      int64
+	columns   []*columnProfile
+}
+
+// openRows opens the rows.csv file of the dataset with the given ID, or merges
+// its partitions in the order in which sketch_columns numbers their columns if
+// it is partitioned.
+func openRows(datasetID string) (io.ReadCloser, error) {
+	path, err := datasets.Path(datasetID, "rows.csv")
+	if err == nil {
+		if path, err = datafile.Find(path); err == nil {
+			return datasetHooks.Open(datasetID, "rows.csv", path)
+		}
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	dir, derr := datasets.Path(datasetID, "rows")
+	if derr != nil {
+		return nil, err
+	}
+	if info, derr := os.Stat(dir); derr != nil || !info.IsDir() {
+		return nil, err
+	}
+	parts, err := partition.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	if parts[0].Name != "rows.csv" {
+		return nil, fmt.Errorf("partitions of %v are not CSV files: %w", dir, os.ErrNotExist)
+	}
+	return partition.CSV(parts, func(p *partition.Part) (io.ReadCloser, error) {
+		return datasetHooks.Open(datasetID, p.Name, p.Path)
+	})
+}
+
+// profileDataset profiles the dataset with the given ID. It returns nil if its
+// table is empty.
+func profileDataset(datasetID string) (*tableProfile, error) {
+	f, err := openRows(datasetID)
+	if err != nil {
+		return nil, fmt.Errorf("error profiling %v: %w", datasetID, err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.LazyQuotes = true
+	r.ReuseRecord = true
+	t := &tableProfile{datasetID: datasetID}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("error profiling %v: %w", datasetID, err)
+		}
+		if t.columns == nil {
+			for _, name := range record {
+				t.columns = append(t.columns, newColumnProfile(name))
+			}
+			continue
+		}
+		t.rows++
+		for i, v := range record {
+			t.columns[i].add(v)
+		}
+	}
+	if t.columns == nil {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// worker profiles the datasets with the IDs received from jobs, sending nil
+// for those that are unchanged, missing, or cannot be read, and recording the
+// failures of the datasets that exist in fails.
+func worker(jobs <-chan string, out chan<- *tableProfile, p *progress.Reporter, fails *failures.Recorder) {
+	for datasetID := range jobs {
+		if changed, err := checksums.Changed(datasetID); err != nil || !changed {
+			if err != nil {
+				fails.Fail(datasetID, err)
+				p.Error()
+			}
+			out <- nil
+			continue
+		}
+		t, err := profileDataset(datasetID)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				log.Println(err)
+			} else {
+				fails.Fail(datasetID, err)
+			}
+			p.Error()
 		}
-		pprof.StartCPUProfile(f)
+		out <- t
+	}
+}
+
+// profileWriter writes the profiles of datasets with the statements of the
+// current chunk.
+type profileWriter struct {
+	deleteDataset, deleteColumns, dataset, column *sql.Stmt
+}
+
+func (w *profileWriter) prepare(tx *sql.Tx) (err error) {
+	if w.deleteDataset, err = tx.Prepare(`DELETE FROM dataset_profiles WHERE dataset_id = ?`); err != nil {
+		return err
 	}
-	db, err := database.New(config.DatabasePath())
+	if w.deleteColumns, err = tx.Prepare(`DELETE FROM column_profiles WHERE dataset_id = ?`); err != nil {
+		return err
+	}
+	w.dataset, err = tx.Prepare(`
+	INSERT INTO dataset_profiles (dataset_id, row_count, column_count) VALUES (?, ?, ?)`)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer db.Close()
+	w.column, err = tx.Prepare(`
+	INSERT INTO column_profiles
+	(column_id, dataset_id, column_name, column_type, null_count, null_rate, distinct_count,
+		top_values, numeric_count, min, max, mean, stddev)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	return err
+}
 
-	ft := fasttext.NewFastText(config.FasttextPath())
-	defer ft.Close()
+// write replaces the profiles of the dataset of t.
+func (w *profileWriter) write(t *tableProfile) error {
+	if _, err := w.deleteDataset.Exec(t.datasetID); err != nil {
+		return err
+	}
+	if _, err := w.deleteColumns.Exec(t.datasetID); err != nil {
+		return err
+	}
+	if _, err := w.dataset.Exec(t.datasetID, t.rows, len(t.columns)); err != nil {
+		return err
+	}
+	for i, c := range t.columns {
+		top, err := json.Marshal(c.top.Top(*topK))
+		if err != nil {
+			return err
+		}
+		var nullRate float64
+		if t.rows > 0 {
+			nullRate = float64(c.nulls) / float64(t.rows)
+		}
+		// The numeric summaries are null without numeric values.
+		var min, max, mean, stddev interface{}
+		if c.numeric > 0 {
+			min, max, mean, stddev = c.min, c.max, c.mean, c.stddev()
+		}
+		// The column IDs are those of sketch_columns.
+		_, err = w.column.Exec(fmt.Sprint(t.datasetID, "-", i), t.datasetID, c.name, c.types.Type(),
+			c.nulls, nullRate, c.distinct.Estimate(), string(top), c.numeric, min, max, mean, stddev)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	metadataIndex, err := index.BuildMetadataEmbeddingIndex(db)
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("profile", "datasets", false)
+	var err error
+	if datasets, err = config.DatasetLayout(); err != nil {
+		log.Fatal(err)
+	}
+	if *topK < 1 {
+		log.Fatal("-topk must be positive")
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Println("built metadata embedding index")
-	joinabilityIndex, err := index.BuildJoinabilityIndex(db)
+	defer db.Close()
 
-	pprof.StopCPUProfile()
+	for _, table := range []string{"dataset_profiles", "column_profiles"} {
+		if ok, err := database.HasTable(db, table); err != nil {
+			log.Fatal(err)
+		} else if !ok {
+			log.Fatalf("no %v table (see sql/create_profile_tables.sql)", table)
+		}
+	}
+	ids, err := datasets.IDs()
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Println("built joinability index")
-	orgConf := &navigation.Config{
-		Gamma:                30,
-		TerminationThreshold: 1e-9,
-		TerminationWindow:    1000,
-		MaxIters:             1750,
-	}
-
-	s, err := server.New(&server.Config{
-		DevMode:              true,
-		DB:                   db,
-		FastText:             ft,
-		MetadataIndex:        metadataIndex,
-		JoinabilityThreshold: joinabilityThreshold,
-		JoinabilityIndex:     joinabilityIndex,
-		OrganizeConfig:       orgConf,
-	})
+	fails, err := failures.Start("profile")
+	if err != nil {
+		log.Fatal(err)
+	}
+	datasetHooks = hooks.Start("profile", fails, false)
+	if checksums, err = checksum.Start(db, datasets, "profile", "rows.csv", "rows"); err != nil {
+		log.Fatal(err)
+	}
+	p, err := progress.Start("datasets", len(ids))
 	if err != nil {
 		log.Fatal(err)
 	}
-	s.Install()
 
-	log.Println("serving at http://localhost:8080")
+	jobs := make(chan string, len(ids))
+	out := make(chan *tableProfile, len(ids))
+	for i := 0; i < numWorkers; i++ {
+		go worker(jobs, out, p, fails)
+	}
+	for _, id := range ids {
+		jobs <- id
+	}
+	close(jobs)
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	w := &profileWriter{}
+	chunk := &database.Chunker{
+		DB:        db,
+		Size:      datasetsPerCommit,
+		Prepare:   w.prepare,
+		Committed: datasetHooks.Committed,
+	}
+	var profiled, columns int
+	for range ids {
+		if t := <-out; t != nil {
+			// The profiles of a dataset are written in a savepoint, so
+			// that a dataset that cannot be written is skipped.
+			err := chunk.Write(func(tx *sql.Tx) error {
+				if err := w.write(t); err != nil {
+					return err
+				}
+				return checksums.Store(tx, t.datasetID)
+			})
+			if err != nil {
+				fails.Fail(t.datasetID, err)
+				p.Error()
+			} else {
+				profiled++
+				columns += len(t.columns)
+				datasetHooks.Stored(t.datasetID)
+			}
+			if err := chunk.Done(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		p.Done(1)
+	}
+	if err := chunk.Close(); err != nil {
+		log.Fatal(err)
+	}
+	rep := p.Stop()
+	log.Printf("profiled %d columns of %d datasets; %d were unchanged, missing, empty, or failed",
+		columns, profiled, len(ids)-profiled)
+	if err := fails.Finish(db, false); err != nil {
+		log.Fatal(err)
+	}
+	summary.Skipped = len(ids) - profiled - fails.Datasets()
+	summary.Count("columns", columns)
+	if err := summary.Finish(db, rep, fails); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,174 @@
+// Command prune removes low-value datasets from the Open Data Link database.
+//
+// Datasets are pruned if they match any of the enabled rules: no sketched
+// columns (-nocolumns), an empty description and no tags (-nodescription), a
+// permalink that no longer resolves (-deadlinks), or a metadata quality score
+// below -minquality. Each pruned dataset is printed with the rules it matched.
+//
+// By default, prune only reports the datasets it would prune. With -hide, their
+// vectors and column sketches are deleted, leaving them out of the indexes
+// while keeping their dataset pages; with -delete, their metadata is deleted as
+// well.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	noColumns     = flag.Bool("nocolumns", true, "prune datasets without sketched columns")
+	noDescription = flag.Bool("nodescription", true, "prune datasets with an empty description and no tags")
+	deadLinks     = flag.Bool("deadlinks", false, "prune datasets whose permalink does not resolve")
+	minQuality    = flag.Float64("minquality", 0, "prune datasets with a metadata quality score below this (0 to 1)")
+	hide          = flag.Bool("hide", false, "delete vectors and column sketches of pruned datasets")
+	del           = flag.Bool("delete", false, "delete pruned datasets entirely")
+	timeout       = flag.Duration("timeout", 10*time.Second, "timeout of dead link checks")
+	workers       = flag.Int("workers", 8, "number of concurrent dead link checks")
+)
+
+// quality returns the fraction of the metadata fields of m that are filled in,
+// counting whether the dataset has sketched columns as a field.
+func quality(m *database.Metadata, columns int) float64 {
+	fields := []bool{
+		m.Name != "",
+		m.Description != "",
+		m.Attribution != "",
+		m.ContactEmail != "",
+		len(m.Categories) > 0,
+		len(m.Tags) > 0,
+		columns > 0,
+	}
+	n := 0
+	for _, ok := range fields {
+		if ok {
+			n++
+		}
+	}
+	return float64(n) / float64(len(fields))
+}
+
+// checkLinks returns the IDs of the datasets whose permalink responds with an
+// error or does not respond.
+func checkLinks(ms []*database.Metadata) map[string]bool {
+	client := &http.Client{Timeout: *timeout}
+	dead := make(map[string]bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan *database.Metadata)
+
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range work {
+				if m.Permalink == "" || linkAlive(client, m.Permalink) {
+					continue
+				}
+				mu.Lock()
+				dead[m.DatasetID] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, m := range ms {
+		work <- m
+	}
+	close(work)
+	wg.Wait()
+	return dead
+}
+
+func linkAlive(client *http.Client, url string) bool {
+	resp, err := client.Head(url)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	// Some portals do not support HEAD requests.
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		if resp, err = client.Get(url); err != nil {
+			return false
+		}
+		resp.Body.Close()
+	}
+	return resp.StatusCode < 400
+}
+
+func main() {
+	config.ParseFlags()
+
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ms, err := db.AllMetadata()
+	if err != nil {
+		log.Fatal(err)
+	}
+	columns, err := db.ColumnCounts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var dead map[string]bool
+	if *deadLinks {
+		dead = checkLinks(ms)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	var pruned, nColumns, nDescription, nDead, nQuality int
+
+	for _, m := range ms {
+		var reasons []string
+		if *noColumns && columns[m.DatasetID] == 0 {
+			reasons = append(reasons, "no columns")
+			nColumns++
+		}
+		if *noDescription && m.Description == "" && len(m.Tags) == 0 {
+			reasons = append(reasons, "no description or tags")
+			nDescription++
+		}
+		if dead[m.DatasetID] {
+			reasons = append(reasons, "dead link")
+			nDead++
+		}
+		if q := quality(m, columns[m.DatasetID]); q < *minQuality {
+			reasons = append(reasons, fmt.Sprintf("quality %.2f", q))
+			nQuality++
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+		pruned++
+		fmt.Fprintf(w, "%s\t%s\t%s\n", m.DatasetID, m.Name, strings.Join(reasons, ", "))
+
+		if *del || *hide {
+			if err := db.DeleteDataset(m.DatasetID, !*del); err != nil {
+				log.Fatalf("dataset %v: %v", m.DatasetID, err)
+			}
+		}
+	}
+	w.Flush()
+
+	action := "would prune"
+	if *del {
+		action = "deleted"
+	} else if *hide {
+		action = "hid"
+	}
+	log.Printf("%s %d of %d datasets (no columns: %d, no description or tags: %d, dead link: %d, low quality: %d)",
+		action, pruned, len(ms), nColumns, nDescription, nDead, nQuality)
+}
@@ -0,0 +1,150 @@
+// Command prune_removed removes from the Open Data Link database the datasets
+// that were removed from their source: the datasets of the catalog, those with
+// metadata or sketched columns, that are no longer in the datasets directory
+// or the manifest (see config.DatasetLayout). Each removed dataset is printed
+// with its name.
+//
+// The removed datasets are soft-deleted: their vectors, column sketches, and
+// other indexed data are deleted, leaving them out of the indexes the next
+// time they are built, while their metadata, and so their dataset pages, are
+// kept. With -delete, their metadata is deleted as well. With -dry-run, the
+// datasets are only reported. The datasets already soft-deleted, with metadata
+// but without a metadata vector or sketched columns, are left alone unless
+// -delete is set.
+//
+// Since an empty or misconfigured datasets directory would remove the whole
+// catalog, prune_removed refuses to remove more than the -maxremoved fraction
+// of the datasets of the catalog.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	dryRun     = flag.Bool("dry-run", false, "report the removed datasets without changing the database")
+	del        = flag.Bool("delete", false, "delete the removed datasets entirely, including their metadata")
+	maxRemoved = flag.Float64("maxremoved", 0.5, "maximum fraction of the datasets of the catalog that may be removed")
+)
+
+// catalog returns the IDs of the datasets of the database, those with metadata
+// or sketched columns, in sorted order, their names by ID, and whether they
+// are indexed, with a metadata vector or sketched columns, by ID.
+func catalog(db *database.DB) ([]string, map[string]string, map[string]bool, error) {
+	ms, err := db.AllMetadata()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	columns, err := db.ColumnCounts()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	indexed := make(map[string]bool)
+	rows, err := db.Query(`SELECT dataset_id FROM metadata_vectors`)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, nil, nil, err
+		}
+		indexed[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	names := make(map[string]string)
+	var ids []string
+	for _, m := range ms {
+		names[m.DatasetID] = m.Name
+		ids = append(ids, m.DatasetID)
+	}
+	for id := range columns {
+		indexed[id] = true
+		if _, ok := names[id]; !ok {
+			names[id] = ""
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, names, indexed, nil
+}
+
+func main() {
+	config.ParseFlags()
+	if *maxRemoved < 0 || *maxRemoved > 1 {
+		log.Fatal("-maxremoved must be between 0 and 1")
+	}
+	datasets, err := config.DatasetLayout()
+	if err != nil {
+		log.Fatal(err)
+	}
+	source, err := datasets.IDs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	present := make(map[string]bool)
+	for _, id := range source {
+		present[id] = true
+	}
+
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ids, names, indexed, err := catalog(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var removed []string
+	var hidden int
+	for _, id := range ids {
+		if present[id] {
+			continue
+		}
+		if !indexed[id] && !*del {
+			hidden++
+			continue
+		}
+		removed = append(removed, id)
+	}
+	if len(removed) > 0 && float64(len(removed)) > *maxRemoved*float64(len(ids)) {
+		log.Fatalf("%d of the %d datasets of the catalog are not in the source, more than -maxremoved %v; check the datasets directory or manifest",
+			len(removed), len(ids), *maxRemoved)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	for _, id := range removed {
+		fmt.Fprintf(w, "%s\t%s\n", id, names[id])
+		if *dryRun {
+			continue
+		}
+		if err := db.DeleteDataset(id, !*del); err != nil {
+			log.Fatalf("dataset %v: %v", id, err)
+		}
+	}
+	w.Flush()
+
+	action := "soft-deleted"
+	if *dryRun {
+		action = "would remove"
+	} else if *del {
+		action = "deleted"
+	}
+	log.Printf("%s %d of %d datasets no longer in the source (%d already soft-deleted, %d datasets in the source)",
+		action, len(removed), len(ids), hidden, len(source))
+}
@@ -0,0 +1,428 @@
+// Command reembed embeds the stale vectors of the Open Data Link database
+// again after an embedding model upgrade. A vector is stale if the
+// vector_versions table records another model, model version, or dimension for
+// it than those of the configured model, or no version at all.
+//
+// Metadata vectors are embedded from the metadata in the database, attribute
+// vectors from the attribute names, and value vectors from the sampled values
+// of the column sketches; -tables selects which of them are checked. With
+// category models, datasets are routed to the model of their categories again.
+// Vectors of texts the model has no embedding for are handled by the
+// OPENDATALINK_NO_EMBEDDING policy, as by the ingestion commands: with skip
+// (the default), they are deleted.
+//
+// With -dry-run, reembed only reports the number of stale vectors.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/attributeembedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/valueembedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	tables = flag.String("tables", "metadata_vectors,attribute_vectors,value_vectors",
+		"comma-separated vector tables to re-embed")
+	dryRun = flag.Bool("dry-run", false, "only report the number of stale vectors")
+)
+
+// vector is a stored vector and the recorded version of its model.
+type vector struct {
+	datasetID string
+	// Key of the vector within the dataset (see database.VersionRecorder).
+	name    string
+	version embedding.Version
+}
+
+// readVectors reads the vectors of a table and their recorded versions, which
+// are zero if none is recorded. key is the SQL expression of the key of the
+// vectors of the table v within their dataset.
+func readVectors(tx *sql.Tx, table, key string) ([]*vector, error) {
+	rows, err := tx.Query(`
+	SELECT v.dataset_id, `+key+`, COALESCE(r.model, ''), COALESCE(r.version, ''), COALESCE(r.dim, 0)
+	FROM `+table+` v
+	LEFT JOIN vector_versions r
+	ON r.vectors = ? AND r.dataset_id = v.dataset_id AND r.name = `+key, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vecs []*vector
+	for rows.Next() {
+		var v vector
+		if err := rows.Scan(&v.datasetID, &v.name, &v.version.Model, &v.version.Version, &v.version.Dim); err != nil {
+			return nil, err
+		}
+		vecs = append(vecs, &v)
+	}
+	return vecs, rows.Err()
+}
+
+// reembedder embeds stale vectors again and writes them in a transaction.
+type reembedder struct {
+	tx       *sql.Tx
+	embedder embedding.Model
+	// The router of the category models, or nil.
+	router *embedding.Router
+	// Version of the vectors of the default model.
+	version   embedding.Version
+	encoding  vec32.Encoding
+	versions  *database.VersionRecorder
+	tokenizer *attributeembedding.Tokenizer
+	// Handling of the texts without embedding, and their counts.
+	noEmbPolicy embedding.NoEmbPolicy
+	noEmb       embedding.NoEmbCounts
+}
+
+// model returns the model with the given name and the version of its vectors.
+func (r *reembedder) model(name string) (embedding.Model, embedding.Version) {
+	if r.router == nil {
+		return r.embedder, r.version
+	}
+	return r.router.Model(name), r.router.Version(name)
+}
+
+// metadata embeds the given stale metadata vectors again, grouped by the
+// model of each dataset.
+func (r *reembedder) metadata(stale []*vector, metas map[string]*database.Metadata) error {
+	groups := make(map[string][]*vector)
+	for _, v := range stale {
+		name := ""
+		if r.router != nil {
+			name, _ = r.router.Route(metas[v.datasetID].Categories)
+		}
+		groups[name] = append(groups[name], v)
+	}
+	for name, group := range groups {
+		model, version := r.model(name)
+		texts := make([]string, len(group))
+		for i, v := range group {
+			texts[i] = wordemb.MetadataText(metas[v.datasetID])
+		}
+		embs, errs, err := embedding.EmbedAll(model, texts)
+		if err != nil {
+			return err
+		}
+		for i, v := range group {
+			meta := metas[v.datasetID]
+			vec, err := r.noEmbPolicy.Apply(embs[i], errs[i], func(e embedding.Embedder) ([]float32, error) {
+				return wordemb.MetadataVector(e, meta)
+			}, &r.noEmb)
+			if err != nil {
+				return err
+			}
+			if vec == nil {
+				// Metadata vectors have no key within their dataset.
+				if err := r.delete("metadata_vectors", "''", v); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := r.tx.Exec(`UPDATE metadata_vectors SET emb = ? WHERE dataset_id = ?`,
+				vec32.Encode(vec, r.encoding), v.datasetID); err != nil {
+				return err
+			}
+			if r.router != nil {
+				if _, err := r.tx.Exec(`
+				INSERT OR REPLACE INTO vector_models (dataset_id, model, dim) VALUES (?, ?, ?)`,
+					v.datasetID, name, len(vec)); err != nil {
+					return err
+				}
+			}
+			if err := r.versions.Record("metadata_vectors", v.datasetID, "", version.Model, version.Version, version.Dim); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// datasetModels returns the model of each dataset recorded in the
+// vector_models table, read in the transaction so that the models of
+// re-embedded metadata vectors are included.
+func (r *reembedder) datasetModels() (map[string]string, error) {
+	models := make(map[string]string)
+	if r.router == nil {
+		return models, nil
+	}
+	rows, err := r.tx.Query(`SELECT dataset_id, model FROM vector_models`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var datasetID, model string
+		if err := rows.Scan(&datasetID, &model); err != nil {
+			return nil, err
+		}
+		models[datasetID] = model
+	}
+	return models, rows.Err()
+}
+
+// attributes embeds the given stale attribute vectors again with the model of
+// their dataset's metadata.
+func (r *reembedder) attributes(stale []*vector, models map[string]string) error {
+	groups := make(map[string][]*vector)
+	for _, v := range stale {
+		groups[models[v.datasetID]] = append(groups[models[v.datasetID]], v)
+	}
+	for name, group := range groups {
+		model, version := r.model(name)
+		names := make([]string, len(group))
+		for i, v := range group {
+			names[i] = v.name
+		}
+		embs, errs, err := r.tokenizer.Vectors(model, names)
+		if err != nil {
+			return err
+		}
+		for i, v := range group {
+			vec, err := r.noEmbPolicy.Apply(embs[i], errs[i], func(e embedding.Embedder) ([]float32, error) {
+				return r.tokenizer.Vector(e, v.name)
+			}, &r.noEmb)
+			if err != nil {
+				return err
+			}
+			if vec == nil {
+				if err := r.delete("attribute_vectors", "attribute_name", v); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := r.tx.Exec(`
+			UPDATE attribute_vectors SET emb = ? WHERE dataset_id = ? AND attribute_name = ?`,
+				vec32.Encode(vec, r.encoding), v.datasetID, v.name); err != nil {
+				return err
+			}
+			if err := r.versions.Record("attribute_vectors", v.datasetID, v.name, version.Model, version.Version, version.Dim); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// values embeds the given stale value vectors again from the sampled values
+// of their column sketches.
+func (r *reembedder) values(stale []*vector) error {
+	for _, v := range stale {
+		var sampleJSON []byte
+		err := r.tx.QueryRow(`SELECT sample FROM column_sketches WHERE column_id = ?`, v.name).Scan(&sampleJSON)
+		if err == sql.ErrNoRows {
+			log.Printf("column %v: no column sketch, not re-embedded", v.name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var sample []string
+		if err := json.Unmarshal(sampleJSON, &sample); err != nil {
+			return err
+		}
+		emb, err := valueembedding.Vector(r.embedder, sample)
+		emb, err = r.noEmbPolicy.Apply(emb, err, func(e embedding.Embedder) ([]float32, error) {
+			return valueembedding.Vector(e, sample)
+		}, &r.noEmb)
+		if err != nil {
+			return err
+		}
+		if emb == nil {
+			if err := r.delete("value_vectors", "column_id", v); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := r.tx.Exec(`UPDATE value_vectors SET emb = ? WHERE column_id = ?`,
+			vec32.Encode(emb, r.encoding), v.name); err != nil {
+			return err
+		}
+		if err := r.versions.Record("value_vectors", v.datasetID, v.name, r.version.Model, r.version.Version, r.version.Dim); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete deletes a vector of a table, whose key within its dataset is the SQL
+// column key, and its recorded version.
+func (r *reembedder) delete(table, key string, v *vector) error {
+	_, err := r.tx.Exec(`DELETE FROM `+table+` WHERE dataset_id = ? AND `+key+` = ?`, v.datasetID, v.name)
+	if err != nil {
+		return err
+	}
+	_, err = r.tx.Exec(`
+	DELETE FROM vector_versions WHERE vectors = ? AND dataset_id = ? AND name = ?`, table, v.datasetID, v.name)
+	return err
+}
+
+func main() {
+	config.ParseFlags()
+
+	selected := make(map[string]bool)
+	for _, table := range strings.Split(*tables, ",") {
+		switch table = strings.TrimSpace(table); table {
+		case "metadata_vectors", "attribute_vectors", "value_vectors":
+			selected[table] = true
+		default:
+			log.Fatalf("unknown vector table %q (want metadata_vectors, attribute_vectors, or value_vectors)", table)
+		}
+	}
+
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	embeddingConfig, err := config.Embedding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoding, err := config.VectorEncoding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	categoryModels, err := config.CategoryModels()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokenizer, err := config.AttributeTokenizer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	noEmbPolicy, err := config.NoEmbPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if embeddingConfig, err = embeddingConfig.Resolve(); err != nil {
+		log.Fatal(err)
+	}
+	r := &reembedder{encoding: encoding, tokenizer: tokenizer, noEmbPolicy: noEmbPolicy}
+	if categoryModels != nil {
+		r.router, err = embedding.OpenRouter(embeddingConfig, categoryModels)
+		r.embedder = r.router
+	} else {
+		r.embedder, err = embedding.Open(embeddingConfig)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.embedder.Close()
+	r.version = embeddingConfig.ModelVersion(r.embedder.Dim())
+
+	if r.tx, err = db.Begin(); err != nil {
+		log.Fatal(err)
+	}
+	defer r.tx.Rollback()
+	if r.versions, err = database.PrepareVersionRecorder(r.tx); err != nil {
+		log.Fatal(err)
+	}
+	if r.versions == nil {
+		log.Fatal("the database has no vector_versions table (see sql/create_vector_versions_table.sql)")
+	}
+	defer r.versions.Close()
+
+	if selected["metadata_vectors"] {
+		vecs, err := readVectors(r.tx, "metadata_vectors", "''")
+		if err != nil {
+			log.Fatal(err)
+		}
+		metas := make(map[string]*database.Metadata)
+		all, err := db.AllMetadata()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range all {
+			metas[m.DatasetID] = m
+		}
+		var stale []*vector
+		for _, v := range vecs {
+			m := metas[v.datasetID]
+			if m == nil {
+				log.Printf("dataset %v: no metadata, not re-embedded", v.datasetID)
+				continue
+			}
+			name := ""
+			if r.router != nil {
+				name, _ = r.router.Route(m.Categories)
+			}
+			if _, version := r.model(name); v.version != version {
+				stale = append(stale, v)
+			}
+		}
+		log.Printf("metadata_vectors: %d of %d vectors are stale", len(stale), len(vecs))
+		if !*dryRun {
+			if err := r.metadata(stale, metas); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if selected["attribute_vectors"] {
+		vecs, err := readVectors(r.tx, "attribute_vectors", "v.attribute_name")
+		if err != nil {
+			log.Fatal(err)
+		}
+		models, err := r.datasetModels()
+		if err != nil {
+			log.Fatal(err)
+		}
+		var stale []*vector
+		for _, v := range vecs {
+			model, version := r.model(models[v.datasetID])
+			if model == nil {
+				log.Fatalf("dataset %v: metadata embedded with category model %q, which is not configured",
+					v.datasetID, models[v.datasetID])
+			}
+			if v.version != version {
+				stale = append(stale, v)
+			}
+		}
+		log.Printf("attribute_vectors: %d of %d vectors are stale", len(stale), len(vecs))
+		if !*dryRun {
+			if err := r.attributes(stale, models); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if selected["value_vectors"] {
+		vecs, err := readVectors(r.tx, "value_vectors", "v.column_id")
+		if err != nil {
+			log.Fatal(err)
+		}
+		var stale []*vector
+		for _, v := range vecs {
+			if v.version != r.version {
+				stale = append(stale, v)
+			}
+		}
+		log.Printf("value_vectors: %d of %d vectors are stale", len(stale), len(vecs))
+		if !*dryRun {
+			if err := r.values(stale); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if *dryRun {
+		return
+	}
+	if err := r.tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("re-embedded vectors without embedding:", &r.noEmb)
+	if stats := embedding.CacheStatsOf(r.embedder); stats != nil {
+		log.Println("embedding cache:", stats)
+	}
+}
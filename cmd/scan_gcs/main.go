@@ -0,0 +1,143 @@
+// Command scan_gcs harvests the tabular files of Google Cloud Storage buckets
+// as datasets, for the ingestion pipeline, and keeps the datasets in sync with
+// the buckets, as scan_s3 does for S3 buckets.
+//
+// The objects of each of the -buckets, under its prefix, if any, are listed,
+// and each CSV, TSV, JSON, JSON Lines, Parquet, or xlsx file, possibly
+// compressed with gzip, is a dataset named after its file. The tables exported
+// by BigQuery, whose rows are split across many files, are harvested from
+// their manifests, the objects whose names end in .manifest listing the URIs
+// of the files of a table, one per line, each a dataset named after its
+// manifest whose sample is read from its files in their order (see package
+// bucket):
+//
+//	scan_gcs -buckets open-data-bucket/exports/
+//	process_datasets -changed-only
+//	prune_removed
+//
+// The requests are authorized with the credentials file of the
+// GOOGLE_APPLICATION_CREDENTIALS environment variable, the key file of a
+// service account, or else the access token of the GOOGLE_OAUTH_ACCESS_TOKEN
+// environment variable, or else the application default credentials of
+// gcloud auth application-default login, if any, unless -anonymous is set, for
+// public buckets.
+//
+// The objects that cannot be read are skipped, and their failures reported
+// (see package failures).
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/bucket"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	buckets    = flag.String("buckets", "", "comma-separated buckets to scan, each with an optional prefix of the names of its objects, such as bucket/prefix/ or gs://bucket/prefix/")
+	endpoint   = flag.String("endpoint", "", "URL of the Cloud Storage service, such as that of an emulator, instead of https://storage.googleapis.com")
+	anonymous  = flag.Bool("anonymous", false, "do not authorize the requests, for public buckets")
+	sampleRows = flag.Int("samplerows", 1000, "number of rows of the samples of the CSV, TSV, and JSON Lines files, or 0 to read them whole")
+	maxSize    = flag.Int64("maxsize", 100<<20, "maximum size of the files read whole, in bytes")
+	maxRemoved = flag.Float64("maxremoved", 0.5, "maximum fraction of the objects of a bucket harvested before that may be removed")
+)
+
+// account returns the Google account of the environment, or nil if there is
+// none.
+func account() (*bucket.GoogleAccount, error) {
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return bucket.ReadGoogleCredentials(path)
+	}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return &bucket.GoogleAccount{AccessToken: token}, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, nil
+	}
+	path := filepath.Join(dir, "gcloud", "application_default_credentials.json")
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil
+	}
+	return bucket.ReadGoogleCredentials(path)
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("scan_gcs", "datasets", false)
+	if *buckets == "" {
+		log.Fatal("no -buckets to scan")
+	}
+	if *sampleRows < 0 {
+		log.Fatal("-samplerows must not be negative")
+	}
+	var a *bucket.GoogleAccount
+	if !*anonymous {
+		var err error
+		if a, err = account(); err != nil {
+			log.Fatal(err)
+		} else if a == nil {
+			log.Print("no Google credentials in the environment; scanning the buckets anonymously")
+		}
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	w, err := harvest.Start(db, config.DatasetsDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fails, err := failures.Start("scan_gcs")
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := &bucket.Options{SampleRows: *sampleRows, MaxSize: *maxSize, MaxRemoved: *maxRemoved}
+	total := &bucket.Stats{}
+	for _, b := range strings.Split(*buckets, ",") {
+		name := strings.TrimPrefix(strings.TrimSpace(b), "gs://")
+		var prefix string
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name, prefix = name[:i], name[i+1:]
+		}
+		g := &bucket.GCS{Bucket: name, Endpoint: *endpoint, Account: a}
+		log.Printf("scanning %v/%v", g.Name(), prefix)
+		// The datasets of each bucket are committed before the next
+		// bucket is scanned, or the scan fails.
+		stats, err := bucket.Sync(db, w, g, prefix, opts, fails)
+		if err := w.Close(); err != nil {
+			log.Fatal(err)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%v/%v: %d tables, %d unchanged, %d written (%d changed), %d removed",
+			g.Name(), prefix, stats.Listed, stats.Unchanged, stats.Written, stats.Changed, stats.Removed)
+		total.Listed += stats.Listed
+		total.Unchanged += stats.Unchanged
+		total.Written += stats.Written
+		total.Changed += stats.Changed
+		total.Removed += stats.Removed
+	}
+	if err := fails.Finish(db, false); err != nil {
+		log.Fatal(err)
+	}
+	summary.Processed, summary.Total = int64(total.Written+total.Unchanged), int64(total.Listed)
+	summary.Count("unchanged", total.Unchanged)
+	summary.Count("changed", total.Changed)
+	summary.Count("removed", total.Removed)
+	if err := summary.Finish(db, nil, fails); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+// Command scan_s3 harvests the tabular files of S3 buckets as datasets, for
+// the ingestion pipeline, and keeps the datasets in sync with the buckets.
+//
+// The objects of each of the -buckets, under its prefix, if any, are listed,
+// and each CSV, TSV, JSON, JSON Lines, Parquet, or xlsx file, possibly
+// compressed with gzip, is a dataset named after its file and identified by a
+// hash of its bucket and key, and the files listed by each manifest, an object
+// whose name ends in .manifest, are the files of a dataset named after it
+// (see package bucket). A sample of the first -samplerows rows of the CSV,
+// TSV, and JSON Lines files, and the other files whole, up to -maxsize bytes,
+// are written to datasets/<id>/ (see config.DatasetsDir), and the columns
+// sampled from them stored in the source_columns table. The objects harvested
+// are recorded in the source_objects table, so that the objects whose entity
+// tags did not change are not read again, and the datasets of the objects
+// removed from the buckets are removed from the datasets directory, for
+// prune_removed to remove them from the catalog:
+//
+//	scan_s3 -buckets open-data-bucket/transport/
+//	process_datasets -changed-only
+//	prune_removed
+//
+// The requests are signed with the credentials of the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables, if set,
+// unless -anonymous is set, for public buckets. Buckets of services compatible
+// with S3, such as MinIO, are scanned with -endpoint and -pathstyle.
+//
+// The objects that cannot be read are skipped, and their failures reported
+// (see package failures).
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/bucket"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	buckets    = flag.String("buckets", "", "comma-separated buckets to scan, each with an optional prefix of the keys of its objects, such as bucket/prefix/ or s3://bucket/prefix/")
+	region     = flag.String("region", "us-east-1", "AWS region of the buckets")
+	endpoint   = flag.String("endpoint", "", "URL of the S3 service, such as http://localhost:9000 for MinIO, instead of that of the region")
+	pathStyle  = flag.Bool("pathstyle", false, "address the buckets by the path of the endpoint, rather than by virtual hosts")
+	anonymous  = flag.Bool("anonymous", false, "do not sign the requests, for public buckets")
+	sampleRows = flag.Int("samplerows", 1000, "number of rows of the samples of the CSV, TSV, and JSON Lines files, or 0 to read them whole")
+	maxSize    = flag.Int64("maxsize", 100<<20, "maximum size of the files read whole, in bytes")
+	maxRemoved = flag.Float64("maxremoved", 0.5, "maximum fraction of the objects of a bucket harvested before that may be removed")
+)
+
+// credentials returns the credentials of the environment, or nil if they are
+// not set.
+func credentials() *bucket.Credentials {
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return nil
+	}
+	return &bucket.Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+}
+
+func main() {
+	config.ParseFlags()
+	summary := runsummary.Start("scan_s3", "datasets", false)
+	if *buckets == "" {
+		log.Fatal("no -buckets to scan")
+	}
+	if *sampleRows < 0 {
+		log.Fatal("-samplerows must not be negative")
+	}
+	var creds *bucket.Credentials
+	if !*anonymous {
+		if creds = credentials(); creds == nil {
+			log.Print("no AWS credentials in the environment; scanning the buckets anonymously")
+		}
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	w, err := harvest.Start(db, config.DatasetsDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fails, err := failures.Start("scan_s3")
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := &bucket.Options{SampleRows: *sampleRows, MaxSize: *maxSize, MaxRemoved: *maxRemoved}
+	total := &bucket.Stats{}
+	for _, b := range strings.Split(*buckets, ",") {
+		name := strings.TrimPrefix(strings.TrimSpace(b), "s3://")
+		var prefix string
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name, prefix = name[:i], name[i+1:]
+		}
+		s := &bucket.S3{Bucket: name, Region: *region, Endpoint: *endpoint, PathStyle: *pathStyle, Credentials: creds}
+		log.Printf("scanning %v/%v", s.Name(), prefix)
+		// The datasets of each bucket are committed before the next
+		// bucket is scanned, or the scan fails.
+		stats, err := bucket.Sync(db, w, s, prefix, opts, fails)
+		if err := w.Close(); err != nil {
+			log.Fatal(err)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%v/%v: %d tables, %d unchanged, %d written (%d changed), %d removed",
+			s.Name(), prefix, stats.Listed, stats.Unchanged, stats.Written, stats.Changed, stats.Removed)
+		total.Listed += stats.Listed
+		total.Unchanged += stats.Unchanged
+		total.Written += stats.Written
+		total.Changed += stats.Changed
+		total.Removed += stats.Removed
+	}
+	if err := fails.Finish(db, false); err != nil {
+		log.Fatal(err)
+	}
+	summary.Processed, summary.Total = int64(total.Written+total.Unchanged), int64(total.Listed)
+	summary.Count("unchanged", total.Unchanged)
+	summary.Count("changed", total.Changed)
+	summary.Count("removed", total.Removed)
+	if err := summary.Finish(db, nil, fails); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,348 @@
+// Command schedule_harvests keeps the Open Data Link catalog fresh by
+// re-harvesting its sources periodically and processing only the datasets
+// that changed, rather than rebuilding the whole catalog.
+//
+// The sources are listed in the JSON -schedule file, each with the harvest
+// command run for it, such as harvest_socrata or scan_s3, the arguments of the
+// command, and how often it is run, as a Go duration:
+//
+//	{
+//		"sources": [
+//			{"name": "nyc", "command": "harvest_socrata", "args": ["-domains", "data.cityofnewyork.us", "-incremental"], "every": "6h"},
+//			{"name": "exports", "command": "scan_s3", "args": ["-buckets", "open-data-bucket/exports/"], "every": "1h"}
+//		],
+//		"process": ["-args", "process_attribute -samplerows 500"],
+//		"prune": true
+//	}
+//
+// The harvesters detect the changed datasets by their modification times with
+// -incremental, and scan_s3 and scan_gcs by the entity tags of their objects.
+// Whenever sources are due, they are run in the order of the schedule, and if
+// any of them changed datasets, process_datasets -changed-only is run with the
+// "process" arguments, which processes the datasets whose files changed (see
+// package checksum). If "prune" is set and datasets were removed from their
+// source, prune_removed is run as well. A source that fails is retried when it
+// is next due, and counted as changed, since it may have harvested datasets
+// before failing.
+//
+// If the database has a scheduled_harvests table (see
+// sql/create_scheduled_harvests_table.sql), the last run of each source is
+// recorded in it, so that the sources are due on time across restarts of
+// schedule_harvests and the datasets harvested before a restart are still
+// processed; otherwise all the sources are due when it starts. With -once, the
+// sources due are run a single time, as from cron. The commands are looked for
+// next to the executable of schedule_harvests and then in PATH, and inherit
+// the shared settings (see config.ParseFlags).
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	scheduleFile = flag.String("schedule", "", "JSON `file` of the sources to harvest and how often")
+	once         = flag.Bool("once", false, "run the sources due once and exit")
+)
+
+// Layout of the times of the scheduled_harvests table
+const timeLayout = "2006-01-02 15:04:05"
+
+// source is a source of the schedule.
+type source struct {
+	Name    string   `json:"name"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Every   string   `json:"every"`
+
+	every time.Duration
+	path  string
+	// Time the last run started, zero if the source was never run.
+	last time.Time
+}
+
+// schedule is the -schedule file.
+type schedule struct {
+	Sources []*source `json:"sources"`
+	// Arguments of process_datasets.
+	Process []string `json:"process"`
+	// Whether to run prune_removed after the runs that removed datasets.
+	Prune bool `json:"prune"`
+}
+
+// readSchedule reads the schedule file at path.
+func readSchedule(path string) (*schedule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var s schedule
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("schedule %v: %v", path, err)
+	}
+	if len(s.Sources) == 0 {
+		return nil, fmt.Errorf("schedule %v: no sources", path)
+	}
+	names := make(map[string]bool)
+	for _, src := range s.Sources {
+		if src.Name == "" || src.Command == "" {
+			return nil, fmt.Errorf("schedule %v: source without a name or command", path)
+		}
+		if names[src.Name] {
+			return nil, fmt.Errorf("schedule %v: duplicate source %q", path, src.Name)
+		}
+		names[src.Name] = true
+		if src.every, err = time.ParseDuration(src.Every); err != nil {
+			return nil, fmt.Errorf("schedule %v: source %v: %v", path, src.Name, err)
+		}
+		if src.every <= 0 {
+			return nil, fmt.Errorf("schedule %v: source %v: every must be positive", path, src.Name)
+		}
+	}
+	return &s, nil
+}
+
+// commandPath returns the path of the executable of a command.
+func commandPath(command string) (string, error) {
+	if exe, err := os.Executable(); err == nil {
+		path := filepath.Join(filepath.Dir(exe), command)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return "", fmt.Errorf("%v (install the commands with go install ./cmd/...)", err)
+	}
+	return path, nil
+}
+
+// state records the runs of the sources in the scheduled_harvests table, if
+// the database has one.
+type state struct {
+	db *sql.DB
+}
+
+// load sets the times of the last runs of the sources and returns whether the
+// datasets of any run are still to be processed.
+func (st *state) load(sources []*source) (bool, error) {
+	if st.db == nil {
+		return false, nil
+	}
+	bySource := make(map[string]*source)
+	for _, src := range sources {
+		bySource[src.Name] = src
+	}
+	rows, err := st.db.Query(`SELECT source, started_at, changed, processed FROM scheduled_harvests`)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	var pending bool
+	for rows.Next() {
+		var name, startedAt string
+		var changed int
+		var processed bool
+		if err := rows.Scan(&name, &startedAt, &changed, &processed); err != nil {
+			return false, err
+		}
+		src, ok := bySource[name]
+		if !ok {
+			continue
+		}
+		if src.last, err = time.Parse(timeLayout, startedAt); err != nil {
+			return false, fmt.Errorf("source %v: %v", name, err)
+		}
+		if !processed && changed != 0 {
+			pending = true
+		}
+	}
+	return pending, rows.Err()
+}
+
+// ran records a run of the source, started at its last time, that changed
+// and removed datasets, or failed with err.
+func (st *state) ran(src *source, changed, removed int, err error) error {
+	if st.db == nil {
+		return nil
+	}
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	_, err = st.db.Exec(`
+	INSERT OR REPLACE INTO scheduled_harvests (source, started_at, status, changed, removed, processed)
+	VALUES (?, ?, ?, ?, ?, 0)`,
+		src.Name, src.last.UTC().Format(timeLayout), status, changed, removed)
+	return err
+}
+
+// processed records that the datasets of the runs were processed.
+func (st *state) processed() error {
+	if st.db == nil {
+		return nil
+	}
+	_, err := st.db.Exec(`UPDATE scheduled_harvests SET processed = 1`)
+	return err
+}
+
+// run runs the command at path with the arguments.
+func run(path string, args ...string) error {
+	cmd := exec.Command(path, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// harvest runs the source and returns the numbers of datasets its run changed
+// and removed, read from its run summary, or -1 if it did not report them.
+func harvest(src *source) (changed, removed int, err error) {
+	f, err := ioutil.TempFile("", "schedule_harvests")
+	if err != nil {
+		return -1, -1, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	args := append([]string{"-summary-json=" + f.Name()}, src.Args...)
+	log.Printf("harvesting %v: %v %v", src.Name, src.Command, strings.Join(args, " "))
+	if err := run(src.path, args...); err != nil {
+		return -1, -1, fmt.Errorf("%v failed: %v", src.Command, err)
+	}
+	var summary struct {
+		Counts map[string]int `json:"counts"`
+	}
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil || json.Unmarshal(data, &summary) != nil {
+		return -1, -1, nil
+	}
+	changed, ok := summary.Counts["changed"]
+	if !ok {
+		changed = -1
+	}
+	return changed, summary.Counts["removed"], nil
+}
+
+func main() {
+	config.ParseFlags()
+	if *scheduleFile == "" {
+		log.Fatal("no -schedule file")
+	}
+	s, err := readSchedule(*scheduleFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	st := &state{}
+	if ok, err := database.HasTable(db, "scheduled_harvests"); err != nil {
+		log.Fatal(err)
+	} else if ok {
+		st.db = db
+	} else {
+		log.Print("no scheduled_harvests table (see sql/create_scheduled_harvests_table.sql); all the sources are due")
+	}
+	pending, err := st.load(s.Sources)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The commands are looked up before any is run, so that a missing
+	// command is reported at once rather than when its source is due.
+	for _, src := range s.Sources {
+		if src.path, err = commandPath(src.Command); err != nil {
+			log.Fatal(err)
+		}
+	}
+	processPath, err := commandPath("process_datasets")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var prunePath string
+	if s.Prune {
+		if prunePath, err = commandPath("prune_removed"); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for {
+		now := time.Now()
+		next := now.Add(24 * time.Hour)
+		var removed int
+		var ran bool
+		for _, src := range s.Sources {
+			if due := src.last.Add(src.every); !src.last.IsZero() && due.After(now) {
+				if due.Before(next) {
+					next = due
+				}
+				continue
+			}
+			ran = true
+			src.last = time.Now()
+			changed, r, err := harvest(src)
+			if err != nil {
+				log.Printf("%v: %v", src.Name, err)
+			} else {
+				log.Printf("%v: %d changed, %d removed", src.Name, changed, r)
+			}
+			// A run that failed, or did not report its changes, may
+			// still have harvested datasets.
+			if changed != 0 {
+				pending = true
+			}
+			if r > 0 {
+				removed += r
+			}
+			if err := st.ran(src, changed, r, err); err != nil {
+				log.Fatal(err)
+			}
+			if due := src.last.Add(src.every); due.Before(next) {
+				next = due
+			}
+		}
+		if pending {
+			log.Printf("processing the changed datasets: process_datasets -changed-only %v", strings.Join(s.Process, " "))
+			if err := run(processPath, append([]string{"-changed-only"}, s.Process...)...); err != nil {
+				// The datasets are processed again after the next runs.
+				log.Printf("process_datasets failed: %v", err)
+			} else {
+				pending = false
+				if err := st.processed(); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		if removed > 0 && s.Prune {
+			log.Printf("pruning the %d removed datasets", removed)
+			if err := run(prunePath); err != nil {
+				log.Printf("prune_removed failed: %v", err)
+			}
+		}
+		if *once {
+			if !ran {
+				log.Print("no sources due")
+			}
+			return
+		}
+		log.Printf("next harvest at %v", next.Format(time.RFC3339))
+		time.Sleep(time.Until(next))
+	}
+}
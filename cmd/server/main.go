@@ -2,18 +2,22 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/server"
-	"github.com/ekzhu/go-fasttext"
-	"github.com/ekzhu/lshensemble"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -21,42 +25,211 @@ var (
 	orgGamma    = flag.Float64("orggamma", 1.0, "Organization gamma parameter")
 	orgWindow   = flag.Int("orgwin", 1001, "Organization termination window size")
 	noJoinIndex = flag.Bool("nojoin", false, "Disable joinable table search")
+	numShards   = flag.Int("shards", 1, "Number of metadata index shards")
+	shardDir    = flag.String("sharddir", "", "Directory to load/save metadata index shards")
+	compactInt  = flag.Duration("compact", time.Hour, "How often to check whether metadata index shards need compacting")
+	compactHrs  = flag.String("compacthours", "2-5", "Hours (local time) during which shards may be compacted")
+	rebuildInt  = flag.Duration("rebuild", 0, "How often to rebuild the metadata index in the background (0 to only rebuild on SIGHUP)")
+	joinRefresh = flag.Duration("joinrefresh", 0, "How often to refresh the joinability index with the datasets sketched again (0 to only refresh on SIGHUP)")
+	attrIndex   = flag.Bool("attrindex", false, "Build the attribute embedding index for semantic join and schema union search")
+	hideSens    = flag.Bool("hidesensitive", false, "Hide the sample values of the columns flagged as holding personal information")
 )
 
 // Containment threshold for joinability index
 const joinabilityThreshold = 0.5
 
+// metadataIndexBackend returns the metadata index backend specification.
+// If OPENDATALINK_INDEX_BACKEND is "tuned", the backend recommended by the
+// tune command is used, or "flat" if the index has not been tuned.
+func metadataIndexBackend(db *database.DB) string {
+	backend := config.IndexBackend()
+	if backend != "tuned" {
+		return backend
+	}
+	t, err := db.IndexTuning("metadata")
+	if err != nil {
+		log.Printf("no tuned metadata index backend, using flat: %v", err)
+		return "flat"
+	}
+	return t.Backend
+}
+
+// buildMetadataIndex builds the metadata index, with an index for each model
+// of the router if it is not nil.
+// If load is true, the shards are loaded from -sharddir if they exist there.
+func buildMetadataIndex(db *database.DB, router *embedding.Router, dim int, load bool) (index.Querier, error) {
+	if router != nil {
+		return index.BuildRoutedMetadataIndex(db, metadataIndexBackend(db), router.Dims())
+	}
+	if *numShards <= 1 && *shardDir == "" {
+		return index.BuildMetadataIndex(db, metadataIndexBackend(db), dim)
+	}
+	if load && *shardDir != "" {
+		idx, err := index.LoadShardedIndex(*shardDir)
+		if err == nil {
+			log.Printf("loaded %d metadata index shards from %s", idx.NumShards(), *shardDir)
+			return idx, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		log.Printf("%v; building the metadata index shards", err)
+	}
+	idx, err := index.BuildShardedMetadataIndex(db, *numShards, dim)
+	if err != nil {
+		return nil, err
+	}
+	if *shardDir != "" {
+		if err := idx.SaveDir(*shardDir); err != nil {
+			idx.Delete()
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
 func main() {
-	flag.Parse()
+	config.ParseFlags()
+	if *numShards < 1 {
+		log.Fatalf("invalid -shards %d: must be at least 1", *numShards)
+	}
 
 	releaseMode := os.Getenv("MODE") == "release"
 	if releaseMode {
 		log.Println("MODE=release")
 	}
 
+	params, err := config.IndexParameters()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	db, err := database.New(config.DatabasePath())
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	ft := fasttext.NewFastText(config.FasttextPath())
-	defer ft.Close()
+	embeddingConfig, err := config.Embedding()
+	if err != nil {
+		log.Fatal(err)
+	}
+	categoryModels, err := config.CategoryModels()
+	if err != nil {
+		log.Fatal(err)
+	}
+	noEmbPolicy, err := config.NoEmbPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// With category models, the metadata index has an index for each model,
+	// and queries are embedded with every model.
+	embedder, versions, err := embedding.OpenWithVersions(embeddingConfig, categoryModels)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer embedder.Close()
+	router, _ := embedder.(*embedding.Router)
+	if router != nil && (*numShards > 1 || *shardDir != "") {
+		log.Fatal("-shards and -sharddir are not supported with category models")
+	}
+	embeddingVersion := versions[""]
+	if err := index.CheckVersions(db, "metadata_vectors", versions); err != nil {
+		log.Fatal(err)
+	}
+	if err := embedding.CheckDim(embedder, params.EmbeddingDim); err != nil {
+		log.Fatal(err)
+	}
 
-	metadataIndex, err := index.BuildMetadataEmbeddingIndex(db)
+	loaded := false
+	metadataIndex, err := index.NewManager("metadata", func() (index.Querier, error) {
+		idx, err := buildMetadataIndex(db, router, params.EmbeddingDim, !loaded)
+		loaded = true
+		return idx, err
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer metadataIndex.Delete()
 	log.Println("built metadata embedding index")
 
-	var joinabilityIndex *lshensemble.LshEnsemble
+	done := make(chan struct{})
+	defer close(done)
+	go metadataIndex.Run(*rebuildInt, done)
+
+	if *numShards > 1 || *shardDir != "" {
+		var start, end int
+		if _, err := fmt.Sscanf(*compactHrs, "%d-%d", &start, &end); err != nil {
+			log.Fatalf("invalid -compacthours %q: %v", *compactHrs, err)
+		}
+		go index.RunCompaction(db, metadataIndex, &index.CompactionConfig{
+			IndexName:         "metadata",
+			Interval:          *compactInt,
+			StartHour:         start,
+			EndHour:           end,
+			MinTombstoneRatio: 0.1,
+		}, done)
+	}
+
+	var joinabilityIndex *index.JoinabilityIndex
 	if !*noJoinIndex {
-		joinabilityIndex, err = index.BuildJoinabilityIndex(db)
+		joinabilityIndex, err = index.BuildJoinabilityIndex(db, params.LSHPartitions, params.LSHMaxK)
 		if err != nil {
 			log.Fatal(err)
 		}
 		log.Println("built joinability index")
 	}
+	joinabilityRefresh := make(chan struct{}, 1)
+	if joinabilityIndex != nil {
+		go refreshJoinabilityIndex(joinabilityIndex, *joinRefresh, joinabilityRefresh, done)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("SIGHUP: rebuilding metadata index and refreshing joinability index")
+			metadataIndex.Trigger()
+			select {
+			case joinabilityRefresh <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	attributeTokenizer, err := config.AttributeTokenizer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var attributeIndex *index.AttributeIndex
+	semanticJoinConfig := index.DefaultSemanticJoinConfig()
+	semanticJoinConfig.AttributeTokenizer = attributeTokenizer
+	if *attrIndex {
+		if err := index.CheckVersions(db, "attribute_vectors", versions); err != nil {
+			log.Fatal(err)
+		}
+		if path := config.AttributeIndexPath(); path != "" {
+			attributeIndex, err = index.BuildOnDiskAttributeIndex(db, params.EmbeddingDim, path)
+		} else {
+			attributeIndex, err = index.BuildAttributeEmbeddingIndex(db, params.EmbeddingDim)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer attributeIndex.Delete()
+		log.Println("built attribute embedding index")
+	}
+
+	// Numeric columns are searchable if sketch_columns sketched them.
+	var numericIndex *index.NumericIndex
+	if ok, err := database.HasTable(db, "numeric_sketches"); err != nil {
+		log.Fatal(err)
+	} else if ok {
+		if numericIndex, err = index.BuildNumericIndex(db); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("built numeric column index over %d columns", numericIndex.Len())
+	}
 
 	orgConf := &navigation.Config{
 		Gamma:                *orgGamma,
@@ -68,11 +241,25 @@ func main() {
 	s, err := server.New(&server.Config{
 		DevMode:              !releaseMode,
 		DB:                   db,
-		FastText:             ft,
+		Embedder:             embedder,
+		EmbeddingVersion:     &embeddingVersion,
+		Router:               router,
 		MetadataIndex:        metadataIndex,
 		JoinabilityThreshold: joinabilityThreshold,
 		JoinabilityIndex:     joinabilityIndex,
+		NumericIndex:         numericIndex,
+		NoEmbPolicy:          noEmbPolicy,
 		OrganizeConfig:       orgConf,
+		EditToken:            config.EditToken(),
+		AdminToken:           config.AdminToken(),
+		BaseURL:              config.BaseURL(),
+		SearchResults:        params.SearchResults,
+		SimilarResults:       params.SimilarResults,
+		CORSOrigins:          config.CORSOrigins(),
+		AttributeIndex:       attributeIndex,
+		SemanticJoinConfig:   semanticJoinConfig,
+		AttributeTokenizer:   attributeTokenizer,
+		HideSensitiveSamples: *hideSens,
 	})
 	if err != nil {
 		log.Fatal(err)
@@ -90,3 +277,30 @@ func main() {
 
 	log.Fatal(http.ListenAndServe(":"+port, s.NewHandler()))
 }
+
+// refreshJoinabilityIndex refreshes idx with the datasets sketched again (see
+// index.JoinabilityIndex.Refresh) every interval, and whenever trigger
+// receives, until done is closed. If interval is zero, idx is only refreshed
+// on trigger.
+func refreshJoinabilityIndex(idx *index.JoinabilityIndex, interval time.Duration, trigger <-chan struct{}, done <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick:
+		case <-trigger:
+		}
+		n, err := idx.Refresh()
+		if err != nil {
+			log.Printf("refreshing joinability index: %v", err)
+		} else if n > 0 {
+			log.Printf("refreshed %d datasets in the joinability index (%d since it was built)", n, idx.Refreshed())
+		}
+	}
+}
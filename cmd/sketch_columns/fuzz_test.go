@@ -0,0 +1,29 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzSketchTable(f *testing.F) {
+	f.Add("name,count\nelm,10\noak,3\n")
+	f.Add("a,b\n\"quoted, value\",\"unterminated\n")
+	f.Add("a,b\n1,2,3\n")
+	f.Add("\n\n")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, data string) {
+		sketch, err := sketchTable(strings.NewReader(data), "abcd-1234")
+		if err != nil || sketch == nil {
+			return
+		}
+		for _, col := range sketch.columnSketches {
+			if len(col.sample) > *sampleValues {
+				t.Errorf("column %q has %d sample values, want at most %d",
+					col.columnName, len(col.sample), *sampleValues)
+			}
+		}
+	})
+}
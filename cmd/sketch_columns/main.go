@@ -1,5 +1,41 @@
 // Command sketch_columns sketches dataset columns and stores the sketches in
 // the Open Data Link database.
+//
+// The columns of a dataset are read from its rows.csv file or, if it has none,
+// its gzip (rows.csv.gz) or zip (rows.csv.zip) compressed version, which is
+// decompressed as it is read, or else from the CSV files of its rows
+// directory, if it is partitioned (see package partition).
+//
+// If the database has a sketch_versions table, the hash of each dataset file
+// is recorded with a version of its sketches, and only the datasets whose
+// files changed since they were last sketched are sketched again, replacing
+// their previous sketches and profiles. If it has a dataset_checksums table,
+// the checksums of the files are recorded too, and only the datasets whose
+// files changed since they were last sketched are read with -changed-only
+// (see package checksum).
+//
+// The columns of a table with more than -samplerows rows are sketched and
+// profiled from a uniform random sample of -samplerows of its rows, chosen by
+// reservoir sampling as the table is read, so that very large tables are
+// sketched in bounded time and memory; their distinct counts and uniqueness
+// are then those of the sample. The -samplevalues sample values of each
+// column, which process_values embeds, are a uniform random sample of its
+// (sampled) values. The samples of a dataset are the same every time it is
+// sketched.
+//
+// If the database has a sensitive_columns table, the columns whose sample
+// values hold personal information, such as emails or phone numbers, are
+// flagged in it (see package pii).
+//
+// The datasets that cannot be read or written, such as files with malformed
+// rows, are skipped, and their failures reported (see package failures).
+//
+// The sketches are committed in chunks of datasets, retried while the
+// database is locked by another connection, such as a running server (see
+// database.Chunker). With -dry-run, the datasets are sketched and the
+// sketches written in transactions that are rolled back, so that the datasets
+// are validated, and what would be stored reported, without changing the
+// database.
 package main
 
 import (
@@ -10,33 +46,88 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"math"
 	"os"
-	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/checksum"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coldomain"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/hooks"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/layout"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/numsketch"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/partition"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/pii"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/progress"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/reservoir"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/runsummary"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/spatial"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/temporal"
 	"github.com/axiomhq/hyperloglog"
 	"github.com/ekzhu/lshensemble"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Layout of the datasets (see config.DatasetLayout), set by main.
+var datasets *layout.Layout
+
+// Ingestion hooks (see package hooks), set by main.
+var datasetHooks *hooks.Hooks
+
+// Checksums of the dataset files (see package checksum), set by main.
+var checksums *checksum.Tracker
+
 const (
-	datasetsDir = "datasets"
 	// Minhash parameters
 	mhSeed = 42
 	mhSize = 256
-	// Number of sample data values
-	sampleSize = 20
 	// Number of worker goroutines
 	numWorkers = 16
+	// Number of datasets whose sketches are committed together
+	datasetsPerCommit = 100
+	// Dimension of the n-gram embeddings of the column names compared with
+	// the names of the column domains
+	domainEmbeddingDim = 256
 )
 
 type tableSketch struct {
 	datasetID      string
 	columnSketches []*columnSketch
+	// Sampler of the rows whose values are the sample values of the
+	// columns.
+	values *reservoir.Sampler
+	// Sampler of the rows sketched and the sample of rows, if -samplerows
+	// is set.
+	rows    *reservoir.Sampler
+	sampled [][]string
+	// SHA-256 hash of the dataset file, if sketch versions are recorded.
+	contentHash string
+}
+
+// sketchVersion is the recorded version of the sketches of a dataset.
+type sketchVersion struct {
+	contentHash string
+	version     int64
+}
+
+// Tables of the sketches and profiles of the columns written by
+// sketch_columns, which are replaced when a dataset is sketched again.
+var sketchTables = []string{
+	"column_sketches",
+	"numeric_sketches",
+	"column_types",
+	"column_uniqueness",
+	"column_domains",
+	"time_columns",
+	"spatial_columns",
+	"sensitive_columns",
 }
 
 func (s *tableSketch) update(record []string) {
@@ -46,44 +137,161 @@ func (s *tableSketch) update(record []string) {
 				columnName:  v,
 				minhash:     lshensemble.NewMinhash(mhSeed, mhSize),
 				hyperloglog: hyperloglog.New(),
-				sample:      make([]string, 0, sampleSize),
+				numeric:     numsketch.NewBuilder(),
+				sample:      make([]string, 0, *sampleValues),
 			})
 		}
-	} else {
-		for i, v := range record {
-			s.columnSketches[i].update(v)
+		return
+	}
+	if s.rows == nil {
+		s.add(record)
+		return
+	}
+	// The record is reused by the CSV reader.
+	if slot := s.rows.Offer(); slot == len(s.sampled) {
+		s.sampled = append(s.sampled, append([]string(nil), record...))
+	} else if slot >= 0 {
+		s.sampled[slot] = append(s.sampled[slot][:0], record...)
+	}
+}
+
+// add adds the values of a row to the sketches of the columns.
+func (s *tableSketch) add(record []string) {
+	slot := s.values.Offer()
+	for i, v := range record {
+		col := s.columnSketches[i]
+		col.update(v)
+		if slot == len(col.sample) {
+			col.sample = append(col.sample, v)
+		} else if slot >= 0 {
+			col.sample[slot] = v
 		}
 	}
 }
 
+// finish adds the sampled rows to the sketches of the columns once the table
+// is read, if rows are sampled.
+func (s *tableSketch) finish() {
+	for _, record := range s.sampled {
+		s.add(record)
+	}
+	s.sampled = nil
+}
+
 type columnSketch struct {
 	columnName  string
 	minhash     *lshensemble.Minhash
 	hyperloglog *hyperloglog.Sketch
+	numeric     *numsketch.Builder
+	types       coltype.Inferrer
+	domains     coldomain.Detector
+	times       temporal.Profiler
+	spatial     spatial.Profiler
 	sample      []string
+	// Number of values, including blank values.
+	rows int64
+	// Domain of the column, classified when the sketch is written.
+	domain coldomain.Domain
 }
 
 func (s *columnSketch) update(v string) {
+	s.rows++
 	if v != "" {
 		b := []byte(v)
 		s.minhash.Push(b)
 		s.hyperloglog.Insert(b)
+		s.numeric.Add(v)
+		s.types.Add(v)
+		s.domains.Add(v)
+		s.times.Add(v)
+		s.spatial.Add(v)
+	}
+}
+
+// uniqueness returns the estimated number of distinct values of the column
+// divided by its number of rows, at most 1, or 0 if it has no rows. Blank
+// values count as rows but not as distinct values, so columns with blanks are
+// not unique.
+func (s *columnSketch) uniqueness() float64 {
+	if s.rows == 0 {
+		return 0
+	}
+	return math.Min(1, float64(s.hyperloglog.Estimate())/float64(s.rows))
+}
+
+// rowsFile is the CSV file of the rows of a dataset, or the directory of its
+// partitions.
+type rowsFile struct {
+	path  string
+	parts []*partition.Part
+}
+
+// findRows returns the rows file of the dataset with the given ID, returning
+// an error for which errors.Is(err, os.ErrNotExist) is true if it has none.
+func findRows(datasetID string) (*rowsFile, error) {
+	path, err := datasets.Path(datasetID, "rows.csv")
+	if err == nil {
+		if path, err = datafile.Find(path); err == nil {
+			return &rowsFile{path: path}, nil
+		}
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
 	}
+	dir, derr := datasets.Path(datasetID, "rows")
+	if derr != nil {
+		return nil, err
+	}
+	if info, derr := os.Stat(dir); derr != nil || !info.IsDir() {
+		return nil, err
+	}
+	parts, err := partition.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	if parts[0].Name != "rows.csv" {
+		return nil, fmt.Errorf("partitions of %v are not CSV files: %w", dir, os.ErrNotExist)
+	}
+	return &rowsFile{path: dir, parts: parts}, nil
+}
 
-	if len(s.sample) < sampleSize {
-		s.sample = append(s.sample, v)
+// hash returns the SHA-256 hash of the file, or of the partitions, in hex.
+func (f *rowsFile) hash() (string, error) {
+	if f.parts != nil {
+		return partition.Hash(f.path, f.parts)
 	}
+	return checksum.File(f.path)
 }
 
-func sketchDataset(path, datasetID string) (*tableSketch, error) {
-	csvfile, err := os.Open(path)
+// open opens the file of the dataset with the given ID, merging its
+// partitions if it is partitioned.
+func (f *rowsFile) open(datasetID string) (io.ReadCloser, error) {
+	if f.parts == nil {
+		return datasetHooks.Open(datasetID, "rows.csv", f.path)
+	}
+	return partition.CSV(f.parts, func(p *partition.Part) (io.ReadCloser, error) {
+		return datasetHooks.Open(datasetID, p.Name, p.Path)
+	})
+}
+
+func sketchDataset(f *rowsFile, datasetID string) (*tableSketch, error) {
+	csvfile, err := f.open(datasetID)
 	if err != nil {
 		return nil, fmt.Errorf("error sketching %v: %w", datasetID, err)
 	}
 	defer csvfile.Close()
+	return sketchTable(csvfile, datasetID)
+}
 
-	sketch := tableSketch{datasetID: datasetID}
-	r := csv.NewReader(csvfile)
+// sketchTable sketches the columns of the CSV table read from in.
+// It returns nil if the table is empty.
+func sketchTable(in io.Reader, datasetID string) (*tableSketch, error) {
+	seed := reservoir.Seed(datasetID)
+	sketch := tableSketch{datasetID: datasetID, values: reservoir.New(*sampleValues, seed)}
+	if *sampleRows > 0 {
+		sketch.rows = reservoir.New(*sampleRows, seed+1)
+	}
+	r := csv.NewReader(in)
 	r.LazyQuotes = true
 	r.ReuseRecord = true
 
@@ -97,53 +305,411 @@ func sketchDataset(path, datasetID string) (*tableSketch, error) {
 		}
 		sketch.update(record)
 	}
+	sketch.finish()
 	if sketch.columnSketches == nil {
 		return nil, nil
 	}
 	return &sketch, nil
 }
 
-func writeSketch(stmt *sql.Stmt, sketch *tableSketch) error {
-	for i, col := range sketch.columnSketches {
-		sample, err := json.Marshal(col.sample)
+// sketchWriter writes table sketches to the database. The optional
+// statements are nil if their tables do not exist.
+type sketchWriter struct {
+	// Statements inserting into column_sketches, numeric_sketches,
+	// column_types, column_uniqueness, column_domains, time_columns,
+	// spatial_columns, and sensitive_columns.
+	columns, numeric, types, uniqueness, domains, times, spatial, sensitive *sql.Stmt
+	// Classifier of the column domains, if domains or spatial is not nil.
+	classifier *coldomain.Classifier
+	// Statement inserting into sketch_versions, the statements deleting the
+	// previous rows of a dataset from the existing sketchTables, and the
+	// previous versions, if sketch versions are recorded.
+	versions *sql.Stmt
+	deletes  []*sql.Stmt
+	previous map[string]sketchVersion
+}
+
+// prepare prepares the statements of the writer in tx, those of the tables
+// that exist.
+func (w *sketchWriter) prepare(tx *sql.Tx) error {
+	var err error
+	w.deletes = nil
+	w.columns, err = tx.Prepare(`
+	INSERT INTO column_sketches
+	(column_id, dataset_id, column_name, distinct_count, minhash, sample)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Numeric columns are sketched if the numeric_sketches table exists.
+	if ok, err := database.HasTable(tx, "numeric_sketches"); err != nil {
+		return err
+	} else if ok {
+		w.numeric, err = tx.Prepare(`
+		INSERT INTO numeric_sketches
+		(column_id, dataset_id, count, min, max, mean, quantiles, histogram)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`)
 		if err != nil {
-			return fmt.Errorf("error writing sketch %v: %v", sketch.datasetID, err)
+			return err
 		}
-		_, err = stmt.Exec(
-			fmt.Sprint(sketch.datasetID, "-", i),
-			sketch.datasetID,
-			col.columnName,
-			col.hyperloglog.Estimate(),
-			lshensemble.SigToBytes(col.minhash.Signature()),
-			sample)
+	}
+	// Column types are inferred if the column_types table exists.
+	if ok, err := database.HasTable(tx, "column_types"); err != nil {
+		return err
+	} else if ok {
+		w.types, err = tx.Prepare(`
+		INSERT INTO column_types (column_id, dataset_id, column_type)
+		VALUES (?, ?, ?)
+		`)
 		if err != nil {
+			return err
+		}
+	}
+	// Column uniqueness is profiled if the column_uniqueness table exists.
+	if ok, err := database.HasTable(tx, "column_uniqueness"); err != nil {
+		return err
+	} else if ok {
+		w.uniqueness, err = tx.Prepare(`
+		INSERT INTO column_uniqueness (column_id, dataset_id, row_count, uniqueness)
+		VALUES (?, ?, ?, ?)
+		`)
+		if err != nil {
+			return err
+		}
+	}
+	// Column domains are classified if the column_domains table exists.
+	if ok, err := database.HasTable(tx, "column_domains"); err != nil {
+		return err
+	} else if ok {
+		w.domains, err = tx.Prepare(`
+		INSERT INTO column_domains (column_id, dataset_id, domain)
+		VALUES (?, ?, ?)
+		`)
+		if err != nil {
+			return err
+		}
+	}
+	// Time columns are profiled if the time_columns table exists.
+	if ok, err := database.HasTable(tx, "time_columns"); err != nil {
+		return err
+	} else if ok {
+		w.times, err = tx.Prepare(`
+		INSERT INTO time_columns (column_id, dataset_id, granularity, min_time, max_time)
+		VALUES (?, ?, ?, ?, ?)
+		`)
+		if err != nil {
+			return err
+		}
+	}
+	// Spatial columns are profiled if the spatial_columns table exists.
+	if ok, err := database.HasTable(tx, "spatial_columns"); err != nil {
+		return err
+	} else if ok {
+		w.spatial, err = tx.Prepare(`
+		INSERT INTO spatial_columns
+		(column_id, dataset_id, kind, longitude_column_id,
+			min_lat, min_long, max_lat, max_long, regions)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`)
+		if err != nil {
+			return err
+		}
+	}
+	// Columns with personal information are flagged if the
+	// sensitive_columns table exists.
+	if ok, err := database.HasTable(tx, "sensitive_columns"); err != nil {
+		return err
+	} else if ok {
+		w.sensitive, err = tx.Prepare(`
+		INSERT INTO sensitive_columns (column_id, dataset_id, kinds)
+		VALUES (?, ?, ?)
+		`)
+		if err != nil {
+			return err
+		}
+	}
+	// Versions are recorded if the sketch_versions table exists, and the
+	// previous sketches of the datasets sketched again are deleted.
+	if w.previous != nil {
+		w.versions, err = tx.Prepare(`
+		INSERT OR REPLACE INTO sketch_versions (dataset_id, content_hash, version)
+		VALUES (?, ?, ?)
+		`)
+		if err != nil {
+			return err
+		}
+		for _, table := range sketchTables {
+			if ok, err := database.HasTable(tx, table); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			stmt, err := tx.Prepare(`DELETE FROM ` + table + ` WHERE dataset_id = ?`)
+			if err != nil {
+				return err
+			}
+			w.deletes = append(w.deletes, stmt)
+		}
+	}
+	return nil
+}
+
+// write writes the column sketches of a table, the numeric sketches of its
+// numeric columns, the inferred types, uniqueness, and domains of its
+// columns, the extents of its time and spatial columns, and the kinds of
+// personal information of its sensitive columns.
+func (w *sketchWriter) write(sketch *tableSketch) error {
+	for _, stmt := range w.deletes {
+		if _, err := stmt.Exec(sketch.datasetID); err != nil {
 			return fmt.Errorf("error writing sketch %v: %v", sketch.datasetID, err)
 		}
 	}
+	for i, col := range sketch.columnSketches {
+		if err := w.writeColumn(sketchColumnID(sketch, i), sketch.datasetID, col); err != nil {
+			return fmt.Errorf("error writing sketch %v: %v", sketch.datasetID, err)
+		}
+	}
+	if w.spatial != nil {
+		if err := w.writeSpatial(sketch); err != nil {
+			return fmt.Errorf("error writing sketch %v: %v", sketch.datasetID, err)
+		}
+	}
+	if w.versions != nil {
+		version := w.previous[sketch.datasetID].version + 1
+		if _, err := w.versions.Exec(sketch.datasetID, sketch.contentHash, version); err != nil {
+			return fmt.Errorf("error writing sketch %v: %v", sketch.datasetID, err)
+		}
+	}
+	return nil
+}
+
+func sketchColumnID(sketch *tableSketch, i int) string {
+	return fmt.Sprint(sketch.datasetID, "-", i)
+}
+
+// writeSpatial writes the extents of the spatial columns of a table: its
+// point, geohash, and administrative code columns, and its first latitude
+// column paired with its first longitude column.
+func (w *sketchWriter) writeSpatial(sketch *tableSketch) error {
+	lat, long := -1, -1
+	for i, col := range sketch.columnSketches {
+		switch col.domain {
+		case coldomain.Latitude:
+			if lat < 0 {
+				lat = i
+			}
+		case coldomain.Longitude:
+			if long < 0 {
+				long = i
+			}
+		default:
+			if e := col.spatial.Extent(col.domain); e != nil {
+				if err := w.writeExtent(sketchColumnID(sketch, i), sketch.datasetID, "", e); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if lat < 0 || long < 0 {
+		return nil
+	}
+	e := spatial.LatLongExtent(
+		sketch.columnSketches[lat].numeric.Sketch(), sketch.columnSketches[long].numeric.Sketch())
+	if e == nil {
+		return nil
+	}
+	return w.writeExtent(sketchColumnID(sketch, lat), sketch.datasetID, sketchColumnID(sketch, long), e)
+}
+
+func (w *sketchWriter) writeExtent(columnID, datasetID, longitudeColumnID string, e *spatial.Extent) error {
+	if !e.Kind.Boxed() {
+		regions, err := json.Marshal(e.Regions)
+		if err != nil {
+			return err
+		}
+		_, err = w.spatial.Exec(columnID, datasetID, e.Kind, nil, nil, nil, nil, nil, regions)
+		return err
+	}
+	var longID interface{}
+	if longitudeColumnID != "" {
+		longID = longitudeColumnID
+	}
+	_, err := w.spatial.Exec(columnID, datasetID, e.Kind, longID,
+		e.Box.MinLat, e.Box.MinLong, e.Box.MaxLat, e.Box.MaxLong, nil)
+	return err
+}
+
+func (w *sketchWriter) writeColumn(columnID, datasetID string, col *columnSketch) error {
+	sample, err := json.Marshal(col.sample)
+	if err != nil {
+		return err
+	}
+	_, err = w.columns.Exec(
+		columnID,
+		datasetID,
+		col.columnName,
+		col.hyperloglog.Estimate(),
+		lshensemble.SigToBytes(col.minhash.Signature()),
+		sample)
+	if err != nil {
+		return err
+	}
+	if err := writeNumericSketch(w.numeric, columnID, datasetID, col.numeric.Sketch()); err != nil {
+		return err
+	}
+	if w.types != nil {
+		if _, err := w.types.Exec(columnID, datasetID, col.types.Type()); err != nil {
+			return err
+		}
+	}
+	if w.uniqueness != nil {
+		if _, err := w.uniqueness.Exec(columnID, datasetID, col.rows, col.uniqueness()); err != nil {
+			return err
+		}
+	}
+	if w.classifier != nil {
+		if col.domain, err = w.classifier.Classify(col.columnName, col.types.Type(), &col.domains); err != nil {
+			return err
+		}
+	}
+	if w.domains != nil && col.domain != coldomain.None {
+		if _, err := w.domains.Exec(columnID, datasetID, col.domain); err != nil {
+			return err
+		}
+	}
+	if w.sensitive != nil {
+		if kinds := pii.Detect(col.sample); len(kinds) > 0 {
+			if _, err := w.sensitive.Exec(columnID, datasetID, pii.Format(kinds)); err != nil {
+				return err
+			}
+		}
+	}
+	if e := col.times.Extent(); w.times != nil && e != nil && col.types.Type() == coltype.Date {
+		_, err := w.times.Exec(columnID, datasetID, e.Granularity.String(),
+			e.Min.Format(database.TimeLayout), e.Max.Format(database.TimeLayout))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func sketchWorker(jobs <-chan string, out chan<- *tableSketch) {
+// writeNumericSketch writes the numeric sketch s of a column with stmt, unless
+// either is nil.
+func writeNumericSketch(stmt *sql.Stmt, columnID, datasetID string, s *numsketch.Sketch) error {
+	if stmt == nil || s == nil {
+		return nil
+	}
+	quantiles, err := json.Marshal(s.Quantiles)
+	if err != nil {
+		return err
+	}
+	histogram, err := json.Marshal(s.Histogram)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(columnID, datasetID, s.Count, s.Min, s.Max, s.Mean, quantiles, histogram)
+	return err
+}
+
+// sketchWorker sketches the datasets with the IDs received from jobs, counting
+// the datasets that cannot be read in p, and recording those that exist in
+// fails. If previous is not nil, the datasets whose files have the hashes of
+// their previous versions are skipped.
+func sketchWorker(jobs <-chan string, out chan<- *tableSketch, previous map[string]sketchVersion, p *progress.Reporter, fails *failures.Recorder) {
 	for datasetID := range jobs {
+		if changed, err := checksums.Changed(datasetID); err != nil || !changed {
+			if err != nil {
+				fails.Fail(datasetID, err)
+				p.Error()
+			}
+			out <- nil
+			continue
+		}
+		rows, err := findRows(datasetID)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				log.Printf("error sketching %v: %v", datasetID, err)
+			} else {
+				fails.Fail(datasetID, err)
+			}
+			p.Error()
+			out <- nil
+			continue
+		}
+		var hash string
+		if previous != nil {
+			if hash, err = rows.hash(); err != nil {
+				fails.Fail(datasetID, err)
+				p.Error()
+				out <- nil
+				continue
+			}
+			if v, ok := previous[datasetID]; ok && v.contentHash == hash {
+				out <- nil
+				continue
+			}
+		}
 		log.Println("sketching", datasetID)
-		path := filepath.Join(datasetsDir, datasetID, "rows.csv")
-		sketch, err := sketchDataset(path, datasetID)
+		sketch, err := sketchDataset(rows, datasetID)
 		if err != nil {
-			if errors.Is(err, os.ErrNotExist) || errors.Is(err, csv.ErrFieldCount) {
+			if errors.Is(err, os.ErrNotExist) {
 				log.Println(err)
 			} else {
-				log.Fatal(err)
+				fails.Fail(datasetID, err)
 			}
+			p.Error()
+		}
+		if sketch != nil {
+			sketch.contentHash = hash
 		}
 		out <- sketch
 	}
 }
 
+// loadSketchVersions returns the recorded sketch versions of the datasets, or
+// nil if the database has no sketch_versions table.
+func loadSketchVersions(db *sql.DB) (map[string]sketchVersion, error) {
+	if ok, err := database.HasTable(db, "sketch_versions"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT dataset_id, content_hash, version FROM sketch_versions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[string]sketchVersion)
+	for rows.Next() {
+		var datasetID string
+		var v sketchVersion
+		if err := rows.Scan(&datasetID, &v.contentHash, &v.version); err != nil {
+			return nil, err
+		}
+		versions[datasetID] = v
+	}
+	return versions, rows.Err()
+}
+
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to `file`")
 var memprofile = flag.String("memprofile", "", "write memory profile to `file`")
+var dryRun = flag.Bool("dry-run", false, "sketch the datasets without writing to the database, reporting what would be stored")
+var sampleRows = flag.Int("samplerows", 0, "sketch the columns of tables with more rows from a random sample of this many rows (0 for all rows)")
+var sampleValues = flag.Int("samplevalues", 20, "number of sample values of each column")
 
 func main() {
-	flag.Parse()
+	config.ParseFlags()
+	summary := runsummary.Start("sketch_columns", "datasets", *dryRun)
+	var err error
+	if datasets, err = config.DatasetLayout(); err != nil {
+		log.Fatal(err)
+	}
+	if *sampleRows < 0 || *sampleValues < 1 {
+		log.Fatal("-samplerows must not be negative and -samplevalues must be positive")
+	}
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -156,50 +722,118 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	files, err := ioutil.ReadDir(datasetsDir)
+	db, err := sql.Open("sqlite3", config.DatabasePath())
 	if err != nil {
 		log.Fatal(err)
 	}
-	jobs := make(chan string, len(files))
-	out := make(chan *tableSketch, len(files))
+	defer db.Close()
 
-	for i := 0; i < numWorkers; i++ {
-		go sketchWorker(jobs, out)
+	previous, err := loadSketchVersions(db)
+	if err != nil {
+		log.Fatal(err)
 	}
-	for _, f := range files {
-		jobs <- f.Name()
+	ids, err := datasets.IDs()
+	if err != nil {
+		log.Fatal(err)
 	}
-	close(jobs)
-
-	db, err := sql.Open("sqlite3", config.DatabasePath())
+	jobs := make(chan string, len(ids))
+	out := make(chan *tableSketch, len(ids))
+	fails, err := failures.Start("sketch_columns")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
-
-	tx, err := db.Begin()
+	datasetHooks = hooks.Start("sketch_columns", fails, *dryRun)
+	if checksums, err = checksum.Start(db, datasets, "sketch_columns", "rows.csv", "rows"); err != nil {
+		log.Fatal(err)
+	}
+	p, err := progress.Start("datasets", len(ids))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	insertStmt, err := tx.Prepare(`
-	INSERT INTO column_sketches
-	(column_id, dataset_id, column_name, distinct_count, minhash, sample)
-	VALUES (?, ?, ?, ?, ?, ?)
-	`)
+	for i := 0; i < numWorkers; i++ {
+		go sketchWorker(jobs, out, previous, p, fails)
+	}
+	for _, id := range ids {
+		jobs <- id
+	}
+	close(jobs)
+
+	// The sketches are committed in chunks of datasets, so that a running
+	// server is not locked out of the database, with their statements
+	// prepared for each chunk.
+	w := &sketchWriter{previous: previous}
+	chunk := &database.Chunker{
+		DB:        db,
+		Size:      datasetsPerCommit,
+		Prepare:   w.prepare,
+		Rollback:  *dryRun,
+		Committed: datasetHooks.Committed,
+	}
+	// Column domains are classified if the column_domains table exists,
+	// and are also needed to find the spatial columns.
+	domains, err := database.HasTable(db, "column_domains")
+	if err != nil {
+		log.Fatal(err)
+	}
+	spatialColumns, err := database.HasTable(db, "spatial_columns")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer insertStmt.Close()
+	if domains || spatialColumns {
+		tokenizer, err := config.AttributeTokenizer()
+		if err != nil {
+			log.Fatal(err)
+		}
+		w.classifier, err = coldomain.NewClassifier(embedding.NewNGram(domainEmbeddingDim), tokenizer)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	for range files {
+	var sketched, columns int
+	for range ids {
 		if sketch := <-out; sketch != nil {
-			if err := writeSketch(insertStmt, sketch); err != nil {
+			// The sketches of a dataset are written in a savepoint, so
+			// that a dataset that cannot be written is skipped.
+			err := chunk.Write(func(tx *sql.Tx) error {
+				if err := w.write(sketch); err != nil {
+					return err
+				}
+				return checksums.Store(tx, sketch.datasetID)
+			})
+			if err != nil {
+				fails.Fail(sketch.datasetID, err)
+				p.Error()
+			} else {
+				sketched++
+				columns += len(sketch.columnSketches)
+				datasetHooks.Stored(sketch.datasetID)
+			}
+			if err := chunk.Done(); err != nil {
 				log.Fatal(err)
 			}
 		}
+		p.Done(1)
+	}
+	if err := chunk.Close(); err != nil {
+		log.Fatal(err)
+	}
+	rep := p.Stop()
+	if *dryRun {
+		log.Printf("dry run: would store the sketches of %d columns of %d datasets", columns, sketched)
+	}
+	if previous != nil || checksum.ChangedOnly() {
+		log.Printf("sketched %d datasets; %d were unchanged, missing, empty, or failed", sketched, len(ids)-sketched)
+	}
+	if err := fails.Finish(db, *dryRun); err != nil {
+		log.Fatal(err)
+	}
+	summary.Skipped = len(ids) - sketched - fails.Datasets()
+	summary.Count("columns", columns)
+	if err := summary.Finish(db, rep, fails); err != nil {
+		log.Fatal(err)
 	}
-	tx.Commit()
 
 	if *memprofile != "" {
 		f, err := os.Create(*memprofile)
@@ -0,0 +1,268 @@
+// Command soak is a soak test for a running Open Data Link server.
+//
+// It sends a random mix of page and API requests, including malformed ones,
+// from concurrent clients for the given duration and reports the response
+// status counts and latencies. The dataset and column IDs used in requests are
+// sampled from the Open Data Link database.
+// It exits with a non-zero status if any request failed with a server error.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	baseURL     = flag.String("url", "http://localhost:8080", "base URL of the server")
+	duration    = flag.Duration("duration", time.Minute, "how long to run")
+	concurrency = flag.Int("concurrency", 8, "number of concurrent clients")
+	timeout     = flag.Duration("timeout", 30*time.Second, "request timeout")
+)
+
+// Number of dataset and column IDs sampled from the database.
+const sampleSize = 1000
+
+type corpus struct {
+	datasetIDs []string
+	columnIDs  []string
+	words      []string
+}
+
+func sample(db *database.DB, query string) ([]string, error) {
+	rows, err := db.Query(query, sampleSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		// Keep the request generators working on an empty database.
+		values = []string{"abcd-1234"}
+	}
+	return values, nil
+}
+
+func loadCorpus(db *database.DB) (*corpus, error) {
+	var c corpus
+	var err error
+
+	if c.datasetIDs, err = sample(db, `
+	SELECT dataset_id FROM metadata ORDER BY RANDOM() LIMIT ?`); err != nil {
+		return nil, err
+	}
+	if c.columnIDs, err = sample(db, `
+	SELECT column_id FROM column_sketches ORDER BY RANDOM() LIMIT ?`); err != nil {
+		return nil, err
+	}
+	names, err := sample(db, `
+	SELECT name FROM metadata ORDER BY RANDOM() LIMIT ?`)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		c.words = append(c.words, strings.Fields(name)...)
+	}
+	if len(c.words) == 0 {
+		c.words = []string{"data"}
+	}
+	return &c, nil
+}
+
+func pick(r *rand.Rand, s []string) string {
+	return s[r.Intn(len(s))]
+}
+
+// garbage returns a random string that is unlikely to be a valid parameter.
+func garbage(r *rand.Rand) string {
+	b := make([]byte, r.Intn(64))
+	for i := range b {
+		b[i] = byte(r.Intn(256))
+	}
+	return string(b)
+}
+
+type request struct {
+	method string
+	path   string
+	body   string
+}
+
+// randomRequest returns a random request to one of the server's endpoints.
+func (c *corpus) randomRequest(r *rand.Rand) request {
+	q := url.QueryEscape
+	dataset := pick(r, c.datasetIDs)
+	column := pick(r, c.columnIDs)
+	if r.Intn(10) == 0 {
+		dataset, column = garbage(r), garbage(r)
+	}
+	query := pick(r, c.words) + " " + pick(r, c.words)
+	if r.Intn(10) == 0 {
+		query = garbage(r)
+	}
+
+	switch r.Intn(12) {
+	case 0:
+		return request{"GET", "/search?q=" + q(query), ""}
+	case 1:
+		return request{"GET", "/dataset/" + url.PathEscape(dataset), ""}
+	case 2:
+		return request{"GET", "/similar-datasets?id=" + q(dataset), ""}
+	case 3:
+		return request{"GET", "/joinable-columns?id=" + q(column), ""}
+	case 4:
+		return request{"GET", "/unionable-tables?id=" + q(dataset), ""}
+	case 5:
+		return request{"GET", "/api/v1/search?q=" + q(query) + "&q=" + q(pick(r, c.words)), ""}
+	case 6:
+		return request{"GET", "/api/v1/datasets/" + url.PathEscape(dataset), ""}
+	case 7:
+		minSim := []string{"0.9", "0.5", "-2", "NaN", garbage(r)}[r.Intn(5)]
+		return request{"GET", "/api/v1/similar-datasets?id=" + q(dataset) + "&min_similarity=" + q(minSim), ""}
+	case 8:
+		return request{"GET", "/api/v1/joinable-columns?id=" + q(column), ""}
+	case 9:
+		return request{"GET", "/widget/search?format=json&limit=" + q(garbage(r)) + "&q=" + q(query), ""}
+	case 10:
+		// Unauthorized edits must be rejected without side effects.
+		body := []string{`{"name": "soak"}`, `{"categories": ["a,b"]}`, garbage(r)}[r.Intn(3)]
+		return request{"PATCH", "/api/v1/datasets/" + url.PathEscape(dataset), body}
+	default:
+		return request{"GET", "/sitemap.xml", ""}
+	}
+}
+
+type stats struct {
+	mu        sync.Mutex
+	status    map[int]int
+	errs      int
+	latencies []time.Duration
+	failures  []string
+}
+
+func (s *stats) record(req request, status int, err error, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies = append(s.latencies, d)
+	if err != nil {
+		s.errs++
+	} else {
+		s.status[status]++
+	}
+	if (err != nil || status >= 500) && len(s.failures) < 20 {
+		msg := fmt.Sprint(status)
+		if err != nil {
+			msg = err.Error()
+		}
+		s.failures = append(s.failures, fmt.Sprintf("%s %s: %s", req.method, req.path, msg))
+	}
+}
+
+func (s *stats) percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	return s.latencies[int(p*float64(len(s.latencies)-1))]
+}
+
+func client(c *corpus, s *stats, seed int64, deadline time.Time, wg *sync.WaitGroup) {
+	defer wg.Done()
+	r := rand.New(rand.NewSource(seed))
+	hc := &http.Client{Timeout: *timeout}
+
+	for time.Now().Before(deadline) {
+		req := c.randomRequest(r)
+		httpReq, err := http.NewRequest(req.method, *baseURL+req.path, strings.NewReader(req.body))
+		if err != nil {
+			log.Fatal(err)
+		}
+		start := time.Now()
+		resp, err := hc.Do(httpReq)
+		status := 0
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			status = resp.StatusCode
+		}
+		s.record(req, status, err, time.Since(start))
+	}
+}
+
+func main() {
+	config.ParseFlags()
+
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	c, err := loadCorpus(db)
+	db.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := &stats{status: make(map[int]int)}
+	deadline := time.Now().Add(*duration)
+	seed := time.Now().UnixNano()
+	var wg sync.WaitGroup
+
+	log.Printf("soaking %s for %v with %d clients (seed %d)", *baseURL, *duration, *concurrency, seed)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go client(c, s, seed+int64(i), deadline, &wg)
+	}
+	wg.Wait()
+
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	var codes []int
+	serverErrors := s.errs
+	for code, n := range s.status {
+		codes = append(codes, code)
+		if code >= 500 {
+			serverErrors += n
+		}
+	}
+	sort.Ints(codes)
+
+	fmt.Printf("requests: %d (%.1f/s)\n", len(s.latencies), float64(len(s.latencies))/duration.Seconds())
+	for _, code := range codes {
+		fmt.Printf("  %d: %d\n", code, s.status[code])
+	}
+	if s.errs > 0 {
+		fmt.Printf("  transport errors: %d\n", s.errs)
+	}
+	fmt.Printf("latency: p50 %v, p95 %v, p99 %v, max %v\n",
+		s.percentile(0.5), s.percentile(0.95), s.percentile(0.99), s.percentile(1))
+	for _, f := range s.failures {
+		fmt.Println("failed:", f)
+	}
+	if serverErrors > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,147 @@
+// Command synth generates a synthetic corpus for performance testing.
+//
+// By default, it writes the datasets to the datasets directory in the layout of
+// the crawler, to be processed by process_metadata and sketch_columns like
+// real datasets. With -vectors, it instead inserts the dataset metadata and
+// random clustered metadata vectors directly into the Open Data Link database,
+// for testing the indexes at scale without fastText.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/synth"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	cfg       = synth.DefaultConfig()
+	dir       = flag.String("dir", "", "directory to write datasets to (default: the -datasets directory)")
+	vectors   = flag.Bool("vectors", false, "insert metadata and random vectors into the database instead of writing datasets")
+	clusters  = flag.Int("clusters", 100, "number of clusters of the random vectors")
+	batchSize = flag.Int("batch", 10000, "number of datasets inserted per transaction with -vectors")
+)
+
+func init() {
+	flag.IntVar(&cfg.Datasets, "n", cfg.Datasets, "number of datasets")
+	flag.IntVar(&cfg.MinColumns, "mincols", cfg.MinColumns, "minimum number of columns per dataset")
+	flag.IntVar(&cfg.MaxColumns, "maxcols", cfg.MaxColumns, "maximum number of columns per dataset")
+	flag.IntVar(&cfg.MinRows, "minrows", cfg.MinRows, "minimum number of rows per dataset")
+	flag.IntVar(&cfg.MaxRows, "maxrows", cfg.MaxRows, "maximum number of rows per dataset")
+	flag.IntVar(&cfg.Domains, "domains", cfg.Domains, "number of shared column value domains")
+	flag.IntVar(&cfg.DomainSize, "domainsize", cfg.DomainSize, "number of distinct values per domain")
+	flag.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed")
+}
+
+func insertVectors(g *synth.Generator, dim int) error {
+	encoding, err := config.VectorEncoding()
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", config.DatabasePath())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	r := rand.New(rand.NewSource(cfg.Seed))
+	vecs := synth.Vectors(r, cfg.Datasets, dim, *clusters)
+
+	for start := 0; start < cfg.Datasets; start += *batchSize {
+		end := start + *batchSize
+		if end > cfg.Datasets {
+			end = cfg.Datasets
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		metadataStmt, err := tx.Prepare(`
+		INSERT INTO metadata (
+			dataset_id,
+			name,
+			description,
+			attribution,
+			contact_email,
+			updated_at,
+			categories,
+			tags,
+			permalink
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		vectorStmt, err := tx.Prepare(`
+		INSERT INTO metadata_vectors (dataset_id, emb) VALUES (?, ?)`)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for i := start; i < end; i++ {
+			d := g.Dataset(i)
+			_, err := metadataStmt.Exec(
+				d.ID,
+				d.Name,
+				d.Description,
+				d.Attribution,
+				d.Email,
+				d.UpdatedAt.Format(time.RFC3339),
+				strings.Join(d.Categories, ","),
+				strings.Join(d.Tags, ","),
+				"https://data.example.org/d/"+d.ID)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			if _, err := vectorStmt.Exec(d.ID, vec32.Encode(vecs[i*dim:(i+1)*dim], encoding)); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		metadataStmt.Close()
+		vectorStmt.Close()
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("inserted %d of %d datasets", end, cfg.Datasets)
+	}
+	return nil
+}
+
+func main() {
+	config.ParseFlags()
+	if *dir == "" {
+		*dir = config.DatasetsDir()
+	}
+
+	g, err := synth.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	start := time.Now()
+
+	if *vectors {
+		params, err := config.IndexParameters()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := insertVectors(g, params.EmbeddingDim); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		if err := g.WriteDir(*dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("generated %d datasets in %v", cfg.Datasets, time.Since(start))
+}
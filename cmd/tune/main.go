@@ -0,0 +1,90 @@
+// Command tune recommends metadata index parameters.
+//
+// It samples the stored metadata vectors, builds an index with each candidate
+// backend over the sample, and measures its recall of the exact nearest
+// neighbors of held-out query vectors and its query latency. The fastest
+// backend reaching the target recall is saved in the index_tuning table, from
+// which the server reads it when OPENDATALINK_INDEX_BACKEND is "tuned".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	sampleSize = flag.Int("sample", 100000, "number of vectors to sample")
+	numQueries = flag.Int("queries", 1000, "number of sampled vectors to hold out as queries")
+	k          = flag.Int64("k", 20, "number of nearest neighbors per query")
+	minRecall  = flag.Float64("recall", 0.95, "target recall")
+	candidates = flag.String("backends", "", "comma-separated backend specifications to sweep (default: ivf sweep)")
+	dryRun     = flag.Bool("n", false, "print the results without saving the recommendation")
+)
+
+func main() {
+	config.ParseFlags()
+
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	params, err := config.IndexParameters()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := index.SampleMetadataVectors(db, params.EmbeddingDim, *sampleSize, *numQueries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	specs := index.IVFCandidates(len(s.IDs))
+	if *candidates != "" {
+		specs = strings.Split(*candidates, ",")
+	}
+	log.Printf("tuning %d backends on %d vectors with %d queries",
+		len(specs), len(s.IDs), len(s.Queries)/s.Dim)
+
+	results, err := index.Tune(s, *k, specs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BACKEND\tRECALL\tLATENCY\tBUILD")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%.3f\t%v\t%v\n", r.Backend, r.Recall, r.Latency, r.BuildTime.Round(time.Millisecond))
+	}
+	w.Flush()
+
+	best := index.Recommend(results, *minRecall)
+	if best == nil {
+		log.Fatal("no backends to tune")
+	}
+	if best.Recall < *minRecall {
+		log.Printf("no backend reached recall %.3f", *minRecall)
+	}
+	fmt.Printf("recommended: %s (recall %.3f, latency %v)\n", best.Backend, best.Recall, best.Latency)
+	if *dryRun {
+		return
+	}
+	err = db.SetIndexTuning(&database.IndexTuning{
+		IndexName: "metadata",
+		Backend:   best.Backend,
+		Recall:    best.Recall,
+		LatencyUs: best.Latency.Microseconds(),
+		TunedAt:   time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
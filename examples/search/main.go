@@ -0,0 +1,122 @@
+// Command search is an example of using Open Data Link as a library.
+//
+// It creates a new Open Data Link database, ingests a few datasets, builds the
+// metadata index, and searches it. It must be run from the repository root,
+// with the fastText database built by build_fasttext:
+//
+//	go run examples/search/main.go -db /tmp/example.sqlite "public transit"
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var dbPath = flag.String("db", "example.sqlite", "path of the database to create")
+
+var datasets = []*opendatalink.Dataset{
+	{
+		DatasetID:   "bus1-stop",
+		Name:        "Bus Stops",
+		Description: "Locations of all bus stops served by the city transit authority.",
+		Categories:  []string{"Transportation"},
+		Tags:        []string{"bus", "transit"},
+	},
+	{
+		DatasetID:   "rail-ride",
+		Name:        "Subway Ridership",
+		Description: "Daily ridership counts at each subway station.",
+		Categories:  []string{"Transportation"},
+		Tags:        []string{"subway", "rail", "transit"},
+	},
+	{
+		DatasetID:   "tree-cens",
+		Name:        "Street Tree Census",
+		Description: "Species, size, and health of trees planted along city streets.",
+		Categories:  []string{"Environment"},
+		Tags:        []string{"trees", "parks"},
+	},
+	{
+		DatasetID:   "rest-insp",
+		Name:        "Restaurant Inspections",
+		Description: "Results of health inspections of restaurants and food vendors.",
+		Categories:  []string{"Health"},
+		Tags:        []string{"food", "inspections"},
+	},
+}
+
+// createDatabase creates an empty Open Data Link database at path.
+func createDatabase(path string) error {
+	schema, err := ioutil.ReadFile("sql/create_metadata_tables.sql")
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(string(schema))
+	return err
+}
+
+func main() {
+	flag.Parse()
+	query := strings.Join(flag.Args(), " ")
+	if query == "" {
+		query = "public transit"
+	}
+
+	os.Remove(*dbPath)
+	if err := createDatabase(*dbPath); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := opendatalink.DefaultConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.DatabasePath = *dbPath
+
+	odl, err := opendatalink.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer odl.Close()
+
+	for _, d := range datasets {
+		if err := odl.Ingest(d); err != nil {
+			log.Fatalf("ingest %s: %v", d.DatasetID, err)
+		}
+	}
+	if err := odl.BuildIndex(); err != nil {
+		log.Fatal(err)
+	}
+
+	results, err := odl.Search(query, 3)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("datasets matching %q:\n", query)
+	for _, r := range results {
+		fmt.Printf("  %.3f  %s\n", r.Similarity, r.Dataset.Name)
+	}
+
+	similar, err := odl.SimilarDatasets(datasets[0].DatasetID, 2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("datasets similar to %q:\n", datasets[0].Name)
+	for _, r := range similar {
+		fmt.Printf("  %.3f  %s\n", r.Similarity, r.Dataset.Name)
+	}
+}
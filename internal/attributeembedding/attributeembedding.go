@@ -1,88 +1,24 @@
-// Package wordemb creates embedding vectors for text by averaging word vectors.
-// chem
+// Package attributeembedding creates embedding vectors for attribute names.
 package attributeembedding
 
 import (
-	"errors"
-	"regexp"
-	"strings"
-
-	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
-	"github.com/ekzhu/go-fasttext"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
 )
 
-// ErrNoEmb is returned by Vector when none of the input words have an
-// embedding.
-var ErrNoEmb = errors.New("no embeddings found for input words")
-
-var wordSepRe = regexp.MustCompile(`\W+`)
-
-// Lucene stop words list.
-var stopwords = map[string]bool{
-	"a":     true,
-	"an":    true,
-	"and":   true,
-	"are":   true,
-	"as":    true,
-	"at":    true,
-	"be":    true,
-	"but":   true,
-	"by":    true,
-	"for":   true,
-	"if":    true,
-	"in":    true,
-	"into":  true,
-	"is":    true,
-	"it":    true,
-	"no":    true,
-	"not":   true,
-	"of":    true,
-	"on":    true,
-	"or":    true,
-	"such":  true,
-	"that":  true,
-	"the":   true,
-	"their": true,
-	"then":  true,
-	"there": true,
-	"these": true,
-	"they":  true,
-	"this":  true,
-	"to":    true,
-	"was":   true,
-	"will":  true,
-	"with":  true,
-}
+// ErrNoEmb is returned by Vector when the attribute name has no words to
+// embed.
+var ErrNoEmb = embedding.ErrNoEmb
 
-// Vector creates an embedding vector for the given text by averaging the
-// fastText vectors of the words.
+// Vector creates an embedding vector for the given attribute name with e,
+// tokenized with the default abbreviations (see Tokenizer.Vector).
 //
-// Returns a zero vector and ErrNoEmb if none of the input words are found in
-// the FastText DB.
-func Vector(ft *fasttext.FastText, text string) ([]float32, error) {
-	vec := make([]float32, fasttext.Dim)
-	foundEmb := false
-
-	for _, word := range wordSepRe.Split(text, -1) {
-		if stopwords[strings.ToLower(word)] {
-			continue
-		}
-		emb, err := ft.GetEmb(word)
-		if err != nil {
-			if err == fasttext.ErrNoEmbFound {
-				continue
-			}
-			return nil, err
-		}
-		foundEmb = true
-		vec32.Normalize(emb)
-		vec32.Add(vec, emb)
-	}
-	vec32.Scale(vec, 1/float32(len(vec)))
-	vec32.Normalize(vec)
+// Returns a zero vector and ErrNoEmb if e has no embedding for the name.
+func Vector(e embedding.Embedder, text string) ([]float32, error) {
+	return defaultTokenizer.Vector(e, text)
+}
 
-	if !foundEmb {
-		return vec, ErrNoEmb
-	}
-	return vec, nil
+// Vectors returns the results of Vector for each of the names, embedding them
+// in batches or concurrently (see Tokenizer.Vectors).
+func Vectors(e embedding.Embedder, names []string) ([][]float32, []error, error) {
+	return defaultTokenizer.Vectors(e, names)
 }
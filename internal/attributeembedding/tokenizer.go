@@ -0,0 +1,324 @@
+package attributeembedding
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// DefaultAbbreviations maps abbreviations common in column names to their
+// expansion.
+var DefaultAbbreviations = map[string]string{
+	"acct":  "account",
+	"addr":  "address",
+	"amt":   "amount",
+	"avg":   "average",
+	"bldg":  "building",
+	"cat":   "category",
+	"cnt":   "count",
+	"cust":  "customer",
+	"dept":  "department",
+	"desc":  "description",
+	"dist":  "district",
+	"dob":   "date of birth",
+	"dt":    "date",
+	"emp":   "employee",
+	"govt":  "government",
+	"info":  "information",
+	"lat":   "latitude",
+	"lng":   "longitude",
+	"loc":   "location",
+	"lon":   "longitude",
+	"max":   "maximum",
+	"mgr":   "manager",
+	"min":   "minimum",
+	"mo":    "month",
+	"nbr":   "number",
+	"no":    "number",
+	"num":   "number",
+	"org":   "organization",
+	"pct":   "percent",
+	"prev":  "previous",
+	"qty":   "quantity",
+	"sq":    "square",
+	"tel":   "telephone",
+	"temp":  "temperature",
+	"tot":   "total",
+	"yr":    "year",
+	"zipcd": "zip code",
+}
+
+// Tokenizer splits attribute names such as "cust_acct_num" or "DateOfBirth"
+// into words and expands abbreviations, so that they get useful embeddings.
+type Tokenizer struct {
+	abbrevs map[string]string
+
+	mu sync.Mutex
+	// Embedder of the names other models have no embedding for, whose
+	// n-gram vectors are cached across names.
+	ngram *embedding.NGram
+}
+
+// NewTokenizer returns a Tokenizer expanding the given lowercase abbreviations.
+func NewTokenizer(abbrevs map[string]string) *Tokenizer {
+	return &Tokenizer{abbrevs: abbrevs}
+}
+
+// ngramEmbedder returns the NGram embedder of vectors of dimension dim.
+func (t *Tokenizer) ngramEmbedder(dim int) *embedding.NGram {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ngram == nil || t.ngram.Dim() != dim {
+		t.ngram = embedding.NewNGram(dim)
+	}
+	return t.ngram
+}
+
+// defaultTokenizer is the Tokenizer of Vector.
+var defaultTokenizer = NewTokenizer(DefaultAbbreviations)
+
+// LoadAbbreviations returns DefaultAbbreviations extended with the
+// abbreviations in the file at path, which has an abbreviation followed by its
+// expansion on each line, e.g. "cust customer". Blank lines and lines starting
+// with "#" are ignored.
+func LoadAbbreviations(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	abbrevs := make(map[string]string, len(DefaultAbbreviations))
+	for abbrev, expansion := range DefaultAbbreviations {
+		abbrevs[abbrev] = expansion
+	}
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: abbreviation %q has no expansion", path, line, fields[0])
+		}
+		abbrevs[strings.ToLower(fields[0])] = strings.ToLower(strings.Join(fields[1:], " "))
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return abbrevs, nil
+}
+
+// Tokens returns the lowercase words of the attribute name, with abbreviations
+// expanded. The name is split on characters other than letters and digits,
+// between lowercase and uppercase letters ("DateOfBirth"), before the last
+// letter of a run of uppercase letters followed by a lowercase letter
+// ("HTTPServer"), and between letters and digits ("col17").
+func (t *Tokenizer) Tokens(name string) []string {
+	var tokens []string
+	for _, word := range splitName(name) {
+		word = strings.ToLower(word)
+		if expansion, ok := t.abbrevs[word]; ok {
+			tokens = append(tokens, strings.Fields(expansion)...)
+		} else {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// splitName splits the attribute name into its words (see Tokens).
+func splitName(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := -1
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if start >= 0 {
+				words = append(words, string(runes[start:i]))
+				start = -1
+			}
+			continue
+		}
+		if start >= 0 && isBoundary(runes, i) {
+			words = append(words, string(runes[start:i]))
+			start = -1
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// isBoundary reports whether a word starts at runes[i], a letter or digit
+// preceded by a letter or digit.
+func isBoundary(runes []rune, i int) bool {
+	prev, r := runes[i-1], runes[i]
+	switch {
+	case unicode.IsDigit(prev) != unicode.IsDigit(r):
+		return true
+	case unicode.IsLower(prev) && unicode.IsUpper(r):
+		return true
+	case unicode.IsUpper(prev) && unicode.IsUpper(r):
+		return i+1 < len(runes) && unicode.IsLower(runes[i+1])
+	}
+	return false
+}
+
+// Vector creates an embedding vector for the given attribute name with e by
+// embedding its tokens (see Tokens). If e has no embedding for the tokens, as
+// for names made of codes or misspelled words, they are embedded with an
+// embedding.NGram of their character n-grams instead, so that the name still
+// matches names spelled similarly.
+//
+// The trade-off of the fallback is that its vectors only match each other: they
+// are nearly orthogonal to the vectors of e, so the names embedded from
+// n-grams are found in searches for similar names only by the names also
+// embedded from n-grams, and Similarity reports 0 between names of the two
+// kinds.
+//
+// Returns a zero vector and ErrNoEmb if the name has no letters or digits.
+func (t *Tokenizer) Vector(e embedding.Embedder, name string) ([]float32, error) {
+	vec, _, err := t.vector(e, name)
+	return vec, err
+}
+
+// vector returns the results of Vector, and whether the vector was embedded
+// from the character n-grams of the name.
+func (t *Tokenizer) vector(e embedding.Embedder, name string) (vec []float32, ngram bool, err error) {
+	tokens := t.Tokens(name)
+	if len(tokens) == 0 {
+		return make([]float32, e.Dim()), false, ErrNoEmb
+	}
+	text := strings.Join(tokens, " ")
+	vec, err = e.Embed(text)
+	if err == ErrNoEmb {
+		vec, err = t.ngramEmbedder(e.Dim()).Embed(text)
+		return vec, true, err
+	}
+	return vec, false, err
+}
+
+// Number of attribute names embedded concurrently by Vectors.
+const vectorWorkers = 8
+
+// Vectors returns the results of Vector for each of the names, embedding each
+// distinct name once. The names are embedded in batches if e is an
+// embedding.BatchEmbedder, and concurrently otherwise, so e must be safe for
+// concurrent use.
+//
+// errs holds ErrNoEmb for the names without letters or digits; err reports
+// any other failure.
+func (t *Tokenizer) Vectors(e embedding.Embedder, names []string) (vecs [][]float32, errs []error, err error) {
+	var texts []string
+	textIndex := make(map[string]int)
+	nameText := make([]int, len(names))
+	for i, name := range names {
+		tokens := t.Tokens(name)
+		if len(tokens) == 0 {
+			nameText[i] = -1
+			continue
+		}
+		text := strings.Join(tokens, " ")
+		j, ok := textIndex[text]
+		if !ok {
+			j = len(texts)
+			textIndex[text] = j
+			texts = append(texts, text)
+		}
+		nameText[i] = j
+	}
+
+	var textVecs [][]float32
+	var textErrs []error
+	if _, ok := e.(embedding.BatchEmbedder); ok {
+		textVecs, textErrs, err = embedding.EmbedAll(e, texts)
+	} else {
+		textVecs, textErrs, err = embedConcurrently(e, texts)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	for j, text := range texts {
+		if textErrs[j] == ErrNoEmb {
+			textVecs[j], textErrs[j] = t.ngramEmbedder(e.Dim()).Embed(text)
+		}
+	}
+
+	vecs = make([][]float32, len(names))
+	errs = make([]error, len(names))
+	for i, j := range nameText {
+		if j < 0 {
+			vecs[i], errs[i] = make([]float32, e.Dim()), ErrNoEmb
+			continue
+		}
+		vecs[i], errs[i] = textVecs[j], textErrs[j]
+	}
+	return vecs, errs, nil
+}
+
+// embedConcurrently embeds the texts with e in vectorWorkers goroutines. Errors
+// other than ErrNoEmb are reported by err.
+func embedConcurrently(e embedding.Embedder, texts []string) (vecs [][]float32, errs []error, err error) {
+	vecs = make([][]float32, len(texts))
+	errs = make([]error, len(texts))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < vectorWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				vecs[i], errs[i] = e.Embed(texts[i])
+			}
+		}()
+	}
+	for i := range texts {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil && err != ErrNoEmb {
+			return nil, nil, err
+		}
+	}
+	return vecs, errs, nil
+}
+
+// Similarity returns the cosine similarity of the vectors of the attribute
+// names a and b (see Vector), or 0 if either has no letters or digits, or if
+// only one of them was embedded from its character n-grams.
+func (t *Tokenizer) Similarity(e embedding.Embedder, a, b string) (float32, error) {
+	va, ngramA, err := t.vector(e, a)
+	if err == ErrNoEmb {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	vb, ngramB, err := t.vector(e, b)
+	if err == ErrNoEmb {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if ngramA != ngramB {
+		return 0, nil
+	}
+	n := vec32.Norm(va) * vec32.Norm(vb)
+	if n == 0 {
+		return 0, nil
+	}
+	return vec32.Dot(va, vb) / n, nil
+}
@@ -0,0 +1,152 @@
+package attributeembedding
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+)
+
+// testEmbedder embeds each text of its vocabulary as a distinct unit vector of
+// dimension 64, and has no embedding for the other texts.
+type testEmbedder map[string]int
+
+func (e testEmbedder) Embed(text string) ([]float32, error) {
+	vec := make([]float32, e.Dim())
+	i, ok := e[text]
+	if !ok {
+		return vec, embedding.ErrNoEmb
+	}
+	vec[i] = 1
+	return vec, nil
+}
+
+func (testEmbedder) Dim() int { return 64 }
+
+func TestTokens(t *testing.T) {
+	custom := NewTokenizer(map[string]string{"cust": "client", "onhand": "on hand"})
+	for _, tt := range []struct {
+		tokenizer *Tokenizer
+		name      string
+		want      []string
+	}{
+		{defaultTokenizer, "cust_acct_num", []string{"customer", "account", "number"}},
+		{defaultTokenizer, "DateOfBirth", []string{"date", "of", "birth"}},
+		{defaultTokenizer, "DOB", []string{"date", "of", "birth"}},
+		{defaultTokenizer, "HTTPServer", []string{"http", "server"}},
+		{defaultTokenizer, "ZIPCode", []string{"zip", "code"}},
+		{defaultTokenizer, "col17", []string{"col", "17"}},
+		{defaultTokenizer, "2019Total", []string{"2019", "total"}},
+		{defaultTokenizer, "Unit Price ($)", []string{"unit", "price"}},
+		{defaultTokenizer, "  __ ", nil},
+		{custom, "cust_acct_num", []string{"client", "acct", "num"}},
+		{custom, "qty_onhand", []string{"qty", "on", "hand"}},
+	} {
+		if got := tt.tokenizer.Tokens(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokens(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarityNGram(t *testing.T) {
+	e := testEmbedder{"date of birth": 0}
+	similarity := func(a, b string) float32 {
+		t.Helper()
+		sim, err := defaultTokenizer.Similarity(e, a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sim
+	}
+	// Names out of the vocabulary are compared by their n-grams.
+	similar := similarity("qty_onhand", "OnhandQty2")
+	if unrelated := similarity("qty_onhand", "zxcv_bnm"); similar <= unrelated || similar < 0.5 {
+		t.Errorf("got similarity %v of similar names, %v of unrelated names", similar, unrelated)
+	}
+	// But not with names in the vocabulary.
+	if sim := similarity("DOB", "date_of_brth"); sim != 0 {
+		t.Errorf("got similarity %v of names embedded with the model and from n-grams, want 0", sim)
+	}
+}
+
+var errEmbed = errors.New("embedding failed")
+
+// countingEmbedder is a testEmbedder that counts the texts it embeds, and
+// fails for the text "fail". It is safe for concurrent use.
+type countingEmbedder struct {
+	testEmbedder
+	mu    sync.Mutex
+	texts map[string]int
+}
+
+func newCountingEmbedder() *countingEmbedder {
+	return &countingEmbedder{
+		testEmbedder: testEmbedder{"customer number": 0, "zip code": 1},
+		texts:        make(map[string]int),
+	}
+}
+
+func (e *countingEmbedder) Embed(text string) ([]float32, error) {
+	e.mu.Lock()
+	e.texts[text]++
+	e.mu.Unlock()
+	if text == "fail" {
+		return nil, errEmbed
+	}
+	return e.testEmbedder.Embed(text)
+}
+
+// batchEmbedder is a countingEmbedder that embeds texts in batches.
+type batchEmbedder struct {
+	*countingEmbedder
+}
+
+func (e batchEmbedder) EmbedBatch(texts []string) ([][]float32, []error, error) {
+	vecs := make([][]float32, len(texts))
+	errs := make([]error, len(texts))
+	for i, text := range texts {
+		vecs[i], errs[i] = e.Embed(text)
+		if errs[i] != nil && errs[i] != ErrNoEmb {
+			return nil, nil, errs[i]
+		}
+	}
+	return vecs, errs, nil
+}
+
+func TestVectors(t *testing.T) {
+	names := []string{"cust_num", "zip-code", "", "CustNum", "qty_onhand", "__", "ZipCode", "qty onhand"}
+	for _, batch := range []bool{false, true} {
+		c := newCountingEmbedder()
+		var e embedding.Embedder = c
+		if batch {
+			e = batchEmbedder{c}
+		}
+		vecs, errs, err := defaultTokenizer.Vectors(e, names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range names {
+			want, wantErr := defaultTokenizer.Vector(newCountingEmbedder(), name)
+			if !reflect.DeepEqual(vecs[i], want) || errs[i] != wantErr {
+				t.Errorf("batch %v: got %v, %v for %q, want the result %v, %v of Vector",
+					batch, vecs[i], errs[i], name, want, wantErr)
+			}
+		}
+		// Each distinct text once, and none for the names without tokens.
+		want := map[string]int{"customer number": 1, "zip code": 1, "quantity onhand": 1}
+		if !reflect.DeepEqual(c.texts, want) {
+			t.Errorf("batch %v: embedded texts %v, want %v", batch, c.texts, want)
+		}
+
+		c = newCountingEmbedder()
+		e = c
+		if batch {
+			e = batchEmbedder{c}
+		}
+		if _, _, err := defaultTokenizer.Vectors(e, []string{"zip_code", "fail", "cust_num"}); err != errEmbed {
+			t.Errorf("batch %v: got error %v, want the error of the embedder", batch, err)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+// Package bucket keeps the datasets harvested from the objects of cloud
+// storage buckets, S3 and Google Cloud Storage buckets, in sync with the
+// buckets. Each tabular object, a CSV, TSV, JSON, JSON Lines, Parquet, or xlsx
+// file, possibly compressed with gzip, is a dataset, of which a sample is
+// written to the datasets directory, with the schema sampled from it, by a
+// harvest.Writer. The tables split across many files, such as those exported
+// by BigQuery, are datasets of their manifests (see Manifest).
+//
+// The objects harvested are recorded in the source_objects table, so that
+// Sync only reads the objects whose entity tags changed since, and removes
+// the datasets of the objects removed from the bucket.
+package bucket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+)
+
+// Bucket is a cloud storage bucket.
+type Bucket interface {
+	// Name returns the URL of the bucket, such as s3://bucket.
+	Name() string
+	// URL returns the HTTP URL of the object of the key.
+	URL(key string) string
+	// List calls f with each object whose key starts with the prefix, in
+	// the order of their keys.
+	List(prefix string, f func(*harvest.Object) error) error
+	// Open opens the contents of the object of the key.
+	Open(key string) (io.ReadCloser, error)
+}
+
+// Options are the options of Sync.
+type Options struct {
+	// Number of rows of the samples of the tables of the objects read as
+	// they are downloaded, CSV, TSV, and JSON Lines files, or 0 for the
+	// whole tables.
+	SampleRows int
+	// Maximum size of the objects downloaded whole, JSON, Parquet, and
+	// xlsx files, and of their decompressed contents.
+	MaxSize int64
+	// Maximum fraction of the objects harvested before that may be
+	// removed, since a mistyped prefix, or an emptied bucket, would remove
+	// all of them.
+	MaxRemoved float64
+}
+
+// Stats are the numbers of objects synced.
+type Stats struct {
+	// Tabular objects listed, and of them those unchanged since they were
+	// harvested, those written, and those whose files changed.
+	Listed, Unchanged, Written, Changed int
+	// Datasets removed, of the objects removed from the bucket.
+	Removed int
+}
+
+// Formats of the tables of the objects, by the extension of their keys. The
+// tables of the formats in sampled are sampled as they are downloaded; the
+// others are downloaded whole.
+var (
+	rowsFiles = map[string]string{
+		".csv":     "rows.csv",
+		".tsv":     "rows.csv",
+		".tab":     "rows.csv",
+		".jsonl":   "rows.jsonl",
+		".ndjson":  "rows.jsonl",
+		".json":    "rows.json",
+		".parquet": "rows.parquet",
+		".xlsx":    "rows.xlsx",
+	}
+	sampled = map[string]bool{".csv": true, ".tsv": true, ".tab": true, ".jsonl": true, ".ndjson": true}
+)
+
+// extension returns the extension of the table of the key, without the .gz
+// extension of compressed objects, in lower case, and whether the object is
+// compressed.
+func extension(key string) (ext string, compressed bool) {
+	key = strings.ToLower(key)
+	if strings.HasSuffix(key, ".gz") {
+		key, compressed = strings.TrimSuffix(key, ".gz"), true
+	}
+	return path.Ext(key), compressed
+}
+
+// Tabular reports whether the object of the key is a table.
+func Tabular(key string) bool {
+	if strings.HasSuffix(key, "/") {
+		return false
+	}
+	ext, _ := extension(key)
+	return rowsFiles[ext] != ""
+}
+
+// DatasetID returns the ID of the dataset of the object of the key of the
+// bucket, named after the scheme of the bucket, such as s3-0123456789abcdef.
+func DatasetID(bucket, key string) string {
+	sum := sha256.Sum256([]byte(bucket + "/" + key))
+	return strings.SplitN(bucket, ":", 2)[0] + "-" + hex.EncodeToString(sum[:8])
+}
+
+// Manifest reports whether the object of the key is an export manifest,
+// whose name ends in .manifest, a newline-delimited list of the URIs of the
+// files of one table, such as those of the tables exported by BigQuery
+// (NEW_LINE_DELIMITED_MANIFEST), of the same bucket.
+func Manifest(key string) bool {
+	return strings.HasSuffix(strings.ToLower(key), ".manifest")
+}
+
+// table is a table of a bucket, the dataset of a tabular object or of a
+// manifest.
+type table struct {
+	id string
+	// Object the dataset is harvested from, whose entity tag, for a
+	// manifest, is that of the manifest and of its files.
+	object *harvest.Object
+	// Files of the table, the object itself unless it is a manifest.
+	files []*harvest.Object
+}
+
+// Sync harvests the tabular objects and the tables of the manifests of b whose
+// keys start with the prefix, which changed since they were last harvested, to
+// w, and removes the datasets of the objects harvested before that were
+// removed from b, if any. The files of the manifests are not harvested as
+// datasets of their own. The objects that cannot be read are skipped, and
+// their failures recorded.
+func Sync(db *sql.DB, w *harvest.Writer, b Bucket, prefix string, opts *Options, fails *failures.Recorder) (*Stats, error) {
+	known, err := harvest.Objects(db, b.Name(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	// The objects are all listed first, so that the files of the manifests
+	// are known.
+	var objects []*harvest.Object
+	byKey := make(map[string]*harvest.Object)
+	err = b.List(prefix, func(o *harvest.Object) error {
+		if Tabular(o.Key) || Manifest(o.Key) {
+			objects = append(objects, o)
+			byKey[o.Key] = o
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats := &Stats{}
+	listed := make(map[string]bool)
+	var tables []*table
+	inManifest := make(map[string]bool)
+	for _, o := range objects {
+		if !Manifest(o.Key) {
+			continue
+		}
+		t := &table{id: DatasetID(b.Name(), o.Key)}
+		// The dataset of a manifest that cannot be read is not removed.
+		listed[t.id] = true
+		stats.Listed++
+		if t.files, err = readManifest(b, o, byKey); err != nil {
+			fails.Fail(t.id, fmt.Errorf("%v/%v: %w", b.Name(), o.Key, err))
+			continue
+		}
+		t.object = manifestObject(o, t.files)
+		for _, f := range t.files {
+			inManifest[f.Key] = true
+		}
+		tables = append(tables, t)
+	}
+	for _, o := range objects {
+		if Manifest(o.Key) || inManifest[o.Key] {
+			continue
+		}
+		t := &table{id: DatasetID(b.Name(), o.Key), object: o, files: []*harvest.Object{o}}
+		listed[t.id] = true
+		stats.Listed++
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].object.Key < tables[j].object.Key })
+	for _, t := range tables {
+		if k := known[t.id]; k != nil && k.ETag == t.object.ETag && t.object.ETag != "" {
+			stats.Unchanged++
+			continue
+		}
+		changed, err := harvestTable(w, b, t, opts)
+		if err != nil {
+			fails.Fail(t.id, fmt.Errorf("%v/%v: %w", b.Name(), t.object.Key, err))
+			continue
+		}
+		stats.Written++
+		if changed {
+			stats.Changed++
+		}
+	}
+	var removed []string
+	for id := range known {
+		if !listed[id] {
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) > 0 && float64(len(removed)) > opts.MaxRemoved*float64(len(known)) {
+		return stats, fmt.Errorf("refusing to remove %d of the %d objects of %v/%v harvested (more than %v)",
+			len(removed), len(known), b.Name(), prefix, opts.MaxRemoved)
+	}
+	sort.Strings(removed)
+	for _, id := range removed {
+		if err := w.Remove(id); err != nil {
+			return stats, err
+		}
+		stats.Removed++
+	}
+	return stats, nil
+}
+
+// readManifest returns the files listed by the manifest o of b, which must be
+// tabular objects of the same format listed by b, in byKey.
+func readManifest(b Bucket, o *harvest.Object, byKey map[string]*harvest.Object) ([]*harvest.Object, error) {
+	rc, err := b.Open(o.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var files []*harvest.Object
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		uri := strings.TrimSpace(scanner.Text())
+		if uri == "" {
+			continue
+		}
+		key := strings.TrimPrefix(uri, b.Name()+"/")
+		if key == uri {
+			return nil, fmt.Errorf("file %v is not in %v", uri, b.Name())
+		}
+		f := byKey[key]
+		if f == nil || !Tabular(key) {
+			return nil, fmt.Errorf("file %v is not a table listed under the prefix", uri)
+		}
+		if ext, _ := extension(key); len(files) > 0 {
+			if first, _ := extension(files[0].Key); ext != first {
+				return nil, fmt.Errorf("file %v is not of the format of %v", uri, files[0].Key)
+			}
+		}
+		if !seen[key] {
+			seen[key] = true
+			files = append(files, f)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no files in the manifest")
+	}
+	return files, nil
+}
+
+// manifestObject returns the object of the dataset of the manifest o of the
+// files, whose entity tag is a hash of the entity tags of the manifest and of
+// the files, so that it changes with any of them, and whose size and
+// modification time are the total size and the last modification time of
+// the files.
+func manifestObject(o *harvest.Object, files []*harvest.Object) *harvest.Object {
+	m := &harvest.Object{Bucket: o.Bucket, Key: o.Key, Modified: o.Modified}
+	h := sha256.New()
+	io.WriteString(h, o.ETag)
+	unknown := o.ETag == ""
+	for _, f := range files {
+		io.WriteString(h, "\n"+f.Key+"\n"+f.ETag)
+		unknown = unknown || f.ETag == ""
+		m.Size += f.Size
+		if f.Modified > m.Modified {
+			m.Modified = f.Modified
+		}
+	}
+	if !unknown {
+		m.ETag = hex.EncodeToString(h.Sum(nil)[:16])
+	}
+	return m
+}
+
+// harvestTable writes the dataset of the table t of b, reporting whether its
+// files changed.
+func harvestTable(w *harvest.Writer, b Bucket, t *table, opts *Options) (bool, error) {
+	ext, _ := extension(t.files[0].Key)
+	rows, columns, err := sample(b, t.files, ext, opts)
+	if err != nil {
+		return false, err
+	}
+
+	// The dataset is named after the file of the object, without its
+	// extensions.
+	o := t.object
+	name := path.Base(o.Key)
+	title := name[:len(name)-len(path.Ext(name))]
+	if _, compressed := extension(o.Key); compressed {
+		title = title[:len(title)-len(path.Ext(title))]
+	}
+	m := &database.Metadata{
+		DatasetID:   t.id,
+		Name:        title,
+		Description: b.Name() + "/" + o.Key,
+		Attribution: bucketName(b.Name()),
+		UpdatedAt:   o.Modified,
+		Permalink:   b.URL(o.Key),
+	}
+	d := &harvest.Dataset{
+		Metadata: m,
+		Source:   b.Name(),
+		Columns:  columns,
+		RowsFile: rowsFiles[ext],
+		Object:   o,
+	}
+	for _, f := range t.files {
+		d.Distributions = append(d.Distributions, &harvest.Distribution{
+			Name:   path.Base(f.Key),
+			URL:    b.URL(f.Key),
+			Format: strings.ToUpper(strings.TrimPrefix(ext, ".")),
+		})
+	}
+	return w.Write(d, bytes.NewReader(rows))
+}
+
+// open opens the contents of the file f of b, decompressing them if it is
+// compressed.
+func open(b Bucket, f *harvest.Object) (io.ReadCloser, error) {
+	rc, err := b.Open(f.Key)
+	if err != nil {
+		return nil, err
+	}
+	if _, compressed := extension(f.Key); !compressed {
+		return rc, nil
+	}
+	zr, err := gzip.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &gzipFile{zr, rc}, nil
+}
+
+// gzipFile is a gzipped file being decompressed.
+type gzipFile struct {
+	*gzip.Reader
+	file io.Closer
+}
+
+func (f *gzipFile) Close() error {
+	f.Reader.Close()
+	return f.file.Close()
+}
+
+// sample returns the sample of the table of the format of the extension of
+// the files of b, and its columns. The sample of the formats sampled as they
+// are downloaded is read from as many of the files as needed, in their order;
+// that of the others is the first file.
+func sample(b Bucket, files []*harvest.Object, ext string, opts *Options) ([]byte, []*harvest.Column, error) {
+	var rows []byte
+	var err error
+	if sampled[ext] {
+		rows, err = sampleFiles(b, files, ext, opts.SampleRows)
+	} else if f := files[0]; f.Size > opts.MaxSize {
+		err = fmt.Errorf("object of %d bytes is larger than %d bytes", f.Size, opts.MaxSize)
+	} else {
+		var rc io.ReadCloser
+		if rc, err = open(b, f); err == nil {
+			rows, err = ioutil.ReadAll(io.LimitReader(rc, opts.MaxSize+1))
+			rc.Close()
+			if err == nil && int64(len(rows)) > opts.MaxSize {
+				err = fmt.Errorf("contents are larger than %d bytes", opts.MaxSize)
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// The schema is sampled from the rows of the sample, or as many rows of
+	// the tables downloaded whole.
+	n := opts.SampleRows
+	if n == 0 {
+		n = math.MaxInt32
+	}
+	var attrs []*datafile.Attribute
+	switch rowsFiles[ext] {
+	case "rows.csv":
+		attrs, err = datafile.ReadCSV(bytes.NewReader(rows), n)
+	case "rows.jsonl", "rows.json":
+		attrs, err = datafile.ReadJSON(bytes.NewReader(rows), n)
+	case "rows.parquet":
+		attrs, err = datafile.ReadParquet(bytes.NewReader(rows), int64(len(rows)), n)
+	case "rows.xlsx":
+		var sheets []*datafile.Sheet
+		if sheets, err = datafile.ReadXLSX(bytes.NewReader(rows), int64(len(rows)), n); err == nil && len(sheets) > 0 {
+			attrs = sheets[0].Attributes
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var columns []*harvest.Column
+	for _, a := range attrs {
+		columns = append(columns, &harvest.Column{Name: a.Name, Type: string(a.Type)})
+	}
+	return rows, columns, nil
+}
+
+// sampleFiles returns the first n rows of the table of the files of b, of the
+// format of the extension, sampled as they are downloaded, or all of them if n
+// is 0. The header of a CSV or TSV table is that of its first file, and those
+// of the next files are skipped.
+func sampleFiles(b Bucket, files []*harvest.Object, ext string, n int) ([]byte, error) {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	rows := 0
+	for i, f := range files {
+		remaining := 0
+		if n > 0 {
+			if remaining = n - rows; remaining <= 0 {
+				break
+			}
+		}
+		rc, err := open(b, f)
+		if err != nil {
+			return nil, err
+		}
+		var sampledRows int
+		switch ext {
+		case ".jsonl", ".ndjson":
+			var lines []byte
+			if lines, err = sampleLines(rc, remaining); err == nil && len(lines) > 0 {
+				if lines[len(lines)-1] != '\n' {
+					lines = append(lines, '\n')
+				}
+				buf.Write(lines)
+				sampledRows = bytes.Count(lines, []byte("\n"))
+			}
+		default:
+			comma := ','
+			if ext != ".csv" {
+				comma = '\t'
+			}
+			sampledRows, err = copyRecords(cw, rc, comma, i > 0, remaining)
+		}
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", f.Key, err)
+		}
+		rows += sampledRows
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// copyRecords writes the header, unless skipHeader is set, and the first n
+// rows, or all of them if n is 0, of the table read from r, whose fields are
+// separated by comma, to cw, as harvest.SampleCSV samples CSV tables, returning the
+// number of rows written.
+func copyRecords(cw *csv.Writer, r io.Reader, comma rune, skipHeader bool, n int) (int, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.LazyQuotes = true
+	cr.FieldsPerRecord = -1
+	rows := 0
+	for i := 0; n == 0 || i <= n; i++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rows, err
+		}
+		if i == 0 && skipHeader {
+			continue
+		}
+		if err := cw.Write(record); err != nil {
+			return rows, err
+		}
+		if i > 0 {
+			rows++
+		}
+	}
+	return rows, nil
+}
+
+// sampleLines returns the first n lines read from r, or all of them if n is
+// 0.
+func sampleLines(r io.Reader, n int) ([]byte, error) {
+	if n == 0 {
+		return ioutil.ReadAll(r)
+	}
+	var buf bytes.Buffer
+	br := bufio.NewReader(r)
+	for i := 0; i < n; i++ {
+		line, err := br.ReadBytes('\n')
+		buf.Write(line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// bucketName returns the name of the bucket of the URL.
+func bucketName(bucket string) string {
+	if i := strings.Index(bucket, "://"); i >= 0 {
+		return bucket[i+len("://"):]
+	}
+	return bucket
+}
+
+// Modified returns the modification time t in the RFC 3339 format of
+// harvest.Object.
+func Modified(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
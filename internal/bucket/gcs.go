@@ -0,0 +1,315 @@
+package bucket
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+)
+
+const (
+	// Endpoint of Google Cloud Storage
+	gcsEndpoint = "https://storage.googleapis.com"
+	// Scope of the access tokens, to read the objects
+	gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+	// Endpoint of the OAuth 2.0 tokens of Google accounts
+	googleTokenURI = "https://oauth2.googleapis.com/token"
+)
+
+// GCS is a Google Cloud Storage bucket.
+type GCS struct {
+	Bucket string
+	// URL of the service, https://storage.googleapis.com if empty, such as
+	// that of an emulator.
+	Endpoint string
+	// Account the requests are authorized with, or nil for public buckets.
+	Account *GoogleAccount
+	// Client of the requests, or http.DefaultClient if nil.
+	Client *http.Client
+
+	crawler crawler
+}
+
+// Name returns the URL of the bucket, gs://<bucket>.
+func (g *GCS) Name() string {
+	return "gs://" + g.Bucket
+}
+
+func (g *GCS) endpoint() string {
+	if g.Endpoint == "" {
+		return gcsEndpoint
+	}
+	return strings.TrimSuffix(g.Endpoint, "/")
+}
+
+// URL returns the HTTP URL of the object of the key.
+func (g *GCS) URL(key string) string {
+	return g.endpoint() + "/" + escapeS3(g.Bucket+"/"+key, false)
+}
+
+// gcsObjects is a page of the objects of a bucket listed by the JSON API.
+type gcsObjects struct {
+	NextPageToken string `json:"nextPageToken"`
+	Items         []struct {
+		Name    string    `json:"name"`
+		MD5Hash string    `json:"md5Hash"`
+		CRC32C  string    `json:"crc32c"`
+		Size    int64     `json:"size,string"`
+		Updated time.Time `json:"updated"`
+	} `json:"items"`
+}
+
+// List calls f with each object whose key starts with the prefix, paging
+// through the bucket with the JSON API. The entity tags of the objects are
+// the hashes of their contents, rather than their entity tags of the JSON
+// API, which change with their metadata, such as their access control lists.
+func (g *GCS) List(prefix string, f func(*harvest.Object) error) error {
+	params := url.Values{
+		"prefix": {prefix},
+		"fields": {"nextPageToken,items(name,md5Hash,crc32c,size,updated)"},
+	}
+	for {
+		res, err := g.get(g.endpoint() + "/storage/v1/b/" + url.PathEscape(g.Bucket) + "/o?" + params.Encode())
+		if err != nil {
+			return err
+		}
+		var page gcsObjects
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("listing the objects of %v: %w", g.Name(), err)
+		}
+		for _, item := range page.Items {
+			// Composite objects have no MD5 hash.
+			etag := item.MD5Hash
+			if etag == "" {
+				etag = "crc32c:" + item.CRC32C
+			}
+			o := &harvest.Object{
+				Bucket:   g.Name(),
+				Key:      item.Name,
+				ETag:     etag,
+				Size:     item.Size,
+				Modified: Modified(item.Updated),
+			}
+			if err := f(o); err != nil {
+				return err
+			}
+		}
+		if page.NextPageToken == "" {
+			return nil
+		}
+		params.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// Open opens the contents of the object of the key.
+func (g *GCS) Open(key string) (io.ReadCloser, error) {
+	res, err := g.get(g.endpoint() + "/storage/v1/b/" + url.PathEscape(g.Bucket) + "/o/" + url.PathEscape(key) + "?alt=media")
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// gcsError is the error body of a failed request.
+type gcsError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// get gets the resource at u, authorizing the requests with the account, if
+// any.
+func (g *GCS) get(u string) (*http.Response, error) {
+	var authorize func(*http.Request) error
+	if g.Account != nil {
+		authorize = func(req *http.Request) error {
+			token, err := g.Account.token(g.Client)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}
+	}
+	return g.crawler.get(g.Client, u, authorize, func(body []byte) string {
+		var e gcsError
+		if json.Unmarshal(body, &e) != nil {
+			return ""
+		}
+		return e.Error.Message
+	})
+}
+
+// GoogleAccount is a Google account the requests to Google Cloud are
+// authorized with, by OAuth 2.0 access tokens.
+type GoogleAccount struct {
+	// Access token of the account, such as that printed by gcloud auth
+	// print-access-token, or the last one obtained with its credentials, if
+	// any.
+	AccessToken string
+	// Credentials of a service account, or of a user, such as those of
+	// gcloud auth application-default login, read by ReadGoogleCredentials.
+	credentials *googleCredentials
+
+	mu      sync.Mutex
+	expires time.Time
+}
+
+// googleCredentials is a credentials file of a Google account.
+type googleCredentials struct {
+	Type string `json:"type"`
+	// Credentials of a service account.
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	// Credentials of a user.
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+
+	key *rsa.PrivateKey
+}
+
+// ReadGoogleCredentials returns the account of the credentials file at path,
+// the key file of a service account, or the application default credentials
+// of a user.
+func ReadGoogleCredentials(path string) (*GoogleAccount, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &googleCredentials{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	switch c.Type {
+	case "service_account":
+		block, _ := pem.Decode([]byte(c.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("%v: no private key", path)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("%v: %w", path, err)
+			}
+		}
+		var ok bool
+		if c.key, ok = key.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("%v: private key is not an RSA key", path)
+		}
+	case "authorized_user":
+		if c.RefreshToken == "" {
+			return nil, fmt.Errorf("%v: no refresh token", path)
+		}
+	default:
+		return nil, fmt.Errorf("%v: unsupported credentials type %q", path, c.Type)
+	}
+	if c.TokenURI == "" {
+		c.TokenURI = googleTokenURI
+	}
+	return &GoogleAccount{credentials: c}, nil
+}
+
+// tokenResponse is the response of the token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// token returns the access token of the account, obtaining a new one with the
+// client when the previous one is about to expire.
+func (a *GoogleAccount) token(client *http.Client) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := a.credentials
+	if c == nil {
+		if a.AccessToken == "" {
+			return "", errors.New("no access token or credentials of the Google account")
+		}
+		return a.AccessToken, nil
+	}
+	if a.AccessToken != "" && time.Now().Before(a.expires) {
+		return a.AccessToken, nil
+	}
+	form := url.Values{}
+	if c.Type == "service_account" {
+		assertion, err := c.assertion(time.Now())
+		if err != nil {
+			return "", err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	} else {
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+		form.Set("refresh_token", c.RefreshToken)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.PostForm(c.TokenURI, form)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var t tokenResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&t); err != nil {
+		return "", fmt.Errorf("POST %v: %v: %w", c.TokenURI, res.Status, err)
+	}
+	if res.StatusCode != http.StatusOK || t.AccessToken == "" {
+		return "", fmt.Errorf("POST %v: %v: %v %v", c.TokenURI, res.Status, t.Error, t.ErrorDescription)
+	}
+	// The token is renewed a minute before it expires.
+	a.AccessToken = t.AccessToken
+	a.expires = time.Now().Add(time.Duration(t.ExpiresIn)*time.Second - time.Minute)
+	return a.AccessToken, nil
+}
+
+// assertion returns the JSON Web Token of the service account, issued at t,
+// asserting its identity to the token endpoint.
+func (c *googleCredentials) assertion(t time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": c.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   c.ClientEmail,
+		"scope": gcsScope,
+		"aud":   c.TokenURI,
+		"iat":   t.Unix(),
+		"exp":   t.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
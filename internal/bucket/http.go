@@ -0,0 +1,28 @@
+package bucket
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/crawl"
+)
+
+// crawler makes the requests to a bucket.
+type crawler struct {
+	once   sync.Once
+	client *crawl.Client
+}
+
+// get gets the resource at u with the client, or http.DefaultClient if nil,
+// authorizing each request with authorize, if not nil, and retrying the
+// requests that fail, or that are throttled or fail on the server, with
+// backoff (see package crawl). The message of the error body of a failed
+// request, if any, is returned by message. The client, authorize, and message
+// of the first request are those of all the requests of the crawler.
+func (c *crawler) get(client *http.Client, u string, authorize func(*http.Request) error, message func(body []byte) string) (*http.Response, error) {
+	c.once.Do(func() {
+		c.client = crawl.NewClient()
+		c.client.HTTP, c.client.Authorize, c.client.Message = client, authorize, message
+	})
+	return c.client.Get(u)
+}
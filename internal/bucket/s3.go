@@ -0,0 +1,255 @@
+package bucket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+)
+
+// SHA-256 hash of the empty payload of the requests
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Credentials are AWS credentials.
+type Credentials struct {
+	AccessKeyID, SecretAccessKey string
+	// Token of temporary credentials, if any.
+	SessionToken string
+}
+
+// S3 is an S3 bucket, or a bucket of a service compatible with S3, such as
+// MinIO.
+type S3 struct {
+	Bucket string
+	Region string
+	// URL of the S3 service, such as https://s3.us-east-1.amazonaws.com, the
+	// default of the region if empty.
+	Endpoint string
+	// Whether the bucket is addressed by the path of the endpoint, rather
+	// than by a virtual host.
+	PathStyle bool
+	// Credentials the requests are signed with, or nil for public buckets.
+	Credentials *Credentials
+	// Client of the requests, or http.DefaultClient if nil.
+	Client *http.Client
+
+	crawler crawler
+}
+
+// Name returns the URL of the bucket, s3://<bucket>.
+func (s *S3) Name() string {
+	return "s3://" + s.Bucket
+}
+
+// bucketURL returns the URL of the bucket.
+func (s *S3) bucketURL() *url.URL {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		u = &url.URL{Scheme: "https", Host: endpoint}
+	}
+	if s.PathStyle {
+		u.Path += "/" + s.Bucket
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	return u
+}
+
+// URL returns the HTTP URL of the object of the key.
+func (s *S3) URL(key string) string {
+	u := s.bucketURL()
+	return u.Scheme + "://" + u.Host + escapeS3(u.Path+"/"+key, false)
+}
+
+// listBucketResult is the result of a ListObjectsV2 request.
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int64
+	}
+}
+
+// List calls f with each object whose key starts with the prefix, paging
+// through the bucket with ListObjectsV2.
+func (s *S3) List(prefix string, f func(*harvest.Object) error) error {
+	params := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		res, err := s.get(s.URL("") + "?" + canonicalQuery(params))
+		if err != nil {
+			return err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("ListObjectsV2 of %v: %w", s.Name(), err)
+		}
+		for _, c := range result.Contents {
+			o := &harvest.Object{
+				Bucket:   s.Name(),
+				Key:      c.Key,
+				ETag:     strings.Trim(c.ETag, `"`),
+				Size:     c.Size,
+				Modified: Modified(c.LastModified),
+			}
+			if err := f(o); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		params.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Open opens the contents of the object of the key.
+func (s *S3) Open(key string) (io.ReadCloser, error) {
+	res, err := s.get(s.URL(key))
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// s3Error is the error of a failed request.
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+// get gets the resource at u, signing the requests with the credentials, if
+// any.
+func (s *S3) get(u string) (*http.Response, error) {
+	var authorize func(*http.Request) error
+	if s.Credentials != nil {
+		authorize = func(req *http.Request) error {
+			s.sign(req, time.Now())
+			return nil
+		}
+	}
+	return s.crawler.get(s.Client, u, authorize, func(body []byte) string {
+		var e s3Error
+		if xml.Unmarshal(body, &e) != nil || e.Code == "" {
+			return ""
+		}
+		return e.Message + " (" + e.Code + ")"
+	})
+}
+
+// sign signs the request, of an empty payload, with AWS Signature Version 4,
+// at the time.
+func (s *S3) sign(req *http.Request, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyHash)
+	if s.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.Credentials.SessionToken)
+	}
+
+	// The host, the range, and the x-amz- headers are signed.
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	query, _ := url.ParseQuery(req.URL.RawQuery)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapeS3(req.URL.Path, false),
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyHash,
+	}, "\n")
+
+	date := t.Format("20060102")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex(canonicalRequest)
+	key := []byte("AWS4" + s.Credentials.SecretAccessKey)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		s.Credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalQuery returns the query of the parameters in the canonical form of
+// Signature Version 4, sorted by name and escaped by escapeS3.
+func canonicalQuery(params url.Values) string {
+	var pairs [][2]string
+	for name, values := range params {
+		for _, v := range values {
+			pairs = append(pairs, [2]string{escapeS3(name, true), escapeS3(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	query := make([]string, len(pairs))
+	for i, p := range pairs {
+		query[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(query, "&")
+}
+
+// escapeS3 escapes s as S3 does, all bytes but the unreserved characters of
+// URIs, and the slashes unless slash is set.
+func escapeS3(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !slash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,233 @@
+// Package checksum detects the datasets whose files changed since an ingestion
+// command last processed them. A Tracker records the SHA-256 checksum of each
+// file that the command reads, for each dataset it processes, in the
+// dataset_checksums table (see sql/create_dataset_checksums_table.sql). With
+// -changed-only, the command processes only the datasets of which a file was
+// changed, added, or removed since then, so that a nightly refresh of a large
+// catalog reads the unchanged datasets' files but does not process them again.
+package checksum
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/layout"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/partition"
+)
+
+var changedOnly = flag.Bool("changed-only", false, "only process the datasets whose files changed since the command last processed them (see package checksum)")
+
+// A Tracker records the checksums of the files of the datasets that a command
+// processes. A nil Tracker, that of a database without a dataset_checksums
+// table, records nothing and reports every dataset as changed. It is safe for
+// concurrent use.
+type Tracker struct {
+	command  string
+	datasets *layout.Layout
+	names    []string
+	// Checksums recorded by the previous runs of the command, by dataset ID
+	// and file name.
+	previous map[string]map[string]string
+
+	mu sync.Mutex
+	// Checksums of the files of the datasets checked by Changed.
+	current   map[string]map[string]string
+	unchanged int
+}
+
+// Start loads the checksums recorded for the named command, which reads the
+// files of the datasets with the conventional names names (see
+// layout.Layout.Path), such as "rows.csv" or "rows" for the partitions of a
+// partitioned dataset. It returns a nil Tracker if the database has no
+// dataset_checksums table, and an error if -changed-only is set then. The
+// flags must have been parsed.
+func Start(db *sql.DB, datasets *layout.Layout, command string, names ...string) (*Tracker, error) {
+	if ok, err := database.HasTable(db, "dataset_checksums"); err != nil {
+		return nil, err
+	} else if !ok {
+		if *changedOnly {
+			return nil, fmt.Errorf("-changed-only needs a dataset_checksums table (see sql/create_dataset_checksums_table.sql)")
+		}
+		return nil, nil
+	}
+	rows, err := db.Query(`SELECT dataset_id, file, checksum FROM dataset_checksums WHERE command = ?`, command)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	t := &Tracker{
+		command:  command,
+		datasets: datasets,
+		names:    names,
+		previous: make(map[string]map[string]string),
+		current:  make(map[string]map[string]string),
+	}
+	for rows.Next() {
+		var datasetID, file, sum string
+		if err := rows.Scan(&datasetID, &file, &sum); err != nil {
+			return nil, err
+		}
+		if t.previous[datasetID] == nil {
+			t.previous[datasetID] = make(map[string]string)
+		}
+		t.previous[datasetID][file] = sum
+	}
+	return t, rows.Err()
+}
+
+// ChangedOnly reports whether -changed-only is set.
+func ChangedOnly() bool {
+	return *changedOnly
+}
+
+// Changed computes the checksums of the files of the dataset with the given
+// ID, to be recorded by Store, and reports whether the dataset is to be
+// processed: always, unless -changed-only is set, and otherwise if the
+// command has not processed it yet or a file of it changed since.
+func (t *Tracker) Changed(datasetID string) (bool, error) {
+	if t == nil {
+		return true, nil
+	}
+	sums, err := Files(t.datasets, datasetID, t.names...)
+	if err != nil {
+		return false, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current[datasetID] = sums
+	if !*changedOnly {
+		return true, nil
+	}
+	previous, ok := t.previous[datasetID]
+	if !ok || !equal(previous, sums) {
+		return true, nil
+	}
+	t.unchanged++
+	return false, nil
+}
+
+// Unchanged returns the number of datasets that Changed reported unchanged.
+func (t *Tracker) Unchanged() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.unchanged
+}
+
+// Store records in tx the checksums of the files of the dataset with the given
+// ID computed by Changed, replacing those recorded when the command last
+// processed it. It is called once the results of the dataset are written to
+// tx, in the same savepoint.
+func (t *Tracker) Store(tx *sql.Tx, datasetID string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	sums, ok := t.current[datasetID]
+	t.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("checksums of dataset %v not computed", datasetID)
+	}
+	if _, err := tx.Exec(`DELETE FROM dataset_checksums WHERE command = ? AND dataset_id = ?`, t.command, datasetID); err != nil {
+		return err
+	}
+	for file, sum := range sums {
+		_, err := tx.Exec(`
+		INSERT INTO dataset_checksums (command, dataset_id, file, checksum) VALUES (?, ?, ?, ?)`,
+			t.command, datasetID, file, sum)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Files returns the SHA-256 checksums in hex of the files of the dataset with
+// the given ID that have the conventional names names, by name. The files that
+// the dataset lacks are left out. The checksum of a compressed file (see
+// datafile.Find) is that of its compressed contents, and the checksum of the
+// partitions of a partitioned dataset ("rows") is their hash (see
+// partition.Hash).
+func Files(datasets *layout.Layout, datasetID string, names ...string) (map[string]string, error) {
+	sums := make(map[string]string)
+	for _, name := range names {
+		path, err := datasets.Path(datasetID, name)
+		if err == nil {
+			sums[name], err = fileChecksum(path, name)
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			delete(sums, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sums, nil
+}
+
+// fileChecksum returns the checksum of the file at path, whose conventional
+// name is name.
+func fileChecksum(path, name string) (string, error) {
+	switch name {
+	case "rows":
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%v is not a directory: %w", path, os.ErrNotExist)
+		}
+		parts, err := partition.List(path)
+		if err != nil {
+			return "", err
+		}
+		return partition.Hash(path, parts)
+	case "rows.parquet", "rows.xlsx":
+	default:
+		var err error
+		if path, err = datafile.Find(path); err != nil {
+			return "", err
+		}
+	}
+	return File(path)
+}
+
+// File returns the SHA-256 checksum of the file at path, in hex.
+func File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// equal reports whether the checksums a and b are those of the same files.
+func equal(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, sum := range a {
+		if b[name] != sum {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package checksum
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/layout"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestTracker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	datasets := layout.Dir(dir)
+	write := func(name, data string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a/rows.csv", "name\nelm\n")
+	write("b/rows/day=1/x.csv", "name\noak\n")
+	write("c/metadata.json", "{}")
+
+	defer func(v bool) { *changedOnly = v }(*changedOnly)
+	*changedOnly = true
+	if _, err := Start(db, datasets, "sketch_columns", "rows.csv", "rows"); err == nil {
+		t.Error("got no error for -changed-only without a dataset_checksums table")
+	}
+	schema, err := ioutil.ReadFile("../../sql/create_dataset_checksums_table.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+
+	// run checks the datasets with a new tracker, recording the checksums of
+	// the changed ones, and returns them.
+	run := func() []string {
+		tracker, err := Start(db, datasets, "sketch_columns", "rows.csv", "rows")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var changed []string
+		for _, id := range []string{"a", "b", "c"} {
+			ok, err := tracker.Changed(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				changed = append(changed, id)
+				if err := tracker.Store(tx, id); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if tracker.Unchanged() != 3-len(changed) {
+			t.Errorf("got %d unchanged datasets, want %d", tracker.Unchanged(), 3-len(changed))
+		}
+		return changed
+	}
+	check := func(what string, want ...string) {
+		got := run()
+		if len(got) != len(want) {
+			t.Errorf("%v: got changed datasets %v, want %v", what, got, want)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%v: got changed datasets %v, want %v", what, got, want)
+				return
+			}
+		}
+	}
+
+	// Dataset c has none of the files, so it is never recorded.
+	check("first run", "a", "b", "c")
+	check("second run", "c")
+	write("b/rows/day=2/x.csv", "name\nash\n")
+	write("c/metadata.json", `{"name": "ignored"}`)
+	check("partition added", "b", "c")
+	if err := os.Remove(filepath.Join(dir, "a/rows.csv")); err != nil {
+		t.Fatal(err)
+	}
+	write("a/rows.csv.gz", "not really gzip")
+	check("file compressed", "a", "c")
+	check("last run", "c")
+}
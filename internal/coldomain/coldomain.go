@@ -0,0 +1,246 @@
+// Package coldomain classifies dataset columns into semantic domains, such as
+// email addresses or zip codes, from their names and values, so that datasets
+// can be filtered by the kinds of data they hold and join suggestions can
+// compare the domains of columns.
+//
+// Emails, phone numbers, points, and dates are recognized by their values
+// alone. Zip codes, FIPS codes, latitudes, longitudes, geohashes, and person
+// names cannot be told apart from other numbers or words by their values, so
+// their columns must also have names whose attribute embeddings are similar to
+// a name of the domain.
+package coldomain
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/attributeembedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// Domain is the semantic domain of the values of a column.
+type Domain string
+
+const (
+	// None is the domain of the columns that are not in any other domain.
+	None    Domain = ""
+	Email   Domain = "email"
+	Phone   Domain = "phone"
+	ZipCode Domain = "zip_code"
+	// FIPS codes of states, counties, and census tracts and blocks.
+	FIPSCode  Domain = "fips_code"
+	Latitude  Domain = "latitude"
+	Longitude Domain = "longitude"
+	// Points given as well-known text, e.g. "POINT (-73.96 40.71)", or as
+	// latitude and longitude, e.g. "(40.71, -73.96)".
+	Point      Domain = "point"
+	Geohash    Domain = "geohash"
+	Date       Domain = "date"
+	PersonName Domain = "person_name"
+)
+
+// Parse returns the Domain named s, which must not be empty.
+func Parse(s string) (Domain, error) {
+	switch d := Domain(s); d {
+	case Email, Phone, ZipCode, FIPSCode, Latitude, Longitude, Point, Geohash, Date, PersonName:
+		return d, nil
+	}
+	return None, fmt.Errorf("unknown column domain %q (want email, phone, zip_code, fips_code, latitude, longitude, point, geohash, date, or person_name)", s)
+}
+
+// Minimum fraction of the non-blank values of a column that must be of a
+// domain for the column to be of that domain.
+const minFraction = 0.9
+
+// valueKind is a kind of value recognized by a Detector.
+type valueKind int
+
+const (
+	emailValue valueKind = iota
+	phoneValue
+	zipValue
+	fipsValue
+	latitudeValue
+	longitudeValue
+	pointValue
+	geohashValue
+	personNameValue
+	numValueKinds
+)
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[A-Za-z]{2,}$`)
+	// North American phone numbers, written with separators so that they are
+	// not confused with other 10-digit numbers.
+	phonePattern = regexp.MustCompile(`^(\+?1[ .-]?)?(\(\d{3}\) ?|\d{3}[ .-])\d{3}[ .-]\d{4}$`)
+	zipPattern   = regexp.MustCompile(`^\d{5}(-\d{4})?$`)
+	// State, county, tract, block group, and block FIPS codes.
+	fipsPattern = regexp.MustCompile(`^\d{2}(\d{3}(\d{6}(\d(\d{3})?)?)?)?$`)
+	// Geohashes of at least 4 characters, whose cells are at most about 40
+	// km wide.
+	geohashPattern = regexp.MustCompile(`^[0-9b-hjkmnp-z]{4,12}$`)
+	// Capitalized words, e.g. "Jane Doe" or "DOE, JANE".
+	personNamePattern = regexp.MustCompile(`^[A-Z][A-Za-z'.-]*,?( [A-Z][A-Za-z'.-]*){1,3}$`)
+)
+
+// A Detector counts the values of a column of each kind.
+type Detector struct {
+	values int64
+	counts [numValueKinds]int64
+}
+
+// Add adds a value of the column. Blank values are ignored.
+func (d *Detector) Add(v string) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return
+	}
+	d.values++
+	if emailPattern.MatchString(v) {
+		d.counts[emailValue]++
+	}
+	if phonePattern.MatchString(v) {
+		d.counts[phoneValue]++
+	}
+	if zipPattern.MatchString(v) {
+		d.counts[zipValue]++
+	}
+	if fipsPattern.MatchString(v) {
+		d.counts[fipsValue]++
+	}
+	if _, _, ok := ParsePoint(v); ok {
+		d.counts[pointValue]++
+	}
+	if geohashPattern.MatchString(strings.ToLower(v)) {
+		d.counts[geohashValue]++
+	}
+	if personNamePattern.MatchString(v) {
+		d.counts[personNameValue]++
+	}
+	if x, err := strconv.ParseFloat(v, 64); err == nil {
+		if math.Abs(x) <= 90 {
+			d.counts[latitudeValue]++
+		}
+		if math.Abs(x) <= 180 {
+			d.counts[longitudeValue]++
+		}
+	}
+}
+
+// is reports whether at least minFraction of the values are of the kind.
+func (d *Detector) is(k valueKind) bool {
+	return d.values > 0 && float64(d.counts[k]) >= minFraction*float64(d.values)
+}
+
+// Names of the domains recognized by column name, with the kinds of values
+// their columns must have.
+var domainNames = []struct {
+	domain Domain
+	kind   valueKind
+	names  []string
+}{
+	{ZipCode, zipValue, []string{"zip", "zip code", "zipcode", "postal code", "postcode"}},
+	{FIPSCode, fipsValue, []string{"fips", "fips code", "county fips", "geoid"}},
+	{Latitude, latitudeValue, []string{"latitude"}},
+	{Longitude, longitudeValue, []string{"longitude"}},
+	{Geohash, geohashValue, []string{"geohash"}},
+	{PersonName, personNameValue, []string{"person name", "full name", "first name", "last name"}},
+}
+
+// Minimum cosine similarity of the attribute embedding of a column name with a
+// name of a domain.
+const minNameSimilarity = 0.8
+
+// Classifier classifies columns into domains.
+type Classifier struct {
+	embedder  embedding.Embedder
+	tokenizer *attributeembedding.Tokenizer
+	// Attribute embedding vectors of the names of each of domainNames.
+	vecs [][][]float32
+}
+
+// NewClassifier returns a Classifier comparing the attribute embeddings of
+// column names, created with e and tokenized with t, with the names of the
+// domains.
+func NewClassifier(e embedding.Embedder, t *attributeembedding.Tokenizer) (*Classifier, error) {
+	c := &Classifier{e, t, make([][][]float32, len(domainNames))}
+	for i, d := range domainNames {
+		for _, name := range d.names {
+			vec, err := t.Vector(e, name)
+			if err != nil {
+				return nil, err
+			}
+			c.vecs[i] = append(c.vecs[i], vec)
+		}
+	}
+	return c, nil
+}
+
+// Classify returns the domain of the column with the given name, inferred type
+// (see package coltype), and values added to d.
+func (c *Classifier) Classify(name string, typ coltype.Type, d *Detector) (Domain, error) {
+	switch {
+	case d.is(emailValue):
+		return Email, nil
+	case d.is(phoneValue):
+		return Phone, nil
+	case d.is(pointValue):
+		return Point, nil
+	case typ == coltype.Date:
+		return Date, nil
+	}
+	vec, err := c.tokenizer.Vector(c.embedder, name)
+	if err == attributeembedding.ErrNoEmb {
+		return None, nil
+	} else if err != nil {
+		return None, err
+	}
+	best, bestSim := None, float32(minNameSimilarity)
+	for i, dn := range domainNames {
+		if !d.is(dn.kind) {
+			continue
+		}
+		for _, v := range c.vecs[i] {
+			if sim := vec32.Cosine(vec, v); sim >= bestSim {
+				best, bestSim = dn.domain, sim
+			}
+		}
+	}
+	return best, nil
+}
+
+var (
+	wktPointPattern     = regexp.MustCompile(`^(?i:POINT)\s*\(\s*(\S+)\s+(\S+)\s*\)$`)
+	latLongPointPattern = regexp.MustCompile(`^\(\s*([^,\s]+)\s*,\s*([^,\s]+)\s*\)$`)
+)
+
+// ParsePoint parses a point of the Point domain, returning its latitude and
+// longitude in degrees.
+func ParsePoint(v string) (lat, long float64, ok bool) {
+	if v == "" || v[0] != '(' && v[0] != 'P' && v[0] != 'p' {
+		return 0, 0, false
+	}
+	var x, y string
+	if m := wktPointPattern.FindStringSubmatch(v); m != nil {
+		// Well-known text gives the longitude first.
+		x, y = m[2], m[1]
+	} else if m := latLongPointPattern.FindStringSubmatch(v); m != nil {
+		x, y = m[1], m[2]
+	} else {
+		return 0, 0, false
+	}
+	lat, err := strconv.ParseFloat(x, 64)
+	if err != nil || math.Abs(lat) > 90 {
+		return 0, 0, false
+	}
+	long, err = strconv.ParseFloat(y, 64)
+	if err != nil || math.Abs(long) > 180 {
+		return 0, 0, false
+	}
+	return lat, long, true
+}
@@ -0,0 +1,56 @@
+package coldomain
+
+import (
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/attributeembedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+)
+
+func TestClassify(t *testing.T) {
+	c, err := NewClassifier(embedding.NewNGram(256), attributeembedding.NewTokenizer(attributeembedding.DefaultAbbreviations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		values []string
+		want   Domain
+	}{
+		{"contact", []string{"a@example.org", "b.c@example.com", ""}, Email},
+		{"tel", []string{"(212) 555-0100", "212-555-0101", "+1 212 555 0102"}, Phone},
+		{"inspection_date", []string{"2020-01-31", "2021-06-01"}, Date},
+		{"zip_code", []string{"10001", "11201-1234"}, ZipCode},
+		{"ZIPCODE", []string{"10001", "11201"}, ZipCode},
+		{"postcode", []string{"10001", "11201"}, ZipCode},
+		{"lat", []string{"40.7128", "40.6782"}, Latitude},
+		{"Longitude", []string{"-73.9352", "-74.0060"}, Longitude},
+		{"location", []string{"POINT (-73.9352 40.7128)", "(40.6782, -73.9442)"}, Point},
+		{"geohash", []string{"dr5ru7", "dr5regw"}, Geohash},
+		{"county_fips", []string{"36061", "36047"}, FIPSCode},
+		{"street_name", []string{"Broadway", "Main Street"}, None},
+		{"FullName", []string{"Jane Doe", "DOE, JOHN"}, PersonName},
+		{"first_name", []string{"Jane Doe", "John Smith"}, PersonName},
+		// Values of the domain, but the name is not.
+		{"permit_no", []string{"10001", "11201"}, None},
+		{"borough", []string{"Staten Island", "New York"}, None},
+		// Name of the domain, but not the values.
+		{"latitude", []string{"north", "south"}, None},
+	}
+	for _, tt := range tests {
+		var d Detector
+		var inf coltype.Inferrer
+		for _, v := range tt.values {
+			d.Add(v)
+			inf.Add(v)
+		}
+		got, err := c.Classify(tt.name, inf.Type(), &d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s %q: got %q, want %q", tt.name, tt.values, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package coltype infers the types of the values of dataset columns, so that
+// columns holding different kinds of values, such as dates and numeric IDs,
+// are not suggested as join partners because some of their values coincide.
+package coltype
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is the type of the values of a column.
+type Type string
+
+const (
+	// Unknown is the type of columns without values. It is compatible with
+	// every type.
+	Unknown Type = ""
+	Int     Type = "int"
+	Float   Type = "float"
+	Date    Type = "date"
+	Bool    Type = "bool"
+	// String is the type of the columns whose values are not mostly of
+	// another type.
+	String Type = "string"
+)
+
+// Parse returns the Type named s, as stored in the column_types table.
+func Parse(s string) (Type, error) {
+	switch t := Type(s); t {
+	case Unknown, Int, Float, Date, Bool, String:
+		return t, nil
+	}
+	return Unknown, fmt.Errorf("unknown column type %q (want int, float, date, bool, or string)", s)
+}
+
+// Numeric reports whether t is Int or Float.
+func (t Type) Numeric() bool {
+	return t == Int || t == Float
+}
+
+// Compatible reports whether columns of types a and b may hold the same
+// values: if they have the same type, either is Unknown, both are numeric, or
+// one is String and the other Int, as for codes made of digits.
+func Compatible(a, b Type) bool {
+	switch {
+	case a == b, a == Unknown, b == Unknown:
+		return true
+	case a.Numeric() && b.Numeric():
+		return true
+	case a == String:
+		return b == Int
+	case b == String:
+		return a == Int
+	}
+	return false
+}
+
+// Common returns the type that the values of columns of types a and b are cast
+// to when the columns are unioned: their type if they have the same type or
+// the other is Unknown, Float if both are numeric, and String otherwise, as
+// every value can be read as a string.
+func Common(a, b Type) Type {
+	switch {
+	case a == b, b == Unknown:
+		return a
+	case a == Unknown:
+		return b
+	case a.Numeric() && b.Numeric():
+		return Float
+	}
+	return String
+}
+
+// Minimum fraction of the non-blank values of a column that must be of a type
+// for the column to be of that type.
+const minFraction = 0.9
+
+// Layouts of the dates recognized by Inferrer, including the floating
+// timestamps of the Socrata API.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.000",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"01/02/2006",
+	"01/02/2006 03:04:05 PM",
+	"2006/01/02",
+}
+
+// An Inferrer infers the type of a column from its values.
+type Inferrer struct {
+	// Number of non-blank values, and of those of each type.
+	values, ints, floats, dates, bools int64
+}
+
+// Add adds a value of the column. Blank values are ignored.
+func (inf *Inferrer) Add(v string) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return
+	}
+	inf.values++
+	if isBool(v) {
+		inf.bools++
+		return
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		inf.ints++
+		inf.floats++
+		return
+	}
+	if x, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(x, 0) && !math.IsNaN(x) {
+		inf.floats++
+		return
+	}
+	if isDate(v) {
+		inf.dates++
+	}
+}
+
+// Type returns the type of the values added so far: Unknown if there are none,
+// otherwise the first of Bool, Int, Float, and Date that at least 90% of the
+// non-blank values are of, or String.
+func (inf *Inferrer) Type() Type {
+	if inf.values == 0 {
+		return Unknown
+	}
+	min := minFraction * float64(inf.values)
+	switch {
+	case float64(inf.bools) >= min:
+		return Bool
+	case float64(inf.ints) >= min:
+		return Int
+	case float64(inf.floats) >= min:
+		return Float
+	case float64(inf.dates) >= min:
+		return Date
+	}
+	return String
+}
+
+func isBool(v string) bool {
+	switch strings.ToLower(v) {
+	case "true", "false", "yes", "no", "t", "f", "y", "n":
+		return true
+	}
+	return false
+}
+
+func isDate(v string) bool {
+	_, ok := ParseDate(v)
+	return ok
+}
+
+// ParseDate parses v as a date in one of the layouts recognized by Inferrer,
+// as UTC if it has no time zone.
+func ParseDate(v string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,74 @@
+package coltype
+
+import "testing"
+
+func TestInferrer(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   Type
+	}{
+		{nil, Unknown},
+		{[]string{"", " "}, Unknown},
+		{[]string{"1", "2", "", "-30"}, Int},
+		{[]string{"1", "2.5", "1e3"}, Float},
+		{[]string{"2020-01-31", "2020-02-01T00:00:00.000", "03/15/2021"}, Date},
+		{[]string{"true", "False", "Y"}, Bool},
+		{[]string{"Queens", "Brooklyn", "1"}, String},
+		// Less than 90% of the values are numbers.
+		{[]string{"1", "2", "3", "4", "5", "6", "7", "8", "n/a", "-"}, String},
+	}
+	for _, tt := range tests {
+		var inf Inferrer
+		for _, v := range tt.values {
+			inf.Add(v)
+		}
+		if got := inf.Type(); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		a, b Type
+		want bool
+	}{
+		{Int, Int, true},
+		{Int, Float, true},
+		{Date, Unknown, true},
+		{String, Int, true},
+		{Date, Int, false},
+		{Date, String, false},
+		{Bool, Int, false},
+		{Float, String, false},
+	}
+	for _, tt := range tests {
+		if got := Compatible(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compatible(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := Compatible(tt.b, tt.a); got != tt.want {
+			t.Errorf("Compatible(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestCommon(t *testing.T) {
+	tests := []struct {
+		a, b, want Type
+	}{
+		{Int, Int, Int},
+		{Int, Float, Float},
+		{Date, Unknown, Date},
+		{Unknown, Unknown, Unknown},
+		{String, Int, String},
+		{Date, Bool, String},
+	}
+	for _, tt := range tests {
+		if got := Common(tt.a, tt.b); got != tt.want {
+			t.Errorf("Common(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+		if got := Common(tt.b, tt.a); got != tt.want {
+			t.Errorf("Common(%q, %q) = %q, want %q", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
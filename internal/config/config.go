@@ -1,6 +1,16 @@
 package config
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/attributeembedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/layout"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
 
 // DatabasePath returns the path to the Open Data Link database.
 // The path is "opendatalink.sqlite", or the contents of the OPENDATALINK_DB
@@ -12,6 +22,26 @@ func DatabasePath() string {
 	return "opendatalink.sqlite"
 }
 
+// DatasetsDir returns the directory of the datasets downloaded by the crawler.
+// The directory is "datasets", or the contents of the OPENDATALINK_DATASETS
+// environment variable if it is set.
+func DatasetsDir() string {
+	if dir := os.Getenv("OPENDATALINK_DATASETS"); dir != "" {
+		return dir
+	}
+	return "datasets"
+}
+
+// DatasetLayout returns the layout of the datasets: that of the manifest at the
+// path of the OPENDATALINK_MANIFEST environment variable if it is set (see
+// layout.ReadManifest), and otherwise that of DatasetsDir.
+func DatasetLayout() (*layout.Layout, error) {
+	if path := os.Getenv("OPENDATALINK_MANIFEST"); path != "" {
+		return layout.ReadManifest(path)
+	}
+	return layout.Dir(DatasetsDir()), nil
+}
+
 // FasttextPath returns the path to the fastText database.
 // The path is "fasttext.sqlite", or the contents of the FASTTEXT_DB environment
 // variable if it is set.
@@ -21,3 +51,291 @@ func FasttextPath() string {
 	}
 	return "fasttext.sqlite"
 }
+
+// Embedding returns the configuration of the embedding model, read from the
+// following environment variables:
+//
+//	OPENDATALINK_EMBEDDER              Backend: fasttext (default), onnx, or remote
+//	FASTTEXT_DB                        FasttextPath (see FasttextPath)
+//	OPENDATALINK_EMBEDDING_WEIGHTING   Weighting: idf, sif, or equal if empty
+//	OPENDATALINK_ONNX_MODEL            ONNX.ModelPath
+//	OPENDATALINK_ONNX_VOCAB            ONNX.VocabPath
+//	OPENDATALINK_ONNX_LIBRARY          ONNX.LibraryPath
+//	OPENDATALINK_ONNX_MAX_TOKENS       ONNX.MaxTokens (default 128)
+//	OPENDATALINK_EMBEDDING_URL         Remote.URL
+//	OPENDATALINK_EMBEDDING_MODEL       Remote.Model
+//	OPENDATALINK_EMBEDDING_API_KEY     Remote.APIKey
+//	OPENDATALINK_EMBEDDING_API_DIM     Remote.Dim (default: found from the API)
+//	OPENDATALINK_EMBEDDING_BATCH       Remote.BatchSize (default 64)
+//	OPENDATALINK_EMBEDDING_RPS         Remote.RequestsPerSecond (default 10)
+//	OPENDATALINK_EMBEDDING_RETRIES     Remote.MaxRetries (default 5)
+//	OPENDATALINK_EMBEDDING_CACHE       Remote.CacheDir
+//	OPENDATALINK_EMBEDDING_CACHE_SIZE  Cache.Size (default 100000)
+//	OPENDATALINK_EMBEDDING_CACHE_DB    Cache.Path
+//	OPENDATALINK_NORMALIZE             Normalize: comma-separated lowercase,
+//	                                   punctuation, fold, and stem steps
+//	OPENDATALINK_STOPWORDS             Normalize.Stopwords: none, lucene, or a path
+//	OPENDATALINK_EXPANSIONS            Normalize.Expansions: default or a path
+//	OPENDATALINK_EMBEDDING_VERSION     Version
+//
+// It returns an error if a numeric variable is not a positive integer or is
+// out of range, or if OPENDATALINK_NORMALIZE names an unknown step.
+func Embedding() (*embedding.Config, error) {
+	maxTokens, err := envInt("OPENDATALINK_ONNX_MAX_TOKENS", 128, 1<<16)
+	if err != nil {
+		return nil, err
+	}
+	remote := embedding.RemoteConfig{
+		URL:      os.Getenv("OPENDATALINK_EMBEDDING_URL"),
+		Model:    os.Getenv("OPENDATALINK_EMBEDDING_MODEL"),
+		APIKey:   os.Getenv("OPENDATALINK_EMBEDDING_API_KEY"),
+		CacheDir: os.Getenv("OPENDATALINK_EMBEDDING_CACHE"),
+	}
+	if os.Getenv("OPENDATALINK_EMBEDDING_API_DIM") != "" {
+		if remote.Dim, err = envInt("OPENDATALINK_EMBEDDING_API_DIM", 0, 1<<16); err != nil {
+			return nil, err
+		}
+	}
+	if remote.BatchSize, err = envInt("OPENDATALINK_EMBEDDING_BATCH", 64, 1<<16); err != nil {
+		return nil, err
+	}
+	if remote.RequestsPerSecond, err = envInt("OPENDATALINK_EMBEDDING_RPS", 10, 1<<16); err != nil {
+		return nil, err
+	}
+	if remote.MaxRetries, err = envInt("OPENDATALINK_EMBEDDING_RETRIES", 5, 100); err != nil {
+		return nil, err
+	}
+	cacheSize, err := envInt("OPENDATALINK_EMBEDDING_CACHE_SIZE", 100000, 1<<30)
+	if err != nil {
+		return nil, err
+	}
+	normalize := embedding.NormalizeConfig{
+		Stopwords:  os.Getenv("OPENDATALINK_STOPWORDS"),
+		Expansions: os.Getenv("OPENDATALINK_EXPANSIONS"),
+	}
+	for _, step := range strings.Split(os.Getenv("OPENDATALINK_NORMALIZE"), ",") {
+		switch strings.TrimSpace(step) {
+		case "":
+		case "lowercase":
+			normalize.Lowercase = true
+		case "punctuation":
+			normalize.StripPunctuation = true
+		case "fold":
+			normalize.Fold = true
+		case "stem":
+			normalize.Stem = true
+		default:
+			return nil, fmt.Errorf("OPENDATALINK_NORMALIZE: unknown step %q (want lowercase, punctuation, fold, or stem)", step)
+		}
+	}
+	return &embedding.Config{
+		Backend:      os.Getenv("OPENDATALINK_EMBEDDER"),
+		FasttextPath: FasttextPath(),
+		Weighting:    os.Getenv("OPENDATALINK_EMBEDDING_WEIGHTING"),
+		DatabasePath: DatabasePath(),
+		ONNX: embedding.ONNXConfig{
+			ModelPath:   os.Getenv("OPENDATALINK_ONNX_MODEL"),
+			VocabPath:   os.Getenv("OPENDATALINK_ONNX_VOCAB"),
+			LibraryPath: os.Getenv("OPENDATALINK_ONNX_LIBRARY"),
+			MaxTokens:   maxTokens,
+		},
+		Remote: remote,
+		Cache: embedding.CacheConfig{
+			Size: cacheSize,
+			Path: os.Getenv("OPENDATALINK_EMBEDDING_CACHE_DB"),
+		},
+		Normalize: normalize,
+		Version:   os.Getenv("OPENDATALINK_EMBEDDING_VERSION"),
+	}, nil
+}
+
+// EditToken returns the bearer token that clients must present to edit dataset
+// metadata through the server API.
+// The token is the contents of the OPENDATALINK_EDIT_TOKEN environment
+// variable. If it is empty, metadata edits are disabled.
+func EditToken() string {
+	return os.Getenv("OPENDATALINK_EDIT_TOKEN")
+}
+
+// AdminToken returns the bearer token that clients must present to use the
+// server's admin endpoints.
+// The token is the contents of the OPENDATALINK_ADMIN_TOKEN environment
+// variable. If it is empty, the admin endpoints are disabled.
+func AdminToken() string {
+	return os.Getenv("OPENDATALINK_ADMIN_TOKEN")
+}
+
+// IndexBackend returns the backend specification of the metadata index (see
+// index.New).
+// The backend is "flat", or the contents of the OPENDATALINK_INDEX_BACKEND
+// environment variable if it is set. "tuned" selects the backend recommended by
+// the tune command.
+func IndexBackend() string {
+	if backend := os.Getenv("OPENDATALINK_INDEX_BACKEND"); backend != "" {
+		return backend
+	}
+	return "flat"
+}
+
+// AttributeIndexPath returns the path of the file the attribute index vectors
+// are memory-mapped from, or "" if the attribute index is held in RAM.
+// The path is the contents of the OPENDATALINK_ATTRIBUTE_INDEX_FILE environment
+// variable.
+func AttributeIndexPath() string {
+	return os.Getenv("OPENDATALINK_ATTRIBUTE_INDEX_FILE")
+}
+
+// AttributeTokenizer returns the tokenizer of attribute names, which expands
+// the default abbreviations and those in the file named by the
+// OPENDATALINK_ABBREVIATIONS environment variable, if it is set (see
+// attributeembedding.LoadAbbreviations).
+//
+// The expansions selected by OPENDATALINK_EXPANSIONS (see Embedding) are
+// expanded as well, ignoring case, since attribute names are often written in
+// lowercase; the abbreviations take precedence.
+func AttributeTokenizer() (*attributeembedding.Tokenizer, error) {
+	abbrevs := attributeembedding.DefaultAbbreviations
+	if path := os.Getenv("OPENDATALINK_ABBREVIATIONS"); path != "" {
+		var err error
+		if abbrevs, err = attributeembedding.LoadAbbreviations(path); err != nil {
+			return nil, err
+		}
+	}
+	expansions, err := embedding.LoadExpansions(os.Getenv("OPENDATALINK_EXPANSIONS"))
+	if err != nil {
+		return nil, err
+	}
+	if expansions == nil {
+		return attributeembedding.NewTokenizer(abbrevs), nil
+	}
+	merged := make(map[string]string, len(abbrevs)+len(expansions))
+	for word, expansion := range expansions {
+		merged[strings.ToLower(word)] = strings.ToLower(expansion)
+	}
+	for abbrev, expansion := range abbrevs {
+		merged[abbrev] = expansion
+	}
+	return attributeembedding.NewTokenizer(merged), nil
+}
+
+// CategoryModels returns the paths of the fastText databases of the embedding
+// models of dataset categories (see embedding.Router), read from the
+// OPENDATALINK_CATEGORY_MODELS environment variable, a comma-separated list of
+// category=path pairs, e.g. "health=health.sqlite,transportation=transit.sqlite".
+// It returns nil if the variable is not set.
+func CategoryModels() (map[string]string, error) {
+	env := os.Getenv("OPENDATALINK_CATEGORY_MODELS")
+	if env == "" {
+		return nil, nil
+	}
+	paths := make(map[string]string)
+	for _, pair := range strings.Split(env, ",") {
+		i := strings.IndexByte(pair, '=')
+		if i <= 0 || i == len(pair)-1 {
+			return nil, fmt.Errorf("OPENDATALINK_CATEGORY_MODELS: invalid category model %q (want category=path)", pair)
+		}
+		paths[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return paths, nil
+}
+
+// VectorEncoding returns the encoding of the embedding vectors written to the
+// vectors tables, named by the OPENDATALINK_VECTOR_ENCODING environment
+// variable: float32 (the default if empty), float16, or int8 (see
+// vec32.Encoding).
+func VectorEncoding() (vec32.Encoding, error) {
+	return vec32.ParseEncoding(os.Getenv("OPENDATALINK_VECTOR_ENCODING"))
+}
+
+// NoEmbPolicy returns the handling of the vectors of texts the embedding model
+// has no embedding for, named by the OPENDATALINK_NO_EMBEDDING environment
+// variable: skip (the default if empty), flag, or fallback (see
+// embedding.NoEmbPolicy).
+func NoEmbPolicy() (embedding.NoEmbPolicy, error) {
+	return embedding.ParseNoEmbPolicy(os.Getenv("OPENDATALINK_NO_EMBEDDING"))
+}
+
+// CORSOrigins returns the origins allowed to make cross-origin requests to the
+// server API and to embed the search widget.
+// The origins are read from the comma-separated OPENDATALINK_CORS_ORIGINS
+// environment variable; "*" allows all origins.
+func CORSOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("OPENDATALINK_CORS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// BaseURL returns the public base URL of the deployment, used for the absolute
+// URLs in sitemaps.
+// The URL is the contents of the OPENDATALINK_BASE_URL environment variable. If
+// it is empty, the server derives it from each request.
+func BaseURL() string {
+	return os.Getenv("OPENDATALINK_BASE_URL")
+}
+
+// IndexParams holds the index hyperparameters.
+type IndexParams struct {
+	// Dimension of the embedding vectors in the embedding indexes, which must
+	// be that of the embedding model (e.g. 384 for all-MiniLM-L6-v2).
+	EmbeddingDim int
+	// Number of LSH Ensemble partitions of the joinability index.
+	LSHPartitions int
+	// Maximum number of hash functions per band (LSH parameter k) of the
+	// joinability index. At most the minhash signature size (256).
+	LSHMaxK int
+	// Number of results of keyword searches.
+	SearchResults int
+	// Number of results of similar dataset searches.
+	SimilarResults int
+}
+
+// IndexParameters returns the index hyperparameters, read from the following
+// environment variables if they are set:
+//
+//	OPENDATALINK_EMBEDDING_DIM         EmbeddingDim (default 300)
+//	OPENDATALINK_LSH_PARTITIONS        LSHPartitions (default 8)
+//	OPENDATALINK_LSH_MAX_K             LSHMaxK (default 4)
+//	OPENDATALINK_SEARCH_RESULTS        SearchResults (default 50)
+//	OPENDATALINK_SIMILAR_RESULTS       SimilarResults (default 20)
+//
+// It returns an error if a variable is not a positive integer or is out of
+// range.
+func IndexParameters() (*IndexParams, error) {
+	var p IndexParams
+	var err error
+
+	if p.EmbeddingDim, err = envInt("OPENDATALINK_EMBEDDING_DIM", 300, 1<<16); err != nil {
+		return nil, err
+	}
+	if p.LSHPartitions, err = envInt("OPENDATALINK_LSH_PARTITIONS", 8, 1024); err != nil {
+		return nil, err
+	}
+	if p.LSHMaxK, err = envInt("OPENDATALINK_LSH_MAX_K", 4, 256); err != nil {
+		return nil, err
+	}
+	if p.SearchResults, err = envInt("OPENDATALINK_SEARCH_RESULTS", 50, 10000); err != nil {
+		return nil, err
+	}
+	if p.SimilarResults, err = envInt("OPENDATALINK_SIMILAR_RESULTS", 20, 10000); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// envInt returns the integer value of the environment variable name, or def if
+// it is not set. The value must be between 1 and max.
+func envInt(name string, def, max int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > max {
+		return 0, fmt.Errorf("%s must be an integer between 1 and %d, got %q", name, max, v)
+	}
+	return n, nil
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// The environment variables set by the flags of ParseFlags.
+var flagVariables = []struct {
+	flag, variable, usage string
+}{
+	{"db", "OPENDATALINK_DB", "path to the Open Data Link database"},
+	{"fasttext", "FASTTEXT_DB", "path to the fastText database"},
+	{"datasets", "OPENDATALINK_DATASETS", "directory of the datasets"},
+	{"manifest", "OPENDATALINK_MANIFEST", "manifest listing the datasets and their files, instead of the datasets directory"},
+	{"log-level", "OPENDATALINK_LOG_LEVEL", "log level: debug, info, or error"},
+}
+
+// ParseFlags defines the flags shared by the commands, parses the command-line
+// flags, and applies the shared flags:
+//
+//	-config     File of environment variables (default: OPENDATALINK_CONFIG)
+//	-db         DatabasePath
+//	-fasttext   FasttextPath
+//	-datasets   DatasetsDir
+//	-manifest   DatasetLayout
+//	-log-level  Level of the standard logger (see SetLogLevel)
+//
+// The flags set the environment variables of the settings, which take
+// precedence over the variables set in the config file (see LoadFile). It
+// exits the program if the config file cannot be read or the log level is
+// invalid.
+func ParseFlags() {
+	configPath := flag.String("config", os.Getenv("OPENDATALINK_CONFIG"), "file of environment variables to use if they are not set")
+	values := make([]*string, len(flagVariables))
+	for i, v := range flagVariables {
+		values[i] = flag.String(v.flag, "", fmt.Sprintf("%v (overrides %v)", v.usage, v.variable))
+	}
+	flag.Parse()
+
+	for i, v := range flagVariables {
+		if *values[i] != "" {
+			os.Setenv(v.variable, *values[i])
+		}
+	}
+	if *configPath != "" {
+		if err := LoadFile(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := SetLogLevel(os.Getenv("OPENDATALINK_LOG_LEVEL")); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// LoadFile sets the environment variables of the config file at path that are
+// not already set. Each line of the file is blank, a comment starting with #,
+// or a variable assignment NAME=value, such as OPENDATALINK_DB=/data/odl.sqlite.
+func LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return fmt.Errorf("%v:%d: want NAME=value", path, n)
+		}
+		name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, value)
+		}
+	}
+	return s.Err()
+}
+
+// SetLogLevel sets the level of the standard logger: debug logs the time in
+// microseconds and the source file and line of each message, info (the
+// default, if level is empty) logs all messages, and error logs only the fatal
+// errors, those logged by the Fatal and Panic functions.
+func SetLogLevel(level string) error {
+	switch level {
+	case "debug":
+		log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+	case "", "info":
+	case "error":
+		log.SetOutput(fatalWriter{os.Stderr})
+	default:
+		return fmt.Errorf("unknown log level %q (want debug, info, or error)", level)
+	}
+	return nil
+}
+
+// fatalWriter writes the messages of the standard logger to w only if they are
+// logged by the Fatal or Panic functions of the log package.
+type fatalWriter struct {
+	w io.Writer
+}
+
+func (f fatalWriter) Write(p []byte) (int, error) {
+	pc := make([]uintptr, 8)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "log.") {
+			name := frame.Function[strings.LastIndexByte(frame.Function, '.')+1:]
+			if strings.HasPrefix(name, "Fatal") || strings.HasPrefix(name, "Panic") {
+				return f.w.Write(p)
+			}
+		}
+		if !more {
+			return len(p), nil
+		}
+	}
+}
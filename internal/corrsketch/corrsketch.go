@@ -0,0 +1,185 @@
+// Package corrsketch implements correlation sketches, which estimate the
+// correlation of numeric columns of different tables after joining the tables
+// on key columns, without joining them.
+//
+// The sketch of a pair of a key column and a numeric column of a table keeps
+// the keys with the smallest hash values, with the mean of the numeric values
+// of their rows. Since all sketches keep the smallest hashes, the sketches of
+// two tables with overlapping keys share a uniform sample of the shared keys,
+// over which the correlation is computed. See Santos et al., "Correlation
+// Sketches for Approximate Join-Correlation Queries", SIGMOD 2021.
+package corrsketch
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Entry is a key of a sketch and the mean of its numeric values.
+type Entry struct {
+	Hash  uint64  `json:"h"`
+	Value float64 `json:"v"`
+}
+
+// Sketch is the correlation sketch of a key column and a numeric column.
+type Sketch struct {
+	// The entries of the keys with the smallest hashes, sorted by hash.
+	Entries []Entry
+	// Maximum number of entries.
+	Size int
+}
+
+// Builder builds a Sketch from the rows of a table.
+type Builder struct {
+	size int
+	keys map[uint64]*mean
+	// Max-heap of the hashes of keys.
+	hashes hashHeap
+}
+
+type mean struct {
+	sum   float64
+	count int
+}
+
+// NewBuilder returns a Builder of a Sketch of at most size keys.
+func NewBuilder(size int) *Builder {
+	return &Builder{size: size, keys: make(map[uint64]*mean)}
+}
+
+// Add adds a row with the given key and numeric value. Rows with a blank key
+// or a value that is not a finite number are ignored.
+func (b *Builder) Add(key, value string) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return
+	}
+	x, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsInf(x, 0) || math.IsNaN(x) {
+		return
+	}
+	h := Hash(key)
+	if m, ok := b.keys[h]; ok {
+		m.sum += x
+		m.count++
+		return
+	}
+	if len(b.hashes) == b.size {
+		if h > b.hashes[0] {
+			return
+		}
+		delete(b.keys, heap.Pop(&b.hashes).(uint64))
+	}
+	heap.Push(&b.hashes, h)
+	b.keys[h] = &mean{x, 1}
+}
+
+// Sketch returns the sketch of the rows added so far, or nil if there are
+// none.
+func (b *Builder) Sketch() *Sketch {
+	if len(b.keys) == 0 {
+		return nil
+	}
+	s := &Sketch{Entries: make([]Entry, 0, len(b.keys)), Size: b.size}
+	for h, m := range b.keys {
+		s.Entries = append(s.Entries, Entry{h, m.sum / float64(m.count)})
+	}
+	sort.Slice(s.Entries, func(i, j int) bool {
+		return s.Entries[i].Hash < s.Entries[j].Hash
+	})
+	return s
+}
+
+// Hash returns the hash of a key: its 64-bit FNV-1a hash, mixed so that the
+// hashes of similar keys are uniformly distributed.
+func Hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	// Finalizer of SplitMix64.
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Correlation returns the Pearson correlation of the numeric values of the
+// keys in both sketches, and the number of such keys. The correlation is 0 if
+// there are fewer than 2 keys or the values of either sketch are constant.
+//
+// Only the keys with hashes up to the smaller of the largest hashes of the two
+// sketches are considered, as larger hashes may have been dropped from the
+// sketch with the smaller maximum.
+func Correlation(a, b *Sketch) (float64, int) {
+	if len(a.Entries) == 0 || len(b.Entries) == 0 {
+		return 0, 0
+	}
+	max := a.Entries[len(a.Entries)-1].Hash
+	if m := b.Entries[len(b.Entries)-1].Hash; m < max {
+		max = m
+	}
+	var xs, ys []float64
+	for i, j := 0, 0; i < len(a.Entries) && j < len(b.Entries); {
+		ea, eb := a.Entries[i], b.Entries[j]
+		switch {
+		case ea.Hash > max || eb.Hash > max:
+			i, j = len(a.Entries), len(b.Entries)
+		case ea.Hash < eb.Hash:
+			i++
+		case ea.Hash > eb.Hash:
+			j++
+		default:
+			xs, ys = append(xs, ea.Value), append(ys, eb.Value)
+			i++
+			j++
+		}
+	}
+	return pearson(xs, ys), len(xs)
+}
+
+// pearson returns the Pearson correlation of xs and ys, or 0 if it is
+// undefined.
+func pearson(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	if n < 2 {
+		return 0
+	}
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx, my = mx/n, my/n
+	var sxy, sxx, syy float64
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return 0
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// hashHeap is a max-heap of hashes.
+type hashHeap []uint64
+
+func (h hashHeap) Len() int            { return len(h) }
+func (h hashHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h hashHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hashHeap) Push(x interface{}) { *h = append(*h, x.(uint64)) }
+
+func (h *hashHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,75 @@
+package corrsketch
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// build returns the sketch of the rows (i, f(i)) for i from start to end-1.
+func build(size, start, end int, f func(i int) float64) *Sketch {
+	b := NewBuilder(size)
+	for i := start; i < end; i++ {
+		b.Add("k"+strconv.Itoa(i), strconv.FormatFloat(f(i), 'g', -1, 64))
+	}
+	return b.Sketch()
+}
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder(3)
+	for _, row := range [][2]string{{"a", "1"}, {"a", "3"}, {"", "5"}, {"b", "n/a"}, {"b", "4"}} {
+		b.Add(row[0], row[1])
+	}
+	s := b.Sketch()
+	if len(s.Entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(s.Entries))
+	}
+	for _, e := range s.Entries {
+		want := 2.0
+		if e.Hash == Hash("b") {
+			want = 4
+		}
+		if e.Value != want {
+			t.Errorf("entry %v: got mean %v, want %v", e.Hash, e.Value, want)
+		}
+	}
+
+	// Only the smallest hashes are kept.
+	s = build(100, 0, 10000, func(i int) float64 { return 0 })
+	if len(s.Entries) != 100 {
+		t.Fatalf("got %d entries, want 100", len(s.Entries))
+	}
+	var kept int
+	for i := 0; i < 10000; i++ {
+		if Hash("k"+strconv.Itoa(i)) <= s.Entries[99].Hash {
+			kept++
+		}
+	}
+	if kept != 100 {
+		t.Errorf("%d keys have hashes up to the largest kept, want 100", kept)
+	}
+}
+
+func TestCorrelation(t *testing.T) {
+	x := build(256, 0, 5000, func(i int) float64 { return float64(i) })
+	tests := []struct {
+		name     string
+		y        *Sketch
+		want     float64
+		minPairs int
+	}{
+		{"linear", build(256, 0, 5000, func(i int) float64 { return 2*float64(i) + 1 }), 1, 200},
+		{"negative", build(256, 2500, 7500, func(i int) float64 { return -float64(i) }), -1, 50},
+		{"constant", build(256, 0, 5000, func(i int) float64 { return 7 }), 0, 200},
+		{"disjoint", build(256, 10000, 15000, func(i int) float64 { return float64(i) }), 0, 0},
+	}
+	for _, tt := range tests {
+		r, n := Correlation(x, tt.y)
+		if math.Abs(r-tt.want) > 1e-6 {
+			t.Errorf("%s: correlation %v, want %v", tt.name, r, tt.want)
+		}
+		if n < tt.minPairs {
+			t.Errorf("%s: %d shared keys, want at least %d", tt.name, n, tt.minPairs)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Package crawl fetches the web resources of the harvesters politely: it
+// identifies the requests with the -useragent, limits the requests in flight
+// to -maxconns, spaces the requests to each host by -hostdelay, retries the
+// requests that fail, or that are throttled or fail on the server, with
+// backoff, waiting as long as their Retry-After header asks, and makes
+// conditional requests with the validators of the previous responses, which
+// may be kept in the http_validators table (see
+// sql/create_http_validators_table.sql) from one harvest to the next.
+//
+// The crawlers of web sites also obey the rules and the crawl delay of the
+// robots.txt of the hosts. The harvesters of the APIs of data portals do not,
+// as robots.txt is meant for crawlers, and that of CKAN for one disallows its
+// API.
+package crawl
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	userAgent = flag.String("useragent", "OpenDataLinkBot/1.0 (+https://github.com/DataIntelligenceCrew/OpenDataLink)", "User-Agent of the requests, whose product name is matched against the rules of robots.txt")
+	hostDelay = flag.Duration("hostdelay", 0, "minimum delay between the requests to a host")
+	maxConns  = flag.Int("maxconns", 8, "maximum number of requests in flight, or 0 for no limit")
+)
+
+const (
+	// Attempts of each request, and the delay before the first retry, which
+	// doubles for each of the next, unless set by the Client
+	defaultAttempts   = 5
+	defaultFirstRetry = time.Second
+	// Longest Retry-After delay waited for
+	maxRetryAfter = 5 * time.Minute
+	// Maximum size of the error bodies read, and of robots.txt
+	maxBodySize = 1 << 16
+	maxRobots   = 500 << 10
+)
+
+var (
+	// ErrDisallowed is the error of the requests disallowed by robots.txt.
+	ErrDisallowed = errors.New("disallowed by robots.txt")
+	// ErrNotModified is the error of the conditional requests of resources
+	// that did not change.
+	ErrNotModified = errors.New("not modified")
+)
+
+// Client makes the requests of a harvester. The zero Client retries the
+// requests with the default client, without delay; NewClient returns one with
+// the settings of the flags. A Client may be used by several goroutines.
+type Client struct {
+	// Client of the requests, or http.DefaultClient if nil.
+	HTTP *http.Client
+	// User-Agent of the requests, that of the http package if empty.
+	UserAgent string
+	// Minimum delay between the requests to a host, raised to the crawl
+	// delay of its robots.txt with ObeyRobots.
+	Delay time.Duration
+	// Whether to obey the robots.txt of the hosts, as crawlers do.
+	ObeyRobots bool
+	// Maximum number of requests in flight, or 0 for no limit.
+	MaxConns int
+	// Attempts of each request, and the delay before the first retry, 5 and
+	// a second if 0.
+	Attempts   int
+	FirstRetry time.Duration
+	// Authorize, if not nil, authorizes each attempt of a request, such as by
+	// signing it.
+	Authorize func(*http.Request) error
+	// Message, if not nil, returns the message of the error body of a failed
+	// request, or "".
+	Message func(body []byte) string
+
+	once  sync.Once
+	conns chan struct{}
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+// host is the state of the requests to a host.
+type host struct {
+	mu sync.Mutex
+	// Time before which the next request may not be made.
+	next time.Time
+	// Rules of robots.txt, read by the first request to the host.
+	robots     *Robots
+	robotsErr  error
+	robotsOnce sync.Once
+}
+
+// NewClient returns a Client with the -useragent, -hostdelay, and -maxconns
+// settings.
+func NewClient() *Client {
+	return &Client{UserAgent: *userAgent, Delay: *hostDelay, MaxConns: *maxConns}
+}
+
+func (c *Client) init() {
+	c.once.Do(func() {
+		if c.MaxConns > 0 {
+			c.conns = make(chan struct{}, c.MaxConns)
+		}
+		c.hosts = make(map[string]*host)
+	})
+}
+
+// Agent returns the product name of the User-Agent, which robots.txt names.
+func (c *Client) Agent() string {
+	name := strings.Fields(c.UserAgent + " ")[0]
+	return strings.SplitN(name, "/", 2)[0]
+}
+
+// host returns the state of the requests to the host of the scheme.
+func (c *Client) host(scheme, name string) *host {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := scheme + "://" + name
+	h := c.hosts[key]
+	if h == nil {
+		h = &host{}
+		c.hosts[key] = h
+	}
+	return h
+}
+
+// wait waits for the turn of the next request to the host, at least delay
+// after the previous one.
+func (h *host) wait(delay time.Duration) {
+	h.mu.Lock()
+	now := time.Now()
+	at := h.next
+	if at.Before(now) {
+		at = now
+	}
+	h.next = at.Add(delay)
+	h.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
+
+// Get gets the resource at u.
+func (c *Client) Get(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends the request, which has no body, such as a GET request with
+// headers, and returns its response if its status is 200 OK, or 206 Partial
+// Content for the requests of a Range. The requests disallowed by robots.txt
+// fail with ErrDisallowed, and the conditional requests of resources not
+// modified with ErrNotModified.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	c.init()
+	h := c.host(req.URL.Scheme, req.URL.Host)
+	delay := c.Delay
+	if c.ObeyRobots {
+		r, err := c.robots(h, req)
+		if err != nil {
+			return nil, err
+		}
+		if !r.Allowed(req.URL.RequestURI()) {
+			return nil, fmt.Errorf("%v %v: %w", req.Method, req.URL, ErrDisallowed)
+		}
+		if r.CrawlDelay > delay {
+			delay = r.CrawlDelay
+		}
+	}
+	attempts, retry := c.Attempts, c.FirstRetry
+	if attempts <= 0 {
+		attempts = defaultAttempts
+	}
+	if retry <= 0 {
+		retry = defaultFirstRetry
+	}
+	for attempt := 1; ; attempt++ {
+		h.wait(delay)
+		res, err := c.send(req)
+		if err == nil && (res.StatusCode == http.StatusOK ||
+			res.StatusCode == http.StatusPartialContent && req.Header.Get("Range") != "") {
+			return res, nil
+		}
+		var after time.Duration
+		if err == nil {
+			if res.StatusCode == http.StatusNotModified {
+				res.Body.Close()
+				return nil, fmt.Errorf("%v %v: %w", req.Method, req.URL, ErrNotModified)
+			}
+			after = retryAfter(res.Header.Get("Retry-After"))
+			err = c.statusError(req, res)
+			if res.StatusCode != http.StatusTooManyRequests && res.StatusCode < 500 {
+				return nil, err
+			}
+		}
+		if attempt == attempts {
+			return nil, err
+		}
+		wait := retry
+		if after > wait {
+			wait = after
+		}
+		log.Printf("%v, retrying in %v", err, wait)
+		time.Sleep(wait)
+		retry *= 2
+	}
+}
+
+// send sends an attempt of the request.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.conns != nil {
+		c.conns <- struct{}{}
+		defer func() { <-c.conns }()
+	}
+	req = req.Clone(req.Context())
+	if c.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	if c.Authorize != nil {
+		if err := c.Authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// statusError returns the error of the failed response of the request,
+// closing its body.
+func (c *Client) statusError(req *http.Request, res *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxBodySize))
+	res.Body.Close()
+	if c.Message != nil {
+		if m := c.Message(body); m != "" {
+			return fmt.Errorf("%v %v: %v: %v", req.Method, req.URL, res.Status, m)
+		}
+	}
+	return fmt.Errorf("%v %v: %v", req.Method, req.URL, res.Status)
+}
+
+// retryAfter returns the delay of a Retry-After header, in seconds or an HTTP
+// date, or 0 if there is none, up to maxRetryAfter.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	var d time.Duration
+	if s, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
+
+// Validators are the validators of a response, with which a conditional
+// request gets the resource only if it changed.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// GetIfChanged gets the resource at u if it changed since the response of the
+// validators v, if any, failing with ErrNotModified otherwise, and sets v to
+// the validators of the response.
+func (c *Client) GetIfChanged(u string, v *Validators) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	v.ETag, v.LastModified = res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+	return res, nil
+}
@@ -0,0 +1,195 @@
+package crawl
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestParseRobots(t *testing.T) {
+	r, err := ParseRobots(strings.NewReader(`
+# Rules of all agents
+User-agent: *
+Disallow: /
+
+User-agent: OtherBot
+User-agent: opendatalinkbot
+Disallow: /private/
+Allow: /private/open$
+Disallow: /*.json
+Crawl-delay: 2.5
+
+Sitemap: https://example.org/sitemap.xml
+`), "OpenDataLinkBot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{
+		"/":                  true,
+		"/datasets?page=2":   true,
+		"/private/":          false,
+		"/private/open":      true,
+		"/private/open/more": false,
+		"/data/records.json": false,
+	} {
+		if got := r.Allowed(path); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if r.CrawlDelay != 2500*time.Millisecond {
+		t.Errorf("got crawl delay %v, want 2.5s", r.CrawlDelay)
+	}
+	if len(r.Sitemaps) != 1 || r.Sitemaps[0] != "https://example.org/sitemap.xml" {
+		t.Errorf("got sitemaps %q", r.Sitemaps)
+	}
+	if r, err = ParseRobots(strings.NewReader("User-agent: *\nDisallow: /\n"), "OpenDataLinkBot"); err != nil {
+		t.Fatal(err)
+	}
+	if r.Allowed("/datasets") {
+		t.Error("path allowed by a robots.txt disallowing all agents")
+	}
+
+	// The rules of invalid UTF-8 are ignored.
+	if r, err = ParseRobots(strings.NewReader("User-Agent:\nAllow:\xa3\nUser-agent: *\nDisallow: /a\xa3\nDisallow: /b\n"), "OpenDataLinkBot"); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Allowed("/a") || r.Allowed("/b") {
+		t.Error("got rules of invalid UTF-8, or not the valid rules")
+	}
+}
+
+func TestDo(t *testing.T) {
+	var throttled int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "TestBot/1.0" {
+			http.Error(w, "no user agent", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: testbot\nDisallow: /private/\n"))
+		case "/throttled":
+			if atomic.AddInt32(&throttled, 1) < 3 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, `{"message": "slow down"}`, http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte("ok"))
+		case "/data.csv":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte("a,b\n1,2\n"))
+		default:
+			http.Error(w, `{"message": "no such resource"}`, http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	c := &Client{
+		UserAgent:  "TestBot/1.0",
+		ObeyRobots: true,
+		Delay:      10 * time.Millisecond,
+		FirstRetry: time.Millisecond,
+		Message: func(body []byte) string {
+			if strings.Contains(string(body), "no such") {
+				return "no such resource"
+			}
+			return ""
+		},
+	}
+
+	start := time.Now()
+	res, err := c.Get(srv.URL + "/throttled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	// robots.txt and three attempts, each after the delay of the host.
+	if throttled != 3 {
+		t.Errorf("got %d attempts, want 3", throttled)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("requests took %v, want at least 30ms of delays", elapsed)
+	}
+
+	if _, err := c.Get(srv.URL + "/private/data.csv"); !errors.Is(err, ErrDisallowed) {
+		t.Errorf("got error %v, want ErrDisallowed", err)
+	}
+	if _, err := c.Get(srv.URL + "/missing"); err == nil || !strings.HasSuffix(err.Error(), "404 Not Found: no such resource") {
+		t.Errorf("got error %v, want the message of the 404 error", err)
+	}
+
+	v := &Validators{}
+	if res, err = c.GetIfChanged(srv.URL+"/data.csv", v); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if v.ETag != `"v1"` {
+		t.Errorf("got validators %+v, want ETag \"v1\"", v)
+	}
+	if _, err := c.GetIfChanged(srv.URL+"/data.csv", v); !errors.Is(err, ErrNotModified) {
+		t.Errorf("got error %v, want ErrNotModified", err)
+	}
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Robots(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Allowed("/private/") {
+		t.Error("robots.txt of the host not read for the agent")
+	}
+}
+
+func TestValidators(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	u := "https://example.org/catalog.json"
+	want := &Validators{ETag: `"abc"`, LastModified: "Wed, 14 Oct 2026 12:00:00 GMT"}
+	// Without the table, the validators are not recorded.
+	if err := WriteValidators(db, u, want); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ReadValidators(db, u); err != nil || *v != (Validators{}) {
+		t.Errorf("got validators %+v (%v) without the table, want none", v, err)
+	}
+
+	schema, err := ioutil.ReadFile("../../sql/create_http_validators_table.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteValidators(db, u, want); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ReadValidators(db, u); err != nil || *v != *want {
+		t.Errorf("got validators %+v (%v), want %+v", v, err, want)
+	}
+}
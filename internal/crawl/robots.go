@@ -0,0 +1,177 @@
+package crawl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// robotsRule is an Allow or Disallow rule of robots.txt.
+type robotsRule struct {
+	allow   bool
+	pattern *regexp.Regexp
+	// Length of the path of the rule, the longest matching rule applying.
+	length int
+}
+
+// Robots are the rules of the robots.txt of a host for a crawler.
+type Robots struct {
+	rules      []*robotsRule
+	CrawlDelay time.Duration
+	// URLs of the sitemaps of the host.
+	Sitemaps []string
+}
+
+// Allowed reports whether the path, with its query, may be crawled.
+func (r *Robots) Allowed(path string) bool {
+	var match *robotsRule
+	for _, rule := range r.rules {
+		if rule.pattern.MatchString(path) && (match == nil || rule.length > match.length ||
+			rule.length == match.length && rule.allow) {
+			match = rule
+		}
+	}
+	return match == nil || match.allow
+}
+
+// rulePattern returns the regular expression of the path pattern of a rule,
+// in which * matches any characters and a final $ the end of the path. It
+// fails for the paths that are not valid UTF-8.
+func rulePattern(path string) (*regexp.Regexp, error) {
+	end := strings.HasSuffix(path, "$")
+	path = strings.TrimSuffix(path, "$")
+	var b strings.Builder
+	b.WriteString("^")
+	for i, part := range strings.Split(path, "*") {
+		if i > 0 {
+			b.WriteString(".*")
+		}
+		b.WriteString(regexp.QuoteMeta(part))
+	}
+	if end {
+		b.WriteString("$")
+	}
+	return regexp.Compile(b.String())
+}
+
+// ParseRobots parses the robots.txt read from r, returning the rules of the
+// group of the agent, or else of the group of all agents (*).
+func ParseRobots(r io.Reader, agent string) (*Robots, error) {
+	agent = strings.ToLower(agent)
+	groups := make(map[string]*Robots)
+	var current []*Robots
+	// Whether the last line was a User-agent line, starting a group with
+	// the next agents.
+	inAgents := false
+	res := &Robots{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		key, value := strings.ToLower(strings.TrimSpace(line[:i])), strings.TrimSpace(line[i+1:])
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = nil
+			}
+			inAgents = true
+			g := groups[strings.ToLower(value)]
+			if g == nil {
+				g = &Robots{}
+				groups[strings.ToLower(value)] = g
+			}
+			current = append(current, g)
+			continue
+		case "allow", "disallow":
+			// An empty Disallow allows all, and the rules whose paths
+			// cannot be matched, such as of invalid UTF-8, are ignored.
+			if value == "" {
+				break
+			}
+			pattern, err := rulePattern(value)
+			if err != nil {
+				break
+			}
+			for _, g := range current {
+				g.rules = append(g.rules, &robotsRule{allow: key == "allow", pattern: pattern, length: len(value)})
+			}
+		case "crawl-delay":
+			if d, err := strconv.ParseFloat(value, 64); err == nil && d > 0 {
+				for _, g := range current {
+					g.CrawlDelay = time.Duration(d * float64(time.Second))
+				}
+			}
+		case "sitemap":
+			res.Sitemaps = append(res.Sitemaps, value)
+		}
+		inAgents = false
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	g := groups[agent]
+	if g == nil {
+		g = groups["*"]
+	}
+	if g != nil {
+		res.rules, res.CrawlDelay = g.rules, g.CrawlDelay
+	}
+	return res, nil
+}
+
+// Robots returns the rules of the robots.txt of the host of u for the
+// client's agent, which allow all if the host has none, read by the first
+// request to the host.
+func (c *Client) Robots(u *url.URL) (*Robots, error) {
+	c.init()
+	req := &http.Request{Method: http.MethodGet, URL: u}
+	return c.robots(c.host(u.Scheme, u.Host), req)
+}
+
+func (c *Client) robots(h *host, req *http.Request) (*Robots, error) {
+	h.robotsOnce.Do(func() {
+		h.robots, h.robotsErr = c.readRobots(h, req)
+	})
+	return h.robots, h.robotsErr
+}
+
+// readRobots reads the robots.txt of the host of the request. The hosts whose
+// robots.txt cannot be read are crawled without one, as those that have none.
+func (c *Client) readRobots(h *host, req *http.Request) (*Robots, error) {
+	u := &url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: "/robots.txt"}
+	robotsReq, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	h.wait(c.Delay)
+	res, err := c.send(robotsReq)
+	if err != nil {
+		log.Printf("%v; crawling %v without robots.txt", err, u.Host)
+		return &Robots{}, nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		if res.StatusCode != http.StatusNotFound && res.StatusCode != http.StatusGone {
+			log.Printf("GET %v: %v; crawling %v without robots.txt", u, res.Status, u.Host)
+		}
+		return &Robots{}, nil
+	}
+	r, err := ParseRobots(io.LimitReader(res.Body, maxRobots), c.Agent())
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", u, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,34 @@
+package crawl
+
+import (
+	"database/sql"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+// ReadValidators returns the validators of the resource at u recorded in the
+// http_validators table, which are empty if the database has no such table or
+// the resource was never recorded.
+func ReadValidators(db *sql.DB, u string) (*Validators, error) {
+	v := &Validators{}
+	if ok, err := database.HasTable(db, "http_validators"); err != nil || !ok {
+		return v, err
+	}
+	err := db.QueryRow(`SELECT etag, last_modified FROM http_validators WHERE url = ?`, u).Scan(&v.ETag, &v.LastModified)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return v, err
+}
+
+// WriteValidators records the validators of the resource at u in the
+// http_validators table, if the database has one, once its response was
+// processed, so that the next harvest skips the resource unless it changed.
+func WriteValidators(db *sql.DB, u string, v *Validators) error {
+	if ok, err := database.HasTable(db, "http_validators"); err != nil || !ok {
+		return err
+	}
+	_, err := db.Exec(`INSERT OR REPLACE INTO http_validators (url, etag, last_modified) VALUES (?, ?, ?)`,
+		u, v.ETag, v.LastModified)
+	return err
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Retries of a chunk whose database is busy, and the delays between them,
+// doubling from minBackoff up to maxBackoff.
+const (
+	maxRetries = 10
+	minBackoff = 50 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// sleep is time.Sleep, replaced by the tests.
+var sleep = time.Sleep
+
+// IsBusy reports whether err is an SQLITE_BUSY or SQLITE_LOCKED error, of a
+// database locked by another connection, such as that of a running server.
+// Errors formatted into others without wrapping are recognized by their
+// messages.
+func IsBusy(err error) bool {
+	var e sqlite3.Error
+	if errors.As(err, &e) {
+		return e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked
+	}
+	return err != nil && (strings.Contains(err.Error(), sqlite3.ErrBusy.Error()) ||
+		strings.Contains(err.Error(), sqlite3.ErrLocked.Error()))
+}
+
+// A Chunker writes to a database in a transaction per chunk of Size units of
+// work, such as datasets, instead of one transaction for a whole run, so that
+// other connections to the database, such as a running server, are not locked
+// out for long, and a run that is interrupted keeps the chunks it committed.
+//
+// The writes of a chunk are kept until it is committed, and if the database
+// is busy or locked by another connection, the chunk is rolled back and its
+// writes done again in a new transaction, after a backoff doubling up to 5s,
+// up to 10 times.
+type Chunker struct {
+	DB *sql.DB
+	// Number of units of work per chunk, at least 1.
+	Size int
+	// Prepare, if not nil, is called with the transaction of each chunk
+	// before its writes, to prepare their statements.
+	Prepare func(tx *sql.Tx) error
+	// If Rollback is set, the chunks are rolled back instead of committed,
+	// as in a dry run.
+	Rollback bool
+	// Committed, if not nil, is called after each chunk is committed.
+	Committed func()
+
+	tx     *sql.Tx
+	writes []func(*sql.Tx) error
+	units  int
+}
+
+// Write runs f in a savepoint of the transaction of the current chunk, which
+// it begins if there is none, and returns the error of f. If f fails, its
+// changes are rolled back and the chunk goes on without them. If the database
+// is busy, the chunk is done again with f, and an error is returned only if
+// it cannot be. Since f may be called again, for a later retry of the chunk,
+// it must not depend on variables changed by later writes, such as the
+// variables of a loop.
+func (c *Chunker) Write(f func(tx *sql.Tx) error) error {
+	var err error
+	if c.tx == nil {
+		err = c.replay()
+	}
+	if err == nil {
+		err = Savepoint(c.tx, func() error { return f(c.tx) })
+	}
+	for attempt := 0; IsBusy(err) && attempt < maxRetries; attempt++ {
+		if c.tx != nil {
+			c.tx.Rollback()
+			c.tx = nil
+		}
+		c.backoff(attempt, err)
+		if err = c.replay(); err == nil {
+			err = Savepoint(c.tx, func() error { return f(c.tx) })
+		}
+	}
+	if err == nil {
+		c.writes = append(c.writes, f)
+	}
+	return err
+}
+
+// Done ends a unit of work, committing the chunk if it holds Size units.
+func (c *Chunker) Done() error {
+	c.units++
+	if c.units < c.Size {
+		return nil
+	}
+	return c.Close()
+}
+
+// Close commits the current chunk, if any.
+func (c *Chunker) Close() error {
+	if c.tx == nil && len(c.writes) == 0 {
+		c.units = 0
+		return nil
+	}
+	var err error
+	if c.tx == nil {
+		err = c.replay()
+	}
+	for attempt := 0; ; attempt++ {
+		if c.tx != nil {
+			err = c.commit()
+		}
+		if !IsBusy(err) || attempt == maxRetries {
+			break
+		}
+		c.backoff(attempt, err)
+		if err = c.replay(); err != nil && !IsBusy(err) {
+			break
+		}
+	}
+	c.tx, c.writes, c.units = nil, nil, 0
+	if err != nil {
+		return fmt.Errorf("committing a chunk: %w", err)
+	}
+	if c.Committed != nil {
+		c.Committed()
+	}
+	return nil
+}
+
+// begin begins the transaction of a chunk, retrying while the database is
+// busy.
+func (c *Chunker) begin() error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if c.tx, err = c.DB.Begin(); err == nil && c.Prepare != nil {
+			if err = c.Prepare(c.tx); err != nil {
+				c.tx.Rollback()
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		c.tx = nil
+		if !IsBusy(err) || attempt == maxRetries {
+			return err
+		}
+		c.backoff(attempt, err)
+	}
+}
+
+// replay begins a new transaction for the chunk and does its writes, if any,
+// again. A write that fails again fails the chunk.
+func (c *Chunker) replay() error {
+	if err := c.begin(); err != nil {
+		return err
+	}
+	for _, f := range c.writes {
+		if err := Savepoint(c.tx, func() error { return f(c.tx) }); err != nil {
+			c.tx.Rollback()
+			c.tx = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// commit commits or rolls back the transaction of the chunk. The driver rolls
+// back a transaction that fails to commit.
+func (c *Chunker) commit() error {
+	tx := c.tx
+	c.tx = nil
+	if c.Rollback {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
+// backoff waits before the attempt+1th retry of a chunk that failed with err.
+func (c *Chunker) backoff(attempt int, err error) {
+	d := minBackoff << uint(attempt)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	log.Printf("database busy (%v), retrying in %v", err, d)
+	sleep(d)
+}
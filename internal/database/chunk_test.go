@@ -0,0 +1,107 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestChunker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Without a busy timeout, a locked database fails at once.
+	path := filepath.Join(dir, "test.sqlite") + "?_busy_timeout=0"
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE t (n INTEGER NOT NULL PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	other, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// Another connection holds the database locked until the chunker has
+	// retried twice.
+	lock, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	}
+	var retries int
+	defer func() { sleep = time.Sleep }()
+	sleep = func(time.Duration) {
+		if retries++; retries == 2 {
+			lock.Rollback()
+		}
+	}
+
+	var commits int
+	var stmt *sql.Stmt
+	c := &Chunker{
+		DB:   db,
+		Size: 2,
+		Prepare: func(tx *sql.Tx) (err error) {
+			stmt, err = tx.Prepare(`INSERT INTO t VALUES (?)`)
+			return err
+		},
+		Committed: func() { commits++ },
+	}
+	for n := 1; n <= 5; n++ {
+		n := n
+		err := c.Write(func(tx *sql.Tx) error {
+			if n == 3 {
+				return errors.New("skipped")
+			}
+			_, err := stmt.Exec(n)
+			return err
+		})
+		if (err != nil) != (n == 3) {
+			t.Errorf("write %d: got %v", n, err)
+		}
+		if err := c.Done(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query(`SELECT n FROM t ORDER BY n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []int
+	for rows.Next() {
+		var n int
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, n)
+	}
+	if len(got) != 4 || got[0] != 1 || got[2] != 4 || retries != 2 || commits != 3 {
+		t.Errorf("got rows %v after %d retries and %d commits, want [1 2 4 5] after 2 retries and 3 commits", got, retries, commits)
+	}
+}
+
+func TestIsBusy(t *testing.T) {
+	if !IsBusy(errors.New("error writing sketch abcd-1234: database is locked")) || IsBusy(errors.New("no such table: t")) || IsBusy(nil) {
+		t.Error("IsBusy does not recognize the busy errors by their messages")
+	}
+}
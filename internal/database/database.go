@@ -5,8 +5,18 @@ package database
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"strings"
+	"time"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coldomain"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/corrsketch"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/numsketch"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/pii"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/spatial"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/temporal"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/topk"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
 	"github.com/ekzhu/lshensemble"
 )
@@ -14,6 +24,10 @@ import (
 // DB is a wrapper of the Open Data Link database.
 type DB struct {
 	*sql.DB
+	// If HideSensitiveSamples is true, the sample values of the column
+	// sketches of the columns flagged as holding personal information (see
+	// package pii) are blanked.
+	HideSensitiveSamples bool
 }
 
 // New open the database.
@@ -22,7 +36,7 @@ func New(databasePath string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db}, nil
+	return &DB{DB: db}, nil
 }
 
 // ColumnSketch is a row of the column_sketches table.
@@ -55,9 +69,112 @@ func (db *DB) ColumnSketch(columnID string) (*ColumnSketch, error) {
 	if err := json.Unmarshal(sample, &c.Sample); err != nil {
 		return nil, err
 	}
+	if err := db.hideSensitiveSamples(c.DatasetID, &c); err != nil {
+		return nil, err
+	}
 	return &c, nil
 }
 
+// hideSensitiveSamples blanks the sample values of the sketches of the
+// sensitive columns among cols, columns of the given dataset, if
+// db.HideSensitiveSamples is true.
+func (db *DB) hideSensitiveSamples(datasetID string, cols ...*ColumnSketch) error {
+	if !db.HideSensitiveSamples {
+		return nil
+	}
+	sensitive, err := db.DatasetSensitiveColumns(datasetID)
+	if err != nil {
+		return err
+	}
+	for _, c := range cols {
+		if len(sensitive[c.ColumnID]) > 0 {
+			c.Sample = make([]string, len(c.Sample))
+		}
+	}
+	return nil
+}
+
+// ColumnSketchByName returns the ColumnSketch of the named column of a
+// dataset. If the dataset has several columns with that name, the one with the
+// smallest column ID is returned.
+func (db *DB) ColumnSketchByName(datasetID, columnName string) (*ColumnSketch, error) {
+	var columnID string
+	err := db.QueryRow(`
+	SELECT column_id FROM column_sketches
+	WHERE dataset_id = ? AND column_name = ?
+	ORDER BY column_id LIMIT 1`, datasetID, columnName).Scan(&columnID)
+	if err != nil {
+		return nil, err
+	}
+	return db.ColumnSketch(columnID)
+}
+
+// scanNumericSketch scans a column ID and a row of the numeric_sketches table.
+func scanNumericSketch(row interface{ Scan(...interface{}) error }) (string, *numsketch.Sketch, error) {
+	var columnID string
+	var s numsketch.Sketch
+	var quantiles, histogram []byte
+	err := row.Scan(&columnID, &s.Count, &s.Min, &s.Max, &s.Mean, &quantiles, &histogram)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := json.Unmarshal(quantiles, &s.Quantiles); err != nil {
+		return "", nil, err
+	}
+	if err := json.Unmarshal(histogram, &s.Histogram); err != nil {
+		return "", nil, err
+	}
+	return columnID, &s, nil
+}
+
+// NumericSketch returns the sketch of the distribution of a numeric column.
+func (db *DB) NumericSketch(columnID string) (*numsketch.Sketch, error) {
+	_, s, err := scanNumericSketch(db.QueryRow(`
+	SELECT column_id, count, min, max, mean, quantiles, histogram
+	FROM numeric_sketches
+	WHERE column_id = ?`, columnID))
+	return s, err
+}
+
+// NumericSketches returns the sketches of the distributions of all numeric
+// columns by column ID.
+func (db *DB) NumericSketches() (map[string]*numsketch.Sketch, error) {
+	return db.numericSketches(`
+	SELECT column_id, count, min, max, mean, quantiles, histogram
+	FROM numeric_sketches`)
+}
+
+// DatasetNumericSketches returns the sketches of the distributions of the
+// numeric columns of a dataset by column ID. It returns no sketches if the
+// database has no numeric_sketches table.
+func (db *DB) DatasetNumericSketches(datasetID string) (map[string]*numsketch.Sketch, error) {
+	if ok, err := HasTable(db, "numeric_sketches"); err != nil || !ok {
+		return nil, err
+	}
+	return db.numericSketches(`
+	SELECT column_id, count, min, max, mean, quantiles, histogram
+	FROM numeric_sketches
+	WHERE dataset_id = ?`, datasetID)
+}
+
+func (db *DB) numericSketches(query string, args ...interface{}) (map[string]*numsketch.Sketch, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sketches := make(map[string]*numsketch.Sketch)
+	for rows.Next() {
+		columnID, s, err := scanNumericSketch(rows)
+		if err != nil {
+			return nil, err
+		}
+		sketches[columnID] = s
+	}
+	return sketches, rows.Err()
+}
+
 // DatasetColumns returns the column sketches for the dataset with the given ID.
 func (db *DB) DatasetColumns(datasetID string) ([]*ColumnSketch, error) {
 	var cols []*ColumnSketch
@@ -91,9 +208,322 @@ func (db *DB) DatasetColumns(datasetID string) ([]*ColumnSketch, error) {
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	if err := db.hideSensitiveSamples(datasetID, cols...); err != nil {
+		return nil, err
+	}
 	return cols, nil
 }
 
+// NumericColumns returns the IDs of the columns of a dataset that have a
+// numeric sketch. It returns no columns if the database has no
+// numeric_sketches table.
+func (db *DB) NumericColumns(datasetID string) (map[string]bool, error) {
+	if ok, err := HasTable(db, "numeric_sketches"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`
+	SELECT column_id FROM numeric_sketches WHERE dataset_id = ?`, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var columnID string
+		if err := rows.Scan(&columnID); err != nil {
+			return nil, err
+		}
+		cols[columnID] = true
+	}
+	return cols, rows.Err()
+}
+
+// ColumnTypes returns the inferred types of all columns by column ID. It
+// returns no types if the database has no column_types table.
+func (db *DB) ColumnTypes() (map[string]coltype.Type, error) {
+	return db.columnTypes(`SELECT column_id, column_type FROM column_types`)
+}
+
+// DatasetColumnTypes returns the inferred types of the columns of a dataset by
+// column ID (see ColumnTypes).
+func (db *DB) DatasetColumnTypes(datasetID string) (map[string]coltype.Type, error) {
+	return db.columnTypes(`
+	SELECT column_id, column_type FROM column_types WHERE dataset_id = ?`, datasetID)
+}
+
+func (db *DB) columnTypes(query string, args ...interface{}) (map[string]coltype.Type, error) {
+	if ok, err := HasTable(db, "column_types"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := make(map[string]coltype.Type)
+	for rows.Next() {
+		var columnID, name string
+		if err := rows.Scan(&columnID, &name); err != nil {
+			return nil, err
+		}
+		t, err := coltype.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		types[columnID] = t
+	}
+	return types, rows.Err()
+}
+
+// ColumnUniqueness returns the uniqueness of all columns by column ID: their
+// approximate distinct counts divided by their numbers of rows. It returns no
+// columns if the database has no column_uniqueness table.
+func (db *DB) ColumnUniqueness() (map[string]float64, error) {
+	return db.columnUniqueness(`SELECT column_id, uniqueness FROM column_uniqueness`)
+}
+
+// DatasetColumnUniqueness returns the uniqueness of the columns of a dataset by
+// column ID (see ColumnUniqueness).
+func (db *DB) DatasetColumnUniqueness(datasetID string) (map[string]float64, error) {
+	return db.columnUniqueness(`
+	SELECT column_id, uniqueness FROM column_uniqueness WHERE dataset_id = ?`, datasetID)
+}
+
+func (db *DB) columnUniqueness(query string, args ...interface{}) (map[string]float64, error) {
+	if ok, err := HasTable(db, "column_uniqueness"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uniqueness := make(map[string]float64)
+	for rows.Next() {
+		var columnID string
+		var u float64
+		if err := rows.Scan(&columnID, &u); err != nil {
+			return nil, err
+		}
+		uniqueness[columnID] = u
+	}
+	return uniqueness, rows.Err()
+}
+
+// ColumnDomains returns the semantic domains of all columns in a domain by
+// column ID. It returns no columns if the database has no column_domains table.
+func (db *DB) ColumnDomains() (map[string]coldomain.Domain, error) {
+	return db.columnDomains(`SELECT column_id, domain FROM column_domains`)
+}
+
+// DatasetColumnDomains returns the semantic domains of the columns of a
+// dataset in a domain by column ID (see ColumnDomains).
+func (db *DB) DatasetColumnDomains(datasetID string) (map[string]coldomain.Domain, error) {
+	return db.columnDomains(`
+	SELECT column_id, domain FROM column_domains WHERE dataset_id = ?`, datasetID)
+}
+
+func (db *DB) columnDomains(query string, args ...interface{}) (map[string]coldomain.Domain, error) {
+	if ok, err := HasTable(db, "column_domains"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := make(map[string]coldomain.Domain)
+	for rows.Next() {
+		var columnID, name string
+		if err := rows.Scan(&columnID, &name); err != nil {
+			return nil, err
+		}
+		d, err := coldomain.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		domains[columnID] = d
+	}
+	return domains, rows.Err()
+}
+
+// DatasetSensitiveColumns returns the kinds of personal information of the
+// sensitive columns of a dataset by column ID (see package pii). It returns no
+// columns if the database has no sensitive_columns table.
+func (db *DB) DatasetSensitiveColumns(datasetID string) (map[string][]pii.Kind, error) {
+	if ok, err := HasTable(db, "sensitive_columns"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`
+	SELECT column_id, kinds FROM sensitive_columns WHERE dataset_id = ?`, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sensitive := make(map[string][]pii.Kind)
+	for rows.Next() {
+		var columnID, kinds string
+		if err := rows.Scan(&columnID, &kinds); err != nil {
+			return nil, err
+		}
+		if sensitive[columnID], err = pii.ParseList(kinds); err != nil {
+			return nil, err
+		}
+	}
+	return sensitive, rows.Err()
+}
+
+// DatasetProfile is a row of the dataset_profiles table.
+type DatasetProfile struct {
+	DatasetID   string
+	RowCount    int64
+	ColumnCount int
+	ProfiledAt  string
+}
+
+// DatasetProfile returns the profile of a dataset, or nil if it has none or
+// the database has no dataset_profiles table.
+func (db *DB) DatasetProfile(datasetID string) (*DatasetProfile, error) {
+	if ok, err := HasTable(db, "dataset_profiles"); err != nil || !ok {
+		return nil, err
+	}
+	p := DatasetProfile{DatasetID: datasetID}
+	err := db.QueryRow(`
+	SELECT row_count, column_count, profiled_at FROM dataset_profiles WHERE dataset_id = ?`,
+		datasetID).Scan(&p.RowCount, &p.ColumnCount, &p.ProfiledAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ColumnProfile is a row of the column_profiles table. The numeric summaries
+// are nil if the column has no numeric values.
+type ColumnProfile struct {
+	ColumnID      string
+	DatasetID     string
+	ColumnName    string
+	Type          coltype.Type
+	NullCount     int64
+	NullRate      float64
+	DistinctCount int64
+	TopValues     []*topk.Item
+	NumericCount  int64
+	Min           *float64
+	Max           *float64
+	Mean          *float64
+	Stddev        *float64
+}
+
+// DatasetColumnProfiles returns the profiles of the columns of a dataset, in
+// the order of the columns. It returns no profiles if the database has no
+// column_profiles table.
+func (db *DB) DatasetColumnProfiles(datasetID string) ([]*ColumnProfile, error) {
+	if ok, err := HasTable(db, "column_profiles"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`
+	SELECT column_id, column_name, column_type, null_count, null_rate, distinct_count,
+		top_values, numeric_count, min, max, mean, stddev
+	FROM column_profiles WHERE dataset_id = ? ORDER BY rowid`, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []*ColumnProfile
+	for rows.Next() {
+		c := ColumnProfile{DatasetID: datasetID}
+		var typ, top string
+		var min, max, mean, stddev sql.NullFloat64
+		err := rows.Scan(&c.ColumnID, &c.ColumnName, &typ, &c.NullCount, &c.NullRate,
+			&c.DistinctCount, &top, &c.NumericCount, &min, &max, &mean, &stddev)
+		if err != nil {
+			return nil, err
+		}
+		if c.Type, err = coltype.Parse(typ); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(top), &c.TopValues); err != nil {
+			return nil, fmt.Errorf("top values of %v: %w", c.ColumnID, err)
+		}
+		c.Min, c.Max, c.Mean, c.Stddev = nullFloat(min), nullFloat(max), nullFloat(mean), nullFloat(stddev)
+		profiles = append(profiles, &c)
+	}
+	return profiles, rows.Err()
+}
+
+// nullFloat returns a pointer to the value of f, or nil if it is null.
+func nullFloat(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
+
+// DomainDatasets returns the set of IDs of the datasets having a column in the
+// domain. It returns no datasets if the database has no column_domains table.
+func (db *DB) DomainDatasets(domain coldomain.Domain) (map[string]bool, error) {
+	if ok, err := HasTable(db, "column_domains"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`
+	SELECT DISTINCT dataset_id FROM column_domains WHERE domain = ?`, domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	datasets := make(map[string]bool)
+	for rows.Next() {
+		var datasetID string
+		if err := rows.Scan(&datasetID); err != nil {
+			return nil, err
+		}
+		datasets[datasetID] = true
+	}
+	return datasets, rows.Err()
+}
+
+// AttributeVectors returns the attribute names of a dataset and their
+// attribute embedding vectors, ordered by name.
+func (db *DB) AttributeVectors(datasetID string) ([]string, [][]float32, error) {
+	rows, err := db.Query(`
+	SELECT attribute_name, emb
+	FROM attribute_vectors
+	WHERE dataset_id = ?
+	ORDER BY attribute_name`, datasetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	var vecs [][]float32
+	for rows.Next() {
+		var name string
+		var emb []byte
+		if err := rows.Scan(&name, &emb); err != nil {
+			return nil, nil, err
+		}
+		vec, err := vec32.FromBytes(emb)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+		vecs = append(vecs, vec)
+	}
+	return names, vecs, rows.Err()
+}
+
 // Metadata is a row of the metadata table.
 type Metadata struct {
 	DatasetID    string
@@ -118,23 +548,24 @@ func (db *DB) DatasetName(datasetID string) (string, error) {
 	return name, nil
 }
 
-// Metadata returns the metadata for the dataset with the given ID.
-func (db *DB) Metadata(datasetID string) (*Metadata, error) {
-	m := Metadata{DatasetID: datasetID}
+const metadataColumns = `
+	dataset_id,
+	name,
+	description,
+	attribution,
+	contact_email,
+	updated_at,
+	categories,
+	tags,
+	permalink`
+
+// scanMetadata scans a row of metadataColumns into a Metadata.
+func scanMetadata(row interface{ Scan(...interface{}) error }) (*Metadata, error) {
+	var m Metadata
 	var categories, tags string
 
-	err := db.QueryRow(`
-	SELECT
-		name,
-		description,
-		attribution,
-		contact_email,
-		updated_at,
-		categories,
-		tags,
-		permalink
-	FROM metadata
-	WHERE dataset_id = ?`, datasetID).Scan(
+	err := row.Scan(
+		&m.DatasetID,
 		&m.Name,
 		&m.Description,
 		&m.Attribution,
@@ -152,10 +583,149 @@ func (db *DB) Metadata(datasetID string) (*Metadata, error) {
 	if tags != "" {
 		m.Tags = strings.Split(tags, ",")
 	}
-
 	return &m, nil
 }
 
+// Metadata returns the metadata for the dataset with the given ID.
+func (db *DB) Metadata(datasetID string) (*Metadata, error) {
+	return scanMetadata(db.QueryRow(`
+	SELECT`+metadataColumns+`
+	FROM metadata
+	WHERE dataset_id = ?`, datasetID))
+}
+
+// AllMetadata returns the metadata of all datasets, ordered by dataset ID.
+func (db *DB) AllMetadata() ([]*Metadata, error) {
+	rows, err := db.Query(`
+	SELECT` + metadataColumns + `
+	FROM metadata
+	ORDER BY dataset_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ms []*Metadata
+	for rows.Next() {
+		m, err := scanMetadata(rows)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// ColumnCounts returns the number of sketched columns of each dataset that has
+// any.
+func (db *DB) ColumnCounts() (map[string]int, error) {
+	counts := make(map[string]int)
+
+	rows, err := db.Query(`
+	SELECT dataset_id, count(*) FROM column_sketches GROUP BY dataset_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var datasetID string
+		var n int
+		if err := rows.Scan(&datasetID, &n); err != nil {
+			return nil, err
+		}
+		counts[datasetID] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// Tables holding per-dataset data used to build the indexes. Tables that do
+// not exist in the database are skipped by DeleteDataset.
+var indexedTables = []string{
+	"metadata_vectors",
+	"column_sketches",
+	"attribute_vectors",
+	"numeric_sketches",
+	"column_types",
+	"column_uniqueness",
+	"column_domains",
+	"relationships",
+	"correlation_sketches",
+	"time_columns",
+	"spatial_columns",
+	"sensitive_columns",
+	"dataset_profiles",
+	"column_profiles",
+	"source_columns",
+	"source_distributions",
+	"harvested_datasets",
+	"source_objects",
+	"sketch_versions",
+	"attribute_ingestion",
+	"attribute_profiles",
+	"index_shards",
+	"vector_versions",
+	"ingestion_errors",
+	"dataset_checksums",
+}
+
+// DeleteDataset deletes a dataset's vectors, column sketches, and shard
+// assignments, so it is left out of the indexes the next time they are built.
+// If keepMetadata is false, the dataset's metadata is deleted as well;
+// otherwise the dataset page remains available.
+func (db *DB) DeleteDataset(datasetID string, keepMetadata bool) error {
+	existing := make(map[string]bool)
+
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table'`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tables := indexedTables
+	if !keepMetadata {
+		tables = append(tables[:len(tables):len(tables)], "metadata")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if !existing[table] {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE dataset_id = ?`, datasetID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	// Relationships referencing the dataset's columns are deleted as well.
+	if existing["relationships"] {
+		if _, err := tx.Exec(`DELETE FROM relationships WHERE key_dataset_id = ?`, datasetID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // MetadataVector returns the metadata embedding vector for a dataset.
 func (db *DB) MetadataVector(datasetID string) ([]float32, error) {
 	var emb []byte
@@ -171,3 +741,598 @@ func (db *DB) MetadataVector(datasetID string) ([]float32, error) {
 	}
 	return vec, nil
 }
+
+// ShardAssignments returns the shard number of each dataset in the index with
+// the given name.
+func (db *DB) ShardAssignments(indexName string) (map[string]int, error) {
+	assigned := make(map[string]int)
+
+	rows, err := db.Query(`
+	SELECT dataset_id, shard FROM index_shards WHERE index_name = ?`, indexName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var datasetID string
+		var shard int
+		if err := rows.Scan(&datasetID, &shard); err != nil {
+			return nil, err
+		}
+		assigned[datasetID] = shard
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return assigned, nil
+}
+
+// SetShardAssignments saves the shard number of each dataset in the index with
+// the given name.
+func (db *DB) SetShardAssignments(indexName string, assigned map[string]int) error {
+	if len(assigned) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`
+	INSERT OR REPLACE INTO index_shards (index_name, dataset_id, shard)
+	VALUES (?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for datasetID, shard := range assigned {
+		if _, err := stmt.Exec(indexName, datasetID, shard); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteShardAssignments deletes the shard assignments of the given datasets
+// in the index with the given name.
+func (db *DB) DeleteShardAssignments(indexName string, datasetIDs []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`
+	DELETE FROM index_shards WHERE index_name = ? AND dataset_id = ?`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, datasetID := range datasetIDs {
+		if _, err := stmt.Exec(indexName, datasetID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CorrelationSketch is the correlation sketch of a pair of a key column and a
+// numeric column of a dataset, computed by correlation_sketches.
+type CorrelationSketch struct {
+	KeyColumnID, ValueColumnID string
+	DatasetID                  string
+	*corrsketch.Sketch
+}
+
+// KeyCorrelationSketches returns the correlation sketches of the numeric
+// columns of the dataset of the key column with the given ID, keyed by it.
+func (db *DB) KeyCorrelationSketches(keyColumnID string) ([]*CorrelationSketch, error) {
+	return db.correlationSketches(`
+	SELECT key_column_id, value_column_id, dataset_id, size, entries
+	FROM correlation_sketches
+	WHERE key_column_id = ?`, keyColumnID)
+}
+
+// ValueCorrelationSketches returns the correlation sketches of the numeric
+// column with the given ID, one for each key column of its dataset.
+func (db *DB) ValueCorrelationSketches(valueColumnID string) ([]*CorrelationSketch, error) {
+	return db.correlationSketches(`
+	SELECT key_column_id, value_column_id, dataset_id, size, entries
+	FROM correlation_sketches
+	WHERE value_column_id = ?`, valueColumnID)
+}
+
+func (db *DB) correlationSketches(query string, args ...interface{}) ([]*CorrelationSketch, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sketches []*CorrelationSketch
+	for rows.Next() {
+		c := CorrelationSketch{Sketch: new(corrsketch.Sketch)}
+		var entries []byte
+		if err := rows.Scan(&c.KeyColumnID, &c.ValueColumnID, &c.DatasetID, &c.Size, &entries); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(entries, &c.Entries); err != nil {
+			return nil, err
+		}
+		sketches = append(sketches, &c)
+	}
+	return sketches, rows.Err()
+}
+
+// SketchVersions returns the versions of the column sketches of the datasets by
+// dataset ID, which sketch_columns increments each time it sketches a new
+// version of a dataset. It returns no versions if the database has no
+// sketch_versions table.
+func (db *DB) SketchVersions() (map[string]int64, error) {
+	if ok, err := HasTable(db, "sketch_versions"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT dataset_id, version FROM sketch_versions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[string]int64)
+	for rows.Next() {
+		var datasetID string
+		var version int64
+		if err := rows.Scan(&datasetID, &version); err != nil {
+			return nil, err
+		}
+		versions[datasetID] = version
+	}
+	return versions, rows.Err()
+}
+
+// TimeLayout is the layout of the times of the time_columns table, in UTC, so
+// that they compare as strings.
+const TimeLayout = "2006-01-02T15:04:05Z"
+
+// TimeColumn is the extent of the times of a date column, computed by
+// sketch_columns.
+type TimeColumn struct {
+	ColumnID, DatasetID string
+	temporal.Extent
+}
+
+// TimeColumn returns the time column with the given ID.
+func (db *DB) TimeColumn(columnID string) (*TimeColumn, error) {
+	return scanTimeColumn(db.QueryRow(`
+	SELECT column_id, dataset_id, granularity, min_time, max_time
+	FROM time_columns
+	WHERE column_id = ?`, columnID))
+}
+
+// TimeColumns returns the time columns whose extents overlap the range from
+// start to end. It returns no columns if the database has no time_columns
+// table.
+func (db *DB) TimeColumns(start, end time.Time) ([]*TimeColumn, error) {
+	if ok, err := HasTable(db, "time_columns"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`
+	SELECT column_id, dataset_id, granularity, min_time, max_time
+	FROM time_columns
+	WHERE min_time <= ? AND max_time >= ?`,
+		end.UTC().Format(TimeLayout), start.UTC().Format(TimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []*TimeColumn
+	for rows.Next() {
+		c, err := scanTimeColumn(rows)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func scanTimeColumn(row interface{ Scan(...interface{}) error }) (*TimeColumn, error) {
+	var c TimeColumn
+	var granularity, minTime, maxTime string
+	if err := row.Scan(&c.ColumnID, &c.DatasetID, &granularity, &minTime, &maxTime); err != nil {
+		return nil, err
+	}
+	var err error
+	if c.Granularity, err = temporal.ParseGranularity(granularity); err != nil {
+		return nil, err
+	}
+	if c.Min, err = time.Parse(TimeLayout, minTime); err != nil {
+		return nil, err
+	}
+	if c.Max, err = time.Parse(TimeLayout, maxTime); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SpatialColumn is the spatial extent of a column, or of a pair of latitude
+// and longitude columns, computed by sketch_columns.
+type SpatialColumn struct {
+	ColumnID, DatasetID string
+	// The longitude column of spatial.LatLong extents.
+	LongitudeColumnID string
+	spatial.Extent
+}
+
+const spatialColumnsQuery = `
+SELECT column_id, dataset_id, kind, longitude_column_id,
+	min_lat, min_long, max_lat, max_long, regions
+FROM spatial_columns`
+
+// DatasetSpatialColumns returns the spatial columns of a dataset. It returns
+// no columns if the database has no spatial_columns table.
+func (db *DB) DatasetSpatialColumns(datasetID string) ([]*SpatialColumn, error) {
+	return db.spatialColumns(spatialColumnsQuery+` WHERE dataset_id = ?`, datasetID)
+}
+
+// SpatialColumns returns the spatial columns whose extents may overlap q: the
+// columns of boxed kinds whose boxes intersect that of q, if q is boxed, and
+// the columns of the kind of q otherwise. It returns no columns if the
+// database has no spatial_columns table.
+func (db *DB) SpatialColumns(q *spatial.Extent) ([]*SpatialColumn, error) {
+	if !q.Kind.Boxed() {
+		return db.spatialColumns(spatialColumnsQuery+` WHERE kind = ?`, q.Kind)
+	}
+	return db.spatialColumns(spatialColumnsQuery+`
+	WHERE kind IN (?, ?, ?)
+	AND min_lat <= ? AND max_lat >= ? AND min_long <= ? AND max_long >= ?`,
+		spatial.LatLong, spatial.Point, spatial.Geohash,
+		q.Box.MaxLat, q.Box.MinLat, q.Box.MaxLong, q.Box.MinLong)
+}
+
+func (db *DB) spatialColumns(query string, args ...interface{}) ([]*SpatialColumn, error) {
+	if ok, err := HasTable(db, "spatial_columns"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []*SpatialColumn
+	for rows.Next() {
+		var c SpatialColumn
+		var kind string
+		var longitudeColumnID sql.NullString
+		var minLat, minLong, maxLat, maxLong sql.NullFloat64
+		var regions []byte
+		err := rows.Scan(&c.ColumnID, &c.DatasetID, &kind, &longitudeColumnID,
+			&minLat, &minLong, &maxLat, &maxLong, &regions)
+		if err != nil {
+			return nil, err
+		}
+		if c.Kind, err = spatial.ParseKind(kind); err != nil {
+			return nil, err
+		}
+		c.LongitudeColumnID = longitudeColumnID.String
+		c.Box = spatial.Box{MinLat: minLat.Float64, MinLong: minLong.Float64, MaxLat: maxLat.Float64, MaxLong: maxLong.Float64}
+		if regions != nil {
+			if err := json.Unmarshal(regions, &c.Regions); err != nil {
+				return nil, err
+			}
+		}
+		columns = append(columns, &c)
+	}
+	return columns, rows.Err()
+}
+
+// Relationship is a probable foreign key relationship between the columns of
+// two datasets, inferred by infer_relationships: the values of the column
+// ColumnID are (mostly) values of the candidate key column KeyColumnID.
+type Relationship struct {
+	ColumnID     string
+	DatasetID    string
+	KeyColumnID  string
+	KeyDatasetID string
+	Containment  float64
+	SharedValues float64
+}
+
+// Relationships returns the relationships in which a column of the dataset is
+// the referencing or the referenced column, by decreasing containment. It
+// returns no relationships if the database has no relationships table.
+func (db *DB) Relationships(datasetID string) ([]*Relationship, error) {
+	if ok, err := HasTable(db, "relationships"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`
+	SELECT column_id, dataset_id, key_column_id, key_dataset_id, containment, shared_values
+	FROM relationships
+	WHERE dataset_id = ? OR key_dataset_id = ?
+	ORDER BY containment DESC, column_id, key_column_id`, datasetID, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rels []*Relationship
+	for rows.Next() {
+		var r Relationship
+		err := rows.Scan(&r.ColumnID, &r.DatasetID, &r.KeyColumnID, &r.KeyDatasetID, &r.Containment, &r.SharedValues)
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, &r)
+	}
+	return rels, rows.Err()
+}
+
+// SetRelationships replaces all relationships with rels.
+func (db *DB) SetRelationships(rels []*Relationship) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM relationships`); err != nil {
+		tx.Rollback()
+		return err
+	}
+	stmt, err := tx.Prepare(`
+	INSERT INTO relationships
+	(column_id, dataset_id, key_column_id, key_dataset_id, containment, shared_values)
+	VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rels {
+		_, err := stmt.Exec(r.ColumnID, r.DatasetID, r.KeyColumnID, r.KeyDatasetID, r.Containment, r.SharedValues)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// InsertMetadata inserts a metadata row for m, replacing any existing row for
+// m.DatasetID.
+func (db *DB) InsertMetadata(m *Metadata) error {
+	_, err := db.Exec(`
+	INSERT OR REPLACE INTO metadata (`+metadataColumns+`)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.DatasetID,
+		m.Name,
+		m.Description,
+		m.Attribution,
+		m.ContactEmail,
+		m.UpdatedAt,
+		strings.Join(m.Categories, ","),
+		strings.Join(m.Tags, ","),
+		m.Permalink)
+	return err
+}
+
+// UpdateMetadata updates the metadata row for m.DatasetID.
+func (db *DB) UpdateMetadata(m *Metadata) error {
+	_, err := db.Exec(`
+	UPDATE metadata SET
+		name = ?,
+		description = ?,
+		attribution = ?,
+		contact_email = ?,
+		updated_at = ?,
+		categories = ?,
+		tags = ?,
+		permalink = ?
+	WHERE dataset_id = ?`,
+		m.Name,
+		m.Description,
+		m.Attribution,
+		m.ContactEmail,
+		m.UpdatedAt,
+		strings.Join(m.Categories, ","),
+		strings.Join(m.Tags, ","),
+		m.Permalink,
+		m.DatasetID)
+	return err
+}
+
+// SetMetadataVector sets the metadata embedding vector for a dataset, in the
+// encoding of the vector it replaces, if any.
+func (db *DB) SetMetadataVector(datasetID string, vec []float32) error {
+	var old []byte
+	err := db.QueryRow(`
+	SELECT emb FROM metadata_vectors WHERE dataset_id = ?`, datasetID).Scan(&old)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	_, err = db.Exec(`
+	INSERT OR REPLACE INTO metadata_vectors (dataset_id, emb) VALUES (?, ?)`,
+		datasetID, vec32.Encode(vec, vec32.EncodingOf(old)))
+	return err
+}
+
+// DeleteMetadataVector deletes the metadata embedding vector for a dataset and
+// its recorded version, if any.
+func (db *DB) DeleteMetadataVector(datasetID string) error {
+	if _, err := db.Exec(`DELETE FROM metadata_vectors WHERE dataset_id = ?`, datasetID); err != nil {
+		return err
+	}
+	ok, err := HasTable(db, "vector_versions")
+	if err != nil || !ok {
+		return err
+	}
+	_, err = db.Exec(`
+	DELETE FROM vector_versions WHERE vectors = 'metadata_vectors' AND dataset_id = ?`, datasetID)
+	return err
+}
+
+// VectorModel returns the name of the embedding model of a dataset's vectors
+// recorded in the vector_models table, or "" (the default model) if none is
+// recorded.
+func (db *DB) VectorModel(datasetID string) (string, error) {
+	var model string
+	err := db.QueryRow(`
+	SELECT model FROM vector_models WHERE dataset_id = ?`, datasetID).Scan(&model)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return model, err
+}
+
+// SetVectorModel records the name and dimension of the embedding model of a
+// dataset's vectors.
+func (db *DB) SetVectorModel(datasetID, model string, dim int) error {
+	_, err := db.Exec(`
+	INSERT OR REPLACE INTO vector_models (dataset_id, model, dim) VALUES (?, ?, ?)`,
+		datasetID, model, dim)
+	return err
+}
+
+// VectorModelDims returns the dimensions of the vectors of each embedding
+// model recorded in the vector_models table.
+func (db *DB) VectorModelDims() (map[string][]int, error) {
+	rows, err := db.Query(`SELECT DISTINCT model, dim FROM vector_models`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dims := make(map[string][]int)
+	for rows.Next() {
+		var model string
+		var dim int
+		if err := rows.Scan(&model, &dim); err != nil {
+			return nil, err
+		}
+		dims[model] = append(dims[model], dim)
+	}
+	return dims, rows.Err()
+}
+
+// HasTable reports whether the database queried by q (a DB, sql.DB, or sql.Tx)
+// has a table with the given name.
+func HasTable(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, name string) (bool, error) {
+	var n int
+	err := q.QueryRow(`
+	SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	return n > 0, err
+}
+
+// Savepoint runs f in a savepoint of tx. If f returns an error, the changes it
+// made are rolled back, and tx can go on to be committed without them.
+func Savepoint(tx *sql.Tx, f func() error) error {
+	if _, err := tx.Exec(`SAVEPOINT write`); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		if _, rerr := tx.Exec(`ROLLBACK TO write`); rerr != nil {
+			return fmt.Errorf("%v (rolling back: %v)", err, rerr)
+		}
+		tx.Exec(`RELEASE write`)
+		return err
+	}
+	_, err := tx.Exec(`RELEASE write`)
+	return err
+}
+
+// VersionRecorder records the versions of the embedding models of vectors in
+// the vector_versions table, for finding the vectors that are stale after a
+// model upgrade. A nil VersionRecorder records nothing.
+type VersionRecorder struct {
+	stmt *sql.Stmt
+}
+
+// PrepareVersionRecorder prepares a VersionRecorder recording the versions of
+// vectors written in tx. It returns nil if the database has no vector_versions
+// table.
+func PrepareVersionRecorder(tx *sql.Tx) (*VersionRecorder, error) {
+	ok, err := HasTable(tx, "vector_versions")
+	if err != nil || !ok {
+		return nil, err
+	}
+	stmt, err := tx.Prepare(`
+	INSERT OR REPLACE INTO vector_versions (vectors, dataset_id, name, model, version, dim)
+	VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	return &VersionRecorder{stmt}, nil
+}
+
+// Record records the model, version, and dimension of a vector in the given
+// vectors table. name is the key of the vector within the dataset: the
+// attribute name of attribute vectors, the column ID of value vectors, or ""
+// for metadata vectors.
+func (r *VersionRecorder) Record(vectors, datasetID, name, model, version string, dim int) error {
+	if r == nil {
+		return nil
+	}
+	_, err := r.stmt.Exec(vectors, datasetID, name, model, version, dim)
+	return err
+}
+
+// Close closes the prepared statement of r.
+func (r *VersionRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.stmt.Close()
+}
+
+// SetVectorVersion records the model, version, and dimension of a vector (see
+// VersionRecorder.Record), unless the database has no vector_versions table.
+func (db *DB) SetVectorVersion(vectors, datasetID, name, model, version string, dim int) error {
+	ok, err := HasTable(db, "vector_versions")
+	if err != nil || !ok {
+		return err
+	}
+	_, err = db.Exec(`
+	INSERT OR REPLACE INTO vector_versions (vectors, dataset_id, name, model, version, dim)
+	VALUES (?, ?, ?, ?, ?, ?)`, vectors, datasetID, name, model, version, dim)
+	return err
+}
+
+// IndexTuning is a row of the index_tuning table.
+type IndexTuning struct {
+	IndexName string
+	Backend   string
+	Recall    float64
+	LatencyUs int64
+	TunedAt   string
+}
+
+// IndexTuning returns the recommended backend of the index with the given
+// name. It returns sql.ErrNoRows if the index has not been tuned.
+func (db *DB) IndexTuning(indexName string) (*IndexTuning, error) {
+	t := IndexTuning{IndexName: indexName}
+
+	err := db.QueryRow(`
+	SELECT backend, recall, latency_us, tuned_at
+	FROM index_tuning
+	WHERE index_name = ?`, indexName).Scan(&t.Backend, &t.Recall, &t.LatencyUs, &t.TunedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SetIndexTuning saves the recommended backend of t.IndexName.
+func (db *DB) SetIndexTuning(t *IndexTuning) error {
+	_, err := db.Exec(`
+	INSERT OR REPLACE INTO index_tuning (index_name, backend, recall, latency_us, tuned_at)
+	VALUES (?, ?, ?, ?, ?)`,
+		t.IndexName, t.Backend, t.Recall, t.LatencyUs, t.TunedAt)
+	return err
+}
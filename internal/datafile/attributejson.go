@@ -0,0 +1,319 @@
+package datafile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// AttributeRecord is an attribute listed in an attribute.json file.
+type AttributeRecord struct {
+	AttributeName string
+	DatasetID     string
+}
+
+// SchemaError is an error in an attribute.json file.
+type SchemaError struct {
+	// Position of the error in the file, from 1.
+	Line, Column int
+	// Path of the field in error, as in "[1].AttributeName", or "" for the
+	// whole file.
+	Field string
+	Msg   string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Column, e.Field, e.Msg)
+}
+
+// SchemaErrors are the errors in an attribute.json file.
+type SchemaErrors []*SchemaError
+
+func (errs SchemaErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ReadAttributeJSON reads the attributes of the dataset with the given ID from
+// its attribute.json file, which holds an attribute object or a list of them:
+//
+//	{"AttributeName": "school_name", "DatasetID": "abcd-1234"}
+//	[{"AttributeName": "school_name"}, {"AttributeName": "zip"}]
+//
+// The AttributeName of each attribute is required, and its DatasetID, which
+// is the given ID if it is omitted, must be the given ID. The file is
+// validated strictly, and all the errors in it are returned as SchemaErrors:
+// unknown fields, values that are not strings, and duplicate attributes are
+// errors, as are JSON syntax errors, after which the file is not validated
+// further.
+func ReadAttributeJSON(r io.Reader, datasetID string) ([]*AttributeRecord, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	v := &attributeValidator{data: data, dec: json.NewDecoder(bytes.NewReader(data)), datasetID: datasetID}
+	attrs := v.validate()
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+	return attrs, nil
+}
+
+type attributeValidator struct {
+	data      []byte
+	dec       *json.Decoder
+	datasetID string
+	errs      SchemaErrors
+}
+
+// errSyntax stops the validation at a syntax error, which has been recorded.
+var errSyntax = errors.New("syntax error")
+
+// errorf records an error of the field at the given offset.
+func (v *attributeValidator) errorf(offset int, field, format string, args ...interface{}) {
+	line := 1 + bytes.Count(v.data[:offset], []byte("\n"))
+	column := offset - bytes.LastIndexByte(v.data[:offset], '\n')
+	v.errs = append(v.errs, &SchemaError{line, column, field, fmt.Sprintf(format, args...)})
+}
+
+// syntaxError records the error of the decoder, which is a syntax error or an
+// unexpected end of file.
+func (v *attributeValidator) syntaxError(err error) error {
+	offset := len(v.data)
+	msg := "unexpected end of file"
+	var serr *json.SyntaxError
+	if errors.As(err, &serr) && !strings.HasPrefix(serr.Error(), "unexpected end") {
+		// The offset is after the invalid character.
+		if offset = int(serr.Offset) - 1; offset < 0 {
+			offset = 0
+		}
+		msg = serr.Error()
+	}
+	v.errorf(offset, "", "%s", msg)
+	return errSyntax
+}
+
+// next returns the offset of the next token.
+func (v *attributeValidator) next() int {
+	offset := int(v.dec.InputOffset())
+	for offset < len(v.data) && strings.IndexByte(" \t\r\n,:", v.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// token returns the next token and its offset.
+func (v *attributeValidator) token() (json.Token, int, error) {
+	offset := v.next()
+	tok, err := v.dec.Token()
+	if err == io.EOF && offset < len(v.data) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		return nil, offset, v.syntaxError(err)
+	}
+	return tok, offset, err
+}
+
+func (v *attributeValidator) validate() []*AttributeRecord {
+	tok, offset, err := v.token()
+	if err == io.EOF {
+		v.errorf(offset, "", "empty file, want an attribute object or a list of them")
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	var attrs []*AttributeRecord
+	// Path of each attribute, by name.
+	names := make(map[string]string)
+	add := func(a *AttributeRecord, offset int, field string) {
+		if a == nil {
+			return
+		}
+		if prev, ok := names[a.AttributeName]; ok {
+			v.errorf(offset, field, "duplicate attribute %q (also %v)", a.AttributeName, prev)
+			return
+		}
+		if field == "" {
+			field = "the file"
+		}
+		names[a.AttributeName] = field
+		attrs = append(attrs, a)
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		a, err := v.object(offset, "")
+		if err != nil {
+			return nil
+		}
+		add(a, offset, "")
+	case json.Delim('['):
+		for i := 0; v.dec.More(); i++ {
+			field := fmt.Sprintf("[%d]", i)
+			tok, offset, err := v.token()
+			if err != nil {
+				return nil
+			}
+			if tok != json.Delim('{') {
+				v.errorf(offset, field, "want an attribute object, got %v", describe(tok))
+				if err := v.skip(tok); err != nil {
+					return nil
+				}
+				continue
+			}
+			a, err := v.object(offset, field)
+			if err != nil {
+				return nil
+			}
+			add(a, offset, field)
+		}
+		if _, _, err := v.token(); err != nil {
+			return nil
+		}
+	default:
+		v.errorf(offset, "", "want an attribute object or a list of them, got %v", describe(tok))
+		if err := v.skip(tok); err != nil {
+			return nil
+		}
+	}
+	if _, offset, err := v.token(); err == nil {
+		v.errorf(offset, "", "unexpected data after the attributes")
+	}
+	return attrs
+}
+
+// object validates an attribute object at the given offset whose opening
+// brace has been read. It returns nil if the attribute is invalid.
+func (v *attributeValidator) object(start int, path string) (*AttributeRecord, error) {
+	field := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+	a := &AttributeRecord{}
+	fields := make(map[string]bool)
+	valid := true
+	for v.dec.More() {
+		tok, offset, err := v.token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+		var raw json.RawMessage
+		if err := v.dec.Decode(&raw); err != nil {
+			return nil, v.syntaxError(err)
+		}
+		var dst *string
+		switch key {
+		case "AttributeName":
+			dst = &a.AttributeName
+		case "DatasetID":
+			dst = &a.DatasetID
+		default:
+			msg := fmt.Sprintf("unknown field %q", key)
+			for _, known := range []string{"AttributeName", "DatasetID"} {
+				if strings.EqualFold(key, known) {
+					msg += fmt.Sprintf(" (did you mean %q?)", known)
+				}
+			}
+			v.errorf(offset, field(key), "%s", msg)
+			valid = false
+			continue
+		}
+		if fields[key] {
+			v.errorf(offset, field(key), "duplicate field")
+			valid = false
+			continue
+		}
+		fields[key] = true
+		if err := json.Unmarshal(raw, dst); err != nil || raw[0] != '"' {
+			var value interface{}
+			json.Unmarshal(raw, &value)
+			v.errorf(offset, field(key), "want a string, got %v", describe(value))
+			valid = false
+			continue
+		}
+		if strings.TrimSpace(*dst) == "" {
+			v.errorf(offset, field(key), "blank %v", key)
+			valid = false
+		}
+	}
+	if _, _, err := v.token(); err != nil {
+		return nil, err
+	}
+
+	if !fields["AttributeName"] {
+		v.errorf(start, path, "missing AttributeName")
+		valid = false
+	}
+	if a.DatasetID == "" {
+		a.DatasetID = v.datasetID
+	} else if strings.TrimSpace(a.DatasetID) != "" && a.DatasetID != v.datasetID {
+		v.errorf(start, field("DatasetID"), "DatasetID %q is not the ID of the dataset, %q", a.DatasetID, v.datasetID)
+		valid = false
+	}
+	if !valid {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// skip skips the value starting with tok.
+func (v *attributeValidator) skip(tok json.Token) error {
+	if tok != json.Delim('{') && tok != json.Delim('[') {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, _, err := v.token()
+		if err == io.EOF {
+			return v.syntaxError(io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// describe returns the JSON type of a token or value, for errors.
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case float64, json.Number:
+		return "a number"
+	case string:
+		return "a string"
+	case json.Delim:
+		if v == '{' {
+			return "an object"
+		}
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	}
+	return fmt.Sprintf("%T", v)
+}
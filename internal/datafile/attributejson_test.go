@@ -0,0 +1,75 @@
+package datafile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadAttributeJSON(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []*AttributeRecord
+		errs []string
+	}{
+		{
+			in:   `{"AttributeName": "zip", "DatasetID": "abcd-1234"}`,
+			want: []*AttributeRecord{{"zip", "abcd-1234"}},
+		},
+		{
+			in:   `[{"AttributeName": "zip"}, {"AttributeName": "county", "DatasetID": "abcd-1234"}]`,
+			want: []*AttributeRecord{{"zip", "abcd-1234"}, {"county", "abcd-1234"}},
+		},
+		{
+			in: `[
+  {"AttributeName": "zip"},
+  {"attributeName": "x", "DatasetID": "efgh-5678"},
+  {"AttributeName": 5, "Type": {"a": [1]}},
+  "school",
+  {"AttributeName": "zip"},
+  {"AttributeName": " "}
+]`,
+			errs: []string{
+				`3:4: [1].attributeName: unknown field "attributeName" (did you mean "AttributeName"?)`,
+				`3:3: [1]: missing AttributeName`,
+				`3:3: [1].DatasetID: DatasetID "efgh-5678" is not the ID of the dataset, "abcd-1234"`,
+				`4:4: [2].AttributeName: want a string, got a number`,
+				`4:24: [2].Type: unknown field "Type"`,
+				`5:3: [3]: want an attribute object, got a string`,
+				`6:3: [4]: duplicate attribute "zip" (also [0])`,
+				`7:4: [5].AttributeName: blank AttributeName`,
+			},
+		},
+		{
+			in:   "{\"AttributeName\": \"zip\",\n}",
+			errs: []string{`1:24: invalid character ',' looking for beginning of value`},
+		},
+		{
+			in:   `{"AttributeName": "zip"`,
+			errs: []string{`1:24: unexpected end of file`},
+		},
+		{
+			in:   `{"AttributeName": "zip"} {}`,
+			errs: []string{`1:26: unexpected data after the attributes`},
+		},
+		{
+			in:   " ",
+			errs: []string{`1:2: empty file, want an attribute object or a list of them`},
+		},
+	} {
+		got, err := ReadAttributeJSON(strings.NewReader(tt.in), "abcd-1234")
+		var errs []string
+		if err != nil {
+			serrs, ok := err.(SchemaErrors)
+			if !ok {
+				t.Fatalf("%v: got %v, want SchemaErrors", tt.in, err)
+			}
+			for _, e := range serrs {
+				errs = append(errs, e.Error())
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(errs, tt.errs) {
+			t.Errorf("%v:\ngot %v, errors\n%v\nwant %v, errors\n%v", tt.in, got, strings.Join(errs, "\n"), tt.want, strings.Join(tt.errs, "\n"))
+		}
+	}
+}
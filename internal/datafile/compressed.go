@@ -0,0 +1,117 @@
+package datafile
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Suffixes of the compressed versions of dataset files, in the order they are
+// looked for by Find.
+var compressedSuffixes = []string{".gz", ".zip"}
+
+// Find returns path if the file exists, and otherwise the path of its gzip
+// (path.gz) or zip (path.zip) compressed version. If none of them exists, it
+// returns the error of the uncompressed file, for which
+// errors.Is(err, os.ErrNotExist) is true.
+func Find(path string) (string, error) {
+	_, notExist := os.Stat(path)
+	if notExist == nil || !errors.Is(notExist, os.ErrNotExist) {
+		return path, notExist
+	}
+	for _, suffix := range compressedSuffixes {
+		if _, err := os.Stat(path + suffix); err == nil {
+			return path + suffix, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", notExist
+}
+
+// Uncompressed returns the path of the uncompressed version of a file found by
+// Find, which is path itself if it is not compressed.
+func Uncompressed(path string) string {
+	for _, suffix := range compressedSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return strings.TrimSuffix(path, suffix)
+		}
+	}
+	return path
+}
+
+// Open opens the dataset file at path or its compressed version (see Find),
+// which is decompressed as it is read. The file of a zip archive is the one
+// named like the uncompressed file, or else the archive's only file.
+func Open(path string) (io.ReadCloser, error) {
+	path, err := Find(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		return &readClosers{zr, []io.Closer{zr, f}}, nil
+	case strings.HasSuffix(path, ".zip"):
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		name := filepath.Base(Uncompressed(path))
+		var file *zip.File
+		var files []*zip.File
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			files = append(files, f)
+			if filepath.Base(f.Name) == name {
+				file = f
+				break
+			}
+		}
+		if file == nil && len(files) == 1 {
+			file = files[0]
+		}
+		if file == nil {
+			zr.Close()
+			return nil, fmt.Errorf("zip archive %v has no file %v", path, name)
+		}
+		rc, err := file.Open()
+		if err != nil {
+			zr.Close()
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		return &readClosers{rc, []io.Closer{rc, zr}}, nil
+	}
+	return os.Open(path)
+}
+
+// readClosers is a reader that closes the readers it reads from when closed.
+type readClosers struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readClosers) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
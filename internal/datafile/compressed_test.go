@@ -0,0 +1,76 @@
+package datafile
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datafile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, write func(f *os.File) error) {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := write(f); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("plain.csv", func(f *os.File) error {
+		_, err := f.WriteString("plain")
+		return err
+	})
+	write("gzipped.csv.gz", func(f *os.File) error {
+		zw := gzip.NewWriter(f)
+		if _, err := zw.Write([]byte("gzipped")); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+	write("zipped.csv.zip", func(f *os.File) error {
+		zw := zip.NewWriter(f)
+		for _, name := range []string{"README", "data/zipped.csv"} {
+			w, err := zw.Create(name)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	})
+
+	for name, want := range map[string]string{
+		"plain.csv":   "plain",
+		"gzipped.csv": "gzipped",
+		"zipped.csv":  "data/zipped.csv",
+	} {
+		f, err := Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil || string(got) != want {
+			t.Errorf("%v: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := Open(filepath.Join(dir, "missing.csv")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v for a missing file, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,217 @@
+// Package datafile profiles the columns of dataset files, so that datasets can
+// be ingested from their raw files: it extracts the column names as
+// attributes, samples their values, and infers their types.
+package datafile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+)
+
+// Maximum number of sample values of an attribute.
+const SampleSize = 20
+
+// Attribute is a column of a dataset file.
+type Attribute struct {
+	Name string
+	// Type inferred from the values read (see coltype.Inferrer).
+	Type coltype.Type
+	// Up to SampleSize distinct non-blank values, in the order read.
+	Sample []string
+}
+
+// profiler profiles the attributes of a file from their values.
+type profiler struct {
+	attributes []*Attribute
+	// Index of each attribute in attributes by name.
+	index  map[string]int
+	types  []coltype.Inferrer
+	sample []map[string]bool
+}
+
+func newProfiler() *profiler {
+	return &profiler{index: make(map[string]int)}
+}
+
+// attribute returns the index of the attribute with the given name, adding it
+// if it is new.
+func (p *profiler) attribute(name string) int {
+	if i, ok := p.index[name]; ok {
+		return i
+	}
+	i := len(p.attributes)
+	p.index[name] = i
+	p.attributes = append(p.attributes, &Attribute{Name: name})
+	p.types = append(p.types, coltype.Inferrer{})
+	p.sample = append(p.sample, make(map[string]bool))
+	return i
+}
+
+// add adds a value of the attribute with index i.
+func (p *profiler) add(i int, v string) {
+	p.types[i].Add(v)
+	v = strings.TrimSpace(v)
+	a := p.attributes[i]
+	if v != "" && len(a.Sample) < SampleSize && !p.sample[i][v] {
+		p.sample[i][v] = true
+		a.Sample = append(a.Sample, v)
+	}
+}
+
+func (p *profiler) result() []*Attribute {
+	for i, a := range p.attributes {
+		a.Type = p.types[i].Type()
+	}
+	return p.attributes
+}
+
+// ReadCSV profiles the attributes of the CSV file read from r, the columns
+// named by its header, from at most maxRows of its rows. Columns with blank
+// names are skipped, and columns with the same name are profiled together.
+// It returns nil if the file is empty.
+func ReadCSV(r io.Reader, maxRows int) ([]*Attribute, error) {
+	cr := csv.NewReader(r)
+	cr.LazyQuotes = true
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := newProfiler()
+	columns := make([]int, len(header))
+	for i, name := range header {
+		if name = strings.TrimSpace(name); name == "" {
+			columns[i] = -1
+		} else {
+			columns[i] = p.attribute(name)
+		}
+	}
+	for n := 0; n < maxRows; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range record {
+			if i < len(columns) && columns[i] >= 0 {
+				p.add(columns[i], v)
+			}
+		}
+	}
+	return p.result(), nil
+}
+
+// ReadJSON profiles the attributes of the JSON dataset file read from r, which
+// holds either a record per line (JSON Lines) or an array of records, as
+// exported by the Socrata API, from at most maxRows of its records. The
+// fields of nested objects are attributes named by their path, as in
+// "location.latitude", and the values of arrays are values of the array's
+// attribute. Fields with blank names are skipped. It returns nil if the file
+// is empty.
+func ReadJSON(r io.Reader, maxRows int) ([]*Attribute, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	p := newProfiler()
+
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	array := tok == json.Delim('[')
+	for n := 0; n < maxRows; n++ {
+		if array {
+			if !dec.More() {
+				break
+			}
+			if tok, err = dec.Token(); err != nil {
+				return nil, err
+			}
+		} else if n > 0 {
+			if tok, err = dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if tok != json.Delim('{') {
+			return nil, fmt.Errorf("record %d is not a JSON object", n+1)
+		}
+		if err := p.addObject(dec, ""); err != nil {
+			return nil, fmt.Errorf("record %d: %w", n+1, err)
+		}
+	}
+	return p.result(), nil
+}
+
+// addObject adds the fields of the JSON object read from dec, whose opening
+// brace has been read, as the attributes under path.
+func (p *profiler) addObject(dec *json.Decoder, path string) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		if key = strings.TrimSpace(key); key == "" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+		if path != "" {
+			key = path + "." + key
+		}
+		if err := p.addValue(dec, key); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// addValue adds the JSON value read from dec as a value of the attribute
+// with the given name.
+func (p *profiler) addValue(dec *json.Decoder, name string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '{' {
+			return p.addObject(dec, name)
+		}
+		for dec.More() {
+			if err := p.addValue(dec, name); err != nil {
+				return err
+			}
+		}
+		_, err := dec.Token()
+		return err
+	case string:
+		p.add(p.attribute(name), v)
+	case json.Number:
+		p.add(p.attribute(name), v.String())
+	case bool:
+		p.add(p.attribute(name), strconv.FormatBool(v))
+	case nil:
+		p.attribute(name)
+	}
+	return nil
+}
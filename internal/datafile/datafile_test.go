@@ -0,0 +1,71 @@
+package datafile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+)
+
+func TestReadCSV(t *testing.T) {
+	in := `id,name, ,zip,name
+1,Queens,x,11101,Kings
+2,Queens,y,,Bronx
+3,Richmond,z,10301
+4,Kings,w,11201,skipped`
+	attrs, err := ReadCSV(strings.NewReader(in), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Attribute{
+		{"id", coltype.Int, []string{"1", "2", "3"}},
+		{"name", coltype.String, []string{"Queens", "Kings", "Bronx", "Richmond"}},
+		{"zip", coltype.Int, []string{"11101", "10301"}},
+	}
+	if !reflect.DeepEqual(attrs, want) {
+		for _, a := range attrs {
+			t.Logf("%+v", a)
+		}
+		t.Errorf("got %v attributes, want %v", len(attrs), len(want))
+	}
+
+	if attrs, err := ReadCSV(strings.NewReader(""), 10); err != nil || attrs != nil {
+		t.Errorf("got %v, %v for an empty file", attrs, err)
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	want := []*Attribute{
+		{"id", coltype.Int, []string{"1", "2"}},
+		{"location.latitude", coltype.Float, []string{"40.7", "40.6"}},
+		{"location.address.city", coltype.String, []string{"Queens"}},
+		{"tags", coltype.String, []string{"a", "b"}},
+		{"open", coltype.Bool, []string{"true"}},
+	}
+	for _, in := range []string{
+		`{"id": 1, "location": {"latitude": "40.7", "address": {"city": "Queens"}}, "tags": ["a", "b"], "": "x"}
+{"id": 2, "location": {"latitude": 40.6}, "tags": [], "open": true}
+{"id": 3}`,
+		`[{"id": 1, "location": {"latitude": "40.7", "address": {"city": "Queens"}}, "tags": ["a", "b"], "": "x"},
+{"id": 2, "location": {"latitude": 40.6}, "tags": [], "open": true}, {"id": 3}]`,
+	} {
+		attrs, err := ReadJSON(strings.NewReader(in), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(attrs, want) {
+			for _, a := range attrs {
+				t.Logf("%+v", a)
+			}
+			t.Errorf("got %v attributes, want %v", len(attrs), len(want))
+		}
+	}
+
+	if attrs, err := ReadJSON(strings.NewReader(""), 10); err != nil || attrs != nil {
+		t.Errorf("got %v, %v for an empty file", attrs, err)
+	}
+	if _, err := ReadJSON(strings.NewReader("1\n2"), 10); err == nil {
+		t.Error("got no error for records that are not objects")
+	}
+}
@@ -0,0 +1,927 @@
+package datafile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+)
+
+// Physical types of Parquet columns.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetInt96     = 3
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+	parquetFixed     = 7
+)
+
+// Repetitions of Parquet schema elements.
+const (
+	parquetRequired = 0
+	parquetOptional = 1
+	parquetRepeated = 2
+)
+
+// Compression codecs of Parquet column chunks.
+const (
+	codecNone   = 0
+	codecSnappy = 1
+	codecGzip   = 2
+)
+
+// Types of Parquet pages.
+const (
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+// Encodings of Parquet pages.
+const (
+	encodingPlain           = 0
+	encodingPlainDictionary = 2
+	encodingBitPacked       = 4
+	encodingRLEDictionary   = 8
+)
+
+// Maximum size of a Parquet page.
+const maxPageSize = 256 << 20
+
+var parquetMagic = []byte("PAR1")
+
+var (
+	errParquet = errors.New("invalid parquet file")
+	// errUnsupported is returned for the column chunks whose values cannot
+	// be read, which are not sampled.
+	errUnsupported = errors.New("unsupported parquet encoding or compression")
+)
+
+// logicalType is the logical type of a Parquet schema element, from its
+// logical or converted type annotation.
+type logicalType int
+
+const (
+	logicalNone logicalType = iota
+	logicalString
+	logicalDecimal
+	logicalDate
+	logicalMillis
+	logicalMicros
+	logicalNanos
+	logicalUnsigned
+	logicalList
+	logicalMap
+	// logicalListElement is the "logical type" of the repeated group of a
+	// list, whose single child is the list element.
+	logicalListElement
+)
+
+// schemaElement is an element of the schema of a Parquet file, a column or a
+// group of columns.
+type schemaElement struct {
+	// Physical type, or -1 for groups.
+	typ         int32
+	typeLength  int32
+	repetition  int32
+	name        string
+	numChildren int32
+	logical     logicalType
+	scale       int32
+}
+
+// parquetColumn is a leaf column of the schema of a Parquet file.
+type parquetColumn struct {
+	// Path of the column in the schema, joined by ".".
+	name       string
+	typ        int32
+	typeLength int32
+	logical    logicalType
+	scale      int32
+	// Maximum definition and repetition levels of the column's values.
+	maxDef, maxRep int
+}
+
+// parquetChunk is the metadata of a column chunk, the values of a column in a
+// row group.
+type parquetChunk struct {
+	// Whether the chunk is stored in another file.
+	external       bool
+	codec          int32
+	numValues      int64
+	compressedSize int64
+	dataOffset     int64
+	dictOffset     int64
+}
+
+type pageHeader struct {
+	typ              int32
+	uncompressedSize int32
+	compressedSize   int32
+	numValues        int32
+	encoding         int32
+	defEncoding      int32
+	// Lengths of the levels of data pages v2, which are not compressed.
+	repLength, defLength int32
+	compressed           bool
+}
+
+// ReadParquet profiles the attributes of the Parquet file of the given size
+// read from r, its leaf columns, from at most maxRows of its rows. Nested
+// columns are named by their path, as in "location.latitude", leaving out the
+// intermediate groups of lists and maps. The types of the attributes are
+// those declared by the schema, except for string columns, whose types are
+// inferred from their values.
+//
+// Values are only sampled from the column chunks that are uncompressed or
+// compressed with Snappy or gzip, and that are encoded with the plain and
+// dictionary encodings, as most files are.
+func ReadParquet(r io.ReaderAt, size int64, maxRows int) ([]*Attribute, error) {
+	if size < 12 {
+		return nil, errParquet
+	}
+	header := make([]byte, 4)
+	footer := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if _, err := r.ReadAt(footer, size-8); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header, parquetMagic) || !bytes.Equal(footer[4:], parquetMagic) {
+		return nil, errParquet
+	}
+	n := int64(binary.LittleEndian.Uint32(footer))
+	if n > size-12 {
+		return nil, errParquet
+	}
+	meta := make([]byte, n)
+	if _, err := r.ReadAt(meta, size-8-n); err != nil {
+		return nil, err
+	}
+	schema, groups, err := readFileMetaData(&thriftReader{bytes.NewReader(meta)})
+	if err != nil {
+		return nil, fmt.Errorf("parquet metadata: %w", err)
+	}
+	columns, err := parquetColumns(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newProfiler()
+	for i, c := range columns {
+		index := p.attribute(c.name)
+		rows := 0
+		for _, chunks := range groups {
+			if rows >= maxRows {
+				break
+			}
+			if i >= len(chunks) {
+				return nil, errParquet
+			}
+			n, err := readChunk(r, size, chunks[i], c, maxRows-rows, func(v string) {
+				p.add(index, v)
+			})
+			if errors.Is(err, errUnsupported) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parquet column %v: %w", c.name, err)
+			}
+			rows += n
+		}
+	}
+	attrs := p.result()
+	for _, c := range columns {
+		if t := c.declaredType(); t != coltype.Unknown {
+			attrs[p.index[c.name]].Type = t
+		}
+	}
+	return attrs, nil
+}
+
+func readFileMetaData(t *thriftReader) ([]*schemaElement, [][]*parquetChunk, error) {
+	var schema []*schemaElement
+	var groups [][]*parquetChunk
+	err := t.fields(func(id int16, typ byte) error {
+		switch {
+		case id == 2 && typ == thriftList:
+			return t.list(func(typ byte) error {
+				if typ != thriftStruct {
+					return errParquet
+				}
+				e, err := readSchemaElement(t)
+				schema = append(schema, e)
+				return err
+			})
+		case id == 4 && typ == thriftList:
+			return t.list(func(typ byte) error {
+				if typ != thriftStruct {
+					return errParquet
+				}
+				chunks, err := readRowGroup(t)
+				groups = append(groups, chunks)
+				return err
+			})
+		}
+		return t.skip(typ)
+	})
+	return schema, groups, err
+}
+
+func readSchemaElement(t *thriftReader) (*schemaElement, error) {
+	e := &schemaElement{typ: -1}
+	converted := int32(-1)
+	err := t.fields(func(id int16, typ byte) error {
+		switch id {
+		case 1:
+			return t.i32Field(typ, &e.typ)
+		case 2:
+			return t.i32Field(typ, &e.typeLength)
+		case 3:
+			return t.i32Field(typ, &e.repetition)
+		case 4:
+			if typ == thriftBinary {
+				var err error
+				e.name, err = t.string()
+				return err
+			}
+		case 5:
+			return t.i32Field(typ, &e.numChildren)
+		case 6:
+			return t.i32Field(typ, &converted)
+		case 7:
+			return t.i32Field(typ, &e.scale)
+		case 10:
+			if typ == thriftStruct {
+				return readLogicalType(t, e)
+			}
+		}
+		return t.skip(typ)
+	})
+	if e.logical == logicalNone {
+		e.logical = convertedType(converted)
+	}
+	return e, err
+}
+
+// readLogicalType reads the LogicalType union of e.
+func readLogicalType(t *thriftReader, e *schemaElement) error {
+	return t.fields(func(id int16, typ byte) error {
+		switch id {
+		case 1, 4, 12:
+			// STRING, ENUM, and JSON.
+			e.logical = logicalString
+		case 2:
+			e.logical = logicalMap
+		case 3:
+			e.logical = logicalList
+		case 5:
+			e.logical = logicalDecimal
+			if typ == thriftStruct {
+				return t.fields(func(id int16, typ byte) error {
+					if id == 1 {
+						return t.i32Field(typ, &e.scale)
+					}
+					return t.skip(typ)
+				})
+			}
+		case 6:
+			e.logical = logicalDate
+		case 8:
+			if typ == thriftStruct {
+				return t.fields(func(id int16, typ byte) error {
+					if id != 2 || typ != thriftStruct {
+						return t.skip(typ)
+					}
+					return t.fields(func(unit int16, typ byte) error {
+						switch unit {
+						case 1:
+							e.logical = logicalMillis
+						case 2:
+							e.logical = logicalMicros
+						case 3:
+							e.logical = logicalNanos
+						}
+						return t.skip(typ)
+					})
+				})
+			}
+		case 10:
+			if typ == thriftStruct {
+				return t.fields(func(id int16, typ byte) error {
+					if id == 2 && typ == thriftFalse {
+						e.logical = logicalUnsigned
+					}
+					return t.skip(typ)
+				})
+			}
+		}
+		return t.skip(typ)
+	})
+}
+
+// convertedType returns the logical type of a Parquet converted type, the
+// annotation of the files written before logical types.
+func convertedType(t int32) logicalType {
+	switch t {
+	case 0, 4, 19:
+		// UTF8, ENUM, and JSON.
+		return logicalString
+	case 1:
+		return logicalMap
+	case 3:
+		return logicalList
+	case 5:
+		return logicalDecimal
+	case 6:
+		return logicalDate
+	case 9:
+		return logicalMillis
+	case 10:
+		return logicalMicros
+	case 11, 12, 13, 14:
+		return logicalUnsigned
+	}
+	return logicalNone
+}
+
+func readRowGroup(t *thriftReader) ([]*parquetChunk, error) {
+	var chunks []*parquetChunk
+	err := t.fields(func(id int16, typ byte) error {
+		if id != 1 || typ != thriftList {
+			return t.skip(typ)
+		}
+		return t.list(func(typ byte) error {
+			if typ != thriftStruct {
+				return errParquet
+			}
+			ch, err := readColumnChunk(t)
+			chunks = append(chunks, ch)
+			return err
+		})
+	})
+	return chunks, err
+}
+
+func readColumnChunk(t *thriftReader) (*parquetChunk, error) {
+	ch := &parquetChunk{}
+	err := t.fields(func(id int16, typ byte) error {
+		switch {
+		case id == 1 && typ == thriftBinary:
+			ch.external = true
+		case id == 3 && typ == thriftStruct:
+			return t.fields(func(id int16, typ byte) error {
+				switch id {
+				case 4:
+					return t.i32Field(typ, &ch.codec)
+				case 5:
+					return t.i64Field(typ, &ch.numValues)
+				case 7:
+					return t.i64Field(typ, &ch.compressedSize)
+				case 9:
+					return t.i64Field(typ, &ch.dataOffset)
+				case 11:
+					return t.i64Field(typ, &ch.dictOffset)
+				}
+				return t.skip(typ)
+			})
+		}
+		return t.skip(typ)
+	})
+	return ch, err
+}
+
+func readPageHeader(t *thriftReader) (*pageHeader, error) {
+	h := &pageHeader{compressed: true}
+	err := t.fields(func(id int16, typ byte) error {
+		switch {
+		case id == 1:
+			return t.i32Field(typ, &h.typ)
+		case id == 2:
+			return t.i32Field(typ, &h.uncompressedSize)
+		case id == 3:
+			return t.i32Field(typ, &h.compressedSize)
+		case id == 5 && typ == thriftStruct:
+			return t.fields(func(id int16, typ byte) error {
+				switch id {
+				case 1:
+					return t.i32Field(typ, &h.numValues)
+				case 2:
+					return t.i32Field(typ, &h.encoding)
+				case 3:
+					return t.i32Field(typ, &h.defEncoding)
+				}
+				return t.skip(typ)
+			})
+		case id == 7 && typ == thriftStruct:
+			return t.fields(func(id int16, typ byte) error {
+				switch id {
+				case 1:
+					return t.i32Field(typ, &h.numValues)
+				case 2:
+					return t.i32Field(typ, &h.encoding)
+				}
+				return t.skip(typ)
+			})
+		case id == 8 && typ == thriftStruct:
+			return t.fields(func(id int16, typ byte) error {
+				switch id {
+				case 1:
+					return t.i32Field(typ, &h.numValues)
+				case 4:
+					return t.i32Field(typ, &h.encoding)
+				case 5:
+					return t.i32Field(typ, &h.defLength)
+				case 6:
+					return t.i32Field(typ, &h.repLength)
+				case 7:
+					h.compressed = typ == thriftTrue
+				}
+				return t.skip(typ)
+			})
+		}
+		return t.skip(typ)
+	})
+	return h, err
+}
+
+// parquetColumns returns the leaf columns of a Parquet schema.
+func parquetColumns(schema []*schemaElement) ([]*parquetColumn, error) {
+	if len(schema) == 0 {
+		return nil, errParquet
+	}
+	w := &schemaWalker{schema: schema, next: 1}
+	if err := w.walk(int(schema[0].numChildren), nil, 0, 0, logicalNone); err != nil {
+		return nil, err
+	}
+	return w.columns, nil
+}
+
+type schemaWalker struct {
+	schema  []*schemaElement
+	next    int
+	columns []*parquetColumn
+}
+
+// walk walks the n schema elements following the last one walked, and their
+// children, which are in a group of the given logical type with the given
+// path and levels.
+func (w *schemaWalker) walk(n int, path []string, def, rep int, parent logicalType) error {
+	for i := 0; i < n; i++ {
+		if w.next >= len(w.schema) {
+			return errParquet
+		}
+		e := w.schema[w.next]
+		w.next++
+
+		def, rep := def, rep
+		switch e.repetition {
+		case parquetOptional:
+			def++
+		case parquetRepeated:
+			def++
+			rep++
+		}
+		p := path
+		list := (parent == logicalList || parent == logicalMap) && e.repetition == parquetRepeated
+		if !list && parent != logicalListElement {
+			p = append(path[:len(path):len(path)], e.name)
+		}
+		if e.typ < 0 {
+			logical := e.logical
+			if list && parent == logicalList && e.numChildren == 1 {
+				logical = logicalListElement
+			}
+			if err := w.walk(int(e.numChildren), p, def, rep, logical); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(p) == 0 {
+			continue
+		}
+		w.columns = append(w.columns, &parquetColumn{
+			name:       strings.Join(p, "."),
+			typ:        e.typ,
+			typeLength: e.typeLength,
+			logical:    e.logical,
+			scale:      e.scale,
+			maxDef:     def,
+			maxRep:     rep,
+		})
+	}
+	return nil
+}
+
+// declaredType returns the type of the values of c declared by the schema, or
+// Unknown for strings, whose type is inferred from their values.
+func (c *parquetColumn) declaredType() coltype.Type {
+	switch c.logical {
+	case logicalDate, logicalMillis, logicalMicros, logicalNanos:
+		return coltype.Date
+	case logicalDecimal:
+		return coltype.Float
+	}
+	switch c.typ {
+	case parquetBoolean:
+		return coltype.Bool
+	case parquetInt32, parquetInt64:
+		return coltype.Int
+	case parquetInt96:
+		return coltype.Date
+	case parquetFloat, parquetDouble:
+		return coltype.Float
+	}
+	return coltype.Unknown
+}
+
+// readChunk reads the values of column c in a column chunk from at most
+// maxRows rows, calling add with each non-null value, and returns the number
+// of rows read.
+func readChunk(r io.ReaderAt, size int64, ch *parquetChunk, c *parquetColumn, maxRows int, add func(string)) (int, error) {
+	if ch.external {
+		return 0, errUnsupported
+	}
+	switch ch.codec {
+	case codecNone, codecSnappy, codecGzip:
+	default:
+		return 0, errUnsupported
+	}
+	start := ch.dataOffset
+	if ch.dictOffset > 0 && ch.dictOffset < start {
+		start = ch.dictOffset
+	}
+	if start < 4 || ch.compressedSize < 0 || start+ch.compressedSize > size {
+		return 0, errParquet
+	}
+	br := bufio.NewReader(io.NewSectionReader(r, start, ch.compressedSize))
+	t := &thriftReader{br}
+
+	var dict []string
+	var values int64
+	rows := 0
+	for values < ch.numValues && rows < maxRows {
+		h, err := readPageHeader(t)
+		if err != nil {
+			return rows, err
+		}
+		if h.compressedSize < 0 || int64(h.compressedSize) > ch.compressedSize ||
+			h.uncompressedSize < 0 || h.uncompressedSize > maxPageSize || h.numValues < 0 {
+			return rows, errParquet
+		}
+		data := make([]byte, h.compressedSize)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return rows, err
+		}
+
+		var reps, defs []int
+		switch h.typ {
+		case pageDictionary:
+			if data, err = decompress(ch.codec, data, h.uncompressedSize); err != nil {
+				return rows, err
+			}
+			if dict, err = decodePlain(c, data, int(h.numValues)); err != nil {
+				return rows, err
+			}
+			continue
+		case pageData:
+			if c.maxDef > 0 && h.defEncoding == encodingBitPacked {
+				return rows, errUnsupported
+			}
+			if data, err = decompress(ch.codec, data, h.uncompressedSize); err != nil {
+				return rows, err
+			}
+			if c.maxRep > 0 {
+				if reps, data, err = readLevels(data, c.maxRep, int(h.numValues)); err != nil {
+					return rows, err
+				}
+			}
+			if c.maxDef > 0 {
+				if defs, data, err = readLevels(data, c.maxDef, int(h.numValues)); err != nil {
+					return rows, err
+				}
+			}
+		case pageDataV2:
+			if h.repLength < 0 || h.defLength < 0 || int(h.repLength+h.defLength) > len(data) {
+				return rows, errParquet
+			}
+			levels := data[:h.repLength+h.defLength]
+			data = data[len(levels):]
+			if h.compressed {
+				if data, err = decompress(ch.codec, data, h.uncompressedSize-int32(len(levels))); err != nil {
+					return rows, err
+				}
+			}
+			if c.maxRep > 0 {
+				if reps, err = decodeHybrid(levels[:h.repLength], bits.Len(uint(c.maxRep)), int(h.numValues)); err != nil {
+					return rows, err
+				}
+			}
+			if c.maxDef > 0 {
+				if defs, err = decodeHybrid(levels[h.repLength:], bits.Len(uint(c.maxDef)), int(h.numValues)); err != nil {
+					return rows, err
+				}
+			}
+		default:
+			// Index pages.
+			continue
+		}
+		n, err := readValues(c, h, reps, defs, data, dict, maxRows-rows, add)
+		if err != nil {
+			return rows, err
+		}
+		rows += n
+		values += int64(h.numValues)
+	}
+	return rows, nil
+}
+
+// readValues reads the values of a data page of column c with the given
+// repetition and definition levels from at most maxRows rows, calling add with
+// each non-null value, and returns the number of rows read.
+func readValues(c *parquetColumn, h *pageHeader, reps, defs []int, data []byte, dict []string, maxRows int, add func(string)) (int, error) {
+	n := int(h.numValues)
+	nonNull := n
+	if defs != nil {
+		nonNull = 0
+		for _, d := range defs {
+			if d == c.maxDef {
+				nonNull++
+			}
+		}
+	}
+	var values []string
+	switch h.encoding {
+	case encodingPlain:
+		var err error
+		if values, err = decodePlain(c, data, nonNull); err != nil {
+			return 0, err
+		}
+	case encodingPlainDictionary, encodingRLEDictionary:
+		if nonNull == 0 {
+			break
+		}
+		if dict == nil || len(data) == 0 {
+			return 0, errParquet
+		}
+		indexes, err := decodeHybrid(data[1:], int(data[0]), nonNull)
+		if err != nil {
+			return 0, err
+		}
+		values = make([]string, len(indexes))
+		for i, j := range indexes {
+			if j >= len(dict) {
+				return 0, errParquet
+			}
+			values[i] = dict[j]
+		}
+	default:
+		return 0, errUnsupported
+	}
+
+	rows, k := 0, 0
+	for i := 0; i < n; i++ {
+		if reps == nil || reps[i] == 0 {
+			if rows == maxRows {
+				break
+			}
+			rows++
+		}
+		if defs == nil || defs[i] == c.maxDef {
+			add(values[k])
+			k++
+		}
+	}
+	return rows, nil
+}
+
+func decompress(codec int32, data []byte, size int32) ([]byte, error) {
+	if size < 0 {
+		return nil, errParquet
+	}
+	switch codec {
+	case codecNone:
+		return data, nil
+	case codecSnappy:
+		return decodeSnappy(data, int(size))
+	case codecGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(zr, int64(size)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > int(size) {
+			return nil, errParquet
+		}
+		return b, nil
+	}
+	return nil, errUnsupported
+}
+
+// readLevels reads n repetition or definition levels up to max of a data page
+// v1, prefixed by their length, and returns them and the rest of the page.
+func readLevels(data []byte, max, n int) ([]int, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, errParquet
+	}
+	length := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	if uint64(length) > uint64(len(data)) {
+		return nil, nil, errParquet
+	}
+	levels, err := decodeHybrid(data[:length], bits.Len(uint(max)), n)
+	return levels, data[length:], err
+}
+
+// decodeHybrid decodes n values of the given bit width of the RLE/bit-packing
+// hybrid encoding of levels and dictionary indexes.
+func decodeHybrid(data []byte, bitWidth, n int) ([]int, error) {
+	if bitWidth < 0 || bitWidth > 32 {
+		return nil, errParquet
+	}
+	byteWidth := (bitWidth + 7) / 8
+	out := make([]int, 0, n)
+	for len(out) < n {
+		header, k := binary.Uvarint(data)
+		if k <= 0 {
+			return nil, errParquet
+		}
+		data = data[k:]
+		if header&1 == 0 {
+			// Run of a repeated value.
+			if len(data) < byteWidth {
+				return nil, errParquet
+			}
+			v := 0
+			for i := byteWidth - 1; i >= 0; i-- {
+				v = v<<8 | int(data[i])
+			}
+			data = data[byteWidth:]
+			for count := header >> 1; count > 0 && len(out) < n; count-- {
+				out = append(out, v)
+			}
+			continue
+		}
+		// Groups of 8 bit-packed values, of which the last may be cut off.
+		groups := header >> 1
+		length := uint64(len(data))
+		if bitWidth == 0 {
+			length = 0
+		} else if groups*uint64(bitWidth) < length {
+			length = groups * uint64(bitWidth)
+		}
+		packed := data[:length]
+		data = data[length:]
+		for i := uint64(0); i < groups*8 && len(out) < n; i++ {
+			pos := int(i) * bitWidth
+			if (pos+bitWidth+7)/8 > len(packed) {
+				return nil, errParquet
+			}
+			v := 0
+			for b := 0; b < bitWidth; b++ {
+				if packed[(pos+b)/8]>>uint((pos+b)%8)&1 != 0 {
+					v |= 1 << uint(b)
+				}
+			}
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+// decodePlain decodes n values of column c of the plain encoding as strings.
+func decodePlain(c *parquetColumn, data []byte, n int) ([]string, error) {
+	var out []string
+	size := 0
+	switch c.typ {
+	case parquetBoolean:
+		if (n+7)/8 > len(data) {
+			return nil, errParquet
+		}
+		for i := 0; i < n; i++ {
+			out = append(out, strconv.FormatBool(data[i/8]>>uint(i%8)&1 != 0))
+		}
+		return out, nil
+	case parquetByteArray:
+		for i := 0; i < n; i++ {
+			if len(data) < 4 {
+				return nil, errParquet
+			}
+			length := binary.LittleEndian.Uint32(data)
+			data = data[4:]
+			if uint64(length) > uint64(len(data)) {
+				return nil, errParquet
+			}
+			out = append(out, c.formatBytes(data[:length]))
+			data = data[length:]
+		}
+		return out, nil
+	case parquetInt32, parquetFloat:
+		size = 4
+	case parquetInt64, parquetDouble:
+		size = 8
+	case parquetInt96:
+		size = 12
+	case parquetFixed:
+		size = int(c.typeLength)
+	}
+	if size <= 0 || n > len(data)/size {
+		return nil, errParquet
+	}
+	for i := 0; i < n; i++ {
+		b := data[i*size : (i+1)*size]
+		var v string
+		switch c.typ {
+		case parquetInt32:
+			v = c.formatInt(int64(int32(binary.LittleEndian.Uint32(b))))
+		case parquetInt64:
+			v = c.formatInt(int64(binary.LittleEndian.Uint64(b)))
+		case parquetInt96:
+			// Nanoseconds of the day and Julian day of legacy timestamps.
+			nanos := int64(binary.LittleEndian.Uint64(b))
+			days := int64(binary.LittleEndian.Uint32(b[8:])) - 2440588
+			v = time.Unix(days*86400, nanos).UTC().Format(time.RFC3339Nano)
+		case parquetFloat:
+			v = strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 'g', -1, 32)
+		case parquetDouble:
+			v = strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64)
+		case parquetFixed:
+			v = c.formatBytes(b)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (c *parquetColumn) formatInt(v int64) string {
+	switch c.logical {
+	case logicalDate:
+		return time.Unix(v*86400, 0).UTC().Format("2006-01-02")
+	case logicalMillis:
+		return time.Unix(v/1e3, v%1e3*1e6).UTC().Format(time.RFC3339Nano)
+	case logicalMicros:
+		return time.Unix(v/1e6, v%1e6*1e3).UTC().Format(time.RFC3339Nano)
+	case logicalNanos:
+		return time.Unix(0, v).UTC().Format(time.RFC3339Nano)
+	case logicalDecimal:
+		return formatDecimal(big.NewInt(v), c.scale)
+	case logicalUnsigned:
+		if c.typ == parquetInt32 {
+			return strconv.FormatUint(uint64(uint32(v)), 10)
+		}
+		return strconv.FormatUint(uint64(v), 10)
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// formatBytes formats a byte array value of c, or returns "" for a binary
+// value that is not text.
+func (c *parquetColumn) formatBytes(b []byte) string {
+	if c.logical == logicalDecimal {
+		// Big-endian two's complement unscaled value.
+		x := new(big.Int).SetBytes(b)
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			x.Sub(x, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+		}
+		return formatDecimal(x, c.scale)
+	}
+	if !utf8.Valid(b) {
+		return ""
+	}
+	return string(b)
+}
+
+func formatDecimal(x *big.Int, scale int32) string {
+	if scale <= 0 {
+		return x.String()
+	}
+	s := new(big.Int).Abs(x).String()
+	if n := int(scale) + 1 - len(s); n > 0 {
+		s = strings.Repeat("0", n) + s
+	}
+	s = s[:len(s)-int(scale)] + "." + s[len(s)-int(scale):]
+	if x.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
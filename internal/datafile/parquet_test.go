@@ -0,0 +1,253 @@
+package datafile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+)
+
+// Thrift compact protocol encoding of the Parquet test file.
+
+type tfield struct {
+	id  int16
+	typ byte
+	v   []byte
+}
+
+func varint(v int64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutVarint(b, v)]
+}
+
+func uvarint(v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutUvarint(b, v)]
+}
+
+func tstruct(fields ...tfield) []byte {
+	var b []byte
+	var last int16
+	for _, f := range fields {
+		if d := f.id - last; d > 0 && d <= 15 {
+			b = append(b, byte(d)<<4|f.typ)
+		} else {
+			b = append(append(b, f.typ), varint(int64(f.id))...)
+		}
+		last = f.id
+		b = append(b, f.v...)
+	}
+	return append(b, 0)
+}
+
+func ti32(id int16, v int64) tfield { return tfield{id, thriftI32, varint(v)} }
+func ti64(id int16, v int64) tfield { return tfield{id, thriftI64, varint(v)} }
+
+func tbin(id int16, s string) tfield {
+	return tfield{id, thriftBinary, append(uvarint(uint64(len(s))), s...)}
+}
+
+func tsub(id int16, fields ...tfield) tfield {
+	return tfield{id, thriftStruct, tstruct(fields...)}
+}
+
+func tlist(id int16, typ byte, elems ...[]byte) tfield {
+	b := []byte{byte(len(elems))<<4 | typ}
+	for _, e := range elems {
+		b = append(b, e...)
+	}
+	return tfield{id, thriftList, b}
+}
+
+func plainStrings(ss ...string) []byte {
+	var b []byte
+	for _, s := range ss {
+		b = append(b, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+// levels prefixes levels of a data page v1 with their length.
+func levels(b ...byte) []byte {
+	return append([]byte{byte(len(b)), 0, 0, 0}, b...)
+}
+
+// snappyLiteral compresses b as a single Snappy literal.
+func snappyLiteral(b []byte) []byte {
+	return append(append(uvarint(uint64(len(b))), byte(len(b)-1)<<2), b...)
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testPage is a page of a column chunk of the test file.
+type testPage struct {
+	header tfield
+	// Number of values of a data page.
+	values     int64
+	data       []byte
+	compressed []byte
+}
+
+func (p testPage) bytes() []byte {
+	data := p.compressed
+	if data == nil {
+		data = p.data
+	}
+	typ := map[int16]int64{5: pageData, 7: pageDictionary, 8: pageDataV2}[p.header.id]
+	return append(tstruct(ti32(1, typ), ti32(2, int64(len(p.data))), ti32(3, int64(len(data))), p.header), data...)
+}
+
+func dataPage(n, encoding int64) tfield {
+	return tsub(5, ti32(1, n), ti32(2, encoding), ti32(3, 3), ti32(4, 3))
+}
+
+func TestReadParquet(t *testing.T) {
+	doubles := make([]byte, 16)
+	binary.LittleEndian.PutUint64(doubles, math.Float64bits(40.7))
+	binary.LittleEndian.PutUint64(doubles[8:], math.Float64bits(40.6))
+	int32s := func(vs ...int32) []byte {
+		b := make([]byte, 4*len(vs))
+		for i, v := range vs {
+			binary.LittleEndian.PutUint32(b[4*i:], uint32(v))
+		}
+		return b
+	}
+	nameData := append(levels(2, 1, 2, 0, 2, 1), 1, 3, 0x01)
+	tagsData := append(append(levels(2, 0, 2, 1, 4, 0), levels(4, 3, 2, 1, 2, 3)...), plainStrings("a", "b", "c")...)
+	chunks := []struct {
+		typ, codec int64
+		path       []string
+		pages      []testPage
+	}{
+		{parquetInt32, codecNone, []string{"id"}, []testPage{
+			{header: dataPage(3, encodingPlain), values: 3, data: int32s(1, 2, 3)},
+		}},
+		{parquetByteArray, codecSnappy, []string{"name"}, []testPage{
+			{header: tsub(7, ti32(1, 2), ti32(2, encodingPlain)), data: plainStrings("Kings", "Queens")},
+			{header: dataPage(3, encodingRLEDictionary), values: 3, data: nameData},
+		}},
+		{parquetDouble, codecNone, []string{"location", "latitude"}, []testPage{
+			{header: tsub(8, ti32(1, 3), ti32(2, 1), ti32(3, 3), ti32(4, encodingPlain), ti32(5, 6), ti32(6, 0),
+				tfield{7, thriftFalse, nil}), values: 3, data: append([]byte{2, 2, 2, 0, 2, 2}, doubles...)},
+		}},
+		{parquetInt32, codecNone, []string{"day"}, []testPage{
+			{header: dataPage(3, encodingPlain), values: 3, data: int32s(0, 1, 18262)},
+		}},
+		{parquetByteArray, codecGzip, []string{"tags", "list", "element"}, []testPage{
+			{header: dataPage(4, encodingPlain), values: 4, data: tagsData},
+		}},
+	}
+
+	file := []byte("PAR1")
+	var columns [][]byte
+	for _, c := range chunks {
+		offset := len(file)
+		dataOffset, dictOffset := int64(offset), int64(0)
+		var values int64
+		for _, p := range c.pages {
+			switch c.codec {
+			case codecSnappy:
+				p.compressed = snappyLiteral(p.data)
+			case codecGzip:
+				p.compressed = gzipped(t, p.data)
+			}
+			if p.header.id == 7 {
+				dictOffset = int64(len(file))
+			} else {
+				dataOffset = int64(len(file))
+				values += p.values
+			}
+			file = append(file, p.bytes()...)
+		}
+		var path [][]byte
+		for _, name := range c.path {
+			path = append(path, tbin(0, name).v)
+		}
+		meta := []tfield{
+			ti32(1, c.typ),
+			tlist(2, thriftI32, varint(0), varint(8)),
+			tlist(3, thriftBinary, path...),
+			ti32(4, c.codec),
+			ti64(5, values),
+			ti64(6, 0),
+			ti64(7, int64(len(file)-offset)),
+			ti64(9, dataOffset),
+		}
+		if dictOffset > 0 {
+			meta = append(meta, ti64(11, dictOffset))
+		}
+		columns = append(columns, tstruct(ti64(2, int64(offset)), tsub(3, meta...)))
+	}
+
+	schema := [][]byte{
+		tstruct(tbin(4, "schema"), ti32(5, 5)),
+		tstruct(ti32(1, parquetInt32), ti32(3, parquetRequired), tbin(4, "id")),
+		tstruct(ti32(1, parquetByteArray), ti32(3, parquetOptional), tbin(4, "name"), ti32(6, 0)),
+		tstruct(ti32(3, parquetOptional), tbin(4, "location"), ti32(5, 1)),
+		tstruct(ti32(1, parquetDouble), ti32(3, parquetOptional), tbin(4, "latitude")),
+		tstruct(ti32(1, parquetInt32), ti32(3, parquetRequired), tbin(4, "day"), tsub(10, tsub(6))),
+		tstruct(ti32(3, parquetOptional), tbin(4, "tags"), ti32(5, 1), ti32(6, 3)),
+		tstruct(ti32(3, parquetRepeated), tbin(4, "list"), ti32(5, 1)),
+		tstruct(ti32(1, parquetByteArray), ti32(3, parquetOptional), tbin(4, "element"), tsub(10, tsub(1))),
+	}
+	meta := tstruct(
+		ti32(1, 1),
+		tlist(2, thriftStruct, schema...),
+		ti64(3, 3),
+		tlist(4, thriftStruct, tstruct(tlist(1, thriftStruct, columns...), ti64(2, 0), ti64(3, 3))),
+		tbin(6, "test"),
+	)
+	file = append(file, meta...)
+	file = append(file, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(file[len(file)-4:], uint32(len(meta)))
+	file = append(file, "PAR1"...)
+
+	attrs, err := ReadParquet(bytes.NewReader(file), int64(len(file)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Attribute{
+		{"id", coltype.Int, []string{"1", "2"}},
+		{"name", coltype.String, []string{"Queens"}},
+		{"location.latitude", coltype.Float, []string{"40.7"}},
+		{"day", coltype.Date, []string{"1970-01-01", "1970-01-02"}},
+		{"tags", coltype.String, []string{"a", "b"}},
+	}
+	if !reflect.DeepEqual(attrs, want) {
+		for _, a := range attrs {
+			t.Logf("%+v", a)
+		}
+		t.Errorf("got %v attributes, want %v", len(attrs), len(want))
+	}
+
+	if _, err := ReadParquet(bytes.NewReader([]byte("PAR1 not parquet")), 16, 2); err == nil {
+		t.Error("got no error for an invalid file")
+	}
+}
+
+func TestDecodeSnappy(t *testing.T) {
+	// Literal "abc" and a copy of 6 bytes at offset 3.
+	got, err := decodeSnappy([]byte{9, 0x08, 'a', 'b', 'c', 0x09, 0x03}, 100)
+	if err != nil || string(got) != "abcabcabc" {
+		t.Errorf("got %q, %v, want abcabcabc", got, err)
+	}
+	if _, err := decodeSnappy([]byte{9, 0x08, 'a', 'b', 'c', 0x09, 0x04}, 100); err == nil {
+		t.Error("got no error for an offset out of range")
+	}
+}
@@ -0,0 +1,80 @@
+package datafile
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errSnappy = errors.New("invalid snappy block")
+
+// decodeSnappy decompresses a block of the Snappy format, in which the pages
+// of most Parquet files are compressed, of at most maxLen bytes decompressed.
+func decodeSnappy(src []byte, maxLen int) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 || n > uint64(maxLen) {
+		return nil, errSnappy
+	}
+	src = src[k:]
+	dst := make([]byte, 0, n)
+	for len(src) > 0 {
+		tag := src[0]
+		src = src[1:]
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			// Literal, whose length is in the tag or in the 1-4 bytes after
+			// it.
+			length = int(tag >> 2)
+			if length >= 60 {
+				nb := length - 59
+				if len(src) < nb {
+					return nil, errSnappy
+				}
+				length = 0
+				for i := nb - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[nb:]
+			}
+			length++
+			if length <= 0 || length > len(src) || len(dst)+length > int(n) {
+				return nil, errSnappy
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1:
+			if len(src) < 1 {
+				return nil, errSnappy
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[0])
+			src = src[1:]
+		case 2:
+			if len(src) < 2 {
+				return nil, errSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src))
+			src = src[2:]
+		case 3:
+			if len(src) < 4 {
+				return nil, errSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src))
+			src = src[4:]
+		}
+		// Copy of earlier output, which may overlap the bytes copied.
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(n) {
+			return nil, errSnappy
+		}
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != int(n) {
+		return nil, errSnappy
+	}
+	return dst, nil
+}
@@ -0,0 +1,198 @@
+package datafile
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Types of the Thrift compact protocol.
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+// Maximum length of a Thrift string or binary value.
+const maxThriftBinary = 1 << 24
+
+var errThrift = errors.New("invalid thrift value")
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// thriftReader decodes structs of the Thrift compact protocol, in which the
+// Parquet file metadata and page headers are encoded.
+type thriftReader struct {
+	r byteReader
+}
+
+func (t *thriftReader) i64() (int64, error) {
+	return binary.ReadVarint(t.r)
+}
+
+func (t *thriftReader) i32() (int32, error) {
+	v, err := t.i64()
+	if err != nil {
+		return 0, err
+	}
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return 0, errThrift
+	}
+	return int32(v), nil
+}
+
+// i32Field reads a field of type i32 into v, or skips it if it has another
+// type.
+func (t *thriftReader) i32Field(typ byte, v *int32) error {
+	if typ != thriftI32 {
+		return t.skip(typ)
+	}
+	x, err := t.i32()
+	*v = x
+	return err
+}
+
+// i64Field reads a field of type i64 into v, or skips it if it has another
+// type.
+func (t *thriftReader) i64Field(typ byte, v *int64) error {
+	if typ != thriftI64 {
+		return t.skip(typ)
+	}
+	x, err := t.i64()
+	*v = x
+	return err
+}
+
+func (t *thriftReader) binary() ([]byte, error) {
+	n, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxThriftBinary {
+		return nil, errThrift
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(t.r, b)
+	return b, err
+}
+
+func (t *thriftReader) string() (string, error) {
+	b, err := t.binary()
+	return string(b), err
+}
+
+// fields reads the fields of a struct, calling f with the ID and type of each,
+// which must read or skip the field's value.
+func (t *thriftReader) fields(f func(id int16, typ byte) error) error {
+	var id int16
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		typ := b & 0x0f
+		if typ == 0 {
+			return nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := t.i64()
+			if err != nil {
+				return err
+			}
+			id = int16(v)
+		}
+		if err := f(id, typ); err != nil {
+			return err
+		}
+	}
+}
+
+// list reads the header of a list or set, calling f with the type of its
+// elements for each element, which must read or skip it. Booleans in lists are
+// encoded as a byte.
+func (t *thriftReader) list(f func(typ byte) error) error {
+	b, err := t.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	n := uint64(b >> 4)
+	if n == 15 {
+		if n, err = binary.ReadUvarint(t.r); err != nil {
+			return err
+		}
+	}
+	typ := b & 0x0f
+	for i := uint64(0); i < n; i++ {
+		if err := f(typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skip skips a value of the given type that is not a list element.
+func (t *thriftReader) skip(typ byte) error {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		return nil
+	case thriftByte:
+		_, err := t.r.ReadByte()
+		return err
+	case thriftI16, thriftI32, thriftI64:
+		_, err := t.i64()
+		return err
+	case thriftDouble:
+		_, err := io.ReadFull(t.r, make([]byte, 8))
+		return err
+	case thriftBinary:
+		_, err := t.binary()
+		return err
+	case thriftList, thriftSet:
+		return t.list(t.skipElement)
+	case thriftMap:
+		n, err := binary.ReadUvarint(t.r)
+		if err != nil || n == 0 {
+			return err
+		}
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := t.skipElement(b >> 4); err != nil {
+				return err
+			}
+			if err := t.skipElement(b & 0x0f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftStruct:
+		return t.fields(func(_ int16, typ byte) error { return t.skip(typ) })
+	}
+	return errThrift
+}
+
+// skipElement skips a list, set, or map element of the given type.
+func (t *thriftReader) skipElement(typ byte) error {
+	if typ == thriftTrue || typ == thriftFalse {
+		_, err := t.r.ReadByte()
+		return err
+	}
+	return t.skip(typ)
+}
@@ -0,0 +1,358 @@
+package datafile
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sheet is a sheet of a spreadsheet file, a table with its own attributes.
+type Sheet struct {
+	Name       string
+	Attributes []*Attribute
+}
+
+var errXLSX = errors.New("invalid xlsx file")
+
+type xlsxWorkbook struct {
+	Properties struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		// ID of the sheet's relationship, in the namespace of the
+		// relationships.
+		RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+// xlsxText is a string of a cell or of the shared strings, made of runs if it
+// is rich text.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t *xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxRow struct {
+	Cells []struct {
+		Ref    string   `xml:"r,attr"`
+		Type   string   `xml:"t,attr"`
+		Style  int      `xml:"s,attr"`
+		Value  string   `xml:"v"`
+		Inline xlsxText `xml:"is"`
+	} `xml:"c"`
+}
+
+// xlsxFile is an open xlsx file.
+type xlsxFile struct {
+	files map[string]*zip.File
+	// Shared strings, which cells of type "s" are indexes of.
+	strings []string
+	// Whether the cells of each style are dates.
+	dateStyles []bool
+	date1904   bool
+}
+
+// ReadXLSX profiles the sheets of the Excel (xlsx) file of the given size read
+// from r, in the order of the workbook. The attributes of a sheet are the
+// columns named by its first row, profiled from at most maxRows of the rows
+// after it. Columns with blank names are skipped, and sheets without columns
+// have no attributes.
+func ReadXLSX(r io.ReaderAt, size int64, maxRows int) ([]*Sheet, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	f := &xlsxFile{files: make(map[string]*zip.File)}
+	for _, zf := range zr.File {
+		f.files[zf.Name] = zf
+	}
+
+	var wb xlsxWorkbook
+	if err := f.decode("xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+	var rels xlsxRelationships
+	if err := f.decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+	if err := f.readStrings(); err != nil {
+		return nil, err
+	}
+	if err := f.readStyles(); err != nil {
+		return nil, err
+	}
+	f.date1904 = wb.Properties.Date1904
+
+	sheets := make([]*Sheet, len(wb.Sheets))
+	for i, s := range wb.Sheets {
+		target, ok := targets[s.RelID]
+		if !ok {
+			return nil, fmt.Errorf("%w: sheet %q has no worksheet", errXLSX, s.Name)
+		}
+		attrs, err := f.readSheet(target, maxRows)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %q: %w", s.Name, err)
+		}
+		sheets[i] = &Sheet{Name: s.Name, Attributes: attrs}
+	}
+	return sheets, nil
+}
+
+func (f *xlsxFile) open(name string) (io.ReadCloser, error) {
+	zf, ok := f.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: no %v", errXLSX, name)
+	}
+	return zf.Open()
+}
+
+func (f *xlsxFile) decode(name string, v interface{}) error {
+	rc, err := f.open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// readStrings reads the shared strings of the file, if it has any.
+func (f *xlsxFile) readStrings() error {
+	if _, ok := f.files["xl/sharedStrings.xml"]; !ok {
+		return nil
+	}
+	rc, err := f.open("xl/sharedStrings.xml")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "si" {
+			var t xlsxText
+			if err := dec.DecodeElement(&t, &se); err != nil {
+				return err
+			}
+			f.strings = append(f.strings, t.String())
+		}
+	}
+}
+
+// readStyles reads which cell styles of the file are dates, if it has styles.
+func (f *xlsxFile) readStyles() error {
+	if _, ok := f.files["xl/styles.xml"]; !ok {
+		return nil
+	}
+	var styles xlsxStyles
+	if err := f.decode("xl/styles.xml", &styles); err != nil {
+		return err
+	}
+	codes := make(map[int]string)
+	for _, nf := range styles.NumFmts {
+		codes[nf.ID] = nf.Code
+	}
+	for _, xf := range styles.CellXfs {
+		f.dateStyles = append(f.dateStyles, isDateFormat(xf.NumFmtID, codes[xf.NumFmtID]))
+	}
+	return nil
+}
+
+// isDateFormat reports whether the number format with the given ID and code
+// (for custom formats) formats numbers as dates.
+func isDateFormat(id int, code string) bool {
+	switch {
+	case id >= 14 && id <= 22, id >= 45 && id <= 47:
+		return true
+	case code == "":
+		return false
+	}
+	// Custom formats are dates if they have date or time placeholders
+	// outside of quoted text and brackets, such as colors.
+	quoted, bracket := false, false
+	for _, c := range strings.ToLower(code) {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			bracket = true
+		case c == ']':
+			bracket = false
+		case bracket:
+		case strings.ContainsRune("dmyhs", c):
+			return true
+		}
+	}
+	return false
+}
+
+func (f *xlsxFile) readSheet(name string, maxRows int) ([]*Attribute, error) {
+	rc, err := f.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	p := newProfiler()
+	// Attribute of each column, by column index, or -1.
+	var columns []int
+	rows := 0
+	dec := xml.NewDecoder(rc)
+	for rows < maxRows {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "row" {
+			continue
+		}
+		var row xlsxRow
+		if err := dec.DecodeElement(&row, &se); err != nil {
+			return nil, err
+		}
+		header := columns == nil
+		if header {
+			columns = []int{}
+		}
+		col := -1
+		for _, c := range row.Cells {
+			if i, ok := cellColumn(c.Ref); ok {
+				col = i
+			} else {
+				col++
+			}
+			var v string
+			switch c.Type {
+			case "s":
+				i, err := strconv.Atoi(c.Value)
+				if err != nil || i < 0 || i >= len(f.strings) {
+					return nil, fmt.Errorf("%w: cell %v has no shared string %v", errXLSX, c.Ref, c.Value)
+				}
+				v = f.strings[i]
+			case "inlineStr":
+				v = c.Inline.String()
+			case "b":
+				v = strconv.FormatBool(c.Value == "1")
+			case "e":
+				// Errors, such as #N/A, are blank.
+			case "", "n":
+				v = c.Value
+				if c.Style >= 0 && c.Style < len(f.dateStyles) && f.dateStyles[c.Style] {
+					v = f.formatDate(v)
+				}
+			default:
+				// Formula strings ("str") and ISO 8601 dates ("d").
+				v = c.Value
+			}
+
+			if header {
+				for len(columns) <= col {
+					columns = append(columns, -1)
+				}
+				if name := strings.TrimSpace(v); name != "" {
+					columns[col] = p.attribute(name)
+				}
+			} else if col < len(columns) && columns[col] >= 0 {
+				p.add(columns[col], v)
+			}
+		}
+		if !header {
+			rows++
+		}
+	}
+	return p.result(), nil
+}
+
+// cellColumn returns the index of the column of a cell reference, such as 2
+// for "C7".
+func cellColumn(ref string) (int, bool) {
+	col := 0
+	n := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+		if n++; n > 3 {
+			return 0, false
+		}
+	}
+	return col - 1, n > 0
+}
+
+// formatDate formats a date serial number, the days since the epoch of the
+// workbook, as a date, with its time of day if it has one. Values that are
+// not numbers are returned unchanged.
+func (f *xlsxFile) formatDate(v string) string {
+	days, err := strconv.ParseFloat(v, 64)
+	if err != nil || days < 0 || days > 2958465 {
+		return v
+	}
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if f.date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	whole, frac := math.Modf(days)
+	t := epoch.AddDate(0, 0, int(whole)).Add(time.Duration(math.Round(frac*86400)) * time.Second)
+	if frac == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
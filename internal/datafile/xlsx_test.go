@@ -0,0 +1,80 @@
+package datafile
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+)
+
+func TestReadXLSX(t *testing.T) {
+	files := []struct{ name, body string }{
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Schools" sheetId="1" r:id="rId1"/><sheet name="Notes" sheetId="2" r:id="rId2"/></sheets>
+</workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="/xl/worksheets/sheet2.xml"/>
+</Relationships>`},
+		{"xl/sharedStrings.xml", `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>school</t></si><si><t>opened</t></si><si><t>students</t></si><si><r><t>PS </t></r><r><t>1</t></r></si>
+</sst>`},
+		{"xl/styles.xml", `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="[Red]0.00"/></numFmts>
+<cellXfs count="3"><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/></cellXfs>
+</styleSheet>`},
+		{"xl/worksheets/sheet1.xml", `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c><c r="D1" t="inlineStr"><is><t>public</t></is></c></row>
+<row r="2"><c r="A2" t="s"><v>3</v></c><c r="B2" s="1"><v>37135</v></c><c r="C2" s="2"><v>512</v></c><c r="D2" t="b"><v>1</v></c></row>
+<row r="3"><c r="A3" t="inlineStr"><is><t>PS 2</t></is></c><c r="C3"><v>480</v></c><c r="D3" t="e"><v>#N/A</v></c></row>
+<row r="4"><c r="A4" t="inlineStr"><is><t>skipped</t></is></c></row>
+</sheetData></worksheet>`},
+		{"xl/worksheets/sheet2.xml", `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="inlineStr"><is><t>note</t></is></c><c r="C1" t="inlineStr"><is><t>author</t></is></c></row>
+<row r="2"><c t="inlineStr"><is><t>x</t></is></c><c t="inlineStr"><is><t>y</t></is></c><c t="inlineStr"><is><t>Ann</t></is></c></row>
+</sheetData></worksheet>`},
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sheets, err := ReadXLSX(bytes.NewReader(buf.Bytes()), int64(buf.Len()), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Sheet{
+		{"Schools", []*Attribute{
+			{"school", coltype.String, []string{"PS 1", "PS 2"}},
+			{"opened", coltype.Date, []string{"2001-09-01"}},
+			{"students", coltype.Int, []string{"512", "480"}},
+			{"public", coltype.Bool, []string{"true"}},
+		}},
+		{"Notes", []*Attribute{
+			{"note", coltype.String, []string{"x"}},
+			{"author", coltype.String, []string{"Ann"}},
+		}},
+	}
+	if !reflect.DeepEqual(sheets, want) {
+		for _, s := range sheets {
+			for _, a := range s.Attributes {
+				t.Logf("%v: %+v", s.Name, a)
+			}
+		}
+		t.Errorf("got %v sheets, want %v", len(sheets), len(want))
+	}
+}
@@ -0,0 +1,216 @@
+// Package dcat reads the datasets of DCAT catalogs, such as the DCAT-AP
+// catalogs of European open data portals, for the ingestion pipeline. The
+// catalogs are RDF documents, in Turtle, RDF/XML, or JSON-LD, whose
+// dcat:Dataset resources, and their dcat:Distribution resources, are mapped
+// into the metadata of harvested datasets (see package harvest). The
+// schema.org Dataset resources of the JSON-LD of web pages, which describe
+// datasets published outside of catalogs, are mapped likewise.
+package dcat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+)
+
+// Maximum length of the dct:identifier used as dataset ID
+const maxIDLength = 100
+
+// Datasets returns the harvested datasets of the dcat:Dataset resources of g.
+// Of the literals in several languages, those in lang are preferred, then
+// those without a language.
+//
+// The dct:title, dct:description, dct:publisher, dcat:contactPoint,
+// dct:modified, or else dct:issued, dcat:theme, dcat:keyword, and
+// dcat:landingPage of each dataset become its name, description,
+// attribution, contact email, update time, categories, tags, and permalink,
+// and its dcat:distribution resources its distributions. The dataset is
+// identified by its dct:identifier, if it is a valid dataset ID, or else by a
+// hash of its IRI.
+func Datasets(g *Graph, lang string) []*harvest.Dataset {
+	r := &reader{g: g, lang: strings.ToLower(lang)}
+	var datasets []*harvest.Dataset
+	for _, s := range g.Subjects(dcatNS + "Dataset") {
+		datasets = append(datasets, r.dataset(s))
+	}
+	return datasets
+}
+
+// reader reads the resources of a graph.
+type reader struct {
+	g    *Graph
+	lang string
+}
+
+// text returns the value of the literal of the property p of s in the
+// preferred language, or "".
+func (r *reader) text(s Term, p string) string {
+	var first, plain *Term
+	for _, o := range r.g.Objects(s, p) {
+		o := o
+		if o.Kind != Literal {
+			continue
+		}
+		if o.Lang == r.lang || strings.HasPrefix(o.Lang, r.lang+"-") {
+			return strings.TrimSpace(o.Value)
+		}
+		if o.Lang == "" && plain == nil {
+			plain = &o
+		}
+		if first == nil {
+			first = &o
+		}
+	}
+	if plain != nil {
+		return strings.TrimSpace(plain.Value)
+	}
+	if first != nil {
+		return strings.TrimSpace(first.Value)
+	}
+	return ""
+}
+
+// texts returns the values of the literals of the property p of s, in the
+// preferred language or without a language if there are any, or else in all
+// of them.
+func (r *reader) texts(s Term, p string) []string {
+	var preferred, all []string
+	for _, o := range r.g.Objects(s, p) {
+		if o.Kind != Literal {
+			continue
+		}
+		v := strings.TrimSpace(o.Value)
+		if o.Lang == "" || o.Lang == r.lang || strings.HasPrefix(o.Lang, r.lang+"-") {
+			preferred = append(preferred, v)
+		}
+		all = append(all, v)
+	}
+	if len(preferred) > 0 {
+		return preferred
+	}
+	return all
+}
+
+// link returns the IRI of the property p of s, or of its literal, as some
+// catalogs write the URLs, or "".
+func (r *reader) link(s Term, p string) string {
+	for _, o := range r.g.Objects(s, p) {
+		if o.Kind != Blank {
+			return strings.TrimSpace(o.Value)
+		}
+	}
+	return ""
+}
+
+// label returns the label of the resource, or literal, o: its name, or the
+// last segment of its IRI.
+func (r *reader) label(o Term) string {
+	if o.Kind == Literal {
+		return strings.TrimSpace(o.Value)
+	}
+	for _, p := range []string{skosNS + "prefLabel", foafNS + "name", vcardNS + "fn", rdfsNS + "label", dctNS + "title", schemaNS + "name"} {
+		if v := r.text(o, p); v != "" {
+			return v
+		}
+	}
+	if o.Kind != IRI {
+		return ""
+	}
+	v := strings.TrimRight(o.Value, "/#")
+	if i := strings.LastIndexAny(v, "/#"); i >= 0 {
+		v = v[i+1:]
+	}
+	return v
+}
+
+// email returns the email address of the contact point o, or "".
+func (r *reader) email(o Term) string {
+	if o.Kind == Literal {
+		return strings.TrimPrefix(strings.TrimSpace(o.Value), "mailto:")
+	}
+	for _, e := range r.g.Objects(o, vcardNS+"hasEmail") {
+		if v := strings.TrimPrefix(strings.TrimSpace(e.Value), "mailto:"); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (r *reader) dataset(s Term) *harvest.Dataset {
+	m := &database.Metadata{
+		Name:        r.text(s, dctNS+"title"),
+		Description: r.text(s, dctNS+"description"),
+		UpdatedAt:   r.text(s, dctNS+"modified"),
+		Permalink:   r.link(s, dcatNS+"landingPage"),
+	}
+	if m.UpdatedAt == "" {
+		m.UpdatedAt = r.text(s, dctNS+"issued")
+	}
+	if m.Permalink == "" && s.Kind == IRI && strings.HasPrefix(s.Value, "http") {
+		m.Permalink = s.Value
+	}
+	m.DatasetID = datasetID(s, r.text(s, dctNS+"identifier"), m)
+	for _, o := range r.g.Objects(s, dctNS+"publisher") {
+		if m.Attribution = r.label(o); m.Attribution != "" {
+			break
+		}
+	}
+	for _, o := range r.g.Objects(s, dcatNS+"contactPoint") {
+		if m.ContactEmail = r.email(o); m.ContactEmail != "" {
+			break
+		}
+	}
+	var categories []string
+	for _, o := range r.g.Objects(s, dcatNS+"theme") {
+		categories = append(categories, r.label(o))
+	}
+	m.Categories = harvest.Unique(categories)
+	m.Tags = harvest.Unique(r.texts(s, dcatNS+"keyword"))
+
+	d := &harvest.Dataset{Metadata: m}
+	for _, o := range r.g.Objects(s, dcatNS+"distribution") {
+		d.Distributions = append(d.Distributions, r.distribution(o))
+	}
+	return d
+}
+
+func (r *reader) distribution(o Term) *harvest.Distribution {
+	f := &harvest.Distribution{
+		Name:        r.text(o, dctNS+"title"),
+		URL:         r.link(o, dcatNS+"downloadURL"),
+		Description: r.text(o, dctNS+"description"),
+	}
+	if f.URL == "" {
+		f.URL = r.link(o, dcatNS+"accessURL")
+	}
+	for _, p := range []string{dctNS + "format", dcatNS + "mediaType"} {
+		for _, v := range r.g.Objects(o, p) {
+			if f.Format = r.label(v); f.Format != "" {
+				return f
+			}
+		}
+	}
+	return f
+}
+
+// datasetID returns the ID of the dataset s, of metadata m: its identifier,
+// if it is a valid ID, or else a hash of its IRI. The blank nodes, whose
+// labels change between documents, are identified by a hash of their
+// identifier, or else of their name and permalink.
+func datasetID(s Term, identifier string, m *database.Metadata) string {
+	if len(identifier) <= maxIDLength && harvest.ValidID(identifier) {
+		return identifier
+	}
+	key := s.Value
+	if s.Kind != IRI {
+		key = identifier
+		if key == "" {
+			key = m.Name + "\n" + m.Permalink
+		}
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "dcat-" + hex.EncodeToString(sum[:8])
+}
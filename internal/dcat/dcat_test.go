@@ -0,0 +1,266 @@
+package dcat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+)
+
+// The same catalog in each of the formats
+var catalogs = map[string]string{
+	"turtle": `
+@prefix dcat: <http://www.w3.org/ns/dcat#> .
+@prefix dct: <http://purl.org/dc/terms/> .
+@prefix foaf: <http://xmlns.com/foaf/0.1/> .
+@prefix vcard: <http://www.w3.org/2006/vcard/ns#> .
+@prefix skos: <http://www.w3.org/2004/02/skos/core#> .
+@base <https://data.example.eu/> .
+
+<catalog> a dcat:Catalog ;
+    dcat:dataset <dataset/air-quality> .
+
+<dataset/air-quality> a dcat:Dataset ;
+    dct:identifier "air-quality" ;
+    dct:title "Luftqualität"@de, "Air quality"@en ;
+    dct:description """Hourly measurements
+of air pollutants."""@en ;
+    dct:publisher [ a foaf:Agent ; foaf:name "Environment Agency" ] ;
+    dcat:contactPoint [ a vcard:Kind ; vcard:hasEmail <mailto:air@example.eu> ] ;
+    dct:modified "2021-03-04T05:06:07Z" ;
+    dcat:theme <http://publications.europa.eu/resource/authority/data-theme/ENVI> ;
+    dcat:keyword "air"@en, "pollution"@en, "Luft"@de ;
+    dcat:landingPage <https://example.eu/air> ;
+    dcat:distribution [
+        a dcat:Distribution ;
+        dct:title "Measurements" ;
+        dcat:downloadURL <files/air.csv> ;
+        dct:format <http://publications.europa.eu/resource/authority/file-type/CSV>
+    ] .
+
+<dataset/2> a dcat:Dataset ;
+    dct:identifier "http://example.eu/id/2" ;
+    dct:title "Noise" ;
+    dct:issued "2020-01-01" .
+`,
+	"rdfxml": `<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+    xmlns:dcat="http://www.w3.org/ns/dcat#"
+    xmlns:dct="http://purl.org/dc/terms/"
+    xmlns:foaf="http://xmlns.com/foaf/0.1/"
+    xmlns:vcard="http://www.w3.org/2006/vcard/ns#"
+    xml:base="https://data.example.eu/">
+  <dcat:Catalog rdf:about="catalog">
+    <dcat:dataset>
+      <dcat:Dataset rdf:about="dataset/air-quality">
+        <dct:identifier>air-quality</dct:identifier>
+        <dct:title xml:lang="de">Luftqualität</dct:title>
+        <dct:title xml:lang="en">Air quality</dct:title>
+        <dct:description xml:lang="en">Hourly measurements
+of air pollutants.</dct:description>
+        <dct:publisher>
+          <foaf:Agent><foaf:name>Environment Agency</foaf:name></foaf:Agent>
+        </dct:publisher>
+        <dcat:contactPoint rdf:parseType="Resource">
+          <vcard:hasEmail rdf:resource="mailto:air@example.eu"/>
+        </dcat:contactPoint>
+        <dct:modified>2021-03-04T05:06:07Z</dct:modified>
+        <dcat:theme rdf:resource="http://publications.europa.eu/resource/authority/data-theme/ENVI"/>
+        <dcat:keyword xml:lang="en">air</dcat:keyword>
+        <dcat:keyword xml:lang="en">pollution</dcat:keyword>
+        <dcat:keyword xml:lang="de">Luft</dcat:keyword>
+        <dcat:landingPage rdf:resource="https://example.eu/air"/>
+        <dcat:distribution>
+          <dcat:Distribution dct:title="Measurements">
+            <dcat:downloadURL rdf:resource="files/air.csv"/>
+            <dct:format rdf:resource="http://publications.europa.eu/resource/authority/file-type/CSV"/>
+          </dcat:Distribution>
+        </dcat:distribution>
+      </dcat:Dataset>
+    </dcat:dataset>
+  </dcat:Catalog>
+  <rdf:Description rdf:about="dataset/2">
+    <rdf:type rdf:resource="http://www.w3.org/ns/dcat#Dataset"/>
+    <dct:identifier>http://example.eu/id/2</dct:identifier>
+    <dct:title>Noise</dct:title>
+    <dct:issued>2020-01-01</dct:issued>
+  </rdf:Description>
+</rdf:RDF>
+`,
+	"jsonld": `{
+  "@context": {
+    "dcat": "http://www.w3.org/ns/dcat#",
+    "dct": "http://purl.org/dc/terms/",
+    "foaf": "http://xmlns.com/foaf/0.1/",
+    "vcard": "http://www.w3.org/2006/vcard/ns#",
+    "@base": "https://data.example.eu/",
+    "title": {"@id": "dct:title", "@container": "@language"},
+    "landingPage": {"@id": "dcat:landingPage", "@type": "@id"}
+  },
+  "@graph": [
+    {
+      "@id": "dataset/air-quality",
+      "@type": "dcat:Dataset",
+      "dct:identifier": "air-quality",
+      "title": {"de": "Luftqualität", "en": "Air quality"},
+      "dct:description": {"@value": "Hourly measurements\nof air pollutants.", "@language": "en"},
+      "dct:publisher": {"@type": "foaf:Agent", "foaf:name": "Environment Agency"},
+      "dcat:contactPoint": {"vcard:hasEmail": {"@id": "mailto:air@example.eu"}},
+      "dct:modified": "2021-03-04T05:06:07Z",
+      "dcat:theme": {"@id": "http://publications.europa.eu/resource/authority/data-theme/ENVI"},
+      "dcat:keyword": [
+        {"@value": "air", "@language": "en"},
+        {"@value": "pollution", "@language": "en"},
+        {"@value": "Luft", "@language": "de"}
+      ],
+      "landingPage": "https://example.eu/air",
+      "dcat:distribution": {
+        "@type": "dcat:Distribution",
+        "dct:title": "Measurements",
+        "dcat:downloadURL": {"@id": "files/air.csv"},
+        "dct:format": {"@id": "http://publications.europa.eu/resource/authority/file-type/CSV"}
+      }
+    },
+    {
+      "@id": "dataset/2",
+      "@type": "dcat:Dataset",
+      "dct:identifier": "http://example.eu/id/2",
+      "title": "Noise",
+      "dct:issued": "2020-01-01"
+    }
+  ]
+}`,
+}
+
+func TestDatasets(t *testing.T) {
+	want := []*harvest.Dataset{
+		{
+			Metadata: &database.Metadata{
+				DatasetID:    "air-quality",
+				Name:         "Air quality",
+				Description:  "Hourly measurements\nof air pollutants.",
+				Attribution:  "Environment Agency",
+				ContactEmail: "air@example.eu",
+				UpdatedAt:    "2021-03-04T05:06:07Z",
+				Categories:   []string{"ENVI"},
+				Tags:         []string{"air", "pollution"},
+				Permalink:    "https://example.eu/air",
+			},
+			Distributions: []*harvest.Distribution{{
+				Name:   "Measurements",
+				URL:    "https://data.example.eu/files/air.csv",
+				Format: "CSV",
+			}},
+		},
+		{
+			Metadata: &database.Metadata{
+				DatasetID: datasetID(iri("https://data.example.eu/dataset/2"), "", nil),
+				Name:      "Noise",
+				UpdatedAt: "2020-01-01",
+				Permalink: "https://data.example.eu/dataset/2",
+			},
+		},
+	}
+	for format, catalog := range catalogs {
+		g, err := Parse(strings.NewReader(catalog), format, "")
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		got := Datasets(g, "en")
+		if len(got) != len(want) {
+			t.Fatalf("%v: got %d datasets, want %d", format, len(got), len(want))
+		}
+		for i := range want {
+			if !reflect.DeepEqual(got[i].Metadata, want[i].Metadata) {
+				t.Errorf("%v: got metadata %+v, want %+v", format, got[i].Metadata, want[i].Metadata)
+			}
+			if len(got[i].Distributions) != len(want[i].Distributions) {
+				t.Errorf("%v: got %d distributions, want %d", format, len(got[i].Distributions), len(want[i].Distributions))
+				continue
+			}
+			for j, f := range want[i].Distributions {
+				if *got[i].Distributions[j] != *f {
+					t.Errorf("%v: got distribution %+v, want %+v", format, got[i].Distributions[j], f)
+				}
+			}
+		}
+	}
+}
+
+func TestParseTurtle(t *testing.T) {
+	g, err := ParseTurtle(strings.NewReader(`
+PREFIX ex: <http://example.org/>
+ex:s ex:p ( 1 2.5 ) , true , 'it\'s' , "é"^^ex:t ;
+    ex:q _:b .
+_:b ex:r ex:o .
+`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := iri("http://example.org/s")
+	objects := g.Objects(s, "http://example.org/p")
+	if len(objects) != 4 {
+		t.Fatalf("got %d objects, want 4", len(objects))
+	}
+	if first := g.Objects(objects[0], rdfNS+"first"); len(first) != 1 || first[0] != literal("1", "", xsdNS+"integer") {
+		t.Errorf("got first item %v of the collection", first)
+	}
+	want := []Term{
+		literal("true", "", xsdNS+"boolean"),
+		literal("it's", "", ""),
+		literal("é", "", "http://example.org/t"),
+	}
+	if !reflect.DeepEqual(objects[1:], want) {
+		t.Errorf("got objects %v, want %v", objects[1:], want)
+	}
+	b := g.Objects(s, "http://example.org/q")
+	if len(b) != 1 || len(g.Objects(b[0], "http://example.org/r")) != 1 {
+		t.Errorf("blank node label not shared between statements")
+	}
+}
+
+func TestSchemaOrgDatasets(t *testing.T) {
+	g, err := ParseJSONLD(strings.NewReader(`{
+  "@context": "https://schema.org/",
+  "@type": "Dataset",
+  "name": "Bird counts",
+  "description": "Counts of birds.",
+  "identifier": {"@type": "PropertyValue", "value": "birds-2020"},
+  "creator": {"@type": "Organization", "name": "Bird Society",
+    "contactPoint": {"@type": "ContactPoint", "email": "mailto:info@birds.example.org"}},
+  "dateModified": "2020-05-06",
+  "keywords": "birds, counts, Birds",
+  "variableMeasured": ["species", {"@type": "PropertyValue", "name": "count", "description": "Number of birds"}],
+  "distribution": {"@type": "DataDownload", "contentUrl": "counts.csv", "encodingFormat": "text/csv"}
+}`), "https://birds.example.org/data/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := SchemaOrgDatasets(g, "https://birds.example.org/data/", "en")
+	if len(got) != 1 {
+		t.Fatalf("got %d datasets, want 1", len(got))
+	}
+	want := &database.Metadata{
+		DatasetID:    "birds-2020",
+		Name:         "Bird counts",
+		Description:  "Counts of birds.",
+		Attribution:  "Bird Society",
+		ContactEmail: "info@birds.example.org",
+		UpdatedAt:    "2020-05-06",
+		Tags:         []string{"birds", "counts"},
+		Permalink:    "https://birds.example.org/data/",
+	}
+	if !reflect.DeepEqual(got[0].Metadata, want) {
+		t.Errorf("got metadata %+v, want %+v", got[0].Metadata, want)
+	}
+	wantColumns := []*harvest.Column{{Name: "species"}, {Name: "count", Description: "Number of birds"}}
+	if !reflect.DeepEqual(got[0].Columns, wantColumns) {
+		t.Errorf("got columns %+v, want %+v", got[0].Columns, wantColumns)
+	}
+	wantDistribution := harvest.Distribution{URL: "https://birds.example.org/data/counts.csv", Format: "text/csv"}
+	if len(got[0].Distributions) != 1 || *got[0].Distributions[0] != wantDistribution {
+		t.Errorf("got distributions %+v, want %+v", got[0].Distributions, wantDistribution)
+	}
+}
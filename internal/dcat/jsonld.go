@@ -0,0 +1,361 @@
+package dcat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Maximum depth of term definitions referring to other terms
+const maxTermDepth = 16
+
+// jsonldContext is an active JSON-LD context.
+type jsonldContext struct {
+	base, vocab, lang string
+	terms             map[string]*termDefinition
+}
+
+// termDefinition is the definition of a term of a JSON-LD context.
+type termDefinition struct {
+	// IRI of the term, possibly a compact IRI or another term. If empty,
+	// the IRI is the term in the vocabulary.
+	id string
+	// Type of the values, such as @id, or a datatype IRI.
+	typ       string
+	container string
+	// Language of the values, if set by the definition.
+	lang    string
+	hasLang bool
+}
+
+// jsonldParser turns the nodes of a JSON-LD document into triples. Remote
+// contexts are not loaded, so terms must be defined by inline contexts, or be
+// those of schema.org.
+type jsonldParser struct {
+	blanks int
+	g      *Graph
+}
+
+// ParseJSONLD parses the JSON-LD document read from r. Relative IRIs are
+// resolved against base, if not empty, or the @base of their context.
+func ParseJSONLD(r io.Reader, base string) (*Graph, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("json-ld: %w", err)
+	}
+	p := &jsonldParser{g: &Graph{}}
+	ctx := &jsonldContext{base: base, terms: make(map[string]*termDefinition)}
+	if err := p.nodes(doc, ctx); err != nil {
+		return nil, err
+	}
+	return p.g, nil
+}
+
+func (p *jsonldParser) newBlank() Term {
+	p.blanks++
+	return blank(fmt.Sprintf("b%d", p.blanks))
+}
+
+// nodes adds the triples of the node object, or array of node objects, v.
+func (p *jsonldParser) nodes(v interface{}, ctx *jsonldContext) error {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, n := range v {
+			if err := p.nodes(n, ctx); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		_, err := p.node(v, ctx)
+		return err
+	}
+	return nil
+}
+
+// with returns the context c updated by the local context v.
+func (c *jsonldContext) with(v interface{}) (*jsonldContext, error) {
+	switch v := v.(type) {
+	case nil:
+		return &jsonldContext{base: c.base, terms: make(map[string]*termDefinition)}, nil
+	case []interface{}:
+		for _, local := range v {
+			var err error
+			if c, err = c.with(local); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	case string:
+		// Remote contexts are not loaded, except that the terms of the
+		// context of schema.org are those of its vocabulary.
+		if isSchemaOrg(v) {
+			next := *c
+			next.vocab = schemaNS
+			return &next, nil
+		}
+		return c, nil
+	}
+	local, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("json-ld: invalid @context")
+	}
+	next := &jsonldContext{base: c.base, vocab: c.vocab, lang: c.lang, terms: make(map[string]*termDefinition)}
+	for term, def := range c.terms {
+		next.terms[term] = def
+	}
+	if b, ok := local["@base"].(string); ok {
+		next.base = resolve(next.base, b)
+	}
+	if vocab, ok := local["@vocab"].(string); ok {
+		next.vocab = vocab
+	}
+	if lang, ok := local["@language"].(string); ok {
+		next.lang = lang
+	} else if _, ok := local["@language"]; ok {
+		next.lang = ""
+	}
+	for term, d := range local {
+		if strings.HasPrefix(term, "@") {
+			continue
+		}
+		switch d := d.(type) {
+		case nil:
+			delete(next.terms, term)
+		case string:
+			next.terms[term] = &termDefinition{id: d}
+		case map[string]interface{}:
+			def := &termDefinition{}
+			def.id, _ = d["@id"].(string)
+			def.typ, _ = d["@type"].(string)
+			def.container, _ = d["@container"].(string)
+			if lang, ok := d["@language"]; ok {
+				def.lang, _ = lang.(string)
+				def.hasLang = true
+			}
+			next.terms[term] = def
+		default:
+			return nil, fmt.Errorf("json-ld: invalid definition of term %q", term)
+		}
+	}
+	return next, nil
+}
+
+// expand returns the IRI of s, a term, a compact IRI, an IRI, or, unless
+// vocab, a relative IRI.
+func (c *jsonldContext) expand(s string, vocab bool) string {
+	for depth := 0; depth < maxTermDepth; depth++ {
+		if def, ok := c.terms[s]; ok && vocab {
+			if def.id == "" {
+				return c.vocab + s
+			}
+			if def.id == s {
+				break
+			}
+			s = def.id
+			continue
+		}
+		if i := strings.IndexByte(s, ':'); i > 0 {
+			prefix, suffix := s[:i], s[i+1:]
+			if prefix == "_" || strings.HasPrefix(suffix, "//") {
+				return s
+			}
+			if def, ok := c.terms[prefix]; ok && def.id != "" {
+				return c.expand(def.id, true) + suffix
+			}
+			return s
+		}
+		break
+	}
+	if strings.HasPrefix(s, "@") {
+		return s
+	}
+	if vocab {
+		if c.vocab == "" {
+			return s
+		}
+		return c.vocab + s
+	}
+	return resolve(c.base, s)
+}
+
+// resource returns the term of the IRI, or blank node identifier, s.
+func (p *jsonldParser) resource(s string, ctx *jsonldContext, vocab bool) Term {
+	s = ctx.expand(s, vocab)
+	if strings.HasPrefix(s, "_:") {
+		return blank("l" + s[2:])
+	}
+	return iri(s)
+}
+
+// isAbsolute reports whether the IRI s has a scheme.
+func isAbsolute(s string) bool {
+	return strings.IndexByte(s, ':') > 0
+}
+
+// node adds the triples of the node object n, and returns its subject.
+func (p *jsonldParser) node(n map[string]interface{}, ctx *jsonldContext) (Term, error) {
+	if local, ok := n["@context"]; ok {
+		var err error
+		if ctx, err = ctx.with(local); err != nil {
+			return Term{}, err
+		}
+	}
+	var subject Term
+	if id, ok := n["@id"].(string); ok {
+		subject = p.resource(id, ctx, false)
+	} else {
+		subject = p.newBlank()
+	}
+	// The keys are sorted so that the triples are in a stable order.
+	keys := make([]string, 0, len(n))
+	for k := range n {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := n[k]
+		switch k {
+		case "@context", "@id":
+			continue
+		case "@type":
+			for _, t := range stringValues(v) {
+				p.g.add(subject, iri(rdfNS+"type"), p.resource(t, ctx, true))
+			}
+			continue
+		case "@graph":
+			if err := p.nodes(v, ctx); err != nil {
+				return Term{}, err
+			}
+			continue
+		}
+		predicate := ctx.expand(k, true)
+		if strings.HasPrefix(predicate, "@") || !isAbsolute(predicate) {
+			continue
+		}
+		objects, err := p.values(v, ctx.terms[k], ctx)
+		if err != nil {
+			return Term{}, err
+		}
+		for _, o := range objects {
+			p.g.add(subject, iri(predicate), o)
+		}
+	}
+	return subject, nil
+}
+
+// stringValues returns the strings of v, a string or an array of strings.
+func stringValues(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// values returns the objects of the value v of a property defined by def,
+// which may be nil.
+func (p *jsonldParser) values(v interface{}, def *termDefinition, ctx *jsonldContext) ([]Term, error) {
+	if def == nil {
+		def = &termDefinition{}
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		var objects []Term
+		for _, item := range v {
+			o, err := p.values(item, def, ctx)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, o...)
+		}
+		return objects, nil
+	case string:
+		switch def.typ {
+		case "@id":
+			return []Term{p.resource(v, ctx, false)}, nil
+		case "@vocab":
+			return []Term{p.resource(v, ctx, true)}, nil
+		case "":
+			lang := ctx.lang
+			if def.hasLang {
+				lang = def.lang
+			}
+			return []Term{literal(v, lang, "")}, nil
+		}
+		return []Term{literal(v, "", ctx.expand(def.typ, true))}, nil
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return []Term{literal(v.String(), "", xsdNS+"double")}, nil
+		}
+		return []Term{literal(v.String(), "", xsdNS+"integer")}, nil
+	case bool:
+		return []Term{literal(fmt.Sprint(v), "", xsdNS+"boolean")}, nil
+	case map[string]interface{}:
+		if def.container == "@language" {
+			if _, ok := v["@value"]; !ok {
+				var objects []Term
+				for lang, s := range v {
+					for _, s := range stringValues(s) {
+						objects = append(objects, literal(s, lang, ""))
+					}
+				}
+				sort.Slice(objects, func(i, j int) bool { return objects[i].Lang < objects[j].Lang })
+				return objects, nil
+			}
+		}
+		if value, ok := v["@value"]; ok {
+			s := fmt.Sprint(value)
+			if t, ok := v["@type"].(string); ok {
+				return []Term{literal(s, "", ctx.expand(t, true))}, nil
+			}
+			lang, ok := v["@language"].(string)
+			if !ok {
+				lang = ctx.lang
+			}
+			return []Term{literal(s, lang, "")}, nil
+		}
+		if list, ok := v["@list"]; ok {
+			items, err := p.values(list, def, ctx)
+			if err != nil {
+				return nil, err
+			}
+			return []Term{p.list(items)}, nil
+		}
+		if set, ok := v["@set"]; ok {
+			return p.values(set, def, ctx)
+		}
+		o, err := p.node(v, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []Term{o}, nil
+	}
+	return nil, fmt.Errorf("json-ld: invalid value %v", v)
+}
+
+// list adds the first and rest nodes of the list of the items, and returns
+// its head.
+func (p *jsonldParser) list(items []Term) Term {
+	head := iri(rdfNS + "nil")
+	for i := len(items) - 1; i >= 0; i-- {
+		node := p.newBlank()
+		p.g.add(node, iri(rdfNS+"first"), items[i])
+		p.g.add(node, iri(rdfNS+"rest"), head)
+		head = node
+	}
+	return head
+}
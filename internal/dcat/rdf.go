@@ -0,0 +1,123 @@
+package dcat
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Namespaces of the vocabularies of DCAT catalogs
+const (
+	rdfNS   = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	rdfsNS  = "http://www.w3.org/2000/01/rdf-schema#"
+	xsdNS   = "http://www.w3.org/2001/XMLSchema#"
+	dcatNS  = "http://www.w3.org/ns/dcat#"
+	dctNS   = "http://purl.org/dc/terms/"
+	foafNS  = "http://xmlns.com/foaf/0.1/"
+	vcardNS = "http://www.w3.org/2006/vcard/ns#"
+	skosNS  = "http://www.w3.org/2004/02/skos/core#"
+	// Namespace of schema.org, whose https IRIs are read as http ones
+	schemaNS = "http://schema.org/"
+)
+
+// Kind is the kind of an RDF term.
+type Kind int
+
+// Kinds of RDF terms
+const (
+	IRI Kind = iota
+	Blank
+	Literal
+)
+
+// Term is an RDF term: an IRI, a blank node, or a literal, with its language
+// or datatype.
+type Term struct {
+	Kind     Kind
+	Value    string
+	Lang     string
+	Datatype string
+}
+
+func iri(v string) Term { return Term{Kind: IRI, Value: v} }
+
+func blank(id string) Term { return Term{Kind: Blank, Value: id} }
+
+func literal(v, lang, datatype string) Term {
+	return Term{Kind: Literal, Value: v, Lang: strings.ToLower(lang), Datatype: datatype}
+}
+
+// Triple is an RDF statement.
+type Triple struct {
+	Subject, Predicate, Object Term
+}
+
+// Graph is a set of triples, indexed by subject.
+type Graph struct {
+	Triples   []Triple
+	bySubject map[Term][]int
+}
+
+func (g *Graph) add(s, p, o Term) {
+	if g.bySubject == nil {
+		g.bySubject = make(map[Term][]int)
+	}
+	g.bySubject[s] = append(g.bySubject[s], len(g.Triples))
+	g.Triples = append(g.Triples, Triple{s, p, o})
+}
+
+// Objects returns the objects of the triples of the subject s and the
+// predicate p.
+func (g *Graph) Objects(s Term, p string) []Term {
+	var objects []Term
+	for _, i := range g.bySubject[s] {
+		if t := g.Triples[i]; t.Predicate.Value == p {
+			objects = append(objects, t.Object)
+		}
+	}
+	return objects
+}
+
+// Subjects returns the subjects of the type, in the order of the triples.
+func (g *Graph) Subjects(typ string) []Term {
+	var subjects []Term
+	seen := make(map[Term]bool)
+	for _, t := range g.Triples {
+		if t.Predicate.Value == rdfNS+"type" && t.Object == iri(typ) && !seen[t.Subject] {
+			seen[t.Subject] = true
+			subjects = append(subjects, t.Subject)
+		}
+	}
+	return subjects
+}
+
+// Formats of RDF documents, by file extension
+var formats = map[string]string{
+	".ttl":    "turtle",
+	".rdf":    "rdfxml",
+	".xml":    "rdfxml",
+	".owl":    "rdfxml",
+	".jsonld": "jsonld",
+	".json":   "jsonld",
+}
+
+// Format returns the format of the RDF document of the file at path,
+// turtle, rdfxml, or jsonld by its extension, or "" if it is unknown.
+func Format(path string) string {
+	return formats[strings.ToLower(filepath.Ext(path))]
+}
+
+// Parse parses the RDF document read from r in the format, turtle, rdfxml, or
+// jsonld. Relative IRIs are resolved against base, if not empty.
+func Parse(r io.Reader, format, base string) (*Graph, error) {
+	switch format {
+	case "turtle":
+		return ParseTurtle(r, base)
+	case "rdfxml":
+		return ParseRDFXML(r, base)
+	case "jsonld":
+		return ParseJSONLD(r, base)
+	}
+	return nil, fmt.Errorf("unknown RDF format %q", format)
+}
@@ -0,0 +1,225 @@
+package dcat
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The namespace of the xml: attributes
+const xmlNS = "http://www.w3.org/XML/1998/namespace"
+
+// xmlElement is an element of an RDF/XML document.
+type xmlElement struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xmlElement
+	text     strings.Builder
+}
+
+func (e *xmlElement) attr(space, local string) (string, bool) {
+	for _, a := range e.attrs {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// rdfxmlParser turns the elements of an RDF/XML document into triples.
+type rdfxmlParser struct {
+	blanks int
+	g      *Graph
+}
+
+// ParseRDFXML parses the RDF/XML document read from r. Relative IRIs are
+// resolved against base, if not empty, or the xml:base of their elements.
+func ParseRDFXML(r io.Reader, base string) (*Graph, error) {
+	root, err := readXML(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &rdfxmlParser{g: &Graph{}}
+	if root.name.Space == rdfNS && root.name.Local == "RDF" {
+		base, lang := p.scope(root, base, "")
+		for _, e := range root.children {
+			if _, err := p.node(e, base, lang); err != nil {
+				return nil, err
+			}
+		}
+	} else if _, err := p.node(root, base, ""); err != nil {
+		return nil, err
+	}
+	return p.g, nil
+}
+
+// readXML reads the tree of the elements of the XML document read from r.
+func readXML(r io.Reader) (*xmlElement, error) {
+	dec := xml.NewDecoder(r)
+	var stack []*xmlElement
+	var root *xmlElement
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rdf/xml: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			e := &xmlElement{name: tok.Name, attrs: tok.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, e)
+			} else if root == nil {
+				root = e
+			}
+			stack = append(stack, e)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(tok)
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("rdf/xml: no root element")
+	}
+	return root, nil
+}
+
+// scope returns the base and language of the element e, in the scope of the
+// given ones.
+func (p *rdfxmlParser) scope(e *xmlElement, base, lang string) (string, string) {
+	if b, ok := e.attr(xmlNS, "base"); ok {
+		base = resolve(base, b)
+	}
+	if l, ok := e.attr(xmlNS, "lang"); ok {
+		lang = l
+	}
+	return base, lang
+}
+
+func (p *rdfxmlParser) newBlank() Term {
+	p.blanks++
+	return blank(fmt.Sprintf("b%d", p.blanks))
+}
+
+// isSyntaxAttr reports whether the attribute is not a property attribute.
+func isSyntaxAttr(a xml.Attr) bool {
+	if a.Name.Space == xmlNS || a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" {
+		return true
+	}
+	if a.Name.Space != rdfNS {
+		return a.Name.Space == ""
+	}
+	switch a.Name.Local {
+	case "about", "nodeID", "ID", "resource", "parseType", "datatype":
+		return true
+	}
+	return false
+}
+
+// node adds the triples of the node element e, and returns its subject.
+func (p *rdfxmlParser) node(e *xmlElement, base, lang string) (Term, error) {
+	base, lang = p.scope(e, base, lang)
+	var subject Term
+	if about, ok := e.attr(rdfNS, "about"); ok {
+		subject = iri(resolve(base, about))
+	} else if id, ok := e.attr(rdfNS, "nodeID"); ok {
+		subject = blank("l" + id)
+	} else if id, ok := e.attr(rdfNS, "ID"); ok {
+		subject = iri(resolve(base, "#"+id))
+	} else {
+		subject = p.newBlank()
+	}
+	if !(e.name.Space == rdfNS && e.name.Local == "Description") {
+		p.g.add(subject, iri(rdfNS+"type"), iri(e.name.Space+e.name.Local))
+	}
+	p.propertyAttrs(subject, e, lang)
+	for _, c := range e.children {
+		if err := p.property(subject, c, base, lang); err != nil {
+			return Term{}, err
+		}
+	}
+	return subject, nil
+}
+
+// propertyAttrs adds the triples of the property attributes of e.
+func (p *rdfxmlParser) propertyAttrs(subject Term, e *xmlElement, lang string) {
+	for _, a := range e.attrs {
+		if isSyntaxAttr(a) {
+			continue
+		}
+		if a.Name.Space == rdfNS && a.Name.Local == "type" {
+			p.g.add(subject, iri(rdfNS+"type"), iri(a.Value))
+			continue
+		}
+		p.g.add(subject, iri(a.Name.Space+a.Name.Local), literal(a.Value, lang, ""))
+	}
+}
+
+// property adds the triple of the property element e of the subject.
+func (p *rdfxmlParser) property(subject Term, e *xmlElement, base, lang string) error {
+	base, lang = p.scope(e, base, lang)
+	predicate := iri(e.name.Space + e.name.Local)
+	if r, ok := e.attr(rdfNS, "resource"); ok {
+		object := iri(resolve(base, r))
+		p.g.add(subject, predicate, object)
+		p.propertyAttrs(object, e, lang)
+		return nil
+	}
+	if id, ok := e.attr(rdfNS, "nodeID"); ok {
+		object := blank("l" + id)
+		p.g.add(subject, predicate, object)
+		p.propertyAttrs(object, e, lang)
+		return nil
+	}
+	switch parseType, _ := e.attr(rdfNS, "parseType"); parseType {
+	case "Resource":
+		object := p.newBlank()
+		p.g.add(subject, predicate, object)
+		for _, c := range e.children {
+			if err := p.property(object, c, base, lang); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "Collection":
+		for _, c := range e.children {
+			object, err := p.node(c, base, lang)
+			if err != nil {
+				return err
+			}
+			p.g.add(subject, predicate, object)
+		}
+		return nil
+	}
+	if len(e.children) > 0 {
+		object, err := p.node(e.children[0], base, lang)
+		if err != nil {
+			return err
+		}
+		p.g.add(subject, predicate, object)
+		return nil
+	}
+	for _, a := range e.attrs {
+		if !isSyntaxAttr(a) {
+			// An empty property element with property attributes is
+			// of a blank node with those properties.
+			object := p.newBlank()
+			p.g.add(subject, predicate, object)
+			p.propertyAttrs(object, e, lang)
+			return nil
+		}
+	}
+	datatype, _ := e.attr(rdfNS, "datatype")
+	if datatype != "" {
+		lang = ""
+	}
+	p.g.add(subject, predicate, literal(e.text.String(), lang, datatype))
+	return nil
+}
@@ -0,0 +1,164 @@
+package dcat
+
+import (
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/harvest"
+)
+
+// isSchemaOrg reports whether the IRI is that of schema.org or of its
+// context.
+func isSchemaOrg(v string) bool {
+	v = strings.TrimSuffix(v, "/")
+	return v == "http://schema.org" || v == "https://schema.org"
+}
+
+// normalizeSchemaOrg returns g with the https IRIs of schema.org replaced by
+// http ones.
+func normalizeSchemaOrg(g *Graph) *Graph {
+	normalize := func(t Term) Term {
+		if t.Kind == IRI && strings.HasPrefix(t.Value, "https://schema.org/") {
+			t.Value = schemaNS + strings.TrimPrefix(t.Value, "https://schema.org/")
+		}
+		return t
+	}
+	n := &Graph{}
+	for _, t := range g.Triples {
+		n.add(t.Subject, normalize(t.Predicate), normalize(t.Object))
+	}
+	return n
+}
+
+// SchemaOrgDatasets returns the harvested datasets of the schema.org Dataset
+// resources of g, such as those of the JSON-LD of a web page, whose URL is
+// page. Of the literals in several languages, those in lang are preferred,
+// then those without a language.
+//
+// The name, description, publisher, or else creator or provider, email of its
+// contact point or publisher, dateModified, or else datePublished or
+// dateCreated, keywords, and url, or else the page, of each dataset become its
+// name, description, attribution, contact email, update time, tags, and
+// permalink, its variableMeasured its columns, and its distribution its
+// distributions. The dataset is identified by its identifier, if it is a
+// valid dataset ID, or else by a hash of its IRI (see Datasets).
+func SchemaOrgDatasets(g *Graph, page, lang string) []*harvest.Dataset {
+	g = normalizeSchemaOrg(g)
+	r := &reader{g: g, lang: strings.ToLower(lang)}
+	var datasets []*harvest.Dataset
+	for _, s := range g.Subjects(schemaNS + "Dataset") {
+		datasets = append(datasets, r.schemaOrgDataset(s, page))
+	}
+	return datasets
+}
+
+// pageLink returns the link of the property p of s, resolved against the
+// page, as the URLs are IRIs in the context of schema.org, which is not
+// loaded.
+func (r *reader) pageLink(s Term, p, page string) string {
+	if v := r.link(s, p); v != "" {
+		return resolve(page, v)
+	}
+	return ""
+}
+
+// agent returns the label of the first agent of s of the properties.
+func (r *reader) agent(s Term, properties ...string) string {
+	for _, p := range properties {
+		for _, o := range r.g.Objects(s, schemaNS+p) {
+			if v := r.label(o); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// schemaOrgEmail returns the email address of the contact point, or else of
+// the publisher or creator, of s, or "".
+func (r *reader) schemaOrgEmail(s Term) string {
+	for _, p := range []string{"contactPoint", "publisher", "creator"} {
+		for _, o := range r.g.Objects(s, schemaNS+p) {
+			if v := r.text(o, schemaNS+"email"); v != "" {
+				return strings.TrimPrefix(v, "mailto:")
+			}
+			for _, c := range r.g.Objects(o, schemaNS+"contactPoint") {
+				if v := r.text(c, schemaNS+"email"); v != "" {
+					return strings.TrimPrefix(v, "mailto:")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// identifier returns the identifier of s, a literal or the value of a
+// PropertyValue, or "".
+func (r *reader) identifier(s Term) string {
+	for _, o := range r.g.Objects(s, schemaNS+"identifier") {
+		if o.Kind == Literal {
+			return strings.TrimSpace(o.Value)
+		}
+		if v := r.text(o, schemaNS+"value"); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// keywords returns the keywords of s, which may be comma-separated literals or
+// DefinedTerms.
+func (r *reader) keywords(s Term) []string {
+	var keywords []string
+	for _, o := range r.g.Objects(s, schemaNS+"keywords") {
+		if o.Kind != Literal {
+			keywords = append(keywords, r.label(o))
+			continue
+		}
+		if o.Lang != "" && o.Lang != r.lang && !strings.HasPrefix(o.Lang, r.lang+"-") {
+			continue
+		}
+		for _, k := range strings.Split(o.Value, ",") {
+			keywords = append(keywords, strings.TrimSpace(k))
+		}
+	}
+	return harvest.Unique(keywords)
+}
+
+func (r *reader) schemaOrgDataset(s Term, page string) *harvest.Dataset {
+	m := &database.Metadata{
+		Name:         r.text(s, schemaNS+"name"),
+		Description:  r.text(s, schemaNS+"description"),
+		Attribution:  r.agent(s, "publisher", "creator", "provider", "sourceOrganization"),
+		ContactEmail: r.schemaOrgEmail(s),
+		Tags:         r.keywords(s),
+		Permalink:    r.pageLink(s, schemaNS+"url", page),
+	}
+	for _, p := range []string{"dateModified", "datePublished", "dateCreated"} {
+		if m.UpdatedAt = r.text(s, schemaNS+p); m.UpdatedAt != "" {
+			break
+		}
+	}
+	if m.Permalink == "" {
+		m.Permalink = page
+	}
+	m.DatasetID = datasetID(s, r.identifier(s), m)
+
+	d := &harvest.Dataset{Metadata: m}
+	for _, o := range r.g.Objects(s, schemaNS+"variableMeasured") {
+		c := &harvest.Column{Name: r.label(o), Description: r.text(o, schemaNS+"description")}
+		if c.Name == "" {
+			continue
+		}
+		d.Columns = append(d.Columns, c)
+	}
+	for _, o := range r.g.Objects(s, schemaNS+"distribution") {
+		d.Distributions = append(d.Distributions, &harvest.Distribution{
+			Name:        r.text(o, schemaNS+"name"),
+			URL:         r.pageLink(o, schemaNS+"contentUrl", page),
+			Format:      r.text(o, schemaNS+"encodingFormat"),
+			Description: r.text(o, schemaNS+"description"),
+		})
+	}
+	return d
+}
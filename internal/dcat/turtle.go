@@ -0,0 +1,466 @@
+package dcat
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// turtleParser parses Turtle documents by recursive descent.
+type turtleParser struct {
+	s        string
+	pos      int
+	base     string
+	prefixes map[string]string
+	blanks   int
+	g        *Graph
+}
+
+// ParseTurtle parses the Turtle document read from r. Relative IRIs are
+// resolved against base, if not empty.
+func ParseTurtle(r io.Reader, base string) (*Graph, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &turtleParser{s: string(data), base: base, prefixes: make(map[string]string), g: &Graph{}}
+	for {
+		p.space()
+		if p.pos == len(p.s) {
+			return p.g, nil
+		}
+		if err := p.statement(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *turtleParser) errorf(format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.s[:p.pos], "\n")
+	return fmt.Errorf("turtle: line %d: %v", line, fmt.Sprintf(format, args...))
+}
+
+// space skips white space and comments.
+func (p *turtleParser) space() {
+	for p.pos < len(p.s) {
+		switch c := p.s[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next byte, or 0 at the end of the document.
+func (p *turtleParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *turtleParser) expect(c byte) error {
+	p.space()
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+// keyword reports whether the document continues with the keyword, in any
+// case, and skips it if so.
+func (p *turtleParser) keyword(k string) bool {
+	end := p.pos + len(k)
+	if end > len(p.s) || !strings.EqualFold(p.s[p.pos:end], k) {
+		return false
+	}
+	if end < len(p.s) && isNameChar(p.s[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *turtleParser) statement() error {
+	// The SPARQL forms of the directives, without @, end without a period.
+	sparql := p.peek() != '@'
+	switch {
+	case p.keyword("@prefix"), p.keyword("prefix"):
+		p.space()
+		start := p.pos
+		for p.pos < len(p.s) && p.s[p.pos] != ':' {
+			p.pos++
+		}
+		prefix := strings.TrimSpace(p.s[start:p.pos])
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		p.space()
+		ns, err := p.iriRef()
+		if err != nil {
+			return err
+		}
+		p.prefixes[prefix] = ns
+		if !sparql {
+			return p.expect('.')
+		}
+		return nil
+	case p.keyword("@base"), p.keyword("base"):
+		p.space()
+		base, err := p.iriRef()
+		if err != nil {
+			return err
+		}
+		p.base = base
+		if !sparql {
+			return p.expect('.')
+		}
+		return nil
+	}
+	subject, err := p.subject()
+	if err != nil {
+		return err
+	}
+	p.space()
+	// A blank node property list may be a statement of its own.
+	if subject.Kind != Blank || p.peek() != '.' {
+		if err := p.predicateObjects(subject); err != nil {
+			return err
+		}
+	}
+	return p.expect('.')
+}
+
+func (p *turtleParser) subject() (Term, error) {
+	p.space()
+	switch p.peek() {
+	case '[':
+		return p.blankNodePropertyList()
+	case '(':
+		return p.collection()
+	}
+	return p.resource()
+}
+
+func (p *turtleParser) newBlank() Term {
+	p.blanks++
+	return blank(fmt.Sprintf("b%d", p.blanks))
+}
+
+func (p *turtleParser) predicateObjects(subject Term) error {
+	for {
+		p.space()
+		var predicate Term
+		if p.peek() == 'a' && p.pos+1 < len(p.s) && !isNameChar(p.s[p.pos+1]) && p.s[p.pos+1] != ':' {
+			p.pos++
+			predicate = iri(rdfNS + "type")
+		} else {
+			var err error
+			if predicate, err = p.resource(); err != nil {
+				return err
+			}
+		}
+		for {
+			object, err := p.object()
+			if err != nil {
+				return err
+			}
+			p.g.add(subject, predicate, object)
+			p.space()
+			if p.peek() != ',' {
+				break
+			}
+			p.pos++
+		}
+		if p.peek() != ';' {
+			return nil
+		}
+		for p.peek() == ';' {
+			p.pos++
+			p.space()
+		}
+		if c := p.peek(); c == '.' || c == ']' || c == 0 {
+			return nil
+		}
+	}
+}
+
+func (p *turtleParser) object() (Term, error) {
+	p.space()
+	switch c := p.peek(); {
+	case c == '[':
+		return p.blankNodePropertyList()
+	case c == '(':
+		return p.collection()
+	case c == '"' || c == '\'':
+		return p.literal()
+	case c == '+' || c == '-' || c == '.' || c >= '0' && c <= '9':
+		return p.number()
+	case p.keyword("true"):
+		return literal("true", "", xsdNS+"boolean"), nil
+	case p.keyword("false"):
+		return literal("false", "", xsdNS+"boolean"), nil
+	}
+	return p.resource()
+}
+
+func (p *turtleParser) blankNodePropertyList() (Term, error) {
+	p.pos++
+	b := p.newBlank()
+	p.space()
+	if p.peek() != ']' {
+		if err := p.predicateObjects(b); err != nil {
+			return Term{}, err
+		}
+	}
+	return b, p.expect(']')
+}
+
+// collection parses a collection into its first and rest nodes.
+func (p *turtleParser) collection() (Term, error) {
+	p.pos++
+	head := iri(rdfNS + "nil")
+	var last Term
+	for {
+		p.space()
+		if p.peek() == ')' {
+			p.pos++
+			if last != (Term{}) {
+				p.g.add(last, iri(rdfNS+"rest"), iri(rdfNS+"nil"))
+			}
+			return head, nil
+		}
+		if p.pos == len(p.s) {
+			return Term{}, p.errorf("unterminated collection")
+		}
+		item, err := p.object()
+		if err != nil {
+			return Term{}, err
+		}
+		node := p.newBlank()
+		if last == (Term{}) {
+			head = node
+		} else {
+			p.g.add(last, iri(rdfNS+"rest"), node)
+		}
+		p.g.add(node, iri(rdfNS+"first"), item)
+		last = node
+	}
+}
+
+// resource parses an IRI, a prefixed name, or a blank node label.
+func (p *turtleParser) resource() (Term, error) {
+	p.space()
+	if p.peek() == '<' {
+		v, err := p.iriRef()
+		return iri(v), err
+	}
+	start := p.pos
+	for p.pos < len(p.s) && (isNameChar(p.s[p.pos]) || p.s[p.pos] == ':' || p.s[p.pos] == '%' || p.s[p.pos] == '\\' ||
+		p.s[p.pos] == '.' && p.pos+1 < len(p.s) && isNameChar(p.s[p.pos+1])) {
+		if p.s[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	name := p.s[start:p.pos]
+	i := strings.IndexByte(name, ':')
+	if i < 0 {
+		return Term{}, p.errorf("expected an IRI, a prefixed name, or a blank node, not %q", name)
+	}
+	if name[:i] == "_" {
+		return blank("l" + name[i+1:]), nil
+	}
+	ns, ok := p.prefixes[name[:i]]
+	if !ok {
+		return Term{}, p.errorf("undefined prefix %q", name[:i])
+	}
+	return iri(ns + strings.Replace(name[i+1:], "\\", "", -1)), nil
+}
+
+// iriRef parses an IRI in angle brackets, resolving it against the base.
+func (p *turtleParser) iriRef() (string, error) {
+	if p.peek() != '<' {
+		return "", p.errorf("expected an IRI")
+	}
+	end := strings.IndexByte(p.s[p.pos:], '>')
+	if end < 0 {
+		return "", p.errorf("unterminated IRI")
+	}
+	v := p.s[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+	if strings.Contains(v, "\\u") || strings.Contains(v, "\\U") {
+		var err error
+		if v, err = unescape(v); err != nil {
+			return "", p.errorf("%v", err)
+		}
+	}
+	return resolve(p.base, v), nil
+}
+
+// resolve resolves the IRI ref against base, if both are valid URLs.
+func resolve(base, ref string) string {
+	if base == "" {
+		return ref
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+func (p *turtleParser) literal() (Term, error) {
+	q := p.s[p.pos]
+	long := strings.HasPrefix(p.s[p.pos:], strings.Repeat(string(q), 3))
+	if long {
+		p.pos += 3
+	} else {
+		p.pos++
+	}
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.s) {
+			return Term{}, p.errorf("unterminated string")
+		}
+		c := p.s[p.pos]
+		if c == q && (!long || strings.HasPrefix(p.s[p.pos:], strings.Repeat(string(q), 3))) {
+			if long {
+				p.pos += 3
+			} else {
+				p.pos++
+			}
+			break
+		}
+		if !long && (c == '\n' || c == '\r') {
+			return Term{}, p.errorf("newline in string")
+		}
+		if c == '\\' && p.pos+1 < len(p.s) {
+			n := 2
+			if e := p.s[p.pos+1]; e == 'u' {
+				n = 6
+			} else if e == 'U' {
+				n = 10
+			}
+			if p.pos+n > len(p.s) {
+				return Term{}, p.errorf("invalid escape")
+			}
+			v, err := unescape(p.s[p.pos : p.pos+n])
+			if err != nil {
+				return Term{}, p.errorf("%v", err)
+			}
+			b.WriteString(v)
+			p.pos += n
+			continue
+		}
+		b.WriteByte(c)
+		p.pos++
+	}
+	var lang, datatype string
+	if p.peek() == '@' {
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.s) && (isNameChar(p.s[p.pos]) || p.s[p.pos] == '-') {
+			p.pos++
+		}
+		lang = p.s[start:p.pos]
+	} else if strings.HasPrefix(p.s[p.pos:], "^^") {
+		p.pos += 2
+		t, err := p.resource()
+		if err != nil {
+			return Term{}, err
+		}
+		datatype = t.Value
+	}
+	return literal(b.String(), lang, datatype), nil
+}
+
+func (p *turtleParser) number() (Term, error) {
+	start := p.pos
+	datatype := xsdNS + "integer"
+	if c := p.peek(); c == '+' || c == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && p.pos+1 < len(p.s) && p.s[p.pos+1] >= '0' && p.s[p.pos+1] <= '9':
+			datatype = xsdNS + "decimal"
+		case c == 'e' || c == 'E':
+			datatype = xsdNS + "double"
+			if p.pos+1 < len(p.s) && (p.s[p.pos+1] == '+' || p.s[p.pos+1] == '-') {
+				p.pos++
+			}
+		default:
+			if p.pos == start {
+				return Term{}, p.errorf("expected a number")
+			}
+			return literal(p.s[start:p.pos], "", datatype), nil
+		}
+		p.pos++
+	}
+	return literal(p.s[start:p.pos], "", datatype), nil
+}
+
+// unescape unescapes the string and numeric escapes of s.
+func unescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u', 'U':
+			n := 4
+			if s[i] == 'U' {
+				n = 8
+			}
+			if i+1+n > len(s) {
+				return "", fmt.Errorf("invalid escape %q", s[i-1:])
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", fmt.Errorf("invalid escape %q", s[i-1:i+1+n])
+			}
+			b.WriteRune(rune(r))
+			i += n
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// isNameChar reports whether c may be part of a prefixed name. The bytes of
+// non-ASCII characters are.
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c >= 0x80
+}
@@ -0,0 +1,281 @@
+// Package download downloads the files of the datasets, such as the
+// distributions listed by their catalogs, to a cache of the files named by
+// the SHA-256 hashes of their contents. A download interrupted by a failure
+// is resumed from where it stopped, with a range request, if the file did not
+// change since, and the files downloaded before are only downloaded again if
+// they changed, with conditional requests (see package crawl).
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/crawl"
+)
+
+// Number of times an interrupted download is resumed by Fetch before it fails
+const maxResumes = 3
+
+// errInterrupted is the error of the downloads interrupted while their
+// contents were read, which may be resumed.
+var errInterrupted = errors.New("download interrupted")
+
+// File is a file downloaded to the cache.
+type File struct {
+	URL string
+	// SHA-256 hash of the contents of the file, in hex, and their size.
+	Hash string
+	Size int64
+	// Validators of the last response of the file.
+	crawl.Validators
+}
+
+// Cache is a directory of downloaded files, named by the hashes of their
+// contents, with the partial downloads in its partial subdirectory.
+type Cache struct {
+	Dir string
+	// Client of the requests.
+	Client *crawl.Client
+	// Maximum size of the files downloaded, or 0 for no limit.
+	MaxSize int64
+}
+
+// Path returns the path of the file of the hash in the cache.
+func (c *Cache) Path(hash string) string {
+	return filepath.Join(c.Dir, hash[:2], hash)
+}
+
+// partial is the state of a partial download, saved next to its contents.
+type partial struct {
+	URL string `json:"url"`
+	crawl.Validators
+}
+
+// partialPath returns the path of the partial download of the file at u.
+func (c *Cache) partialPath(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(c.Dir, "partial", hex.EncodeToString(sum[:16]))
+}
+
+// Fetch downloads the file at u to the cache and returns it. If the previous
+// download of the file, prev, is not nil, is still in the cache, and the file
+// did not change since, as its server reports, prev is returned instead. A
+// download that is interrupted is resumed, up to 3 times, and is otherwise
+// left partial, for the next Fetch to resume.
+func (c *Cache) Fetch(u string, prev *File) (*File, error) {
+	for resumes := 0; ; resumes++ {
+		f, err := c.fetch(u, prev, true)
+		if err == nil || !errors.Is(err, errInterrupted) || resumes == maxResumes {
+			return f, err
+		}
+		log.Printf("%v; resuming", err)
+	}
+}
+
+// fetch makes a request of the download of the file at u, resuming its
+// partial download if resume is set.
+func (c *Cache) fetch(u string, prev *File, resume bool) (*File, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil && prev.URL == u {
+		if _, err := os.Stat(c.Path(prev.Hash)); err == nil {
+			if prev.ETag != "" {
+				req.Header.Set("If-None-Match", prev.ETag)
+			}
+			if prev.LastModified != "" {
+				req.Header.Set("If-Modified-Since", prev.LastModified)
+			}
+		}
+	}
+	path := c.partialPath(u)
+	var offset int64
+	var part partial
+	if resume {
+		offset, part = c.readPartial(path, u)
+	}
+	if offset > 0 {
+		// The partial file is only resumed if the file is still the one
+		// of its validators, a weak entity tag not being enough.
+		ifRange := part.LastModified
+		if part.ETag != "" && !strings.HasPrefix(part.ETag, "W/") {
+			ifRange = part.ETag
+		}
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		req.Header.Set("If-Range", ifRange)
+	}
+	res, err := c.Client.Do(req)
+	if errors.Is(err, crawl.ErrNotModified) && prev != nil {
+		return prev, nil
+	}
+	var urlErr *url.Error
+	if err != nil && offset > 0 && !errors.As(err, &urlErr) {
+		// A partial download that cannot be resumed, such as one whose
+		// range the server no longer satisfies, is started over.
+		log.Printf("%v; downloading %v again", err, u)
+		c.removePartial(path)
+		return c.fetch(u, prev, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusPartialContent {
+		if start, ok := rangeStart(res.Header.Get("Content-Range")); !ok || start != offset {
+			c.removePartial(path)
+			return nil, fmt.Errorf("GET %v: range of the response %q is not that requested", u, res.Header.Get("Content-Range"))
+		}
+		if res.ContentLength >= 0 {
+			res.ContentLength += offset
+		}
+	} else {
+		offset = 0
+		part = partial{URL: u, Validators: crawl.Validators{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}}
+		if err := c.writePartial(path, &part); err != nil {
+			return nil, err
+		}
+	}
+	if c.MaxSize > 0 && res.ContentLength > c.MaxSize {
+		c.removePartial(path)
+		return nil, fmt.Errorf("GET %v: file of %d bytes is larger than %d bytes", u, res.ContentLength, c.MaxSize)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	out, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader = res.Body
+	if c.MaxSize > 0 {
+		body = io.LimitReader(res.Body, c.MaxSize-offset+1)
+	}
+	n, err := io.Copy(out, body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GET %v: %w after %d bytes: %v", u, errInterrupted, offset+n, err)
+	}
+	if c.MaxSize > 0 && offset+n > c.MaxSize {
+		c.removePartial(path)
+		return nil, fmt.Errorf("GET %v: file is larger than %d bytes", u, c.MaxSize)
+	}
+	return c.store(path, &File{URL: u, Validators: part.Validators})
+}
+
+// rangeStart returns the first byte of the Content-Range header of a partial
+// response, bytes <first>-<last>/<size>.
+func rangeStart(contentRange string) (int64, bool) {
+	v := strings.TrimPrefix(contentRange, "bytes ")
+	i := strings.IndexByte(v, '-')
+	if i < 0 || len(v) == len(contentRange) {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(v[:i], 10, 64)
+	return start, err == nil
+}
+
+// store moves the completed download at path to the cache as f, setting its
+// hash and size.
+func (c *Cache) store(path string, f *File) (*File, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	f.Size, err = io.Copy(h, in)
+	in.Close()
+	if err != nil {
+		return nil, err
+	}
+	f.Hash = hex.EncodeToString(h.Sum(nil))
+	dest := c.Path(f.Hash)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return nil, err
+	}
+	os.Remove(path + ".json")
+	return f, nil
+}
+
+// readPartial returns the size and the state of the partial download of the
+// file at u at path, or 0 if there is none that may be resumed.
+func (c *Cache) readPartial(path, u string) (int64, partial) {
+	var part partial
+	data, err := ioutil.ReadFile(path + ".json")
+	if err != nil || json.Unmarshal(data, &part) != nil || part.URL != u ||
+		part.ETag == "" && part.LastModified == "" {
+		return 0, part
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, part
+	}
+	return info.Size(), part
+}
+
+// writePartial saves the state of the partial download at path.
+func (c *Cache) writePartial(path string, part *partial) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(part)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".json", data, 0644)
+}
+
+// removePartial removes the partial download at path.
+func (c *Cache) removePartial(path string) {
+	os.Remove(path)
+	os.Remove(path + ".json")
+}
+
+// Clean removes the files of the cache whose hashes are not kept.
+func (c *Cache) Clean(keep map[string]bool) error {
+	dirs, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || len(d.Name()) != 2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(c.Dir, d.Name()))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if keep[f.Name()] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(c.Dir, d.Name(), f.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package download
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/crawl"
+)
+
+func TestFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents := []byte(strings.Repeat("a,b,c\n1,2,3\n", 1000))
+	modified := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	var ranges []string
+	interrupt := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		if interrupt {
+			// The first response is cut after half of the file.
+			interrupt = false
+			w.Header().Set("Content-Length", "12000")
+			w.Write(contents[:6000])
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "rows.csv", modified, bytes.NewReader(contents))
+	}))
+	defer srv.Close()
+
+	c := &Cache{Dir: dir, Client: &crawl.Client{FirstRetry: time.Millisecond}}
+	f, err := c.Fetch(srv.URL+"/rows.csv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=6000-" {
+		t.Errorf("got requests of ranges %q, want the whole file and then bytes=6000-", ranges)
+	}
+	got, err := ioutil.ReadFile(c.Path(f.Hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, contents) || f.Size != int64(len(contents)) || f.ETag != `"v1"` {
+		t.Errorf("got file of %d bytes with ETag %v, want the %d bytes of the file and ETag \"v1\"", f.Size, f.ETag, len(contents))
+	}
+	if partials, _ := filepath.Glob(filepath.Join(dir, "partial", "*")); len(partials) != 0 {
+		t.Errorf("partial downloads %q left in the cache", partials)
+	}
+
+	// The file is not downloaded again while it is not modified.
+	ranges = nil
+	again, err := c.Fetch(srv.URL+"/rows.csv", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != f || len(ranges) != 1 {
+		t.Errorf("got %+v after %d requests, want the previous download after 1", again, len(ranges))
+	}
+
+	c.MaxSize = 1000
+	if _, err := c.Fetch(srv.URL+"/other.csv", nil); err == nil {
+		t.Error("file larger than the maximum size downloaded")
+	}
+
+	if err := c.Clean(map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.Path(f.Hash)); !os.IsNotExist(err) {
+		t.Errorf("file of the cache not removed by Clean (%v)", err)
+	}
+}
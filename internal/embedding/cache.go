@@ -0,0 +1,337 @@
+package embedding
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Number of new embeddings written to the cache database per transaction.
+const cacheFlushSize = 1024
+
+// CacheConfig configures the embedding cache.
+type CacheConfig struct {
+	// Maximum number of embeddings kept in memory.
+	Size int
+	// Path of the SQLite database that embeddings are persisted in across
+	// runs. If empty, embeddings are only cached in memory.
+	Path string
+}
+
+// CacheStats counts the lookups of an embedding cache.
+type CacheStats struct {
+	// Lookups found in memory, found in the database, and embedded by the
+	// model.
+	MemoryHits int64
+	DiskHits   int64
+	Misses     int64
+	// Number of embeddings in memory.
+	Entries int
+}
+
+// HitRate returns the fraction of lookups that were cache hits.
+func (s *CacheStats) HitRate() float64 {
+	hits := s.MemoryHits + s.DiskHits
+	if hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+s.Misses)
+}
+
+func (s *CacheStats) String() string {
+	return fmt.Sprintf("%d memory hits, %d disk hits, %d misses (hit rate %.1f%%)",
+		s.MemoryHits, s.DiskHits, s.Misses, 100*s.HitRate())
+}
+
+// Cache is a Model that caches the embeddings of another model, keyed by the
+// name of the model and the text. The most recently used embeddings are kept in
+// memory, and all embeddings are persisted in a SQLite database if configured,
+// so that repeated words and attribute names are embedded once across runs.
+//
+// The database is separate from the Open Data Link database, so that the
+// commands can write to it while they hold a write transaction on the Open Data
+// Link database. It is safe for concurrent use.
+type Cache struct {
+	model Model
+	name  string
+	size  int
+	db    *sql.DB
+
+	mu sync.Mutex
+	// Most recently used entry first.
+	lru     *list.List
+	entries map[string]*list.Element
+	// Entries not yet written to the database.
+	pending []*cacheEntry
+	stats   CacheStats
+	// Serializes writes to the database.
+	flushMu sync.Mutex
+}
+
+type cacheEntry struct {
+	text string
+	// nil if the model has no embedding for the text.
+	vec []float32
+}
+
+// NewCache returns a Cache of the embeddings of model, which it takes
+// ownership of, named name (see Version.CacheName).
+func NewCache(model Model, name string, cfg *CacheConfig) (*Cache, error) {
+	if cfg.Size < 0 {
+		return nil, fmt.Errorf("embedding cache: invalid size %d", cfg.Size)
+	}
+	c := &Cache{
+		model:   model,
+		name:    name,
+		size:    cfg.Size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if cfg.Path == "" {
+		return c, nil
+	}
+	db, err := sql.Open("sqlite3", cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS embedding_cache (
+		model TEXT NOT NULL,
+		text TEXT NOT NULL,
+		-- NULL if the model has no embedding for the text.
+		emb BLOB,
+		PRIMARY KEY (model, text)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("embedding cache: %v", err)
+	}
+	c.db = db
+	return c, nil
+}
+
+// Dim returns the dimension of the model's embedding vectors.
+func (c *Cache) Dim() int {
+	return c.model.Dim()
+}
+
+// Embed returns the embedding vector of the given text, embedding it with the
+// model if it is not cached.
+func (c *Cache) Embed(text string) ([]float32, error) {
+	if vec, ok, err := c.lookup(text); err != nil || ok {
+		return vec, err
+	}
+	vec, err := c.model.Embed(text)
+	if err != nil && err != ErrNoEmb {
+		return nil, err
+	}
+	c.add(text, vec, err)
+	return vec, err
+}
+
+// EmbedBatch returns the results of Embed for each of the texts, embedding the
+// texts that are not cached with a single call to EmbedAll.
+func (c *Cache) EmbedBatch(texts []string) ([][]float32, []error, error) {
+	vecs := make([][]float32, len(texts))
+	errs := make([]error, len(texts))
+	var todo []int
+	var misses []string
+	for i, text := range texts {
+		vec, ok, err := c.lookup(text)
+		if ok {
+			vecs[i], errs[i] = vec, err
+			continue
+		}
+		todo = append(todo, i)
+		misses = append(misses, text)
+	}
+	if len(misses) == 0 {
+		return vecs, errs, nil
+	}
+	res, resErrs, err := EmbedAll(c.model, misses)
+	if err != nil {
+		return nil, nil, err
+	}
+	for j, i := range todo {
+		vecs[i], errs[i] = res[j], resErrs[j]
+		c.add(texts[i], res[j], resErrs[j])
+	}
+	return vecs, errs, nil
+}
+
+// lookup returns a copy of the cached embedding of text and ErrNoEmb if the
+// model has no embedding for it, and whether it is cached.
+func (c *Cache) lookup(text string) ([]float32, bool, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[text]; ok {
+		c.lru.MoveToFront(el)
+		c.stats.MemoryHits++
+		vec := el.Value.(*cacheEntry).vec
+		c.mu.Unlock()
+		return c.result(vec)
+	}
+	c.mu.Unlock()
+
+	if c.db == nil {
+		c.miss()
+		return nil, false, nil
+	}
+	var data []byte
+	err := c.db.QueryRow(`
+	SELECT emb FROM embedding_cache WHERE model = ? AND text = ?`,
+		c.name, text).Scan(&data)
+	if err == sql.ErrNoRows {
+		c.miss()
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("embedding cache: %v", err)
+	}
+	var vec []float32
+	if data != nil {
+		if vec, err = vec32.FromBytes(data); err != nil || len(vec) != c.Dim() {
+			// Written by a model of another dimension under the same
+			// name: embed the text again.
+			c.miss()
+			return nil, false, nil
+		}
+	}
+	c.mu.Lock()
+	c.stats.DiskHits++
+	c.remember(&cacheEntry{text, vec})
+	c.mu.Unlock()
+	return c.result(vec)
+}
+
+func (c *Cache) miss() {
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+}
+
+// result returns the result of Embed for the cached vector vec.
+func (c *Cache) result(vec []float32) ([]float32, bool, error) {
+	if vec == nil {
+		return make([]float32, c.Dim()), true, ErrNoEmb
+	}
+	return append([]float32(nil), vec...), true, nil
+}
+
+// add caches the result of the model's Embed, writing the pending entries to
+// the database once there are cacheFlushSize of them.
+func (c *Cache) add(text string, vec []float32, err error) {
+	e := &cacheEntry{text: text}
+	if err == nil {
+		e.vec = append([]float32(nil), vec...)
+	}
+	c.mu.Lock()
+	c.remember(e)
+	var batch []*cacheEntry
+	if c.db != nil {
+		c.pending = append(c.pending, e)
+		if len(c.pending) >= cacheFlushSize {
+			batch, c.pending = c.pending, nil
+		}
+	}
+	c.mu.Unlock()
+
+	// Caching is best-effort: the entries are embedded again in later runs
+	// if they cannot be written.
+	c.flush(batch)
+}
+
+// remember adds e to the in-memory cache, evicting the least recently used
+// entry if the cache is full. c.mu must be held.
+func (c *Cache) remember(e *cacheEntry) {
+	if c.size == 0 {
+		return
+	}
+	if el, ok := c.entries[e.text]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	if c.lru.Len() >= c.size {
+		last := c.lru.Back()
+		delete(c.entries, last.Value.(*cacheEntry).text)
+		c.lru.Remove(last)
+	}
+	c.entries[e.text] = c.lru.PushFront(e)
+}
+
+// flush writes the entries to the database in a single transaction.
+func (c *Cache) flush(entries []*cacheEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`
+	INSERT OR REPLACE INTO embedding_cache (model, text, emb) VALUES (?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		var data []byte
+		if e.vec != nil {
+			data = vec32.Bytes(e.vec)
+		}
+		if _, err := stmt.Exec(c.name, e.text, data); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Stats returns the lookup counts of the cache.
+func (c *Cache) Stats() *CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.lru.Len()
+	return &s
+}
+
+// Close writes the pending entries to the database and closes it and the
+// model.
+func (c *Cache) Close() error {
+	err := c.model.Close()
+	if c.db != nil {
+		c.mu.Lock()
+		batch := c.pending
+		c.pending = nil
+		c.mu.Unlock()
+		if ferr := c.flush(batch); ferr != nil && err == nil {
+			err = fmt.Errorf("embedding cache: %v", ferr)
+		}
+		if cerr := c.db.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// CacheStatsOf returns the stats of e if it is a Cache (possibly normalizing
+// the texts it embeds), or nil.
+func CacheStatsOf(e Embedder) *CacheStats {
+	switch e := e.(type) {
+	case *Cache:
+		return e.Stats()
+	case *normalizing:
+		return CacheStatsOf(e.Model)
+	}
+	return nil
+}
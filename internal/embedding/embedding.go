@@ -0,0 +1,369 @@
+// Package embedding defines the interface of the text embedding models used to
+// create the metadata and attribute embedding vectors, and provides the
+// fastText, ONNX sentence-transformer, and remote embedding API
+// implementations and a persistent embedding cache.
+package embedding
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	"github.com/ekzhu/go-fasttext"
+)
+
+// ErrNoEmb is returned by Embed when the model has no embedding for the text.
+var ErrNoEmb = errors.New("no embeddings found for input words")
+
+// Embedder is a text embedding model.
+type Embedder interface {
+	// Embed returns the unit embedding vector of the given text, of
+	// dimension Dim().
+	//
+	// Returns a zero vector and ErrNoEmb if the model has no embedding for
+	// the text.
+	Embed(text string) ([]float32, error)
+	// Dim returns the dimension of the embedding vectors.
+	Dim() int
+}
+
+// Model is an Embedder whose resources must be freed with Close.
+type Model interface {
+	Embedder
+	io.Closer
+}
+
+// Config selects and configures the embedding model.
+type Config struct {
+	// Name of the backend: "fasttext" (the default if empty), "onnx", or
+	// "remote".
+	Backend string
+	// Path of the fastText database, for the fasttext backend.
+	FasttextPath string
+	// Weighting of the words averaged by the fasttext backend: "" (the
+	// default) weights them equally, "idf" by their inverse document
+	// frequency in the metadata of the corpus, and "sif" by their smooth
+	// inverse frequency, removing the common component of the corpus from
+	// the embeddings.
+	Weighting string
+	// Document frequencies of the idf and sif weightings. If nil, they (and
+	// Component) are loaded from the Open Data Link database at
+	// DatabasePath.
+	IDF *IDF
+	// Common component removed with the sif weighting, or nil to remove none.
+	Component []float32
+	// Path of the Open Data Link database.
+	DatabasePath string
+	// Configuration of the onnx backend.
+	ONNX ONNXConfig
+	// Configuration of the remote backend.
+	Remote RemoteConfig
+	// Configuration of the embedding cache. The embeddings are not cached if
+	// both the size and path are zero.
+	Cache CacheConfig
+	// Normalization of the texts before they are embedded.
+	Normalize NormalizeConfig
+	// Version of the model, recorded with the vectors it creates (see
+	// ModelVersion). Changing it marks the vectors of earlier versions as
+	// stale, e.g. after retraining a model in place.
+	Version string
+}
+
+// ONNXConfig configures the ONNX sentence-transformer backend.
+type ONNXConfig struct {
+	// Path of the ONNX model, which must take int64 input_ids,
+	// attention_mask, and (optionally) token_type_ids inputs of shape
+	// [batch, tokens] and produce token embeddings of shape
+	// [batch, tokens, dim] or a sentence embedding of shape [batch, dim] as
+	// its first output.
+	ModelPath string
+	// Path of the model's WordPiece vocabulary (vocab.txt).
+	VocabPath string
+	// Path of the ONNX Runtime shared library. If empty,
+	// "libonnxruntime.so" is loaded from the library search path.
+	LibraryPath string
+	// Maximum number of tokens per text, including [CLS] and [SEP]. Longer
+	// texts are truncated.
+	MaxTokens int
+}
+
+// Open opens the embedding model selected by cfg, wrapped in a Cache if
+// configured. The texts are normalized as configured before they are embedded
+// (and cached).
+func Open(cfg *Config) (Model, error) {
+	var model Model
+	var err error
+	var normalizer *Normalizer
+	if cfg.Normalize.enabled() {
+		if normalizer, err = NewNormalizer(&cfg.Normalize); err != nil {
+			return nil, err
+		}
+	}
+	if cfg, err = cfg.Resolve(); err != nil {
+		return nil, err
+	}
+	switch cfg.Backend {
+	case "", "fasttext":
+		ft := fasttext.NewFastText(cfg.FasttextPath)
+		var e *FastText
+		switch cfg.Weighting {
+		case "idf":
+			e = NewWeightedFastText(ft, cfg.IDF)
+		case "sif":
+			if cfg.Component != nil && len(cfg.Component) != fasttext.Dim {
+				ft.Close()
+				return nil, fmt.Errorf("SIF common component has dimension %d, want %d", len(cfg.Component), fasttext.Dim)
+			}
+			e = NewSIFFastText(ft, cfg.IDF, cfg.Component)
+		default:
+			e = NewFastText(ft)
+		}
+		if cfg.Normalize.Stopwords != "" {
+			// The normalizer removes the configured stop words instead.
+			e.stopwords = nil
+		}
+		model = e
+	case "onnx":
+		model, err = NewONNX(&cfg.ONNX)
+	case "remote":
+		model, err = NewRemote(&cfg.Remote)
+	default:
+		return nil, fmt.Errorf("unknown embedding backend %q (want fasttext, onnx, or remote)", cfg.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Cache.Size != 0 || cfg.Cache.Path != "" {
+		c, err := NewCache(model, cfg.ModelVersion(model.Dim()).CacheName(), &cfg.Cache)
+		if err != nil {
+			model.Close()
+			return nil, err
+		}
+		model = c
+	}
+	if normalizer != nil {
+		model = &normalizing{model, normalizer}
+	}
+	return model, nil
+}
+
+// Resolve returns cfg with the document frequencies and common component of
+// its weighting loaded from the Open Data Link database if IDF is nil, or cfg
+// itself if there is nothing to load.
+func (cfg *Config) Resolve() (*Config, error) {
+	switch cfg.Weighting {
+	case "":
+	case "idf", "sif":
+		if cfg.Backend != "" && cfg.Backend != "fasttext" {
+			return nil, fmt.Errorf("embedding backend %s does not support %s weighting", cfg.Backend, cfg.Weighting)
+		}
+		if cfg.IDF == nil {
+			var err error
+			loaded := *cfg
+			if loaded.IDF, err = LoadIDF(cfg.DatabasePath); err != nil {
+				return nil, err
+			}
+			if cfg.Weighting == "sif" {
+				if loaded.Component, err = LoadComponent(cfg.DatabasePath); err != nil {
+					return nil, err
+				}
+			}
+			return &loaded, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown embedding weighting %q (want idf or sif)", cfg.Weighting)
+	}
+	return cfg, nil
+}
+
+// Version identifies the model that created a stored vector, so that vectors
+// created by other models can be found and embedded again.
+type Version struct {
+	// Name of the model (see Config.ModelName).
+	Model string
+	// Version of the model (see Config.Version).
+	Version string
+	// Dimension of the vectors.
+	Dim int
+}
+
+// CacheName returns the name of the model of v, its version, and the
+// dimension of its vectors, which identifies its embeddings in the cache
+// database, so that changing any of them does not reuse the cached
+// embeddings.
+func (v Version) CacheName() string {
+	return fmt.Sprintf("%s@%s:%d", v.Model, v.Version, v.Dim)
+}
+
+// ModelVersion returns the Version of the vectors of dimension dim created by
+// the model selected by cfg, which should be resolved (see Resolve) so that
+// the name covers the document frequencies of its weighting.
+func (cfg *Config) ModelVersion(dim int) Version {
+	return Version{Model: cfg.ModelName(), Version: cfg.Version, Dim: dim}
+}
+
+// ModelName returns the name of the model selected by cfg, which identifies
+// the vectors it creates with their version (see ModelVersion).
+func (cfg *Config) ModelName() string {
+	switch cfg.Backend {
+	case "", "fasttext":
+		name := "fasttext:" + cfg.FasttextPath
+		if cfg.Weighting != "" && cfg.IDF != nil {
+			name += ":" + cfg.Weighting + ":" + cfg.IDF.Fingerprint()
+		}
+		if cfg.Weighting == "sif" && cfg.Component != nil {
+			name += ":" + componentFingerprint(cfg.Component)
+		}
+		if cfg.Normalize.Stopwords != "" {
+			name += ":nostop"
+		}
+		return name
+	case "onnx":
+		return "onnx:" + cfg.ONNX.ModelPath
+	case "remote":
+		return fmt.Sprintf("remote:%s:%s:%d", cfg.Remote.URL, cfg.Remote.Model, cfg.Remote.Dim)
+	default:
+		return cfg.Backend
+	}
+}
+
+// CheckDim returns an error if e does not produce vectors of the dimension dim
+// the indexes are built with.
+func CheckDim(e Embedder, dim int) error {
+	if e.Dim() != dim {
+		return fmt.Errorf("embedding model produces vectors of dimension %d, but the indexes use dimension %d",
+			e.Dim(), dim)
+	}
+	return nil
+}
+
+var wordSepRe = regexp.MustCompile(`\W+`)
+
+// Lucene stop words list.
+var stopwords = map[string]bool{
+	"a":     true,
+	"an":    true,
+	"and":   true,
+	"are":   true,
+	"as":    true,
+	"at":    true,
+	"be":    true,
+	"but":   true,
+	"by":    true,
+	"for":   true,
+	"if":    true,
+	"in":    true,
+	"into":  true,
+	"is":    true,
+	"it":    true,
+	"no":    true,
+	"not":   true,
+	"of":    true,
+	"on":    true,
+	"or":    true,
+	"such":  true,
+	"that":  true,
+	"the":   true,
+	"their": true,
+	"then":  true,
+	"there": true,
+	"these": true,
+	"they":  true,
+	"this":  true,
+	"to":    true,
+	"was":   true,
+	"will":  true,
+	"with":  true,
+}
+
+// FastText is an Embedder that embeds text by averaging the fastText vectors
+// of its words, excluding stop words.
+type FastText struct {
+	ft *fasttext.FastText
+	// Lowercase stop words excluded from the average, or nil.
+	stopwords map[string]bool
+	// Document frequencies weighting the words, or nil to weight them
+	// equally.
+	idf *IDF
+	// Whether the words are weighted by their smooth inverse frequency
+	// instead of their inverse document frequency.
+	sif bool
+	// Common component removed from the embeddings, or nil.
+	component []float32
+}
+
+// NewFastText returns a FastText embedder over the fastText database ft.
+func NewFastText(ft *fasttext.FastText) *FastText {
+	return &FastText{ft: ft, stopwords: stopwords}
+}
+
+// NewWeightedFastText returns a FastText embedder over the fastText database
+// ft that weights the words by their inverse document frequency in idf.
+func NewWeightedFastText(ft *fasttext.FastText, idf *IDF) *FastText {
+	return &FastText{ft: ft, stopwords: stopwords, idf: idf}
+}
+
+// NewSIFFastText returns a FastText embedder over the fastText database ft
+// that creates smooth inverse frequency (SIF) embeddings: the words are
+// weighted by their smooth inverse frequency in idf, and the common component
+// of the corpus (see FirstComponent), if not nil, is removed from the
+// embeddings.
+func NewSIFFastText(ft *fasttext.FastText, idf *IDF, component []float32) *FastText {
+	return &FastText{ft: ft, stopwords: stopwords, idf: idf, sif: true, component: component}
+}
+
+// Close closes the fastText database.
+func (e *FastText) Close() error {
+	return e.ft.Close()
+}
+
+// Dim returns the dimension of the fastText vectors.
+func (e *FastText) Dim() int {
+	return fasttext.Dim
+}
+
+// Embed creates an embedding vector for the given text by averaging the
+// fastText vectors of the words, weighted by their inverse document frequency
+// or smooth inverse frequency if the embedder has document frequencies.
+//
+// Returns a zero vector and ErrNoEmb if none of the words are found in the
+// fastText database.
+func (e *FastText) Embed(text string) ([]float32, error) {
+	vec := make([]float32, fasttext.Dim)
+	foundEmb := false
+
+	for _, word := range wordSepRe.Split(text, -1) {
+		if e.stopwords[strings.ToLower(word)] {
+			continue
+		}
+		emb, err := e.ft.GetEmb(word)
+		if err != nil {
+			if err == fasttext.ErrNoEmbFound {
+				continue
+			}
+			return nil, err
+		}
+		foundEmb = true
+		vec32.Normalize(emb)
+		switch {
+		case e.sif:
+			vec32.Scale(emb, e.idf.SIFWeight(word))
+		case e.idf != nil:
+			vec32.Scale(emb, e.idf.Weight(word))
+		}
+		vec32.Add(vec, emb)
+	}
+	vec32.Scale(vec, 1/float32(len(vec)))
+	if e.component != nil {
+		RemoveComponent(vec, e.component)
+	}
+	vec32.Normalize(vec)
+
+	if !foundEmb {
+		return vec, ErrNoEmb
+	}
+	return vec, nil
+}
@@ -0,0 +1,119 @@
+package embedding
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultExpansions maps acronyms common in open data metadata to their
+// expansion.
+var DefaultExpansions = map[string]string{
+	"ACS":   "American Community Survey",
+	"ADA":   "Americans with Disabilities Act",
+	"BID":   "business improvement district",
+	"CAD":   "computer aided dispatch",
+	"CDC":   "Centers for Disease Control",
+	"CPI":   "consumer price index",
+	"DMV":   "department of motor vehicles",
+	"DOE":   "department of education",
+	"DOH":   "department of health",
+	"DOT":   "department of transportation",
+	"EMS":   "emergency medical services",
+	"EPA":   "Environmental Protection Agency",
+	"EV":    "electric vehicle",
+	"FD":    "fire department",
+	"FEMA":  "Federal Emergency Management Agency",
+	"FOIA":  "Freedom of Information Act",
+	"FOIL":  "freedom of information law",
+	"FY":    "fiscal year",
+	"GDP":   "gross domestic product",
+	"GIS":   "geographic information system",
+	"GTFS":  "general transit feed specification",
+	"HHS":   "health and human services",
+	"HUD":   "housing and urban development",
+	"HVAC":  "heating ventilation and air conditioning",
+	"ICU":   "intensive care unit",
+	"K12":   "kindergarten through twelfth grade",
+	"LEED":  "leadership in energy and environmental design",
+	"LIHTC": "low income housing tax credit",
+	"MWBE":  "minority and women owned business enterprise",
+	"NAICS": "North American Industry Classification System",
+	"NOAA":  "National Oceanic and Atmospheric Administration",
+	"OSHA":  "Occupational Safety and Health Administration",
+	"PD":    "police department",
+	"SNAP":  "supplemental nutrition assistance program",
+	"TIF":   "tax increment financing",
+	"USDA":  "United States Department of Agriculture",
+	"WIC":   "women infants and children",
+	"YTD":   "year to date",
+}
+
+// LoadExpansions returns the expansions selected by spec: none if it is "",
+// DefaultExpansions if it is "default", and otherwise DefaultExpansions
+// extended with the expansions in the file at path spec, which has a word
+// followed by its expansion on each line, e.g. "DOT department of
+// transportation". Blank lines and lines starting with "#" are ignored.
+func LoadExpansions(spec string) (map[string]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	expansions := make(map[string]string, len(DefaultExpansions))
+	for word, expansion := range DefaultExpansions {
+		expansions[word] = expansion
+	}
+	if spec == "default" {
+		return expansions, nil
+	}
+
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: %q has no expansion", spec, line, fields[0])
+		}
+		expansions[fields[0]] = strings.Join(fields[1:], " ")
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("loading expansions: %v", err)
+	}
+	return expansions, nil
+}
+
+// expand returns text with the words in expansions replaced by their
+// expansion. Words with uppercase letters in expansions, such as acronyms,
+// only match words of the same case (so that "US" is expanded but not "us");
+// lowercase words match words of any case. Punctuation around the words is
+// kept.
+func expand(text string, expansions map[string]string) string {
+	words := strings.Fields(text)
+	changed := false
+	for i, word := range words {
+		core := trimPunct(word)
+		if core == "" {
+			continue
+		}
+		expansion, ok := expansions[core]
+		if !ok {
+			expansion, ok = expansions[strings.ToLower(core)]
+		}
+		if ok {
+			words[i] = strings.Replace(word, core, expansion, 1)
+			changed = true
+		}
+	}
+	if !changed {
+		return text
+	}
+	return strings.Join(words, " ")
+}
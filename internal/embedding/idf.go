@@ -0,0 +1,120 @@
+package embedding
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+)
+
+// IDF holds the document frequencies of the words of a corpus, used to weight
+// words by their inverse document frequency (or smooth inverse frequency) in
+// the averaged fastText embeddings, so that frequent words do not dominate
+// them.
+type IDF struct {
+	docs int
+	// Number of documents containing each lowercased word, and their sum.
+	df    map[string]int
+	words int
+}
+
+// NewIDF returns an IDF of an empty corpus.
+func NewIDF() *IDF {
+	return &IDF{df: make(map[string]int)}
+}
+
+// AddDocument adds a document with the given text to the corpus.
+func (idf *IDF) AddDocument(text string) {
+	idf.docs++
+	seen := make(map[string]bool)
+	for _, word := range wordSepRe.Split(strings.ToLower(text), -1) {
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+		idf.df[word]++
+		idf.words++
+	}
+}
+
+// Weight returns the smoothed inverse document frequency of word,
+// ln((1 + N) / (1 + df)) + 1 for a corpus of N documents, df of which contain
+// the word. Words that are not in the corpus have the largest weight.
+func (idf *IDF) Weight(word string) float32 {
+	df := idf.df[strings.ToLower(word)]
+	return float32(math.Log(float64(1+idf.docs)/float64(1+df)) + 1)
+}
+
+// Fingerprint returns a hash of the document frequencies, which identifies the
+// embeddings weighted by them in the embedding cache.
+func (idf *IDF) Fingerprint() string {
+	words := make([]string, 0, len(idf.df))
+	for word := range idf.df {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d\n", idf.docs)
+	for _, word := range words {
+		fmt.Fprintf(h, "%s %d\n", word, idf.df[word])
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// LoadIDF loads the document frequencies saved by Save from the word_documents
+// table of the Open Data Link database at path.
+func LoadIDF(path string) (*IDF, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT word, documents FROM word_documents`)
+	if err != nil {
+		return nil, fmt.Errorf("loading IDF statistics (run process_metadata with idf or sif weighting first): %v", err)
+	}
+	defer rows.Close()
+
+	idf := NewIDF()
+	for rows.Next() {
+		var word string
+		var docs int
+		if err := rows.Scan(&word, &docs); err != nil {
+			return nil, err
+		}
+		if word == "" {
+			idf.docs = docs
+		} else {
+			idf.df[word] = docs
+			idf.words += docs
+		}
+	}
+	return idf, rows.Err()
+}
+
+// Save replaces the contents of the word_documents table with the document
+// frequencies.
+func (idf *IDF) Save(tx *sql.Tx) error {
+	if _, err := tx.Exec(`DELETE FROM word_documents`); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO word_documents (word, documents) VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// The empty word holds the number of documents.
+	if _, err := stmt.Exec("", idf.docs); err != nil {
+		return err
+	}
+	for word, docs := range idf.df {
+		if _, err := stmt.Exec(word, docs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
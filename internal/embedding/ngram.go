@@ -0,0 +1,105 @@
+package embedding
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// Lengths of the character n-grams embedded by NGram, as in fastText.
+const (
+	minNGram = 3
+	maxNGram = 6
+)
+
+// Maximum number of n-gram vectors cached by an NGram.
+const ngramCacheSize = 1 << 14
+
+// NGram is an Embedder that embeds text by summing pseudo-random vectors of the
+// character n-grams of its words, determined by hashes of the n-grams. It needs
+// no vocabulary, so it embeds any word, and words sharing n-grams (e.g.
+// "qty_onhand" and "onhand_qty2") get similar vectors.
+//
+// The vectors are random projections of the spelling of the texts: they are
+// comparable with each other, but nearly orthogonal to the vectors of other
+// models whatever their meaning. NGram is meant as a fallback for texts other
+// models have no embedding for, and its vectors should only be compared with
+// each other. It is safe for concurrent use.
+type NGram struct {
+	dim int
+
+	mu sync.Mutex
+	// Vectors of the most recent n-grams, cleared when full.
+	grams map[string][]float32
+}
+
+// NewNGram returns an NGram embedder of vectors of dimension dim.
+func NewNGram(dim int) *NGram {
+	return &NGram{dim: dim, grams: make(map[string][]float32)}
+}
+
+// Dim returns the dimension of the vectors.
+func (e *NGram) Dim() int {
+	return e.dim
+}
+
+// Embed returns the normalized sum of the vectors of the character n-grams of
+// the lowercased words of text, each enclosed in "<" and ">" to distinguish
+// prefixes and suffixes.
+//
+// Returns a zero vector and ErrNoEmb if the text has no words.
+func (e *NGram) Embed(text string) ([]float32, error) {
+	vec := make([]float32, e.dim)
+	found := false
+	for _, word := range wordSepRe.Split(strings.ToLower(text), -1) {
+		if word == "" {
+			continue
+		}
+		found = true
+		runes := []rune("<" + word + ">")
+		for n := minNGram; n <= maxNGram; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				vec32.Add(vec, e.gramVector(string(runes[i:i+n])))
+			}
+		}
+		if len(runes) < minNGram {
+			// Words of one letter have no n-grams.
+			vec32.Add(vec, e.gramVector(string(runes)))
+		}
+	}
+	vec32.Normalize(vec)
+	if !found {
+		return vec, ErrNoEmb
+	}
+	return vec, nil
+}
+
+// gramVector returns the pseudo-random unit vector of the n-gram gram, which
+// must not be modified.
+func (e *NGram) gramVector(gram string) []float32 {
+	e.mu.Lock()
+	vec, ok := e.grams[gram]
+	e.mu.Unlock()
+	if ok {
+		return vec
+	}
+	h := fnv.New64a()
+	h.Write([]byte(gram))
+	r := rand.New(rand.NewSource(int64(h.Sum64())))
+	vec = make([]float32, e.dim)
+	for i := range vec {
+		vec[i] = float32(r.NormFloat64())
+	}
+	vec32.Normalize(vec)
+
+	e.mu.Lock()
+	if len(e.grams) >= ngramCacheSize {
+		e.grams = make(map[string][]float32)
+	}
+	e.grams[gram] = vec
+	e.mu.Unlock()
+	return vec
+}
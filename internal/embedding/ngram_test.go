@@ -0,0 +1,38 @@
+package embedding
+
+import (
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+func TestNGram(t *testing.T) {
+	e := NewNGram(256)
+	embed := func(text string) []float32 { return mustEmbed(t, e, text) }
+	qty := embed("qty onhand")
+	similar := vec32.Dot(qty, embed("onhand qty2"))
+	unrelated := vec32.Dot(qty, embed("zxcv bnm"))
+	if similar <= unrelated || similar < 0.5 {
+		t.Errorf("got cosine %v of similar names, %v of unrelated names", similar, unrelated)
+	}
+
+	// The cached n-gram vectors give the same vectors as new ones.
+	if got := vec32.Dot(qty, embed("qty onhand")); got < 0.9999 {
+		t.Errorf("got cosine %v with the vector of the same text", got)
+	}
+	if got := vec32.Dot(qty, mustEmbed(t, NewNGram(256), "qty onhand")); got < 0.9999 {
+		t.Errorf("got cosine %v with the vector of another NGram", got)
+	}
+	if _, err := e.Embed(" - "); err != ErrNoEmb {
+		t.Errorf("got error %v for a text without words, want ErrNoEmb", err)
+	}
+}
+
+func mustEmbed(t *testing.T, e Embedder, text string) []float32 {
+	t.Helper()
+	vec, err := e.Embed(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vec
+}
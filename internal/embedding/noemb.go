@@ -0,0 +1,73 @@
+package embedding
+
+import "fmt"
+
+// NoEmbPolicy is the handling of the vectors of texts a model has no
+// embedding for (see ErrNoEmb) when vectors are stored. Whatever the policy,
+// the index builders skip zero vectors, which would only add noise to the
+// indexes.
+type NoEmbPolicy string
+
+const (
+	// NoEmbSkip stores no vector for the text.
+	NoEmbSkip NoEmbPolicy = "skip"
+	// NoEmbFlag stores a zero vector, which marks the text as having no
+	// embedding, so that the rows without embeddings can be found.
+	NoEmbFlag NoEmbPolicy = "flag"
+	// NoEmbFallback embeds the text with an NGram embedder instead, so that it
+	// is still matched by texts spelled similarly. Texts without words get no
+	// vector, as with NoEmbSkip.
+	NoEmbFallback NoEmbPolicy = "fallback"
+)
+
+// ParseNoEmbPolicy returns the policy named s, or NoEmbSkip if s is "".
+func ParseNoEmbPolicy(s string) (NoEmbPolicy, error) {
+	switch p := NoEmbPolicy(s); p {
+	case "":
+		return NoEmbSkip, nil
+	case NoEmbSkip, NoEmbFlag, NoEmbFallback:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown no-embedding policy %q (want skip, flag, or fallback)", s)
+}
+
+// Apply applies p to the vector vec of a text, for which embedding it
+// returned err, and counts the text in counts if it has no embedding. With
+// NoEmbFallback, embed is called to embed the text again with an embedder of
+// vectors of dimension len(vec).
+//
+// It returns the vector to store, or nil if none is stored. Errors other than
+// ErrNoEmb are returned.
+func (p NoEmbPolicy) Apply(vec []float32, err error, embed func(Embedder) ([]float32, error), counts *NoEmbCounts) ([]float32, error) {
+	if err != ErrNoEmb {
+		return vec, err
+	}
+	switch p {
+	case NoEmbFlag:
+		counts.Flagged++
+		return vec, nil
+	case NoEmbFallback:
+		fallback, err := embed(NewNGram(len(vec)))
+		if err == nil {
+			counts.Fallback++
+			return fallback, nil
+		}
+		if err != ErrNoEmb {
+			return nil, err
+		}
+	}
+	counts.Skipped++
+	return nil, nil
+}
+
+// NoEmbCounts counts the texts without embedding by how they were handled.
+type NoEmbCounts struct {
+	Skipped  int
+	Flagged  int
+	Fallback int
+}
+
+func (c *NoEmbCounts) String() string {
+	return fmt.Sprintf("%d skipped, %d flagged, %d embedded by the n-gram fallback",
+		c.Skipped, c.Flagged, c.Fallback)
+}
@@ -0,0 +1,219 @@
+package embedding
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// NormalizeConfig configures the normalization of texts before they are
+// embedded, which is applied to the ingested texts and the queries alike.
+type NormalizeConfig struct {
+	// Lowercase the texts.
+	Lowercase bool
+	// Replace punctuation and symbols with spaces.
+	StripPunctuation bool
+	// Fold the texts to plain characters: strip accents from Latin letters,
+	// remove combining marks, and replace full-width forms, ligatures, and
+	// typographic quotes and dashes with their ASCII equivalents.
+	Fold bool
+	// Reduce English plurals to their singular with the S-stemmer, which
+	// (unlike more aggressive stemmers) mostly keeps words that have
+	// embeddings.
+	Stem bool
+	// Stop words removed from the texts: "" (the default of the backend:
+	// the Lucene list for fasttext, none for the others), "none", "lucene",
+	// or the path of a file with one stop word per line.
+	Stopwords string
+	// Expansions of acronyms and domain terms, e.g. "DOT" to "department of
+	// transportation", applied before the other steps: "" (none), "default"
+	// (DefaultExpansions), or the path of a file extending them (see
+	// LoadExpansions).
+	Expansions string
+}
+
+// enabled reports whether the configuration changes any text.
+func (cfg *NormalizeConfig) enabled() bool {
+	return cfg.Lowercase || cfg.StripPunctuation || cfg.Fold || cfg.Stem || cfg.Stopwords != "" ||
+		cfg.Expansions != ""
+}
+
+// Normalizer normalizes texts as configured by a NormalizeConfig.
+type Normalizer struct {
+	cfg NormalizeConfig
+	// Lowercase stop words, or nil.
+	stopwords map[string]bool
+	// Expansions of words, or nil.
+	expansions map[string]string
+}
+
+// NewNormalizer returns a Normalizer for cfg, loading its stop words and
+// expansions.
+func NewNormalizer(cfg *NormalizeConfig) (*Normalizer, error) {
+	n := &Normalizer{cfg: *cfg}
+	var err error
+	if n.expansions, err = LoadExpansions(cfg.Expansions); err != nil {
+		return nil, err
+	}
+	switch cfg.Stopwords {
+	case "", "none":
+	case "lucene":
+		n.stopwords = stopwords
+	default:
+		if n.stopwords, err = loadStopwords(cfg.Stopwords); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// loadStopwords loads the stop words in the file at path, one per line.
+// Blank lines and lines starting with "#" are ignored.
+func loadStopwords(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	words := make(map[string]bool)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		word := strings.TrimSpace(s.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words[strings.ToLower(word)] = true
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("loading stop words: %v", err)
+	}
+	return words, nil
+}
+
+// Normalize returns the normalized text. Its words are separated by single
+// spaces.
+func (n *Normalizer) Normalize(text string) string {
+	if n.expansions != nil {
+		text = expand(text, n.expansions)
+	}
+	if n.cfg.Fold {
+		text = fold(text)
+	}
+	if n.cfg.Lowercase {
+		text = strings.ToLower(text)
+	}
+	if n.cfg.StripPunctuation {
+		text = strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+				return ' '
+			}
+			return r
+		}, text)
+	}
+	words := strings.Fields(text)
+	out := words[:0]
+	for _, word := range words {
+		if n.stopwords != nil && n.stopwords[strings.ToLower(trimPunct(word))] {
+			continue
+		}
+		if n.cfg.Stem {
+			word = stem(word)
+		}
+		out = append(out, word)
+	}
+	return strings.Join(out, " ")
+}
+
+func trimPunct(word string) string {
+	return strings.TrimFunc(word, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+}
+
+// Latin-1 Supplement and Latin Extended-A letters with diacritics and the
+// letters they decompose to, from the Unicode canonical decompositions.
+const (
+	foldFrom = "ÀÁÂÃÄÅÇÈÉÊËÌÍÎÏÑÒÓÔÕÖÙÚÛÜÝàáâãäåçèéêëìíîïñòóôõöùúûüýÿĀāĂăĄąĆćĈĉĊċČčĎďĒēĔĕĖėĘęĚěĜĝĞğĠġĢģĤĥĨĩĪīĬĭĮįİĴĵĶķĹĺĻļĽľŃńŅņŇňŌōŎŏŐőŔŕŖŗŘřŚśŜŝŞşŠšŢţŤťŨũŪūŬŭŮůŰűŲųŴŵŶŷŸŹźŻżŽž"
+	foldTo   = "AAAAAACEEEEIIIINOOOOOUUUUYaaaaaaceeeeiiiinooooouuuuyyAaAaAaCcCcCcCcDdEeEeEeEeEeGgGgGgGgHhIiIiIiIiIJjKkLlLlLlNnNnNnOoOoOoRrRrRrSsSsSsSsTtTtUuUuUuUuUuUuWwYyYZzZzZz"
+)
+
+var foldLetters = make(map[rune]rune)
+
+func init() {
+	to := []rune(foldTo)
+	for i, r := range []rune(foldFrom) {
+		foldLetters[r] = to[i]
+	}
+}
+
+// Letters and punctuation that fold to several characters.
+var foldReplacer = strings.NewReplacer(
+	"ß", "ss", "Æ", "AE", "æ", "ae", "Œ", "OE", "œ", "oe", "Ø", "O", "ø", "o",
+	"Ł", "L", "ł", "l", "Đ", "D", "đ", "d", "Þ", "Th", "þ", "th",
+	"ﬀ", "ff", "ﬁ", "fi", "ﬂ", "fl", "ﬃ", "ffi", "ﬄ", "ffl",
+	"‘", "'", "’", "'", "“", `"`, "”", `"`, "–", "-", "—", "-", "…", "...",
+)
+
+// fold folds text to plain characters (see NormalizeConfig.Fold).
+func fold(text string) string {
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			return -1
+		case r >= '！' && r <= '～':
+			// Full-width forms of ASCII characters.
+			return r - '！' + '!'
+		case r == '　':
+			return ' '
+		}
+		if base, ok := foldLetters[r]; ok {
+			return base
+		}
+		return r
+	}, text)
+	return foldReplacer.Replace(text)
+}
+
+// stem reduces an English plural to its singular with the S-stemmer of Harman,
+// "How effective is suffixing?" (1991). Words that are not all letters are
+// left unchanged.
+func stem(word string) string {
+	for _, r := range word {
+		if !unicode.IsLetter(r) {
+			return word
+		}
+	}
+	lower := strings.ToLower(word)
+	n := len(word)
+	switch {
+	case strings.HasSuffix(lower, "ies") && !strings.HasSuffix(lower, "eies") && !strings.HasSuffix(lower, "aies") && n > 4:
+		return word[:n-3] + "y"
+	case strings.HasSuffix(lower, "es") && !strings.HasSuffix(lower, "aes") && !strings.HasSuffix(lower, "ees") && !strings.HasSuffix(lower, "oes") && n > 3:
+		return word[:n-1]
+	case strings.HasSuffix(lower, "s") && !strings.HasSuffix(lower, "us") && !strings.HasSuffix(lower, "ss") && n > 3:
+		return word[:n-1]
+	}
+	return word
+}
+
+// normalizing is a Model that normalizes the texts before embedding them with
+// another model.
+type normalizing struct {
+	Model
+	n *Normalizer
+}
+
+func (e *normalizing) Embed(text string) ([]float32, error) {
+	return e.Model.Embed(e.n.Normalize(text))
+}
+
+func (e *normalizing) EmbedBatch(texts []string) ([][]float32, []error, error) {
+	normalized := make([]string, len(texts))
+	for i, text := range texts {
+		normalized[i] = e.n.Normalize(text)
+	}
+	return EmbedAll(e.Model, normalized)
+}
@@ -0,0 +1,321 @@
+//go:build onnx
+// +build onnx
+
+package embedding
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+#include <onnxruntime_c_api.h>
+
+static const OrtApi *ort;
+static OrtEnv *ort_env;
+
+// ort_error returns a copy of the message of status, which it releases.
+static char *ort_error(OrtStatus *status) {
+	char *msg = strdup(ort->GetErrorMessage(status));
+	ort->ReleaseStatus(status);
+	return msg;
+}
+
+#define ORT_TRY(expr) do { OrtStatus *s_ = (expr); if (s_) return ort_error(s_); } while (0)
+
+// ort_init loads the ONNX Runtime library and creates the environment.
+static char *ort_init(const char *lib) {
+	void *h = dlopen(lib, RTLD_NOW | RTLD_LOCAL);
+	if (!h) return strdup(dlerror());
+	const OrtApiBase *(*get_api_base)(void) = (const OrtApiBase *(*)(void))dlsym(h, "OrtGetApiBase");
+	if (!get_api_base) return strdup(dlerror());
+	ort = get_api_base()->GetApi(ORT_API_VERSION);
+	if (!ort) return strdup("ONNX Runtime library is older than its headers");
+	ORT_TRY(ort->CreateEnv(ORT_LOGGING_LEVEL_WARNING, "opendatalink", &ort_env));
+	return NULL;
+}
+
+static char *ort_create_session(const char *model, OrtSession **session) {
+	OrtSessionOptions *opts;
+	ORT_TRY(ort->CreateSessionOptions(&opts));
+	OrtStatus *s = ort->CreateSession(ort_env, model, opts, session);
+	ort->ReleaseSessionOptions(opts);
+	return s ? ort_error(s) : NULL;
+}
+
+static void ort_release_session(OrtSession *session) {
+	ort->ReleaseSession(session);
+}
+
+// ort_io_names copies the names of the first (up to) max inputs (or outputs) of
+// the session into names and sets n to their number.
+static char *ort_io_names(OrtSession *session, int outputs, char **names, size_t max, size_t *n) {
+	OrtAllocator *alloc;
+	ORT_TRY(ort->GetAllocatorWithDefaultOptions(&alloc));
+	ORT_TRY(outputs ? ort->SessionGetOutputCount(session, n) : ort->SessionGetInputCount(session, n));
+	if (*n > max) *n = max;
+	for (size_t i = 0; i < *n; i++) {
+		char *name;
+		ORT_TRY(outputs ? ort->SessionGetOutputName(session, i, alloc, &name)
+		                : ort->SessionGetInputName(session, i, alloc, &name));
+		names[i] = strdup(name);
+		ORT_TRY(ort->AllocatorFree(alloc, name));
+	}
+	return NULL;
+}
+
+// ort_run runs the session on the ninputs int64 tensors of shape [1, len]
+// concatenated in inputs and copies its output named output_name, a float
+// tensor of at most 3 dimensions, into a new array out with its shape in shape
+// and its number of dimensions in rank.
+static char *ort_run(OrtSession *session, const char **input_names, int64_t *inputs, size_t ninputs, int64_t len,
+		const char *output_name, float **out, int64_t *shape, size_t *rank) {
+	OrtMemoryInfo *mem;
+	ORT_TRY(ort->CreateCpuMemoryInfo(OrtArenaAllocator, OrtMemTypeDefault, &mem));
+
+	int64_t in_shape[2] = {1, len};
+	OrtValue *values[3] = {NULL, NULL, NULL};
+	OrtValue *output = NULL;
+	OrtTensorTypeAndShapeInfo *info = NULL;
+	OrtStatus *s = NULL;
+	size_t count = 0;
+	float *data;
+
+	for (size_t i = 0; i < ninputs && !s; i++)
+		s = ort->CreateTensorWithDataAsOrtValue(mem, inputs + i * len, len * sizeof(int64_t), in_shape, 2,
+			ONNX_TENSOR_ELEMENT_DATA_TYPE_INT64, &values[i]);
+	if (!s) s = ort->Run(session, NULL, input_names, (const OrtValue *const *)values, ninputs,
+		&output_name, 1, &output);
+	if (!s) s = ort->GetTensorTypeAndShape(output, &info);
+	if (!s) s = ort->GetDimensionsCount(info, rank);
+	if (!s && *rank > 3) s = ort->CreateStatus(ORT_FAIL, "model output has more than 3 dimensions");
+	if (!s) s = ort->GetDimensions(info, shape, *rank);
+	if (!s) s = ort->GetTensorShapeElementCount(info, &count);
+	if (!s) s = ort->GetTensorMutableData(output, (void **)&data);
+	if (!s) {
+		*out = malloc(count * sizeof(float));
+		memcpy(*out, data, count * sizeof(float));
+	}
+
+	if (info) ort->ReleaseTensorTypeAndShapeInfo(info);
+	if (output) ort->ReleaseValue(output);
+	for (size_t i = 0; i < ninputs; i++)
+		if (values[i]) ort->ReleaseValue(values[i]);
+	ort->ReleaseMemoryInfo(mem);
+	return s ? ort_error(s) : NULL;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+var (
+	ortOnce sync.Once
+	ortErr  error
+)
+
+// ONNX is an Embedder that embeds text with a sentence-transformer model run by
+// ONNX Runtime. The token embeddings of the model are mean-pooled into a unit
+// text embedding.
+//
+// Building it requires the onnx build tag and the ONNX Runtime C headers (e.g.
+// CGO_CFLAGS=-I/path/to/onnxruntime/include). The ONNX Runtime library is
+// loaded when the first model is opened. It is safe for concurrent use.
+type ONNX struct {
+	session *C.OrtSession
+	tok     *WordPiece
+	// Names of the model inputs, fed in this order.
+	inputs []*C.char
+	output *C.char
+	// Whether each input is the input_ids, attention_mask, or token_type_ids.
+	kinds     []onnxInput
+	dim       int
+	maxTokens int
+}
+
+type onnxInput int
+
+const (
+	inputIDs onnxInput = iota
+	attentionMask
+	tokenTypeIDs
+)
+
+var onnxInputs = map[string]onnxInput{
+	"input_ids":      inputIDs,
+	"attention_mask": attentionMask,
+	"token_type_ids": tokenTypeIDs,
+}
+
+// NewONNX opens the ONNX sentence-transformer model of cfg.
+func NewONNX(cfg *ONNXConfig) (Model, error) {
+	if cfg.MaxTokens < 3 {
+		return nil, fmt.Errorf("onnx embedding: invalid maximum number of tokens %d", cfg.MaxTokens)
+	}
+	tok, err := LoadWordPiece(cfg.VocabPath)
+	if err != nil {
+		return nil, err
+	}
+	ortOnce.Do(func() {
+		lib := cfg.LibraryPath
+		if lib == "" {
+			lib = "libonnxruntime.so"
+		}
+		clib := C.CString(lib)
+		defer C.free(unsafe.Pointer(clib))
+		ortErr = cError(C.ort_init(clib))
+	})
+	if ortErr != nil {
+		return nil, fmt.Errorf("onnx embedding: loading ONNX Runtime: %v", ortErr)
+	}
+
+	e := &ONNX{tok: tok, maxTokens: cfg.MaxTokens}
+	cmodel := C.CString(cfg.ModelPath)
+	defer C.free(unsafe.Pointer(cmodel))
+	if err := cError(C.ort_create_session(cmodel, &e.session)); err != nil {
+		return nil, fmt.Errorf("onnx embedding: %v", err)
+	}
+	if err := e.init(); err != nil {
+		e.Close()
+		return nil, fmt.Errorf("onnx embedding: %v", err)
+	}
+	return e, nil
+}
+
+// init reads the model input and output names and the embedding dimension.
+func (e *ONNX) init() error {
+	var names [3]*C.char
+	var n C.size_t
+	if err := cError(C.ort_io_names(e.session, 0, &names[0], C.size_t(len(names)), &n)); err != nil {
+		return err
+	}
+	e.inputs = append(e.inputs, names[:n]...)
+	if len(e.inputs) == 0 {
+		return errors.New("model has no inputs")
+	}
+	for _, name := range e.inputs {
+		kind, ok := onnxInputs[C.GoString(name)]
+		if !ok {
+			return fmt.Errorf("unknown model input %q", C.GoString(name))
+		}
+		e.kinds = append(e.kinds, kind)
+	}
+	if err := cError(C.ort_io_names(e.session, 1, &names[0], 1, &n)); err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("model has no outputs")
+	}
+	e.output = names[0]
+
+	// Embed the empty text to find the dimension.
+	vec, err := e.run(e.tok.Encode("", e.maxTokens))
+	if err != nil {
+		return err
+	}
+	e.dim = len(vec)
+	return nil
+}
+
+// Dim returns the dimension of the model's embedding vectors.
+func (e *ONNX) Dim() int {
+	return e.dim
+}
+
+// Embed creates an embedding vector for the given text with the model.
+//
+// Returns a zero vector and ErrNoEmb if the text has no tokens.
+func (e *ONNX) Embed(text string) ([]float32, error) {
+	ids := e.tok.Encode(text, e.maxTokens)
+	// Only [CLS] and [SEP].
+	if len(ids) == 2 {
+		return make([]float32, e.dim), ErrNoEmb
+	}
+	vec, err := e.run(ids)
+	if err != nil {
+		return nil, err
+	}
+	vec32.Normalize(vec)
+	return vec, nil
+}
+
+// run runs the model on the token IDs and returns the mean of the token
+// embeddings, or the sentence embedding if the model outputs one.
+func (e *ONNX) run(ids []int64) ([]float32, error) {
+	n := len(ids)
+	inputs := make([]int64, len(e.kinds)*n)
+	for i, kind := range e.kinds {
+		in := inputs[i*n : (i+1)*n]
+		switch kind {
+		case inputIDs:
+			copy(in, ids)
+		case attentionMask:
+			for j := range in {
+				in[j] = 1
+			}
+		case tokenTypeIDs:
+			// A single segment, all zeros.
+		}
+	}
+
+	var out *C.float
+	var shape [3]C.int64_t
+	var rank C.size_t
+	err := cError(C.ort_run(e.session, &e.inputs[0], (*C.int64_t)(unsafe.Pointer(&inputs[0])),
+		C.size_t(len(e.kinds)), C.int64_t(n), e.output, &out, &shape[0], &rank))
+	if err != nil {
+		return nil, err
+	}
+	defer C.free(unsafe.Pointer(out))
+
+	var tokens, dim int
+	switch rank {
+	case 2: // [1, dim]
+		tokens, dim = 1, int(shape[1])
+	case 3: // [1, tokens, dim]
+		tokens, dim = int(shape[1]), int(shape[2])
+	default:
+		return nil, fmt.Errorf("model output has %d dimensions, want 2 or 3", rank)
+	}
+	var data []float32
+	sh := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	sh.Data = uintptr(unsafe.Pointer(out))
+	sh.Len = tokens * dim
+	sh.Cap = sh.Len
+
+	vec := make([]float32, dim)
+	for t := 0; t < tokens; t++ {
+		vec32.Add(vec, data[t*dim:(t+1)*dim])
+	}
+	vec32.Scale(vec, 1/float32(tokens))
+	return vec, nil
+}
+
+// Close frees the model.
+func (e *ONNX) Close() error {
+	C.ort_release_session(e.session)
+	for _, name := range e.inputs {
+		C.free(unsafe.Pointer(name))
+	}
+	if e.output != nil {
+		C.free(unsafe.Pointer(e.output))
+	}
+	return nil
+}
+
+// cError converts an error message returned by the C helpers to an error and
+// frees it.
+func cError(msg *C.char) error {
+	if msg == nil {
+		return nil
+	}
+	defer C.free(unsafe.Pointer(msg))
+	return errors.New(C.GoString(msg))
+}
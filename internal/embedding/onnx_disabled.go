@@ -0,0 +1,12 @@
+//go:build !onnx
+// +build !onnx
+
+package embedding
+
+import "errors"
+
+// NewONNX returns an error, since the onnx backend requires building with the
+// onnx build tag.
+func NewONNX(cfg *ONNXConfig) (Model, error) {
+	return nil, errors.New("onnx embedding backend not available: build with -tags onnx")
+}
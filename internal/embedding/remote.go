@@ -0,0 +1,340 @@
+package embedding
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// RemoteConfig configures the remote embedding API backend.
+type RemoteConfig struct {
+	// URL of the OpenAI-compatible embeddings endpoint, e.g.
+	// "https://api.openai.com/v1/embeddings".
+	URL string
+	// Name of the model, e.g. "text-embedding-3-small".
+	Model string
+	// API key sent as a bearer token, if not empty.
+	APIKey string
+	// Dimension of the embedding vectors. If zero, it is found by embedding
+	// a probe text. Otherwise it is sent as the dimensions request
+	// parameter, which only some models support.
+	Dim int
+	// Maximum number of texts per request.
+	BatchSize int
+	// Maximum number of requests per second, or 0 for no limit.
+	RequestsPerSecond int
+	// Number of times a failed request is retried, with exponential backoff.
+	MaxRetries int
+	// Directory that embeddings are cached in, so that texts are only sent
+	// once. If empty, embeddings are not cached.
+	CacheDir string
+	// Timeout of each request. If zero, requests time out after one minute.
+	Timeout time.Duration
+}
+
+// BatchEmbedder is implemented by embedders that can embed several texts at
+// once more efficiently than one at a time.
+type BatchEmbedder interface {
+	Embedder
+	// EmbedBatch returns the results of Embed for each of the texts. The
+	// error of each text is returned in errs, with err reporting failures of
+	// the whole batch.
+	EmbedBatch(texts []string) (vecs [][]float32, errs []error, err error)
+}
+
+// EmbedAll embeds the texts with e, in batches if e is a BatchEmbedder.
+func EmbedAll(e Embedder, texts []string) (vecs [][]float32, errs []error, err error) {
+	if b, ok := e.(BatchEmbedder); ok {
+		return b.EmbedBatch(texts)
+	}
+	vecs = make([][]float32, len(texts))
+	errs = make([]error, len(texts))
+	for i, text := range texts {
+		vecs[i], errs[i] = e.Embed(text)
+		if errs[i] != nil && errs[i] != ErrNoEmb {
+			return nil, nil, errs[i]
+		}
+	}
+	return vecs, errs, nil
+}
+
+// Delay before the first retry of a failed request, doubled for each retry.
+var remoteRetryDelay = 500 * time.Millisecond
+
+// Remote is an Embedder that embeds text with a remote OpenAI-compatible
+// embeddings API. It is safe for concurrent use.
+type Remote struct {
+	cfg    RemoteConfig
+	client *http.Client
+	// Dimension of the embedding vectors: cfg.Dim, or the dimension found
+	// by embedding a probe text. Only cfg.Dim is sent to the API.
+	dim int
+	// Guards next.
+	mu sync.Mutex
+	// Earliest time of the next request with a request rate limit.
+	next time.Time
+}
+
+// NewRemote returns a Remote embedder for the API of cfg.
+func NewRemote(cfg *RemoteConfig) (*Remote, error) {
+	if cfg.URL == "" || cfg.Model == "" {
+		return nil, errors.New("remote embedding: URL and model must be set")
+	}
+	if cfg.BatchSize < 1 || cfg.RequestsPerSecond < 0 || cfg.MaxRetries < 0 {
+		return nil, fmt.Errorf("remote embedding: invalid batch size %d, requests per second %d, or retries %d",
+			cfg.BatchSize, cfg.RequestsPerSecond, cfg.MaxRetries)
+	}
+	e := &Remote{cfg: *cfg, client: &http.Client{Timeout: cfg.Timeout}, dim: cfg.Dim}
+	if e.client.Timeout == 0 {
+		e.client.Timeout = time.Minute
+	}
+	if cfg.CacheDir != "" {
+		if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	if e.dim == 0 {
+		vec, err := e.Embed("dimension")
+		if err != nil {
+			return nil, fmt.Errorf("remote embedding: %v", err)
+		}
+		e.dim = len(vec)
+	}
+	return e, nil
+}
+
+// Dim returns the dimension of the embedding vectors.
+func (e *Remote) Dim() int {
+	return e.dim
+}
+
+// Close does nothing. It is provided so that Remote is a Model.
+func (e *Remote) Close() error {
+	return nil
+}
+
+// Embed creates an embedding vector for the given text with the API.
+//
+// Returns a zero vector and ErrNoEmb if the text is blank.
+func (e *Remote) Embed(text string) ([]float32, error) {
+	vecs, errs, err := e.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], errs[0]
+}
+
+// EmbedBatch creates embedding vectors for the texts with the API, sending the
+// texts that are not cached in requests of at most BatchSize texts.
+//
+// Blank texts are not sent, and get a zero vector and ErrNoEmb in errs.
+func (e *Remote) EmbedBatch(texts []string) ([][]float32, []error, error) {
+	vecs := make([][]float32, len(texts))
+	errs := make([]error, len(texts))
+	// Indexes of the texts to request and of repeated texts, and the index
+	// of the first occurrence of each text.
+	var todo, repeats []int
+	first := make(map[string]int)
+
+	for i, text := range texts {
+		if strings.TrimSpace(text) == "" {
+			vecs[i], errs[i] = make([]float32, e.dim), ErrNoEmb
+			continue
+		}
+		if _, ok := first[text]; ok {
+			repeats = append(repeats, i)
+			continue
+		}
+		first[text] = i
+		if vec := e.cached(text); vec != nil {
+			vecs[i] = vec
+			continue
+		}
+		todo = append(todo, i)
+	}
+	for start := 0; start < len(todo); start += e.cfg.BatchSize {
+		end := start + e.cfg.BatchSize
+		if end > len(todo) {
+			end = len(todo)
+		}
+		batch := make([]string, end-start)
+		for j, i := range todo[start:end] {
+			batch[j] = texts[i]
+		}
+		res, err := e.request(batch)
+		if err != nil {
+			return nil, nil, fmt.Errorf("remote embedding: %v", err)
+		}
+		for j, i := range todo[start:end] {
+			vec32.Normalize(res[j])
+			vecs[i] = res[j]
+			e.cache(texts[i], res[j])
+		}
+	}
+	for _, i := range repeats {
+		vecs[i] = vecs[first[texts[i]]]
+	}
+	return vecs, errs, nil
+}
+
+type remoteRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type remoteResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// request requests the embedding vectors of the texts, retrying failed
+// requests.
+func (e *Remote) request(texts []string) ([][]float32, error) {
+	body, err := json.Marshal(remoteRequest{e.cfg.Model, texts, e.cfg.Dim})
+	if err != nil {
+		return nil, err
+	}
+	delay := remoteRetryDelay
+	for attempt := 0; ; attempt++ {
+		e.wait()
+		vecs, retryAfter, err := e.post(body, len(texts))
+		if err == nil || retryAfter < 0 || attempt == e.cfg.MaxRetries {
+			return vecs, err
+		}
+		if retryAfter < delay {
+			// Jitter spreads out the retries of concurrent requests.
+			retryAfter = delay/2 + time.Duration(rand.Int63n(int64(delay)))
+		}
+		time.Sleep(retryAfter)
+		delay *= 2
+	}
+}
+
+// post sends one request. If the request failed temporarily, it returns how
+// long to wait before retrying (0 if the server did not say); if the error is
+// permanent, it returns a negative duration.
+func (e *Remote) post(body []byte, n int) ([][]float32, time.Duration, error) {
+	req, err := http.NewRequest("POST", e.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.cfg.APIKey)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return nil, -1, err
+		}
+		secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, time.Duration(secs) * time.Second, err
+	}
+
+	var res remoteResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, -1, err
+	}
+	vecs := make([][]float32, n)
+	for _, d := range res.Data {
+		if d.Index < 0 || d.Index >= n {
+			return nil, -1, fmt.Errorf("response has embedding of input %d of %d", d.Index, n)
+		}
+		vecs[d.Index] = d.Embedding
+	}
+	for i, vec := range vecs {
+		if vec == nil || e.dim != 0 && len(vec) != e.dim {
+			return nil, -1, fmt.Errorf("response has no embedding of dimension %d for input %d", e.dim, i)
+		}
+	}
+	return vecs, 0, nil
+}
+
+// wait waits until a request can be sent without exceeding the request rate
+// limit.
+func (e *Remote) wait() {
+	if e.cfg.RequestsPerSecond == 0 {
+		return
+	}
+	e.mu.Lock()
+	now := time.Now()
+	at := e.next
+	if at.Before(now) {
+		at = now
+	}
+	e.next = at.Add(time.Second / time.Duration(e.cfg.RequestsPerSecond))
+	e.mu.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+// cachePath returns the path of the cache file of the embedding of text.
+func (e *Remote) cachePath(text string) string {
+	h := sha256.Sum256([]byte(e.cfg.URL + "\x00" + e.cfg.Model + "\x00" + text))
+	name := hex.EncodeToString(h[:])
+	return filepath.Join(e.cfg.CacheDir, name[:2], name)
+}
+
+// cached returns the cached embedding of text, or nil if it is not cached.
+func (e *Remote) cached(text string) []float32 {
+	if e.cfg.CacheDir == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(e.cachePath(text))
+	if err != nil {
+		return nil
+	}
+	vec, err := vec32.FromBytes(data)
+	if err != nil || len(vec) != e.dim {
+		return nil
+	}
+	return vec
+}
+
+// cache caches the embedding of text. Caching is best-effort: errors are
+// ignored.
+func (e *Remote) cache(text string, vec []float32) {
+	if e.cfg.CacheDir == "" || len(vec) != e.dim {
+		return
+	}
+	path := e.cachePath(text)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	// Write to a temporary file first so that concurrent readers never see
+	// a partial file.
+	tmp := fmt.Sprintf("%s.%d.tmp", path, rand.Int63())
+	if err := ioutil.WriteFile(tmp, vec32.Bytes(vec), 0644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+	}
+}
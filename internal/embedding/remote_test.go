@@ -0,0 +1,234 @@
+package embedding
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// remoteServer is an OpenAI-compatible embeddings API for the tests. It embeds
+// each text as a vector of dimension 3, after failing with the given status
+// codes and Retry-After headers.
+type remoteServer struct {
+	*httptest.Server
+	failures []remoteFailure
+
+	mu sync.Mutex
+	// The request bodies received, including those of the failures.
+	requests []map[string]interface{}
+}
+
+type remoteFailure struct {
+	status     int
+	retryAfter string
+}
+
+func newRemoteServer(failures ...remoteFailure) *remoteServer {
+	s := &remoteServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		n := len(s.requests)
+		s.mu.Unlock()
+		if n <= len(s.failures) {
+			f := s.failures[n-1]
+			if f.retryAfter != "" {
+				w.Header().Set("Retry-After", f.retryAfter)
+			}
+			http.Error(w, `{"error": "failure"}`, f.status)
+			return
+		}
+		var data []map[string]interface{}
+		for i, text := range req["input"].([]interface{}) {
+			data = append(data, map[string]interface{}{
+				"index":     i,
+				"embedding": []float32{float32(len(text.(string))), 1, 0},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	return s
+}
+
+// received returns the request bodies received.
+func (s *remoteServer) received() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}(nil), s.requests...)
+}
+
+// inputs returns the number of texts of each request received.
+func (s *remoteServer) inputs() []int {
+	var n []int
+	for _, req := range s.received() {
+		n = append(n, len(req["input"].([]interface{})))
+	}
+	return n
+}
+
+func TestRemoteEmbedBatch(t *testing.T) {
+	srv := newRemoteServer()
+	defer srv.Close()
+	e, err := NewRemote(&RemoteConfig{URL: srv.URL, Model: "test", BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Dim() != 3 {
+		t.Errorf("got dimension %d, want the dimension 3 of the probe", e.Dim())
+	}
+	vecs, errs, err := e.EmbedBatch([]string{"a", "bb", " ", "a", "ccc", "dddd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The probe, then the four distinct texts in batches of two.
+	if got := srv.inputs(); len(got) != 3 || got[1] != 2 || got[2] != 2 {
+		t.Errorf("got requests of %v texts, want [1 2 2]", got)
+	}
+	for _, req := range srv.received() {
+		if _, ok := req["dimensions"]; ok {
+			t.Errorf("request %v has dimensions without a configured dimension", req)
+		}
+	}
+	if errs[2] != ErrNoEmb || len(vecs[2]) != 3 {
+		t.Errorf("got %v, %v for a blank text, want a zero vector and ErrNoEmb", vecs[2], errs[2])
+	}
+	if &vecs[3][0] != &vecs[0][0] || vecs[4][0] <= vecs[1][0] {
+		t.Errorf("got vectors %v", vecs)
+	}
+
+	srv = newRemoteServer()
+	defer srv.Close()
+	if _, err = NewRemote(&RemoteConfig{URL: srv.URL, Model: "test", BatchSize: 2, Dim: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.inputs()) != 0 {
+		t.Errorf("got %d requests with a configured dimension, want none", len(srv.inputs()))
+	}
+}
+
+func TestRemoteDimensions(t *testing.T) {
+	srv := newRemoteServer()
+	defer srv.Close()
+	e, err := NewRemote(&RemoteConfig{URL: srv.URL, Model: "test", BatchSize: 1, Dim: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Embed("a"); err != nil {
+		t.Fatal(err)
+	}
+	req := srv.received()[0]
+	if d, ok := req["dimensions"].(float64); !ok || d != 3 {
+		t.Errorf("got dimensions %v, want the configured dimension 3", req["dimensions"])
+	}
+}
+
+func TestRemoteRateLimit(t *testing.T) {
+	srv := newRemoteServer()
+	defer srv.Close()
+	e, err := NewRemote(&RemoteConfig{URL: srv.URL, Model: "test", BatchSize: 1, Dim: 3, RequestsPerSecond: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, _, err := e.EmbedBatch([]string{"a", "b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	// Three requests, 50ms apart.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("requests took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestRemoteRetry(t *testing.T) {
+	defer func(d time.Duration) { remoteRetryDelay = d }(remoteRetryDelay)
+	remoteRetryDelay = time.Millisecond
+
+	srv := newRemoteServer(
+		remoteFailure{http.StatusTooManyRequests, "1"},
+		remoteFailure{http.StatusServiceUnavailable, ""},
+	)
+	defer srv.Close()
+	e, err := NewRemote(&RemoteConfig{URL: srv.URL, Model: "test", BatchSize: 1, Dim: 3, MaxRetries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := e.Embed("a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.inputs()) != 3 {
+		t.Errorf("got %d attempts, want 3", len(srv.inputs()))
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retries took %v, want at least the Retry-After of 1s", elapsed)
+	}
+
+	// Retries stop after MaxRetries.
+	srv = newRemoteServer(
+		remoteFailure{http.StatusInternalServerError, ""},
+		remoteFailure{http.StatusInternalServerError, ""},
+	)
+	defer srv.Close()
+	e, err = NewRemote(&RemoteConfig{URL: srv.URL, Model: "test", BatchSize: 1, Dim: 3, MaxRetries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Embed("a"); err == nil || len(srv.inputs()) != 2 {
+		t.Errorf("got error %v after %d attempts, want an error after 2", err, len(srv.inputs()))
+	}
+}
+
+func TestRemotePermanentError(t *testing.T) {
+	srv := newRemoteServer(remoteFailure{http.StatusBadRequest, "0"})
+	defer srv.Close()
+	e, err := NewRemote(&RemoteConfig{URL: srv.URL, Model: "test", BatchSize: 1, Dim: 3, MaxRetries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Embed("a"); err == nil || len(srv.inputs()) != 1 {
+		t.Errorf("got error %v after %d attempts, want an error after 1", err, len(srv.inputs()))
+	}
+}
+
+func TestRemoteCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedding")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srv := newRemoteServer()
+	defer srv.Close()
+
+	cfg := &RemoteConfig{URL: srv.URL, Model: "test", BatchSize: 2, CacheDir: dir}
+	var want [][]float32
+	for i := 0; i < 2; i++ {
+		e, err := NewRemote(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vecs, _, err := e.EmbedBatch([]string{"a", "bb"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			want = vecs
+		} else if vecs[0][0] != want[0][0] || vecs[1][0] != want[1][0] {
+			t.Errorf("got cached vectors %v, want %v", vecs, want)
+		}
+	}
+	// The probe of each embedder, which is not cached as its dimension is
+	// unknown, and the texts once.
+	if got := srv.inputs(); len(got) != 3 || got[1] != 2 || got[2] != 1 {
+		t.Errorf("got requests of %v texts, want [1 2 1]", got)
+	}
+}
@@ -0,0 +1,192 @@
+package embedding
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Router is a Model that routes the texts of datasets to embedding models by
+// the datasets' categories, e.g. to fastText models fine-tuned on the
+// vocabulary of health or transportation datasets. Texts embedded with Embed
+// use the default model.
+//
+// Models are named by their (lowercase) category; the default model is named
+// "".
+type Router struct {
+	models   map[string]Model
+	versions map[string]Version
+}
+
+// OpenRouter opens the model selected by cfg as the default model and, for
+// each category in paths, the fastText database at its path with the settings
+// of cfg.
+func OpenRouter(cfg *Config, paths map[string]string) (*Router, error) {
+	if len(paths) > 0 && cfg.Backend != "" && cfg.Backend != "fasttext" {
+		return nil, fmt.Errorf("embedding backend %s does not support category models", cfg.Backend)
+	}
+	cfg, err := cfg.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	def, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &Router{
+		models:   map[string]Model{"": def},
+		versions: map[string]Version{"": cfg.ModelVersion(def.Dim())},
+	}
+	for category, path := range paths {
+		category = strings.ToLower(category)
+		if category == "" {
+			r.Close()
+			return nil, fmt.Errorf("category model %s has no category", path)
+		}
+		c := *cfg
+		c.FasttextPath = path
+		m, err := Open(&c)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("category %s: %v", category, err)
+		}
+		r.models[category] = m
+		r.versions[category] = c.ModelVersion(m.Dim())
+	}
+	return r, nil
+}
+
+// OpenWithVersions opens the model selected by cfg and returns it with the
+// versions of the vectors of each model by name (see Router.Versions). If
+// paths is not empty, the model is a Router over the category models in paths
+// (see OpenRouter); otherwise the only model is the default model "".
+func OpenWithVersions(cfg *Config, paths map[string]string) (Model, map[string]Version, error) {
+	if len(paths) > 0 {
+		r, err := OpenRouter(cfg, paths)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r, r.Versions(), nil
+	}
+	cfg, err := cfg.Resolve()
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := Open(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, map[string]Version{"": cfg.ModelVersion(m.Dim())}, nil
+}
+
+// Route returns the name and model of the first of the categories that has a
+// model, or the default model.
+func (r *Router) Route(categories []string) (string, Model) {
+	for _, category := range categories {
+		category = strings.ToLower(category)
+		if m, ok := r.models[category]; ok && category != "" {
+			return category, m
+		}
+	}
+	return "", r.models[""]
+}
+
+// Model returns the model with the given name, or nil.
+func (r *Router) Model(name string) Model {
+	return r.models[name]
+}
+
+// Version returns the version of the vectors of the model with the given
+// name.
+func (r *Router) Version(name string) Version {
+	return r.versions[name]
+}
+
+// Versions returns the versions of the vectors of the models by name.
+func (r *Router) Versions() map[string]Version {
+	versions := make(map[string]Version, len(r.versions))
+	for name, v := range r.versions {
+		versions[name] = v
+	}
+	return versions
+}
+
+// Names returns the sorted names of the models, starting with the default
+// model "".
+func (r *Router) Names() []string {
+	names := make([]string, 0, len(r.models))
+	for name := range r.models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dims returns the dimension of each model's vectors by name.
+func (r *Router) Dims() map[string]int {
+	dims := make(map[string]int, len(r.models))
+	for name, m := range r.models {
+		dims[name] = m.Dim()
+	}
+	return dims
+}
+
+// EmbedRouted returns the concatenated embeddings of text by every model, in
+// the order of Names, for querying the vectors of all models. The embeddings of
+// models that have no embedding for the text are zero vectors.
+//
+// Returns ErrNoEmb if no model has an embedding for the text.
+func (r *Router) EmbedRouted(text string) ([]float32, error) {
+	var vec []float32
+	found := false
+	for _, name := range r.Names() {
+		v, err := r.models[name].Embed(text)
+		if err != nil && err != ErrNoEmb {
+			return nil, err
+		}
+		if err == nil {
+			found = true
+		}
+		vec = append(vec, v...)
+	}
+	if !found {
+		return vec, ErrNoEmb
+	}
+	return vec, nil
+}
+
+// RoutedVector returns the vector vec of the named model, padded with zero
+// vectors for the other models to the layout of EmbedRouted, so that only the
+// vectors of the named model are queried.
+func (r *Router) RoutedVector(name string, vec []float32) []float32 {
+	var out []float32
+	for _, n := range r.Names() {
+		if n == name {
+			out = append(out, vec...)
+		} else {
+			out = append(out, make([]float32, r.models[n].Dim())...)
+		}
+	}
+	return out
+}
+
+// Embed embeds text with the default model.
+func (r *Router) Embed(text string) ([]float32, error) {
+	return r.models[""].Embed(text)
+}
+
+// Dim returns the dimension of the default model's vectors.
+func (r *Router) Dim() int {
+	return r.models[""].Dim()
+}
+
+// Close closes the models.
+func (r *Router) Close() error {
+	var err error
+	for _, m := range r.models {
+		if cerr := m.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
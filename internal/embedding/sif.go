@@ -0,0 +1,105 @@
+package embedding
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// Smoothing parameter a of the SIF weights a / (a + p(w)), as recommended by
+// Arora et al., "A Simple but Tough-to-Beat Baseline for Sentence Embeddings".
+const sifSmoothing = 1e-3
+
+// Number of power iterations of FirstComponent.
+const componentIterations = 50
+
+// SIFWeight returns the smooth inverse frequency weight of word,
+// a / (a + p(word)), where p(word) is the frequency of the word among the
+// distinct words of the documents.
+func (idf *IDF) SIFWeight(word string) float32 {
+	p := 0.0
+	if idf.words > 0 {
+		p = float64(idf.df[strings.ToLower(word)]) / float64(idf.words)
+	}
+	return float32(sifSmoothing / (sifSmoothing + p))
+}
+
+// FirstComponent returns the first principal component (uncentered) of the
+// vectors of dimension dim, the unit vector u maximizing the sum of (u·v)² over
+// the vectors v, computed by power iteration.
+//
+// Returns a zero vector if all vectors are zero.
+func FirstComponent(vecs [][]float32, dim int) []float32 {
+	r := rand.New(rand.NewSource(1))
+	u := make([]float32, dim)
+	for i := range u {
+		u[i] = float32(r.NormFloat64())
+	}
+	vec32.Normalize(u)
+
+	next := make([]float32, dim)
+	for iter := 0; iter < componentIterations; iter++ {
+		for i := range next {
+			next[i] = 0
+		}
+		for _, v := range vecs {
+			d := vec32.Dot(u, v)
+			for i, x := range v {
+				next[i] += d * x
+			}
+		}
+		if vec32.Norm(next) == 0 {
+			return next
+		}
+		vec32.Normalize(next)
+		u, next = next, u
+	}
+	return u
+}
+
+// RemoveComponent removes the projection of vec onto the unit vector u.
+func RemoveComponent(vec, u []float32) {
+	d := vec32.Dot(vec, u)
+	for i, x := range u {
+		vec[i] -= d * x
+	}
+}
+
+// componentFingerprint returns a hash of the common component u, which
+// identifies the embeddings it was removed from in the embedding cache.
+func componentFingerprint(u []float32) string {
+	h := fnv.New64a()
+	h.Write(vec32.Bytes(u))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// LoadComponent loads the common component saved by SaveComponent from the
+// sif_component table of the Open Data Link database at path.
+func LoadComponent(path string) ([]float32, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var data []byte
+	err = db.QueryRow(`SELECT emb FROM sif_component`).Scan(&data)
+	if err != nil {
+		return nil, fmt.Errorf("loading SIF common component (run process_metadata with sif weighting first): %v", err)
+	}
+	return vec32.FromBytes(data)
+}
+
+// SaveComponent replaces the contents of the sif_component table with the
+// common component u.
+func SaveComponent(tx *sql.Tx, u []float32) error {
+	if _, err := tx.Exec(`DELETE FROM sif_component`); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO sif_component (emb) VALUES (?)`, vec32.Bytes(u))
+	return err
+}
@@ -0,0 +1,135 @@
+package embedding
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Words longer than this many characters are mapped to the unknown token, as
+// in BERT.
+const maxWordChars = 100
+
+// WordPiece is the WordPiece tokenizer of BERT-style models such as the
+// sentence-transformers models.
+//
+// Text is lowercased, split on whitespace and punctuation, and each word is
+// split into the longest vocabulary pieces, continuation pieces being prefixed
+// with "##". Accents are not stripped.
+type WordPiece struct {
+	vocab         map[string]int64
+	unk, cls, sep int64
+}
+
+// LoadWordPiece loads a WordPiece vocabulary (vocab.txt) with one token per
+// line, the ID of a token being its line number starting at 0.
+// The vocabulary must contain the [UNK], [CLS], and [SEP] tokens.
+func LoadWordPiece(path string) (*WordPiece, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &WordPiece{vocab: make(map[string]int64)}
+	s := bufio.NewScanner(f)
+	for id := int64(0); s.Scan(); id++ {
+		t.vocab[strings.TrimRight(s.Text(), "\r")] = id
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for _, special := range []struct {
+		token string
+		id    *int64
+	}{{"[UNK]", &t.unk}, {"[CLS]", &t.cls}, {"[SEP]", &t.sep}} {
+		id, ok := t.vocab[special.token]
+		if !ok {
+			return nil, fmt.Errorf("vocabulary %s has no %s token", path, special.token)
+		}
+		*special.id = id
+	}
+	return t, nil
+}
+
+// Encode returns the token IDs of text, starting with [CLS] and ending with
+// [SEP], truncated to at most maxTokens tokens (including [CLS] and [SEP]).
+func (t *WordPiece) Encode(text string, maxTokens int) []int64 {
+	ids := []int64{t.cls}
+	for _, word := range splitWords(strings.ToLower(text)) {
+		if len(ids) >= maxTokens-1 {
+			break
+		}
+		ids = append(ids, t.pieces(word)...)
+	}
+	if len(ids) > maxTokens-1 {
+		ids = ids[:maxTokens-1]
+	}
+	return append(ids, t.sep)
+}
+
+// pieces returns the token IDs of the longest vocabulary pieces of word, or the
+// unknown token if word cannot be split into vocabulary pieces.
+func (t *WordPiece) pieces(word string) []int64 {
+	runes := []rune(word)
+	if len(runes) > maxWordChars {
+		return []int64{t.unk}
+	}
+	var ids []int64
+	for start := 0; start < len(runes); {
+		end := len(runes)
+		for ; end > start; end-- {
+			piece := string(runes[start:end])
+			if start > 0 {
+				piece = "##" + piece
+			}
+			if id, ok := t.vocab[piece]; ok {
+				ids = append(ids, id)
+				break
+			}
+		}
+		if end == start {
+			return []int64{t.unk}
+		}
+		start = end
+	}
+	return ids
+}
+
+// splitWords splits text on whitespace, and splits punctuation and CJK
+// characters into words of their own. Control characters are removed.
+func splitWords(text string) []string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == 0 || r == unicode.ReplacementChar || unicode.IsControl(r):
+		case isPunct(r) || unicode.Is(unicode.Han, r):
+			flush()
+			words = append(words, string(r))
+		default:
+			word = append(word, r)
+		}
+	}
+	flush()
+	return words
+}
+
+// isPunct reports whether r is split off as punctuation. As in BERT, all
+// non-alphanumeric ASCII characters are punctuation.
+func isPunct(r rune) bool {
+	if r < 128 {
+		return r > ' ' && !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	}
+	return unicode.IsPunct(r)
+}
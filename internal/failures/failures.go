@@ -0,0 +1,173 @@
+// Package failures records the datasets that the ingestion commands fail to
+// process. By default a dataset that cannot be processed is skipped, so that
+// one bad dataset does not abort the whole run, and its failure is reported
+// at the end of the run in the log, in the ingestion_errors table if the
+// database has one, and optionally in a file of JSON lines. With
+// -on-error=fail, the first failure aborts the run instead.
+package failures
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+var (
+	onError    = flag.String("on-error", "skip", "what to do with a dataset that cannot be processed: skip it and report the failure, or fail the run")
+	errorsPath = flag.String("errors-file", "", "write the datasets that could not be processed as JSON lines to `file`")
+)
+
+// Failure is the failure of a command to process a dataset.
+type Failure struct {
+	Command   string    `json:"command"`
+	DatasetID string    `json:"dataset_id"`
+	Error     string    `json:"error"`
+	Time      time.Time `json:"time"`
+}
+
+// A Recorder records the failures of a command to process datasets. It is safe
+// for concurrent use.
+type Recorder struct {
+	command  string
+	failFast bool
+
+	mu       sync.Mutex
+	failures []*Failure
+}
+
+// Start starts recording the failures of the named command, with the -on-error
+// policy. The flags must have been parsed.
+func Start(command string) (*Recorder, error) {
+	r := &Recorder{command: command}
+	switch *onError {
+	case "skip":
+	case "fail":
+		r.failFast = true
+	default:
+		return nil, fmt.Errorf("unknown -on-error policy %q (want skip or fail)", *onError)
+	}
+	return r, nil
+}
+
+// Fail records that the dataset with the given ID could not be processed, and
+// logs the error. If the -on-error policy is to fail, the failure is written
+// to the -errors-file and the command exits.
+func (r *Recorder) Fail(datasetID string, err error) {
+	f := &Failure{Command: r.command, DatasetID: datasetID, Error: err.Error(), Time: time.Now().UTC()}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, f)
+	if r.failFast {
+		if err := r.writeFile(); err != nil {
+			log.Print(err)
+		}
+		log.Fatalf("dataset %v: %v", datasetID, err)
+	}
+	log.Printf("dataset %v: %v (skipped)", datasetID, err)
+}
+
+// Finish logs the number of failures and writes them to the -errors-file. If db
+// has an ingestion_errors table, the failures also replace the ones recorded in
+// it by the previous run of the command, unless dryRun is set.
+func (r *Recorder) Finish(db *sql.DB, dryRun bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.SliceStable(r.failures, func(i, j int) bool {
+		return r.failures[i].DatasetID < r.failures[j].DatasetID
+	})
+	if len(r.failures) > 0 {
+		log.Printf("%d datasets could not be processed", r.datasets())
+	}
+	if err := r.writeFile(); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	if ok, err := database.HasTable(db, "ingestion_errors"); err != nil || !ok {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := r.writeTable(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Datasets returns the number of datasets with failures.
+func (r *Recorder) Datasets() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.datasets()
+}
+
+// datasets returns the number of datasets with failures. r.mu must be held.
+func (r *Recorder) datasets() int {
+	ids := make(map[string]bool)
+	for _, f := range r.failures {
+		ids[f.DatasetID] = true
+	}
+	return len(ids)
+}
+
+// writeFile writes the failures to the -errors-file, if it is set. r.mu must
+// be held.
+func (r *Recorder) writeFile() error {
+	if *errorsPath == "" {
+		return nil
+	}
+	f, err := os.Create(*errorsPath)
+	if err != nil {
+		return err
+	}
+	if err := write(f, r.failures); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// write writes failures to w as JSON lines.
+func write(w io.Writer, failures []*Failure) error {
+	enc := json.NewEncoder(w)
+	for _, f := range failures {
+		if err := enc.Encode(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTable replaces the failures of the command in the ingestion_errors
+// table. r.mu must be held.
+func (r *Recorder) writeTable(tx *sql.Tx) error {
+	if _, err := tx.Exec(`DELETE FROM ingestion_errors WHERE command = ?`, r.command); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`
+	INSERT INTO ingestion_errors (command, dataset_id, error, failed_at) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, f := range r.failures {
+		_, err := stmt.Exec(f.Command, f.DatasetID, f.Error, f.Time.Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package failures
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestFinish(t *testing.T) {
+	dir, err := ioutil.TempDir("", "failures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	schema, err := ioutil.ReadFile("../../sql/create_ingestion_errors_table.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+	INSERT INTO ingestion_errors (command, dataset_id, error) VALUES
+	('sketch_columns', 'old-0001', 'previous run'),
+	('process_values', 'old-0002', 'other command')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Start("sketch_columns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Fail("bbbb-0002", errors.New("second"))
+	r.Fail("aaaa-0001", errors.New("first"))
+	if err := r.Finish(db, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Finish(db, false); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query(`SELECT command, dataset_id, error FROM ingestion_errors ORDER BY rowid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got [][3]string
+	for rows.Next() {
+		var row [3]string
+		if err := rows.Scan(&row[0], &row[1], &row[2]); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+	want := [][3]string{
+		{"process_values", "old-0002", "other command"},
+		{"sketch_columns", "aaaa-0001", "first"},
+		{"sketch_columns", "bbbb-0002", "second"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got rows %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got row %v, want %v", got[i], want[i])
+		}
+	}
+}
+
+func TestStartPolicy(t *testing.T) {
+	defer func(policy string) { *onError = policy }(*onError)
+	*onError = "retry"
+	if _, err := Start("sketch_columns"); err == nil {
+		t.Error("got no error for an unknown policy")
+	}
+}
@@ -0,0 +1,466 @@
+// Package harvest stores the datasets harvested from data catalogs, such as
+// Socrata portals, for the ingestion pipeline. A Writer writes the metadata of
+// each dataset, and a sample of its rows, to the datasets directory in the
+// layout of the crawler (see package layout), and stores the metadata in the
+// metadata table, so that the dataset pages are available before the datasets
+// are processed. If the database has the tables, it also stores the columns
+// declared by the catalog in the source_columns table, the files of the
+// dataset listed by the catalog in the source_distributions table, and the
+// catalog of each dataset in the harvested_datasets table (see
+// sql/create_harvest_tables.sql), and the objects of the datasets harvested
+// from cloud storage buckets in the source_objects table (see
+// sql/create_source_objects_table.sql).
+//
+// The datasets whose files changed are marked for processing: their
+// checksums are deleted from the dataset_checksums table, if any, so that the
+// ingestion commands run with -changed-only process, and embed, them again
+// (see package checksum).
+package harvest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+// Number of datasets whose rows are committed together
+const datasetsPerCommit = 100
+
+// IDs that are valid dataset IDs, and names of dataset directories
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidID reports whether id is a valid dataset ID, which names a directory
+// of the datasets directory: letters, digits, dots, underscores, and hyphens,
+// starting with a letter or a digit. The IDs of the harvested datasets are
+// those of their sources, which are not trusted to be paths within the
+// datasets directory.
+func ValidID(id string) bool {
+	return validID.MatchString(id)
+}
+
+// Dataset is a dataset harvested from a catalog.
+type Dataset struct {
+	*database.Metadata
+	// Catalog the dataset was harvested from, such as
+	// socrata:data.cityofnewyork.us.
+	Source string
+	// Columns declared by the catalog, in their order.
+	Columns []*Column
+	// Files of the dataset listed by the catalog.
+	Distributions []*Distribution
+	// Contents of the metadata.json file of the dataset, in the format of
+	// the Socrata Discovery API results read by process_metadata. If nil,
+	// the file is generated from Metadata (see MetadataFile).
+	MetadataFile []byte
+	// Name of the file of the rows of the dataset, such as rows.parquet
+	// (see package layout), or rows.csv if empty.
+	RowsFile string
+	// Object of a cloud storage bucket the dataset was harvested from, if
+	// any.
+	Object *Object
+}
+
+// Column is a column of a dataset, as declared by its catalog.
+type Column struct {
+	// Display name of the column.
+	Name string
+	// Name of the column in the files of the dataset, if it differs.
+	FieldName string
+	// Type of the column, as named by the catalog.
+	Type        string
+	Description string
+}
+
+// Distribution is a file of a dataset, such as a CKAN resource, listed by its
+// catalog.
+type Distribution struct {
+	Name string
+	URL  string
+	// Format of the file, as named by the catalog, such as CSV.
+	Format      string
+	Description string
+}
+
+// Object is an object of a cloud storage bucket, such as an S3 object.
+type Object struct {
+	// URL of the bucket, such as s3://bucket.
+	Bucket string
+	Key    string
+	// Entity tag of the contents of the object, which changes with them.
+	ETag string
+	Size int64
+	// Time the object was last modified, in RFC 3339 format.
+	Modified string
+}
+
+// Writer writes harvested datasets.
+type Writer struct {
+	dir   string
+	chunk *database.Chunker
+	// Whether the database has the optional tables.
+	columns, distributions, harvested, checksums, objects bool
+	// Update times of the harvested datasets, by ID, loaded by Incremental.
+	updated map[string]string
+}
+
+// Start returns a Writer of datasets to the datasets directory dir and the
+// database db, which must have a metadata table.
+func Start(db *sql.DB, dir string) (*Writer, error) {
+	w := &Writer{dir: dir, chunk: &database.Chunker{DB: db, Size: datasetsPerCommit}}
+	if ok, err := database.HasTable(db, "metadata"); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("no metadata table (see sql/create_metadata_tables.sql)")
+	}
+	for table, ok := range map[string]*bool{
+		"source_columns":       &w.columns,
+		"source_distributions": &w.distributions,
+		"harvested_datasets":   &w.harvested,
+		"dataset_checksums":    &w.checksums,
+		"source_objects":       &w.objects,
+	} {
+		var err error
+		if *ok, err = database.HasTable(db, table); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Incremental loads the update times of the datasets harvested before, those
+// of the harvested_datasets table, for Unchanged.
+func (w *Writer) Incremental() error {
+	if !w.harvested {
+		return fmt.Errorf("no harvested_datasets table (see sql/create_harvest_tables.sql)")
+	}
+	rows, err := w.chunk.DB.Query(`
+	SELECT m.dataset_id, m.updated_at FROM metadata m
+	JOIN harvested_datasets h ON h.dataset_id = m.dataset_id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w.updated = make(map[string]string)
+	for rows.Next() {
+		var id, updatedAt string
+		if err := rows.Scan(&id, &updatedAt); err != nil {
+			return err
+		}
+		w.updated[id] = updatedAt
+	}
+	return rows.Err()
+}
+
+// Unchanged reports whether the dataset of the ID was harvested before, with
+// the same update time, from its source, and its metadata file is still in the
+// datasets directory, so that an incremental harvest may skip it. It is false
+// for all datasets unless Incremental was called, and for the datasets without
+// an update time.
+func (w *Writer) Unchanged(datasetID, updatedAt string) bool {
+	if updatedAt == "" || w.updated[datasetID] != updatedAt || !ValidID(datasetID) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(w.dir, datasetID, "metadata.json"))
+	return err == nil
+}
+
+// Write writes d and, if rows is not nil, the sample of its rows read from
+// rows, as rows.csv or d.RowsFile. It reports whether the files of the
+// dataset changed. The rows of the database are committed in chunks of
+// datasets; the last ones by Close. It fails for the datasets whose IDs are
+// not valid (see ValidID).
+func (w *Writer) Write(d *Dataset, rows io.Reader) (changed bool, err error) {
+	if !ValidID(d.DatasetID) {
+		return false, fmt.Errorf("invalid dataset ID %q", d.DatasetID)
+	}
+	dir := filepath.Join(w.dir, d.DatasetID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, err
+	}
+	meta := d.MetadataFile
+	if meta == nil {
+		if meta, err = MetadataFile(d.Metadata); err != nil {
+			return false, err
+		}
+	}
+	if changed, err = writeFile(filepath.Join(dir, "metadata.json"), bytes.NewReader(meta)); err != nil {
+		return false, err
+	}
+	if rows != nil {
+		name := d.RowsFile
+		if name == "" {
+			name = "rows.csv"
+		}
+		c, err := writeFile(filepath.Join(dir, name), rows)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || c
+	}
+	err = w.chunk.Write(func(tx *sql.Tx) error {
+		return w.store(tx, d, changed)
+	})
+	if err != nil {
+		return false, err
+	}
+	return changed, w.chunk.Done()
+}
+
+// Remove removes the dataset of the ID, harvested from a source it was
+// removed from: its directory, and its rows of the tables of the harvested
+// datasets. Its metadata, and the data of the pipeline, are left to
+// prune_removed. The rows are committed with those of the datasets written.
+func (w *Writer) Remove(datasetID string) error {
+	if !ValidID(datasetID) {
+		return fmt.Errorf("invalid dataset ID %q", datasetID)
+	}
+	if err := os.RemoveAll(filepath.Join(w.dir, datasetID)); err != nil {
+		return err
+	}
+	err := w.chunk.Write(func(tx *sql.Tx) error {
+		for table, ok := range map[string]bool{
+			"source_columns":       w.columns,
+			"source_distributions": w.distributions,
+			"harvested_datasets":   w.harvested,
+			"source_objects":       w.objects,
+		} {
+			if !ok {
+				continue
+			}
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE dataset_id = ?`, datasetID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return w.chunk.Done()
+}
+
+// Close commits the datasets written since the last commit.
+func (w *Writer) Close() error {
+	return w.chunk.Close()
+}
+
+// store stores the rows of d in the database, deleting its checksums if its
+// files changed.
+func (w *Writer) store(tx *sql.Tx, d *Dataset, changed bool) error {
+	_, err := tx.Exec(`
+	INSERT OR REPLACE INTO metadata
+	(dataset_id, name, description, attribution, contact_email, updated_at, categories, tags, permalink)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.DatasetID,
+		d.Name,
+		d.Description,
+		d.Attribution,
+		d.ContactEmail,
+		d.UpdatedAt,
+		strings.Join(d.Categories, ","),
+		strings.Join(d.Tags, ","),
+		d.Permalink)
+	if err != nil {
+		return err
+	}
+	if w.columns {
+		if _, err := tx.Exec(`DELETE FROM source_columns WHERE dataset_id = ?`, d.DatasetID); err != nil {
+			return err
+		}
+		for i, c := range d.Columns {
+			_, err := tx.Exec(`
+			INSERT INTO source_columns (dataset_id, position, name, field_name, type, description)
+			VALUES (?, ?, ?, ?, ?, ?)`, d.DatasetID, i, c.Name, c.FieldName, c.Type, c.Description)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if w.distributions {
+		if _, err := tx.Exec(`DELETE FROM source_distributions WHERE dataset_id = ?`, d.DatasetID); err != nil {
+			return err
+		}
+		for i, f := range d.Distributions {
+			_, err := tx.Exec(`
+			INSERT INTO source_distributions (dataset_id, position, name, url, format, description)
+			VALUES (?, ?, ?, ?, ?, ?)`, d.DatasetID, i, f.Name, f.URL, f.Format, f.Description)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if w.harvested {
+		_, err := tx.Exec(`
+		INSERT OR REPLACE INTO harvested_datasets (dataset_id, source) VALUES (?, ?)`,
+			d.DatasetID, d.Source)
+		if err != nil {
+			return err
+		}
+	}
+	if o := d.Object; o != nil && w.objects {
+		_, err := tx.Exec(`
+		INSERT OR REPLACE INTO source_objects (dataset_id, bucket, key, etag, size, modified)
+		VALUES (?, ?, ?, ?, ?, ?)`, d.DatasetID, o.Bucket, o.Key, o.ETag, o.Size, o.Modified)
+		if err != nil {
+			return err
+		}
+	}
+	if changed && w.checksums {
+		if _, err := tx.Exec(`DELETE FROM dataset_checksums WHERE dataset_id = ?`, d.DatasetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes the contents read from r to the file at path, through a
+// temporary file, so that a failed harvest keeps the previous file. It
+// reports whether the contents differ from those of the previous file.
+func writeFile(path string, r io.Reader) (changed bool, err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if old, err := fileHash(path); err == nil && bytes.Equal(old, h.Sum(nil)) {
+		return false, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), path)
+}
+
+// fileHash returns the SHA-256 hash of the file at path.
+func fileHash(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Objects returns the objects of the bucket recorded in the source_objects
+// table, whose keys start with the prefix, by the IDs of their datasets.
+func Objects(db *sql.DB, bucket, prefix string) (map[string]*Object, error) {
+	if ok, err := database.HasTable(db, "source_objects"); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("no source_objects table (see sql/create_source_objects_table.sql)")
+	}
+	rows, err := db.Query(`
+	SELECT dataset_id, key, etag, size, modified FROM source_objects
+	WHERE bucket = ? AND substr(key, 1, ?) = ?`, bucket, len(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	objects := make(map[string]*Object)
+	for rows.Next() {
+		var id string
+		o := &Object{Bucket: bucket}
+		if err := rows.Scan(&id, &o.Key, &o.ETag, &o.Size, &o.Modified); err != nil {
+			return nil, err
+		}
+		objects[id] = o
+	}
+	return objects, rows.Err()
+}
+
+// discoveryResult is the subset of the Socrata Discovery API result format
+// read by process_metadata.
+type discoveryResult struct {
+	Resource struct {
+		Name         string `json:"name"`
+		ID           string `json:"id"`
+		Description  string `json:"description"`
+		Attribution  string `json:"attribution"`
+		ContactEmail string `json:"contact_email"`
+		UpdatedAt    string `json:"updatedAt"`
+	} `json:"resource"`
+	Classification struct {
+		Categories []string `json:"categories"`
+		Tags       []string `json:"tags"`
+	} `json:"classification"`
+	Permalink string `json:"permalink"`
+}
+
+// MetadataFile returns the metadata file of the dataset of m, in the format
+// of the Socrata Discovery API results read by process_metadata.
+func MetadataFile(m *database.Metadata) ([]byte, error) {
+	var r discoveryResult
+	r.Resource.Name = m.Name
+	r.Resource.ID = m.DatasetID
+	r.Resource.Description = m.Description
+	r.Resource.Attribution = m.Attribution
+	r.Resource.ContactEmail = m.ContactEmail
+	r.Resource.UpdatedAt = m.UpdatedAt
+	r.Classification.Categories = m.Categories
+	r.Classification.Tags = m.Tags
+	r.Permalink = m.Permalink
+	return json.MarshalIndent(&r, "", "  ")
+}
+
+// SampleCSV returns the header and the first n rows of the CSV table read
+// from r, or the whole table if n is 0. The rows may have different numbers of
+// fields.
+func SampleCSV(r io.Reader, n int) ([]byte, error) {
+	cr := csv.NewReader(r)
+	cr.LazyQuotes = true
+	cr.FieldsPerRecord = -1
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	for i := 0; n == 0 || i <= n; i++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := cw.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// Unique returns the non-blank values, without the later duplicates of
+// values in any case, as process_metadata merges categories and tags.
+func Unique(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, v := range values {
+		lower := strings.ToLower(strings.TrimSpace(v))
+		if lower == "" || seen[lower] {
+			continue
+		}
+		seen[lower] = true
+		unique = append(unique, v)
+	}
+	return unique
+}
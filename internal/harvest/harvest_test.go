@@ -0,0 +1,166 @@
+package harvest
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "harvest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, file := range []string{"create_metadata_tables.sql", "create_harvest_tables.sql", "create_dataset_checksums_table.sql"} {
+		schema, err := ioutil.ReadFile("../../sql/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(schema)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.Exec(`
+	INSERT INTO dataset_checksums (command, dataset_id, file, checksum) VALUES
+	('sketch_columns', 'abcd-1234', 'rows.csv', 'old')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Dataset{
+		Metadata: &database.Metadata{
+			DatasetID:  "abcd-1234",
+			Name:       "Schools",
+			Categories: Unique([]string{"Education", "", "education"}),
+		},
+		Source:  "socrata:data.example.org",
+		Columns: []*Column{{Name: "School Name", FieldName: "school_name", Type: "text"}},
+	}
+	datasets := filepath.Join(dir, "datasets")
+	// The checksums of the changed dataset are deleted, and those of the
+	// unchanged dataset kept.
+	for i, want := range []struct {
+		changed   bool
+		checksums int
+	}{{true, 0}, {false, 1}} {
+		w, err := Start(db, datasets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changed, err := w.Write(d, strings.NewReader("school_name\nPS 1\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var checksums int
+		if err := db.QueryRow(`SELECT count(*) FROM dataset_checksums`).Scan(&checksums); err != nil {
+			t.Fatal(err)
+		}
+		if changed != want.changed || checksums != want.checksums {
+			t.Errorf("write %d: got changed %v and %d checksums, want %v and %d",
+				i+1, changed, checksums, want.changed, want.checksums)
+		}
+		_, err = db.Exec(`
+		INSERT OR REPLACE INTO dataset_checksums (command, dataset_id, file, checksum) VALUES
+		('sketch_columns', 'abcd-1234', 'rows.csv', 'new')`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := (&database.DB{DB: db}).Metadata("abcd-1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "Schools" || len(m.Categories) != 1 || m.Categories[0] != "Education" {
+		t.Errorf("got metadata %+v", m)
+	}
+	var name, fieldName, source string
+	err = db.QueryRow(`SELECT name, field_name FROM source_columns WHERE dataset_id = 'abcd-1234'`).Scan(&name, &fieldName)
+	if err != nil || name != "School Name" || fieldName != "school_name" {
+		t.Errorf("got source column %q, %q, %v", name, fieldName, err)
+	}
+	if err := db.QueryRow(`SELECT source FROM harvested_datasets`).Scan(&source); err != nil || source != d.Source {
+		t.Errorf("got source %q, %v", source, err)
+	}
+	meta, err := ioutil.ReadFile(filepath.Join(datasets, "abcd-1234", "metadata.json"))
+	if err != nil || !strings.Contains(string(meta), `"id": "abcd-1234"`) {
+		t.Errorf("got metadata file %s, %v", meta, err)
+	}
+
+	// An incremental harvest skips the datasets of the same update time.
+	d.UpdatedAt = "2021-06-01T00:00:00Z"
+	w, err := Start(db, datasets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(d, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Unchanged("abcd-1234", d.UpdatedAt) {
+		t.Error("dataset unchanged before Incremental")
+	}
+	if err := w.Incremental(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		id, updatedAt string
+		want          bool
+	}{
+		{"abcd-1234", "2021-06-01T00:00:00Z", true},
+		{"abcd-1234", "2021-07-01T00:00:00Z", false},
+		{"efgh-5678", "2021-06-01T00:00:00Z", false},
+	} {
+		if got := w.Unchanged(tt.id, tt.updatedAt); got != tt.want {
+			t.Errorf("Unchanged(%v, %v) = %v, want %v", tt.id, tt.updatedAt, got, tt.want)
+		}
+	}
+
+	// The IDs of the sources are not written outside the datasets
+	// directory.
+	for _, id := range []string{"", "../../x", "../x", "..", "/tmp/x", "a/b", `a\b`, ".x"} {
+		bad := &Dataset{Metadata: &database.Metadata{DatasetID: id, Name: "Escape"}}
+		if _, err := w.Write(bad, strings.NewReader("a\n1\n")); err == nil {
+			t.Errorf("dataset of ID %q written", id)
+		}
+		if err := w.Remove(id); err == nil {
+			t.Errorf("dataset of ID %q removed", id)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x")); !os.IsNotExist(err) {
+		t.Errorf("dataset written outside the datasets directory (%v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(datasets, "abcd-1234")); err != nil {
+		t.Errorf("dataset removed by the removal of an invalid ID: %v", err)
+	}
+}
+
+func TestSampleCSV(t *testing.T) {
+	in := "a,b\n1,2\n3\n5,6\n"
+	for _, tt := range []struct {
+		n    int
+		want string
+	}{{2, "a,b\n1,2\n3\n"}, {0, in}, {9, in}} {
+		got, err := SampleCSV(strings.NewReader(in), tt.n)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("SampleCSV(%q, %d) = %q, %v, want %q", in, tt.n, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Package hooks runs the ingestion hooks of the ingestion commands, shell
+// commands given by the -pre-hook and -post-hook flags, so that deployments
+// can clean, scrub, or enrich the datasets without changing the commands.
+//
+// The pre-processing hook filters each dataset file read by a command: it is
+// run with the decompressed contents of the file on its standard input, and
+// the command ingests what it writes to its standard output instead. The
+// post-processing hook is run after the results of each dataset are
+// committed, for instance to enrich the database with them. The hooks are run
+// with sh -c, with their standard error that of the command, and with the
+// environment of the command and these variables:
+//
+//	ODL_COMMAND     the ingestion command, e.g. sketch_columns
+//	ODL_DATASET_ID  the ID of the dataset
+//	ODL_FILE        the conventional name of the file, e.g. rows.csv (pre-hook)
+//	ODL_PATH        the path of the file, if it is read from one (pre-hook)
+//
+// A hook that exits with a non-zero status fails its dataset, which is
+// recorded in the command's failures (see package failures).
+package hooks
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+)
+
+var (
+	preHook  = flag.String("pre-hook", "", "shell `command` filtering each dataset file before it is ingested (see package hooks)")
+	postHook = flag.String("post-hook", "", "shell `command` run after the results of each dataset are committed")
+)
+
+// Hooks runs the hooks of an ingestion command. It is safe for concurrent
+// use.
+type Hooks struct {
+	command   string
+	pre, post string
+	dryRun    bool
+	fails     *failures.Recorder
+	mu        sync.Mutex
+	// Datasets stored since the last commit.
+	stored []string
+}
+
+// Start returns the hooks of the -pre-hook and -post-hook flags for the
+// ingestion command, recording the datasets whose post-processing hooks fail
+// in fails. The post-processing hook is not run if dryRun is set. The flags
+// must have been parsed.
+func Start(command string, fails *failures.Recorder, dryRun bool) *Hooks {
+	return &Hooks{command: command, pre: *preHook, post: *postHook, dryRun: dryRun, fails: fails}
+}
+
+// env returns the environment of a hook for the dataset with the given ID and
+// the variables of vars, pairs of names and values.
+func (h *Hooks) env(datasetID string, vars ...string) []string {
+	env := append(os.Environ(), "ODL_COMMAND="+h.command, "ODL_DATASET_ID="+datasetID)
+	for i := 0; i+1 < len(vars); i += 2 {
+		env = append(env, vars[i]+"="+vars[i+1])
+	}
+	return env
+}
+
+// Open opens the file at path of the dataset with the given ID, whose
+// conventional name is name, decompressing it (see datafile.Open), and reads
+// it through the pre-processing hook if there is one.
+func (h *Hooks) Open(datasetID, name, path string) (io.ReadCloser, error) {
+	f, err := datafile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if h.pre == "" {
+		return f, nil
+	}
+	r, err := h.filter(f, h.env(datasetID, "ODL_FILE", name, "ODL_PATH", path))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Filter reads the contents read from r, those of the file of the dataset
+// with the given ID whose conventional name is name, through the
+// pre-processing hook if there is one. Closing the returned reader does not
+// close r.
+func (h *Hooks) Filter(datasetID, name string, r io.Reader) (io.ReadCloser, error) {
+	if h.pre == "" {
+		return ioutil.NopCloser(r), nil
+	}
+	return h.filter(ioutil.NopCloser(r), h.env(datasetID, "ODL_FILE", name))
+}
+
+// RandomFile is a dataset file read at random.
+type RandomFile interface {
+	io.ReaderAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// OpenRandom opens the file at path of the dataset with the given ID, whose
+// conventional name is name, to be read at random. If there is a
+// pre-processing hook, the file is filtered into a temporary file, which is
+// removed when it is closed.
+func (h *Hooks) OpenRandom(datasetID, name, path string) (RandomFile, error) {
+	if h.pre == "" {
+		return os.Open(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := h.filter(f, h.env(datasetID, "ODL_FILE", name, "ODL_PATH", path))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	defer r.Close()
+	tmp, err := ioutil.TempFile("", "odl-hook-*-"+name)
+	if err != nil {
+		return nil, err
+	}
+	t := &tempFile{tmp}
+	if _, err := io.Copy(tmp, r); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// tempFile is a temporary file removed when it is closed.
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	if rerr := os.Remove(t.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// filter starts the pre-processing hook with the environment env and the
+// contents of in on its standard input, and returns the reader of its output,
+// which closes in when it is closed.
+func (h *Hooks) filter(in io.ReadCloser, env []string) (io.ReadCloser, error) {
+	cmd := exec.Command("sh", "-c", h.pre)
+	cmd.Env = env
+	cmd.Stdin = in
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("pre-hook: %v", err)
+	}
+	return &hookReader{cmd: cmd, out: out, in: in}, nil
+}
+
+// hookReader reads the output of a running pre-processing hook.
+type hookReader struct {
+	cmd *exec.Cmd
+	out io.ReadCloser
+	in  io.Closer
+	// Whether the hook has exited, and its error.
+	exited bool
+	err    error
+}
+
+// Read reads the output of the hook, returning the hook's error instead of
+// io.EOF if it failed, so that a failing hook fails its dataset rather than
+// truncating it.
+func (r *hookReader) Read(p []byte) (int, error) {
+	if r.exited {
+		if r.err != nil {
+			return 0, fmt.Errorf("pre-hook: %v", r.err)
+		}
+		return 0, io.EOF
+	}
+	n, err := r.out.Read(p)
+	if err == io.EOF {
+		r.exited = true
+		if r.err = r.cmd.Wait(); r.err != nil {
+			return n, fmt.Errorf("pre-hook: %v", r.err)
+		}
+	}
+	return n, err
+}
+
+// Close stops the hook, if it has not exited, and closes its input. The hook
+// is killed if the output was not read to its end, as when only the first
+// rows of a file are read.
+func (r *hookReader) Close() error {
+	if !r.exited {
+		r.cmd.Process.Kill()
+		r.out.Close()
+		r.cmd.Wait()
+	}
+	return r.in.Close()
+}
+
+// Stored records that the results of the dataset with the given ID were
+// written in the current transaction, for the post-processing hook to be run
+// once it is committed.
+func (h *Hooks) Stored(datasetID string) {
+	if h.post == "" || h.dryRun {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stored = append(h.stored, datasetID)
+}
+
+// Committed runs the post-processing hook for each dataset stored since the
+// last call, after the transaction of their results is committed. The datasets
+// whose hooks fail are recorded in the failures.
+func (h *Hooks) Committed() {
+	h.mu.Lock()
+	stored := h.stored
+	h.stored = nil
+	h.mu.Unlock()
+	for _, id := range stored {
+		cmd := exec.Command("sh", "-c", h.post)
+		cmd.Env = h.env(id)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			h.fails.Fail(id, fmt.Errorf("post-hook: %v", err))
+		}
+	}
+}
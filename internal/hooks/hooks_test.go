@@ -0,0 +1,66 @@
+package hooks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rows.csv")
+	if err := ioutil.WriteFile(path, []byte("name,ssn\nalice,123-45-6789\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Hooks{command: "sketch_columns", pre: `sed "s/[0-9]\{3\}-[0-9]\{2\}-[0-9]\{4\}/$ODL_DATASET_ID:$ODL_FILE/"`}
+	r, err := h.Open("abcd-1234", "rows.csv", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if want := "name,ssn\nalice,abcd-1234:rows.csv\n"; string(got) != want || err != nil {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+
+	f, err := h.OpenRandom("abcd-1234", "rows.csv", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil || int(info.Size()) != len("name,ssn\nalice,abcd-1234:rows.csv\n") {
+		t.Errorf("got %v, %v for the filtered file", info, err)
+	}
+	f.Close()
+	if _, err := os.Stat(f.(*tempFile).Name()); !os.IsNotExist(err) {
+		t.Errorf("the filtered file was not removed: %v", err)
+	}
+
+	h.pre = "cat; exit 3"
+	if r, err = h.Open("abcd-1234", "rows.csv", path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("got %v for a failing hook, want its exit status", err)
+	}
+	r.Close()
+
+	// A hook whose output is not read to its end is stopped.
+	h.pre = "yes"
+	if r, err = h.Open("abcd-1234", "rows.csv", path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("got %v closing a running hook", err)
+	}
+}
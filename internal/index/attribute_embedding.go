@@ -2,57 +2,199 @@
 package index
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
-	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
 	"github.com/DataIntelligenceCrew/go-faiss"
 )
 
 // AttributeIndex is an index over the attribute embedding vectors.
 type AttributeIndex struct {
-	idx *faiss.IndexFlat
+	idx flatSearcher
 	// Maps ID of vector in index to dataset ID.
 	idMap []string
+	// Maps ID of vector in index to attribute name.
+	attributeNames []string
+}
+
+// AttributeMatch is an attribute matching an attribute index query.
+type AttributeMatch struct {
+	DatasetID     string
+	AttributeName string
+	// Cosine similarity of the attribute to the query.
+	Similarity float32
+}
+
+// DatasetMatch is a dataset matching an attribute index query.
+type DatasetMatch struct {
+	DatasetID string
+	// Score combining the similarities of the matching attributes.
+	Score float32
+	// The dataset's matching attributes, sorted by similarity.
+	Attributes []*AttributeMatch
+}
+
+// Aggregation is a way of combining the similarities of a dataset's matching
+// attributes into a single dataset score.
+type Aggregation int
+
+const (
+	// AggregateMax scores a dataset by its most similar attribute.
+	AggregateMax Aggregation = iota
+	// AggregateMean scores a dataset by the mean similarity of its matching
+	// attributes.
+	AggregateMean
+	// AggregateCountWeighted scores a dataset by the sum of the similarities of
+	// its matching attributes, i.e. the mean weighted by the number of matches,
+	// favoring datasets with many similar attributes.
+	AggregateCountWeighted
+)
+
+var aggregationNames = map[string]Aggregation{
+	"max":   AggregateMax,
+	"mean":  AggregateMean,
+	"count": AggregateCountWeighted,
+}
+
+// ParseAggregation returns the Aggregation named "max", "mean", or "count"
+// (count-weighted).
+func ParseAggregation(name string) (Aggregation, error) {
+	agg, ok := aggregationNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown aggregation %q (want max, mean, or count)", name)
+	}
+	return agg, nil
+}
+
+// Number of attribute neighbors fetched per requested dataset by
+// QueryDatasets, so that datasets with many matching attributes do not use up
+// the candidates.
+const attributeOversample = 10
+
+// flatSearcher is an exact inner product search over vectors numbered in the
+// order they were added: a faiss flat index in memory or vectors in a
+// memory-mapped file.
+type flatSearcher interface {
+	D() int
+	// Search returns the similarities and numbers of the k nearest neighbors
+	// of each of the concatenated query vectors in x, padded with -1 labels.
+	Search(x []float32, k int64) ([]float32, []int64, error)
+	// rangeSearch returns the numbers and similarities of the vectors whose
+	// similarity with x is greater than minSim.
+	rangeSearch(x []float32, minSim float32) ([]int64, []float32, error)
+	Delete()
 }
 
-// BuildAttributeEmbeddingIndex builds a MetadataIndex.
-func BuildAttributeEmbeddingIndex(db *database.DB) (*MetadataIndex, error) {
-	index, err := faiss.NewIndexFlatIP(300)
+// faissFlat is a flatSearcher backed by a faiss flat index.
+type faissFlat struct {
+	*faiss.IndexFlat
+}
+
+func (idx faissFlat) rangeSearch(x []float32, minSim float32) ([]int64, []float32, error) {
+	res, err := idx.RangeSearch(x, minSim)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer res.Delete()
+
+	labels, dist := res.Labels()
+	return labels, dist, nil
+}
 
-	rows, err := db.Query(`SELECT dataset_id, attribute_name, emb FROM attribute_vectors`)
+// Queries of the dataset IDs, attribute names, and vectors of the attribute
+// name and attribute value embedding vectors.
+const (
+	attributeVectorsQuery = `SELECT dataset_id, attribute_name, emb FROM attribute_vectors`
+	valueVectorsQuery     = `SELECT dataset_id, column_name, emb FROM value_vectors`
+)
+
+// scanAttributeVectors calls f with each batch of rows of the attribute vectors
+// of the vectors table selected by query, in table order, with the normalized
+// vectors concatenated. The vectors are decoded in parallel, and zero vectors
+// are skipped (see decodeRows).
+func scanAttributeVectors(db *database.DB, vectors string, dim int, query string, f func(datasetIDs, attributeNames []string, vecs []float32) error) error {
+	rows, err := db.Query(query)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var idMap []string
-	var vecs []float32
+	return decodeRows(rows, vectors, 2, dim, func(keys [][]string, vecs []float32) error {
+		return f(keys[0], keys[1], vecs)
+	})
+}
 
-	for rows.Next() {
-		var datasetID string
-		var attributeName string
-		var emb []byte
+// BuildAttributeEmbeddingIndex builds an AttributeIndex over vectors of the
+// given dimension.
+func BuildAttributeEmbeddingIndex(db *database.DB, dim int) (*AttributeIndex, error) {
+	return buildAttributeIndex(db, "attribute_vectors", dim, attributeVectorsQuery)
+}
 
-		if err := rows.Scan(&datasetID, &attributeName, &emb); err != nil {
-			return nil, err
-		}
-		vec, err := vec32.FromBytes(emb)
-		if err != nil {
-			return nil, err
-		}
-		idMap = append(idMap, datasetID)
-		vecs = append(vecs, vec...)
+// BuildValueEmbeddingIndex builds an AttributeIndex over the column value
+// embedding vectors of the given dimension, created by process_values. The
+// attribute names of the matches are the column names.
+func BuildValueEmbeddingIndex(db *database.DB, dim int) (*AttributeIndex, error) {
+	return buildAttributeIndex(db, "value_vectors", dim, valueVectorsQuery)
+}
+
+// buildAttributeIndex builds an in-memory AttributeIndex over the vectors of
+// the vectors table selected by query (see scanAttributeVectors).
+func buildAttributeIndex(db *database.DB, vectors string, dim int, query string) (*AttributeIndex, error) {
+	index, err := faiss.NewIndexFlatIP(dim)
+	if err != nil {
+		return nil, err
 	}
-	if err := rows.Err(); err != nil {
+	idx := &AttributeIndex{idx: faissFlat{index}}
+
+	err = scanAttributeVectors(db, vectors, dim, query, func(datasetIDs, attributeNames []string, vecs []float32) error {
+		idx.idMap = append(idx.idMap, datasetIDs...)
+		idx.attributeNames = append(idx.attributeNames, attributeNames...)
+		return index.Add(vecs)
+	})
+	if err != nil {
+		index.Delete()
 		return nil, err
 	}
-	if err := index.Add(vecs); err != nil {
+	return idx, nil
+}
+
+// BuildOnDiskAttributeIndex builds an AttributeIndex over vectors of the given
+// dimension whose vectors are written to the file at path and memory-mapped
+// instead of being held in RAM, so that the index can exceed RAM at the cost of
+// query latency. Only the dataset IDs and attribute names are held in RAM.
+//
+// Any existing file at path is replaced.
+func BuildOnDiskAttributeIndex(db *database.DB, dim int, path string) (*AttributeIndex, error) {
+	w, err := createMmapVectors(path, dim)
+	if err != nil {
 		return nil, err
 	}
+	idx := &AttributeIndex{}
 
-	return &MetadataIndex{index, idMap}, nil
+	err = scanAttributeVectors(db, "attribute_vectors", dim, attributeVectorsQuery, func(datasetIDs, attributeNames []string, vecs []float32) error {
+		idx.idMap = append(idx.idMap, datasetIDs...)
+		idx.attributeNames = append(idx.attributeNames, attributeNames...)
+		for i := 0; i < len(vecs); i += dim {
+			if err := w.add(vecs[i : i+dim]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		w.close()
+		return nil, err
+	}
+	if err := w.close(); err != nil {
+		return nil, err
+	}
+	vecs, err := openMmapVectors(path)
+	if err != nil {
+		return nil, err
+	}
+	idx.idx = vecs
+	return idx, nil
 }
 
 // Delete frees the memory associated with the index.
@@ -65,7 +207,7 @@ func (idx *AttributeIndex) Delete() {
 // Returns the dataset IDs of the (up to) k nearest neighbors and the
 // corresponding cosine similarity, sorted by similarity.
 func (idx *AttributeIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
-	dist, ids, err := idx.idx.Search(vec, k)
+	dist, ids, err := idx.idx.Search(normalized(vec, idx.idx.D()), k)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -79,3 +221,108 @@ func (idx *AttributeIndex) Query(vec []float32, k int64) ([]string, []float32, e
 	}
 	return datasets, dist[:len(datasets)], nil
 }
+
+// QueryAttributes queries the index with vec.
+//
+// Returns the (up to) k nearest attributes, sorted by similarity.
+func (idx *AttributeIndex) QueryAttributes(vec []float32, k int64) ([]*AttributeMatch, error) {
+	dist, ids, err := idx.idx.Search(normalized(vec, idx.idx.D()), k)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]*AttributeMatch, 0, k)
+
+	for i, id := range ids {
+		if id == -1 {
+			break
+		}
+		matches = append(matches, &AttributeMatch{
+			DatasetID:     idx.idMap[id],
+			AttributeName: idx.attributeNames[id],
+			Similarity:    dist[i],
+		})
+	}
+	return matches, nil
+}
+
+// QueryDatasets queries the index with vec and collapses the matching
+// attributes into unique datasets, scored by combining the similarities of each
+// dataset's attributes with agg.
+//
+// The matches are taken from the k*attributeOversample nearest attributes.
+// Returns the (up to) k best scoring datasets, sorted by score.
+func (idx *AttributeIndex) QueryDatasets(vec []float32, k int64, agg Aggregation) ([]*DatasetMatch, error) {
+	attrs, err := idx.QueryAttributes(vec, k*attributeOversample)
+	if err != nil {
+		return nil, err
+	}
+	var datasets []*DatasetMatch
+	byID := make(map[string]*DatasetMatch)
+
+	// The attributes are sorted by similarity, so each dataset's attributes
+	// are as well.
+	for _, a := range attrs {
+		d := byID[a.DatasetID]
+		if d == nil {
+			d = &DatasetMatch{DatasetID: a.DatasetID}
+			byID[a.DatasetID] = d
+			datasets = append(datasets, d)
+		}
+		d.Attributes = append(d.Attributes, a)
+	}
+	for _, d := range datasets {
+		var sum float32
+		for _, a := range d.Attributes {
+			sum += a.Similarity
+		}
+		switch agg {
+		case AggregateMax:
+			d.Score = d.Attributes[0].Similarity
+		case AggregateMean:
+			d.Score = sum / float32(len(d.Attributes))
+		case AggregateCountWeighted:
+			d.Score = sum
+		default:
+			return nil, fmt.Errorf("unknown aggregation %d", agg)
+		}
+	}
+	sort.SliceStable(datasets, func(i, j int) bool {
+		return datasets[i].Score > datasets[j].Score
+	})
+	if int64(len(datasets)) > k {
+		datasets = datasets[:k]
+	}
+	return datasets, nil
+}
+
+// QueryBatch queries the index with each of the concatenated query vectors in
+// vecs using a single search.
+//
+// Returns the results of Query for each query vector.
+func (idx *AttributeIndex) QueryBatch(vecs []float32, k int64) ([][]string, [][]float32, error) {
+	dist, ids, err := idx.idx.Search(normalized(vecs, idx.idx.D()), k)
+	if err != nil {
+		return nil, nil, err
+	}
+	datasets, sims := splitResults(idx.idMap, dist, ids, len(vecs)/idx.idx.D(), k)
+	return datasets, sims, nil
+}
+
+// QueryThreshold queries the index for all attribute vectors whose cosine
+// similarity with vec is greater than minSim.
+//
+// Returns the dataset IDs of the matching attributes and the corresponding
+// cosine similarity, sorted by similarity.
+func (idx *AttributeIndex) QueryThreshold(vec []float32, minSim float32) ([]string, []float32, error) {
+	labels, dist, err := idx.idx.rangeSearch(normalized(vec, idx.idx.D()), minSim)
+	if err != nil {
+		return nil, nil, err
+	}
+	datasets := make([]string, len(labels))
+	sims := make([]float32, len(labels))
+	for i, id := range labels {
+		datasets[i], sims[i] = idx.idMap[id], dist[i]
+	}
+	sort.Sort(bySimilarity{datasets, sims})
+	return datasets, sims, nil
+}
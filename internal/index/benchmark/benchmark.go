@@ -0,0 +1,208 @@
+// Package benchmark measures the recall and query latency of the index
+// backends on a sample of the catalog, so that backend and parameter choices
+// are data-driven.
+//
+// Each backend is built over the same sample and queried with the same
+// held-out query vectors. Recall@k is measured against the exact results of a
+// flat index.
+package benchmark
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+)
+
+// Result is the measured performance of a backend.
+type Result struct {
+	// Backend specification (see index.New).
+	Backend string
+	// Mean fraction of the exact k nearest neighbors found per query.
+	Recall float64
+	// Query latency percentiles.
+	P50, P95, P99 time.Duration
+	// Queries per second, issuing one query at a time.
+	QPS float64
+	// Time to build the index over the sample.
+	BuildTime time.Duration
+	// Estimated memory used by the index, if it reports it.
+	MemoryBytes int64
+}
+
+// Report is the result of a benchmark run.
+type Report struct {
+	Vectors, Queries, Dim int
+	K                     int64
+	Started               time.Time
+	Results               []*Result
+}
+
+// DefaultBackends returns the backend specifications benchmarked by default
+// for n indexed vectors: flat, sharded with 4 shards, LSH forests with 8 and 32
+// trees, and IVF with 4*sqrt(n) lists, scanning 1, 4, 16, and 64 of them.
+func DefaultBackends(n int) []string {
+	backends := []string{"flat", "sharded:4", "lshforest:8", "lshforest:32"}
+	nlist := 4 * int(math.Sqrt(float64(n)))
+	if nlist < 1 {
+		nlist = 1
+	}
+	for nprobe := 1; nprobe <= 64 && nprobe <= nlist; nprobe *= 4 {
+		backends = append(backends, fmt.Sprintf("ivf:%d:%d", nlist, nprobe))
+	}
+	return backends
+}
+
+// Run benchmarks each backend on the sample with k nearest neighbor queries.
+func Run(s *index.Sample, k int64, backends []string) (*Report, error) {
+	r := &Report{
+		Vectors: len(s.IDs),
+		Queries: len(s.Queries) / s.Dim,
+		Dim:     s.Dim,
+		K:       k,
+		Started: time.Now(),
+	}
+	exact, err := index.NewFlatIndex(s.Dim)
+	if err != nil {
+		return nil, err
+	}
+	defer exact.Delete()
+
+	if err := exact.Build(s.IDs, s.Vecs); err != nil {
+		return nil, err
+	}
+	truth, _, err := exact.QueryBatch(s.Queries, k)
+	if err != nil {
+		return nil, err
+	}
+	for _, backend := range backends {
+		res, err := run(s, k, backend, truth)
+		if err != nil {
+			return nil, fmt.Errorf("benchmark %s: %v", backend, err)
+		}
+		r.Results = append(r.Results, res)
+	}
+	return r, nil
+}
+
+func run(s *index.Sample, k int64, backend string, truth [][]string) (*Result, error) {
+	idx, err := index.New(backend, s.Dim)
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Delete()
+
+	start := time.Now()
+	if err := idx.Build(s.IDs, s.Vecs); err != nil {
+		return nil, err
+	}
+	res := &Result{Backend: backend, BuildTime: time.Since(start)}
+	if r, ok := idx.(index.StatsReporter); ok {
+		res.MemoryBytes = r.Stats().MemoryBytes
+	}
+
+	latencies := make([]time.Duration, len(truth))
+	var total time.Duration
+	for q, want := range truth {
+		start := time.Now()
+		got, _, err := idx.Query(s.Queries[q*s.Dim:(q+1)*s.Dim], k)
+		latencies[q] = time.Since(start)
+		if err != nil {
+			return nil, err
+		}
+		total += latencies[q]
+		res.Recall += recall(got, want)
+	}
+	res.Recall /= float64(len(truth))
+	res.QPS = float64(len(truth)) / total.Seconds()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res.P50 = percentile(latencies, 0.5)
+	res.P95 = percentile(latencies, 0.95)
+	res.P99 = percentile(latencies, 0.99)
+	return res, nil
+}
+
+// recall returns the fraction of want that is in got.
+func recall(got, want []string) float64 {
+	if len(want) == 0 {
+		return 1
+	}
+	found := make(map[string]bool, len(got))
+	for _, id := range got {
+		found[id] = true
+	}
+	n := 0
+	for _, id := range want {
+		if found[id] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(want))
+}
+
+// percentile returns the pth percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// WriteText writes the report as an aligned table.
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "%d vectors of dimension %d, %d queries, recall@%d, %s\n\n",
+		r.Vectors, r.Dim, r.Queries, r.K, r.Started.Format(time.RFC3339))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "BACKEND\tRECALL\tP50\tP95\tP99\tQPS\tBUILD\tMEMORY\t")
+	for _, res := range r.Results {
+		fmt.Fprintf(tw, "%s\t%.3f\t%v\t%v\t%v\t%.0f\t%v\t%s\t\n",
+			res.Backend, res.Recall, res.P50, res.P95, res.P99, res.QPS,
+			res.BuildTime.Round(time.Millisecond), formatBytes(res.MemoryBytes))
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes the report as CSV, with a header row, for comparing runs.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"backend", "vectors", "dim", "queries", "k", "recall",
+		"p50_us", "p95_us", "p99_us", "qps", "build_ms", "memory_bytes"})
+	for _, res := range r.Results {
+		cw.Write([]string{
+			res.Backend,
+			strconv.Itoa(r.Vectors),
+			strconv.Itoa(r.Dim),
+			strconv.Itoa(r.Queries),
+			strconv.FormatInt(r.K, 10),
+			strconv.FormatFloat(res.Recall, 'f', 4, 64),
+			strconv.FormatInt(res.P50.Microseconds(), 10),
+			strconv.FormatInt(res.P95.Microseconds(), 10),
+			strconv.FormatInt(res.P99.Microseconds(), 10),
+			strconv.FormatFloat(res.QPS, 'f', 1, 64),
+			strconv.FormatInt(res.BuildTime.Milliseconds(), 10),
+			strconv.FormatInt(res.MemoryBytes, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n == 0:
+		return "-"
+	case n < 1<<20:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	case n < 1<<30:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	default:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	}
+}
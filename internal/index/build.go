@@ -0,0 +1,168 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// Number of rows decoded per batch by decodeRows.
+const decodeBatchSize = 10000
+
+// rowBatch is a batch of rows scanned by decodeRows.
+type rowBatch struct {
+	// keys[c][i] is the cth key column of the ith row.
+	keys [][]string
+	embs [][]byte
+	vecs []float32
+	// Number of zero vectors left out of keys and vecs.
+	zero int
+	err  error
+	// Closed once vecs or err is set.
+	done chan struct{}
+}
+
+// decodeRows scans rows of the vectors table, which must select nkeys string
+// columns followed by an embedding vector of dimension dim, and decodes and
+// normalizes the vectors in parallel.
+//
+// It calls f with the key columns (keys[c][i] is the cth key of the ith row)
+// and the concatenated vectors of each batch of rows, in row order. Decoding
+// stops at the first error, which is returned.
+//
+// Zero vectors, which are stored for texts without embedding (see
+// embedding.NoEmbFlag) or by earlier versions of the ingestion commands, are
+// skipped, since they would match any query with similarity 0; their number
+// is logged.
+func decodeRows(rows *sql.Rows, vectors string, nkeys, dim int, f func(keys [][]string, vecs []float32) error) error {
+	workers := runtime.GOMAXPROCS(0)
+	todo := make(chan *rowBatch, workers)
+	// Batches in row order, for f.
+	ordered := make(chan *rowBatch, 2*workers)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range todo {
+				b.vecs, b.zero, b.err = decodeBatch(b, dim)
+				b.embs = nil
+				close(b.done)
+			}
+		}()
+	}
+
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(todo)
+		defer close(ordered)
+		scanErr <- scanBatches(rows, nkeys, func(b *rowBatch) bool {
+			select {
+			case ordered <- b:
+			case <-quit:
+				return false
+			}
+			todo <- b
+			return true
+		})
+	}()
+
+	var err error
+	zero := 0
+	for b := range ordered {
+		<-b.done
+		if err != nil {
+			continue
+		}
+		zero += b.zero
+		if err = b.err; err == nil && len(b.vecs) > 0 {
+			err = f(b.keys, b.vecs)
+		}
+		if err != nil {
+			close(quit)
+		}
+	}
+	wg.Wait()
+	if serr := <-scanErr; err == nil {
+		err = serr
+	}
+	if err == nil && zero > 0 {
+		log.Printf("%s: skipped %d zero vectors, which have no embedding", vectors, zero)
+	}
+	return err
+}
+
+// scanBatches scans rows into batches of decodeBatchSize rows and passes them to
+// send until it returns false.
+func scanBatches(rows *sql.Rows, nkeys int, send func(*rowBatch) bool) error {
+	newBatch := func() *rowBatch {
+		return &rowBatch{keys: make([][]string, nkeys), done: make(chan struct{})}
+	}
+	b := newBatch()
+	dest := make([]interface{}, nkeys+1)
+	keys := make([]string, nkeys)
+	for c := range keys {
+		dest[c] = &keys[c]
+	}
+	var emb []byte
+	dest[nkeys] = &emb
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for c, key := range keys {
+			b.keys[c] = append(b.keys[c], key)
+		}
+		b.embs = append(b.embs, emb)
+		if len(b.embs) == decodeBatchSize {
+			if !send(b) {
+				return nil
+			}
+			b = newBatch()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(b.embs) > 0 {
+		send(b)
+	}
+	return nil
+}
+
+// decodeBatch decodes and normalizes the vectors of dimension dim of the batch
+// and returns them concatenated, leaving out the zero vectors and their keys,
+// whose number it returns.
+func decodeBatch(b *rowBatch, dim int) ([]float32, int, error) {
+	vecs := make([]float32, 0, len(b.embs)*dim)
+	n := 0
+	for i, emb := range b.embs {
+		vec, err := vec32.FromBytes(emb)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(vec) != dim {
+			return nil, 0, fmt.Errorf("vector of %v: dimension is %d, want %d", b.keys[0][i], len(vec), dim)
+		}
+		if vec32.Norm(vec) == 0 {
+			continue
+		}
+		vec32.Normalize(vec)
+		vecs = append(vecs, vec...)
+		for c := range b.keys {
+			b.keys[c][n] = b.keys[c][i]
+		}
+		n++
+	}
+	for c := range b.keys {
+		b.keys[c] = b.keys[c][:n]
+	}
+	return vecs, len(b.embs) - n, nil
+}
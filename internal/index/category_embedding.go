@@ -2,9 +2,9 @@ package index
 
 import (
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
 	"github.com/DataIntelligenceCrew/go-faiss"
-	"github.com/ekzhu/go-fasttext"
 
 	"strings"
 )
@@ -16,8 +16,8 @@ type CategoryIndex struct {
 	idMap []string
 }
 
-// BuildCategoryEmbeddingIndex builds a CategoryIndex.
-func BuildCategoryEmbeddingIndex(db *database.DB, ft *fasttext.FastText) (*CategoryIndex, error) {
+// BuildCategoryEmbeddingIndex builds a CategoryIndex with vectors embedded by e.
+func BuildCategoryEmbeddingIndex(db *database.DB, e embedding.Embedder) (*CategoryIndex, error) {
 	categories := make(map[string]bool) // Set of all categories
 
 	rows, err := db.Query(`SELECT categories FROM metadata`)
@@ -41,7 +41,7 @@ func BuildCategoryEmbeddingIndex(db *database.DB, ft *fasttext.FastText) (*Categ
 		return nil, err
 	}
 
-	index, err := faiss.NewIndexFlatIP(300)
+	index, err := faiss.NewIndexFlatIP(e.Dim())
 	if err != nil {
 		return nil, err
 	}
@@ -50,7 +50,7 @@ func BuildCategoryEmbeddingIndex(db *database.DB, ft *fasttext.FastText) (*Categ
 	var vecs []float32
 
 	for category := range categories {
-		vec, err := wordemb.Vector(ft, []string{category})
+		vec, err := wordemb.Vector(e, []string{category})
 		if err != nil {
 			if err == wordemb.ErrNoEmb {
 				continue
@@ -62,7 +62,7 @@ func BuildCategoryEmbeddingIndex(db *database.DB, ft *fasttext.FastText) (*Categ
 		vecs = append(vecs, vec...)
 	}
 
-	if err := index.Add(vecs); err != nil {
+	if err := index.Add(normalized(vecs, 300)); err != nil {
 		return nil, err
 	}
 
@@ -79,7 +79,7 @@ func (idx *CategoryIndex) Delete() {
 // Returns the category names of the (up to) k nearest neighbors and the
 // corresponding cosine similarity, sorted by similarity.
 func (idx *CategoryIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
-	dist, ids, err := idx.idx.Search(vec, k)
+	dist, ids, err := idx.idx.Search(normalized(vec, idx.idx.D()), k)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -0,0 +1,178 @@
+package index
+
+import (
+	"log"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+// Compact rebuilds the shards whose fraction of tombstoned vectors is at least
+// minRatio, dropping the tombstoned vectors.
+//
+// Each shard is rebuilt from a snapshot without blocking queries and is then
+// swapped in atomically. Vectors added or removed while the shard is rebuilt
+// are carried over to the new shard.
+// Compact returns the IDs of the datasets that were removed from the index.
+func (idx *ShardedIndex) Compact(minRatio float64) ([]string, error) {
+	var removed []string
+
+	for i := 0; i < idx.NumShards(); i++ {
+		ids, err := idx.compactShard(i, minRatio)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, ids...)
+	}
+	return removed, nil
+}
+
+// compactHook, if not nil, is called by compactShard after it builds the new
+// shard and before it swaps it in, so that tests can change the index in
+// between.
+var compactHook func()
+
+func (idx *ShardedIndex) compactShard(i int, minRatio float64) ([]string, error) {
+	// Snapshot the shard.
+	idx.mu.RLock()
+	old := idx.shards[i]
+	if len(old.dead) == 0 || old.tombstoneRatio() < minRatio {
+		idx.mu.RUnlock()
+		return nil, nil
+	}
+	dim := old.idx.D()
+	n := len(old.idMap)
+	xb := make([]float32, n*dim)
+	copy(xb, old.idx.Xb())
+	idMap := make([]string, n)
+	copy(idMap, old.idMap)
+	dead := make(map[int64]bool, len(old.dead))
+	for p := range old.dead {
+		dead[p] = true
+	}
+	idx.mu.RUnlock()
+
+	// Build the new shard from the live vectors in the snapshot.
+	s, err := newShard(dim)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	var vecs []float32
+	var removed []string
+
+	for p, id := range idMap {
+		if dead[int64(p)] {
+			removed = append(removed, id)
+			continue
+		}
+		ids = append(ids, id)
+		vecs = append(vecs, xb[p*dim:(p+1)*dim]...)
+	}
+	if err := s.add(ids, vecs); err != nil {
+		s.idx.Delete()
+		return nil, err
+	}
+
+	if compactHook != nil {
+		compactHook()
+	}
+
+	// Swap in the new shard, applying changes made since the snapshot.
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	cur := idx.shards[i]
+	if added := cur.idMap[n:]; len(added) > 0 {
+		if err := s.add(added, cur.idx.Xb()[n*dim:]); err != nil {
+			s.idx.Delete()
+			return nil, err
+		}
+	}
+	for id := range s.pos {
+		if _, ok := cur.pos[id]; !ok {
+			s.remove(id)
+		}
+	}
+	idx.shards[i] = s
+	cur.idx.Delete()
+
+	// Only report datasets that are no longer in the index.
+	live := removed[:0]
+	for _, id := range removed {
+		if _, ok := s.pos[id]; !ok {
+			live = append(live, id)
+		}
+	}
+	return live, nil
+}
+
+// CompactionConfig configures a background compaction job.
+type CompactionConfig struct {
+	// Name of the index in the index_shards table.
+	IndexName string
+	// How often to check whether the index needs compacting.
+	Interval time.Duration
+	// Compaction only runs between StartHour and EndHour (local time), which
+	// should be a low-traffic window. If StartHour equals EndHour, compaction
+	// may run at any time.
+	StartHour, EndHour int
+	// Minimum fraction of tombstoned vectors for a shard to be compacted.
+	MinTombstoneRatio float64
+}
+
+// inWindow reports whether t is in the compaction window.
+func (cfg *CompactionConfig) inWindow(t time.Time) bool {
+	h := t.Hour()
+	switch {
+	case cfg.StartHour == cfg.EndHour:
+		return true
+	case cfg.StartHour < cfg.EndHour:
+		return h >= cfg.StartHour && h < cfg.EndHour
+	default: // Window wraps around midnight
+		return h >= cfg.StartHour || h < cfg.EndHour
+	}
+}
+
+// RunCompaction periodically compacts the index held by m until done is closed.
+// Nothing is done while m holds an index other than a ShardedIndex.
+//
+// The shard assignments of removed datasets are deleted from the index_shards
+// table after each compaction.
+func RunCompaction(db *database.DB, m *Manager, cfg *CompactionConfig, done <-chan struct{}) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case t := <-ticker.C:
+			if !cfg.inWindow(t) {
+				continue
+			}
+			start := time.Now()
+			var removed []string
+			err := m.Do(func(q Querier) error {
+				idx, ok := q.(*ShardedIndex)
+				if !ok {
+					return nil
+				}
+				var err error
+				removed, err = idx.Compact(cfg.MinTombstoneRatio)
+				return err
+			})
+			if err != nil {
+				log.Printf("compacting %s index: %v", cfg.IndexName, err)
+			}
+			if len(removed) == 0 {
+				continue
+			}
+			if err := db.DeleteShardAssignments(cfg.IndexName, removed); err != nil {
+				log.Printf("compacting %s index: %v", cfg.IndexName, err)
+			}
+			log.Printf("compacted %s index, removed %d tombstones in %v",
+				cfg.IndexName, len(removed), time.Since(start))
+		}
+	}
+}
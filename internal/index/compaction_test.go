@@ -0,0 +1,82 @@
+package index
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCompactConcurrentChanges(t *testing.T) {
+	idx, err := NewShardedIndex(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Delete()
+	if err := idx.Add([]string{"a", "b", "c", "d"}, []float32{1, 0, 0, 1, 1, 1, 1, -1}); err != nil {
+		t.Fatal(err)
+	}
+	idx.Remove("a")
+	idx.Remove("b")
+
+	// Changes while the shard is compacted: a removed dataset is added
+	// again, and the others are removed, updated, and added.
+	compactHook = func() {
+		idx.Remove("c")
+		if err := idx.Update("d", []float32{0, 1}); err != nil {
+			t.Error(err)
+		}
+		if err := idx.Add([]string{"a", "e"}, []float32{1, 0, -1, 0}); err != nil {
+			t.Error(err)
+		}
+	}
+	defer func() { compactHook = nil }()
+	removed, err := idx.Compact(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"b"}) {
+		t.Errorf("Compact returned %q, want [b]", removed)
+	}
+	compactHook = nil
+
+	live := func() []string {
+		t.Helper()
+		ids, _, err := idx.Query([]float32{1, 1}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	if ids := live(); !reflect.DeepEqual(ids, []string{"a", "d", "e"}) {
+		t.Errorf("got datasets %q after compaction, want [a d e]", ids)
+	}
+	s := idx.shards[0]
+	for _, id := range s.idMap {
+		if id == "b" {
+			t.Error("dataset tombstoned before the compaction still in the shard")
+		}
+	}
+	if _, ok := s.pos["c"]; ok {
+		t.Error("dataset removed during the compaction live in the shard")
+	}
+	if d, ok := s.pos["d"]; !ok || s.idx.Xb()[2*d] != 0 {
+		t.Error("dataset updated during the compaction lost its new vector")
+	}
+
+	// The tombstones of the changes are dropped by the next compaction.
+	if removed, err = idx.Compact(0); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"c"}) {
+		t.Errorf("second Compact returned %q, want [c]", removed)
+	}
+	s = idx.shards[0]
+	if len(s.dead) != 0 || len(s.idMap) != 3 {
+		t.Errorf("got %d vectors and %d tombstones after compacting again, want 3 and none",
+			len(s.idMap), len(s.dead))
+	}
+	if ids := live(); !reflect.DeepEqual(ids, []string{"a", "d", "e"}) {
+		t.Errorf("got datasets %q after compacting again, want [a d e]", ids)
+	}
+}
@@ -0,0 +1,46 @@
+package index
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/ekzhu/lshensemble"
+)
+
+// EntityColumns returns the categorical columns, i.e. those whose inferred type
+// is string or unknown, that contain at least the fraction threshold of the
+// distinct entities, sorted by containment. The entities are trimmed, and
+// blank ones ignored. They are sketched as a column and the columns are found
+// as by Joinable.
+func (idx *JoinabilityIndex) EntityColumns(entities []string, threshold float64) ([]*JoinCandidate, error) {
+	mh := lshensemble.NewMinhash(mhSeed, mhSize)
+	distinct := make(map[string]bool)
+	for _, e := range entities {
+		e = strings.TrimSpace(e)
+		if e != "" && !distinct[e] {
+			distinct[e] = true
+			mh.Push([]byte(e))
+		}
+	}
+	if len(distinct) == 0 {
+		return nil, nil
+	}
+	query := &database.ColumnSketch{DistinctCount: len(distinct), Minhash: mh.Signature()}
+	candidates, err := idx.Joinable(query, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []*JoinCandidate
+	for _, c := range candidates {
+		if t := idx.columnType(c.ColumnID); t == coltype.String || t == coltype.Unknown {
+			columns = append(columns, c)
+		}
+	}
+	sort.SliceStable(columns, func(i, j int) bool {
+		return columns[i].Containment > columns[j].Containment
+	})
+	return columns, nil
+}
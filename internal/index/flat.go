@@ -0,0 +1,237 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	"github.com/DataIntelligenceCrew/go-faiss"
+)
+
+// FlatIndex is an Index that performs exact nearest neighbor search with a
+// faiss flat inner product index.
+type FlatIndex struct {
+	idx *faiss.IndexFlat
+	// Maps ID of vector in index to dataset ID.
+	idMap []string
+}
+
+// NewFlatIndex creates an empty FlatIndex.
+func NewFlatIndex(dim int) (*FlatIndex, error) {
+	idx, err := faiss.NewIndexFlatIP(dim)
+	if err != nil {
+		return nil, err
+	}
+	return &FlatIndex{idx: idx}, nil
+}
+
+// Dim returns the vector dimension.
+func (idx *FlatIndex) Dim() int {
+	return idx.idx.D()
+}
+
+// Build replaces the contents of the index with the given vectors.
+func (idx *FlatIndex) Build(ids []string, vecs []float32) error {
+	if err := idx.idx.Reset(); err != nil {
+		return err
+	}
+	idx.idMap = nil
+	return idx.Add(ids, vecs)
+}
+
+// Add adds the given vectors to the index.
+func (idx *FlatIndex) Add(ids []string, vecs []float32) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if len(vecs) != len(ids)*idx.Dim() {
+		return fmt.Errorf("got %d values for %d vectors of dimension %d",
+			len(vecs), len(ids), idx.Dim())
+	}
+	if err := idx.idx.Add(normalized(vecs, idx.Dim())); err != nil {
+		return err
+	}
+	idx.idMap = append(idx.idMap, ids...)
+	return nil
+}
+
+// Delete frees the memory associated with the index.
+func (idx *FlatIndex) Delete() {
+	idx.idx.Delete()
+}
+
+// Query queries the index with vec.
+//
+// Returns the dataset IDs of the (up to) k nearest neighbors and the
+// corresponding cosine similarity, sorted by similarity.
+func (idx *FlatIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
+	dist, ids, err := idx.idx.Search(normalized(vec, idx.Dim()), k)
+	if err != nil {
+		return nil, nil, err
+	}
+	datasets := make([]string, 0, k)
+
+	for _, id := range ids {
+		if id == -1 {
+			break
+		}
+		datasets = append(datasets, idx.idMap[id])
+	}
+	return datasets, dist[:len(datasets)], nil
+}
+
+// QueryBatch queries the index with each of the concatenated query vectors in
+// vecs using a single search.
+//
+// Returns the results of Query for each query vector.
+func (idx *FlatIndex) QueryBatch(vecs []float32, k int64) ([][]string, [][]float32, error) {
+	dist, ids, err := idx.idx.Search(normalized(vecs, idx.Dim()), k)
+	if err != nil {
+		return nil, nil, err
+	}
+	datasets, sims := splitResults(idx.idMap, dist, ids, len(vecs)/idx.Dim(), k)
+	return datasets, sims, nil
+}
+
+// QueryThreshold queries the index for all vectors whose cosine similarity with
+// vec is greater than minSim.
+//
+// Returns the dataset IDs and the corresponding cosine similarity, sorted by
+// similarity.
+func (idx *FlatIndex) QueryThreshold(vec []float32, minSim float32) ([]string, []float32, error) {
+	res, err := idx.idx.RangeSearch(normalized(vec, idx.Dim()), minSim)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Delete()
+
+	labels, dist := res.Labels()
+	datasets := make([]string, len(labels))
+	sims := make([]float32, len(labels))
+	for i, id := range labels {
+		datasets[i], sims[i] = idx.idMap[id], dist[i]
+	}
+	sort.Sort(bySimilarity{datasets, sims})
+	return datasets, sims, nil
+}
+
+// Update replaces the vector for a dataset, or adds it if the dataset is not in
+// the index.
+func (idx *FlatIndex) Update(datasetID string, vec []float32) error {
+	if err := idx.Remove(datasetID); err != nil {
+		return err
+	}
+	return idx.Add([]string{datasetID}, vec)
+}
+
+// Remove removes the vector for a dataset, if it is in the index.
+func (idx *FlatIndex) Remove(datasetID string) error {
+	var ids []int64
+	for i, id := range idx.idMap {
+		if id == datasetID {
+			ids = append(ids, int64(i))
+		}
+	}
+	if len(ids) > 0 {
+		sel, err := faiss.NewIDSelectorBatch(ids)
+		if err != nil {
+			return err
+		}
+		defer sel.Delete()
+
+		if _, err := idx.idx.RemoveIDs(sel); err != nil {
+			return err
+		}
+		// IndexFlat shifts the remaining vectors down, preserving their order,
+		// so do the same to idMap.
+		idMap := idx.idMap[:0]
+		for _, id := range idx.idMap {
+			if id != datasetID {
+				idMap = append(idMap, id)
+			}
+		}
+		idx.idMap = idMap
+	}
+	return nil
+}
+
+// Save writes the contents of the index to w.
+func (idx *FlatIndex) Save(w io.Writer) error {
+	return writeVectors(w, idx.Dim(), idx.idMap, idx.idx.Xb(), nil)
+}
+
+// Load replaces the contents of the index with the contents written by Save.
+func (idx *FlatIndex) Load(r io.Reader) error {
+	dim, ids, vecs, err := readVectors(r)
+	if err != nil {
+		return err
+	}
+	if dim != idx.Dim() {
+		return fmt.Errorf("loading index: dimension is %d, want %d", dim, idx.Dim())
+	}
+	return idx.Build(ids, vecs)
+}
+
+// writeVectors writes the vector dimension and count followed by each ID
+// (length-prefixed) and its vector to w.
+// If skip is not nil, the vectors for which it returns true are not written.
+func writeVectors(w io.Writer, dim int, ids []string, vecs []float32, skip func(i int) bool) error {
+	count := 0
+	for i := range ids {
+		if skip == nil || !skip(i) {
+			count++
+		}
+	}
+	bw := bufio.NewWriter(w)
+	binary.Write(bw, binary.BigEndian, uint32(dim))
+	binary.Write(bw, binary.BigEndian, uint32(count))
+	for i, id := range ids {
+		if skip != nil && skip(i) {
+			continue
+		}
+		binary.Write(bw, binary.BigEndian, uint32(len(id)))
+		bw.WriteString(id)
+		bw.Write(vec32.Bytes(vecs[i*dim : (i+1)*dim]))
+	}
+	return bw.Flush()
+}
+
+// readVectors reads vectors written by writeVectors.
+func readVectors(r io.Reader) (dim int, ids []string, vecs []float32, err error) {
+	br := bufio.NewReader(r)
+
+	var d, count uint32
+	if err := binary.Read(br, binary.BigEndian, &d); err != nil {
+		return 0, nil, nil, err
+	}
+	if err := binary.Read(br, binary.BigEndian, &count); err != nil {
+		return 0, nil, nil, err
+	}
+	ids = make([]string, 0, count)
+	vecs = make([]float32, 0, int(count)*int(d))
+	buf := make([]byte, 4*d)
+
+	for i := uint32(0); i < count; i++ {
+		var n uint32
+		if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+			return 0, nil, nil, err
+		}
+		id := make([]byte, n)
+		if _, err := io.ReadFull(br, id); err != nil {
+			return 0, nil, nil, err
+		}
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return 0, nil, nil, err
+		}
+		vec, err := vec32.FromBytes(buf)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		ids = append(ids, string(id))
+		vecs = append(vecs, vec...)
+	}
+	return int(d), ids, vecs, nil
+}
@@ -0,0 +1,26 @@
+package index
+
+import "github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+
+// ForeignKeys returns the candidate key columns of other datasets that contain
+// at least the fraction minContainment of the values of query (see Joinable),
+// i.e. the columns that query probably references as a foreign key. Both query
+// and the key columns must have at least minDistinct distinct values, since
+// the few values of columns such as flags are contained in many keys by
+// chance.
+func (idx *JoinabilityIndex) ForeignKeys(query *database.ColumnSketch, minContainment float64, minDistinct int) ([]*JoinCandidate, error) {
+	if query.DistinctCount < minDistinct {
+		return nil, nil
+	}
+	candidates, err := idx.Joinable(query, minContainment)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*JoinCandidate
+	for _, c := range candidates {
+		if c.Key() && c.DatasetID != query.DatasetID && c.DistinctCount >= minDistinct {
+			keys = append(keys, c)
+		}
+	}
+	return keys, nil
+}
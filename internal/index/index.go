@@ -0,0 +1,230 @@
+// Package index provides indexes over the Open Data Link embedding vectors and
+// column sketches.
+package index
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// Querier is implemented by indexes that support nearest neighbor queries.
+type Querier interface {
+	// Query returns the IDs of the (up to) k nearest neighbors of vec and the
+	// corresponding cosine similarity, sorted by similarity.
+	Query(vec []float32, k int64) ([]string, []float32, error)
+}
+
+// RangeQuerier is implemented by indexes that support similarity threshold
+// queries.
+type RangeQuerier interface {
+	// QueryThreshold returns the IDs of all vectors whose cosine similarity
+	// with vec is greater than minSim and the corresponding similarity,
+	// sorted by similarity.
+	QueryThreshold(vec []float32, minSim float32) ([]string, []float32, error)
+}
+
+// BatchQuerier is implemented by indexes that can answer several nearest
+// neighbor queries with a single search.
+type BatchQuerier interface {
+	// QueryBatch queries the index with each of the concatenated query vectors
+	// in vecs and returns the results of Query for each of them.
+	QueryBatch(vecs []float32, k int64) ([][]string, [][]float32, error)
+}
+
+// Index is a nearest neighbor index over embedding vectors keyed by string IDs
+// (usually dataset IDs).
+//
+// Vectors are passed as a single slice holding one vector of Dim() values per
+// ID, concatenated in the same order as the IDs. Indexed and query vectors are
+// normalized to unit vectors, so similarities are cosine similarities.
+type Index interface {
+	Querier
+	// Build replaces the contents of the index with the given vectors.
+	Build(ids []string, vecs []float32) error
+	// Add adds the given vectors to the index.
+	Add(ids []string, vecs []float32) error
+	// Save writes the contents of the index to w.
+	Save(w io.Writer) error
+	// Load replaces the contents of the index with the contents written by
+	// Save.
+	Load(r io.Reader) error
+	// Dim returns the vector dimension.
+	Dim() int
+	// Delete frees the memory associated with the index.
+	Delete()
+}
+
+// A backendFunc creates an empty Index with the given vector dimension.
+// arg is the part of the backend specification after the colon, if any.
+type backendFunc func(dim int, arg string) (Index, error)
+
+var backends = map[string]backendFunc{
+	"flat": func(dim int, arg string) (Index, error) {
+		return NewFlatIndex(dim)
+	},
+	"sharded": func(dim int, arg string) (Index, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("sharded index: invalid number of shards %q", arg)
+		}
+		return NewShardedIndex(dim, n)
+	},
+	"ivf": func(dim int, arg string) (Index, error) {
+		nlist, nprobe, err := parseIVF(arg)
+		if err != nil {
+			return nil, err
+		}
+		return NewIVFIndex(dim, nlist, nprobe)
+	},
+	"lshforest": func(dim int, arg string) (Index, error) {
+		trees, bits, err := parseLSHForest(arg)
+		if err != nil {
+			return nil, err
+		}
+		return NewLSHForestIndex(dim, trees, bits)
+	},
+}
+
+// Backends returns the names of the available index backends.
+func Backends() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates an empty Index with the given vector dimension.
+//
+// The backend specification is the name of a backend, optionally followed by a
+// colon and a backend argument, e.g. "flat", "sharded:4", "ivf:1024:32",
+// "lshforest:8:16", "rerank:4:ivf:1024:8", or "pca:128:ivf:1024:8".
+func New(backend string, dim int) (Index, error) {
+	name, arg := backend, ""
+	if i := strings.IndexByte(backend, ':'); i >= 0 {
+		name, arg = backend[:i], backend[i+1:]
+	}
+	f, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown index backend %q (available: %s)",
+			name, strings.Join(Backends(), ", "))
+	}
+	return f(dim, arg)
+}
+
+// Tolerance within which vectors are considered to be unit vectors by
+// normalized.
+const normTolerance = 1e-4
+
+// normalized returns the concatenated vectors of dimension dim in vecs
+// converted to unit vectors, so that their inner products are cosine
+// similarities in [-1, 1]. Zero vectors are left unchanged.
+//
+// vecs is returned as is if its vectors are already unit vectors, or if its
+// length is not a multiple of dim, which is left for faiss to report.
+// Otherwise, the vectors are normalized in a copy.
+func normalized(vecs []float32, dim int) []float32 {
+	if dim < 1 || len(vecs)%dim != 0 {
+		return vecs
+	}
+	var out []float32
+	for i := 0; i < len(vecs); i += dim {
+		n := vec32.Norm(vecs[i : i+dim])
+		if n == 0 || math.Abs(float64(n)-1) <= normTolerance {
+			continue
+		}
+		if out == nil {
+			out = make([]float32, len(vecs))
+			copy(out, vecs)
+		}
+		vec32.Normalize(out[i : i+dim])
+	}
+	if out == nil {
+		return vecs
+	}
+	return out
+}
+
+// bySimilarity sorts parallel slices of IDs and similarities by decreasing
+// similarity.
+type bySimilarity struct {
+	ids  []string
+	sims []float32
+}
+
+func (s bySimilarity) Len() int           { return len(s.ids) }
+func (s bySimilarity) Less(i, j int) bool { return s.sims[i] > s.sims[j] }
+func (s bySimilarity) Swap(i, j int) {
+	s.ids[i], s.ids[j] = s.ids[j], s.ids[i]
+	s.sims[i], s.sims[j] = s.sims[j], s.sims[i]
+}
+
+// splitResults splits the results of a faiss search for n queries into the
+// dataset IDs and similarities of each query, mapping vector IDs with idMap.
+func splitResults(idMap []string, dist []float32, ids []int64, n int, k int64) ([][]string, [][]float32) {
+	datasets := make([][]string, n)
+	sims := make([][]float32, n)
+
+	for q := 0; q < n; q++ {
+		qdist, qids := dist[int64(q)*k:int64(q+1)*k], ids[int64(q)*k:int64(q+1)*k]
+		for _, id := range qids {
+			if id == -1 {
+				break
+			}
+			datasets[q] = append(datasets[q], idMap[id])
+		}
+		sims[q] = qdist[:len(datasets[q])]
+	}
+	return datasets, sims
+}
+
+// loadVectors runs query with args, which must select an ID and an embedding
+// vector of dimension dim of the vectors table, and returns the IDs and the
+// concatenated normalized vectors, without the zero vectors (see decodeRows).
+func loadVectors(db *database.DB, vectors string, dim int, query string, args ...interface{}) ([]string, []float32, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	var vecs []float32
+
+	err = decodeRows(rows, vectors, 1, dim, func(keys [][]string, batch []float32) error {
+		ids = append(ids, keys[0]...)
+		vecs = append(vecs, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ids, vecs, nil
+}
+
+// BuildMetadataIndex builds an Index over the metadata embedding vectors of the
+// given dimension with the given backend (see New).
+func BuildMetadataIndex(db *database.DB, backend string, dim int) (Index, error) {
+	idx, err := New(backend, dim)
+	if err != nil {
+		return nil, err
+	}
+	ids, vecs, err := loadVectors(db, "metadata_vectors", dim, `SELECT dataset_id, emb FROM metadata_vectors`)
+	if err != nil {
+		idx.Delete()
+		return nil, err
+	}
+	if err := idx.Build(ids, vecs); err != nil {
+		idx.Delete()
+		return nil, err
+	}
+	return idx, nil
+}
@@ -0,0 +1,196 @@
+package index
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/go-faiss"
+)
+
+// IVFIndex is an Index that performs approximate nearest neighbor search with
+// a faiss inverted file inner product index.
+//
+// The vectors are clustered into nlist lists, and queries only scan the nprobe
+// lists closest to the query vector. Larger nprobe values increase recall at
+// the cost of latency.
+type IVFIndex struct {
+	idx    *faiss.Index
+	dim    int
+	nlist  int
+	nprobe int
+	// Maps ID of vector in index to dataset ID.
+	idMap []string
+	// The indexed vectors, kept for Save, since faiss inverted lists cannot
+	// be read back.
+	vecs []float32
+}
+
+// NewIVFIndex creates an empty IVFIndex with nlist inverted lists, of which
+// nprobe are scanned per query.
+func NewIVFIndex(dim, nlist, nprobe int) (*IVFIndex, error) {
+	if nlist < 1 || nprobe < 1 {
+		return nil, fmt.Errorf("ivf index: invalid nlist %d or nprobe %d", nlist, nprobe)
+	}
+	idx := &IVFIndex{dim: dim, nlist: nlist, nprobe: nprobe}
+	if err := idx.reset(nlist); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// parseIVF parses the "<nlist>[:<nprobe>]" argument of the ivf backend.
+// nprobe defaults to 1/16 of nlist.
+func parseIVF(arg string) (nlist, nprobe int, err error) {
+	parts := strings.Split(arg, ":")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("ivf index: invalid argument %q", arg)
+	}
+	if nlist, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("ivf index: invalid nlist %q", parts[0])
+	}
+	nprobe = nlist / 16
+	if nprobe < 1 {
+		nprobe = 1
+	}
+	if len(parts) == 2 {
+		if nprobe, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, fmt.Errorf("ivf index: invalid nprobe %q", parts[1])
+		}
+	}
+	return nlist, nprobe, nil
+}
+
+// reset replaces the faiss index with an empty, untrained index with nlist
+// inverted lists.
+func (idx *IVFIndex) reset(nlist int) error {
+	fidx, err := faiss.IndexFactory(idx.dim, fmt.Sprintf("IVF%d,Flat", nlist), faiss.MetricInnerProduct)
+	if err != nil {
+		return err
+	}
+	if idx.idx != nil {
+		idx.idx.Delete()
+	}
+	idx.idx = fidx
+	idx.idMap = nil
+	idx.vecs = nil
+	return idx.SetNprobe(idx.nprobe)
+}
+
+// SetNprobe sets the number of inverted lists scanned per query.
+func (idx *IVFIndex) SetNprobe(nprobe int) error {
+	ps, err := faiss.NewParameterSpace()
+	if err != nil {
+		return err
+	}
+	defer ps.Delete()
+
+	if err := ps.SetIndexParameter(idx.idx, "nprobe", float64(nprobe)); err != nil {
+		return err
+	}
+	idx.nprobe = nprobe
+	return nil
+}
+
+// Dim returns the vector dimension.
+func (idx *IVFIndex) Dim() int {
+	return idx.dim
+}
+
+// Build replaces the contents of the index with the given vectors, training
+// the inverted lists on them.
+// If there are fewer vectors than inverted lists, one list per vector is used.
+func (idx *IVFIndex) Build(ids []string, vecs []float32) error {
+	nlist := idx.nlist
+	if len(ids) < nlist {
+		nlist = len(ids)
+	}
+	if nlist < 1 {
+		nlist = 1
+	}
+	if err := idx.reset(nlist); err != nil {
+		return err
+	}
+	return idx.Add(ids, vecs)
+}
+
+// Add adds the given vectors to the index.
+// If the index has not been trained, it is trained on the vectors first.
+func (idx *IVFIndex) Add(ids []string, vecs []float32) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if len(vecs) != len(ids)*idx.dim {
+		return fmt.Errorf("got %d values for %d vectors of dimension %d",
+			len(vecs), len(ids), idx.dim)
+	}
+	vecs = normalized(vecs, idx.dim)
+	if !idx.idx.IsTrained() {
+		if err := idx.idx.Train(vecs); err != nil {
+			return err
+		}
+	}
+	if err := idx.idx.Add(vecs); err != nil {
+		return err
+	}
+	idx.idMap = append(idx.idMap, ids...)
+	idx.vecs = append(idx.vecs, vecs...)
+	return nil
+}
+
+// Delete frees the memory associated with the index.
+func (idx *IVFIndex) Delete() {
+	idx.idx.Delete()
+}
+
+// Query queries the index with vec.
+//
+// Returns the dataset IDs of the (up to) k approximate nearest neighbors and
+// the corresponding cosine similarity, sorted by similarity.
+func (idx *IVFIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
+	dist, ids, err := idx.idx.Search(normalized(vec, idx.dim), k)
+	if err != nil {
+		return nil, nil, err
+	}
+	datasets := make([]string, 0, k)
+
+	for _, id := range ids {
+		if id == -1 {
+			break
+		}
+		datasets = append(datasets, idx.idMap[id])
+	}
+	return datasets, dist[:len(datasets)], nil
+}
+
+// QueryBatch queries the index with each of the concatenated query vectors in
+// vecs using a single search.
+//
+// Returns the results of Query for each query vector.
+func (idx *IVFIndex) QueryBatch(vecs []float32, k int64) ([][]string, [][]float32, error) {
+	dist, ids, err := idx.idx.Search(normalized(vecs, idx.dim), k)
+	if err != nil {
+		return nil, nil, err
+	}
+	datasets, sims := splitResults(idx.idMap, dist, ids, len(vecs)/idx.dim, k)
+	return datasets, sims, nil
+}
+
+// Save writes the contents of the index to w.
+// The inverted lists are retrained when the index is loaded.
+func (idx *IVFIndex) Save(w io.Writer) error {
+	return writeVectors(w, idx.dim, idx.idMap, idx.vecs, nil)
+}
+
+// Load replaces the contents of the index with the contents written by Save.
+func (idx *IVFIndex) Load(r io.Reader) error {
+	dim, ids, vecs, err := readVectors(r)
+	if err != nil {
+		return err
+	}
+	if dim != idx.dim {
+		return fmt.Errorf("loading index: dimension is %d, want %d", dim, idx.dim)
+	}
+	return idx.Build(ids, vecs)
+}
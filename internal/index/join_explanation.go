@@ -0,0 +1,64 @@
+package index
+
+import (
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coldomain"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+// JoinExplanation explains the suggestion of a column as a join partner of a
+// query column, so that it can be evaluated without downloading either table.
+type JoinExplanation struct {
+	// Estimated fraction of the values of the query column that are values
+	// of the candidate, and number of values of both.
+	Containment  float64
+	SharedValues float64
+	// Distinct non-blank values in the samples of both columns, in the order
+	// of the query column's sample.
+	SampleOverlap []string
+	// Cosine similarity of the attribute embeddings of the column names.
+	NameSimilarity float32
+	// Inferred types of the query and candidate columns, and whether they are
+	// compatible (see coltype.Compatible).
+	QueryType, Type coltype.Type
+	Compatible      bool
+	// Semantic domains of the query and candidate columns. Columns in the
+	// same domain are likely to join even if few values overlap, e.g. zip
+	// codes written with and without the 4-digit extension.
+	QueryDomain, Domain coldomain.Domain
+}
+
+// Explain returns the explanation of the candidate c of the query column, whose
+// names have the given similarity.
+func (idx *JoinabilityIndex) Explain(query *database.ColumnSketch, c *JoinCandidate, nameSim float32) *JoinExplanation {
+	queryType, typ := idx.columnType(query.ColumnID), idx.columnType(c.ColumnID)
+	return &JoinExplanation{
+		Containment:    c.Containment,
+		SharedValues:   c.Intersection,
+		SampleOverlap:  sampleOverlap(query.Sample, c.Sample),
+		NameSimilarity: nameSim,
+		QueryType:      queryType,
+		Type:           typ,
+		Compatible:     coltype.Compatible(queryType, typ),
+		QueryDomain:    idx.columnDomain(query.ColumnID),
+		Domain:         idx.columnDomain(c.ColumnID),
+	}
+}
+
+// sampleOverlap returns the distinct non-blank values of a that are in b, in
+// the order of a.
+func sampleOverlap(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, v := range b {
+		inB[v] = true
+	}
+	overlap := []string{}
+	added := make(map[string]bool)
+	for _, v := range a {
+		if v != "" && inB[v] && !added[v] {
+			overlap = append(overlap, v)
+			added[v] = true
+		}
+	}
+	return overlap
+}
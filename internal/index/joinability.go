@@ -1,22 +1,89 @@
 package index
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coldomain"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/lazo"
 	"github.com/ekzhu/lshensemble"
 )
 
+// Number of minhash hash functions, and seed of the signatures, as computed
+// by sketch_columns
 const (
-	// Number of minhash hash functions
 	mhSize = 256
-	// Number of LSH Ensemble partitions
-	numPart = 8
-	// Maximum value for the minhash LSH parameter K
-	// (number of hash functions per band).
-	maxK = 4
+	mhSeed = 42
 )
 
-// BuildJoinabilityIndex builds an LSH Ensemble index on the dataset columns.
-func BuildJoinabilityIndex(db *database.DB) (*lshensemble.LshEnsemble, error) {
+// MinKeyUniqueness is the minimum uniqueness of the candidate key columns,
+// whose values are (nearly) all distinct.
+const MinKeyUniqueness = 0.95
+
+// Containment added to that of candidate key columns to rank them, so that
+// joins on the key of the candidate's table are preferred.
+const keyPreference = 0.1
+
+// JoinabilityIndex is an LSH Ensemble index over the minhash signatures of the
+// sampled values of the dataset columns, computed by sketch_columns, for
+// finding the columns a column can be joined with.
+//
+// The LSH Ensemble cannot be updated, so the datasets sketched again after the
+// index was built are refreshed (see Refresh) by leaving their columns out of
+// its results and looking up their new columns by a linear scan.
+type JoinabilityIndex struct {
+	*lshensemble.LshEnsemble
+	db *database.DB
+
+	mu sync.RWMutex // Guards the fields below.
+	// Inferred types, uniqueness, and semantic domains of the columns by
+	// column ID, if sketch_columns profiled them.
+	types      map[string]coltype.Type
+	uniqueness map[string]float64
+	domains    map[string]coldomain.Domain
+	// Sketch versions of the indexed datasets, if sketch_columns recorded
+	// them.
+	versions map[string]int64
+	// IDs of the refreshed datasets, whose columns in the LSH Ensemble are out
+	// of date, and their current columns. They are replaced rather than
+	// modified, so queries can use them without holding mu.
+	refreshed map[string]bool
+	delta     []*database.ColumnSketch
+}
+
+// JoinCandidate is a column that a query column may be joined with, and the
+// estimated overlap of their distinct values; Containment is the fraction of
+// the values of the query column that are values of the candidate.
+type JoinCandidate struct {
+	*database.ColumnSketch
+	lazo.Estimate
+	// Uniqueness of the candidate column (see database.DB.ColumnUniqueness),
+	// or 0 if it was not profiled.
+	Uniqueness float64
+}
+
+// Key reports whether the candidate column is a candidate key of its table.
+func (c *JoinCandidate) Key() bool {
+	return c.Uniqueness >= MinKeyUniqueness
+}
+
+// BuildJoinabilityIndex builds a JoinabilityIndex on the dataset columns with
+// numPart partitions. maxK is the maximum value for the minhash LSH parameter
+// K (number of hash functions per band).
+func BuildJoinabilityIndex(db *database.DB, numPart, maxK int) (*JoinabilityIndex, error) {
+	if numPart < 1 || maxK < 1 || maxK > mhSize {
+		return nil, fmt.Errorf("invalid joinability index parameters: %d partitions, max K %d", numPart, maxK)
+	}
+	// The versions are read before the sketches, so that datasets sketched
+	// again in between are refreshed.
+	versions, err := db.SketchVersions()
+	if err != nil {
+		return nil, err
+	}
 	var domainRecords []*lshensemble.DomainRecord
 
 	rows, err := db.Query(`
@@ -51,10 +118,264 @@ func BuildJoinabilityIndex(db *database.DB) (*lshensemble.LshEnsemble, error) {
 		return nil, err
 	}
 
+	types, err := db.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	uniqueness, err := db.ColumnUniqueness()
+	if err != nil {
+		return nil, err
+	}
+	domains, err := db.ColumnDomains()
+	if err != nil {
+		return nil, err
+	}
 	index, err := lshensemble.BootstrapLshEnsembleEquiDepth(
 		numPart, mhSize, maxK, len(domainRecords), lshensemble.Recs2Chan(domainRecords))
 	if err != nil {
 		return nil, err
 	}
-	return index, nil
+	return &JoinabilityIndex{
+		LshEnsemble: index,
+		db:          db,
+		types:       types,
+		uniqueness:  uniqueness,
+		domains:     domains,
+		versions:    versions,
+	}, nil
+}
+
+// Query returns the keys of the columns that may contain at least the fraction
+// threshold of the values of a column with the minhash signature sig and size
+// distinct values: the candidates of the LSH Ensemble, less the columns of the
+// refreshed datasets, and the current columns of the refreshed datasets whose
+// estimated containment is at least threshold. The keys are sent until done is
+// closed.
+func (idx *JoinabilityIndex) Query(sig []uint64, size int, threshold float64, done <-chan struct{}) <-chan interface{} {
+	idx.mu.RLock()
+	refreshed, delta := idx.refreshed, idx.delta
+	idx.mu.RUnlock()
+	if len(refreshed) == 0 {
+		return idx.LshEnsemble.Query(sig, size, threshold, done)
+	}
+	out := make(chan interface{})
+	go func() {
+		defer close(out)
+		for key := range idx.LshEnsemble.Query(sig, size, threshold, done) {
+			columnID := key.(string)
+			if refreshed[columnID[:strings.LastIndex(columnID, "-")]] {
+				continue
+			}
+			select {
+			case out <- key:
+			case <-done:
+				return
+			}
+		}
+		for _, c := range delta {
+			if lazo.Overlap(sig, c.Minhash, size, c.DistinctCount).Containment < threshold {
+				continue
+			}
+			select {
+			case out <- c.ColumnID:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Refresh updates the index with the datasets whose sketch versions (see
+// database.DB.SketchVersions) changed since the index was built or last
+// refreshed, including the datasets that were added or deleted, without
+// rebuilding the LSH Ensemble. It returns the number of refreshed datasets.
+//
+// The new columns are scanned by every query, so the index should be rebuilt
+// once many datasets have been refreshed.
+func (idx *JoinabilityIndex) Refresh() (int, error) {
+	versions, err := idx.db.SketchVersions()
+	if err != nil || versions == nil {
+		return 0, err
+	}
+	idx.mu.RLock()
+	var changed []string
+	for datasetID, v := range versions {
+		if idx.versions[datasetID] != v {
+			changed = append(changed, datasetID)
+		}
+	}
+	for datasetID := range idx.versions {
+		if _, ok := versions[datasetID]; !ok {
+			changed = append(changed, datasetID)
+		}
+	}
+	idx.mu.RUnlock()
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	// The current columns and profiles of the changed datasets, which have
+	// none if they were deleted.
+	var columns []*database.ColumnSketch
+	types := make(map[string]coltype.Type)
+	uniqueness := make(map[string]float64)
+	domains := make(map[string]coldomain.Domain)
+	for _, datasetID := range changed {
+		cols, err := idx.db.DatasetColumns(datasetID)
+		if err != nil {
+			return 0, err
+		}
+		columns = append(columns, cols...)
+		t, err := idx.db.DatasetColumnTypes(datasetID)
+		if err != nil {
+			return 0, err
+		}
+		u, err := idx.db.DatasetColumnUniqueness(datasetID)
+		if err != nil {
+			return 0, err
+		}
+		d, err := idx.db.DatasetColumnDomains(datasetID)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range cols {
+			types[c.ColumnID], uniqueness[c.ColumnID], domains[c.ColumnID] = t[c.ColumnID], u[c.ColumnID], d[c.ColumnID]
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	refreshed := make(map[string]bool, len(idx.refreshed)+len(changed))
+	for datasetID := range idx.refreshed {
+		refreshed[datasetID] = true
+	}
+	isChanged := make(map[string]bool, len(changed))
+	for _, datasetID := range changed {
+		refreshed[datasetID] = true
+		isChanged[datasetID] = true
+	}
+	var delta []*database.ColumnSketch
+	for _, c := range idx.delta {
+		if !isChanged[c.DatasetID] {
+			delta = append(delta, c)
+		}
+	}
+	idx.refreshed, idx.delta = refreshed, append(delta, columns...)
+
+	if idx.types == nil {
+		idx.types = make(map[string]coltype.Type)
+	}
+	if idx.uniqueness == nil {
+		idx.uniqueness = make(map[string]float64)
+	}
+	if idx.domains == nil {
+		idx.domains = make(map[string]coldomain.Domain)
+	}
+	for _, c := range columns {
+		idx.types[c.ColumnID] = types[c.ColumnID]
+		idx.uniqueness[c.ColumnID] = uniqueness[c.ColumnID]
+		idx.domains[c.ColumnID] = domains[c.ColumnID]
+	}
+	if idx.versions == nil {
+		idx.versions = make(map[string]int64)
+	}
+	for _, datasetID := range changed {
+		if v, ok := versions[datasetID]; ok {
+			idx.versions[datasetID] = v
+		} else {
+			delete(idx.versions, datasetID)
+		}
+	}
+	return len(changed), nil
+}
+
+// FindJoinable returns the candidate join partners of the named column of a
+// dataset (see Joinable).
+func (idx *JoinabilityIndex) FindJoinable(datasetID, column string, threshold float64) ([]*JoinCandidate, error) {
+	query, err := idx.db.ColumnSketchByName(datasetID, column)
+	if err != nil {
+		return nil, err
+	}
+	return idx.Joinable(query, threshold)
+}
+
+// Joinable returns the columns other than query that contain at least the
+// fraction threshold of the values of query, sorted by containment, with
+// keyPreference added to that of candidate keys. The index
+// finds the candidates, whose overlap with query is then estimated from the
+// minhash signatures and distinct counts (see lazo.Overlap) to drop the false
+// positives of the LSH. Columns whose inferred types are incompatible with that
+// of query (see coltype.Compatible) are left out, as their shared values are
+// coincidences, e.g. numeric IDs matching the digits of dates.
+func (idx *JoinabilityIndex) Joinable(query *database.ColumnSketch, threshold float64) ([]*JoinCandidate, error) {
+	done := make(chan struct{})
+	defer close(done)
+	keys := idx.Query(query.Minhash, query.DistinctCount, threshold, done)
+
+	var candidates []*JoinCandidate
+	for key := range keys {
+		columnID := key.(string)
+		if columnID == query.ColumnID || !idx.Compatible(query.ColumnID, columnID) {
+			continue
+		}
+		c, err := idx.db.ColumnSketch(columnID)
+		if err != nil {
+			return nil, err
+		}
+		overlap := lazo.Overlap(query.Minhash, c.Minhash, query.DistinctCount, c.DistinctCount)
+		if overlap.Containment < threshold {
+			continue
+		}
+		candidates = append(candidates, idx.candidate(c, overlap))
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].rank() > candidates[j].rank()
+	})
+	return candidates, nil
+}
+
+// candidate returns the JoinCandidate of the column c with the given overlap.
+func (idx *JoinabilityIndex) candidate(c *database.ColumnSketch, overlap lazo.Estimate) *JoinCandidate {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return &JoinCandidate{c, overlap, idx.uniqueness[c.ColumnID]}
+}
+
+// rank returns the containment of c, plus keyPreference if it is a candidate
+// key.
+func (c *JoinCandidate) rank() float64 {
+	if c.Key() {
+		return c.Containment + keyPreference
+	}
+	return c.Containment
+}
+
+// Compatible reports whether the columns with the given IDs have compatible
+// inferred types. Columns without an inferred type are compatible with all
+// columns.
+func (idx *JoinabilityIndex) Compatible(columnID1, columnID2 string) bool {
+	return coltype.Compatible(idx.columnType(columnID1), idx.columnType(columnID2))
+}
+
+// Refreshed returns the number of datasets refreshed since the index was
+// built.
+func (idx *JoinabilityIndex) Refreshed() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.refreshed)
+}
+
+// columnType returns the inferred type of the column with the given ID.
+func (idx *JoinabilityIndex) columnType(columnID string) coltype.Type {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.types[columnID]
+}
+
+// columnDomain returns the semantic domain of the column with the given ID.
+func (idx *JoinabilityIndex) columnDomain(columnID string) coldomain.Domain {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.domains[columnID]
 }
@@ -0,0 +1,271 @@
+package index
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// Seed of the random hyperplanes of LSHForestIndex, fixed so that an index
+// hashes the same vectors the same way across rebuilds and processes.
+const lshForestSeed = 1
+
+// LSHForestIndex is an Index that performs approximate nearest neighbor search
+// with an LSH forest over random hyperplane (SimHash) signatures.
+//
+// Each of the trees hashes a vector to a signature of up to 64 bits, one per
+// random hyperplane, whose bits are set if the vector is on the positive side
+// of the hyperplane. Vectors with a small angle between them share long
+// signature prefixes. A query gathers candidates from all trees sharing the
+// longest prefixes with the query signature, shortening the prefixes until
+// there are at least k candidates per tree, and ranks the candidates by their
+// exact cosine similarity. More trees increase recall at the cost of latency.
+type LSHForestIndex struct {
+	dim   int
+	trees []*lshTree
+	// Maps ID of vector in index to dataset ID.
+	idMap []string
+	// The indexed (normalized) vectors, used to rank candidates.
+	vecs []float32
+}
+
+// lshTree is a prefix tree of signatures, stored as the sorted signatures of
+// the indexed vectors.
+type lshTree struct {
+	// bits hyperplanes of dimension dim, concatenated.
+	planes []float32
+	bits   int
+	// Sorted signatures and the ID in the index of the corresponding vector.
+	sigs []uint64
+	ids  []int32
+}
+
+// NewLSHForestIndex creates an empty LSHForestIndex with the given number of
+// trees, each hashing vectors to signatures of the given number of bits
+// (at most 64).
+func NewLSHForestIndex(dim, trees, bits int) (*LSHForestIndex, error) {
+	if dim < 1 || trees < 1 || bits < 1 || bits > 64 {
+		return nil, fmt.Errorf("lshforest index: invalid dimension %d, trees %d, or bits %d",
+			dim, trees, bits)
+	}
+	r := rand.New(rand.NewSource(lshForestSeed))
+	idx := &LSHForestIndex{dim: dim}
+	for i := 0; i < trees; i++ {
+		t := &lshTree{planes: make([]float32, bits*dim), bits: bits}
+		for j := range t.planes {
+			t.planes[j] = float32(r.NormFloat64())
+		}
+		idx.trees = append(idx.trees, t)
+	}
+	return idx, nil
+}
+
+// parseLSHForest parses the "[<trees>[:<bits>]]" argument of the lshforest
+// backend. trees defaults to 8 and bits to 16.
+func parseLSHForest(arg string) (trees, bits int, err error) {
+	trees, bits = 8, 16
+	if arg == "" {
+		return trees, bits, nil
+	}
+	parts := strings.Split(arg, ":")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("lshforest index: invalid argument %q", arg)
+	}
+	if trees, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("lshforest index: invalid number of trees %q", parts[0])
+	}
+	if len(parts) == 2 {
+		if bits, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, fmt.Errorf("lshforest index: invalid number of bits %q", parts[1])
+		}
+	}
+	return trees, bits, nil
+}
+
+// signature returns the signature of the vector, with the bit of the first
+// hyperplane in the most significant bit.
+func (t *lshTree) signature(vec []float32) uint64 {
+	dim := len(vec)
+	var sig uint64
+	for b := 0; b < t.bits; b++ {
+		if vec32.Dot(vec, t.planes[b*dim:(b+1)*dim]) >= 0 {
+			sig |= 1 << uint(63-b)
+		}
+	}
+	return sig
+}
+
+// prefixRange returns the range of t.sigs sharing the first n bits of sig.
+func (t *lshTree) prefixRange(sig uint64, n int) (lo, hi int) {
+	// Shifting by 64 gives 0, so n = 0 matches every signature.
+	mask := ^uint64(0) << uint(64-n)
+	prefix := sig & mask
+	lo = sort.Search(len(t.sigs), func(i int) bool { return t.sigs[i] >= prefix })
+	hi = sort.Search(len(t.sigs), func(i int) bool { return t.sigs[i] > prefix|^mask })
+	return lo, hi
+}
+
+func (t *lshTree) Len() int           { return len(t.sigs) }
+func (t *lshTree) Less(i, j int) bool { return t.sigs[i] < t.sigs[j] }
+func (t *lshTree) Swap(i, j int) {
+	t.sigs[i], t.sigs[j] = t.sigs[j], t.sigs[i]
+	t.ids[i], t.ids[j] = t.ids[j], t.ids[i]
+}
+
+// Dim returns the vector dimension.
+func (idx *LSHForestIndex) Dim() int {
+	return idx.dim
+}
+
+// Build replaces the contents of the index with the given vectors.
+func (idx *LSHForestIndex) Build(ids []string, vecs []float32) error {
+	for _, t := range idx.trees {
+		t.sigs, t.ids = nil, nil
+	}
+	idx.idMap = nil
+	idx.vecs = nil
+	return idx.Add(ids, vecs)
+}
+
+// Add adds the given vectors to the index.
+func (idx *LSHForestIndex) Add(ids []string, vecs []float32) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if len(vecs) != len(ids)*idx.dim {
+		return fmt.Errorf("got %d values for %d vectors of dimension %d",
+			len(vecs), len(ids), idx.dim)
+	}
+	vecs = normalized(vecs, idx.dim)
+	first := len(idx.idMap)
+	for _, t := range idx.trees {
+		for i := range ids {
+			t.sigs = append(t.sigs, t.signature(vecs[i*idx.dim:(i+1)*idx.dim]))
+			t.ids = append(t.ids, int32(first+i))
+		}
+		sort.Sort(t)
+	}
+	idx.idMap = append(idx.idMap, ids...)
+	idx.vecs = append(idx.vecs, vecs...)
+	return nil
+}
+
+// Delete frees the memory associated with the index.
+func (idx *LSHForestIndex) Delete() {}
+
+// Query queries the index with vec.
+//
+// Returns the dataset IDs of the (up to) k approximate nearest neighbors and
+// the corresponding cosine similarity, sorted by similarity.
+func (idx *LSHForestIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
+	if len(vec) != idx.dim {
+		return nil, nil, fmt.Errorf("got query vector of dimension %d, want %d", len(vec), idx.dim)
+	}
+	vec = normalized(vec, idx.dim)
+	top := &neighborHeap{}
+	for _, id := range idx.candidates(vec, k) {
+		sim := vec32.Dot(vec, idx.vecs[int(id)*idx.dim:(int(id)+1)*idx.dim])
+		top.offer(neighbor{int64(id), sim}, k)
+	}
+	datasets := make([]string, top.Len())
+	sims := make([]float32, top.Len())
+	for i := top.Len() - 1; i >= 0; i-- {
+		nb := heap.Pop(top).(neighbor)
+		datasets[i], sims[i] = idx.idMap[nb.id], nb.sim
+	}
+	return datasets, sims, nil
+}
+
+// candidates returns the IDs in the index of the vectors sharing the longest
+// signature prefixes with the normalized query vector vec, descending all
+// trees in step until there are at least k candidates per tree.
+func (idx *LSHForestIndex) candidates(vec []float32, k int64) []int32 {
+	if k < 1 || len(idx.idMap) == 0 {
+		return nil
+	}
+	want := int(k) * len(idx.trees)
+	seen := make(map[int32]bool)
+	var cands []int32
+
+	sigs := make([]uint64, len(idx.trees))
+	// Range of each tree's signatures already added to cands.
+	lo := make([]int, len(idx.trees))
+	hi := make([]int, len(idx.trees))
+	for i, t := range idx.trees {
+		sigs[i] = t.signature(vec)
+		lo[i], hi[i] = t.prefixRange(sigs[i], t.bits)
+		hi[i] = lo[i]
+	}
+	add := func(t *lshTree, from, to int) {
+		for _, id := range t.ids[from:to] {
+			if !seen[id] {
+				seen[id] = true
+				cands = append(cands, id)
+			}
+		}
+	}
+	for n := idx.trees[0].bits; n >= 0 && len(cands) < want; n-- {
+		for i, t := range idx.trees {
+			l, h := t.prefixRange(sigs[i], n)
+			add(t, l, lo[i])
+			add(t, hi[i], h)
+			lo[i], hi[i] = l, h
+		}
+	}
+	return cands
+}
+
+// QueryBatch queries the index with each of the concatenated query vectors in
+// vecs.
+//
+// Returns the results of Query for each query vector.
+func (idx *LSHForestIndex) QueryBatch(vecs []float32, k int64) ([][]string, [][]float32, error) {
+	nq := len(vecs) / idx.dim
+	datasets := make([][]string, nq)
+	sims := make([][]float32, nq)
+	for q := range datasets {
+		var err error
+		datasets[q], sims[q], err = idx.Query(vecs[q*idx.dim:(q+1)*idx.dim], k)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return datasets, sims, nil
+}
+
+// Save writes the contents of the index to w.
+// The trees are rebuilt when the index is loaded.
+func (idx *LSHForestIndex) Save(w io.Writer) error {
+	return writeVectors(w, idx.dim, idx.idMap, idx.vecs, nil)
+}
+
+// Load replaces the contents of the index with the contents written by Save.
+func (idx *LSHForestIndex) Load(r io.Reader) error {
+	dim, ids, vecs, err := readVectors(r)
+	if err != nil {
+		return err
+	}
+	if dim != idx.dim {
+		return fmt.Errorf("loading index: dimension is %d, want %d", dim, idx.dim)
+	}
+	return idx.Build(ids, vecs)
+}
+
+// Stats describes the contents of the index.
+func (idx *LSHForestIndex) Stats() *Stats {
+	n := len(idx.idMap)
+	// Each tree holds a signature and an ID per vector.
+	treeBytes := int64(len(idx.trees)) * int64(n) * (8 + 4)
+	return &Stats{
+		Kind:        "lshforest",
+		Vectors:     n,
+		Dim:         idx.dim,
+		MemoryBytes: vectorBytes(n, idx.dim) + stringsBytes(idx.idMap) + treeBytes,
+	}
+}
@@ -0,0 +1,127 @@
+package index
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestParseLSHForest(t *testing.T) {
+	for _, tt := range []struct {
+		arg         string
+		trees, bits int
+	}{
+		{"", 8, 16},
+		{"32", 32, 16},
+		{"4:20", 4, 20},
+	} {
+		trees, bits, err := parseLSHForest(tt.arg)
+		if err != nil || trees != tt.trees || bits != tt.bits {
+			t.Errorf("parseLSHForest(%q) = %d, %d, %v, want %d, %d",
+				tt.arg, trees, bits, err, tt.trees, tt.bits)
+		}
+	}
+	for _, backend := range []string{"lshforest:x", "lshforest:4:x", "lshforest:4:8:1", "lshforest:4:65", "lshforest:0"} {
+		if _, err := New(backend, 8); err == nil {
+			t.Errorf("New(%q) succeeded", backend)
+		}
+	}
+}
+
+func TestLSHForestRecall(t *testing.T) {
+	const n, dim, nq, k = 1000, 16, 20, 10
+	r := rand.New(rand.NewSource(1))
+	ids, vecs := randomVectors(r, n, dim)
+	_, queries := randomVectors(r, nq+1, dim)
+	queries = queries[:nq*dim]
+
+	exact, err := NewFlatIndex(dim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exact.Delete()
+	if err := exact.Build(ids, vecs); err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := exact.QueryBatch(queries, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prev float64
+	for _, trees := range []int{1, 8, 32} {
+		idx, err := NewLSHForestIndex(dim, trees, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.Build(ids, vecs); err != nil {
+			t.Fatal(err)
+		}
+		got, sims, err := idx.QueryBatch(queries, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var recall float64
+		for q := range got {
+			if len(got[q]) != k {
+				t.Fatalf("%d trees: got %d results, want %d", trees, len(got[q]), k)
+			}
+			for i := 1; i < len(sims[q]); i++ {
+				if sims[q][i] > sims[q][i-1] {
+					t.Fatalf("%d trees: results not sorted by similarity: %v", trees, sims[q])
+				}
+			}
+			recall += recallOf(got[q], want[q])
+		}
+		recall /= nq
+		t.Logf("%d trees: recall@%d %.3f", trees, k, recall)
+		if recall < prev {
+			t.Errorf("recall with %d trees is %.3f, less than %.3f with fewer trees", trees, recall, prev)
+		}
+		prev = recall
+	}
+	if prev < 0.9 {
+		t.Errorf("recall with 32 trees is %.3f, want at least 0.9", prev)
+	}
+}
+
+func TestLSHForestSaveLoad(t *testing.T) {
+	const n, dim = 50, 8
+	r := rand.New(rand.NewSource(2))
+	ids, vecs := randomVectors(r, n, dim)
+
+	idx, err := NewLSHForestIndex(dim, 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Build(ids[:n/2], vecs[:n/2*dim]); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Add(ids[n/2:], vecs[n/2*dim:]); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := idx.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewLSHForestIndex(dim, 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n-1; i++ {
+		q := vecs[i*dim : (i+1)*dim]
+		want, _, _ := idx.Query(q, 5)
+		got, _, _ := loaded.Query(q, 5)
+		if len(got) == 0 || got[0] != ids[i] || len(got) != len(want) {
+			t.Fatalf("query %s: got %v, want %v", ids[i], got, want)
+		}
+		for j := range want {
+			if got[j] != want[j] {
+				t.Fatalf("query %s: got %v, want %v", ids[i], got, want)
+			}
+		}
+	}
+}
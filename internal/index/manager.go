@@ -0,0 +1,252 @@
+package index
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Updater is implemented by indexes that support updating a dataset's vector
+// in place.
+type Updater interface {
+	// Update replaces the vector for a dataset, or adds it if the dataset is
+	// not in the index.
+	Update(datasetID string, vec []float32) error
+}
+
+// Remover is implemented by indexes that support removing a dataset's vector.
+type Remover interface {
+	// Remove removes the vector for a dataset, if it is in the index.
+	Remove(datasetID string) error
+}
+
+// BuildFunc builds a new index.
+type BuildFunc func() (Querier, error)
+
+// Manager holds the index used to serve queries and rebuilds it in the
+// background.
+//
+// A rebuilt index is swapped in atomically once it is fully built, so queries
+// are never served from a half-built index. The old index is freed after the
+// queries using it have finished.
+type Manager struct {
+	name    string
+	build   BuildFunc
+	mu      sync.RWMutex // Guards current, builtAt, and pending
+	current Querier
+	builtAt time.Time
+	// Updates made while a rebuild is in progress, which are applied to the
+	// new index before it is swapped in; removals have a nil vector. Nil if no
+	// rebuild is in progress.
+	pending map[string][]float32
+	trigger chan struct{}
+}
+
+// NewManager builds the initial index with build and returns a Manager for it.
+// The name is used in log messages.
+func NewManager(name string, build BuildFunc) (*Manager, error) {
+	idx, err := build()
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		name:    name,
+		build:   build,
+		current: idx,
+		builtAt: time.Now(),
+		trigger: make(chan struct{}, 1),
+	}, nil
+}
+
+// Query queries the current index with vec.
+func (m *Manager) Query(vec []float32, k int64) ([]string, []float32, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current.Query(vec, k)
+}
+
+// QueryBatch queries the current index with each of the concatenated query
+// vectors in vecs.
+// It returns an error if the current index does not implement BatchQuerier.
+func (m *Manager) QueryBatch(vecs []float32, k int64) ([][]string, [][]float32, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	q, ok := m.current.(BatchQuerier)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s index does not support batch queries", m.name)
+	}
+	return q.QueryBatch(vecs, k)
+}
+
+// QueryThreshold queries the current index for all vectors whose cosine
+// similarity with vec is greater than minSim.
+// It returns an error if the current index does not implement RangeQuerier.
+func (m *Manager) QueryThreshold(vec []float32, minSim float32) ([]string, []float32, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	q, ok := m.current.(RangeQuerier)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s index does not support threshold queries", m.name)
+	}
+	return q.QueryThreshold(vec, minSim)
+}
+
+// Do calls f with the current index.
+// The index is not swapped out or freed until f returns.
+func (m *Manager) Do(f func(Querier) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return f(m.current)
+}
+
+// BuiltAt returns the time the current index was built.
+func (m *Manager) BuiltAt() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.builtAt
+}
+
+// Rebuild builds a new index and swaps it in place of the current index.
+// If the build fails, the current index continues to be used.
+func (m *Manager) Rebuild() error {
+	start := time.Now()
+	m.mu.Lock()
+	m.pending = make(map[string][]float32)
+	m.mu.Unlock()
+
+	idx, err := m.build()
+	if err != nil {
+		m.mu.Lock()
+		m.pending = nil
+		m.mu.Unlock()
+		return err
+	}
+	m.mu.Lock()
+	if u, ok := idx.(Updater); ok {
+		for datasetID, vec := range m.pending {
+			if vec == nil {
+				if _, err := remove(idx, datasetID); err != nil {
+					log.Printf("rebuilding %s index: replaying removal of %s: %v",
+						m.name, datasetID, err)
+				}
+				continue
+			}
+			if err := u.Update(datasetID, vec); err != nil {
+				log.Printf("rebuilding %s index: replaying update of %s: %v",
+					m.name, datasetID, err)
+			}
+		}
+	}
+	m.pending = nil
+	old := m.current
+	m.current = idx
+	m.builtAt = time.Now()
+	m.mu.Unlock()
+
+	if d, ok := old.(interface{ Delete() }); ok {
+		d.Delete()
+	}
+	log.Printf("rebuilt %s index in %v", m.name, time.Since(start))
+	return nil
+}
+
+// Update updates the vector for a dataset in the current index.
+// It returns an error if the current index does not implement Updater.
+func (m *Manager) Update(datasetID string, vec []float32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.current.(Updater)
+	if !ok {
+		return fmt.Errorf("%s index does not support updates", m.name)
+	}
+	if err := u.Update(datasetID, vec); err != nil {
+		return err
+	}
+	if m.pending != nil {
+		m.pending[datasetID] = vec
+	}
+	return nil
+}
+
+// tombstoner is implemented by indexes that can remove a dataset's vector,
+// such as ShardedIndex.
+type tombstoner interface {
+	Remove(datasetID string) bool
+}
+
+// remove removes the vector for a dataset from idx, if idx is a Remover or a
+// tombstoner. It reports whether idx supports removals.
+func remove(idx Querier, datasetID string) (bool, error) {
+	switch r := idx.(type) {
+	case Remover:
+		return true, r.Remove(datasetID)
+	case tombstoner:
+		r.Remove(datasetID)
+		return true, nil
+	}
+	return false, nil
+}
+
+// Remove removes the vector for a dataset from the current index.
+// It returns an error if the current index does not support removals.
+func (m *Manager) Remove(datasetID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ok, err := remove(m.current, datasetID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s index does not support removals", m.name)
+	}
+	if m.pending != nil {
+		m.pending[datasetID] = nil
+	}
+	return nil
+}
+
+// Trigger requests a rebuild from Run.
+// It does not block; a request made while one is pending is dropped.
+func (m *Manager) Trigger() {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run rebuilds the index every interval, and whenever Trigger is called, until
+// done is closed.
+// If interval is zero, the index is only rebuilt on Trigger.
+func (m *Manager) Run(interval time.Duration, done <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick:
+		case <-m.trigger:
+		}
+		if err := m.Rebuild(); err != nil {
+			log.Printf("rebuilding %s index: %v", m.name, err)
+		}
+	}
+}
+
+// Delete frees the memory associated with the current index.
+func (m *Manager) Delete() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d, ok := m.current.(interface{ Delete() }); ok {
+		d.Delete()
+	}
+}
@@ -0,0 +1,188 @@
+package index
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testIndex is an index of the vectors of the datasets in vecs, whose queries
+// return all the datasets in ID order.
+type testIndex struct {
+	vecs    map[string][]float32
+	deleted bool
+}
+
+func newTestIndex(ids ...string) *testIndex {
+	idx := &testIndex{vecs: make(map[string][]float32)}
+	for _, id := range ids {
+		idx.vecs[id] = []float32{1, 0}
+	}
+	return idx
+}
+
+func (idx *testIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
+	var ids []string
+	for id := range idx.vecs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, make([]float32, len(ids)), nil
+}
+
+func (idx *testIndex) Update(datasetID string, vec []float32) error {
+	idx.vecs[datasetID] = vec
+	return nil
+}
+
+func (idx *testIndex) Remove(datasetID string) error {
+	delete(idx.vecs, datasetID)
+	return nil
+}
+
+func (idx *testIndex) Delete() {
+	idx.deleted = true
+}
+
+func TestManagerRebuild(t *testing.T) {
+	// The datasets in the database, read by each build.
+	ids := []string{"a", "b"}
+	var buildErr error
+	// Closed when the rebuild has started, and by the test to let it finish.
+	var started, finish chan struct{}
+	var built []*testIndex
+	m, err := NewManager("test", func() (Querier, error) {
+		if buildErr != nil {
+			return nil, buildErr
+		}
+		idx := newTestIndex(ids...)
+		built = append(built, idx)
+		if started != nil {
+			close(started)
+			<-finish
+		}
+		return idx, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func() []string {
+		t.Helper()
+		got, _, err := m.Query([]float32{1, 0}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	ids = append(ids, "c")
+	started, finish = make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() { done <- m.Rebuild() }()
+	<-started
+	if got := query(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("got datasets %q during the rebuild, want those of the old index [a b]", got)
+	}
+	// Changes made during the rebuild, to the vectors read before them, are
+	// replayed on the new index.
+	for id, vec := range map[string][]float32{"a": {0, 1}, "d": {1, 1}} {
+		if err := m.Update(id, vec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Remove("b"); err != nil {
+		t.Fatal(err)
+	}
+	close(finish)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := query(); !reflect.DeepEqual(got, []string{"a", "c", "d"}) {
+		t.Errorf("got datasets %q after the rebuild, want [a c d]", got)
+	}
+	if vec := built[1].vecs["a"]; !reflect.DeepEqual(vec, []float32{0, 1}) {
+		t.Errorf("got vector %v of a after the rebuild, want its update [0 1]", vec)
+	}
+	if !built[0].deleted || built[1].deleted {
+		t.Error("old index not freed after the rebuild, or new index freed")
+	}
+
+	// A failed build keeps the current index.
+	buildErr = errors.New("build failed")
+	if err := m.Rebuild(); err != buildErr {
+		t.Errorf("got error %v, want the error of the build", err)
+	}
+	if got := query(); !reflect.DeepEqual(got, []string{"a", "c", "d"}) || built[1].deleted {
+		t.Errorf("got datasets %q after a failed rebuild, want those of the current index", got)
+	}
+}
+
+func TestManagerRebuildConcurrentChanges(t *testing.T) {
+	for _, backend := range []string{"flat", "sharded"} {
+		// The vectors in the database, read by each build.
+		ids := []string{"a", "b", "c"}
+		vecs := []float32{1, 0, 0, 1, 1, 1}
+		// Closed when the rebuild has started, and by the test to let it
+		// finish.
+		var started, finish chan struct{}
+		m, err := NewManager("test", func() (Querier, error) {
+			var idx Index
+			var err error
+			if backend == "flat" {
+				idx, err = NewFlatIndex(2)
+			} else {
+				idx, err = NewShardedIndex(2, 2)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := idx.Build(ids, vecs); err != nil {
+				return nil, err
+			}
+			if started != nil {
+				close(started)
+				<-finish
+			}
+			return idx, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		started, finish = make(chan struct{}), make(chan struct{})
+		done := make(chan error)
+		go func() { done <- m.Rebuild() }()
+		<-started
+		// Changes made while the index is rebuilt from the vectors read
+		// before them.
+		for _, err := range []error{
+			m.Update("a", []float32{-1, 0}),
+			m.Update("d", []float32{1, -1}),
+			m.Remove("b"),
+			m.Update("e", []float32{0, -1}),
+			m.Remove("e"),
+		} {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		close(finish)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		got, sims, err := m.Query([]float32{-1, 0}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) == 0 || got[0] != "a" || sims[0] < 0.999 {
+			t.Errorf("%s: got %q (%v), want the updated vector of a first", backend, got, sims)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, []string{"a", "c", "d"}) {
+			t.Errorf("%s: got datasets %q after the rebuild, want [a c d]", backend, got)
+		}
+		m.Delete()
+	}
+}
@@ -0,0 +1,279 @@
+package index
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// mmapMagic identifies files of memory-mapped vectors.
+const mmapMagic = "ODLM"
+
+// Size of the mmapVectors file header: magic, dimension, and vector count.
+const mmapHeaderSize = 16
+
+// mmapVectors is an exact inner product search over vectors stored in a
+// memory-mapped file, so that the vectors can exceed RAM at the cost of reading
+// them from disk when they are not in the page cache.
+//
+// The vectors are numbered in the order they were written and stored in the
+// machine's native byte order, so files are not portable across architectures.
+type mmapVectors struct {
+	data []byte
+	// View of the vectors in data.
+	vecs []float32
+	dim  int
+}
+
+// mmapWriter writes vectors to a file to be opened with openMmapVectors.
+type mmapWriter struct {
+	f   *os.File
+	bw  *bufio.Writer
+	dim int
+	n   uint64
+}
+
+// createMmapVectors creates a file at path to write vectors of the given
+// dimension to.
+func createMmapVectors(path string, dim int) (*mmapWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &mmapWriter{f: f, bw: bufio.NewWriter(f), dim: dim}
+	// The vector count is filled in by close.
+	if _, err := w.bw.Write(make([]byte, mmapHeaderSize)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// add appends a vector to the file.
+func (w *mmapWriter) add(vec []float32) error {
+	if len(vec) != w.dim {
+		return fmt.Errorf("got vector of dimension %d, want %d", len(vec), w.dim)
+	}
+	if _, err := w.bw.Write(float32Bytes(vec)); err != nil {
+		return err
+	}
+	w.n++
+	return nil
+}
+
+// close writes the file header and closes the file.
+func (w *mmapWriter) close() error {
+	if err := w.bw.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	header := make([]byte, mmapHeaderSize)
+	copy(header, mmapMagic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(w.dim))
+	binary.LittleEndian.PutUint64(header[8:], w.n)
+	if _, err := w.f.WriteAt(header, 0); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// openMmapVectors maps the vectors in the file written by an mmapWriter at
+// path into memory.
+func openMmapVectors(path string) (*mmapVectors, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, mmapHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if string(header[:4]) != mmapMagic {
+		return nil, fmt.Errorf("%s: not a vector file", path)
+	}
+	dim := int(binary.LittleEndian.Uint32(header[4:]))
+	n := binary.LittleEndian.Uint64(header[8:])
+	if dim < 1 {
+		return nil, fmt.Errorf("%s: invalid dimension %d", path, dim)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := mmapHeaderSize + int64(n)*int64(dim)*4
+	if fi.Size() != size {
+		return nil, fmt.Errorf("%s: size is %d, want %d for %d vectors", path, fi.Size(), size, n)
+	}
+	data, err := mmap(f, int(size))
+	if err != nil {
+		return nil, err
+	}
+	m := &mmapVectors{data: data, dim: dim}
+	if n > 0 {
+		sh := (*reflect.SliceHeader)(unsafe.Pointer(&m.vecs))
+		sh.Data = uintptr(unsafe.Pointer(&data[mmapHeaderSize]))
+		sh.Len = int(n) * dim
+		sh.Cap = sh.Len
+	}
+	return m, nil
+}
+
+// float32Bytes returns the memory of vec as bytes in native byte order.
+func float32Bytes(vec []float32) []byte {
+	var b []byte
+	if len(vec) > 0 {
+		sh := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+		sh.Data = uintptr(unsafe.Pointer(&vec[0]))
+		sh.Len = 4 * len(vec)
+		sh.Cap = sh.Len
+	}
+	return b
+}
+
+// D returns the vector dimension.
+func (m *mmapVectors) D() int {
+	return m.dim
+}
+
+// Delete unmaps the vectors.
+func (m *mmapVectors) Delete() {
+	if m.data != nil {
+		munmap(m.data)
+		m.data, m.vecs = nil, nil
+	}
+}
+
+func (m *mmapVectors) count() int {
+	return len(m.vecs) / m.dim
+}
+
+// scan calls f with each range of vector numbers [start, end), in parallel.
+func (m *mmapVectors) scan(f func(start, end int)) {
+	n := m.count()
+	workers := runtime.GOMAXPROCS(0)
+	chunk := (n + workers - 1) / workers
+	if chunk < 1024 {
+		chunk = 1024
+	}
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += chunk {
+		end := start + chunk
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			f(start, end)
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// Search returns the similarities and numbers of the k nearest neighbors of
+// each of the concatenated query vectors in x, sorted by similarity and padded
+// with -1 labels, as faiss does.
+func (m *mmapVectors) Search(x []float32, k int64) ([]float32, []int64, error) {
+	if len(x)%m.dim != 0 {
+		return nil, nil, errors.New("query vector length is not a multiple of the dimension")
+	}
+	nq := len(x) / m.dim
+	dist := make([]float32, int64(nq)*k)
+	labels := make([]int64, int64(nq)*k)
+
+	for q := 0; q < nq; q++ {
+		qvec := x[q*m.dim : (q+1)*m.dim]
+		var mu sync.Mutex
+		top := &neighborHeap{}
+
+		m.scan(func(start, end int) {
+			local := &neighborHeap{}
+			for i := start; i < end; i++ {
+				local.offer(neighbor{int64(i), vec32.Dot(qvec, m.vecs[i*m.dim:(i+1)*m.dim])}, k)
+			}
+			mu.Lock()
+			for _, nb := range *local {
+				top.offer(nb, k)
+			}
+			mu.Unlock()
+		})
+
+		qdist, qlabels := dist[int64(q)*k:int64(q+1)*k], labels[int64(q)*k:int64(q+1)*k]
+		for i := range qlabels {
+			qlabels[i] = -1
+		}
+		for i := top.Len() - 1; i >= 0; i-- {
+			nb := heap.Pop(top).(neighbor)
+			qdist[i], qlabels[i] = nb.sim, nb.id
+		}
+	}
+	return dist, labels, nil
+}
+
+// rangeSearch returns the numbers and similarities of the vectors whose
+// similarity with x is greater than minSim, in no particular order.
+func (m *mmapVectors) rangeSearch(x []float32, minSim float32) ([]int64, []float32, error) {
+	if len(x) != m.dim {
+		return nil, nil, fmt.Errorf("got query vector of dimension %d, want %d", len(x), m.dim)
+	}
+	var mu sync.Mutex
+	var labels []int64
+	var dist []float32
+
+	m.scan(func(start, end int) {
+		var l []int64
+		var d []float32
+		for i := start; i < end; i++ {
+			if sim := vec32.Dot(x, m.vecs[i*m.dim:(i+1)*m.dim]); sim > minSim {
+				l, d = append(l, int64(i)), append(d, sim)
+			}
+		}
+		mu.Lock()
+		labels, dist = append(labels, l...), append(dist, d...)
+		mu.Unlock()
+	})
+	return labels, dist, nil
+}
+
+type neighbor struct {
+	id  int64
+	sim float32
+}
+
+// neighborHeap is a min-heap of neighbors by similarity.
+type neighborHeap []neighbor
+
+func (h neighborHeap) Len() int            { return len(h) }
+func (h neighborHeap) Less(i, j int) bool  { return h[i].sim < h[j].sim }
+func (h neighborHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *neighborHeap) Push(x interface{}) { *h = append(*h, x.(neighbor)) }
+func (h *neighborHeap) Pop() interface{} {
+	old := *h
+	nb := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return nb
+}
+
+// offer adds nb to the heap if it is among the k most similar neighbors seen.
+func (h *neighborHeap) offer(nb neighbor, k int64) {
+	if int64(h.Len()) < k {
+		heap.Push(h, nb)
+	} else if k > 0 && nb.sim > (*h)[0].sim {
+		(*h)[0] = nb
+		heap.Fix(h, 0)
+	}
+}
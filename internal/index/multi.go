@@ -0,0 +1,203 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/attributeembedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
+)
+
+// Fusion is a way of combining the rankings of the metadata and attribute
+// indexes into a single ranking.
+type Fusion int
+
+const (
+	// FuseRRF scores a dataset by the sum of 1/(RRFK + rank) over the
+	// rankings it appears in (reciprocal rank fusion). It does not depend on
+	// the scales of the scores of each index.
+	FuseRRF Fusion = iota
+	// FuseWeighted scores a dataset by the weighted sum of its metadata
+	// similarity and attribute score. Indexes a dataset does not appear in
+	// contribute 0.
+	FuseWeighted
+)
+
+var fusionNames = map[string]Fusion{
+	"rrf":      FuseRRF,
+	"weighted": FuseWeighted,
+}
+
+// ParseFusion returns the Fusion named "rrf" or "weighted".
+func ParseFusion(name string) (Fusion, error) {
+	f, ok := fusionNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown fusion %q (want rrf or weighted)", name)
+	}
+	return f, nil
+}
+
+// FusionConfig configures how a MultiIndexSearcher fuses rankings.
+type FusionConfig struct {
+	Method Fusion
+	// Weights of the metadata similarity and the attribute score with
+	// FuseWeighted.
+	MetadataWeight, AttributeWeight float32
+	// Rank offset of FuseRRF, which dampens the advantage of top ranks.
+	RRFK int
+	// How the attribute similarities of a dataset are combined into its
+	// attribute score.
+	Aggregation Aggregation
+	// Number of candidates fetched from each index per requested result.
+	Oversample int64
+	// Tokenizer of the query for the attribute index. If nil, the default
+	// abbreviations are expanded.
+	AttributeTokenizer *attributeembedding.Tokenizer
+}
+
+// DefaultFusionConfig returns a FusionConfig for reciprocal rank fusion with
+// the conventional rank offset of 60.
+func DefaultFusionConfig() *FusionConfig {
+	return &FusionConfig{
+		Method:          FuseRRF,
+		MetadataWeight:  0.5,
+		AttributeWeight: 0.5,
+		RRFK:            60,
+		Aggregation:     AggregateMax,
+		Oversample:      2,
+	}
+}
+
+// FusedMatch is a dataset matching a MultiIndexSearcher query.
+type FusedMatch struct {
+	DatasetID string
+	// Fused score.
+	Score float32
+	// Rank (starting at 1) and similarity of the dataset in the metadata
+	// index results, or 0 if it is not in them.
+	MetadataRank       int
+	MetadataSimilarity float32
+	// Rank (starting at 1) and score of the dataset in the attribute index
+	// results, or 0 if it is not in them.
+	AttributeRank  int
+	AttributeScore float32
+	// The dataset's matching attributes, sorted by similarity.
+	Attributes []*AttributeMatch
+}
+
+// MultiIndexSearcher searches the metadata and attribute embedding indexes in
+// parallel and fuses their results into a single ranked list of datasets.
+type MultiIndexSearcher struct {
+	metadata   Querier
+	attributes *AttributeIndex
+	embedder   embedding.Embedder
+	cfg        *FusionConfig
+}
+
+// NewMultiIndexSearcher returns a MultiIndexSearcher over the given indexes,
+// which embeds queries with e. If cfg is nil, DefaultFusionConfig is used.
+func NewMultiIndexSearcher(metadata Querier, attributes *AttributeIndex, e embedding.Embedder, cfg *FusionConfig) *MultiIndexSearcher {
+	if cfg == nil {
+		cfg = DefaultFusionConfig()
+	}
+	return &MultiIndexSearcher{metadata, attributes, e, cfg}
+}
+
+// Search returns the (up to) k best datasets for the free-text query, sorted by
+// fused score.
+//
+// The query is embedded as metadata text for the metadata index and as an
+// attribute name for the attribute index. If none of the query words have an
+// embedding, wordemb.ErrNoEmb is returned.
+func (s *MultiIndexSearcher) Search(query string, k int64) ([]*FusedMatch, error) {
+	metaVec, err := wordemb.Vector(s.embedder, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	var attrVec []float32
+	if s.cfg.AttributeTokenizer != nil {
+		attrVec, err = s.cfg.AttributeTokenizer.Vector(s.embedder, query)
+	} else {
+		attrVec, err = attributeembedding.Vector(s.embedder, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.SearchVectors(metaVec, attrVec, k)
+}
+
+// SearchVectors returns the (up to) k best datasets for the metadata query
+// vector metaVec and the attribute query vector attrVec, sorted by fused score.
+func (s *MultiIndexSearcher) SearchVectors(metaVec, attrVec []float32, k int64) ([]*FusedMatch, error) {
+	n := k * s.cfg.Oversample
+	if n < k {
+		n = k
+	}
+	var metaIDs []string
+	var metaSims []float32
+	var datasets []*DatasetMatch
+	var metaErr, attrErr error
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		metaIDs, metaSims, metaErr = s.metadata.Query(metaVec, n)
+	}()
+	go func() {
+		defer wg.Done()
+		datasets, attrErr = s.attributes.QueryDatasets(attrVec, n, s.cfg.Aggregation)
+	}()
+	wg.Wait()
+
+	if metaErr != nil {
+		return nil, metaErr
+	}
+	if attrErr != nil {
+		return nil, attrErr
+	}
+
+	var matches []*FusedMatch
+	byID := make(map[string]*FusedMatch)
+	match := func(datasetID string) *FusedMatch {
+		m := byID[datasetID]
+		if m == nil {
+			m = &FusedMatch{DatasetID: datasetID}
+			byID[datasetID] = m
+			matches = append(matches, m)
+		}
+		return m
+	}
+	for i, id := range metaIDs {
+		m := match(id)
+		m.MetadataRank, m.MetadataSimilarity = i+1, metaSims[i]
+	}
+	for i, d := range datasets {
+		m := match(d.DatasetID)
+		m.AttributeRank, m.AttributeScore, m.Attributes = i+1, d.Score, d.Attributes
+	}
+
+	for _, m := range matches {
+		switch s.cfg.Method {
+		case FuseRRF:
+			for _, rank := range []int{m.MetadataRank, m.AttributeRank} {
+				if rank > 0 {
+					m.Score += 1 / float32(s.cfg.RRFK+rank)
+				}
+			}
+		case FuseWeighted:
+			m.Score = s.cfg.MetadataWeight*m.MetadataSimilarity + s.cfg.AttributeWeight*m.AttributeScore
+		default:
+			return nil, fmt.Errorf("unknown fusion %d", s.cfg.Method)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if int64(len(matches)) > k {
+		matches = matches[:k]
+	}
+	return matches, nil
+}
@@ -0,0 +1,97 @@
+package index
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// randomVectors returns n random vectors of dimension dim that are not unit
+// vectors, the last of which is a zero vector.
+func randomVectors(r *rand.Rand, n, dim int) ([]string, []float32) {
+	ids := make([]string, n)
+	vecs := make([]float32, n*dim)
+	for i := range ids {
+		ids[i] = string(rune('a' + i))
+		if i == n-1 {
+			break
+		}
+		scale := 10 * r.Float32()
+		for j := 0; j < dim; j++ {
+			vecs[i*dim+j] = scale * float32(r.NormFloat64())
+		}
+	}
+	return ids, vecs
+}
+
+// checkSimilarities fails if any similarity is not in [-1, 1].
+func checkSimilarities(t *testing.T, backend string, sims []float32) {
+	t.Helper()
+	const eps = 1e-5
+	for _, sim := range sims {
+		if !(sim >= -1-eps && sim <= 1+eps) {
+			t.Errorf("%s: similarity %v not in [-1, 1]", backend, sim)
+		}
+	}
+}
+
+func TestSimilaritiesBounded(t *testing.T) {
+	const n, dim = 20, 8
+	r := rand.New(rand.NewSource(1))
+	ids, vecs := randomVectors(r, n, dim)
+	_, queries := randomVectors(r, 3, dim)
+
+	for _, backend := range []string{"flat", "sharded:3", "ivf:4:4", "lshforest:4:8", "rerank:2:ivf:4:1", "pca:8:flat"} {
+		idx, err := New(backend, dim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.Build(ids, vecs); err != nil {
+			t.Fatalf("%s: %v", backend, err)
+		}
+		for q := 0; q < len(queries)/dim; q++ {
+			got, sims, err := idx.Query(queries[q*dim:(q+1)*dim], n)
+			if err != nil {
+				t.Fatalf("%s: %v", backend, err)
+			}
+			if len(got) != n {
+				t.Errorf("%s: got %d results, want %d", backend, len(got), n)
+			}
+			checkSimilarities(t, backend, sims)
+		}
+		if b, ok := idx.(BatchQuerier); ok {
+			_, sims, err := b.QueryBatch(queries, n)
+			if err != nil {
+				t.Fatalf("%s: %v", backend, err)
+			}
+			for _, s := range sims {
+				checkSimilarities(t, backend, s)
+			}
+		}
+		// A vector is most similar to itself.
+		got, sims, err := idx.Query(vecs[:dim], 1)
+		if err != nil {
+			t.Fatalf("%s: %v", backend, err)
+		}
+		if len(got) != 1 || got[0] != ids[0] || sims[0] < 1-1e-5 {
+			t.Errorf("%s: nearest neighbor of %s is %v with similarity %v", backend, ids[0], got, sims)
+		}
+		idx.Delete()
+	}
+}
+
+func TestNormalizedDoesNotModifyInput(t *testing.T) {
+	vecs := []float32{3, 4, 0, 0, 0.6, 0.8}
+	got := normalized(vecs, 2)
+	want := []float32{0.6, 0.8, 0, 0, 0.6, 0.8}
+	for i := range want {
+		if d := got[i] - want[i]; d > 1e-6 || d < -1e-6 {
+			t.Fatalf("normalized(%v) = %v, want %v", vecs, got, want)
+		}
+	}
+	if vecs[0] != 3 {
+		t.Errorf("normalized modified its input: %v", vecs)
+	}
+	if unit := vecs[4:]; &normalized(unit, 2)[0] != &unit[0] {
+		t.Error("normalized copied unit vectors")
+	}
+}
@@ -0,0 +1,69 @@
+package index
+
+import (
+	"sort"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/numsketch"
+)
+
+// NumericIndex is an index over the sketches of the distributions of numeric
+// columns (see package numsketch), for finding columns with similar
+// distributions, e.g. to correlate or augment a column. Queries compare the
+// query with every sketch.
+type NumericIndex struct {
+	ids      []string
+	sketches []*numsketch.Sketch
+}
+
+// BuildNumericIndex builds a NumericIndex over the numeric column sketches.
+func BuildNumericIndex(db *database.DB) (*NumericIndex, error) {
+	sketches, err := db.NumericSketches()
+	if err != nil {
+		return nil, err
+	}
+	idx := &NumericIndex{}
+	for id := range sketches {
+		idx.ids = append(idx.ids, id)
+	}
+	sort.Strings(idx.ids)
+	for _, id := range idx.ids {
+		idx.sketches = append(idx.sketches, sketches[id])
+	}
+	return idx, nil
+}
+
+// Len returns the number of indexed columns.
+func (idx *NumericIndex) Len() int {
+	return len(idx.ids)
+}
+
+// Query returns the column IDs of the (up to) k columns whose distributions
+// are the most similar to s and the corresponding similarity (see
+// numsketch.Similarity), sorted by similarity.
+func (idx *NumericIndex) Query(s *numsketch.Sketch, k int) ([]string, []float64) {
+	return idx.QueryMetric(s, k, numsketch.MetricEMD)
+}
+
+// QueryMetric is like Query, comparing the distributions by the metric m.
+func (idx *NumericIndex) QueryMetric(s *numsketch.Sketch, k int, m numsketch.Metric) ([]string, []float64) {
+	order := make([]int, len(idx.ids))
+	sims := make([]float64, len(idx.ids))
+	for i, t := range idx.sketches {
+		order[i] = i
+		sims[i] = m.Compare(s, t)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sims[order[i]] > sims[order[j]]
+	})
+	if len(order) > k {
+		order = order[:k]
+	}
+	ids := make([]string, len(order))
+	topSims := make([]float64, len(order))
+	for i, o := range order {
+		ids[i] = idx.ids[o]
+		topSims[i] = sims[o]
+	}
+	return ids, topSims
+}
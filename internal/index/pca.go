@@ -0,0 +1,396 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// The pca backend is registered in init since it refers to New, which refers
+// to backends.
+func init() {
+	backends["pca"] = func(dim int, arg string) (Index, error) {
+		outDim, backend, err := parsePCA(arg)
+		if err != nil {
+			return nil, err
+		}
+		if outDim < 1 || outDim > dim {
+			return nil, fmt.Errorf("pca index: invalid output dimension %d for vectors of dimension %d", outDim, dim)
+		}
+		reduced, err := New(backend, outDim)
+		if err != nil {
+			return nil, err
+		}
+		return NewPCAIndex(reduced, dim), nil
+	}
+}
+
+// Maximum number of vectors the PCA transform is trained on. Larger inputs are
+// sampled evenly.
+const pcaMaxTraining = 100000
+
+// Maximum number of sweeps of the Jacobi eigenvalue algorithm, which usually
+// converges in less than 10.
+const jacobiSweeps = 50
+
+// PCAIndex is an Index that reduces the dimension of the vectors with a
+// principal component analysis (PCA) transform trained on the indexed vectors,
+// and indexes the reduced vectors in another index.
+//
+// This shrinks the memory of the index and speeds up queries at the cost of
+// approximate similarities, computed in the reduced space. Wrapping the index
+// in a RerankIndex ("rerank:4:pca:128:flat") restores exact similarities.
+type PCAIndex struct {
+	reduced Index
+	dim     int
+	// Mean of the training vectors, and the principal components as rows of
+	// dim values, or nil if the transform has not been trained.
+	mean []float32
+	proj []float32
+}
+
+// NewPCAIndex creates an empty PCAIndex over vectors of dimension dim, which
+// are reduced to the dimension of the (empty) index reduced, which it takes
+// ownership of.
+func NewPCAIndex(reduced Index, dim int) *PCAIndex {
+	return &PCAIndex{reduced: reduced, dim: dim}
+}
+
+// parsePCA parses the "<dim>:<backend>" argument of the pca backend.
+func parsePCA(arg string) (outDim int, backend string, err error) {
+	i := strings.IndexByte(arg, ':')
+	if i < 0 {
+		return 0, "", fmt.Errorf("pca index: invalid argument %q (want <dim>:<backend>)", arg)
+	}
+	if outDim, err = strconv.Atoi(arg[:i]); err != nil {
+		return 0, "", fmt.Errorf("pca index: invalid output dimension %q", arg[:i])
+	}
+	return outDim, arg[i+1:], nil
+}
+
+// Dim returns the vector dimension, before the reduction.
+func (idx *PCAIndex) Dim() int {
+	return idx.dim
+}
+
+// Build replaces the contents of the index with the given vectors, training
+// the PCA transform on them.
+func (idx *PCAIndex) Build(ids []string, vecs []float32) error {
+	if len(vecs) != len(ids)*idx.dim {
+		return fmt.Errorf("got %d values for %d vectors of dimension %d",
+			len(vecs), len(ids), idx.dim)
+	}
+	idx.mean, idx.proj = nil, nil
+	if len(ids) > 0 {
+		idx.train(normalized(vecs, idx.dim))
+	}
+	return idx.reduced.Build(ids, idx.transform(vecs))
+}
+
+// Add adds the given vectors to the index.
+// If the transform has not been trained, it is trained on the vectors first.
+func (idx *PCAIndex) Add(ids []string, vecs []float32) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if len(vecs) != len(ids)*idx.dim {
+		return fmt.Errorf("got %d values for %d vectors of dimension %d",
+			len(vecs), len(ids), idx.dim)
+	}
+	if idx.proj == nil {
+		idx.train(normalized(vecs, idx.dim))
+	}
+	return idx.reduced.Add(ids, idx.transform(vecs))
+}
+
+// train trains the transform on the normalized vectors: the principal
+// components are the eigenvectors of their covariance matrix with the largest
+// eigenvalues.
+func (idx *PCAIndex) train(vecs []float32) {
+	dim, outDim := idx.dim, idx.reduced.Dim()
+	n := len(vecs) / dim
+	step := 1
+	if n > pcaMaxTraining {
+		step = (n + pcaMaxTraining - 1) / pcaMaxTraining
+	}
+
+	mean := make([]float64, dim)
+	samples := 0
+	for i := 0; i < n; i += step {
+		for j, v := range vecs[i*dim : (i+1)*dim] {
+			mean[j] += float64(v)
+		}
+		samples++
+	}
+	for j := range mean {
+		mean[j] /= float64(samples)
+	}
+	cov := make([]float64, dim*dim)
+	x := make([]float64, dim)
+	for i := 0; i < n; i += step {
+		for j, v := range vecs[i*dim : (i+1)*dim] {
+			x[j] = float64(v) - mean[j]
+		}
+		for j := 0; j < dim; j++ {
+			row := cov[j*dim:]
+			for k := j; k < dim; k++ {
+				row[k] += x[j] * x[k]
+			}
+		}
+	}
+	for j := 0; j < dim; j++ {
+		for k := j; k < dim; k++ {
+			cov[j*dim+k] /= float64(samples)
+			cov[k*dim+j] = cov[j*dim+k]
+		}
+	}
+
+	values, vectors := eigenSymmetric(cov, dim)
+	order := make([]int, dim)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return values[order[a]] > values[order[b]] })
+
+	idx.mean = make([]float32, dim)
+	for j, m := range mean {
+		idx.mean[j] = float32(m)
+	}
+	idx.proj = make([]float32, outDim*dim)
+	for r := 0; r < outDim; r++ {
+		c := order[r]
+		for j := 0; j < dim; j++ {
+			idx.proj[r*dim+j] = float32(vectors[j*dim+c])
+		}
+	}
+}
+
+// eigenSymmetric returns the eigenvalues of the symmetric n×n matrix a (in
+// row-major order), which it overwrites, and the corresponding eigenvectors as
+// the columns of a matrix, computed with the cyclic Jacobi eigenvalue
+// algorithm.
+func eigenSymmetric(a []float64, n int) (values, vectors []float64) {
+	v := make([]float64, n*n)
+	for i := 0; i < n; i++ {
+		v[i*n+i] = 1
+	}
+	for sweep := 0; sweep < jacobiSweeps; sweep++ {
+		off, total := 0.0, 0.0
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if i != j {
+					off += a[i*n+j] * a[i*n+j]
+				}
+				total += a[i*n+j] * a[i*n+j]
+			}
+		}
+		if off <= 1e-24*total {
+			break
+		}
+		for p := 0; p < n-1; p++ {
+			for q := p + 1; q < n; q++ {
+				apq := a[p*n+q]
+				if apq == 0 {
+					continue
+				}
+				// Rotate the p and q rows and columns so that a[p][q]
+				// becomes zero.
+				theta := (a[q*n+q] - a[p*n+p]) / (2 * apq)
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					akp, akq := a[k*n+p], a[k*n+q]
+					a[k*n+p] = c*akp - s*akq
+					a[k*n+q] = s*akp + c*akq
+				}
+				for k := 0; k < n; k++ {
+					apk, aqk := a[p*n+k], a[q*n+k]
+					a[p*n+k] = c*apk - s*aqk
+					a[q*n+k] = s*apk + c*aqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := v[k*n+p], v[k*n+q]
+					v[k*n+p] = c*vkp - s*vkq
+					v[k*n+q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+	values = make([]float64, n)
+	for i := range values {
+		values[i] = a[i*n+i]
+	}
+	return values, v
+}
+
+// transform returns the concatenated vectors of dimension dim in vecs reduced
+// by the PCA transform.
+func (idx *PCAIndex) transform(vecs []float32) []float32 {
+	dim, outDim := idx.dim, idx.reduced.Dim()
+	n := len(vecs) / dim
+	out := make([]float32, n*outDim)
+	if idx.proj == nil {
+		return out
+	}
+	vecs = normalized(vecs, dim)
+	x := make([]float32, dim)
+	for i := 0; i < n; i++ {
+		for j, v := range vecs[i*dim : (i+1)*dim] {
+			x[j] = v - idx.mean[j]
+		}
+		for r := 0; r < outDim; r++ {
+			out[i*outDim+r] = vec32.Dot(idx.proj[r*dim:(r+1)*dim], x)
+		}
+	}
+	return out
+}
+
+// Delete frees the memory associated with the index.
+func (idx *PCAIndex) Delete() {
+	idx.reduced.Delete()
+}
+
+// Query queries the index with vec.
+//
+// Returns the dataset IDs of the (up to) k approximate nearest neighbors and
+// their cosine similarity in the reduced space, sorted by similarity.
+func (idx *PCAIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
+	if len(vec) != idx.dim {
+		return nil, nil, fmt.Errorf("query vector has dimension %d, want %d", len(vec), idx.dim)
+	}
+	return idx.reduced.Query(idx.transform(vec), k)
+}
+
+// QueryBatch queries the index with each of the concatenated query vectors in
+// vecs, with a single search if the reduced index supports it.
+//
+// Returns the results of Query for each query vector.
+func (idx *PCAIndex) QueryBatch(vecs []float32, k int64) ([][]string, [][]float32, error) {
+	if len(vecs)%idx.dim != 0 {
+		return nil, nil, fmt.Errorf("query vectors have %d values, not a multiple of dimension %d", len(vecs), idx.dim)
+	}
+	reduced := idx.transform(vecs)
+	if b, ok := idx.reduced.(BatchQuerier); ok {
+		return b.QueryBatch(reduced, k)
+	}
+	outDim := idx.reduced.Dim()
+	nq := len(vecs) / idx.dim
+	datasets := make([][]string, nq)
+	sims := make([][]float32, nq)
+	for q := range datasets {
+		var err error
+		datasets[q], sims[q], err = idx.reduced.Query(reduced[q*outDim:(q+1)*outDim], k)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return datasets, sims, nil
+}
+
+// QueryThreshold returns the IDs of all vectors whose cosine similarity with
+// vec in the reduced space is greater than minSim, if the reduced index
+// supports similarity threshold queries.
+func (idx *PCAIndex) QueryThreshold(vec []float32, minSim float32) ([]string, []float32, error) {
+	q, ok := idx.reduced.(RangeQuerier)
+	if !ok {
+		return nil, nil, fmt.Errorf("pca index: reduced index does not support similarity threshold queries")
+	}
+	if len(vec) != idx.dim {
+		return nil, nil, fmt.Errorf("query vector has dimension %d, want %d", len(vec), idx.dim)
+	}
+	return q.QueryThreshold(idx.transform(vec), minSim)
+}
+
+// Update replaces the vector for a dataset, or adds it if the dataset is not in
+// the index, if the reduced index supports updates. The transform is not
+// retrained.
+func (idx *PCAIndex) Update(datasetID string, vec []float32) error {
+	u, ok := idx.reduced.(Updater)
+	if !ok {
+		return fmt.Errorf("pca index: reduced index does not support updates")
+	}
+	if len(vec) != idx.dim {
+		return fmt.Errorf("vector has dimension %d, want %d", len(vec), idx.dim)
+	}
+	if idx.proj == nil {
+		return idx.Add([]string{datasetID}, vec)
+	}
+	return u.Update(datasetID, idx.transform(vec))
+}
+
+// Save writes the PCA transform followed by the contents of the reduced index
+// to w.
+func (idx *PCAIndex) Save(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	binary.Write(bw, binary.BigEndian, uint32(idx.dim))
+	trained := uint32(0)
+	if idx.proj != nil {
+		trained = 1
+	}
+	binary.Write(bw, binary.BigEndian, trained)
+	bw.Write(vec32.Bytes(idx.mean))
+	bw.Write(vec32.Bytes(idx.proj))
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return idx.reduced.Save(w)
+}
+
+// Load replaces the contents of the index with the contents written by Save.
+func (idx *PCAIndex) Load(r io.Reader) error {
+	// The reduced index reads from the same buffered reader, which the
+	// buffered readers of its Load reuse, so that no input is lost.
+	br := bufio.NewReader(r)
+	var dim, trained uint32
+	if err := binary.Read(br, binary.BigEndian, &dim); err != nil {
+		return err
+	}
+	if int(dim) != idx.dim {
+		return fmt.Errorf("loading index: dimension is %d, want %d", dim, idx.dim)
+	}
+	if err := binary.Read(br, binary.BigEndian, &trained); err != nil {
+		return err
+	}
+	idx.mean, idx.proj = nil, nil
+	if trained != 0 {
+		buf := make([]byte, 4*idx.dim*(1+idx.reduced.Dim()))
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return err
+		}
+		vecs, err := vec32.FromBytes(buf)
+		if err != nil {
+			return err
+		}
+		idx.mean, idx.proj = vecs[:idx.dim], vecs[idx.dim:]
+	}
+	return idx.reduced.Load(br)
+}
+
+// Stats describes the contents of the index. The stats of the reduced index
+// are reported as its only shard.
+func (idx *PCAIndex) Stats() *Stats {
+	s := &Stats{
+		Kind:        "pca",
+		Dim:         idx.dim,
+		MemoryBytes: vectorBytes(len(idx.mean)+len(idx.proj), 1),
+	}
+	if r, ok := idx.reduced.(StatsReporter); ok {
+		rs := r.Stats()
+		s.Vectors = rs.Vectors
+		s.MemoryBytes += rs.MemoryBytes
+		s.MappedBytes += rs.MappedBytes
+		s.Shards = []*Stats{rs}
+	}
+	return s
+}
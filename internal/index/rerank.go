@@ -0,0 +1,225 @@
+package index
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// The rerank backend is registered in init since it refers to New, which
+// refers to backends.
+func init() {
+	backends["rerank"] = func(dim int, arg string) (Index, error) {
+		factor, backend, err := parseRerank(arg)
+		if err != nil {
+			return nil, err
+		}
+		approx, err := New(backend, dim)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := NewRerankIndex(approx, factor)
+		if err != nil {
+			approx.Delete()
+			return nil, err
+		}
+		return idx, nil
+	}
+}
+
+// RerankIndex is an Index that searches in two stages: it retrieves factor
+// times the requested number of candidates from an approximate index, and
+// re-ranks them by their exact cosine similarity with the query vector.
+//
+// This improves the precision of approximate indexes whose similarities or
+// rankings are approximate at the cost of keeping a copy of the vectors and
+// scanning factor*k of them per query, which is much less than exact search.
+type RerankIndex struct {
+	approx Index
+	factor int64
+	dim    int
+	// The indexed (normalized) vectors and the position of the latest vector
+	// of each ID.
+	vecs []float32
+	pos  map[string]int
+	// Maps position of vector to dataset ID, for Save.
+	idMap []string
+}
+
+// NewRerankIndex creates an empty RerankIndex over the (empty) approximate
+// index approx, which it takes ownership of, re-ranking factor candidates per
+// requested result.
+func NewRerankIndex(approx Index, factor int) (*RerankIndex, error) {
+	if factor < 1 {
+		return nil, fmt.Errorf("rerank index: invalid candidate factor %d", factor)
+	}
+	return &RerankIndex{
+		approx: approx,
+		factor: int64(factor),
+		dim:    approx.Dim(),
+		pos:    make(map[string]int),
+	}, nil
+}
+
+// parseRerank parses the "<factor>:<backend>" argument of the rerank backend.
+func parseRerank(arg string) (factor int, backend string, err error) {
+	i := strings.IndexByte(arg, ':')
+	if i < 0 {
+		return 0, "", fmt.Errorf("rerank index: invalid argument %q (want <factor>:<backend>)", arg)
+	}
+	if factor, err = strconv.Atoi(arg[:i]); err != nil {
+		return 0, "", fmt.Errorf("rerank index: invalid candidate factor %q", arg[:i])
+	}
+	return factor, arg[i+1:], nil
+}
+
+// Dim returns the vector dimension.
+func (idx *RerankIndex) Dim() int {
+	return idx.dim
+}
+
+// Build replaces the contents of the index with the given vectors.
+func (idx *RerankIndex) Build(ids []string, vecs []float32) error {
+	if err := idx.approx.Build(ids, vecs); err != nil {
+		return err
+	}
+	idx.vecs = nil
+	idx.pos = make(map[string]int)
+	idx.idMap = nil
+	idx.store(ids, vecs)
+	return nil
+}
+
+// Add adds the given vectors to the index.
+func (idx *RerankIndex) Add(ids []string, vecs []float32) error {
+	if err := idx.approx.Add(ids, vecs); err != nil {
+		return err
+	}
+	idx.store(ids, vecs)
+	return nil
+}
+
+// store keeps a normalized copy of the vectors, which the approximate index
+// has already validated.
+func (idx *RerankIndex) store(ids []string, vecs []float32) {
+	vecs = normalized(vecs, idx.dim)
+	for i, id := range ids {
+		idx.pos[id] = len(idx.idMap)
+		idx.idMap = append(idx.idMap, id)
+		idx.vecs = append(idx.vecs, vecs[i*idx.dim:(i+1)*idx.dim]...)
+	}
+}
+
+// Delete frees the memory associated with the index.
+func (idx *RerankIndex) Delete() {
+	idx.approx.Delete()
+}
+
+// Query queries the index with vec.
+//
+// Returns the dataset IDs of the (up to) k approximate nearest neighbors and
+// their exact cosine similarity, sorted by similarity.
+func (idx *RerankIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
+	cands, _, err := idx.approx.Query(vec, k*idx.factor)
+	if err != nil {
+		return nil, nil, err
+	}
+	datasets, sims := idx.rerank(normalized(vec, idx.dim), cands, k)
+	return datasets, sims, nil
+}
+
+// QueryBatch queries the index with each of the concatenated query vectors in
+// vecs, retrieving the candidates with a single search if the approximate
+// index supports it.
+//
+// Returns the results of Query for each query vector.
+func (idx *RerankIndex) QueryBatch(vecs []float32, k int64) ([][]string, [][]float32, error) {
+	nq := len(vecs) / idx.dim
+	b, ok := idx.approx.(BatchQuerier)
+	if !ok {
+		datasets := make([][]string, nq)
+		sims := make([][]float32, nq)
+		for q := range datasets {
+			var err error
+			datasets[q], sims[q], err = idx.Query(vecs[q*idx.dim:(q+1)*idx.dim], k)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return datasets, sims, nil
+	}
+	cands, _, err := b.QueryBatch(vecs, k*idx.factor)
+	if err != nil {
+		return nil, nil, err
+	}
+	vecs = normalized(vecs, idx.dim)
+	datasets := make([][]string, len(cands))
+	sims := make([][]float32, len(cands))
+	for q := range cands {
+		datasets[q], sims[q] = idx.rerank(vecs[q*idx.dim:(q+1)*idx.dim], cands[q], k)
+	}
+	return datasets, sims, nil
+}
+
+// rerank returns the (up to) k candidates most similar to the normalized query
+// vector vec and their exact similarity, sorted by similarity.
+func (idx *RerankIndex) rerank(vec []float32, cands []string, k int64) ([]string, []float32) {
+	datasets := make([]string, 0, len(cands))
+	sims := make([]float32, 0, len(cands))
+	seen := make(map[string]bool, len(cands))
+
+	for _, id := range cands {
+		i, ok := idx.pos[id]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		datasets = append(datasets, id)
+		sims = append(sims, vec32.Dot(vec, idx.vecs[i*idx.dim:(i+1)*idx.dim]))
+	}
+	sort.Stable(bySimilarity{datasets, sims})
+	if int64(len(datasets)) > k {
+		datasets, sims = datasets[:k], sims[:k]
+	}
+	return datasets, sims
+}
+
+// Save writes the contents of the index to w.
+// The approximate index is rebuilt when the index is loaded.
+func (idx *RerankIndex) Save(w io.Writer) error {
+	return writeVectors(w, idx.dim, idx.idMap, idx.vecs, nil)
+}
+
+// Load replaces the contents of the index with the contents written by Save.
+func (idx *RerankIndex) Load(r io.Reader) error {
+	dim, ids, vecs, err := readVectors(r)
+	if err != nil {
+		return err
+	}
+	if dim != idx.dim {
+		return fmt.Errorf("loading index: dimension is %d, want %d", dim, idx.dim)
+	}
+	return idx.Build(ids, vecs)
+}
+
+// Stats describes the contents of the index. The stats of the approximate
+// index are reported as its only shard.
+func (idx *RerankIndex) Stats() *Stats {
+	s := &Stats{
+		Kind:        "rerank",
+		Vectors:     len(idx.pos),
+		Dim:         idx.dim,
+		MemoryBytes: vectorBytes(len(idx.idMap), idx.dim) + stringsBytes(idx.idMap),
+	}
+	if r, ok := idx.approx.(StatsReporter); ok {
+		as := r.Stats()
+		s.MemoryBytes += as.MemoryBytes
+		s.MappedBytes += as.MappedBytes
+		s.Shards = []*Stats{as}
+	}
+	return s
+}
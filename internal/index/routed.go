@@ -0,0 +1,221 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// RoutedIndex is a metadata index over vectors created by several embedding
+// models (see embedding.Router), with one index per model, since the vectors of
+// different models are not comparable and may have different dimensions.
+//
+// Query vectors are the concatenated embeddings of the query by every model,
+// in the sorted order of the model names (see embedding.Router.EmbedRouted).
+type RoutedIndex struct {
+	names   []string
+	dims    []int
+	indexes []Index
+	// Maps dataset IDs to the position of the index of their model.
+	models map[string]int
+}
+
+// BuildRoutedMetadataIndex builds a RoutedIndex over the metadata embedding
+// vectors of the models with the given dimensions by name, using the given
+// backend (see New) for the index of each model. The model of each vector is
+// read from the vector_models table; vectors without a model are those of the
+// default model "".
+//
+// It returns an error if the table records a model that is not in dims or a
+// dimension other than that of the model.
+func BuildRoutedMetadataIndex(db *database.DB, backend string, dims map[string]int) (*RoutedIndex, error) {
+	recorded, err := db.VectorModelDims()
+	if err != nil {
+		return nil, err
+	}
+	for name, ds := range recorded {
+		dim, ok := dims[name]
+		if !ok {
+			return nil, fmt.Errorf("metadata vectors of model %q, which is not configured", name)
+		}
+		for _, d := range ds {
+			if d != dim {
+				return nil, fmt.Errorf("metadata vectors of model %q have dimension %d, but the model produces dimension %d", name, d, dim)
+			}
+		}
+	}
+
+	idx := &RoutedIndex{models: make(map[string]int)}
+	for name := range dims {
+		idx.names = append(idx.names, name)
+	}
+	sort.Strings(idx.names)
+	for i, name := range idx.names {
+		dim := dims[name]
+		sub, err := New(backend, dim)
+		if err != nil {
+			idx.Delete()
+			return nil, err
+		}
+		idx.dims = append(idx.dims, dim)
+		idx.indexes = append(idx.indexes, sub)
+
+		ids, vecs, err := loadVectors(db, "metadata_vectors", dim, `
+		SELECT v.dataset_id, v.emb FROM metadata_vectors v
+		LEFT JOIN vector_models m ON m.dataset_id = v.dataset_id
+		WHERE COALESCE(m.model, '') = ?`, name)
+		if err != nil {
+			idx.Delete()
+			return nil, err
+		}
+		if err := sub.Build(ids, vecs); err != nil {
+			idx.Delete()
+			return nil, err
+		}
+		for _, id := range ids {
+			idx.models[id] = i
+		}
+	}
+	return idx, nil
+}
+
+// Dim returns the dimension of the concatenated query vectors.
+func (idx *RoutedIndex) Dim() int {
+	dim := 0
+	for _, d := range idx.dims {
+		dim += d
+	}
+	return dim
+}
+
+// Index returns the index of the vectors of the named model, or nil.
+func (idx *RoutedIndex) Index(name string) Index {
+	for i, n := range idx.names {
+		if n == name {
+			return idx.indexes[i]
+		}
+	}
+	return nil
+}
+
+// Query queries the index of each model with its part of the concatenated
+// query vector vec, skipping models whose part is a zero vector (which means
+// the model has no embedding for the query).
+//
+// Returns the dataset IDs of the (up to) k nearest neighbors among all models
+// and the corresponding cosine similarity, sorted by similarity.
+func (idx *RoutedIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
+	if len(vec) != idx.Dim() {
+		return nil, nil, fmt.Errorf("query vector has dimension %d, want %d", len(vec), idx.Dim())
+	}
+	var datasets []string
+	var sims []float32
+	start := 0
+	for i, sub := range idx.indexes {
+		part := vec[start : start+idx.dims[i]]
+		start += idx.dims[i]
+		if vec32.Norm(part) == 0 {
+			continue
+		}
+		ids, s, err := sub.Query(part, k)
+		if err != nil {
+			return nil, nil, err
+		}
+		datasets = append(datasets, ids...)
+		sims = append(sims, s...)
+	}
+	sort.Stable(bySimilarity{datasets, sims})
+	if int64(len(datasets)) > k {
+		datasets, sims = datasets[:k], sims[:k]
+	}
+	return datasets, sims, nil
+}
+
+// Update replaces the vector for a dataset, or adds it if the dataset is not in
+// the index. vec is a concatenated vector whose only part that is not a zero
+// vector is that of the model of the dataset (see
+// embedding.Router.RoutedVector); the dataset is removed from the index of its
+// previous model if that is another one. A zero vector removes the dataset.
+func (idx *RoutedIndex) Update(datasetID string, vec []float32) error {
+	if len(vec) != idx.Dim() {
+		return fmt.Errorf("vector has dimension %d, want %d", len(vec), idx.Dim())
+	}
+	model := -1
+	var part []float32
+	start := 0
+	for i, dim := range idx.dims {
+		if p := vec[start : start+dim]; vec32.Norm(p) != 0 {
+			model, part = i, p
+			break
+		}
+		start += dim
+	}
+	if model < 0 {
+		return idx.Remove(datasetID)
+	}
+	if prev, ok := idx.models[datasetID]; ok && prev != model {
+		if err := idx.remove(prev, datasetID); err != nil {
+			return err
+		}
+	}
+	u, ok := idx.indexes[model].(Updater)
+	if !ok {
+		return fmt.Errorf("index of model %q does not support updates", idx.names[model])
+	}
+	if err := u.Update(datasetID, part); err != nil {
+		return err
+	}
+	idx.models[datasetID] = model
+	return nil
+}
+
+// Remove removes the vector for a dataset, if it is in the index.
+func (idx *RoutedIndex) Remove(datasetID string) error {
+	model, ok := idx.models[datasetID]
+	if !ok {
+		return nil
+	}
+	return idx.remove(model, datasetID)
+}
+
+// remove removes the vector for a dataset from the index of the model at the
+// position.
+func (idx *RoutedIndex) remove(model int, datasetID string) error {
+	ok, err := remove(idx.indexes[model], datasetID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("index of model %q does not support removals", idx.names[model])
+	}
+	delete(idx.models, datasetID)
+	return nil
+}
+
+// Delete frees the memory associated with the index.
+func (idx *RoutedIndex) Delete() {
+	for _, sub := range idx.indexes {
+		sub.Delete()
+	}
+}
+
+// Stats describes the contents of the index. The stats of the index of each
+// model are reported as its shards, named by the model.
+func (idx *RoutedIndex) Stats() *Stats {
+	s := &Stats{Kind: "routed", Dim: idx.Dim()}
+	for i, sub := range idx.indexes {
+		r, ok := sub.(StatsReporter)
+		if !ok {
+			continue
+		}
+		ss := r.Stats()
+		ss.Name = idx.names[i]
+		s.Vectors += ss.Vectors
+		s.MemoryBytes += ss.MemoryBytes
+		s.MappedBytes += ss.MappedBytes
+		s.Shards = append(s.Shards, ss)
+	}
+	return s
+}
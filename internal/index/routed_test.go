@@ -0,0 +1,66 @@
+package index
+
+import (
+	"testing"
+)
+
+func TestRoutedIndexUpdate(t *testing.T) {
+	idx := &RoutedIndex{names: []string{"", "health"}, dims: []int{2, 3}, models: make(map[string]int)}
+	for _, dim := range idx.dims {
+		sub, err := New("flat", dim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx.indexes = append(idx.indexes, sub)
+	}
+	defer idx.Delete()
+
+	// The vectors of the default model, and of the health model.
+	def := func(x, y float32) []float32 { return []float32{x, y, 0, 0, 0} }
+	health := func(x, y, z float32) []float32 { return []float32{0, 0, x, y, z} }
+	for id, vec := range map[string][]float32{
+		"a": def(1, 0),
+		"b": def(0, 1),
+		"c": health(1, 0, 0),
+	} {
+		if err := idx.Update(id, vec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	query := func(vec []float32) []string {
+		t.Helper()
+		ids, _, err := idx.Query(vec, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+	if ids := query(health(1, 0, 0)); len(ids) != 1 || ids[0] != "c" {
+		t.Errorf("got %q from the index of the health model, want [c]", ids)
+	}
+
+	// A dataset whose categories changed moves to the index of its new
+	// model.
+	if err := idx.Update("a", health(0, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := query(def(1, 0)); len(ids) != 1 || ids[0] != "b" {
+		t.Errorf("got %q from the index of the default model, want [b]", ids)
+	}
+	if ids := query(health(0, 1, 0)); len(ids) != 2 || ids[0] != "a" {
+		t.Errorf("got %q from the index of the health model, want [a c]", ids)
+	}
+
+	if err := idx.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Update("b", make([]float32, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := query([]float32{1, 1, 1, 1, 1}); len(ids) != 1 || ids[0] != "a" {
+		t.Errorf("got %q after removing b and c, want [a]", ids)
+	}
+	if err := idx.Update("a", def(1, 0)[:4]); err == nil {
+		t.Error("vector of the wrong dimension updated")
+	}
+}
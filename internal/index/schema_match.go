@@ -0,0 +1,84 @@
+package index
+
+import (
+	"database/sql"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/schemamatch"
+)
+
+// SchemaMatcher aligns the attributes of two datasets (see schemamatch) by the
+// attribute vectors, column sketches, inferred types, and numeric sketches in
+// the database, using whichever of them exist.
+type SchemaMatcher struct {
+	db  *database.DB
+	cfg *schemamatch.Config
+}
+
+// NewSchemaMatcher returns a SchemaMatcher over the datasets in db. If cfg is
+// nil, schemamatch.DefaultConfig is used.
+func NewSchemaMatcher(db *database.DB, cfg *schemamatch.Config) *SchemaMatcher {
+	if cfg == nil {
+		cfg = schemamatch.DefaultConfig()
+	}
+	return &SchemaMatcher{db, cfg}
+}
+
+// Match returns the alignment of the attributes of the datasets with the
+// given IDs. Returns sql.ErrNoRows if either dataset has no sketched columns.
+func (m *SchemaMatcher) Match(leftID, rightID string) (*schemamatch.Mapping, error) {
+	left, err := m.attributes(leftID)
+	if err != nil {
+		return nil, err
+	}
+	right, err := m.attributes(rightID)
+	if err != nil {
+		return nil, err
+	}
+	return m.cfg.Match(left, right), nil
+}
+
+// attributes returns the columns of a dataset with the evidence they are
+// matched by.
+func (m *SchemaMatcher) attributes(datasetID string) ([]*schemamatch.Attribute, error) {
+	cols, err := m.db.DatasetColumns(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	types, err := m.db.DatasetColumnTypes(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	numeric, err := m.db.DatasetNumericSketches(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	vecs := make(map[string][]float32)
+	if ok, err := database.HasTable(m.db, "attribute_vectors"); err != nil {
+		return nil, err
+	} else if ok {
+		names, vs, err := m.db.AttributeVectors(datasetID)
+		if err != nil {
+			return nil, err
+		}
+		for i, name := range names {
+			vecs[name] = vs[i]
+		}
+	}
+
+	attrs := make([]*schemamatch.Attribute, len(cols))
+	for i, c := range cols {
+		attrs[i] = &schemamatch.Attribute{
+			Name:          c.ColumnName,
+			Vector:        vecs[c.ColumnName],
+			Type:          types[c.ColumnID],
+			Minhash:       c.Minhash,
+			DistinctCount: c.DistinctCount,
+			Numeric:       numeric[c.ColumnID],
+		}
+	}
+	return attrs, nil
+}
@@ -0,0 +1,180 @@
+package index
+
+import (
+	"database/sql"
+	"sort"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/attributeembedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/lazo"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// SemanticJoinConfig configures a SemanticJoinSearcher.
+type SemanticJoinConfig struct {
+	// Weight of the name similarity of a candidate in its score; its
+	// containment has weight 1 - NameWeight.
+	NameWeight float64
+	// Minimum name similarity of the candidates found by name alone.
+	MinNameSimilarity float32
+	// Number of attributes fetched from the attribute index.
+	NameCandidates int64
+	// Tokenizer of the column names. If nil, the default abbreviations are
+	// expanded.
+	AttributeTokenizer *attributeembedding.Tokenizer
+}
+
+// DefaultSemanticJoinConfig returns a SemanticJoinConfig weighting the value
+// overlap and the name similarity equally.
+func DefaultSemanticJoinConfig() *SemanticJoinConfig {
+	return &SemanticJoinConfig{
+		NameWeight:        0.5,
+		MinNameSimilarity: 0.7,
+		NameCandidates:    100,
+	}
+}
+
+// SemanticJoinCandidate is a column that a query column may be joined with,
+// found by the overlap of their values or the similarity of their names.
+type SemanticJoinCandidate struct {
+	*JoinCandidate
+	// Cosine similarity of the attribute embeddings of the column names.
+	NameSimilarity float32
+	// Weighted sum of the containment and the name similarity.
+	Score float64
+}
+
+// SemanticJoinSearcher finds joinable columns with both the joinability index
+// and the attribute embedding index, so that columns holding the same key are
+// found even when their values are formatted differently, e.g. a "boro" column
+// of borough codes for a "borough" column of borough names.
+type SemanticJoinSearcher struct {
+	joinability *JoinabilityIndex
+	attributes  *AttributeIndex
+	embedder    embedding.Embedder
+	cfg         *SemanticJoinConfig
+}
+
+// NewSemanticJoinSearcher returns a SemanticJoinSearcher over the given
+// indexes, which embeds column names with e. The attribute index must be built
+// over attribute vectors created with e. If cfg is nil,
+// DefaultSemanticJoinConfig is used.
+func NewSemanticJoinSearcher(joinability *JoinabilityIndex, attributes *AttributeIndex, e embedding.Embedder, cfg *SemanticJoinConfig) *SemanticJoinSearcher {
+	if cfg == nil {
+		cfg = DefaultSemanticJoinConfig()
+	}
+	return &SemanticJoinSearcher{joinability, attributes, e, cfg}
+}
+
+// Search returns the columns other than query that contain at least the
+// fraction threshold of its values (see JoinabilityIndex.Joinable), or whose
+// names have a similarity of at least cfg.MinNameSimilarity with its name and
+// whose types are compatible with its type, sorted by score.
+//
+// The overlap of the columns found by name is estimated as for the columns
+// found by value, and the name similarity of those found by value computed, so
+// that every candidate is scored on both. If the query column name has no
+// letters or digits, only the columns found by value are returned.
+func (s *SemanticJoinSearcher) Search(query *database.ColumnSketch, threshold float64) ([]*SemanticJoinCandidate, error) {
+	byValue, err := s.joinability.Joinable(query, threshold)
+	if err != nil {
+		return nil, err
+	}
+	nameVec, err := s.nameVector(query.ColumnName)
+	if err != nil && err != attributeembedding.ErrNoEmb {
+		return nil, err
+	}
+	noName := err == attributeembedding.ErrNoEmb
+
+	var candidates []*SemanticJoinCandidate
+	byID := make(map[string]*SemanticJoinCandidate)
+	// Name similarities by column name, as many columns share names.
+	nameSims := make(map[string]float32)
+
+	for _, c := range byValue {
+		sc := &SemanticJoinCandidate{JoinCandidate: c}
+		if !noName {
+			sim, ok := nameSims[c.ColumnName]
+			if !ok {
+				if sim, err = s.nameSimilarity(nameVec, c.ColumnName); err != nil {
+					return nil, err
+				}
+				nameSims[c.ColumnName] = sim
+			}
+			sc.NameSimilarity = sim
+		}
+		byID[c.ColumnID] = sc
+		candidates = append(candidates, sc)
+	}
+
+	if !noName {
+		attrs, err := s.attributes.QueryAttributes(nameVec, s.cfg.NameCandidates)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range attrs {
+			if a.Similarity < s.cfg.MinNameSimilarity {
+				break
+			}
+			c, err := s.joinability.db.ColumnSketchByName(a.DatasetID, a.AttributeName)
+			if err == sql.ErrNoRows {
+				// The column was not sketched, e.g. it has no values.
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if c.ColumnID == query.ColumnID || !s.joinability.Compatible(query.ColumnID, c.ColumnID) {
+				continue
+			}
+			if sc := byID[c.ColumnID]; sc != nil {
+				sc.NameSimilarity = a.Similarity
+				continue
+			}
+			overlap := lazo.Overlap(query.Minhash, c.Minhash, query.DistinctCount, c.DistinctCount)
+			sc := &SemanticJoinCandidate{s.joinability.candidate(c, overlap), a.Similarity, 0}
+			byID[c.ColumnID] = sc
+			candidates = append(candidates, sc)
+		}
+	}
+
+	w := s.cfg.NameWeight
+	for _, c := range candidates {
+		nameSim := float64(c.NameSimilarity)
+		if nameSim < 0 {
+			nameSim = 0
+		}
+		c.Score = (1-w)*c.Containment + w*nameSim
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates, nil
+}
+
+// nameVector returns the attribute embedding vector of a column name.
+func (s *SemanticJoinSearcher) nameVector(name string) ([]float32, error) {
+	if s.cfg.AttributeTokenizer != nil {
+		return s.cfg.AttributeTokenizer.Vector(s.embedder, name)
+	}
+	return attributeembedding.Vector(s.embedder, name)
+}
+
+// nameSimilarity returns the cosine similarity of the attribute embedding
+// vector vec with that of the column name, or 0 if the name has no letters or
+// digits.
+func (s *SemanticJoinSearcher) nameSimilarity(vec []float32, name string) (float32, error) {
+	other, err := s.nameVector(name)
+	if err == attributeembedding.ErrNoEmb {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := vec32.Norm(vec) * vec32.Norm(other)
+	if n == 0 {
+		return 0, nil
+	}
+	return vec32.Dot(vec, other) / n, nil
+}
@@ -0,0 +1,595 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/go-faiss"
+)
+
+// ShardedIndex is an index over embedding vectors that are partitioned across
+// multiple faiss indexes (shards).
+// Queries are run on all shards in parallel and the results are merged.
+//
+// Vectors can be added and removed after the index is built. Removed vectors
+// are tombstoned and filtered out of query results until the shard is
+// compacted.
+type ShardedIndex struct {
+	mu     sync.RWMutex // Guards shards and shardOf
+	shards []*shard
+	// Maps dataset ID to shard number.
+	shardOf map[string]int
+}
+
+// shard is one partition of a ShardedIndex.
+type shard struct {
+	idx *faiss.IndexFlat
+	// Maps ID of vector in index to dataset ID.
+	idMap []string
+	// Maps dataset ID to the ID of its live vector in the index.
+	pos map[string]int64
+	// Set of IDs of tombstoned vectors.
+	dead map[int64]bool
+}
+
+// NewShardedIndex creates an empty ShardedIndex with numShards shards.
+func NewShardedIndex(dim, numShards int) (*ShardedIndex, error) {
+	if numShards < 1 {
+		return nil, fmt.Errorf("invalid number of shards: %d", numShards)
+	}
+	idx := &ShardedIndex{
+		shards:  make([]*shard, numShards),
+		shardOf: make(map[string]int),
+	}
+	for i := range idx.shards {
+		s, err := newShard(dim)
+		if err != nil {
+			idx.Delete()
+			return nil, err
+		}
+		idx.shards[i] = s
+	}
+	return idx, nil
+}
+
+func newShard(dim int) (*shard, error) {
+	idx, err := faiss.NewIndexFlatIP(dim)
+	if err != nil {
+		return nil, err
+	}
+	return &shard{
+		idx:  idx,
+		pos:  make(map[string]int64),
+		dead: make(map[int64]bool),
+	}, nil
+}
+
+func (s *shard) add(ids []string, vecs []float32) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := s.idx.Add(vecs); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if p, ok := s.pos[id]; ok {
+			s.dead[p] = true
+		}
+		s.pos[id] = int64(len(s.idMap))
+		s.idMap = append(s.idMap, id)
+	}
+	return nil
+}
+
+func (s *shard) remove(id string) bool {
+	p, ok := s.pos[id]
+	if !ok {
+		return false
+	}
+	s.dead[p] = true
+	delete(s.pos, id)
+	return true
+}
+
+// tombstoneRatio returns the fraction of vectors in the shard that are
+// tombstoned.
+func (s *shard) tombstoneRatio() float64 {
+	if len(s.idMap) == 0 {
+		return 0
+	}
+	return float64(len(s.dead)) / float64(len(s.idMap))
+}
+
+// shardFor returns the default shard for a dataset ID.
+func shardFor(datasetID string, numShards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(datasetID))
+	return int(h.Sum32() % uint32(numShards))
+}
+
+// BuildShardedMetadataIndex builds a ShardedIndex over the metadata embedding
+// vectors of the given dimension with numShards shards.
+//
+// Shard assignments are read from and saved to the index_shards table so that
+// a dataset stays in the same shard across rebuilds.
+func BuildShardedMetadataIndex(db *database.DB, numShards, dim int) (*ShardedIndex, error) {
+	return buildShardedIndex(db, "metadata", numShards, dim,
+		`SELECT dataset_id, emb FROM metadata_vectors`)
+}
+
+func buildShardedIndex(db *database.DB, name string, numShards, dim int, query string) (*ShardedIndex, error) {
+	if numShards < 1 {
+		return nil, fmt.Errorf("invalid number of shards: %d", numShards)
+	}
+	assigned, err := db.ShardAssignments(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([][]string, numShards)
+	vecs := make([][]float32, numShards)
+	// Datasets without a (valid) shard assignment.
+	newAssignments := make(map[string]int)
+
+	err = decodeRows(rows, name+"_vectors", 1, dim, func(keys [][]string, batch []float32) error {
+		for i, datasetID := range keys[0] {
+			n, ok := assigned[datasetID]
+			if !ok || n >= numShards {
+				n = shardFor(datasetID, numShards)
+				newAssignments[datasetID] = n
+			}
+			ids[n] = append(ids[n], datasetID)
+			vecs[n] = append(vecs[n], batch[i*dim:(i+1)*dim]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.SetShardAssignments(name, newAssignments); err != nil {
+		return nil, err
+	}
+
+	idx, err := NewShardedIndex(dim, numShards)
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range idx.shards {
+		if err := s.add(ids[i], vecs[i]); err != nil {
+			idx.Delete()
+			return nil, err
+		}
+		for _, id := range ids[i] {
+			idx.shardOf[id] = i
+		}
+	}
+	return idx, nil
+}
+
+// Dim returns the vector dimension.
+func (idx *ShardedIndex) Dim() int {
+	return idx.shards[0].idx.D()
+}
+
+// Build replaces the contents of the index with the given vectors.
+// Vectors are assigned to shards by hashing their IDs.
+func (idx *ShardedIndex) Build(ids []string, vecs []float32) error {
+	idx.mu.Lock()
+	for i, s := range idx.shards {
+		ns, err := newShard(s.idx.D())
+		if err != nil {
+			idx.mu.Unlock()
+			return err
+		}
+		s.idx.Delete()
+		idx.shards[i] = ns
+	}
+	idx.shardOf = make(map[string]int)
+	idx.mu.Unlock()
+
+	return idx.Add(ids, vecs)
+}
+
+// Add adds the given vectors to the index, replacing the existing vectors of
+// datasets that are already in the index.
+//
+// New datasets are added to the shard given by hashing the dataset ID.
+func (idx *ShardedIndex) Add(ids []string, vecs []float32) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	dim := idx.shards[0].idx.D()
+	if len(vecs) != len(ids)*dim {
+		return fmt.Errorf("got %d values for %d vectors of dimension %d",
+			len(vecs), len(ids), dim)
+	}
+	vecs = normalized(vecs, dim)
+	shardIDs := make([][]string, len(idx.shards))
+	shardVecs := make([][]float32, len(idx.shards))
+
+	for i, id := range ids {
+		n, ok := idx.shardOf[id]
+		if !ok {
+			n = shardFor(id, len(idx.shards))
+		}
+		shardIDs[n] = append(shardIDs[n], id)
+		shardVecs[n] = append(shardVecs[n], vecs[i*dim:(i+1)*dim]...)
+	}
+	for n, s := range idx.shards {
+		if err := s.add(shardIDs[n], shardVecs[n]); err != nil {
+			return err
+		}
+		for _, id := range shardIDs[n] {
+			idx.shardOf[id] = n
+		}
+	}
+	return nil
+}
+
+// Update replaces the vector for a dataset, or adds it if the dataset is not in
+// the index.
+func (idx *ShardedIndex) Update(datasetID string, vec []float32) error {
+	return idx.Add([]string{datasetID}, vec)
+}
+
+// Remove tombstones the vector for a dataset.
+// It reports whether the dataset was in the index.
+func (idx *ShardedIndex) Remove(datasetID string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	n, ok := idx.shardOf[datasetID]
+	if !ok {
+		return false
+	}
+	delete(idx.shardOf, datasetID)
+	return idx.shards[n].remove(datasetID)
+}
+
+// NumShards returns the number of shards.
+func (idx *ShardedIndex) NumShards() int {
+	return len(idx.shards)
+}
+
+// Delete frees the memory associated with the index.
+func (idx *ShardedIndex) Delete() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, s := range idx.shards {
+		if s != nil {
+			s.idx.Delete()
+		}
+	}
+}
+
+type shardResult struct {
+	ids  []string
+	sims []float32
+	err  error
+}
+
+// Query queries all shards with vec and merges the results.
+//
+// Returns the dataset IDs of the (up to) k nearest neighbors and the
+// corresponding cosine similarity, sorted by similarity.
+func (idx *ShardedIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	vec = normalized(vec, idx.Dim())
+	results := make([]shardResult, len(idx.shards))
+	var wg sync.WaitGroup
+
+	for i, s := range idx.shards {
+		wg.Add(1)
+		go func(i int, s *shard) {
+			defer wg.Done()
+			// Fetch extra neighbors to make up for tombstoned vectors.
+			dist, ids, err := s.idx.Search(vec, k+int64(len(s.dead)))
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			for j, id := range ids {
+				if id == -1 || int64(len(results[i].ids)) == k {
+					break
+				}
+				if s.dead[id] {
+					continue
+				}
+				results[i].ids = append(results[i].ids, s.idMap[id])
+				results[i].sims = append(results[i].sims, dist[j])
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	type hit struct {
+		id  string
+		sim float32
+	}
+	var hits []hit
+	for _, res := range results {
+		if res.err != nil {
+			return nil, nil, res.err
+		}
+		for i, id := range res.ids {
+			hits = append(hits, hit{id, res.sims[i]})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].sim > hits[j].sim })
+	if int64(len(hits)) > k {
+		hits = hits[:k]
+	}
+	datasets := make([]string, len(hits))
+	sims := make([]float32, len(hits))
+	for i, h := range hits {
+		datasets[i], sims[i] = h.id, h.sim
+	}
+	return datasets, sims, nil
+}
+
+// QueryBatch queries all shards with each of the concatenated query vectors in
+// vecs, using a single search per shard, and merges the results of each query.
+//
+// Returns the results of Query for each query vector.
+func (idx *ShardedIndex) QueryBatch(vecs []float32, k int64) ([][]string, [][]float32, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	vecs = normalized(vecs, idx.Dim())
+	n := len(vecs) / idx.Dim()
+	results := make([][]shardResult, len(idx.shards))
+	errs := make([]error, len(idx.shards))
+	var wg sync.WaitGroup
+
+	for i, s := range idx.shards {
+		wg.Add(1)
+		go func(i int, s *shard) {
+			defer wg.Done()
+			// Fetch extra neighbors to make up for tombstoned vectors.
+			sk := k + int64(len(s.dead))
+			dist, ids, err := s.idx.Search(vecs, sk)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i] = make([]shardResult, n)
+			for q := range results[i] {
+				res := &results[i][q]
+				for j := int64(q) * sk; j < int64(q+1)*sk; j++ {
+					id := ids[j]
+					if id == -1 || int64(len(res.ids)) == k {
+						break
+					}
+					if s.dead[id] {
+						continue
+					}
+					res.ids = append(res.ids, s.idMap[id])
+					res.sims = append(res.sims, dist[j])
+				}
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	datasets := make([][]string, n)
+	sims := make([][]float32, n)
+
+	for q := 0; q < n; q++ {
+		for i := range idx.shards {
+			datasets[q] = append(datasets[q], results[i][q].ids...)
+			sims[q] = append(sims[q], results[i][q].sims...)
+		}
+		sort.Sort(bySimilarity{datasets[q], sims[q]})
+		if int64(len(datasets[q])) > k {
+			datasets[q], sims[q] = datasets[q][:k], sims[q][:k]
+		}
+	}
+	return datasets, sims, nil
+}
+
+// QueryThreshold queries all shards for the vectors whose cosine similarity with
+// vec is greater than minSim and merges the results.
+//
+// Returns the dataset IDs and the corresponding cosine similarity, sorted by
+// similarity.
+func (idx *ShardedIndex) QueryThreshold(vec []float32, minSim float32) ([]string, []float32, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	vec = normalized(vec, idx.Dim())
+	results := make([]shardResult, len(idx.shards))
+	var wg sync.WaitGroup
+
+	for i, s := range idx.shards {
+		wg.Add(1)
+		go func(i int, s *shard) {
+			defer wg.Done()
+			res, err := s.idx.RangeSearch(vec, minSim)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			defer res.Delete()
+
+			labels, dist := res.Labels()
+			for j, id := range labels {
+				if s.dead[id] {
+					continue
+				}
+				results[i].ids = append(results[i].ids, s.idMap[id])
+				results[i].sims = append(results[i].sims, dist[j])
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	var datasets []string
+	var sims []float32
+	for _, res := range results {
+		if res.err != nil {
+			return nil, nil, res.err
+		}
+		datasets = append(datasets, res.ids...)
+		sims = append(sims, res.sims...)
+	}
+	sort.Sort(bySimilarity{datasets, sims})
+	return datasets, sims, nil
+}
+
+func shardPath(dir string, i int) string {
+	return filepath.Join(dir, fmt.Sprintf("shard-%03d.idx", i))
+}
+
+// SaveDir writes each shard to its own file in dir.
+// Tombstoned vectors are not saved.
+func (idx *ShardedIndex) SaveDir(dir string) error {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i, s := range idx.shards {
+		f, err := os.Create(shardPath(dir, i))
+		if err != nil {
+			return err
+		}
+		if err := s.save(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadShardedIndex loads a ShardedIndex saved by SaveDir from dir. If dir has
+// no shards, the error satisfies errors.Is(err, os.ErrNotExist).
+func LoadShardedIndex(dir string) (*ShardedIndex, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "shard-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no shards found in %s: %w", dir, os.ErrNotExist)
+	}
+	idx := &ShardedIndex{
+		shards:  make([]*shard, len(paths)),
+		shardOf: make(map[string]int),
+	}
+	for i := range idx.shards {
+		path := shardPath(dir, i)
+		f, err := os.Open(path)
+		if err != nil {
+			idx.Delete()
+			return nil, err
+		}
+		s, err := loadShard(f)
+		f.Close()
+		if err != nil {
+			idx.Delete()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		idx.shards[i] = s
+		for id := range s.pos {
+			idx.shardOf[id] = i
+		}
+	}
+	return idx, nil
+}
+
+// Save writes the number of shards followed by the contents of each shard to
+// w. Tombstoned vectors are not saved.
+func (idx *ShardedIndex) Save(w io.Writer) error {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if err := binary.Write(w, binary.BigEndian, uint32(len(idx.shards))); err != nil {
+		return err
+	}
+	for _, s := range idx.shards {
+		if err := s.save(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load replaces the contents of the index with the contents written by Save.
+func (idx *ShardedIndex) Load(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var n uint32
+	if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+		return err
+	}
+	shards := make([]*shard, n)
+	shardOf := make(map[string]int)
+	for i := range shards {
+		s, err := loadShard(br)
+		if err != nil {
+			for _, s := range shards[:i] {
+				s.idx.Delete()
+			}
+			return err
+		}
+		shards[i] = s
+		for id := range s.pos {
+			shardOf[id] = i
+		}
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, s := range idx.shards {
+		s.idx.Delete()
+	}
+	idx.shards, idx.shardOf = shards, shardOf
+	return nil
+}
+
+// save writes the live vectors in the shard to w.
+func (s *shard) save(w io.Writer) error {
+	return writeVectors(w, s.idx.D(), s.idMap, s.idx.Xb(), func(i int) bool {
+		return s.dead[int64(i)]
+	})
+}
+
+func loadShard(r io.Reader) (*shard, error) {
+	dim, ids, vecs, err := readVectors(r)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newShard(dim)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.add(ids, vecs); err != nil {
+		s.idx.Delete()
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,129 @@
+package index
+
+import (
+	"time"
+	"unsafe"
+)
+
+// Stats describes the contents of an index.
+type Stats struct {
+	// Name of the index, set by Manager.
+	Name string
+	// Kind of index, e.g. "flat" or "sharded".
+	Kind string
+	// Number of live vectors.
+	Vectors int
+	// Vector dimension.
+	Dim int
+	// Estimated memory used by the vectors and ID maps in bytes.
+	MemoryBytes int64
+	// Size of the vectors memory-mapped from disk in bytes, which are not
+	// counted in MemoryBytes.
+	MappedBytes int64
+	// Number of tombstoned vectors awaiting compaction.
+	Tombstones int
+	// Stats of each shard of a sharded index.
+	Shards []*Stats
+	// Time the index was built, set by Manager.
+	BuiltAt time.Time
+}
+
+// StatsReporter is implemented by indexes that can describe their contents.
+type StatsReporter interface {
+	Stats() *Stats
+}
+
+// vectorBytes returns the size in bytes of n vectors of dimension dim.
+func vectorBytes(n, dim int) int64 {
+	return int64(n) * int64(dim) * 4
+}
+
+// stringsBytes returns the estimated memory used by ids in bytes.
+func stringsBytes(ids []string) int64 {
+	n := int64(len(ids)) * int64(unsafe.Sizeof(""))
+	for _, id := range ids {
+		n += int64(len(id))
+	}
+	return n
+}
+
+// Stats describes the contents of the index.
+func (idx *FlatIndex) Stats() *Stats {
+	n := len(idx.idMap)
+	return &Stats{
+		Kind:        "flat",
+		Vectors:     n,
+		Dim:         idx.Dim(),
+		MemoryBytes: vectorBytes(n, idx.Dim()) + stringsBytes(idx.idMap),
+	}
+}
+
+// Stats describes the contents of the index.
+func (idx *IVFIndex) Stats() *Stats {
+	n := len(idx.idMap)
+	// The inverted lists and the copy kept for Save each hold the vectors,
+	// and the quantizer holds the centroids.
+	return &Stats{
+		Kind:        "ivf",
+		Vectors:     n,
+		Dim:         idx.dim,
+		MemoryBytes: 2*vectorBytes(n, idx.dim) + vectorBytes(idx.nlist, idx.dim) + stringsBytes(idx.idMap),
+	}
+}
+
+// Stats describes the contents of the index.
+// The vectors of tombstoned datasets are counted in the memory of their shards.
+func (idx *ShardedIndex) Stats() *Stats {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	st := &Stats{Kind: "sharded", Dim: idx.shards[0].idx.D()}
+	for _, s := range idx.shards {
+		ss := &Stats{
+			Kind:        "flat",
+			Vectors:     len(s.pos),
+			Dim:         st.Dim,
+			MemoryBytes: vectorBytes(len(s.idMap), st.Dim) + stringsBytes(s.idMap),
+			Tombstones:  len(s.dead),
+		}
+		st.Vectors += ss.Vectors
+		st.MemoryBytes += ss.MemoryBytes
+		st.Tombstones += ss.Tombstones
+		st.Shards = append(st.Shards, ss)
+	}
+	return st
+}
+
+// Stats describes the contents of the index.
+func (idx *AttributeIndex) Stats() *Stats {
+	n := len(idx.idMap)
+	st := &Stats{
+		Kind:        "attribute",
+		Vectors:     n,
+		Dim:         idx.idx.D(),
+		MemoryBytes: stringsBytes(idx.idMap) + stringsBytes(idx.attributeNames),
+	}
+	if _, ok := idx.idx.(*mmapVectors); ok {
+		st.MappedBytes = vectorBytes(n, st.Dim)
+	} else {
+		st.MemoryBytes += vectorBytes(n, st.Dim)
+	}
+	return st
+}
+
+// Stats describes the contents of the current index, with its name and build
+// time.
+// If the current index does not implement StatsReporter, only the name and
+// build time are set.
+func (m *Manager) Stats() *Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	st := &Stats{}
+	if r, ok := m.current.(StatsReporter); ok {
+		st = r.Stats()
+	}
+	st.Name = m.name
+	st.BuiltAt = m.builtAt
+	return st
+}
@@ -0,0 +1,166 @@
+package index
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+// TuneResult is the measured quality of an index backend on a sample.
+type TuneResult struct {
+	// Backend specification (see New).
+	Backend string
+	// Mean fraction of the exact k nearest neighbors found per query.
+	Recall float64
+	// Mean query latency.
+	Latency time.Duration
+	// Time to build the index over the sample.
+	BuildTime time.Duration
+}
+
+// Sample is a sample of stored vectors to tune index parameters on.
+type Sample struct {
+	Dim int
+	// IDs and concatenated vectors to index.
+	IDs  []string
+	Vecs []float32
+	// Concatenated query vectors, which are not in the index.
+	Queries []float32
+}
+
+// SampleMetadataVectors returns a random sample of size metadata embedding
+// vectors, of which numQueries are held out as query vectors.
+func SampleMetadataVectors(db *database.DB, dim, size, numQueries int) (*Sample, error) {
+	if numQueries < 1 || size <= numQueries {
+		return nil, fmt.Errorf("tune: invalid sample size %d with %d queries", size, numQueries)
+	}
+	ids, vecs, err := loadVectors(db, "metadata_vectors", dim, `
+	SELECT dataset_id, emb FROM metadata_vectors ORDER BY RANDOM() LIMIT ?`, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) <= numQueries {
+		return nil, fmt.Errorf("tune: only %d vectors for %d queries", len(ids), numQueries)
+	}
+	return &Sample{
+		Dim:     dim,
+		IDs:     ids[numQueries:],
+		Vecs:    vecs[numQueries*dim:],
+		Queries: vecs[:numQueries*dim],
+	}, nil
+}
+
+// IVFCandidates returns ivf backend specifications to sweep for n indexed
+// vectors: nlist of 1, 4, and 16 times sqrt(n), each with nprobe of increasing
+// powers of 2 up to nlist.
+func IVFCandidates(n int) []string {
+	var specs []string
+	base := int(math.Sqrt(float64(n)))
+	if base < 1 {
+		base = 1
+	}
+	for _, nlist := range []int{base, 4 * base, 16 * base} {
+		if nlist > n {
+			break
+		}
+		for nprobe := 1; nprobe <= nlist; nprobe *= 2 {
+			specs = append(specs, fmt.Sprintf("ivf:%d:%d", nlist, nprobe))
+		}
+	}
+	return specs
+}
+
+// Tune builds an index with each candidate backend over the sample and
+// measures its recall of the exact k nearest neighbors of the sample queries
+// and its query latency.
+func Tune(s *Sample, k int64, candidates []string) ([]*TuneResult, error) {
+	exact, err := NewFlatIndex(s.Dim)
+	if err != nil {
+		return nil, err
+	}
+	defer exact.Delete()
+
+	if err := exact.Build(s.IDs, s.Vecs); err != nil {
+		return nil, err
+	}
+	truth, _, err := exact.QueryBatch(s.Queries, k)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*TuneResult
+	for _, backend := range candidates {
+		r, err := tuneBackend(s, k, backend, truth)
+		if err != nil {
+			return nil, fmt.Errorf("tune %s: %v", backend, err)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func tuneBackend(s *Sample, k int64, backend string, truth [][]string) (*TuneResult, error) {
+	idx, err := New(backend, s.Dim)
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Delete()
+
+	start := time.Now()
+	if err := idx.Build(s.IDs, s.Vecs); err != nil {
+		return nil, err
+	}
+	r := &TuneResult{Backend: backend, BuildTime: time.Since(start)}
+
+	var recall float64
+	start = time.Now()
+	for q, want := range truth {
+		got, _, err := idx.Query(s.Queries[q*s.Dim:(q+1)*s.Dim], k)
+		if err != nil {
+			return nil, err
+		}
+		recall += recallOf(got, want)
+	}
+	r.Latency = time.Since(start) / time.Duration(len(truth))
+	r.Recall = recall / float64(len(truth))
+	return r, nil
+}
+
+// recallOf returns the fraction of want that is in got.
+func recallOf(got, want []string) float64 {
+	if len(want) == 0 {
+		return 1
+	}
+	found := make(map[string]bool, len(got))
+	for _, id := range got {
+		found[id] = true
+	}
+	n := 0
+	for _, id := range want {
+		if found[id] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(want))
+}
+
+// Recommend returns the result with the lowest query latency among those with
+// recall of at least minRecall, or the result with the highest recall if none
+// reaches it.
+func Recommend(results []*TuneResult, minRecall float64) *TuneResult {
+	var best, mostRecall *TuneResult
+	for _, r := range results {
+		if r.Recall >= minRecall && (best == nil || r.Latency < best.Latency) {
+			best = r
+		}
+		if mostRecall == nil || r.Recall > mostRecall.Recall {
+			mostRecall = r
+		}
+	}
+	if best == nil {
+		return mostRecall
+	}
+	return best
+}
@@ -0,0 +1,126 @@
+package index
+
+import (
+	"sort"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/unionability"
+)
+
+// Minimum cosine similarity of the attribute names matched by
+// UnionabilitySearcher.
+const unionMinSimilarity = 0.7
+
+// UnionableTable is a table that may be unioned with a query table.
+type UnionableTable struct {
+	DatasetID string
+	*unionability.Alignment
+}
+
+// UnionabilitySearcher finds the tables whose schemas align with that of a
+// query table (see unionability.Align), using the attribute embedding index to
+// find the candidates.
+type UnionabilitySearcher struct {
+	attributes *AttributeIndex
+	db         *database.DB
+}
+
+// NewUnionabilitySearcher returns a UnionabilitySearcher over the attribute
+// embedding index built over the attribute vectors in db.
+func NewUnionabilitySearcher(attributes *AttributeIndex, db *database.DB) *UnionabilitySearcher {
+	return &UnionabilitySearcher{attributes, db}
+}
+
+// Search returns the (up to) k tables other than the dataset with the given ID
+// whose schemas best align with its schema, sorted by alignment score.
+//
+// The candidates are the datasets with an attribute among the nearest
+// attributes of each of the dataset's attributes in the index. Returns no
+// tables if the dataset has no attribute vectors.
+func (s *UnionabilitySearcher) Search(datasetID string, k int) ([]*UnionableTable, error) {
+	query, err := s.tableAttributes(datasetID)
+	if err != nil || len(query) == 0 {
+		return nil, err
+	}
+	var candidates []string
+	added := map[string]bool{datasetID: true}
+
+	for _, a := range query {
+		matches, err := s.attributes.QueryAttributes(a.Vector, int64(k)*attributeOversample)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if m.Similarity < unionMinSimilarity {
+				break
+			}
+			if !added[m.DatasetID] {
+				added[m.DatasetID] = true
+				candidates = append(candidates, m.DatasetID)
+			}
+		}
+	}
+
+	var tables []*UnionableTable
+	for _, id := range candidates {
+		attrs, err := s.tableAttributes(id)
+		if err != nil {
+			return nil, err
+		}
+		a := unionability.Align(query, attrs, unionMinSimilarity)
+		if len(a.Matches) > 0 {
+			tables = append(tables, &UnionableTable{id, a})
+		}
+	}
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tables[i].Score > tables[j].Score
+	})
+	if len(tables) > k {
+		tables = tables[:k]
+	}
+	return tables, nil
+}
+
+// tableAttributes returns the attributes of a dataset with their attribute
+// vectors and the types of their columns.
+func (s *UnionabilitySearcher) tableAttributes(datasetID string) ([]*unionability.Attribute, error) {
+	names, vecs, err := s.db.AttributeVectors(datasetID)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	cols, err := s.db.DatasetColumns(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	colTypes, err := s.db.DatasetColumnTypes(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	// The numeric sketches tell numeric columns apart if the types were not
+	// inferred.
+	var numeric map[string]bool
+	if colTypes == nil {
+		if numeric, err = s.db.NumericColumns(datasetID); err != nil {
+			return nil, err
+		}
+	}
+	types := make(map[string]unionability.Type)
+	for _, c := range cols {
+		t := unionability.TypeText
+		if ct, ok := colTypes[c.ColumnID]; ok && ct == coltype.Unknown {
+			t = unionability.TypeUnknown
+		} else if ct.Numeric() || numeric[c.ColumnID] {
+			t = unionability.TypeNumeric
+		}
+		if _, ok := types[c.ColumnName]; !ok {
+			types[c.ColumnName] = t
+		}
+	}
+
+	attrs := make([]*unionability.Attribute, len(names))
+	for i, name := range names {
+		attrs[i] = &unionability.Attribute{Name: name, Vector: vecs[i], Type: types[name]}
+	}
+	return attrs, nil
+}
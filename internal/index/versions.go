@@ -0,0 +1,74 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+)
+
+// CheckVersions returns an error if the vector_versions table records that
+// any of the vectors of the given table (metadata_vectors, attribute_vectors,
+// or value_vectors) were created by another model, model version, or dimension
+// than the model that embeds the queries of their index, since the vectors of
+// different models are not comparable even if they have the same dimension.
+//
+// versions holds the version of the vectors of each model by name (see
+// embedding.Router.Versions); the model of the metadata and attribute vectors
+// of a dataset is read from the vector_models table, and is the default model
+// "" if none is recorded, as it is for all value vectors. Vectors without a
+// recorded version are not checked. It returns nil if the database has no
+// vector_versions table.
+func CheckVersions(db *database.DB, vectors string, versions map[string]embedding.Version) error {
+	ok, err := database.HasTable(db, "vector_versions")
+	if err != nil || !ok {
+		return err
+	}
+	routed := false
+	if vectors != "value_vectors" {
+		if routed, err = database.HasTable(db, "vector_models"); err != nil {
+			return err
+		}
+	}
+	query := `
+	SELECT '', r.model, r.version, r.dim, COUNT(*) FROM vector_versions r
+	WHERE r.vectors = ?
+	GROUP BY r.model, r.version, r.dim`
+	if routed {
+		query = `
+		SELECT COALESCE(m.model, ''), r.model, r.version, r.dim, COUNT(*) FROM vector_versions r
+		LEFT JOIN vector_models m ON m.dataset_id = r.dataset_id
+		WHERE r.vectors = ?
+		GROUP BY 1, r.model, r.version, r.dim`
+	}
+	rows, err := db.Query(query, vectors)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var stale int
+	var example embedding.Version
+	for rows.Next() {
+		var name string
+		var v embedding.Version
+		var n int
+		if err := rows.Scan(&name, &v.Model, &v.Version, &v.Dim, &n); err != nil {
+			return err
+		}
+		if want, ok := versions[name]; !ok || v != want {
+			if stale == 0 {
+				example = v
+			}
+			stale += n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if stale > 0 {
+		return fmt.Errorf("%d %s were created by another embedding model than the configured one, e.g. %s version %q of dimension %d (run reembed)",
+			stale, vectors, example.Model, example.Version, example.Dim)
+	}
+	return nil
+}
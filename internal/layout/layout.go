@@ -0,0 +1,207 @@
+// Package layout locates the files of the datasets to ingest. By default the
+// datasets are the subdirectories of the datasets directory, named by their
+// IDs, with files of conventional names:
+//
+//	datasets/<id>/metadata.json
+//	datasets/<id>/attribute.json
+//	datasets/<id>/rows.csv (or rows.parquet, rows.xlsx, rows.jsonl, rows.json)
+//	datasets/<id>/rows/ (the files of a partitioned dataset, see package partition)
+//
+// A manifest lists the datasets of catalogs stored in other directory
+// structures instead, with the path and format of each of their files. It is
+// a JSON or YAML file (see ReadManifest):
+//
+//	datasets:
+//	  - id: abcd-1234
+//	    files:
+//	      - path: catalog/schools/abcd-1234.meta.json
+//	        format: metadata
+//	      - path: /mnt/exports/2021/schools.parquet
+package layout
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The conventional file names of the files of each format in the datasets
+// directory, which Path looks up.
+var formatNames = map[string]string{
+	"metadata":   "metadata.json",
+	"attributes": "attribute.json",
+	"csv":        "rows.csv",
+	"parquet":    "rows.parquet",
+	"xlsx":       "rows.xlsx",
+	"jsonl":      "rows.jsonl",
+	"json":       "rows.json",
+	"partitions": "rows",
+}
+
+// Manifest is the list of the datasets of a catalog.
+type Manifest struct {
+	Datasets []*Dataset `json:"datasets"`
+}
+
+// Dataset is a dataset listed in a manifest.
+type Dataset struct {
+	ID    string  `json:"id"`
+	Files []*File `json:"files"`
+}
+
+// File is a file of a dataset listed in a manifest.
+type File struct {
+	// Path of the file, relative to the directory of the manifest unless it
+	// is absolute. Files may be gzip or zip compressed, as in the datasets
+	// directory.
+	Path string `json:"path"`
+	// Format of the file: metadata, attributes, csv, parquet, xlsx, jsonl,
+	// json, or partitions, for the directory of the files of a partitioned
+	// dataset. If it is empty, it is inferred from the name of the file:
+	// metadata.json and attribute.json files are metadata and attributes,
+	// paths ending in a slash are partitions, and other files have the
+	// format of their extension.
+	Format string `json:"format"`
+}
+
+// format returns the format of f.
+func (f *File) format() string {
+	if f.Format != "" {
+		return f.Format
+	}
+	if strings.HasSuffix(f.Path, "/") {
+		return "partitions"
+	}
+	name := filepath.Base(f.Path)
+	for _, suffix := range []string{".gz", ".zip"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	switch name {
+	case "metadata.json":
+		return "metadata"
+	case "attribute.json":
+		return "attributes"
+	}
+	return strings.TrimPrefix(filepath.Ext(name), ".")
+}
+
+// Layout locates the files of the datasets, in the datasets directory or as
+// listed in a manifest.
+type Layout struct {
+	dir string
+	// The IDs of the datasets of the manifest, in its order, and the paths of
+	// their files by ID and conventional name, if the layout is a manifest.
+	ids   []string
+	files map[string]map[string]string
+}
+
+// Dir returns the layout of the datasets directory dir.
+func Dir(dir string) *Layout {
+	return &Layout{dir: dir}
+}
+
+// ReadManifest reads the layout listed in the manifest at path. Manifests
+// whose names end in .yaml or .yml are YAML files, of which a subset is
+// supported: block mappings and sequences of plain or quoted strings, with
+// comments. Other manifests are JSON files. Unknown fields, datasets listed
+// twice, and dataset files of unknown formats or with the format of another
+// file of the dataset are errors.
+func ReadManifest(path string) (*Layout, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		v, err := parseYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %v: %v", path, err)
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var m Manifest
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("manifest %v: %v", path, err)
+	}
+	l, err := newManifestLayout(&m, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("manifest %v: %v", path, err)
+	}
+	return l, nil
+}
+
+func newManifestLayout(m *Manifest, dir string) (*Layout, error) {
+	l := &Layout{files: make(map[string]map[string]string)}
+	for i, d := range m.Datasets {
+		if d == nil || strings.TrimSpace(d.ID) == "" {
+			return nil, fmt.Errorf("dataset %d has no id", i)
+		}
+		if _, ok := l.files[d.ID]; ok {
+			return nil, fmt.Errorf("dataset %v is listed twice", d.ID)
+		}
+		files := make(map[string]string)
+		for _, f := range d.Files {
+			if f == nil || f.Path == "" {
+				return nil, fmt.Errorf("dataset %v has a file without a path", d.ID)
+			}
+			format := f.format()
+			name, ok := formatNames[format]
+			if !ok {
+				return nil, fmt.Errorf("dataset %v: file %v has unknown format %q", d.ID, f.Path, format)
+			}
+			if _, ok := files[name]; ok {
+				return nil, fmt.Errorf("dataset %v has several %v files", d.ID, format)
+			}
+			path := f.Path
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			files[name] = path
+		}
+		l.ids = append(l.ids, d.ID)
+		l.files[d.ID] = files
+	}
+	return l, nil
+}
+
+// IDs returns the IDs of the datasets: those of the manifest, in its order, or
+// the names of the entries of the datasets directory, in sorted order.
+func (l *Layout) IDs() ([]string, error) {
+	if l.files != nil {
+		return l.ids, nil
+	}
+	infos, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(infos))
+	for i, info := range infos {
+		ids[i] = info.Name()
+	}
+	return ids, nil
+}
+
+// Path returns the path of the file of the dataset with the given ID that
+// has the conventional name name, such as "rows.csv": the file in the
+// dataset's directory, which may not exist, or the file of the name's format
+// listed in the manifest. If the manifest lists no such file, it returns an
+// error for which errors.Is(err, os.ErrNotExist) is true.
+func (l *Layout) Path(datasetID, name string) (string, error) {
+	if l.files == nil {
+		return filepath.Join(l.dir, datasetID, name), nil
+	}
+	if path, ok := l.files[datasetID][name]; ok {
+		return path, nil
+	}
+	return "", &os.PathError{Op: "open", Path: datasetID + "/" + name, Err: errNotListed}
+}
+
+// errNotListed is the error of the files that are not listed in the manifest.
+var errNotListed = fmt.Errorf("not listed in the manifest: %w", os.ErrNotExist)
@@ -0,0 +1,138 @@
+package layout
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const yamlManifest = `# Schools catalog
+datasets:
+- id: abcd-1234
+  files:
+    - path: schools/meta.json   # the metadata
+      format: metadata
+    - path: "schools/rows 2021.csv.gz"
+    - {path: ignored}
+`
+
+func TestReadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if _, err := ReadManifest(write("flow.yaml", yamlManifest)); err == nil || !strings.Contains(err.Error(), "line 8") {
+		t.Errorf("got %v for a flow mapping, want an error on line 8", err)
+	}
+	manifest := strings.Replace(yamlManifest, "    - {path: ignored}\n", `- id: 'efgh-5678'
+  files:
+  - path: /data/efgh/attribute.json
+`, 1)
+	yamlLayout, err := ReadManifest(write("manifest.yml", manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonLayout, err := ReadManifest(write("manifest.json", `{"datasets": [
+		{"id": "abcd-1234", "files": [
+			{"path": "schools/meta.json", "format": "metadata"},
+			{"path": "schools/rows 2021.csv.gz"}
+		]},
+		{"id": "efgh-5678", "files": [{"path": "/data/efgh/attribute.json"}]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(yamlLayout, jsonLayout) {
+		t.Errorf("got YAML layout %+v, want the JSON layout %+v", yamlLayout, jsonLayout)
+	}
+
+	ids, err := yamlLayout.IDs()
+	if err != nil || !reflect.DeepEqual(ids, []string{"abcd-1234", "efgh-5678"}) {
+		t.Errorf("got IDs %v, %v", ids, err)
+	}
+	for _, tt := range []struct{ id, name, want string }{
+		{"abcd-1234", "metadata.json", filepath.Join(dir, "schools/meta.json")},
+		{"abcd-1234", "rows.csv", filepath.Join(dir, "schools/rows 2021.csv.gz")},
+		{"efgh-5678", "attribute.json", "/data/efgh/attribute.json"},
+	} {
+		if got, err := yamlLayout.Path(tt.id, tt.name); got != tt.want || err != nil {
+			t.Errorf("%v %v: got %q, %v, want %q", tt.id, tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := yamlLayout.Path("abcd-1234", "rows.parquet"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v for an unlisted file, want os.ErrNotExist", err)
+	}
+
+	partitioned, err := ReadManifest(write("partitioned.json", `{"datasets": [{"id": "a", "files": [{"path": "exports/daily/"}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := partitioned.Path("a", "rows"); got != filepath.Join(dir, "exports/daily") || err != nil {
+		t.Errorf("got %q, %v for the partitions of a dataset", got, err)
+	}
+
+	for data, want := range map[string]string{
+		`{"datasets": [{"id": "a"}, {"id": "a"}]}`:                                         "listed twice",
+		`{"datasets": [{"id": "a", "files": [{"path": "a.txt"}]}]}`:                        `unknown format "txt"`,
+		`{"datasets": [{"id": "a", "files": [{"path": "a.csv"}, {"path": "b.csv.zip"}]}]}`: "several csv files",
+		`{"datasets": [{"id": "a", "file": []}]}`:                                          `unknown field "file"`,
+		`{"datasets": [{"files": []}]}`:                                                    "dataset 0 has no id",
+	} {
+		if _, err := ReadManifest(write("invalid.json", data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%v: got %v, want an error with %q", data, err, want)
+		}
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	got, err := parseYAML([]byte(`---
+a: plain text # comment
+'b c': 'it''s'
+d:
+- "x\ty"
+-
+  - nested
+e: ~
+f: []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a":   "plain text",
+		"b c": "it's",
+		"d":   []interface{}{"x\ty", []interface{}{"nested"}},
+		"e":   nil,
+		"f":   []interface{}{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	for data, want := range map[string]string{
+		"a: 1\n  b: 2\n":  "line 2: unexpected indentation",
+		"a: 1\na: 2\n":    `line 2: duplicate key "a"`,
+		"a: 1\n- b\n":     "line 2: sequence item in a mapping",
+		"a: \"open\n":     "line 1: unterminated string",
+		"a:\n\t- b\n":     "line 2: tabs",
+		"a: *anchor\n":    "unsupported YAML",
+		"just a string\n": "line 1: want a key: value pair",
+	} {
+		if _, err := parseYAML([]byte(data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want an error with %q", data, err, want)
+		}
+	}
+}
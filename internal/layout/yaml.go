@@ -0,0 +1,240 @@
+package layout
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a line of a YAML document with content.
+type yamlLine struct {
+	// Line number, from 1, and indentation of the line.
+	number, indent int
+	text           string
+}
+
+// parseYAML parses the subset of YAML of manifests: block mappings and
+// sequences whose scalars are plain, single-quoted, or double-quoted strings,
+// with comments. The empty flow collections [] and {} are supported, and null
+// and ~ are nil. Other scalars, such as numbers, are strings.
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []*yamlLine
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(text, " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed[0] == '#' || text == "---" {
+			continue
+		}
+		if trimmed[0] == '\t' {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", i+1)
+		}
+		lines = append(lines, &yamlLine{i + 1, len(text) - len(trimmed), trimmed})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[p.i].number)
+	}
+	return v, nil
+}
+
+type yamlParser struct {
+	lines []*yamlLine
+	// Index of the next line.
+	i int
+}
+
+// block parses the mapping or sequence whose lines have the given indentation.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isSequenceItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && isSequenceItem(p.lines[p.i].text) {
+		line := p.lines[p.i]
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			p.i++
+			v, err := p.nested(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			continue
+		}
+		if _, _, ok := splitKey(rest); ok || isSequenceItem(rest) {
+			// The item is a block collection starting on the line of
+			// its dash, the rest of whose lines are indented like its
+			// first.
+			p.lines[p.i] = &yamlLine{line.number, line.indent + len(line.text) - len(rest), rest}
+			v, err := p.block(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			continue
+		}
+		v, err := scalar(rest, line.number)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+		p.i++
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent {
+		line := p.lines[p.i]
+		if isSequenceItem(line.text) {
+			return nil, fmt.Errorf("line %d: sequence item in a mapping", line.number)
+		}
+		key, rest, ok := splitKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: want a key: value pair", line.number)
+		}
+		k, err := scalar(key, line.number)
+		if err != nil {
+			return nil, err
+		}
+		ks, _ := k.(string)
+		if _, dup := m[ks]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.number, ks)
+		}
+		p.i++
+		var v interface{}
+		if rest == "" {
+			// The sequence of a key may be indented like the key.
+			if v, err = p.nested(indent, true); err != nil {
+				return nil, err
+			}
+		} else if v, err = scalar(rest, line.number); err != nil {
+			return nil, err
+		}
+		m[ks] = v
+	}
+	return m, nil
+}
+
+// nested parses the value of a key or sequence item whose lines follow it,
+// indented more than indent, or as much if the value is a sequence and
+// sameIndent is set. It returns nil if there is no such value.
+func (p *yamlParser) nested(indent int, sameIndent bool) (interface{}, error) {
+	if p.i == len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.i]
+	if next.indent > indent || sameIndent && next.indent == indent && isSequenceItem(next.text) {
+		return p.block(next.indent)
+	}
+	return nil, nil
+}
+
+// splitKey splits the text of a mapping entry into its key and the rest of the
+// line, reporting whether it is one.
+func splitKey(text string) (key, rest string, ok bool) {
+	start := 0
+	if text[0] == '"' || text[0] == '\'' {
+		// The colon follows the closing quote.
+		end := closingQuote(text)
+		if end < 0 {
+			return "", "", false
+		}
+		start = end + 1
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return text[:i], strings.TrimSpace(stripComment(text[i+1:])), true
+		}
+		if text[i] == '#' && i > 0 && text[i-1] == ' ' {
+			break
+		}
+	}
+	return "", "", false
+}
+
+// stripComment removes the comment at the end of the text of a value.
+func stripComment(text string) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "#") {
+		return ""
+	}
+	if len(text) > 0 && (text[0] == '"' || text[0] == '\'') {
+		return text
+	}
+	if i := strings.Index(text, " #"); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
+
+// scalar parses the scalar text of the given line.
+func scalar(text string, line int) (interface{}, error) {
+	text = stripComment(text)
+	switch {
+	case text == "" || text == "~" || text == "null":
+		return nil, nil
+	case text == "[]":
+		return []interface{}{}, nil
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case text[0] == '"':
+		end := closingQuote(text)
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: unterminated string", line)
+		}
+		if rest := stripComment(text[end+1:]); rest != "" {
+			return nil, fmt.Errorf("line %d: unexpected %q after string", line, rest)
+		}
+		s, err := strconv.Unquote(text[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid string %v", line, text[:end+1])
+		}
+		return s, nil
+	case text[0] == '\'':
+		end := closingQuote(text)
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: unterminated string", line)
+		}
+		if rest := stripComment(text[end+1:]); rest != "" {
+			return nil, fmt.Errorf("line %d: unexpected %q after string", line, rest)
+		}
+		return strings.ReplaceAll(text[1:end], "''", "'"), nil
+	case strings.ContainsAny(text[:1], "[{&*!|>%@`"):
+		return nil, fmt.Errorf("line %d: unsupported YAML %q", line, text)
+	}
+	return text, nil
+}
+
+// closingQuote returns the index of the quote closing the quoted string at the
+// start of text, or -1.
+func closingQuote(text string) int {
+	for i := 1; i < len(text); i++ {
+		switch {
+		case text[0] == '"' && text[i] == '\\':
+			i++
+		case text[i] == text[0] && text[0] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			// A quote escaped by another.
+			i++
+		case text[i] == text[0]:
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,63 @@
+// Package lazo estimates the overlap of the value sets of two columns, their
+// Jaccard similarity and the containment of each in the other, from their
+// MinHash signatures and distinct counts, as in Lazo (Fernandez et al.,
+// "Lazo: A Cardinality-Based Method for Coupled Estimation of Jaccard
+// Similarity and Containment", ICDE 2019).
+//
+// The Jaccard similarity alone cannot tell whether a small key column is
+// contained in a large column, since their similarity is small either way;
+// the cardinalities turn it into an estimate of the number of shared values,
+// and so of the one-sided containments.
+package lazo
+
+import "math"
+
+// Estimate is the estimated overlap of the distinct values of a query column Q
+// and a candidate column X.
+type Estimate struct {
+	// Jaccard similarity |Q ∩ X| / |Q ∪ X|.
+	Jaccard float64
+	// Number of distinct values in both columns, |Q ∩ X|.
+	Intersection float64
+	// Fraction of the values of Q that are values of X, |Q ∩ X| / |Q|.
+	Containment float64
+	// Fraction of the values of X that are values of Q, |Q ∩ X| / |X|.
+	ReverseContainment float64
+}
+
+// Overlap estimates the overlap of the columns with MinHash signatures q and x,
+// created with the same hash functions, and qSize and xSize distinct values.
+//
+// The Jaccard similarity is estimated from the signatures and corrected to be
+// at most min(qSize, xSize) / max(qSize, xSize), the similarity of a column
+// contained in the other: the error of the estimate, about 1/sqrt(len(q)),
+// exceeds the similarity of columns of very different sizes. The intersection
+// follows from |Q ∩ X| = J (|Q| + |X|) / (1 + J), and is at most the smaller
+// distinct count.
+//
+// It returns a zero Estimate if a column has no values or the signatures differ
+// in length.
+func Overlap(q, x []uint64, qSize, xSize int) Estimate {
+	if qSize <= 0 || xSize <= 0 || len(q) == 0 || len(q) != len(x) {
+		return Estimate{}
+	}
+	eq := 0
+	for i, h := range q {
+		if x[i] == h {
+			eq++
+		}
+	}
+	small, large := float64(qSize), float64(xSize)
+	if small > large {
+		small, large = large, small
+	}
+	j := math.Min(float64(eq)/float64(len(q)), small/large)
+
+	inter := math.Min(j*(float64(qSize)+float64(xSize))/(1+j), small)
+	return Estimate{
+		Jaccard:            j,
+		Intersection:       inter,
+		Containment:        inter / float64(qSize),
+		ReverseContainment: inter / float64(xSize),
+	}
+}
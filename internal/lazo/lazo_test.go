@@ -0,0 +1,52 @@
+package lazo
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/ekzhu/lshensemble"
+)
+
+// signature returns the MinHash signature of the numbers from start to
+// start+n-1.
+func signature(start, n int) []uint64 {
+	mh := lshensemble.NewMinhash(42, 256)
+	for i := start; i < start+n; i++ {
+		mh.Push([]byte(strconv.Itoa(i)))
+	}
+	return mh.Signature()
+}
+
+func TestOverlap(t *testing.T) {
+	tests := []struct {
+		name           string
+		qStart, qSize  int
+		xStart, xSize  int
+		containment    float64
+		reverse, delta float64
+	}{
+		{"contained", 0, 1000, 0, 10000, 1, 0.1, 0.15},
+		{"half", 0, 1000, 500, 10000, 0.5, 0.05, 0.15},
+		{"disjoint", 0, 1000, 5000, 1000, 0, 0, 0.05},
+		// The signatures overestimate the similarity, which is bounded by
+		// the distinct counts.
+		{"small key", 0, 100, 0, 100000, 1, 0.001, 0.05},
+	}
+	for _, tt := range tests {
+		e := Overlap(signature(tt.qStart, tt.qSize), signature(tt.xStart, tt.xSize), tt.qSize, tt.xSize)
+		if math.Abs(e.Containment-tt.containment) > tt.delta {
+			t.Errorf("%s: containment %v, want %v", tt.name, e.Containment, tt.containment)
+		}
+		if math.Abs(e.ReverseContainment-tt.reverse) > tt.delta {
+			t.Errorf("%s: reverse containment %v, want %v", tt.name, e.ReverseContainment, tt.reverse)
+		}
+		if e.Intersection > math.Min(float64(tt.qSize), float64(tt.xSize)) {
+			t.Errorf("%s: intersection %v exceeds the smaller column", tt.name, e.Intersection)
+		}
+	}
+
+	if e := Overlap(signature(0, 10), signature(0, 10), 0, 10); e != (Estimate{}) {
+		t.Errorf("got %+v for an empty column", e)
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"strings"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
 	indexpkg "github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
-	"github.com/ekzhu/go-fasttext"
 )
 
-func (O *TableGraph) labelNodes(db *database.DB, ft *fasttext.FastText) error {
-	idx, err := indexpkg.BuildCategoryEmbeddingIndex(db, ft)
+func (O *TableGraph) labelNodes(db *database.DB, e embedding.Embedder) error {
+	idx, err := indexpkg.BuildCategoryEmbeddingIndex(db, e)
 	if err != nil {
 		return err
 	}
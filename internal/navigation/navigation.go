@@ -7,9 +7,9 @@ import (
 	"math"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
 	"github.com/DataIntelligenceCrew/go-faiss"
-	"github.com/ekzhu/go-fasttext"
 	"gonum.org/v1/gonum/blas/blas32"
 	"gonum.org/v1/gonum/graph"
 	"gonum.org/v1/gonum/graph/encoding"
@@ -241,7 +241,7 @@ func (pq *priorityQueue) Pop() interface{} {
 	return item
 }
 
-func BuildOrganization(db *database.DB, ft *fasttext.FastText, cfg *Config, ids []string) (*TableGraph, error) {
+func BuildOrganization(db *database.DB, e embedding.Embedder, cfg *Config, ids []string) (*TableGraph, error) {
 	g, err := BuildInitialOrg(db, cfg, ids)
 	if err != nil {
 		return nil, err
@@ -250,7 +250,7 @@ func BuildOrganization(db *database.DB, ft *fasttext.FastText, cfg *Config, ids
 	if err != nil {
 		return nil, err
 	}
-	if err := g.labelNodes(db, ft); err != nil {
+	if err := g.labelNodes(db, e); err != nil {
 		return nil, err
 	}
 	return g, nil
@@ -0,0 +1,261 @@
+// Package numsketch sketches the distributions of numeric columns, so that
+// columns can be found by the values they hold even if their names carry
+// little meaning (e.g. "amt" or "value").
+package numsketch
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Number of values kept to estimate the quantiles and histogram.
+	reservoirSize = 1024
+	// Minimum fraction of the non-blank values of a numeric column that are
+	// numbers.
+	minNumeric = 0.9
+	// Number of histogram bins.
+	histogramBins = 10
+)
+
+// Probabilities of the quantiles of a Sketch.
+var quantileProbs = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+
+// Sketch summarizes the distribution of the values of a numeric column.
+type Sketch struct {
+	// Number of numeric values.
+	Count int64
+	Min   float64
+	Max   float64
+	Mean  float64
+	// The deciles of the values, from the 10th to the 90th percentile.
+	Quantiles []float64
+	// Fraction of the values in each of 10 equal-width bins between Min and
+	// Max.
+	Histogram []float64
+}
+
+// A Builder builds the Sketch of a column from its values.
+type Builder struct {
+	// Number of non-blank and of numeric values seen.
+	values, numeric int64
+	min, max, sum   float64
+	// Uniform sample of the numeric values (reservoir sampling).
+	reservoir []float64
+	rand      *rand.Rand
+}
+
+// NewBuilder returns a Builder of the sketch of a column without values.
+func NewBuilder() *Builder {
+	return &Builder{min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// Add adds a value of the column. Blank values are ignored; values that are
+// not finite numbers count against the column being numeric.
+func (b *Builder) Add(v string) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return
+	}
+	b.values++
+	x, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsInf(x, 0) || math.IsNaN(x) {
+		return
+	}
+	b.numeric++
+	b.min = math.Min(b.min, x)
+	b.max = math.Max(b.max, x)
+	b.sum += x
+
+	if len(b.reservoir) < reservoirSize {
+		b.reservoir = append(b.reservoir, x)
+		return
+	}
+	if b.rand == nil {
+		// Seeded, so that sketching a column twice gives the same sketch.
+		b.rand = rand.New(rand.NewSource(1))
+	}
+	if i := b.rand.Int63n(b.numeric); i < reservoirSize {
+		b.reservoir[i] = x
+	}
+}
+
+// Sketch returns the sketch of the values added so far, or nil if the column
+// is not numeric: if it has no numbers, or less than 90% of its non-blank
+// values are numbers.
+func (b *Builder) Sketch() *Sketch {
+	if b.numeric == 0 || float64(b.numeric) < minNumeric*float64(b.values) {
+		return nil
+	}
+	sample := append([]float64(nil), b.reservoir...)
+	sort.Float64s(sample)
+
+	s := &Sketch{
+		Count:     b.numeric,
+		Min:       b.min,
+		Max:       b.max,
+		Mean:      b.sum / float64(b.numeric),
+		Quantiles: make([]float64, len(quantileProbs)),
+		Histogram: make([]float64, histogramBins),
+	}
+	for i, p := range quantileProbs {
+		s.Quantiles[i] = quantile(sample, p)
+	}
+	width := (s.Max - s.Min) / histogramBins
+	for _, x := range sample {
+		bin := 0
+		if width > 0 {
+			bin = int((x - s.Min) / width)
+		}
+		if bin >= histogramBins {
+			bin = histogramBins - 1
+		}
+		s.Histogram[bin]++
+	}
+	for i := range s.Histogram {
+		s.Histogram[i] /= float64(len(sample))
+	}
+	return s
+}
+
+// quantile returns the p-quantile of the sorted values, interpolating between
+// the closest values.
+func quantile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	frac := pos - float64(i)
+	return sorted[i] + frac*(sorted[i+1]-sorted[i])
+}
+
+// points returns the minimum, quantiles, and maximum of s, which approximate
+// its quantile function.
+func (s *Sketch) points() []float64 {
+	points := make([]float64, 0, len(s.Quantiles)+2)
+	points = append(points, s.Min)
+	points = append(points, s.Quantiles...)
+	return append(points, s.Max)
+}
+
+// Similarity returns the similarity of the distributions of a and b, between
+// 0 and 1: one minus the mean absolute difference of their quantiles, relative
+// to the range of the values of both, which estimates the earth mover's
+// distance of the distributions. Identical distributions have similarity 1,
+// and distributions of disjoint ranges a similarity close to 0.
+//
+// The similarity depends on the scale of the values, so that e.g. temperatures
+// are not similar to prices of the same shape of distribution.
+func Similarity(a, b *Sketch) float64 {
+	lo, hi := math.Min(a.Min, b.Min), math.Max(a.Max, b.Max)
+	if hi == lo {
+		return 1
+	}
+	pa, pb := a.points(), b.points()
+	if len(pa) != len(pb) {
+		return 0
+	}
+	var d float64
+	for i := range pa {
+		d += math.Abs(pa[i] - pb[i])
+	}
+	return 1 - d/float64(len(pa))/(hi-lo)
+}
+
+// KSSimilarity returns one minus the Kolmogorov-Smirnov statistic of the
+// distributions of a and b, the largest difference of their cumulative
+// distribution functions, between 0 and 1. The functions are interpolated
+// linearly between the minimum, quantiles, and maximum of each sketch.
+//
+// Unlike Similarity, it does not depend on how far apart the values are, only
+// on how much of the distributions overlap: distributions of disjoint ranges
+// have similarity 0 however close they are.
+func KSSimilarity(a, b *Sketch) float64 {
+	pa, pb := a.points(), b.points()
+	if len(pa) != len(pb) {
+		return 0
+	}
+	// The difference of piecewise linear functions is largest at a breakpoint,
+	// on either side of it for the steps of repeated points.
+	var d float64
+	for _, pts := range [][]float64{pa, pb} {
+		for _, x := range pts {
+			d = math.Max(d, math.Abs(cdf(pa, x, false)-cdf(pb, x, false)))
+			d = math.Max(d, math.Abs(cdf(pa, x, true)-cdf(pb, x, true)))
+		}
+	}
+	return 1 - d
+}
+
+// cdf returns the value at x of the cumulative distribution function of the
+// points of a sketch at probabilities 0, quantileProbs, and 1, interpolated
+// linearly, or its left limit at x if left is true.
+func cdf(points []float64, x float64, left bool) float64 {
+	n := len(points)
+	below := func(p float64) bool {
+		if left {
+			return p < x
+		}
+		return p <= x
+	}
+	if !below(points[0]) {
+		return 0
+	}
+	if below(points[n-1]) {
+		return 1
+	}
+	i := n - 2
+	for !below(points[i]) {
+		i--
+	}
+	frac := (x - points[i]) / (points[i+1] - points[i])
+	return prob(i, n) + frac*(prob(i+1, n)-prob(i, n))
+}
+
+// prob returns the probability of the ith of the n points of a sketch.
+func prob(i, n int) float64 {
+	switch i {
+	case 0:
+		return 0
+	case n - 1:
+		return 1
+	}
+	return quantileProbs[i-1]
+}
+
+// Metric is a measure of the similarity of distributions.
+type Metric int
+
+const (
+	// MetricEMD compares distributions with Similarity.
+	MetricEMD Metric = iota
+	// MetricKS compares distributions with KSSimilarity.
+	MetricKS
+)
+
+var metricNames = map[string]Metric{
+	"emd": MetricEMD,
+	"ks":  MetricKS,
+}
+
+// ParseMetric returns the Metric named "emd" or "ks".
+func ParseMetric(name string) (Metric, error) {
+	m, ok := metricNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown metric %q (want emd or ks)", name)
+	}
+	return m, nil
+}
+
+// Compare returns the similarity of the distributions of a and b by the metric.
+func (m Metric) Compare(a, b *Sketch) float64 {
+	if m == MetricKS {
+		return KSSimilarity(a, b)
+	}
+	return Similarity(a, b)
+}
@@ -0,0 +1,76 @@
+package numsketch
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// sketchOf returns the sketch of the numbers from start to start+n-1.
+func sketchOf(start, n int) *Sketch {
+	b := NewBuilder()
+	for i := start; i < start+n; i++ {
+		b.Add(strconv.Itoa(i))
+	}
+	return b.Sketch()
+}
+
+func TestSketch(t *testing.T) {
+	s := sketchOf(1, 5000)
+	if s.Count != 5000 || s.Min != 1 || s.Max != 5000 || s.Mean != 2500.5 {
+		t.Errorf("got count %d, min %v, max %v, mean %v", s.Count, s.Min, s.Max, s.Mean)
+	}
+	// The quantiles are estimated from a sample.
+	if median := s.Quantiles[4]; math.Abs(median-2500) > 250 {
+		t.Errorf("median %v, want about 2500", median)
+	}
+	var total float64
+	for _, f := range s.Histogram {
+		total += f
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("histogram fractions sum to %v", total)
+	}
+
+	b := NewBuilder()
+	for _, v := range []string{"1", "2", "Queens", "Brooklyn", ""} {
+		b.Add(v)
+	}
+	if s := b.Sketch(); s != nil {
+		t.Errorf("got sketch %+v of a text column", s)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	a := sketchOf(0, 100)
+	if sim := Similarity(a, a); sim != 1 {
+		t.Errorf("similarity with itself is %v", sim)
+	}
+	near, far := Similarity(a, sketchOf(10, 100)), Similarity(a, sketchOf(1000, 100))
+	if !(near > far && far >= 0 && near < 1) {
+		t.Errorf("similarity of shifted distributions: %v, far: %v", near, far)
+	}
+}
+
+func TestKSSimilarity(t *testing.T) {
+	a := sketchOf(0, 100)
+	if sim := KSSimilarity(a, a); sim != 1 {
+		t.Errorf("similarity with itself is %v", sim)
+	}
+	if sim := KSSimilarity(a, sketchOf(50, 100)); math.Abs(sim-0.5) > 0.05 {
+		t.Errorf("similarity of distributions shifted by half their range is %v, want about 0.5", sim)
+	}
+	near, far := KSSimilarity(a, sketchOf(200, 100)), KSSimilarity(a, sketchOf(1000, 100))
+	if near != 0 || far != 0 {
+		t.Errorf("similarity of disjoint distributions: %v, %v", near, far)
+	}
+
+	// A constant column, whose points are all equal.
+	b := NewBuilder()
+	for i := 0; i < 10; i++ {
+		b.Add("50")
+	}
+	if sim := KSSimilarity(a, b.Sketch()); math.Abs(sim-0.5) > 0.05 {
+		t.Errorf("similarity with the median is %v, want about 0.5", sim)
+	}
+}
@@ -0,0 +1,366 @@
+// Package partition reads partitioned datasets, whose rows are split across
+// the files of a directory, such as daily CSV dumps or the hive-style
+// partitions of a table:
+//
+//	datasets/<id>/rows/2021-01-01.csv
+//	datasets/<id>/rows/2021-01-02.csv.gz
+//	datasets/<id>/rows/year=2021/month=01/part-0000.parquet
+//
+// A partitioned dataset is ingested as one dataset: its columns are the union
+// of the columns of its files, by name, and the partition columns of its
+// hive-style directories, whose values are those of the directories of each
+// file.
+package partition
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+)
+
+// Conventional names of the files of the partitions by extension.
+var formatNames = map[string]string{
+	".csv":     "rows.csv",
+	".parquet": "rows.parquet",
+	".xlsx":    "rows.xlsx",
+	".jsonl":   "rows.jsonl",
+	".json":    "rows.json",
+}
+
+// Part is a file of a partitioned dataset.
+type Part struct {
+	Path string
+	// Conventional name of the format of the file, as in "rows.csv".
+	Name string
+	// Partition columns of the hive-style directories of the file, from the
+	// outermost, with their values.
+	Columns []Column
+}
+
+// Column is a partition column, with its value for a part.
+type Column struct {
+	Name, Value string
+}
+
+// List returns the parts of the partitioned dataset in the directory dir, its
+// files at any depth in the order of their paths. Files and directories whose
+// names start with "." or "_", such as _SUCCESS markers, are skipped. The
+// files may be gzip or zip compressed, except Parquet and Excel files, and
+// must all have the same format.
+func List(dir string) ([]*Part, error) {
+	var parts []*Part
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if path != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		format, ok := formatNames[filepath.Ext(datafile.Uncompressed(name))]
+		if !ok {
+			return fmt.Errorf("partition %v has an unknown format", path)
+		}
+		if format != formatNames[filepath.Ext(name)] && (format == "rows.parquet" || format == "rows.xlsx") {
+			return fmt.Errorf("partition %v is read at random and cannot be compressed", path)
+		}
+		p := &Part{Path: path, Name: format}
+		rel, err := filepath.Rel(dir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		for _, d := range strings.Split(filepath.ToSlash(rel), "/") {
+			if i := strings.Index(d, "="); i > 0 {
+				p.Columns = append(p.Columns, Column{d[:i], d[i+1:]})
+			}
+		}
+		parts = append(parts, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("partitioned dataset %v has no files", dir)
+	}
+	for _, p := range parts[1:] {
+		if p.Name != parts[0].Name {
+			return nil, fmt.Errorf("partitioned dataset %v has files of several formats", dir)
+		}
+	}
+	return parts, nil
+}
+
+// Hash returns the SHA-256 hash in hex of the paths of the parts, relative to
+// the directory dir, and of their contents.
+func Hash(dir string, parts []*Part) (string, error) {
+	h := sha256.New()
+	for _, p := range parts {
+		rel, err := filepath.Rel(dir, p.Path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(rel))
+		f, err := os.Open(p.Path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// partitionColumns returns the names of the partition columns of the parts,
+// in the order in which they first appear, that are not the names of columns
+// of their files.
+func partitionColumns(parts []*Part, fileColumns map[string]bool) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range parts {
+		for _, c := range p.Columns {
+			if !seen[c.Name] && !fileColumns[c.Name] {
+				seen[c.Name] = true
+				names = append(names, c.Name)
+			}
+		}
+	}
+	return names
+}
+
+// value returns the value of the partition column of the part, or "".
+func (p *Part) value(column string) string {
+	for _, c := range p.Columns {
+		if c.Name == column {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// CSV returns a CSV file merging the CSV files of the parts, opened with
+// open: its columns are the columns of the files, the nth column of a name
+// being that of the nth column of the name in each file, and the partition
+// columns, each row having the values of the columns of its file and blank
+// values for the others. The rows of a file must have as many fields as its
+// header. The files are read twice, for their headers and for their rows.
+func CSV(parts []*Part, open func(*Part) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	var columns []string
+	index := make(map[string]int)
+	headers := make([][]string, len(parts))
+	for i, p := range parts {
+		if p.Name != "rows.csv" {
+			return nil, fmt.Errorf("partition %v is not a CSV file", p.Path)
+		}
+		header, err := readHeader(p, open)
+		if err != nil {
+			return nil, err
+		}
+		headers[i] = header
+		for _, key := range columnKeys(header) {
+			if _, ok := index[key]; !ok {
+				index[key] = len(columns)
+				columns = append(columns, key[:strings.IndexByte(key, 0)])
+			}
+		}
+	}
+	fileColumns := make(map[string]bool)
+	for _, name := range columns {
+		fileColumns[name] = true
+	}
+	extra := partitionColumns(parts, fileColumns)
+	if len(columns)+len(extra) == 0 {
+		return nil, fmt.Errorf("partitioned dataset has no columns")
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w := csv.NewWriter(pw)
+		err := w.Write(append(append([]string(nil), columns...), extra...))
+		for i, p := range parts {
+			if err != nil {
+				break
+			}
+			err = copyRows(w, p, open, headers[i], index, len(columns), extra)
+		}
+		if err == nil {
+			w.Flush()
+			err = w.Error()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// columnKeys returns the keys of the columns of a header, their names and
+// their numbers of occurrences before them in the header.
+func columnKeys(header []string) []string {
+	keys := make([]string, len(header))
+	counts := make(map[string]int)
+	for i, name := range header {
+		keys[i] = fmt.Sprintf("%s\x00%d", name, counts[name])
+		counts[name]++
+	}
+	return keys
+}
+
+// readHeader returns the header of the CSV file of the part, or nil if it is
+// empty.
+func readHeader(p *Part, open func(*Part) (io.ReadCloser, error)) ([]string, error) {
+	f, err := open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.LazyQuotes = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", p.Path, err)
+	}
+	return header, nil
+}
+
+// copyRows writes the rows of the CSV file of the part, whose header is
+// header, with their fields in the merged columns of index, and the values of
+// the partition columns extra after the ncolumns columns of the files.
+func copyRows(w *csv.Writer, p *Part, open func(*Part) (io.ReadCloser, error), header []string, index map[string]int, ncolumns int, extra []string) error {
+	if header == nil {
+		return nil
+	}
+	f, err := open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.LazyQuotes = true
+	r.ReuseRecord = true
+	if _, err := r.Read(); err != nil {
+		return fmt.Errorf("%v: %w", p.Path, err)
+	}
+	positions := make([]int, len(header))
+	for i, key := range columnKeys(header) {
+		positions[i] = index[key]
+	}
+	row := make([]string, ncolumns+len(extra))
+	for i, name := range extra {
+		row[ncolumns+i] = p.value(name)
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %w", p.Path, err)
+		}
+		for i := 0; i < ncolumns; i++ {
+			row[i] = ""
+		}
+		for i, v := range record {
+			row[positions[i]] = v
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+}
+
+// Attributes merges the attributes of the files of the parts, profiled from
+// each file, and adds the partition columns: the attributes of a name are
+// merged into one, of the common type of their types (see coltype.Common),
+// whose sample values are taken from each file in turn, so that the sample
+// spans the partitions.
+func Attributes(parts []*Part, profiles [][]*datafile.Attribute) []*datafile.Attribute {
+	var merged []*datafile.Attribute
+	index := make(map[string]int)
+	var samples [][][]string
+	for _, attrs := range profiles {
+		for _, a := range attrs {
+			i, ok := index[a.Name]
+			if !ok {
+				i = len(merged)
+				index[a.Name] = i
+				merged = append(merged, &datafile.Attribute{Name: a.Name, Type: a.Type})
+				samples = append(samples, nil)
+			} else {
+				merged[i].Type = coltype.Common(merged[i].Type, a.Type)
+			}
+			samples[i] = append(samples[i], a.Sample)
+		}
+	}
+	for i, a := range merged {
+		a.Sample = interleave(samples[i])
+	}
+
+	fileColumns := make(map[string]bool)
+	for _, a := range merged {
+		fileColumns[a.Name] = true
+	}
+	for _, name := range partitionColumns(parts, fileColumns) {
+		var inf coltype.Inferrer
+		values := make(map[string]bool)
+		var sample []string
+		for _, p := range parts {
+			v := p.value(name)
+			inf.Add(v)
+			if v != "" && !values[v] {
+				values[v] = true
+				sample = append(sample, v)
+			}
+		}
+		sort.Strings(sample)
+		if len(sample) > datafile.SampleSize {
+			sample = sample[:datafile.SampleSize]
+		}
+		merged = append(merged, &datafile.Attribute{Name: name, Type: inf.Type(), Sample: sample})
+	}
+	return merged
+}
+
+// interleave returns up to datafile.SampleSize distinct values taken from
+// each of the samples in turn.
+func interleave(samples [][]string) []string {
+	var sample []string
+	seen := make(map[string]bool)
+	for i := 0; len(sample) < datafile.SampleSize; i++ {
+		more := false
+		for _, s := range samples {
+			if i >= len(s) {
+				continue
+			}
+			more = true
+			if !seen[s[i]] && len(sample) < datafile.SampleSize {
+				seen[s[i]] = true
+				sample = append(sample, s[i])
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	return sample
+}
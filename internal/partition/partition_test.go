@@ -0,0 +1,110 @@
+package partition
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/datafile"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func open(p *Part) (io.ReadCloser, error) {
+	return datafile.Open(p.Path)
+}
+
+func TestCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"year=2021/month=01/a.csv": "name,zip\nelm,10001\n",
+		"year=2021/month=02/b.csv": "zip,count,zip\n10002,3,10003\n",
+		"year=2022/c.csv":          "",
+		"_SUCCESS":                 "",
+		".hidden/d.txt":            "",
+	})
+
+	parts, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 || parts[0].Name != "rows.csv" ||
+		!reflect.DeepEqual(parts[1].Columns, []Column{{"year", "2021"}, {"month", "02"}}) {
+		t.Fatalf("got parts %+v %+v %+v", parts[0], parts[1], parts[2])
+	}
+	r, err := CSV(parts, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	want := "name,zip,count,zip,year,month\nelm,10001,,,2021,01\n,10002,3,10003,2021,02\n"
+	if string(data) != want || err != nil {
+		t.Errorf("got %q, %v, want %q", data, err, want)
+	}
+
+	writeFiles(t, dir, map[string]string{"year=2022/c.csv": "name\nelm,oak\n"})
+	r, err = CSV(parts, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil || !strings.Contains(err.Error(), "c.csv") {
+		t.Errorf("got %v for a row with too many fields", err)
+	}
+	r.Close()
+
+	hash, err := Hash(dir, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{"year=2022/c.csv": "name\nelm\n"})
+	if again, err := Hash(dir, parts); err != nil || again == hash {
+		t.Errorf("got hash %v, %v after a partition changed, want another than %v", again, err, hash)
+	}
+
+	writeFiles(t, dir, map[string]string{"year=2022/d.jsonl": "{}\n"})
+	if _, err := List(dir); err == nil || !strings.Contains(err.Error(), "several formats") {
+		t.Errorf("got %v for partitions of several formats", err)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	parts := []*Part{
+		{Columns: []Column{{"day", "2021-01-02"}}},
+		{Columns: []Column{{"day", "2021-01-01"}}},
+	}
+	got := Attributes(parts, [][]*datafile.Attribute{
+		{{Name: "count", Type: coltype.Int, Sample: []string{"1", "2", "3"}}},
+		{{Name: "count", Type: coltype.Float, Sample: []string{"2.5", "2"}}, {Name: "name", Type: coltype.String, Sample: []string{"elm"}}},
+	})
+	want := []*datafile.Attribute{
+		{Name: "count", Type: coltype.Float, Sample: []string{"1", "2.5", "2", "3"}},
+		{Name: "name", Type: coltype.String, Sample: []string{"elm"}},
+		{Name: "day", Type: coltype.Date, Sample: []string{"2021-01-01", "2021-01-02"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for _, a := range got {
+			t.Logf("%+v", a)
+		}
+		t.Errorf("got the attributes above, want %+v %+v %+v", want[0], want[1], want[2])
+	}
+}
@@ -0,0 +1,175 @@
+// Package pii detects the dataset columns that may hold personally
+// identifiable information, such as email addresses or social security
+// numbers, from their sample values, so that the server can warn about them or
+// leave their values out of the data previews.
+//
+// Emails, SSN-like numbers, and phone numbers are recognized by their
+// patterns. Person names are capitalized words of which one is a common given
+// name, so that names of places, such as "New York", are not taken for them.
+// The detection is a heuristic: a flagged column may hold no personal
+// information, and names or numbers written otherwise are missed.
+package pii
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Kind is a kind of personally identifiable information.
+type Kind string
+
+const (
+	Email Kind = "email"
+	// Social security numbers, written with separators, e.g. 123-45-6789.
+	SSN   Kind = "ssn"
+	Phone Kind = "phone"
+	// Full names of persons, e.g. "Jane Doe" or "DOE, JANE".
+	PersonName Kind = "person_name"
+)
+
+// Kinds are the kinds of information detected, in the order of Detect.
+var Kinds = []Kind{Email, SSN, Phone, PersonName}
+
+// Parse returns the Kind named s.
+func Parse(s string) (Kind, error) {
+	for _, k := range Kinds {
+		if Kind(s) == k {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown kind of personal information %q (want email, ssn, phone, or person_name)", s)
+}
+
+// Minimum fraction of the non-blank sample values of a column that must be of
+// a kind for the column to be flagged. It is lower than for column domains
+// (see package coldomain), since a column with some personal information is
+// sensitive.
+const minFraction = 0.5
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[A-Za-z]{2,}$`)
+	// Area, group, and serial numbers, of which none is all zeros, and the
+	// areas 666 and 900-999 are never assigned.
+	ssnPattern = regexp.MustCompile(`^(\d{3})[- ](\d{2})[- ](\d{4})$`)
+	// North American phone numbers, and international ones with a country
+	// code, written with separators so that they are not confused with other
+	// numbers.
+	phonePattern = regexp.MustCompile(`^((\+?1[ .-]?)?(\(\d{3}\) ?|\d{3}[ .-])\d{3}[ .-]\d{4}|\+\d{1,3}([ .-]\d{2,4}){2,4})$`)
+	// Two to four capitalized words, e.g. "Jane Q. Doe" or "DOE, JANE".
+	personNamePattern = regexp.MustCompile(`^[A-Z][A-Za-z'.-]*,?( [A-Z][A-Za-z'.-]*){1,3}$`)
+)
+
+// Common given names, in lower case.
+var givenNames = makeSet(strings.Fields(`
+	aaron adam adrian aisha alan albert alex alexander alice alicia allison
+	amanda amber amy ana andrea andrew angela anita ann anna anne anthony
+	antonio arthur ashley barbara benjamin betty beverly billy bonnie brenda
+	brian brittany bruce bryan carl carlos carmen carol carolyn catherine
+	charles charlotte cheryl chris christian christina christine christopher
+	cindy claire cynthia daniel danielle david deborah debra denise dennis
+	diana diane donald donna doris dorothy douglas dylan edward elizabeth
+	emily emma eric erica ethan eugene evelyn frances frank gabriel gary
+	george gerald gloria grace gregory hannah harold heather helen henry
+	isabella jack jacob jacqueline james jane janet janice jason jean jeffrey
+	jennifer jeremy jerry jesse jessica joan joe john johnny jonathan jose
+	joseph joshua joyce juan judith judy julia julie justin karen katherine
+	kathleen kathryn kelly kenneth kevin kimberly kyle larry laura lauren
+	lawrence linda lisa logan lori louis luis madison margaret maria marie
+	marilyn mark martha martin mary matthew megan melissa michael michelle
+	mildred nancy natalie nathan nicholas nicole noah olivia pamela patricia
+	patrick paul peter philip rachel ralph randy raymond rebecca richard
+	robert roger ronald rose roy russell ruth ryan samantha samuel sandra
+	sara sarah scott sean sharon shirley sophia stephanie stephen steven
+	susan teresa terry theresa thomas timothy tyler victoria vincent virginia
+	walter wayne william willie zachary
+`))
+
+func makeSet(words []string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
+
+// Detect returns the kinds of personal information of the sample values of a
+// column, in the order of Kinds, or none if the column is not sensitive.
+// Blank values are ignored.
+func Detect(values []string) []Kind {
+	var n int
+	counts := make(map[Kind]int)
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		n++
+		if emailPattern.MatchString(v) {
+			counts[Email]++
+		}
+		if isSSN(v) {
+			counts[SSN]++
+		}
+		if phonePattern.MatchString(v) {
+			counts[Phone]++
+		}
+		if isPersonName(v) {
+			counts[PersonName]++
+		}
+	}
+	var kinds []Kind
+	for _, k := range Kinds {
+		if n > 0 && float64(counts[k]) >= minFraction*float64(n) {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds
+}
+
+// isSSN reports whether v is written like a social security number.
+func isSSN(v string) bool {
+	m := ssnPattern.FindStringSubmatch(v)
+	if m == nil {
+		return false
+	}
+	area, group, serial := m[1], m[2], m[3]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// isPersonName reports whether v is capitalized words of which one is a common
+// given name.
+func isPersonName(v string) bool {
+	if !personNamePattern.MatchString(v) {
+		return false
+	}
+	for _, w := range strings.Fields(v) {
+		if givenNames[strings.ToLower(strings.Trim(w, ",."))] {
+			return true
+		}
+	}
+	return false
+}
+
+// Format returns kinds separated by commas, as stored in the
+// sensitive_columns table.
+func Format(kinds []Kind) string {
+	s := make([]string, len(kinds))
+	for i, k := range kinds {
+		s[i] = string(k)
+	}
+	return strings.Join(s, ",")
+}
+
+// ParseList parses kinds separated by commas, as formatted by Format.
+func ParseList(s string) ([]Kind, error) {
+	var kinds []Kind
+	for _, name := range strings.Split(s, ",") {
+		k, err := Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		kinds = append(kinds, k)
+	}
+	return kinds, nil
+}
@@ -0,0 +1,38 @@
+package pii
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{[]string{"a@example.org", "b.c@example.com", ""}, "email"},
+		{[]string{"123-45-6789", "321 54 9876", "n/a"}, "ssn"},
+		// Never assigned, or zero, area, group, or serial numbers.
+		{[]string{"000-12-3456", "666-12-3456", "912-34-5678", "123-00-4567", "123-45-0000"}, ""},
+		{[]string{"(212) 555-0100", "212-555-0101", "+44 20 7946 0958"}, "phone"},
+		{[]string{"Jane Doe", "DOE, JOHN", "Maria del Carmen Ruiz"}, "person_name"},
+		{[]string{"Staten Island", "New York", "Main Street"}, ""},
+		// A column of some personal information is flagged.
+		{[]string{"a@example.org", "none", "b@example.org", "unknown"}, "email"},
+		{[]string{"a@example.org", "none", "unknown"}, ""},
+		{[]string{"10001", "1234567890", "2021-01-31"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := Format(Detect(tt.values)); got != tt.want {
+			t.Errorf("Detect(%q) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	kinds, err := ParseList("email,person_name")
+	if err != nil || len(kinds) != 2 || kinds[0] != Email || kinds[1] != PersonName {
+		t.Errorf("got %v, %v", kinds, err)
+	}
+	if _, err := ParseList("email,address"); err == nil {
+		t.Error("got no error for an unknown kind")
+	}
+}
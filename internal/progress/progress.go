@@ -0,0 +1,178 @@
+// Package progress reports the progress of the long-running ingestion
+// commands: the number of items processed out of the total, the processing
+// rate, the estimated time remaining, and the number of errors, periodically
+// in the log and optionally as a stream of JSON lines for monitoring tools.
+package progress
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	interval = flag.Duration("progress", 30*time.Second, "interval between progress reports, or 0 to only report when done")
+	jsonPath = flag.String("progress-json", "", "write the progress reports as JSON lines to `file` (- for standard output)")
+)
+
+// Report is a progress report, written as a JSON line by the Reporter of a
+// command run with -progress-json.
+type Report struct {
+	Time   time.Time `json:"time"`
+	Unit   string    `json:"unit"`
+	Done   int64     `json:"done"`
+	Total  int64     `json:"total"`
+	Errors int64     `json:"errors"`
+	// Items processed per second since the start.
+	Rate float64 `json:"rate"`
+	// Estimated seconds until all items are processed, or nil if nothing
+	// has been processed yet.
+	ETASeconds *float64 `json:"eta_seconds"`
+	// Whether this is the final report.
+	Finished bool `json:"finished"`
+}
+
+func (r *Report) String() string {
+	eta := "unknown"
+	if r.ETASeconds != nil {
+		eta = (time.Duration(*r.ETASeconds) * time.Second).String()
+	}
+	var percent float64
+	if r.Total > 0 {
+		percent = 100 * float64(r.Done) / float64(r.Total)
+	}
+	return fmt.Sprintf("%d/%d %v (%.1f%%), %.1f/s, ETA %v, %d errors",
+		r.Done, r.Total, r.Unit, percent, r.Rate, eta, r.Errors)
+}
+
+// A Reporter reports the progress of processing a number of items. It is
+// safe for concurrent use.
+type Reporter struct {
+	// Accessed atomically.
+	done, errors, total int64
+
+	unit  string
+	start time.Time
+	// Destination of the JSON reports, if any, and its closer.
+	enc    *json.Encoder
+	closer io.Closer
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Start starts reporting the progress of processing total items, called unit
+// (e.g. "datasets") in the reports, every -progress interval and to the
+// -progress-json file. The flags must have been parsed.
+func Start(unit string, total int) (*Reporter, error) {
+	var w io.Writer
+	var closer io.Closer
+	switch *jsonPath {
+	case "":
+	case "-":
+		w = os.Stdout
+	default:
+		f, err := os.Create(*jsonPath)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = f, f
+	}
+	r := newReporter(unit, total, w)
+	r.closer = closer
+	if *interval > 0 {
+		r.wg.Add(1)
+		go r.run(*interval)
+	}
+	return r, nil
+}
+
+func newReporter(unit string, total int, w io.Writer) *Reporter {
+	r := &Reporter{unit: unit, total: int64(total), start: time.Now(), stop: make(chan struct{})}
+	if w != nil {
+		r.enc = json.NewEncoder(w)
+	}
+	return r
+}
+
+func (r *Reporter) run(interval time.Duration) {
+	defer r.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			r.write(r.report(now, false))
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Add adds n items to the total, for items whose number is not known when
+// the reporter is started, such as those of a stream.
+func (r *Reporter) Add(n int) {
+	atomic.AddInt64(&r.total, int64(n))
+}
+
+// Done records that n more items were processed, successfully or not.
+func (r *Reporter) Done(n int) {
+	atomic.AddInt64(&r.done, int64(n))
+}
+
+// Error records an error processing an item, which is counted as processed
+// only by Done.
+func (r *Reporter) Error() {
+	atomic.AddInt64(&r.errors, 1)
+}
+
+// report returns the progress report at the time now.
+func (r *Reporter) report(now time.Time, finished bool) *Report {
+	rep := &Report{
+		Time:     now,
+		Unit:     r.unit,
+		Done:     atomic.LoadInt64(&r.done),
+		Total:    atomic.LoadInt64(&r.total),
+		Errors:   atomic.LoadInt64(&r.errors),
+		Finished: finished,
+	}
+	if elapsed := now.Sub(r.start).Seconds(); elapsed > 0 {
+		rep.Rate = float64(rep.Done) / elapsed
+	}
+	if rep.Rate > 0 {
+		eta := float64(rep.Total-rep.Done) / rep.Rate
+		if eta < 0 {
+			eta = 0
+		}
+		rep.ETASeconds = &eta
+	}
+	return rep
+}
+
+func (r *Reporter) write(rep *Report) {
+	log.Println("progress:", rep)
+	if r.enc != nil {
+		if err := r.enc.Encode(rep); err != nil {
+			log.Println("progress:", err)
+		}
+	}
+}
+
+// Stop stops the periodic reports, and writes and returns the final report.
+func (r *Reporter) Stop() *Report {
+	close(r.stop)
+	r.wg.Wait()
+	rep := r.report(time.Now(), true)
+	r.write(rep)
+	if r.closer != nil {
+		if err := r.closer.Close(); err != nil {
+			log.Println("progress:", err)
+		}
+	}
+	return rep
+}
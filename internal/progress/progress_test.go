@@ -0,0 +1,40 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	var buf bytes.Buffer
+	r := newReporter("datasets", 100, &buf)
+	if rep := r.report(r.start.Add(time.Second), false); rep.ETASeconds != nil {
+		t.Errorf("got ETA %v before any progress", *rep.ETASeconds)
+	}
+
+	r.Done(20)
+	r.Error()
+	rep := r.report(r.start.Add(10*time.Second), false)
+	if rep.Done != 20 || rep.Errors != 1 || rep.Rate != 2 {
+		t.Errorf("got %v, want 20 done, 1 error, and 2/s", rep)
+	}
+	if rep.ETASeconds == nil || *rep.ETASeconds != 40 {
+		t.Errorf("got ETA %v, want 40s", rep.ETASeconds)
+	}
+
+	r.write(rep)
+	var got Report
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Unit != "datasets" || got.Total != 100 || *got.ETASeconds != 40 || got.Finished {
+		t.Errorf("got JSON report %+v", got)
+	}
+
+	r.Add(20)
+	if rep := r.report(r.start.Add(10*time.Second), false); rep.Total != 120 || *rep.ETASeconds != 50 {
+		t.Errorf("got %v after adding 20 items, want 120 in total and an ETA of 50s", rep)
+	}
+}
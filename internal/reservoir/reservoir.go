@@ -0,0 +1,90 @@
+// Package reservoir samples streams of unknown length: a Sampler chooses a
+// uniform random sample of a fixed number of the items of a stream as they are
+// read, with reservoir sampling (Li's Algorithm L, which skips the items that
+// are not sampled without drawing a random number for each of them).
+package reservoir
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+)
+
+// Sampler chooses a uniform random sample of size items of a stream. The
+// caller stores the items in a reservoir of size slots, where Offer places
+// them.
+type Sampler struct {
+	size int
+	rng  *rand.Rand
+	// Number of items offered, index of the next item to be sampled once
+	// the reservoir is full, and the current weight of Algorithm L.
+	n, next int64
+	w       float64
+}
+
+// New returns a sampler of size items whose random choices are determined by
+// seed. It panics if size is not positive.
+func New(size int, seed int64) *Sampler {
+	if size <= 0 {
+		panic("reservoir: size must be positive")
+	}
+	s := &Sampler{size: size, rng: rand.New(rand.NewSource(seed))}
+	s.w = math.Exp(math.Log(s.random()) / float64(size))
+	s.next = int64(size) - 1
+	s.skip()
+	return s
+}
+
+// Seed returns a seed derived from key, so that the items of a stream
+// identified by key, such as the rows of a dataset, are sampled the same way
+// every time.
+func Seed(key string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int64(h.Sum64())
+}
+
+// random returns a random number in (0, 1].
+func (s *Sampler) random() float64 {
+	return 1 - s.rng.Float64()
+}
+
+// skip advances next past the items that are not sampled.
+func (s *Sampler) skip() {
+	if s.w >= 1 {
+		s.next++
+		return
+	}
+	s.next += int64(math.Floor(math.Log(s.random())/math.Log(1-s.w))) + 1
+}
+
+// Offer offers the next item of the stream, and returns the slot of the
+// reservoir in which to store it, replacing the item there if any, or -1 if
+// the item is not sampled. The first size items fill the slots in order.
+func (s *Sampler) Offer() int {
+	i := s.n
+	s.n++
+	if i < int64(s.size) {
+		return int(i)
+	}
+	if i < s.next {
+		return -1
+	}
+	slot := s.rng.Intn(s.size)
+	s.w *= math.Exp(math.Log(s.random()) / float64(s.size))
+	s.skip()
+	return slot
+}
+
+// Len returns the number of items in the reservoir.
+func (s *Sampler) Len() int {
+	if s.n < int64(s.size) {
+		return int(s.n)
+	}
+	return s.size
+}
+
+// Offered returns the number of items offered.
+func (s *Sampler) Offered() int64 {
+	return s.n
+}
@@ -0,0 +1,48 @@
+package reservoir
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sample returns the sample of size items of the stream 0, 1, ..., n-1.
+func sample(s *Sampler, n int) []int {
+	var items []int
+	for i := 0; i < n; i++ {
+		if slot := s.Offer(); slot == len(items) {
+			items = append(items, i)
+		} else if slot >= 0 {
+			items[slot] = i
+		}
+	}
+	return items
+}
+
+func TestSampler(t *testing.T) {
+	if got := sample(New(10, 1), 4); !reflect.DeepEqual(got, []int{0, 1, 2, 3}) {
+		t.Errorf("got %v for a stream shorter than the sample", got)
+	}
+	if a, b := sample(New(10, 1), 1000), sample(New(10, 1), 1000); !reflect.DeepEqual(a, b) {
+		t.Errorf("got samples %v and %v with the same seed", a, b)
+	}
+
+	// Each item of the stream is sampled with the same probability.
+	const size, n, runs = 10, 100, 20000
+	counts := make([]int, n)
+	for run := 0; run < runs; run++ {
+		s := New(size, int64(run))
+		items := sample(s, n)
+		if len(items) != size || s.Len() != size || s.Offered() != n {
+			t.Fatalf("got %d items, Len %d, and Offered %d", len(items), s.Len(), s.Offered())
+		}
+		for _, item := range items {
+			counts[item]++
+		}
+	}
+	// Each count is binomial with mean 2000 and standard deviation 42.
+	for item, count := range counts {
+		if count < 1800 || count > 2200 {
+			t.Errorf("item %d sampled %d times, want about %d", item, count, runs*size/n)
+		}
+	}
+}
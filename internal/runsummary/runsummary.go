@@ -0,0 +1,144 @@
+// Package runsummary summarizes the runs of the ingestion commands: how many
+// items they processed, skipped, and failed, how many texts they embedded and
+// the fraction of them without embedding, their other counts, and how long
+// they ran. The summary of a run is logged as a JSON line when it ends,
+// optionally written to a file, and, if the database has an ingest_runs table
+// (see sql/create_ingest_runs_table.sql), recorded in it, so that the runs of
+// a command can be compared over time.
+package runsummary
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/progress"
+)
+
+var jsonPath = flag.String("summary-json", "", "write the summary of the run as JSON to `file` (- for standard output)")
+
+// Summary is the summary of a run of an ingestion command.
+type Summary struct {
+	Command        string    `json:"command"`
+	StartedAt      time.Time `json:"started_at"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+	DryRun         bool      `json:"dry_run"`
+	// What the command processes, such as datasets or columns, and how many
+	// of them it processed, successfully or not, out of the total.
+	Unit      string `json:"unit"`
+	Processed int64  `json:"processed"`
+	Total     int64  `json:"total"`
+	// Items left alone, e.g. unchanged since the previous run or empty.
+	Skipped int `json:"skipped"`
+	// Datasets that could not be processed (see package failures).
+	Failed int `json:"failed"`
+	// Texts embedded, such as attribute names, and those of them that the
+	// model had no embedding for, whatever the no-embedding policy.
+	Embedded int `json:"embedded"`
+	OOV      int `json:"oov"`
+	// OOV/Embedded, or nil if nothing was embedded.
+	OOVRate *float64 `json:"oov_rate"`
+	// Other counts of the command, such as the vectors stored.
+	Counts map[string]int `json:"counts"`
+
+	mu sync.Mutex
+}
+
+// Start starts the summary of a run of the named command, processing items
+// called unit, such as datasets.
+func Start(command, unit string, dryRun bool) *Summary {
+	return &Summary{
+		Command:   command,
+		StartedAt: time.Now().UTC(),
+		DryRun:    dryRun,
+		Unit:      unit,
+		Counts:    make(map[string]int),
+	}
+}
+
+// Embeddings records that texts more texts were embedded, of which those
+// counted in noEmb had no embedding.
+func (s *Summary) Embeddings(texts int, noEmb *embedding.NoEmbCounts) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Embedded += texts
+	s.OOV += noEmb.Skipped + noEmb.Flagged + noEmb.Fallback
+}
+
+// Count adds n to the count called name.
+func (s *Summary) Count(name string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Counts[name] += n
+}
+
+// Finish completes the summary with the final progress report rep, if not
+// nil, and the failures of fails, and logs it and writes it to the
+// -summary-json file. If db has an ingest_runs table, the summary is also
+// recorded in it, unless the run is a dry run.
+func (s *Summary) Finish(db *sql.DB, rep *progress.Report, fails *failures.Recorder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ElapsedSeconds = time.Since(s.StartedAt).Seconds()
+	if rep != nil {
+		s.Processed, s.Total = rep.Done, rep.Total
+	}
+	s.Failed = fails.Datasets()
+	if s.Embedded > 0 {
+		rate := float64(s.OOV) / float64(s.Embedded)
+		s.OOVRate = &rate
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	log.Printf("run summary: %s", b)
+	if err := writeFile(b); err != nil {
+		return err
+	}
+	if s.DryRun {
+		return nil
+	}
+	if ok, err := database.HasTable(db, "ingest_runs"); err != nil || !ok {
+		return err
+	}
+	counts, err := json.Marshal(s.Counts)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+	INSERT INTO ingest_runs (command, started_at, elapsed_seconds, unit, processed, total, skipped, failed, embedded, oov_rate, counts)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.Command, s.StartedAt.Format("2006-01-02 15:04:05"), s.ElapsedSeconds, s.Unit,
+		s.Processed, s.Total, s.Skipped, s.Failed, s.Embedded, s.OOVRate, string(counts))
+	return err
+}
+
+// writeFile writes the summary b to the -summary-json file, if it is set.
+func writeFile(b []byte) error {
+	b = append(b, '\n')
+	switch *jsonPath {
+	case "":
+		return nil
+	case "-":
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	f, err := os.Create(*jsonPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,67 @@
+package runsummary
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/failures"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/progress"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestFinish(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runsummary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	schema, err := ioutil.ReadFile("../../sql/create_ingest_runs_table.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	fails, err := failures.Start("process_attribute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fails.Fail("aaaa-0001", errors.New("unreadable"))
+
+	for _, dryRun := range []bool{true, false} {
+		s := Start("process_attribute", "datasets", dryRun)
+		s.Skipped = 2
+		s.Embeddings(8, &embedding.NoEmbCounts{Skipped: 1, Fallback: 1})
+		s.Count("vectors", 7)
+		if err := s.Finish(db, &progress.Report{Done: 5, Total: 5}, fails); err != nil {
+			t.Fatal(err)
+		}
+		if s.Failed != 1 || s.OOVRate == nil || *s.OOVRate != 0.25 {
+			t.Errorf("got %d failed and OOV rate %v, want 1 and 0.25", s.Failed, s.OOVRate)
+		}
+	}
+
+	// Only the run that is not a dry run is recorded.
+	var runs, processed, skipped, failed int
+	var rate float64
+	var counts string
+	err = db.QueryRow(`SELECT count(*), processed, skipped, failed, oov_rate, counts FROM ingest_runs`).
+		Scan(&runs, &processed, &skipped, &failed, &rate, &counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs != 1 || processed != 5 || skipped != 2 || failed != 1 || rate != 0.25 || counts != `{"vectors":7}` {
+		t.Errorf("got %d runs with %d processed, %d skipped, %d failed, OOV rate %v, and counts %v",
+			runs, processed, skipped, failed, rate, counts)
+	}
+}
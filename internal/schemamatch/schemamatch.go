@@ -0,0 +1,178 @@
+// Package schemamatch aligns the attributes of two tables, for computing how
+// well their schemas match and exporting the mapping between them.
+//
+// The similarity of two attributes combines the cosine similarity of their
+// attribute name embeddings, the overlap of their values estimated from their
+// MinHash signatures, and the similarity of the distributions of their numeric
+// values, each weighted as configured. Only the evidence available for both
+// attributes is weighed, so attributes lacking sketches are matched by name
+// alone. Attributes of incompatible types (see coltype.Compatible) are never
+// matched.
+package schemamatch
+
+import (
+	"math"
+	"sort"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/lazo"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/numsketch"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// Attribute is an attribute of a table with the evidence it is matched by.
+type Attribute struct {
+	Name string
+	// Attribute embedding vector of the name, or nil if it was not embedded.
+	Vector []float32
+	Type   coltype.Type
+	// MinHash signature and distinct count of the values, or nil and 0 if the
+	// column was not sketched.
+	Minhash       []uint64
+	DistinctCount int
+	// Sketch of the numeric values, or nil if the column is not numeric.
+	Numeric *numsketch.Sketch
+}
+
+// Config configures Match.
+type Config struct {
+	// Weights of the name, value overlap, and distribution similarities.
+	NameWeight, ValueWeight, DistributionWeight float64
+	// Minimum similarity of the matched attributes.
+	MinSimilarity float64
+}
+
+// DefaultConfig returns a Config weighting the names the most, since the
+// values of unionable tables, e.g. of different years, often differ.
+func DefaultConfig() *Config {
+	return &Config{
+		NameWeight:         0.5,
+		ValueWeight:        0.3,
+		DistributionWeight: 0.2,
+		MinSimilarity:      0.5,
+	}
+}
+
+// Similarities are the similarities of two attributes. Those that could not
+// be computed, as either attribute lacks the evidence, are -1.
+type Similarities struct {
+	// Cosine similarity of the attribute name embeddings.
+	Name float64
+	// Fraction of the values of the attribute with fewer distinct values
+	// that are values of the other.
+	Values float64
+	// Similarity of the distributions of the numeric values (see
+	// numsketch.Similarity).
+	Distribution float64
+}
+
+// Match is an attribute of the left table matched with one of the right table.
+type Match struct {
+	Left, Right string
+	// Weighted mean of the computed similarities.
+	Similarity float64
+	Similarities
+}
+
+// Mapping is the alignment of the attributes of two tables.
+type Mapping struct {
+	// Sum of the similarities of the matches divided by the number of
+	// attributes of the larger table, between 0 and 1.
+	Score float64
+	// The matched attributes, sorted by similarity.
+	Matches []*Match
+	// The attributes of each table that are not matched, in order.
+	UnmatchedLeft, UnmatchedRight []string
+}
+
+// Compare returns the similarities of attributes a and b and their weighted
+// mean, or 0 if their types are incompatible or no similarity could be
+// computed.
+func (cfg *Config) Compare(a, b *Attribute) (float64, Similarities) {
+	sims := Similarities{-1, -1, -1}
+	if !coltype.Compatible(a.Type, b.Type) {
+		return 0, sims
+	}
+	var sum, weights float64
+
+	if a.Vector != nil && b.Vector != nil {
+		sims.Name = math.Max(0, float64(vec32.Cosine(a.Vector, b.Vector)))
+		sum += cfg.NameWeight * sims.Name
+		weights += cfg.NameWeight
+	}
+	if a.Minhash != nil && b.Minhash != nil {
+		e := lazo.Overlap(a.Minhash, b.Minhash, a.DistinctCount, b.DistinctCount)
+		sims.Values = math.Max(e.Containment, e.ReverseContainment)
+		sum += cfg.ValueWeight * sims.Values
+		weights += cfg.ValueWeight
+	}
+	if a.Numeric != nil && b.Numeric != nil {
+		sims.Distribution = numsketch.Similarity(a.Numeric, b.Numeric)
+		sum += cfg.DistributionWeight * sims.Distribution
+		weights += cfg.DistributionWeight
+	}
+	if weights == 0 {
+		return 0, sims
+	}
+	return sum / weights, sims
+}
+
+// Match aligns the attributes of the left and right tables. The pairs with a
+// similarity of at least cfg.MinSimilarity are matched greedily, most similar
+// first, so that each attribute is matched at most once and the alignment may
+// be partial.
+func (cfg *Config) Match(left, right []*Attribute) *Mapping {
+	type pair struct {
+		l, r int
+		sim  float64
+		sims Similarities
+	}
+	var pairs []pair
+
+	for i, l := range left {
+		for j, r := range right {
+			if sim, sims := cfg.Compare(l, r); sim >= cfg.MinSimilarity && sim > 0 {
+				pairs = append(pairs, pair{i, j, sim, sims})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].sim > pairs[j].sim
+	})
+
+	m := &Mapping{Matches: []*Match{}}
+	lMatched := make(map[int]bool)
+	rMatched := make(map[int]bool)
+	var sum float64
+
+	for _, p := range pairs {
+		if lMatched[p.l] || rMatched[p.r] {
+			continue
+		}
+		lMatched[p.l], rMatched[p.r] = true, true
+		m.Matches = append(m.Matches, &Match{left[p.l].Name, right[p.r].Name, p.sim, p.sims})
+		sum += p.sim
+	}
+	m.UnmatchedLeft = unmatched(left, lMatched)
+	m.UnmatchedRight = unmatched(right, rMatched)
+	n := len(left)
+	if len(right) > n {
+		n = len(right)
+	}
+	if n > 0 {
+		m.Score = sum / float64(n)
+	}
+	return m
+}
+
+// unmatched returns the names of the attributes whose indexes are not in
+// matched.
+func unmatched(attrs []*Attribute, matched map[int]bool) []string {
+	names := []string{}
+	for i, a := range attrs {
+		if !matched[i] {
+			names = append(names, a.Name)
+		}
+	}
+	return names
+}
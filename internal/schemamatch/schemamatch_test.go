@@ -0,0 +1,80 @@
+package schemamatch
+
+import (
+	"math"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/numsketch"
+	"github.com/ekzhu/lshensemble"
+)
+
+// signature returns the MinHash signature of the values.
+func signature(values ...string) []uint64 {
+	mh := lshensemble.NewMinhash(42, 256)
+	for _, v := range values {
+		mh.Push([]byte(v))
+	}
+	return mh.Signature()
+}
+
+func sketch(values ...string) *numsketch.Sketch {
+	b := numsketch.NewBuilder()
+	for _, v := range values {
+		b.Add(v)
+	}
+	return b.Sketch()
+}
+
+func TestMatch(t *testing.T) {
+	boroughs := signature("Bronx", "Brooklyn", "Manhattan", "Queens", "Staten Island")
+	left := []*Attribute{
+		{"borough", []float32{1, 0, 0}, coltype.String, boroughs, 5, nil},
+		{"year", []float32{0, 1, 0}, coltype.Int, signature("2019"), 1, sketch("2019", "2019")},
+		{"notes", []float32{0, 0, 1}, coltype.String, nil, 0, nil},
+	}
+	right := []*Attribute{
+		// Less similar name, but the same values.
+		{"boro", []float32{0.6, 0.8, 0}, coltype.String, boroughs, 5, nil},
+		{"yr", []float32{0, 1, 0}, coltype.Int, signature("2020"), 1, sketch("2020", "2020")},
+		// Same name embedding as notes, but dates.
+		{"note_date", []float32{0, 0, 1}, coltype.Date, nil, 0, nil},
+	}
+	m := DefaultConfig().Match(left, right)
+
+	want := map[string]string{"borough": "boro", "year": "yr"}
+	if len(m.Matches) != len(want) {
+		t.Fatalf("got %d matches, want %d", len(m.Matches), len(want))
+	}
+	var sum float64
+	for _, match := range m.Matches {
+		if want[match.Left] != match.Right {
+			t.Errorf("%v matched with %v", match.Left, match.Right)
+		}
+		sum += match.Similarity
+	}
+	if math.Abs(m.Score-sum/3) > 1e-9 {
+		t.Errorf("score %v, want %v", m.Score, sum/3)
+	}
+	if len(m.UnmatchedLeft) != 1 || m.UnmatchedLeft[0] != "notes" {
+		t.Errorf("unmatched left %q, want [notes]", m.UnmatchedLeft)
+	}
+	if len(m.UnmatchedRight) != 1 || m.UnmatchedRight[0] != "note_date" {
+		t.Errorf("unmatched right %q, want [note_date]", m.UnmatchedRight)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	cfg := DefaultConfig()
+	a := &Attribute{Name: "a", Vector: []float32{1, 0}}
+	b := &Attribute{Name: "b", Vector: []float32{1, 1}}
+
+	// Only the names can be compared.
+	sim, sims := cfg.Compare(a, b)
+	if math.Abs(sim-math.Sqrt2/2) > 1e-6 || sims.Values != -1 || sims.Distribution != -1 {
+		t.Errorf("got %v, %+v", sim, sims)
+	}
+	if sim, _ := cfg.Compare(a, &Attribute{Name: "c"}); sim != 0 {
+		t.Errorf("got %v without evidence, want 0", sim)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+)
+
+// authorizedAdmin reports whether req carries the admin token.
+func (s *Server) authorizedAdmin(req *http.Request) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+type adminIndexStats struct {
+	Name        string             `json:"name,omitempty"`
+	Kind        string             `json:"kind,omitempty"`
+	Vectors     int                `json:"vectors"`
+	Dim         int                `json:"dim"`
+	MemoryBytes int64              `json:"memory_bytes"`
+	MappedBytes int64              `json:"mapped_bytes,omitempty"`
+	Tombstones  int                `json:"tombstones,omitempty"`
+	Shards      []*adminIndexStats `json:"shards,omitempty"`
+	BuiltAt     string             `json:"built_at,omitempty"`
+}
+
+func newAdminIndexStats(st *index.Stats) *adminIndexStats {
+	a := &adminIndexStats{
+		Name:        st.Name,
+		Kind:        st.Kind,
+		Vectors:     st.Vectors,
+		Dim:         st.Dim,
+		MemoryBytes: st.MemoryBytes,
+		MappedBytes: st.MappedBytes,
+		Tombstones:  st.Tombstones,
+	}
+	if !st.BuiltAt.IsZero() {
+		a.BuiltAt = st.BuiltAt.UTC().Format(time.RFC3339)
+	}
+	for _, ss := range st.Shards {
+		a.Shards = append(a.Shards, newAdminIndexStats(ss))
+	}
+	return a
+}
+
+type adminProcessStats struct {
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	Goroutines     int    `json:"goroutines"`
+}
+
+type adminEmbeddingCacheStats struct {
+	MemoryHits int64   `json:"memory_hits"`
+	DiskHits   int64   `json:"disk_hits"`
+	Misses     int64   `json:"misses"`
+	HitRate    float64 `json:"hit_rate"`
+	Entries    int     `json:"entries"`
+}
+
+func newAdminEmbeddingCacheStats(st *embedding.CacheStats) *adminEmbeddingCacheStats {
+	return &adminEmbeddingCacheStats{
+		MemoryHits: st.MemoryHits,
+		DiskHits:   st.DiskHits,
+		Misses:     st.Misses,
+		HitRate:    st.HitRate(),
+		Entries:    st.Entries,
+	}
+}
+
+type adminIndexesResponse struct {
+	Indexes []*adminIndexStats `json:"indexes"`
+	// Whether the joinability index is loaded. Its size is not reported.
+	JoinabilityIndex bool `json:"joinability_index"`
+	// Number of datasets refreshed in the joinability index since it was
+	// built, which are scanned by every joinability query.
+	JoinabilityRefreshed int                       `json:"joinability_refreshed"`
+	EmbeddingCache       *adminEmbeddingCacheStats `json:"embedding_cache,omitempty"`
+	Process              *adminProcessStats        `json:"process"`
+}
+
+// handleAdminIndexes handles GET /admin/indexes.
+//
+// It reports the contents of the loaded indexes, the hit rate of the embedding
+// cache, and the memory used by the server process. Requests must carry the
+// admin token.
+func (s *Server) handleAdminIndexes(w http.ResponseWriter, req *http.Request) {
+	if !s.authorizedAdmin(req) {
+		apiErrorStatus(w, http.StatusForbidden)
+		return
+	}
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	res := &adminIndexesResponse{
+		Indexes:          []*adminIndexStats{},
+		JoinabilityIndex: s.joinabilityIndex != nil,
+	}
+	if s.joinabilityIndex != nil {
+		res.JoinabilityRefreshed = s.joinabilityIndex.Refreshed()
+	}
+	if r, ok := s.metadataIndex.(index.StatsReporter); ok {
+		st := r.Stats()
+		if st.Name == "" {
+			st.Name = "metadata"
+		}
+		res.Indexes = append(res.Indexes, newAdminIndexStats(st))
+	}
+	if st := embedding.CacheStatsOf(s.embedder); st != nil {
+		res.EmbeddingCache = newAdminEmbeddingCacheStats(st)
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	res.Process = &adminProcessStats{
+		HeapAllocBytes: ms.HeapAlloc,
+		SysBytes:       ms.Sys,
+		Goroutines:     runtime.NumGoroutine(),
+	}
+	writeJSON(w, http.StatusOK, res)
+}
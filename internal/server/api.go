@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// apiVersion is a version of the JSON API, served under /api/<name>/.
+//
+// Each version has its own request and response types (see api_v1.go), so a
+// new version can change the shape of results without breaking clients of
+// older versions. When a version is deprecated, its responses carry
+// Deprecation, Sunset, and successor Link headers, until it is removed at the
+// sunset date.
+type apiVersion struct {
+	name string
+	// Sunset is the HTTP date after which a deprecated version may be removed.
+	// If empty, the version is not deprecated.
+	sunset string
+	// Successor is the name of the version replacing a deprecated version.
+	successor string
+	routes    []apiRoute
+}
+
+// apiRoute is an endpoint of an API version.
+type apiRoute struct {
+	// Path relative to the version prefix. A trailing slash matches all paths
+	// under it, as with http.ServeMux, and the request path passed to the
+	// handler is the remainder after the slash.
+	path    string
+	handler http.HandlerFunc
+}
+
+// apiVersions returns the supported versions of the JSON API.
+func (s *Server) apiVersions() []*apiVersion {
+	return []*apiVersion{
+		s.apiV1(),
+	}
+}
+
+// installAPI registers the handlers of all API versions on mux.
+func (s *Server) installAPI(mux *http.ServeMux) {
+	for _, v := range s.apiVersions() {
+		for _, r := range v.routes {
+			pattern := "/api/" + v.name + "/" + r.path
+			var h http.Handler = r.handler
+			if strings.HasSuffix(pattern, "/") {
+				h = http.StripPrefix(pattern, h)
+			}
+			mux.Handle(pattern, v.wrap(h))
+		}
+	}
+	// Unversioned endpoint that predates /api/v1.
+	mux.Handle("/api/dataset/", deprecated("v1", "Sat, 01 May 2027 00:00:00 GMT",
+		"/api/v1/datasets/",
+		http.StripPrefix("/api/dataset/", http.HandlerFunc(s.handleV1Dataset))))
+}
+
+// wrap sets the version's response headers before calling h.
+func (v *apiVersion) wrap(h http.Handler) http.Handler {
+	next := h
+	if v.sunset != "" {
+		next = deprecated(v.successor, v.sunset, "/api/"+v.successor+"/", next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("API-Version", v.name)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// deprecated marks the responses of h as deprecated in favor of the successor
+// API version rooted at successorPath.
+func deprecated(successor, sunset, successorPath string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", sunset)
+		w.Header().Set("Link", "<"+successorPath+">; rel=\"successor-version\"")
+		log.Printf("deprecated API call: %s %s (successor: %s)",
+			req.Method, req.URL.Path, successor)
+		h.ServeHTTP(w, req)
+	})
+}
+
+// apiError is the response body of failed API requests.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeJSON writes v as the JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print(err)
+	}
+}
+
+// apiErrorStatus writes an error response with the status text of code.
+func apiErrorStatus(w http.ResponseWriter, code int) {
+	writeJSON(w, code, &apiError{http.StatusText(code)})
+}
+
+// apiServerError logs err and writes an internal server error response.
+// The error message is only included in dev mode.
+func (s *Server) apiServerError(w http.ResponseWriter, err error) {
+	log.Print(err)
+	msg := http.StatusText(http.StatusInternalServerError)
+	if s.devMode {
+		msg = err.Error()
+	}
+	writeJSON(w, http.StatusInternalServerError, &apiError{msg})
+}
+
+// allowMethods writes a method not allowed response and returns false if the
+// request method is not one of methods.
+func allowMethods(w http.ResponseWriter, req *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if req.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	apiErrorStatus(w, http.StatusMethodNotAllowed)
+	return false
+}
@@ -0,0 +1,1164 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coldomain"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/numsketch"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/pii"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/temporal"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/topk"
+)
+
+func (s *Server) apiV1() *apiVersion {
+	return &apiVersion{
+		name: "v1",
+		routes: []apiRoute{
+			{"search", s.handleV1Search},
+			{"datasets/", s.handleV1Dataset},
+			{"similar-datasets", s.handleV1SimilarDatasets},
+			{"joinable-columns", s.handleV1JoinableColumns},
+			{"unionable-tables", s.handleV1UnionableTables},
+			{"similar-distributions", s.handleV1SimilarDistributions},
+			{"correlated-columns", s.handleV1CorrelatedColumns},
+			{"entity-search", s.handleV1EntitySearch},
+			{"temporal-search", s.handleV1TemporalSearch},
+			{"spatial-search", s.handleV1SpatialSearch},
+			{"relationships", s.handleV1Relationships},
+			{"join-preview", s.handleV1JoinPreview},
+			{"schema-match", s.handleV1SchemaMatch},
+		},
+	}
+}
+
+// v1Dataset is the v1 representation of a dataset's metadata.
+type v1Dataset struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Attribution  string   `json:"attribution"`
+	ContactEmail string   `json:"contact_email"`
+	UpdatedAt    string   `json:"updated_at"`
+	Categories   []string `json:"categories"`
+	Tags         []string `json:"tags"`
+	Permalink    string   `json:"permalink"`
+}
+
+func newV1Dataset(m *database.Metadata) *v1Dataset {
+	return &v1Dataset{
+		ID:           m.DatasetID,
+		Name:         m.Name,
+		Description:  m.Description,
+		Attribution:  m.Attribution,
+		ContactEmail: m.ContactEmail,
+		UpdatedAt:    m.UpdatedAt,
+		Categories:   m.Categories,
+		Tags:         m.Tags,
+		Permalink:    m.Permalink,
+	}
+}
+
+func newV1Datasets(ms []*database.Metadata) []*v1Dataset {
+	datasets := make([]*v1Dataset, len(ms))
+	for i, m := range ms {
+		datasets[i] = newV1Dataset(m)
+	}
+	return datasets
+}
+
+// v1Column is the v1 representation of a dataset column.
+type v1Column struct {
+	ID            string   `json:"id"`
+	DatasetID     string   `json:"dataset_id"`
+	Name          string   `json:"name"`
+	DistinctCount int      `json:"distinct_count"`
+	Sample        []string `json:"sample"`
+	// Kinds of personal information of the column, if it is sensitive (see
+	// package pii), in dataset responses.
+	Sensitive []pii.Kind `json:"sensitive,omitempty"`
+	// Profile of the column, if it has been profiled, in dataset responses.
+	Profile *v1ColumnProfile `json:"profile,omitempty"`
+}
+
+// v1ColumnProfile is the v1 representation of the profile of a column. The
+// numeric summaries are omitted if the column has no numeric values.
+type v1ColumnProfile struct {
+	Type          coltype.Type `json:"type"`
+	NullCount     int64        `json:"null_count"`
+	NullRate      float64      `json:"null_rate"`
+	DistinctCount int64        `json:"distinct_count"`
+	TopValues     []*topk.Item `json:"top_values"`
+	NumericCount  int64        `json:"numeric_count"`
+	Min           *float64     `json:"min,omitempty"`
+	Max           *float64     `json:"max,omitempty"`
+	Mean          *float64     `json:"mean,omitempty"`
+	Stddev        *float64     `json:"stddev,omitempty"`
+}
+
+func newV1ColumnProfile(c *database.ColumnProfile) *v1ColumnProfile {
+	return &v1ColumnProfile{
+		Type:          c.Type,
+		NullCount:     c.NullCount,
+		NullRate:      c.NullRate,
+		DistinctCount: c.DistinctCount,
+		TopValues:     c.TopValues,
+		NumericCount:  c.NumericCount,
+		Min:           c.Min,
+		Max:           c.Max,
+		Mean:          c.Mean,
+		Stddev:        c.Stddev,
+	}
+}
+
+func newV1Column(c *database.ColumnSketch) *v1Column {
+	return &v1Column{
+		ID:            c.ColumnID,
+		DatasetID:     c.DatasetID,
+		Name:          c.ColumnName,
+		DistinctCount: c.DistinctCount,
+		Sample:        c.Sample,
+	}
+}
+
+type v1SearchResponse struct {
+	Query   string       `json:"query"`
+	Results []*v1Dataset `json:"results"`
+}
+
+type v1BatchSearchResponse struct {
+	Results []*v1SearchResponse `json:"results"`
+}
+
+type v1DatasetResponse struct {
+	*v1Dataset
+	// Number of rows of the dataset, if it has been profiled.
+	RowCount *int64      `json:"row_count,omitempty"`
+	Columns  []*v1Column `json:"columns"`
+}
+
+type v1SimilarDatasetsResponse struct {
+	DatasetID string       `json:"dataset_id"`
+	Results   []*v1Dataset `json:"results"`
+}
+
+type v1JoinableColumn struct {
+	Column      *v1Column `json:"column"`
+	DatasetName string    `json:"dataset_name"`
+	Containment float64   `json:"containment"`
+	// Fraction of the values of the column that are values of the query
+	// column.
+	ReverseContainment float64 `json:"reverse_containment"`
+	// Estimated number of distinct values of both columns.
+	SharedValues int64 `json:"shared_values"`
+	// Uniqueness of the column's values, and whether it is a candidate key
+	// of its table, if sketch_columns profiled it.
+	Uniqueness   float64 `json:"uniqueness,omitempty"`
+	CandidateKey bool    `json:"candidate_key"`
+	// Similarity of the column names and combined score, in semantic
+	// searches.
+	NameSimilarity float32 `json:"name_similarity,omitempty"`
+	Score          float64 `json:"score,omitempty"`
+	// Why the column was suggested.
+	Explanation *v1JoinExplanation `json:"explanation"`
+}
+
+type v1JoinExplanation struct {
+	Containment    float64  `json:"containment"`
+	SharedValues   int64    `json:"shared_values"`
+	SampleOverlap  []string `json:"sample_overlap"`
+	NameSimilarity float32  `json:"name_similarity"`
+	QueryType      string   `json:"query_type"`
+	Type           string   `json:"type"`
+	Compatible     bool     `json:"types_compatible"`
+	QueryDomain    string   `json:"query_domain,omitempty"`
+	Domain         string   `json:"domain,omitempty"`
+}
+
+func newV1JoinExplanation(e *index.JoinExplanation) *v1JoinExplanation {
+	return &v1JoinExplanation{
+		Containment:    e.Containment,
+		SharedValues:   int64(math.Round(e.SharedValues)),
+		SampleOverlap:  e.SampleOverlap,
+		NameSimilarity: e.NameSimilarity,
+		QueryType:      string(e.QueryType),
+		Type:           string(e.Type),
+		Compatible:     e.Compatible,
+		QueryDomain:    string(e.QueryDomain),
+		Domain:         string(e.Domain),
+	}
+}
+
+type v1JoinableColumnsResponse struct {
+	Column  *v1Column           `json:"column"`
+	Results []*v1JoinableColumn `json:"results"`
+}
+
+type v1SimilarDistribution struct {
+	Column      *v1Column `json:"column"`
+	DatasetName string    `json:"dataset_name"`
+	Similarity  float64   `json:"similarity"`
+}
+
+type v1SimilarDistributionsResponse struct {
+	Column  *v1Column                `json:"column"`
+	Results []*v1SimilarDistribution `json:"results"`
+}
+
+type v1JoinPreview struct {
+	Left            *v1Column      `json:"left"`
+	Right           *v1Column      `json:"right"`
+	LeftColumns     []string       `json:"left_columns"`
+	RightColumns    []string       `json:"right_columns"`
+	Rows            []*v1JoinedRow `json:"rows"`
+	MatchRate       float64        `json:"match_rate"`
+	SampleMatchRate float64        `json:"sample_match_rate"`
+}
+
+type v1JoinedRow struct {
+	Left  []string `json:"left"`
+	Right []string `json:"right"`
+}
+
+func columnNames(cols []*database.ColumnSketch) []string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.ColumnName
+	}
+	return names
+}
+
+type v1SchemaMatch struct {
+	Left                   string   `json:"left"`
+	Right                  string   `json:"right"`
+	Similarity             float64  `json:"similarity"`
+	NameSimilarity         *float64 `json:"name_similarity"`
+	ValueSimilarity        *float64 `json:"value_similarity"`
+	DistributionSimilarity *float64 `json:"distribution_similarity"`
+}
+
+type v1SchemaMatchResponse struct {
+	Left           string           `json:"left"`
+	Right          string           `json:"right"`
+	Score          float64          `json:"score"`
+	Matches        []*v1SchemaMatch `json:"matches"`
+	UnmatchedLeft  []string         `json:"unmatched_left"`
+	UnmatchedRight []string         `json:"unmatched_right"`
+}
+
+// computed returns a pointer to the similarity sim, or nil if it was not
+// computed (see schemamatch.Similarities).
+func computed(sim float64) *float64 {
+	if sim < 0 {
+		return nil
+	}
+	return &sim
+}
+
+type v1Relationship struct {
+	Column         *v1Column `json:"column"`
+	DatasetName    string    `json:"dataset_name"`
+	KeyColumn      *v1Column `json:"key_column"`
+	KeyDatasetName string    `json:"key_dataset_name"`
+	Containment    float64   `json:"containment"`
+	SharedValues   int64     `json:"shared_values"`
+}
+
+type v1RelationshipsResponse struct {
+	DatasetID string            `json:"dataset_id"`
+	Results   []*v1Relationship `json:"results"`
+}
+
+type v1CorrelatedColumn struct {
+	Column      *v1Column `json:"column"`
+	DatasetName string    `json:"dataset_name"`
+	QueryKey    *v1Column `json:"query_key"`
+	KeyColumn   *v1Column `json:"key_column"`
+	Correlation float64   `json:"correlation"`
+	Keys        int       `json:"keys"`
+}
+
+type v1CorrelatedColumnsResponse struct {
+	Column  *v1Column             `json:"column"`
+	Results []*v1CorrelatedColumn `json:"results"`
+}
+
+type v1EntitySearchRequest struct {
+	Entities       []string `json:"entities"`
+	MinContainment float64  `json:"min_containment"`
+}
+
+type v1EntitySearchResult struct {
+	DatasetID   string    `json:"dataset_id"`
+	DatasetName string    `json:"dataset_name"`
+	Column      *v1Column `json:"column"`
+	Containment float64   `json:"containment"`
+	Matched     int64     `json:"matched"`
+}
+
+type v1EntitySearchResponse struct {
+	Results []*v1EntitySearchResult `json:"results"`
+}
+
+type v1TemporalSearchResult struct {
+	DatasetID   string    `json:"dataset_id"`
+	DatasetName string    `json:"dataset_name"`
+	Column      *v1Column `json:"column"`
+	Granularity string    `json:"granularity"`
+	MinTime     string    `json:"min_time"`
+	MaxTime     string    `json:"max_time"`
+	Overlap     float64   `json:"overlap"`
+}
+
+type v1TemporalSearchResponse struct {
+	Start       string                    `json:"start"`
+	End         string                    `json:"end"`
+	Granularity string                    `json:"granularity"`
+	Results     []*v1TemporalSearchResult `json:"results"`
+}
+
+// v1SpatialColumn is the v1 representation of a spatial column, or of a pair
+// of latitude and longitude columns.
+type v1SpatialColumn struct {
+	Kind    string      `json:"kind"`
+	Columns []*v1Column `json:"columns"`
+	// The bounding box of lat_long, point, and geohash columns.
+	Box *v1Box `json:"box,omitempty"`
+	// The regions of zip_code and fips_code columns.
+	Regions []string `json:"regions,omitempty"`
+}
+
+type v1Box struct {
+	MinLat  float64 `json:"min_lat"`
+	MinLong float64 `json:"min_long"`
+	MaxLat  float64 `json:"max_lat"`
+	MaxLong float64 `json:"max_long"`
+}
+
+func newV1SpatialColumn(c *database.SpatialColumn, sketches []*database.ColumnSketch) *v1SpatialColumn {
+	res := &v1SpatialColumn{Kind: string(c.Kind), Columns: make([]*v1Column, len(sketches))}
+	for i, sketch := range sketches {
+		res.Columns[i] = newV1Column(sketch)
+	}
+	if c.Kind.Boxed() {
+		res.Box = &v1Box{c.Box.MinLat, c.Box.MinLong, c.Box.MaxLat, c.Box.MaxLong}
+	} else {
+		res.Regions = c.Regions
+	}
+	return res
+}
+
+type v1SpatialSearchResult struct {
+	DatasetID   string           `json:"dataset_id"`
+	DatasetName string           `json:"dataset_name"`
+	QueryColumn *v1SpatialColumn `json:"query_column"`
+	Column      *v1SpatialColumn `json:"column"`
+	Overlap     float64          `json:"overlap"`
+}
+
+type v1SpatialSearchResponse struct {
+	ID      string                   `json:"id"`
+	Results []*v1SpatialSearchResult `json:"results"`
+}
+
+type v1UnionableTable struct {
+	DatasetID   string  `json:"dataset_id"`
+	DatasetName string  `json:"dataset_name"`
+	Alignment   float64 `json:"alignment"`
+	// The matched attributes, in schema searches.
+	Attributes []*v1AttributeMatch `json:"attributes,omitempty"`
+	// The columns each query column is unioned with.
+	Mappings []*v1AttributeMapping `json:"mappings"`
+}
+
+// v1AttributeMapping is the v1 representation of the mapping of a query column
+// to a column of a unionable table. The types are omitted if unknown, and the
+// column, its type, the cast, and the score if the query column has no
+// unionable column; the union then holds nulls for the query column.
+type v1AttributeMapping struct {
+	QueryColumn string `json:"query_column"`
+	QueryType   string `json:"query_type,omitempty"`
+	Column      string `json:"column,omitempty"`
+	Type        string `json:"type,omitempty"`
+	// The type both columns are cast to; a column of another type must be
+	// cast to it.
+	Cast  string  `json:"cast,omitempty"`
+	Score float64 `json:"score,omitempty"`
+}
+
+type v1AttributeMatch struct {
+	QueryAttribute string  `json:"query_attribute"`
+	Attribute      string  `json:"attribute"`
+	Similarity     float32 `json:"similarity"`
+}
+
+type v1UnionableTablesResponse struct {
+	DatasetID string              `json:"dataset_id"`
+	Results   []*v1UnionableTable `json:"results"`
+}
+
+// v1MetadataPatch is the request body of PATCH /api/v1/datasets/<id>.
+// Fields that are omitted are left unchanged.
+type v1MetadataPatch struct {
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	Attribution *string   `json:"attribution"`
+	Categories  *[]string `json:"categories"`
+	Tags        *[]string `json:"tags"`
+}
+
+// decodeV1MetadataPatch decodes the request body of a v1 metadata edit.
+func decodeV1MetadataPatch(r io.Reader) (*metadataPatch, error) {
+	var patch v1MetadataPatch
+	if err := json.NewDecoder(r).Decode(&patch); err != nil {
+		return nil, err
+	}
+	p := patch.toPatch()
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseMinSimilarity parses the min_similarity parameter of similar dataset
+// searches.
+func parseMinSimilarity(v string) (float32, error) {
+	minSim, err := strconv.ParseFloat(v, 32)
+	// Written to also reject NaN.
+	if err != nil || !(minSim >= -1 && minSim <= 1) {
+		return 0, errors.New("min_similarity must be a number between -1 and 1")
+	}
+	return float32(minSim), nil
+}
+
+// parseMinContainment parses the min_containment parameter of joinable column
+// searches.
+func parseMinContainment(v string) (float64, error) {
+	minContainment, err := strconv.ParseFloat(v, 64)
+	// Written to also reject NaN.
+	if err != nil || !(minContainment > 0 && minContainment <= 1) {
+		return 0, errors.New("min_containment must be a number greater than 0 and at most 1")
+	}
+	return minContainment, nil
+}
+
+func (p *v1MetadataPatch) toPatch() *metadataPatch {
+	return &metadataPatch{
+		Name:        p.Name,
+		Description: p.Description,
+		Attribution: p.Attribution,
+		Categories:  p.Categories,
+		Tags:        p.Tags,
+	}
+}
+
+// handleV1Search handles GET /api/v1/search?q=<query>.
+//
+// If q is given more than once, the searches are run as a batch and the
+// response holds the results of each query in order. If domain is given, only
+// the results having a column in that semantic domain are returned.
+func (s *Server) handleV1Search(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	req.ParseForm()
+	var domain coldomain.Domain
+	if d := req.FormValue("domain"); d != "" {
+		var err error
+		if domain, err = coldomain.Parse(d); err != nil {
+			writeJSON(w, http.StatusBadRequest, &apiError{err.Error()})
+			return
+		}
+	}
+	if queries := req.Form["q"]; len(queries) > 1 {
+		results, err := s.keywordSearchBatch(queries)
+		if err != nil {
+			s.apiServerError(w, err)
+			return
+		}
+		res := &v1BatchSearchResponse{make([]*v1SearchResponse, len(queries))}
+		for i, query := range queries {
+			if domain != coldomain.None {
+				if results[i], err = s.domainResults(results[i], domain); err != nil {
+					s.apiServerError(w, err)
+					return
+				}
+			}
+			res.Results[i] = &v1SearchResponse{query, newV1Datasets(results[i])}
+		}
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	query := req.FormValue("q")
+	results, err := s.keywordSearch(query, false)
+	if err == nil && domain != coldomain.None {
+		results, err = s.domainResults(results, domain)
+	}
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &v1SearchResponse{query, newV1Datasets(results)})
+}
+
+// handleV1Dataset handles GET and PATCH /api/v1/datasets/<id>.
+// The path prefix is stripped before the handler is called.
+func (s *Server) handleV1Dataset(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet, http.MethodPatch) {
+		return
+	}
+	datasetID := req.URL.Path
+	if req.Method == http.MethodPatch {
+		s.patchV1Dataset(w, req, datasetID)
+		return
+	}
+	meta, err := s.db.Metadata(datasetID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	cols, sensitive, err := s.datasetColumns(datasetID)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	profile, profiles, err := s.datasetProfiles(datasetID, sensitive)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	res := &v1DatasetResponse{v1Dataset: newV1Dataset(meta), Columns: make([]*v1Column, len(cols))}
+	if profile != nil {
+		res.RowCount = &profile.RowCount
+	}
+	columnProfiles := make(map[string]*database.ColumnProfile, len(profiles))
+	for _, p := range profiles {
+		columnProfiles[p.ColumnID] = p
+	}
+	for i, c := range cols {
+		res.Columns[i] = newV1Column(c)
+		res.Columns[i].Sensitive = sensitive[c.ColumnID]
+		if p := columnProfiles[c.ColumnID]; p != nil {
+			res.Columns[i].Profile = newV1ColumnProfile(p)
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) patchV1Dataset(w http.ResponseWriter, req *http.Request, datasetID string) {
+	if !s.authorizedEdit(req) {
+		apiErrorStatus(w, http.StatusForbidden)
+		return
+	}
+	patch, err := decodeV1MetadataPatch(req.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &apiError{err.Error()})
+		return
+	}
+	meta, err := s.editMetadata(datasetID, patch)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, newV1Dataset(meta))
+}
+
+// handleV1SimilarDatasets handles
+// GET /api/v1/similar-datasets?id=<id>[&min_similarity=<cutoff>].
+//
+// If min_similarity is given, all datasets with a greater cosine similarity are
+// returned instead of the 20 most similar.
+func (s *Server) handleV1SimilarDatasets(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	queryID := req.FormValue("id")
+	var results []*database.Metadata
+	var err error
+
+	if v := req.FormValue("min_similarity"); v != "" {
+		minSim, perr := parseMinSimilarity(v)
+		if perr != nil {
+			writeJSON(w, http.StatusBadRequest, &apiError{perr.Error()})
+			return
+		}
+		results, err = s.similarDatasetsAbove(queryID, minSim)
+	} else {
+		results, err = s.similarDatasets(queryID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else if err == errNoThreshold {
+			writeJSON(w, http.StatusNotImplemented, &apiError{err.Error()})
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, &v1SimilarDatasetsResponse{queryID, newV1Datasets(results)})
+}
+
+// handleV1JoinableColumns handles
+// GET /api/v1/joinable-columns?id=<column id>[&min_containment=<cutoff>] and
+// GET /api/v1/joinable-columns?dataset=<id>&column=<name>[&min_containment=<cutoff>].
+//
+// min_containment overrides the configured joinability threshold. With
+// mode=semantic, columns with names similar to the query column's are found as
+// well, and the results are sorted by a score combining both. With
+// key_only=true, only the columns that are candidate keys of their tables are
+// returned.
+func (s *Server) handleV1JoinableColumns(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	threshold := s.joinabilityThreshold
+	if v := req.FormValue("min_containment"); v != "" {
+		var err error
+		if threshold, err = parseMinContainment(v); err != nil {
+			writeJSON(w, http.StatusBadRequest, &apiError{err.Error()})
+			return
+		}
+	}
+	mode := req.FormValue("mode")
+	if mode != "" && mode != "values" && mode != "semantic" {
+		writeJSON(w, http.StatusBadRequest, &apiError{"mode must be values or semantic"})
+		return
+	}
+	var keyOnly bool
+	if v := req.FormValue("key_only"); v != "" {
+		var err error
+		if keyOnly, err = strconv.ParseBool(v); err != nil {
+			writeJSON(w, http.StatusBadRequest, &apiError{"key_only must be true or false"})
+			return
+		}
+	}
+	var query *database.ColumnSketch
+	var err error
+	if datasetID := req.FormValue("dataset"); datasetID != "" {
+		query, err = s.db.ColumnSketchByName(datasetID, req.FormValue("column"))
+	} else {
+		query, err = s.db.ColumnSketch(req.FormValue("id"))
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	var results []*joinabilityResult
+	if mode == "semantic" {
+		results, err = s.semanticJoinableColumns(query, threshold)
+	} else {
+		results, err = s.joinableColumns(query, threshold, false)
+	}
+	if err != nil {
+		if err == errNoSemanticJoin {
+			writeJSON(w, http.StatusNotImplemented, &apiError{err.Error()})
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	if keyOnly {
+		keys := results[:0]
+		for _, r := range results {
+			if r.Key() {
+				keys = append(keys, r)
+			}
+		}
+		results = keys
+	}
+	explanations, err := s.explainJoins(query, results, mode == "semantic")
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	res := &v1JoinableColumnsResponse{
+		newV1Column(query), make([]*v1JoinableColumn, len(results)),
+	}
+	for i, r := range results {
+		res.Results[i] = &v1JoinableColumn{
+			newV1Column(r.ColumnSketch), r.DatasetName, r.Containment,
+			r.ReverseContainment, int64(math.Round(r.Intersection)),
+			r.Uniqueness, r.Key(), r.NameSimilarity, r.Score,
+			newV1JoinExplanation(explanations[i]),
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleV1SimilarDistributions handles
+// GET /api/v1/similar-distributions?id=<column id>[&metric=<emd or ks>].
+func (s *Server) handleV1SimilarDistributions(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	metric := numsketch.MetricEMD
+	if v := req.FormValue("metric"); v != "" {
+		var err error
+		if metric, err = numsketch.ParseMetric(v); err != nil {
+			writeJSON(w, http.StatusBadRequest, &apiError{err.Error()})
+			return
+		}
+	}
+	query, err := s.db.ColumnSketch(req.FormValue("id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	results, err := s.similarDistributions(query.ColumnID, metric)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, &apiError{"column is not numeric"})
+		} else if err == errNoNumericIndex {
+			writeJSON(w, http.StatusNotImplemented, &apiError{err.Error()})
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	res := &v1SimilarDistributionsResponse{
+		newV1Column(query), make([]*v1SimilarDistribution, len(results)),
+	}
+	for i, r := range results {
+		res.Results[i] = &v1SimilarDistribution{
+			newV1Column(r.ColumnSketch), r.DatasetName, r.Similarity,
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleV1JoinPreview handles
+// GET /api/v1/join-preview?left=<column id>&right=<column id>[&limit=<n>].
+//
+// The response holds up to limit (default 10, at most 100) rows of the inner
+// join of the sample rows of the two columns' datasets on those columns, and
+// the estimated fraction of the left column's values that match.
+func (s *Server) handleV1JoinPreview(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	limit := defaultPreviewRows
+	if v := req.FormValue("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxPreviewRows {
+			writeJSON(w, http.StatusBadRequest, &apiError{
+				fmt.Sprintf("limit must be an integer between 1 and %d", maxPreviewRows)})
+			return
+		}
+	}
+	p, err := s.joinPreview(req.FormValue("left"), req.FormValue("right"), limit)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	res := &v1JoinPreview{
+		Left:            newV1Column(p.Left),
+		Right:           newV1Column(p.Right),
+		LeftColumns:     columnNames(p.LeftColumns),
+		RightColumns:    columnNames(p.RightColumns),
+		Rows:            make([]*v1JoinedRow, len(p.Rows)),
+		MatchRate:       p.MatchRate,
+		SampleMatchRate: p.SampleMatchRate,
+	}
+	for i, r := range p.Rows {
+		res.Rows[i] = &v1JoinedRow{r.Left, r.Right}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleV1SchemaMatch handles GET /api/v1/schema-match?left=<id>&right=<id>.
+//
+// The response is the alignment of the attributes of the two datasets (see
+// schemamatch.Config.Match); similarities that could not be computed for a
+// match are null.
+func (s *Server) handleV1SchemaMatch(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	left, right := req.FormValue("left"), req.FormValue("right")
+	m, err := s.schemaMatcher.Match(left, right)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	res := &v1SchemaMatchResponse{
+		Left:           left,
+		Right:          right,
+		Score:          m.Score,
+		Matches:        make([]*v1SchemaMatch, len(m.Matches)),
+		UnmatchedLeft:  m.UnmatchedLeft,
+		UnmatchedRight: m.UnmatchedRight,
+	}
+	for i, match := range m.Matches {
+		res.Matches[i] = &v1SchemaMatch{
+			Left:                   match.Left,
+			Right:                  match.Right,
+			Similarity:             match.Similarity,
+			NameSimilarity:         computed(match.Name),
+			ValueSimilarity:        computed(match.Values),
+			DistributionSimilarity: computed(match.Distribution),
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleV1Relationships handles GET /api/v1/relationships?id=<id>.
+//
+// The results are the inferred foreign key relationships in which a column of
+// the dataset references, or is referenced by, a column of another dataset.
+func (s *Server) handleV1Relationships(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	datasetID := req.FormValue("id")
+	if _, err := s.db.DatasetName(datasetID); err != nil {
+		if err == sql.ErrNoRows {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	results, err := s.relationships(datasetID)
+	if err != nil {
+		if err == errNoRelationships {
+			writeJSON(w, http.StatusNotImplemented, &apiError{err.Error()})
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	res := &v1RelationshipsResponse{datasetID, make([]*v1Relationship, len(results))}
+	for i, r := range results {
+		res.Results[i] = &v1Relationship{
+			Column:         newV1Column(r.Column),
+			DatasetName:    r.DatasetName,
+			KeyColumn:      newV1Column(r.KeyColumn),
+			KeyDatasetName: r.KeyDatasetName,
+			Containment:    r.Containment,
+			SharedValues:   int64(math.Round(r.SharedValues)),
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleV1CorrelatedColumns handles
+// GET /api/v1/correlated-columns?id=<column id>.
+func (s *Server) handleV1CorrelatedColumns(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	query, err := s.db.ColumnSketch(req.FormValue("id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	results, err := s.correlatedColumns(query.ColumnID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, &apiError{"column has no correlation sketches"})
+		} else if err == errNoCorrelationSketches || err == errNoJoinabilityIndex {
+			writeJSON(w, http.StatusNotImplemented, &apiError{err.Error()})
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	res := &v1CorrelatedColumnsResponse{newV1Column(query), make([]*v1CorrelatedColumn, len(results))}
+	for i, r := range results {
+		res.Results[i] = &v1CorrelatedColumn{
+			Column:      newV1Column(r.Column),
+			DatasetName: r.DatasetName,
+			QueryKey:    newV1Column(r.QueryKey),
+			KeyColumn:   newV1Column(r.KeyColumn),
+			Correlation: r.Correlation,
+			Keys:        r.Keys,
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleV1EntitySearch handles POST /api/v1/entity-search, whose body is a
+// JSON object with the list of entities and optionally the min_containment,
+// the configured joinability threshold by default.
+//
+// The results are the datasets with a categorical column containing at least
+// min_containment of the distinct entities, with the containment and the
+// estimated number of entities matched.
+func (s *Server) handleV1EntitySearch(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodPost) {
+		return
+	}
+	var body v1EntitySearchRequest
+	r := http.MaxBytesReader(w, req.Body, maxEntityBodyBytes)
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, &apiError{err.Error()})
+		return
+	}
+	if len(body.Entities) == 0 || len(body.Entities) > maxEntities {
+		writeJSON(w, http.StatusBadRequest, &apiError{
+			fmt.Sprintf("entities must be a list of 1 to %d values", maxEntities)})
+		return
+	}
+	threshold := s.joinabilityThreshold
+	if body.MinContainment != 0 {
+		if !(body.MinContainment > 0 && body.MinContainment <= 1) {
+			writeJSON(w, http.StatusBadRequest, &apiError{"min_containment must be a number greater than 0 and at most 1"})
+			return
+		}
+		threshold = body.MinContainment
+	}
+	results, err := s.entitySearch(body.Entities, threshold)
+	if err != nil {
+		if err == errNoJoinabilityIndex {
+			writeJSON(w, http.StatusNotImplemented, &apiError{err.Error()})
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	res := &v1EntitySearchResponse{make([]*v1EntitySearchResult, len(results))}
+	for i, r := range results {
+		res.Results[i] = &v1EntitySearchResult{
+			DatasetID:   r.DatasetID,
+			DatasetName: r.DatasetName,
+			Column:      newV1Column(r.ColumnSketch),
+			Containment: r.Containment,
+			Matched:     int64(math.Round(r.Intersection)),
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleV1UnionableTables handles
+// GET /api/v1/unionable-tables?id=<id>[&mode=schema[&limit=<k>]].
+//
+// With mode=schema, the tables are aligned by attribute name embedding and
+// type rather than by value overlap, and the limit (at most 100) best tables
+// are returned.
+func (s *Server) handleV1UnionableTables(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	queryID := req.FormValue("id")
+	mode := req.FormValue("mode")
+	if mode != "" && mode != "values" && mode != "schema" {
+		writeJSON(w, http.StatusBadRequest, &apiError{"mode must be values or schema"})
+		return
+	}
+	var results []*unionabilityResult
+	var err error
+	if mode == "schema" {
+		limit := int(s.similarResults)
+		if v := req.FormValue("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 100 {
+				writeJSON(w, http.StatusBadRequest, &apiError{"limit must be an integer between 1 and 100"})
+				return
+			}
+		}
+		results, err = s.schemaUnionableTables(queryID, limit)
+	} else {
+		results, err = s.unionableTables(queryID)
+	}
+	if err != nil {
+		if err == errInvalidID {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else if err == errNoSchemaUnion {
+			writeJSON(w, http.StatusNotImplemented, &apiError{err.Error()})
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	res := &v1UnionableTablesResponse{queryID, make([]*v1UnionableTable, len(results))}
+	for i, r := range results {
+		res.Results[i] = &v1UnionableTable{
+			DatasetID:   r.DatasetID,
+			DatasetName: r.DatasetName,
+			Alignment:   r.Alignment,
+			Mappings:    make([]*v1AttributeMapping, len(r.Mappings)),
+		}
+		for _, m := range r.Matches {
+			res.Results[i].Attributes = append(res.Results[i].Attributes,
+				&v1AttributeMatch{m.Query, m.Candidate, m.Similarity})
+		}
+		for j, m := range r.Mappings {
+			res.Results[i].Mappings[j] = &v1AttributeMapping{
+				QueryColumn: m.QueryColumn,
+				QueryType:   string(m.QueryType),
+				Column:      m.Column,
+				Type:        string(m.Type),
+				Cast:        string(m.Cast),
+				Score:       m.Score,
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleV1TemporalSearch handles
+// GET /api/v1/temporal-search?start=<time>&end=<time>&granularity=<granularity>
+// and GET /api/v1/temporal-search?id=<column id>&granularity=<granularity>.
+// With id, the range is that of the time column with the ID, and its dataset
+// is left out of the results. The granularity is that of the join, which the
+// times of the results must roll up to: that of the column by default with id,
+// and year, admitting any time column, otherwise.
+//
+// The results are the datasets with a time column overlapping the range, with
+// the fraction of the range it covers.
+func (s *Server) handleV1TemporalSearch(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	var start, end time.Time
+	var exclude string
+	g := temporal.Year
+
+	if columnID := req.FormValue("id"); columnID != "" {
+		if ok, err := database.HasTable(s.db, "time_columns"); err != nil {
+			s.apiServerError(w, err)
+			return
+		} else if !ok {
+			writeJSON(w, http.StatusNotImplemented, &apiError{errNoTimeColumns.Error()})
+			return
+		}
+		c, err := s.db.TimeColumn(columnID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				apiErrorStatus(w, http.StatusNotFound)
+			} else {
+				s.apiServerError(w, err)
+			}
+			return
+		}
+		start, end, g, exclude = c.Min, c.Max, c.Granularity, c.DatasetID
+	} else {
+		var ok bool
+		if start, ok = coltype.ParseDate(req.FormValue("start")); !ok {
+			writeJSON(w, http.StatusBadRequest, &apiError{"start must be a date or time"})
+			return
+		}
+		if end, ok = coltype.ParseDate(req.FormValue("end")); !ok {
+			writeJSON(w, http.StatusBadRequest, &apiError{"end must be a date or time"})
+			return
+		}
+		if end.Before(start) {
+			writeJSON(w, http.StatusBadRequest, &apiError{"end must not be before start"})
+			return
+		}
+	}
+	if v := req.FormValue("granularity"); v != "" {
+		var err error
+		if g, err = temporal.ParseGranularity(v); err != nil {
+			writeJSON(w, http.StatusBadRequest, &apiError{err.Error()})
+			return
+		}
+	}
+	results, err := s.temporalSearch(start, end, g, exclude)
+	if err != nil {
+		if err == errNoTimeColumns {
+			writeJSON(w, http.StatusNotImplemented, &apiError{err.Error()})
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	res := &v1TemporalSearchResponse{
+		Start:       start.UTC().Format(database.TimeLayout),
+		End:         end.UTC().Format(database.TimeLayout),
+		Granularity: g.String(),
+		Results:     make([]*v1TemporalSearchResult, len(results)),
+	}
+	for i, r := range results {
+		res.Results[i] = &v1TemporalSearchResult{
+			DatasetID:   r.DatasetID,
+			DatasetName: r.DatasetName,
+			Column:      newV1Column(r.Column),
+			Granularity: r.Granularity.String(),
+			MinTime:     r.Min.Format(database.TimeLayout),
+			MaxTime:     r.Max.Format(database.TimeLayout),
+			Overlap:     r.Overlap,
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleV1SpatialSearch handles GET /api/v1/spatial-search?id=<id>.
+//
+// The results are the datasets spatially joinable with the dataset, with the
+// spatial columns of both datasets and the fraction of the extent of the
+// dataset's column that the other covers.
+func (s *Server) handleV1SpatialSearch(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	datasetID := req.FormValue("id")
+	if _, err := s.db.DatasetName(datasetID); err != nil {
+		if err == sql.ErrNoRows {
+			apiErrorStatus(w, http.StatusNotFound)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	results, err := s.spatialSearch(datasetID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, &apiError{"dataset has no spatial columns"})
+		} else if err == errNoSpatialColumns {
+			writeJSON(w, http.StatusNotImplemented, &apiError{err.Error()})
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	res := &v1SpatialSearchResponse{datasetID, make([]*v1SpatialSearchResult, len(results))}
+	for i, r := range results {
+		res.Results[i] = &v1SpatialSearchResult{
+			DatasetID:   r.Column.DatasetID,
+			DatasetName: r.DatasetName,
+			QueryColumn: newV1SpatialColumn(r.QueryColumn, r.QuerySketches),
+			Column:      newV1SpatialColumn(r.Column, r.Sketches),
+			Overlap:     r.Overlap,
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
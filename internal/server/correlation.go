@@ -0,0 +1,112 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/corrsketch"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+var errNoCorrelationSketches = errors.New("correlation sketches are not computed")
+
+// Minimum number of shared keys of the sketches of correlated columns, below
+// which the estimated correlation is mostly noise.
+const minCorrelationKeys = 20
+
+type correlationResult struct {
+	// The correlated column and the key columns the datasets are joined on.
+	Column              *database.ColumnSketch
+	QueryKey, KeyColumn *database.ColumnSketch
+	DatasetName         string
+	// Estimated Pearson correlation and number of keys it is estimated from.
+	Correlation float64
+	Keys        int
+}
+
+// correlatedColumns returns the numeric columns of other datasets that are the
+// most correlated with the numeric column with the given ID, positively or
+// negatively, once their datasets are joined on key columns found by the
+// joinability index (see corrsketch.Correlation), sorted by the absolute value
+// of the correlation.
+//
+// Returns errNoCorrelationSketches if the database has no
+// correlation_sketches table, and sql.ErrNoRows if the column has no sketch.
+func (s *Server) correlatedColumns(columnID string) ([]*correlationResult, error) {
+	if ok, err := database.HasTable(s.db, "correlation_sketches"); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errNoCorrelationSketches
+	}
+	if s.joinabilityIndex == nil {
+		return nil, errNoJoinabilityIndex
+	}
+	targets, err := s.db.ValueCorrelationSketches(columnID)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	// The most correlated result of each column, by column ID.
+	byColumn := make(map[string]*correlationResult)
+	var ids []string
+
+	for _, target := range targets {
+		queryKey, err := s.db.ColumnSketch(target.KeyColumnID)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := s.joinabilityIndex.Joinable(queryKey, s.joinabilityThreshold)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if key.DatasetID == target.DatasetID {
+				continue
+			}
+			sketches, err := s.db.KeyCorrelationSketches(key.ColumnID)
+			if err != nil {
+				return nil, err
+			}
+			for _, sketch := range sketches {
+				r, n := corrsketch.Correlation(target.Sketch, sketch.Sketch)
+				if n < minCorrelationKeys {
+					continue
+				}
+				best := byColumn[sketch.ValueColumnID]
+				if best == nil {
+					ids = append(ids, sketch.ValueColumnID)
+				} else if math.Abs(best.Correlation) >= math.Abs(r) {
+					continue
+				}
+				byColumn[sketch.ValueColumnID] = &correlationResult{
+					QueryKey: queryKey, KeyColumn: key.ColumnSketch, Correlation: r, Keys: n,
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return math.Abs(byColumn[ids[i]].Correlation) > math.Abs(byColumn[ids[j]].Correlation)
+	})
+	if int64(len(ids)) > s.similarResults {
+		ids = ids[:s.similarResults]
+	}
+	results := make([]*correlationResult, len(ids))
+	names := make(map[string]string)
+
+	for i, id := range ids {
+		r := byColumn[id]
+		if r.Column, err = s.db.ColumnSketch(id); err != nil {
+			return nil, err
+		}
+		if r.DatasetName, err = s.datasetName(names, r.Column.DatasetID); err != nil {
+			return nil, err
+		}
+		results[i] = r
+	}
+	return results, nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+)
+
+// Maximum number of entities of an entity search, and size of its request
+// body in bytes.
+const (
+	maxEntities        = 10000
+	maxEntityBodyBytes = 1 << 20
+)
+
+type entityResult struct {
+	*index.JoinCandidate
+	DatasetName string
+}
+
+// entitySearch returns the datasets with a categorical column containing at
+// least the fraction threshold of the entities (see
+// index.JoinabilityIndex.EntityColumns), with the column containing the most
+// of them, sorted by containment.
+//
+// Returns errNoJoinabilityIndex if the joinability index is not built.
+func (s *Server) entitySearch(entities []string, threshold float64) ([]*entityResult, error) {
+	if s.joinabilityIndex == nil {
+		return nil, errNoJoinabilityIndex
+	}
+	columns, err := s.joinabilityIndex.EntityColumns(entities, threshold)
+	if err != nil {
+		return nil, err
+	}
+	results := []*entityResult{}
+	added := make(map[string]bool)
+
+	for _, c := range columns {
+		if added[c.DatasetID] {
+			continue
+		}
+		added[c.DatasetID] = true
+		datasetName, err := s.db.DatasetName(c.DatasetID)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &entityResult{c, datasetName})
+		if int64(len(results)) == s.searchResults {
+			break
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,51 @@
+//go:build go1.18
+// +build go1.18
+
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+func FuzzDecodeV1MetadataPatch(f *testing.F) {
+	f.Add(`{"name": "Corrected name", "description": "Corrected description"}`)
+	f.Add(`{"categories": ["Transportation", "Health"], "tags": []}`)
+	f.Add(`{"tags": null}`)
+	f.Add(`{"name": 1}`)
+	f.Add(`[]`)
+	f.Fuzz(func(t *testing.T, body string) {
+		patch, err := decodeV1MetadataPatch(strings.NewReader(body))
+		if err != nil {
+			return
+		}
+		m := &database.Metadata{DatasetID: "abcd-1234", Categories: []string{"c"}}
+		patch.apply(m)
+
+		// Categories must survive being stored comma-separated.
+		if len(m.Categories) > 0 {
+			got := strings.Split(strings.Join(m.Categories, ","), ",")
+			if !reflect.DeepEqual(got, m.Categories) {
+				t.Errorf("categories %q stored as %q", m.Categories, got)
+			}
+		}
+	})
+}
+
+func FuzzParseMinSimilarity(f *testing.F) {
+	for _, v := range []string{"0.8", "-1", "1", "1.5", "NaN", "Inf", "0x1p-2", ""} {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, v string) {
+		minSim, err := parseMinSimilarity(v)
+		if err != nil {
+			return
+		}
+		if !(minSim >= -1 && minSim <= 1) {
+			t.Errorf("parseMinSimilarity(%q) = %v, want a value between -1 and 1", v, minSim)
+		}
+	})
+}
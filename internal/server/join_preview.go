@@ -0,0 +1,140 @@
+package server
+
+import (
+	"database/sql"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/lazo"
+)
+
+// Default and maximum numbers of joined rows in a join preview.
+const (
+	defaultPreviewRows = 10
+	maxPreviewRows     = 100
+)
+
+// joinPreview is the inner join of the sample rows of two datasets on a column
+// of each.
+type joinPreview struct {
+	// The joined columns.
+	Left, Right *database.ColumnSketch
+	// All columns of the two datasets, in order.
+	LeftColumns, RightColumns []*database.ColumnSketch
+	// Joined rows, each with the values of LeftColumns and RightColumns.
+	Rows []*joinedRow
+	// Estimated fraction of the distinct values of Left that are values of
+	// Right (see lazo.Overlap).
+	MatchRate float64
+	// Fraction of the sample rows of the left dataset with a non-blank key
+	// that match a sample row of the right dataset.
+	SampleMatchRate float64
+}
+
+type joinedRow struct {
+	Left, Right []string
+}
+
+// joinPreview joins the sample rows of the datasets of the columns with the
+// given IDs on those columns, returning at most limit joined rows.
+//
+// The sample rows are the first rows of the datasets, made of the samples of
+// their columns (see sketch_columns), so the preview shows the shape of the
+// joined table; the match rate estimated from the column sketches is a better
+// guide to how many rows join than the sample matches.
+//
+// Returns sql.ErrNoRows if either column does not exist.
+func (s *Server) joinPreview(leftID, rightID string, limit int) (*joinPreview, error) {
+	p := new(joinPreview)
+	var err error
+	if p.Left, p.LeftColumns, err = s.columnWithDataset(leftID); err != nil {
+		return nil, err
+	}
+	if p.Right, p.RightColumns, err = s.columnWithDataset(rightID); err != nil {
+		return nil, err
+	}
+	p.MatchRate = lazo.Overlap(
+		p.Left.Minhash, p.Right.Minhash, p.Left.DistinctCount, p.Right.DistinctCount).Containment
+
+	leftRows, rightRows := sampleRows(p.LeftColumns), sampleRows(p.RightColumns)
+	leftKey, rightKey := columnIndex(p.LeftColumns, leftID), columnIndex(p.RightColumns, rightID)
+
+	// Sample rows of the right dataset by key.
+	byKey := make(map[string][][]string)
+	for _, row := range rightRows {
+		if k := row[rightKey]; k != "" {
+			byKey[k] = append(byKey[k], row)
+		}
+	}
+	p.Rows = []*joinedRow{}
+	var keyed, matched int
+
+	for _, row := range leftRows {
+		k := row[leftKey]
+		if k == "" {
+			continue
+		}
+		keyed++
+		if len(byKey[k]) > 0 {
+			matched++
+		}
+		for _, other := range byKey[k] {
+			if len(p.Rows) < limit {
+				p.Rows = append(p.Rows, &joinedRow{row, other})
+			}
+		}
+	}
+	if keyed > 0 {
+		p.SampleMatchRate = float64(matched) / float64(keyed)
+	}
+	return p, nil
+}
+
+// columnWithDataset returns the sketch of the column with the given ID and the
+// sketches of all the columns of its dataset, as shown in data previews (see
+// datasetColumns).
+func (s *Server) columnWithDataset(columnID string) (*database.ColumnSketch, []*database.ColumnSketch, error) {
+	c, err := s.db.ColumnSketch(columnID)
+	if err != nil {
+		return nil, nil, err
+	}
+	cols, _, err := s.datasetColumns(c.DatasetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	i := columnIndex(cols, columnID)
+	if i < 0 {
+		return nil, nil, sql.ErrNoRows
+	}
+	return cols[i], cols, nil
+}
+
+// columnIndex returns the index of the column with the given ID in cols, or -1.
+func columnIndex(cols []*database.ColumnSketch, columnID string) int {
+	for i, c := range cols {
+		if c.ColumnID == columnID {
+			return i
+		}
+	}
+	return -1
+}
+
+// sampleRows returns the rows made of the samples of the columns of a dataset,
+// which are the values of its first rows.
+func sampleRows(cols []*database.ColumnSketch) [][]string {
+	n := 0
+	for _, c := range cols {
+		if len(c.Sample) > n {
+			n = len(c.Sample)
+		}
+	}
+	rows := make([][]string, n)
+	for i := range rows {
+		rows[i] = make([]string, len(cols))
+		for j, c := range cols {
+			if i < len(c.Sample) {
+				rows[i][j] = c.Sample[i]
+			}
+		}
+	}
+	return rows
+}
@@ -1,50 +1,45 @@
 package server
 
 import (
-	"sort"
+	"errors"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/attributeembedding"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
-	"github.com/ekzhu/lshensemble"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+)
+
+var (
+	errNoSemanticJoin     = errors.New("attribute embedding index is not built")
+	errNoJoinabilityIndex = errors.New("joinability index is not built")
 )
 
 type joinabilityResult struct {
-	*database.ColumnSketch
+	*index.JoinCandidate
 	DatasetName string
-	Containment float64
+	// Name similarity and score of the results of semantic searches.
+	NameSimilarity float32
+	Score          float64
 }
 
-func (s *Server) joinableColumns(query *database.ColumnSketch) ([]*joinabilityResult, error) {
-	done := make(chan struct{})
-	defer close(done)
-	resultKeys := s.joinabilityIndex.Query(
-		query.Minhash, query.DistinctCount, s.joinabilityThreshold, done)
-
-	results := make([]*joinabilityResult, 0, len(resultKeys))
-
-	for key := range resultKeys {
-		colID := key.(string)
-		if colID == query.ColumnID {
-			continue
-		}
-		res, err := s.db.ColumnSketch(colID)
-		if err != nil {
-			return nil, err
-		}
-		containment := lshensemble.Containment(
-			query.Minhash, res.Minhash, query.DistinctCount, res.DistinctCount)
-		if containment < s.joinabilityThreshold {
-			continue
-		}
-		datasetName, err := s.db.DatasetName(res.DatasetID)
+// joinableColumns returns the columns joinable with query with a containment
+// of at least threshold, sorted by containment with a preference for candidate
+// keys (see index.JoinabilityIndex.Joinable).
+// If organize is true, the navigation organization is built over the datasets
+// of the 50 best results.
+func (s *Server) joinableColumns(query *database.ColumnSketch, threshold float64, organize bool) ([]*joinabilityResult, error) {
+	candidates, err := s.joinabilityIndex.Joinable(query, threshold)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*joinabilityResult, len(candidates))
+	for i, c := range candidates {
+		datasetName, err := s.db.DatasetName(c.DatasetID)
 		if err != nil {
 			return nil, err
 		}
-		results = append(results, &joinabilityResult{res, datasetName, containment})
+		results[i] = &joinabilityResult{JoinCandidate: c, DatasetName: datasetName}
 	}
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].Containment > results[j].Containment
-	})
-	if len(results) == 0 {
+	if !organize || len(results) == 0 {
 		return results, nil
 	}
 
@@ -69,3 +64,56 @@ func (s *Server) joinableColumns(query *database.ColumnSketch) ([]*joinabilityRe
 	}
 	return results, nil
 }
+
+// semanticJoinableColumns returns the columns joinable with query by value
+// overlap or name similarity (see index.SemanticJoinSearcher), sorted by
+// score.
+//
+// Returns errNoSemanticJoin if the attribute embedding index is not built.
+func (s *Server) semanticJoinableColumns(query *database.ColumnSketch, threshold float64) ([]*joinabilityResult, error) {
+	if s.semanticJoin == nil {
+		return nil, errNoSemanticJoin
+	}
+	candidates, err := s.semanticJoin.Search(query, threshold)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*joinabilityResult, len(candidates))
+	for i, c := range candidates {
+		datasetName, err := s.db.DatasetName(c.DatasetID)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = &joinabilityResult{c.JoinCandidate, datasetName, c.NameSimilarity, c.Score}
+	}
+	return results, nil
+}
+
+// explainJoins returns the explanations of the results of a joinable column
+// search for query (see index.JoinabilityIndex.Explain). The name similarities
+// of the results of value searches are computed with the attribute tokenizer.
+func (s *Server) explainJoins(query *database.ColumnSketch, results []*joinabilityResult, semantic bool) ([]*index.JoinExplanation, error) {
+	tokenizer := s.attributeTokenizer
+	if tokenizer == nil {
+		tokenizer = attributeembedding.NewTokenizer(attributeembedding.DefaultAbbreviations)
+	}
+	// Name similarities by column name, as many columns share names.
+	nameSims := make(map[string]float32)
+	explanations := make([]*index.JoinExplanation, len(results))
+
+	for i, r := range results {
+		sim := r.NameSimilarity
+		if !semantic {
+			var ok bool
+			if sim, ok = nameSims[r.ColumnName]; !ok {
+				var err error
+				if sim, err = tokenizer.Similarity(s.embedder, query.ColumnName, r.ColumnName); err != nil {
+					return nil, err
+				}
+				nameSims[r.ColumnName] = sim
+			}
+		}
+		explanations[i] = s.joinabilityIndex.Explain(query, r.JoinCandidate, sim)
+	}
+	return explanations, nil
+}
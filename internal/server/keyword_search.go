@@ -1,7 +1,9 @@
 package server
 
 import (
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coldomain"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
 )
 
@@ -10,10 +12,11 @@ import (
 // It first tries a semantic search using the metadata embedding index and falls
 // back to an exact text search if none of the query words are found in the
 // fastText DB.
-// For semantic search, the 50 closest matches are returned.
+// For semantic search, the closest matches (50 by default) are returned, and if organize is
+// true, the navigation organization is built over them.
 // Text search returns all matches.
-func (s *Server) keywordSearch(query string) ([]*database.Metadata, error) {
-	vec, err := wordemb.Vector(s.ft, []string{query})
+func (s *Server) keywordSearch(query string, organize bool) ([]*database.Metadata, error) {
+	vec, err := s.queryVector(query)
 	if err != nil {
 		if err == wordemb.ErrNoEmb {
 			return s.textSearch(query)
@@ -21,7 +24,7 @@ func (s *Server) keywordSearch(query string) ([]*database.Metadata, error) {
 		return nil, err
 	}
 
-	ids, _, err := s.metadataIndex.Query(vec, 50)
+	ids, _, err := s.metadataIndex.Query(vec, s.searchResults)
 	if err != nil {
 		return nil, err
 	}
@@ -35,12 +38,104 @@ func (s *Server) keywordSearch(query string) ([]*database.Metadata, error) {
 		results = append(results, meta)
 	}
 
-	if err := s.buildOrganization(query, ids); err != nil {
+	if organize {
+		if err := s.buildOrganization(query, ids); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// queryVector returns the vector of a query for the metadata index: its
+// embeddings by every model if there are category models.
+func (s *Server) queryVector(query string) ([]float32, error) {
+	if s.router != nil {
+		return s.router.EmbedRouted(query)
+	}
+	return wordemb.Vector(s.embedder, []string{query})
+}
+
+// keywordSearchBatch performs a keyword search for each of the queries, without
+// building the navigation organization.
+//
+// The semantic searches are issued as a single batched query to the metadata
+// index when it supports batch queries.
+func (s *Server) keywordSearchBatch(queries []string) ([][]*database.Metadata, error) {
+	results := make([][]*database.Metadata, len(queries))
+	// Indexes into queries of the semantic searches.
+	var semantic []int
+	var vecs []float32
+
+	for i, query := range queries {
+		vec, err := s.queryVector(query)
+		if err != nil {
+			if err != wordemb.ErrNoEmb {
+				return nil, err
+			}
+			if results[i], err = s.textSearch(query); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		semantic = append(semantic, i)
+		vecs = append(vecs, vec...)
+	}
+	if len(semantic) == 0 {
+		return results, nil
+	}
+
+	ids, err := s.queryBatch(vecs, len(semantic), s.searchResults)
+	if err != nil {
 		return nil, err
 	}
+	for j, i := range semantic {
+		for _, id := range ids[j] {
+			meta, err := s.db.Metadata(id)
+			if err != nil {
+				return nil, err
+			}
+			results[i] = append(results[i], meta)
+		}
+	}
 	return results, nil
 }
 
+// queryBatch queries the metadata index with each of the n concatenated query
+// vectors in vecs, falling back to one query per vector if the index does not
+// support batch queries.
+func (s *Server) queryBatch(vecs []float32, n int, k int64) ([][]string, error) {
+	if q, ok := s.metadataIndex.(index.BatchQuerier); ok {
+		ids, _, err := q.QueryBatch(vecs, k)
+		return ids, err
+	}
+	dim := len(vecs) / n
+	ids := make([][]string, n)
+
+	for i := range ids {
+		var err error
+		ids[i], _, err = s.metadataIndex.Query(vecs[i*dim:(i+1)*dim], k)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// domainResults returns the results having a column in the domain, in order.
+func (s *Server) domainResults(results []*database.Metadata, domain coldomain.Domain) ([]*database.Metadata, error) {
+	datasets, err := s.db.DomainDatasets(domain)
+	if err != nil {
+		return nil, err
+	}
+	filtered := []*database.Metadata{}
+	for _, meta := range results {
+		if datasets[meta.DatasetID] {
+			filtered = append(filtered, meta)
+		}
+	}
+	return filtered, nil
+}
+
 func (s *Server) textSearch(query string) ([]*database.Metadata, error) {
 	rows, err := s.db.Query(`
 	SELECT dataset_id
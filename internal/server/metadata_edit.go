@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
+)
+
+// metadataPatch holds the metadata fields to change in a metadata edit.
+// Fields that are nil are left unchanged.
+type metadataPatch struct {
+	Name        *string
+	Description *string
+	Attribution *string
+	Categories  *[]string
+	Tags        *[]string
+}
+
+// validate returns an error if the patch cannot be stored.
+// Categories and tags are stored comma-separated, so they must not contain
+// commas.
+func (p *metadataPatch) validate() error {
+	for _, list := range []*[]string{p.Categories, p.Tags} {
+		if list == nil {
+			continue
+		}
+		for _, v := range *list {
+			if v == "" || strings.Contains(v, ",") {
+				return fmt.Errorf("invalid category or tag %q", v)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *metadataPatch) apply(m *database.Metadata) {
+	if p.Name != nil {
+		m.Name = *p.Name
+	}
+	if p.Description != nil {
+		m.Description = *p.Description
+	}
+	if p.Attribution != nil {
+		m.Attribution = *p.Attribution
+	}
+	if p.Categories != nil {
+		m.Categories = *p.Categories
+	}
+	if p.Tags != nil {
+		m.Tags = *p.Tags
+	}
+}
+
+// editMetadata applies patch to the metadata of a dataset, recomputes the
+// dataset's metadata embedding vector, with the model of its categories if
+// there are category models, and updates the metadata index.
+func (s *Server) editMetadata(datasetID string, patch *metadataPatch) (*database.Metadata, error) {
+	meta, err := s.db.Metadata(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	patch.apply(meta)
+
+	var model string
+	var e embedding.Embedder = s.embedder
+	version := s.embeddingVersion
+	if s.router != nil {
+		model, e = s.router.Route(meta.Categories)
+		v := s.router.Version(model)
+		version = &v
+	}
+	vec, embErr := wordemb.MetadataVector(e, meta)
+	if embErr != nil && embErr != wordemb.ErrNoEmb {
+		return nil, embErr
+	}
+	if err := s.db.UpdateMetadata(meta); err != nil {
+		return nil, err
+	}
+	var noEmb embedding.NoEmbCounts
+	vec, err = s.noEmbPolicy.Apply(vec, embErr, func(e embedding.Embedder) ([]float32, error) {
+		return wordemb.MetadataVector(e, meta)
+	}, &noEmb)
+	if err != nil {
+		return nil, err
+	}
+	if vec == nil {
+		if err := s.db.DeleteMetadataVector(datasetID); err != nil {
+			return nil, err
+		}
+	} else {
+		if s.router != nil {
+			if err := s.db.SetVectorModel(datasetID, model, len(vec)); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.db.SetMetadataVector(datasetID, vec); err != nil {
+			return nil, err
+		}
+		if v := version; v != nil {
+			if err := s.db.SetVectorVersion("metadata_vectors", datasetID, "", v.Model, v.Version, v.Dim); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if vec == nil || noEmb.Flagged > 0 {
+		// Zero vectors are not indexed.
+		r, ok := s.metadataIndex.(index.Remover)
+		if !ok {
+			return nil, errors.New("editMetadata: metadata index does not support removals")
+		}
+		if err := r.Remove(datasetID); err != nil {
+			return nil, err
+		}
+		return meta, nil
+	}
+	u, ok := s.metadataIndex.(index.Updater)
+	if !ok {
+		return nil, errors.New("editMetadata: metadata index does not support updates")
+	}
+	if s.router != nil {
+		vec = s.router.RoutedVector(model, vec)
+	}
+	if err := u.Update(datasetID, vec); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// authorizedEdit reports whether req carries the edit token.
+func (s *Server) authorizedEdit(req *http.Request) bool {
+	if s.editToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.editToken)) == 1
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/numsketch"
+)
+
+var errNoNumericIndex = errors.New("numeric column index is not built")
+
+type distributionResult struct {
+	*database.ColumnSketch
+	DatasetName string
+	Similarity  float64
+}
+
+// similarDistributions returns the numeric columns whose distributions are the
+// most similar to that of the column with the given ID by the metric m, sorted
+// by similarity.
+//
+// Returns sql.ErrNoRows if the column has no numeric sketch.
+func (s *Server) similarDistributions(columnID string, m numsketch.Metric) ([]*distributionResult, error) {
+	if s.numericIndex == nil {
+		return nil, errNoNumericIndex
+	}
+	query, err := s.db.NumericSketch(columnID)
+	if err != nil {
+		return nil, err
+	}
+	// The query column is among the results.
+	ids, sims := s.numericIndex.QueryMetric(query, int(s.similarResults)+1, m)
+
+	results := make([]*distributionResult, 0, len(ids))
+	for i, id := range ids {
+		if id == columnID {
+			continue
+		}
+		col, err := s.db.ColumnSketch(id)
+		if err != nil {
+			return nil, err
+		}
+		datasetName, err := s.db.DatasetName(col.DatasetID)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &distributionResult{col, datasetName, sims[i]})
+	}
+	if int64(len(results)) > s.similarResults {
+		results = results[:s.similarResults]
+	}
+	return results, nil
+}
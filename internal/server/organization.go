@@ -14,7 +14,7 @@ func (s *Server) buildOrganization(name string, datasetIDs []string) error {
 	start := time.Now()
 	var err error
 	s.organization, err = navigation.BuildOrganization(
-		s.db, s.ft, s.organizationConfig, datasetIDs)
+		s.db, s.embedder, s.organizationConfig, datasetIDs)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/pii"
+)
+
+// datasetProfiles returns the profile of a dataset and of its columns, or nil
+// and no columns if it has not been profiled. If the server hides sensitive
+// samples, the most frequent values of the sensitive columns are left out.
+func (s *Server) datasetProfiles(datasetID string, sensitive map[string][]pii.Kind) (*database.DatasetProfile, []*database.ColumnProfile, error) {
+	p, err := s.db.DatasetProfile(datasetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	cols, err := s.db.DatasetColumnProfiles(datasetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.hideSensitive {
+		for _, c := range cols {
+			if len(sensitive[c.ColumnID]) > 0 {
+				c.TopValues = nil
+			}
+		}
+	}
+	return p, cols, nil
+}
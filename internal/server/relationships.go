@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+var errNoRelationships = errors.New("relationships are not inferred")
+
+type relationshipResult struct {
+	*database.Relationship
+	// Sketches of the referencing and the referenced column.
+	Column, KeyColumn *database.ColumnSketch
+	// Names of the datasets of the two columns.
+	DatasetName, KeyDatasetName string
+}
+
+// relationships returns the inferred foreign key relationships in which a
+// column of the dataset with the given ID is the referencing or the referenced
+// column (see infer_relationships), by decreasing containment.
+//
+// Returns errNoRelationships if the database has no relationships table.
+func (s *Server) relationships(datasetID string) ([]*relationshipResult, error) {
+	if ok, err := database.HasTable(s.db, "relationships"); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errNoRelationships
+	}
+	rels, err := s.db.Relationships(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*relationshipResult, len(rels))
+	names := make(map[string]string)
+
+	for i, r := range rels {
+		res := &relationshipResult{Relationship: r}
+		if res.Column, err = s.db.ColumnSketch(r.ColumnID); err != nil {
+			return nil, err
+		}
+		if res.KeyColumn, err = s.db.ColumnSketch(r.KeyColumnID); err != nil {
+			return nil, err
+		}
+		if res.DatasetName, err = s.datasetName(names, r.DatasetID); err != nil {
+			return nil, err
+		}
+		if res.KeyDatasetName, err = s.datasetName(names, r.KeyDatasetID); err != nil {
+			return nil, err
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+// datasetName returns the name of a dataset, caching it in names.
+func (s *Server) datasetName(names map[string]string, datasetID string) (string, error) {
+	if name, ok := names[datasetID]; ok {
+		return name, nil
+	}
+	name, err := s.db.DatasetName(datasetID)
+	if err != nil {
+		return "", err
+	}
+	names[datasetID] = name
+	return name, nil
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/pii"
+)
+
+// datasetColumns returns the column sketches of a dataset, as shown in data
+// previews, and the kinds of personal information of its sensitive columns by
+// column ID (see package pii). If the server hides sensitive samples, the
+// sample values of the sensitive columns are blanked by the database.
+func (s *Server) datasetColumns(datasetID string) ([]*database.ColumnSketch, map[string][]pii.Kind, error) {
+	cols, err := s.db.DatasetColumns(datasetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	sensitive, err := s.db.DatasetSensitiveColumns(datasetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cols, sensitive, nil
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	"github.com/ekzhu/lshensemble"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testEmbedder embeds every text as the same vector.
+type testEmbedder struct{}
+
+func (testEmbedder) Embed(string) ([]float32, error) { return []float32{1, 0}, nil }
+
+func (testEmbedder) Dim() int { return 2 }
+
+func TestHideSensitiveJoinableColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := database.New(filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, file := range []string{
+		"create_metadata_tables.sql",
+		"create_column_sketches_table.sql",
+		"create_column_types_table.sql",
+		"create_column_uniqueness_table.sql",
+		"create_column_domains_table.sql",
+		"create_sensitive_columns_table.sql",
+	} {
+		schema, err := ioutil.ReadFile("../../sql/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(schema)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two datasets of the same emails, in columns flagged as sensitive.
+	emails := []string{"ann@example.org", "bob@example.org", "cy@example.org"}
+	mh := lshensemble.NewMinhash(42, 256)
+	for _, e := range emails {
+		mh.Push([]byte(e))
+	}
+	sample, err := json.Marshal(emails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"aaaa-1111", "bbbb-2222"} {
+		_, err := db.Exec(`
+		INSERT INTO metadata VALUES (?, 'Contacts', '', '', '', '', '', '', '')`, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(`
+		INSERT INTO column_sketches VALUES (?, ?, 'email', ?, ?, ?)`,
+			id+"-0", id, len(emails), lshensemble.SigToBytes(mh.Signature()), sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(`INSERT INTO sensitive_columns VALUES (?, ?, 'email')`, id+"-0", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx, err := index.BuildJoinabilityIndex(db, 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: db, embedder: testEmbedder{}, joinabilityIndex: idx, joinabilityThreshold: 0.5}
+
+	for _, hide := range []bool{false, true} {
+		db.HideSensitiveSamples = hide
+		w := httptest.NewRecorder()
+		s.handleV1JoinableColumns(w, httptest.NewRequest("GET", "/api/v1/joinable-columns?id=aaaa-1111-0", nil))
+		if w.Code != 200 {
+			t.Fatalf("got status %d: %s", w.Code, w.Body)
+		}
+		var res v1JoinableColumnsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Results) != 1 || res.Results[0].Column.ID != "bbbb-2222-0" {
+			t.Fatalf("got results %s, want the column of bbbb-2222", w.Body)
+		}
+		if got := strings.Contains(w.Body.String(), "@example.org"); got == hide {
+			t.Errorf("hiding %v: got samples in the response %v: %s", hide, got, w.Body)
+		}
+	}
+}
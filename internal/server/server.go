@@ -14,26 +14,44 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/attributeembedding"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	nav "github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
-	"github.com/ekzhu/go-fasttext"
-	"github.com/ekzhu/lshensemble"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/pii"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/schemamatch"
 )
 
 // Server serves the Open Data Link frontend.
 type Server struct {
 	devMode              bool
 	db                   *database.DB
-	ft                   *fasttext.FastText
-	metadataIndex        *index.MetadataIndex
+	embedder             embedding.Embedder
+	router               *embedding.Router
+	embeddingVersion     *embedding.Version
+	noEmbPolicy          embedding.NoEmbPolicy
+	metadataIndex        index.Querier
 	joinabilityThreshold float64
-	joinabilityIndex     *lshensemble.LshEnsemble
+	joinabilityIndex     *index.JoinabilityIndex
+	numericIndex         *index.NumericIndex
+	semanticJoin         *index.SemanticJoinSearcher
+	schemaUnion          *index.UnionabilitySearcher
+	attributeTokenizer   *attributeembedding.Tokenizer
+	schemaMatcher        *index.SchemaMatcher
 	mux                  sync.Mutex // Guards access to templates
 	templates            map[string]*template.Template
 	organization         *nav.TableGraph
 	organizationConfig   *nav.Config
 	organizationGraphSVG []byte
+	editToken            string
+	adminToken           string
+	corsOrigins          []string
+	widget               *template.Template
+	baseURL              string
+	searchResults        int64
+	similarResults       int64
+	hideSensitive        bool
 }
 
 // Config is used to configure the server.
@@ -41,11 +59,60 @@ type Config struct {
 	// If DevMode is true, templates will not be cached.
 	DevMode              bool
 	DB                   *database.DB
-	FastText             *fasttext.FastText
-	MetadataIndex        *index.MetadataIndex
+	Embedder             embedding.Embedder
+	MetadataIndex        index.Querier
 	JoinabilityThreshold float64
-	JoinabilityIndex     *lshensemble.LshEnsemble
+	JoinabilityIndex     *index.JoinabilityIndex
 	OrganizeConfig       *nav.Config
+	// Bearer token required to edit metadata. If empty, edits are disabled.
+	EditToken string
+	// Bearer token required to use the admin endpoints. If empty, they are
+	// disabled.
+	AdminToken string
+	// Origins allowed to make cross-origin requests to the API and widget and
+	// to embed the widget. "*" allows all origins.
+	CORSOrigins []string
+	// Base URL of the deployment used in sitemaps, e.g.
+	// "https://opendatalink.example.org". If empty, it is derived from the
+	// request.
+	BaseURL string
+	// Number of results of keyword searches. If zero, 50 results are returned.
+	SearchResults int
+	// Number of results of similar dataset searches. If zero, 20 results are
+	// returned.
+	SimilarResults int
+	// Version of the vectors of Embedder, recorded with the vectors of
+	// edited metadata. If nil, no version is recorded.
+	EmbeddingVersion *embedding.Version
+	// Router of the category models, if any. Queries are then embedded with
+	// every model, and edited metadata with the model of its categories,
+	// whose version is recorded instead of EmbeddingVersion. MetadataIndex
+	// must be a routed index (see index.BuildRoutedMetadataIndex).
+	Router *embedding.Router
+	// Index of the numeric column sketches. If nil, similar distribution
+	// searches are disabled.
+	NumericIndex *index.NumericIndex
+	// Handling of edited metadata without embedding. If empty, no vector is
+	// stored for it.
+	NoEmbPolicy embedding.NoEmbPolicy
+	// Index of the attribute embeddings of Embedder. If nil, semantic
+	// joinable column and schema unionable table searches are disabled.
+	AttributeIndex *index.AttributeIndex
+	// Configuration of semantic joinable column searches. If nil,
+	// index.DefaultSemanticJoinConfig is used.
+	SemanticJoinConfig *index.SemanticJoinConfig
+	// Tokenizer of the column names compared in join explanations. If nil,
+	// the default abbreviations are expanded.
+	AttributeTokenizer *attributeembedding.Tokenizer
+	// Configuration of schema matching. If nil, schemamatch.DefaultConfig is
+	// used.
+	SchemaMatchConfig *schemamatch.Config
+	// If HideSensitiveSamples is true, the sample values of the columns
+	// flagged as holding personal information (see package pii) are left
+	// out of every response, and of the join explanations. DB, which the
+	// indexes should share, then hides them. Otherwise the columns are only
+	// marked.
+	HideSensitiveSamples bool
 }
 
 // New creates a new Server with the given configuration.
@@ -54,15 +121,54 @@ func New(cfg *Config) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	widget, err := template.ParseFiles("web/template/widget.html")
+	if err != nil {
+		return nil, err
+	}
+	searchResults, similarResults := cfg.SearchResults, cfg.SimilarResults
+	if searchResults == 0 {
+		searchResults = 50
+	}
+	if similarResults == 0 {
+		similarResults = 20
+	}
+	if cfg.HideSensitiveSamples {
+		cfg.DB.HideSensitiveSamples = true
+	}
+	var semanticJoin *index.SemanticJoinSearcher
+	if cfg.JoinabilityIndex != nil && cfg.AttributeIndex != nil {
+		semanticJoin = index.NewSemanticJoinSearcher(
+			cfg.JoinabilityIndex, cfg.AttributeIndex, cfg.Embedder, cfg.SemanticJoinConfig)
+	}
+	var schemaUnion *index.UnionabilitySearcher
+	if cfg.AttributeIndex != nil {
+		schemaUnion = index.NewUnionabilitySearcher(cfg.AttributeIndex, cfg.DB)
+	}
 	return &Server{
 		devMode:              cfg.DevMode,
 		db:                   cfg.DB,
-		ft:                   cfg.FastText,
+		embedder:             cfg.Embedder,
+		router:               cfg.Router,
+		embeddingVersion:     cfg.EmbeddingVersion,
+		noEmbPolicy:          cfg.NoEmbPolicy,
 		templates:            templates,
 		metadataIndex:        cfg.MetadataIndex,
 		joinabilityThreshold: cfg.JoinabilityThreshold,
 		joinabilityIndex:     cfg.JoinabilityIndex,
+		numericIndex:         cfg.NumericIndex,
+		semanticJoin:         semanticJoin,
+		schemaUnion:          schemaUnion,
+		attributeTokenizer:   cfg.AttributeTokenizer,
+		schemaMatcher:        index.NewSchemaMatcher(cfg.DB, cfg.SchemaMatchConfig),
 		organizationConfig:   cfg.OrganizeConfig,
+		editToken:            cfg.EditToken,
+		adminToken:           cfg.AdminToken,
+		corsOrigins:          cfg.CORSOrigins,
+		widget:               widget,
+		baseURL:              strings.TrimSuffix(cfg.BaseURL, "/"),
+		searchResults:        int64(searchResults),
+		similarResults:       int64(similarResults),
+		hideSensitive:        cfg.HideSensitiveSamples,
 	}, nil
 }
 
@@ -77,6 +183,16 @@ func (s *Server) NewHandler() http.Handler {
 	mux.HandleFunc("/unionable-tables", s.handleUnionableTables)
 	mux.HandleFunc("/navigation/", s.handleNav)
 	mux.HandleFunc("/navigation-graph", s.handleNavGraph)
+	mux.HandleFunc("/robots.txt", s.handleRobots)
+	mux.HandleFunc("/sitemap.xml", s.handleSitemapIndex)
+	mux.HandleFunc("/sitemaps/", s.handleSitemap)
+	mux.HandleFunc("/admin/indexes", s.handleAdminIndexes)
+
+	api := http.NewServeMux()
+	s.installAPI(api)
+	api.HandleFunc("/widget/search", s.handleWidgetSearch)
+	mux.Handle("/api/", corsHandler(s.corsOrigins, api))
+	mux.Handle("/widget/", corsHandler(s.corsOrigins, api))
 
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
 
@@ -121,7 +237,12 @@ func (s *Server) handleDataset(w http.ResponseWriter, req *http.Request) {
 		}
 		return
 	}
-	cols, err := s.db.DatasetColumns(datasetID)
+	cols, sensitive, err := s.datasetColumns(datasetID)
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	profile, profiles, err := s.datasetProfiles(datasetID, sensitive)
 	if err != nil {
 		s.serverError(w, err)
 		return
@@ -130,17 +251,28 @@ func (s *Server) handleDataset(w http.ResponseWriter, req *http.Request) {
 		PageTitle string
 		*database.Metadata
 		Columns []*database.ColumnSketch
+		// Kinds of personal information of the sensitive columns by column
+		// ID, and whether their sample values are hidden.
+		Sensitive     map[string][]pii.Kind
+		HideSensitive bool
+		// Profiles of the dataset and its columns (see cmd/profile).
+		Profile        *database.DatasetProfile
+		ColumnProfiles []*database.ColumnProfile
 	}{
 		meta.Name + " - Open Data Link",
 		meta,
 		cols,
+		sensitive,
+		s.hideSensitive,
+		profile,
+		profiles,
 	})
 }
 
 func (s *Server) handleSearch(w http.ResponseWriter, req *http.Request) {
 	query := req.FormValue("q")
 	s.organization = nil
-	results, err := s.keywordSearch(query)
+	results, err := s.keywordSearch(query, true)
 	if err != nil {
 		s.serverError(w, err)
 		return
@@ -196,7 +328,7 @@ func (s *Server) handleJoinableColumns(w http.ResponseWriter, req *http.Request)
 		}
 		return
 	}
-	results, err := s.joinableColumns(query)
+	results, err := s.joinableColumns(query, s.joinabilityThreshold, true)
 	if err != nil {
 		s.serverError(w, err)
 		return
@@ -316,6 +448,15 @@ func parseTemplates() (map[string]*template.Template, error) {
 			"commaseparate": func(words []string) string {
 				return strings.Join(words, ", ")
 			},
+			"percent": func(x float64) string {
+				return strconv.FormatFloat(100*x, 'f', 1, 64) + "%"
+			},
+			"number": func(x *float64) string {
+				if x == nil {
+					return ""
+				}
+				return strconv.FormatFloat(*x, 'g', 6, 64)
+			},
 		}).ParseFiles("web/template/base.html", "web/template/"+page+".html")
 		if err != nil {
 			return nil, err
@@ -1,18 +1,72 @@
 package server
 
-import "github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+import (
+	"errors"
 
-// similarDatasets returns the metadata of the 20 datasets most similar to the
-// query.
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+)
+
+var errNoThreshold = errors.New("metadata index does not support similarity thresholds")
+
+// similarDatasets returns the metadata of the datasets most similar to the query
+// (20 by default).
 func (s *Server) similarDatasets(datasetID string) ([]*database.Metadata, error) {
-	vec, err := s.db.MetadataVector(datasetID)
+	vec, err := s.datasetVector(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	// The query dataset is usually its own nearest neighbor.
+	ids, _, err := s.metadataIndex.Query(vec, s.similarResults+1)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.datasetsExcept(ids, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(results)) > s.similarResults {
+		results = results[:s.similarResults]
+	}
+	return results, nil
+}
+
+// similarDatasetsAbove returns the metadata of all datasets whose similarity to
+// the query is greater than minSim, sorted by similarity.
+func (s *Server) similarDatasetsAbove(datasetID string, minSim float32) ([]*database.Metadata, error) {
+	q, ok := s.metadataIndex.(index.RangeQuerier)
+	if !ok {
+		return nil, errNoThreshold
+	}
+	vec, err := s.datasetVector(datasetID)
 	if err != nil {
 		return nil, err
 	}
-	ids, _, err := s.metadataIndex.Query(vec, 21)
+	ids, _, err := q.QueryThreshold(vec, minSim)
 	if err != nil {
 		return nil, err
 	}
+	return s.datasetsExcept(ids, datasetID)
+}
+
+// datasetVector returns the metadata vector of a dataset for querying the
+// metadata index. Only the datasets embedded with the same model are
+// comparable, so with category models the vector queries only those.
+func (s *Server) datasetVector(datasetID string) ([]float32, error) {
+	vec, err := s.db.MetadataVector(datasetID)
+	if err != nil || s.router == nil {
+		return vec, err
+	}
+	model, err := s.db.VectorModel(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	return s.router.RoutedVector(model, vec), nil
+}
+
+// datasetsExcept returns the metadata of the datasets in ids other than
+// datasetID.
+func (s *Server) datasetsExcept(ids []string, datasetID string) ([]*database.Metadata, error) {
 	var results []*database.Metadata
 
 	for _, id := range ids {
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Maximum number of URLs in a sitemap file, set by the sitemap protocol.
+const sitemapSize = 50000
+
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	NS       string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// siteURL returns the base URL of the deployment, used for the absolute URLs in
+// sitemaps.
+func (s *Server) siteURL(req *http.Request) string {
+	if s.baseURL != "" {
+		return s.baseURL
+	}
+	return baseURL(req)
+}
+
+// handleRobots serves robots.txt, pointing crawlers to the sitemap.
+func (s *Server) handleRobots(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "User-agent: *\nAllow: /\nSitemap: %s/sitemap.xml\n", s.siteURL(req))
+}
+
+// handleSitemapIndex serves the sitemap index, which lists one sitemap per
+// sitemapSize datasets.
+func (s *Server) handleSitemapIndex(w http.ResponseWriter, req *http.Request) {
+	var count int
+	if err := s.db.QueryRow(`SELECT count(*) FROM metadata`).Scan(&count); err != nil {
+		s.serverError(w, err)
+		return
+	}
+	index := &sitemapIndex{NS: sitemapNS}
+	base := s.siteURL(req)
+
+	for i := 0; i*sitemapSize < count; i++ {
+		index.Sitemaps = append(index.Sitemaps,
+			sitemapLoc{fmt.Sprintf("%s/sitemaps/sitemap-%d.xml.gz", base, i+1)})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(index); err != nil {
+		log.Print(err)
+	}
+}
+
+// handleSitemap serves /sitemaps/sitemap-<n>.xml.gz, the gzipped sitemap of the
+// nth batch of sitemapSize dataset pages.
+func (s *Server) handleSitemap(w http.ResponseWriter, req *http.Request) {
+	var n int
+	if _, err := fmt.Sscanf(req.URL.Path, "/sitemaps/sitemap-%d.xml.gz", &n); err != nil || n < 1 {
+		http.NotFound(w, req)
+		return
+	}
+	rows, err := s.db.Query(`
+	SELECT dataset_id, updated_at
+	FROM metadata
+	ORDER BY dataset_id
+	LIMIT ? OFFSET ?`, sitemapSize, (n-1)*sitemapSize)
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	set := &urlSet{NS: sitemapNS}
+	base := s.siteURL(req)
+
+	for rows.Next() {
+		var datasetID, updatedAt string
+		if err := rows.Scan(&datasetID, &updatedAt); err != nil {
+			s.serverError(w, err)
+			return
+		}
+		u := sitemapURL{Loc: base + "/dataset/" + url.PathEscape(datasetID)}
+		if t, err := time.Parse(time.RFC3339, updatedAt); err == nil {
+			u.LastMod = t.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, u)
+	}
+	if err := rows.Err(); err != nil {
+		s.serverError(w, err)
+		return
+	}
+	if len(set.URLs) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	gz := gzip.NewWriter(w)
+	gz.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(gz).Encode(set); err != nil {
+		log.Print(err)
+	}
+	if err := gz.Close(); err != nil {
+		log.Print(err)
+	}
+}
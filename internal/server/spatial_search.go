@@ -0,0 +1,112 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+var errNoSpatialColumns = errors.New("spatial columns are not profiled")
+
+type spatialResult struct {
+	// The spatial column of the query dataset and the column covering the
+	// most of it.
+	QueryColumn, Column *database.SpatialColumn
+	// The sketches of the query column and the column: of the latitude and
+	// the longitude column of spatial.LatLong columns.
+	QuerySketches, Sketches []*database.ColumnSketch
+	DatasetName             string
+	// Fraction of the extent of the query column that the column covers.
+	Overlap float64
+}
+
+// spatialSearch returns the datasets that are spatially joinable with the
+// dataset with the given ID: those with a spatial column covering the same
+// region as a spatial column of the dataset (see spatial.Extent.Overlap), with
+// the column covering the most of it, sorted by overlap.
+//
+// Returns errNoSpatialColumns if the database has no spatial_columns table,
+// and sql.ErrNoRows if the dataset has no spatial columns.
+func (s *Server) spatialSearch(datasetID string) ([]*spatialResult, error) {
+	if ok, err := database.HasTable(s.db, "spatial_columns"); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errNoSpatialColumns
+	}
+	queries, err := s.db.DatasetSpatialColumns(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	// The result covering the most of a query column of each dataset, by
+	// dataset ID.
+	byDataset := make(map[string]*spatialResult)
+	var ids []string
+
+	for _, q := range queries {
+		columns, err := s.db.SpatialColumns(&q.Extent)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range columns {
+			if c.DatasetID == datasetID {
+				continue
+			}
+			overlap := c.Overlap(&q.Extent)
+			if overlap == 0 {
+				continue
+			}
+			best := byDataset[c.DatasetID]
+			if best == nil {
+				ids = append(ids, c.DatasetID)
+			} else if best.Overlap >= overlap {
+				continue
+			}
+			byDataset[c.DatasetID] = &spatialResult{QueryColumn: q, Column: c, Overlap: overlap}
+		}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return byDataset[ids[i]].Overlap > byDataset[ids[j]].Overlap
+	})
+	if int64(len(ids)) > s.searchResults {
+		ids = ids[:s.searchResults]
+	}
+	results := make([]*spatialResult, len(ids))
+
+	for i, id := range ids {
+		r := byDataset[id]
+		if r.QuerySketches, err = s.spatialColumnSketches(r.QueryColumn); err != nil {
+			return nil, err
+		}
+		if r.Sketches, err = s.spatialColumnSketches(r.Column); err != nil {
+			return nil, err
+		}
+		if r.DatasetName, err = s.db.DatasetName(id); err != nil {
+			return nil, err
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+// spatialColumnSketches returns the sketches of the column, or of the latitude
+// and the longitude columns, of c.
+func (s *Server) spatialColumnSketches(c *database.SpatialColumn) ([]*database.ColumnSketch, error) {
+	ids := []string{c.ColumnID}
+	if c.LongitudeColumnID != "" {
+		ids = append(ids, c.LongitudeColumnID)
+	}
+	sketches := make([]*database.ColumnSketch, len(ids))
+	for i, id := range ids {
+		var err error
+		if sketches[i], err = s.db.ColumnSketch(id); err != nil {
+			return nil, err
+		}
+	}
+	return sketches, nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/temporal"
+)
+
+var errNoTimeColumns = errors.New("time columns are not profiled")
+
+type temporalResult struct {
+	*database.TimeColumn
+	Column      *database.ColumnSketch
+	DatasetName string
+	// Fraction of the query range the column covers.
+	Overlap float64
+}
+
+// temporalSearch returns the datasets with a time column overlapping the range
+// from start to end whose times roll up to the granularity g, with the column
+// covering the most of the range, sorted by overlap. The datasets in exclude
+// are left out.
+//
+// Returns errNoTimeColumns if the database has no time_columns table.
+func (s *Server) temporalSearch(start, end time.Time, g temporal.Granularity, exclude string) ([]*temporalResult, error) {
+	if ok, err := database.HasTable(s.db, "time_columns"); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errNoTimeColumns
+	}
+	columns, err := s.db.TimeColumns(start, end)
+	if err != nil {
+		return nil, err
+	}
+	// The column covering the most of the range of each dataset, by dataset ID.
+	byDataset := make(map[string]*temporalResult)
+	var ids []string
+
+	for _, c := range columns {
+		if c.DatasetID == exclude || !c.Granularity.RollsUpTo(g) {
+			continue
+		}
+		overlap := c.Overlap(start, end)
+		best := byDataset[c.DatasetID]
+		if best == nil {
+			ids = append(ids, c.DatasetID)
+		} else if best.Overlap >= overlap {
+			continue
+		}
+		byDataset[c.DatasetID] = &temporalResult{TimeColumn: c, Overlap: overlap}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return byDataset[ids[i]].Overlap > byDataset[ids[j]].Overlap
+	})
+	if int64(len(ids)) > s.searchResults {
+		ids = ids[:s.searchResults]
+	}
+	results := make([]*temporalResult, len(ids))
+
+	for i, id := range ids {
+		r := byDataset[id]
+		if r.Column, err = s.db.ColumnSketch(r.ColumnID); err != nil {
+			return nil, err
+		}
+		if r.DatasetName, err = s.db.DatasetName(id); err != nil {
+			return nil, err
+		}
+		results[i] = r
+	}
+	return results, nil
+}
@@ -1,19 +1,75 @@
 package server
 
 import (
+	"database/sql"
 	"errors"
 	"sort"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
-	"github.com/ekzhu/lshensemble"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/lazo"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/unionability"
 )
 
-var errInvalidID = errors.New("unionableTables: invalid dataset ID")
+var (
+	errInvalidID     = errors.New("unionableTables: invalid dataset ID")
+	errNoSchemaUnion = errors.New("attribute embedding index is not built")
+)
 
 type unionabilityResult struct {
 	DatasetID   string
 	DatasetName string
 	Alignment   float64
+	// The matched attributes of schema searches.
+	Matches []*unionability.Match
+	// The mappings of the columns of the query table.
+	Mappings []*attributeMapping
+}
+
+// attributeMapping maps a column of the query table to the column of a
+// unionable table it is unioned with.
+type attributeMapping struct {
+	QueryColumn string
+	// The unionable column, empty if the query column has none.
+	Column          string
+	QueryType, Type coltype.Type
+	// The type both columns are cast to (see coltype.Common).
+	Cast coltype.Type
+	// Containment of the query column in the column, or similarity of their
+	// name embeddings in schema searches.
+	Score float64
+}
+
+// columnMatch is the column of a unionable table matched with a query column.
+type columnMatch struct {
+	column string
+	score  float64
+}
+
+// attributeMappings returns the mappings of the query columns, in the order of
+// the query table, to the candidate columns matched with them by name.
+func (s *Server) attributeMappings(query []*database.ColumnSketch, queryTypes map[string]coltype.Type, candidate []*database.ColumnSketch, matches map[string]columnMatch) ([]*attributeMapping, error) {
+	if len(candidate) == 0 {
+		return nil, nil
+	}
+	types, err := s.db.DatasetColumnTypes(candidate[0].DatasetID)
+	if err != nil {
+		return nil, err
+	}
+	typesByName := make(map[string]coltype.Type)
+	for _, c := range candidate {
+		typesByName[c.ColumnName] = types[c.ColumnID]
+	}
+	mappings := make([]*attributeMapping, len(query))
+	for i, q := range query {
+		m := &attributeMapping{QueryColumn: q.ColumnName, QueryType: queryTypes[q.ColumnID]}
+		if match, ok := matches[q.ColumnName]; ok {
+			m.Column, m.Type, m.Score = match.column, typesByName[match.column], match.score
+			m.Cast = coltype.Common(m.QueryType, m.Type)
+		}
+		mappings[i] = m
+	}
+	return mappings, nil
 }
 
 func (s *Server) unionableTables(datasetID string) ([]*unionabilityResult, error) {
@@ -27,6 +83,10 @@ func (s *Server) unionableTables(datasetID string) ([]*unionabilityResult, error
 	if err != nil {
 		return nil, err
 	}
+	queryTypes, err := s.db.DatasetColumnTypes(datasetID)
+	if err != nil {
+		return nil, err
+	}
 	results := make([]*unionabilityResult, 0, len(candidates))
 
 	for _, datasetID := range candidates {
@@ -38,9 +98,17 @@ func (s *Server) unionableTables(datasetID string) ([]*unionabilityResult, error
 		if err != nil {
 			return nil, err
 		}
-		alignment := unionabilityScore(query, candidate)
+		alignment, pairs := unionabilityScore(query, candidate)
+		matches := make(map[string]columnMatch)
+		for _, p := range pairs {
+			matches[p.query.ColumnName] = columnMatch{p.candidate.ColumnName, p.score}
+		}
+		mappings, err := s.attributeMappings(query, queryTypes, candidate, matches)
+		if err != nil {
+			return nil, err
+		}
 		results = append(results, &unionabilityResult{
-			datasetID, datasetName, alignment,
+			DatasetID: datasetID, DatasetName: datasetName, Alignment: alignment, Mappings: mappings,
 		})
 	}
 	sort.Slice(results, func(i, j int) bool {
@@ -50,6 +118,58 @@ func (s *Server) unionableTables(datasetID string) ([]*unionabilityResult, error
 	return results, nil
 }
 
+// schemaUnionableTables returns the (up to) k tables whose schemas best align
+// with that of the dataset with the given ID, by attribute name embedding and
+// type (see index.UnionabilitySearcher), sorted by alignment.
+//
+// Returns errNoSchemaUnion if the attribute embedding index is not built, and
+// errInvalidID if there is no dataset with the ID.
+func (s *Server) schemaUnionableTables(datasetID string, k int) ([]*unionabilityResult, error) {
+	if s.schemaUnion == nil {
+		return nil, errNoSchemaUnion
+	}
+	tables, err := s.schemaUnion.Search(datasetID, k)
+	if err != nil {
+		return nil, err
+	}
+	if tables == nil {
+		if _, err := s.db.DatasetName(datasetID); err == sql.ErrNoRows {
+			return nil, errInvalidID
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	query, err := s.db.DatasetColumns(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	queryTypes, err := s.db.DatasetColumnTypes(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*unionabilityResult, len(tables))
+	for i, t := range tables {
+		datasetName, err := s.db.DatasetName(t.DatasetID)
+		if err != nil {
+			return nil, err
+		}
+		candidate, err := s.db.DatasetColumns(t.DatasetID)
+		if err != nil {
+			return nil, err
+		}
+		matches := make(map[string]columnMatch)
+		for _, m := range t.Matches {
+			matches[m.Query] = columnMatch{m.Candidate, float64(m.Similarity)}
+		}
+		mappings, err := s.attributeMappings(query, queryTypes, candidate, matches)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = &unionabilityResult{t.DatasetID, datasetName, t.Score, t.Matches, mappings}
+	}
+	return results, nil
+}
+
 func (s *Server) unionCandidates(table []*database.ColumnSketch) ([]string, error) {
 	datasetID := table[0].DatasetID
 	// Maps dataset IDs to number of joinability query results they appear in.
@@ -92,11 +212,19 @@ func (s *Server) unionCandidates(table []*database.ColumnSketch) ([]string, erro
 	return results, nil
 }
 
+// columnPair is a query column and the candidate column it is unionable with.
+type columnPair struct {
+	query, candidate *database.ColumnSketch
+	// Containment of the query column in the candidate column.
+	score float64
+}
+
 // unionabilityScore returns a score between 0 and 1 that represents the
-// unionability of the candidate table with the query table.
+// unionability of the candidate table with the query table, and the pairs of
+// unionable columns it counts.
 // Roughly, it is the fraction of candidate columns that are unionable with a
 // query column.
-func unionabilityScore(query, candidate []*database.ColumnSketch) float64 {
+func unionabilityScore(query, candidate []*database.ColumnSketch) (float64, []*columnPair) {
 	var small, big []*database.ColumnSketch
 	var qsmall bool
 
@@ -106,7 +234,7 @@ func unionabilityScore(query, candidate []*database.ColumnSketch) float64 {
 		small, big = query, candidate
 		qsmall = true
 	}
-	var scores []float64
+	var pairs []*columnPair
 	matched := make(map[*database.ColumnSketch]bool)
 
 	for _, c1 := range small {
@@ -123,27 +251,32 @@ func unionabilityScore(query, candidate []*database.ColumnSketch) float64 {
 			} else {
 				q, x = c2, c1
 			}
-			cont := lshensemble.Containment(
-				q.Minhash, x.Minhash, q.DistinctCount, x.DistinctCount)
+			cont := lazo.Overlap(q.Minhash, x.Minhash, q.DistinctCount, x.DistinctCount).Containment
 			if cont > bestCont {
 				best, bestCont = c2, cont
 			}
 		}
 		if best != nil {
 			matched[best] = true
-			scores = append(scores, bestCont)
+			if qsmall {
+				pairs = append(pairs, &columnPair{c1, best, bestCont})
+			} else {
+				pairs = append(pairs, &columnPair{best, c1, bestCont})
+			}
 		}
 	}
-	sort.Float64s(scores)
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].score > pairs[j].score
+	})
 	score := float64(1)
 	alignment := 0
 
-	for i := len(scores) - 1; i >= 0; i-- {
+	for _, p := range pairs {
 		if score < 0.5 {
 			break
 		}
-		score *= scores[i]
+		score *= p.score
 		alignment++
 	}
-	return float64(alignment) / float64(len(query))
+	return float64(alignment) / float64(len(query)), pairs[:alignment]
 }
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// widgetResult is a search result shown in the search widget.
+type widgetResult struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Snippet string `json:"snippet"`
+	URL     string `json:"url"`
+}
+
+type widgetPage struct {
+	BaseURL string
+	Query   string
+	Limit   int
+	Results []*widgetResult
+}
+
+// baseURL returns the absolute URL of the server as seen by the client.
+// Widget links must be absolute because the widget is embedded in other sites.
+func baseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host
+}
+
+// handleWidgetSearch handles /widget/search?q=<query>, a minimal search page
+// meant to be embedded into other portals' websites in an iframe.
+// With format=json, the results are returned as JSON instead, for portals
+// rendering their own search box.
+func (s *Server) handleWidgetSearch(w http.ResponseWriter, req *http.Request) {
+	page := &widgetPage{
+		BaseURL: baseURL(req),
+		Query:   req.FormValue("q"),
+		Limit:   10,
+	}
+	if n, err := strconv.Atoi(req.FormValue("limit")); err == nil && n > 0 && n <= 50 {
+		page.Limit = n
+	}
+	if page.Query != "" {
+		results, err := s.keywordSearch(page.Query, false)
+		if err != nil {
+			s.serverError(w, err)
+			return
+		}
+		if len(results) > page.Limit {
+			results = results[:page.Limit]
+		}
+		for _, m := range results {
+			snippet := m.Description
+			if i := strings.IndexByte(snippet, '\n'); i >= 0 {
+				snippet = snippet[:i]
+			}
+			if len(snippet) > 120 {
+				snippet = snippet[:117] + "..."
+			}
+			page.Results = append(page.Results, &widgetResult{
+				ID:      m.DatasetID,
+				Name:    m.Name,
+				Snippet: snippet,
+				URL:     page.BaseURL + "/dataset/" + m.DatasetID,
+			})
+		}
+	}
+
+	if req.FormValue("format") == "json" {
+		if page.Results == nil {
+			page.Results = []*widgetResult{}
+		}
+		writeJSON(w, http.StatusOK, page.Results)
+		return
+	}
+	tmpl, err := s.widgetTemplate()
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	// Unlike the other pages, the widget may be framed by the allowed origins.
+	w.Header().Set("Content-Security-Policy", "frame-ancestors "+s.frameAncestors())
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, page); err != nil {
+		s.serverError(w, err)
+		return
+	}
+	if _, err := buf.WriteTo(w); err != nil {
+		s.serverError(w, err)
+	}
+}
+
+func (s *Server) widgetTemplate() (*template.Template, error) {
+	if !s.devMode && s.widget != nil {
+		return s.widget, nil
+	}
+	return template.ParseFiles("web/template/widget.html")
+}
+
+// frameAncestors returns the CSP frame-ancestors source list for the widget.
+func (s *Server) frameAncestors() string {
+	if len(s.corsOrigins) == 0 {
+		return "'self'"
+	}
+	return strings.Join(s.corsOrigins, " ")
+}
+
+// corsHandler adds CORS headers to the responses of next for requests from the
+// allowed origins and answers preflight requests.
+// An origin of "*" allows all origins.
+func corsHandler(origins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" || !(allowed["*"] || allowed[origin]) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		h := w.Header()
+		if allowed["*"] {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin")
+		}
+		h.Set("Access-Control-Expose-Headers", "API-Version, Deprecation, Sunset, Link")
+
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, PATCH, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			h.Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
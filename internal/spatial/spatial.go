@@ -0,0 +1,275 @@
+// Package spatial computes the spatial extents of the geographic columns of
+// datasets, so that datasets covering the same region can be found and joined
+// spatially.
+//
+// The extents of latitude and longitude, point, and geohash columns are their
+// bounding boxes. Columns of administrative codes cannot be placed on a map
+// without a gazetteer, so their extents are the regions their codes fall in,
+// which are only compared with those of columns of the same kind of codes.
+package spatial
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coldomain"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/numsketch"
+)
+
+// Kind is the kind of column, or pair of columns, of an extent.
+type Kind string
+
+const (
+	// A latitude column and a longitude column of the same dataset.
+	LatLong  Kind = "lat_long"
+	Point    Kind = "point"
+	Geohash  Kind = "geohash"
+	ZipCode  Kind = "zip_code"
+	FIPSCode Kind = "fips_code"
+)
+
+// ParseKind returns the Kind named s.
+func ParseKind(s string) (Kind, error) {
+	switch k := Kind(s); k {
+	case LatLong, Point, Geohash, ZipCode, FIPSCode:
+		return k, nil
+	}
+	return "", fmt.Errorf("unknown spatial column kind %q (want lat_long, point, geohash, zip_code, or fips_code)", s)
+}
+
+// Boxed reports whether the extents of kind k are bounding boxes rather than
+// regions.
+func (k Kind) Boxed() bool {
+	return k == LatLong || k == Point || k == Geohash
+}
+
+// Box is a bounding box in degrees.
+type Box struct {
+	MinLat, MinLong, MaxLat, MaxLong float64
+}
+
+// extend extends the box, which is empty if empty is true, to contain the box
+// c.
+func (b *Box) extend(c Box, empty bool) {
+	if empty {
+		*b = c
+		return
+	}
+	b.MinLat = math.Min(b.MinLat, c.MinLat)
+	b.MinLong = math.Min(b.MinLong, c.MinLong)
+	b.MaxLat = math.Max(b.MaxLat, c.MaxLat)
+	b.MaxLong = math.Max(b.MaxLong, c.MaxLong)
+}
+
+// Overlap returns the fraction of the area of the box q that b covers. Along
+// each axis on which q has no width, q is covered if b contains it.
+func (b Box) Overlap(q Box) float64 {
+	return overlap(b.MinLat, b.MaxLat, q.MinLat, q.MaxLat) *
+		overlap(b.MinLong, b.MaxLong, q.MinLong, q.MaxLong)
+}
+
+// overlap returns the fraction of the interval from qmin to qmax covered by
+// the interval from min to max.
+func overlap(min, max, qmin, qmax float64) float64 {
+	from, to := math.Max(min, qmin), math.Min(max, qmax)
+	if to < from {
+		return 0
+	}
+	if qmax > qmin {
+		return (to - from) / (qmax - qmin)
+	}
+	return 1
+}
+
+// Extent is the spatial extent of a column.
+type Extent struct {
+	Kind Kind
+	// The bounding box, of boxed kinds.
+	Box Box
+	// The sorted regions, of the other kinds: the 3-digit prefixes of zip
+	// codes, which are the areas of the postal sectional centers, and the
+	// county (or, of state codes, the state) of FIPS codes.
+	Regions []string
+}
+
+// Overlap returns the fraction of the extent q that e covers, or 0 if their
+// kinds cannot be compared. The regions of q are covered by those of e that
+// are equal to them or contain them, or that they contain.
+func (e *Extent) Overlap(q *Extent) float64 {
+	if e.Kind.Boxed() && q.Kind.Boxed() {
+		return e.Box.Overlap(q.Box)
+	}
+	if e.Kind != q.Kind || len(q.Regions) == 0 {
+		return 0
+	}
+	// The regions of e, and the states containing them.
+	regions := make(map[string]bool)
+	states := make(map[string]bool)
+	for _, r := range e.Regions {
+		regions[r] = true
+		if len(r) > stateCodeLen {
+			states[r[:stateCodeLen]] = true
+		}
+	}
+	var n int
+	for _, r := range q.Regions {
+		switch {
+		case regions[r],
+			len(r) == stateCodeLen && states[r],
+			len(r) > stateCodeLen && regions[r[:stateCodeLen]]:
+			n++
+		}
+	}
+	return float64(n) / float64(len(q.Regions))
+}
+
+const (
+	// Lengths of the region prefixes of zip and FIPS codes.
+	zipRegionLen  = 3
+	stateCodeLen  = 2
+	countyCodeLen = 5
+	// Maximum number of regions of a column, more than there are of either
+	// kind, beyond which the column is not one of codes.
+	maxRegions = 5000
+	// Minimum length of the geohashes of geohash columns (see coldomain).
+	minGeohashLen = 4
+)
+
+var (
+	zipPattern  = regexp.MustCompile(`^\d{5}(-\d{4})?$`)
+	fipsPattern = regexp.MustCompile(`^\d{2}(\d{3}(\d{6}(\d(\d{3})?)?)?)?$`)
+)
+
+// A Profiler computes the extents of a column from its values, for each of the
+// kinds of column it may be.
+type Profiler struct {
+	points, geohashes   Box
+	npoints, ngeohashes int64
+	zips, fips          map[string]bool
+}
+
+// Add adds a value of the column. Blank values are ignored.
+func (p *Profiler) Add(v string) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return
+	}
+	if lat, long, ok := coldomain.ParsePoint(v); ok {
+		p.points.extend(Box{lat, long, lat, long}, p.npoints == 0)
+		p.npoints++
+	}
+	if len(v) >= minGeohashLen {
+		if b, ok := DecodeGeohash(v); ok {
+			p.geohashes.extend(b, p.ngeohashes == 0)
+			p.ngeohashes++
+		}
+	}
+	if zipPattern.MatchString(v) {
+		p.zips = addRegion(p.zips, v[:zipRegionLen])
+	}
+	if fipsPattern.MatchString(v) {
+		if len(v) > countyCodeLen {
+			v = v[:countyCodeLen]
+		}
+		p.fips = addRegion(p.fips, v)
+	}
+}
+
+// addRegion adds the region r to the set of regions, unless it has maxRegions
+// regions.
+func addRegion(regions map[string]bool, r string) map[string]bool {
+	if regions == nil {
+		regions = make(map[string]bool)
+	}
+	if len(regions) < maxRegions {
+		regions[r] = true
+	}
+	return regions
+}
+
+// Extent returns the extent of the column if it is of the domain d, which must
+// be the domain of a single column of one of the kinds, or nil if it is not or
+// the column has no values of the kind.
+func (p *Profiler) Extent(d coldomain.Domain) *Extent {
+	switch d {
+	case coldomain.Point:
+		if p.npoints > 0 {
+			return &Extent{Kind: Point, Box: p.points}
+		}
+	case coldomain.Geohash:
+		if p.ngeohashes > 0 {
+			return &Extent{Kind: Geohash, Box: p.geohashes}
+		}
+	case coldomain.ZipCode:
+		return regionExtent(ZipCode, p.zips)
+	case coldomain.FIPSCode:
+		return regionExtent(FIPSCode, p.fips)
+	}
+	return nil
+}
+
+func regionExtent(k Kind, regions map[string]bool) *Extent {
+	if len(regions) == 0 || len(regions) >= maxRegions {
+		return nil
+	}
+	e := &Extent{Kind: k}
+	for r := range regions {
+		e.Regions = append(e.Regions, r)
+	}
+	sort.Strings(e.Regions)
+	return e
+}
+
+// LatLongExtent returns the extent of a latitude column and a longitude column
+// with the numeric sketches lat and long, or nil if either is nil or out of
+// range.
+func LatLongExtent(lat, long *numsketch.Sketch) *Extent {
+	if lat == nil || long == nil ||
+		math.Abs(lat.Min) > 90 || math.Abs(lat.Max) > 90 ||
+		math.Abs(long.Min) > 180 || math.Abs(long.Max) > 180 {
+		return nil
+	}
+	return &Extent{Kind: LatLong, Box: Box{lat.Min, long.Min, lat.Max, long.Max}}
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// DecodeGeohash returns the cell of the geohash s, which is case-insensitive.
+func DecodeGeohash(s string) (Box, bool) {
+	if s == "" || len(s) > 12 {
+		return Box{}, false
+	}
+	b := Box{-90, -180, 90, 180}
+	// Geohashes interleave the bits of the longitude and the latitude,
+	// starting with the longitude.
+	long := true
+	for _, c := range strings.ToLower(s) {
+		d := strings.IndexRune(geohashAlphabet, c)
+		if d < 0 {
+			return Box{}, false
+		}
+		for bit := 4; bit >= 0; bit-- {
+			on := d&(1<<uint(bit)) != 0
+			if long {
+				mid := (b.MinLong + b.MaxLong) / 2
+				if on {
+					b.MinLong = mid
+				} else {
+					b.MaxLong = mid
+				}
+			} else {
+				mid := (b.MinLat + b.MaxLat) / 2
+				if on {
+					b.MinLat = mid
+				} else {
+					b.MaxLat = mid
+				}
+			}
+			long = !long
+		}
+	}
+	return b, true
+}
@@ -0,0 +1,72 @@
+package spatial
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coldomain"
+)
+
+func TestDecodeGeohash(t *testing.T) {
+	b, ok := DecodeGeohash("DR5RU7")
+	if !ok {
+		t.Fatal("not a geohash")
+	}
+	// dr5ru7 is in Midtown Manhattan, near 40.756, -73.99.
+	if b.MinLat > 40.756 || b.MaxLat < 40.756 || b.MinLong > -73.99 || b.MaxLong < -73.99 {
+		t.Errorf("got cell %+v", b)
+	}
+	if _, ok := DecodeGeohash("dr5ai"); ok {
+		t.Error("decoded a geohash with letters not in the alphabet")
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	var p Profiler
+	for _, v := range []string{"POINT (-74.0 40.7)", "(40.6, -73.9)", "", "n/a"} {
+		p.Add(v)
+	}
+	want := &Extent{Kind: Point, Box: Box{40.6, -74, 40.7, -73.9}}
+	if e := p.Extent(coldomain.Point); !reflect.DeepEqual(e, want) {
+		t.Errorf("got point extent %+v, want %+v", e, want)
+	}
+	if e := p.Extent(coldomain.Geohash); e != nil {
+		t.Errorf("got geohash extent %+v of a column without geohashes", e)
+	}
+
+	p = Profiler{}
+	for _, v := range []string{"10001", "10458-1234", "11201"} {
+		p.Add(v)
+	}
+	if e := p.Extent(coldomain.ZipCode); e == nil || !reflect.DeepEqual(e.Regions, []string{"100", "104", "112"}) {
+		t.Errorf("got zip code extent %+v", e)
+	}
+	if e := p.Extent(coldomain.FIPSCode); e == nil || !reflect.DeepEqual(e.Regions, []string{"10001", "11201"}) {
+		t.Errorf("got FIPS code extent %+v", e)
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	box := &Extent{Kind: LatLong, Box: Box{40, -75, 41, -73}}
+	counties := &Extent{Kind: FIPSCode, Regions: []string{"36047", "36061"}}
+	tests := []struct {
+		e, q *Extent
+		want float64
+	}{
+		{box, &Extent{Kind: Point, Box: Box{40.5, -74, 41.5, -73}}, 0.5},
+		{box, &Extent{Kind: Geohash, Box: Box{40.5, -74, 40.5, -74}}, 1},
+		{box, &Extent{Kind: Point, Box: Box{50, -74, 51, -73}}, 0},
+		{counties, &Extent{Kind: FIPSCode, Regions: []string{"36047", "36081"}}, 0.5},
+		// A state contains its counties.
+		{counties, &Extent{Kind: FIPSCode, Regions: []string{"36", "06"}}, 0.5},
+		{&Extent{Kind: FIPSCode, Regions: []string{"36"}}, counties, 1},
+		{counties, box, 0},
+		{&Extent{Kind: ZipCode, Regions: []string{"360"}}, counties, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.e.Overlap(tt.q); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%+v.Overlap(%+v) = %v, want %v", tt.e, tt.q, got, tt.want)
+		}
+	}
+}
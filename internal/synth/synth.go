@@ -0,0 +1,355 @@
+// Package synth generates synthetic Open Data Link corpora for performance
+// testing the indexes and the database without downloading real datasets.
+//
+// Datasets are generated deterministically from a seed. Their columns draw
+// values from a pool of shared value domains with Zipf-distributed
+// frequencies, so that, as in real open data portals, some columns overlap and
+// are joinable while most are not.
+package synth
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config configures a Generator.
+type Config struct {
+	// Number of datasets.
+	Datasets int
+	// Range of the number of columns per dataset.
+	MinColumns, MaxColumns int
+	// Range of the number of rows per dataset.
+	MinRows, MaxRows int
+	// Number of shared value domains that columns draw values from.
+	Domains int
+	// Number of distinct values per domain.
+	DomainSize int
+	// Seed of the random number generator.
+	Seed int64
+}
+
+// DefaultConfig returns a Config for a small corpus of 1000 datasets.
+func DefaultConfig() *Config {
+	return &Config{
+		Datasets:   1000,
+		MinColumns: 2,
+		MaxColumns: 20,
+		MinRows:    10,
+		MaxRows:    1000,
+		Domains:    200,
+		DomainSize: 5000,
+		Seed:       1,
+	}
+}
+
+// Column kinds.
+const (
+	kindText = iota
+	kindNumber
+	kindDate
+	numKinds
+)
+
+// Column describes a column of a synthetic dataset.
+type Column struct {
+	Name string
+	kind int
+	// Domain the values of text columns are drawn from.
+	domain int
+}
+
+// Dataset is a synthetic dataset.
+type Dataset struct {
+	ID          string
+	Name        string
+	Description string
+	Attribution string
+	Email       string
+	UpdatedAt   time.Time
+	Categories  []string
+	Tags        []string
+	Columns     []*Column
+	NumRows     int
+	seed        int64
+}
+
+// Generator generates synthetic datasets.
+type Generator struct {
+	cfg *Config
+}
+
+// New returns a Generator for the given configuration.
+func New(cfg *Config) (*Generator, error) {
+	switch {
+	case cfg.Datasets < 0:
+		return nil, fmt.Errorf("synth: invalid number of datasets %d", cfg.Datasets)
+	case cfg.MinColumns < 1 || cfg.MaxColumns < cfg.MinColumns:
+		return nil, fmt.Errorf("synth: invalid column range %d-%d", cfg.MinColumns, cfg.MaxColumns)
+	case cfg.MinRows < 0 || cfg.MaxRows < cfg.MinRows:
+		return nil, fmt.Errorf("synth: invalid row range %d-%d", cfg.MinRows, cfg.MaxRows)
+	case cfg.Domains < 1 || cfg.DomainSize < 1:
+		return nil, fmt.Errorf("synth: invalid domains %d of size %d", cfg.Domains, cfg.DomainSize)
+	}
+	return &Generator{cfg}, nil
+}
+
+func between(r *rand.Rand, min, max int) int {
+	return min + r.Intn(max-min+1)
+}
+
+func pick(r *rand.Rand, words []string) string {
+	return words[r.Intn(len(words))]
+}
+
+// datasetID returns a Socrata-style four-by-four ID for the ith dataset.
+// Multiplying by an odd number is a bijection modulo 2^40, so the IDs of
+// different datasets are distinct.
+func datasetID(seed int64, i int) string {
+	const chars = "abcdefghijkmnpqrstuvwxyz23456789"
+	x := (uint64(i)*0x9e3779b97f4a7c15 + uint64(seed)) & (1<<40 - 1)
+	b := make([]byte, 9)
+	for j := 8; j >= 0; j-- {
+		if j == 4 {
+			b[j] = '-'
+			continue
+		}
+		b[j] = chars[x%32]
+		x /= 32
+	}
+	return string(b)
+}
+
+func sentence(r *rand.Rand, n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = pick(r, vocabulary)
+	}
+	return strings.Join(words, " ")
+}
+
+// Dataset returns the ith dataset. The same dataset is returned for the same
+// configuration and i.
+func (g *Generator) Dataset(i int) *Dataset {
+	seed := g.cfg.Seed*1000003 + int64(i)
+	r := rand.New(rand.NewSource(seed))
+
+	topic := pick(r, topics)
+	d := &Dataset{
+		ID:          datasetID(g.cfg.Seed, i),
+		Name:        strings.Title(topic + " " + pick(r, vocabulary) + " " + pick(r, datasetNouns)),
+		Description: "This dataset contains " + topic + " " + sentence(r, between(r, 5, 40)) + ".",
+		Attribution: "Department of " + strings.Title(pick(r, topics)),
+		UpdatedAt:   time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(r.Int63n(int64(12 * 365 * 24 * time.Hour)))),
+		Categories:  []string{strings.Title(topic)},
+		NumRows:     between(r, g.cfg.MinRows, g.cfg.MaxRows),
+		seed:        seed,
+	}
+	d.Email = "data@" + strings.ToLower(strings.Fields(d.Attribution)[2]) + ".example.org"
+	for n := r.Intn(6); n > 0; n-- {
+		d.Tags = append(d.Tags, pick(r, vocabulary))
+	}
+
+	seen := make(map[string]bool)
+	for n := between(r, g.cfg.MinColumns, g.cfg.MaxColumns); len(d.Columns) < n; {
+		c := &Column{kind: r.Intn(numKinds)}
+		switch c.kind {
+		case kindText:
+			// Zipf-distributed domains make a few domains, and thus joinable
+			// columns, common.
+			c.domain = int(zipf(r, g.cfg.Domains))
+			c.Name = domainNames[c.domain%len(domainNames)]
+		case kindNumber:
+			c.Name = pick(r, vocabulary) + " " + pick(r, measures)
+		case kindDate:
+			c.Name = pick(r, vocabulary) + " date"
+		}
+		if seen[c.Name] {
+			c.Name += " " + strconv.Itoa(len(d.Columns)+1)
+		}
+		seen[c.Name] = true
+		d.Columns = append(d.Columns, c)
+	}
+	return d
+}
+
+// zipf returns a Zipf-distributed integer in [0, n).
+func zipf(r *rand.Rand, n int) uint64 {
+	if n == 1 {
+		return 0
+	}
+	return rand.NewZipf(r, 1.1, 1, uint64(n-1)).Uint64()
+}
+
+// domainValue returns the jth value of a value domain.
+func domainValue(domain int, j uint64) string {
+	return fmt.Sprintf("%s %d", domainNames[domain%len(domainNames)], uint64(domain)*1000003+j)
+}
+
+// Rows calls f with each row of the dataset, after the header row.
+// The slice passed to f is reused between calls.
+func (g *Generator) Rows(d *Dataset, f func(row []string) error) error {
+	r := rand.New(rand.NewSource(d.seed + 7))
+	zipfs := make([]*rand.Zipf, len(d.Columns))
+	for i, c := range d.Columns {
+		if c.kind == kindText && g.cfg.DomainSize > 1 {
+			zipfs[i] = rand.NewZipf(r, 1.2, 1, uint64(g.cfg.DomainSize-1))
+		}
+	}
+	row := make([]string, len(d.Columns))
+
+	for n := 0; n < d.NumRows; n++ {
+		for i, c := range d.Columns {
+			switch c.kind {
+			case kindText:
+				var j uint64
+				if zipfs[i] != nil {
+					j = zipfs[i].Uint64()
+				}
+				row[i] = domainValue(c.domain, j)
+			case kindNumber:
+				row[i] = strconv.FormatFloat(math.Round(r.NormFloat64()*1000+5000)/100, 'f', 2, 64)
+			case kindDate:
+				row[i] = d.UpdatedAt.AddDate(0, 0, -r.Intn(3650)).Format("2006-01-02")
+			}
+			// Real data has missing values.
+			if r.Intn(50) == 0 {
+				row[i] = ""
+			}
+		}
+		if err := f(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes the dataset's rows as CSV, with a header row.
+func (g *Generator) WriteCSV(w io.Writer, d *Dataset) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(d.Columns))
+	for i, c := range d.Columns {
+		header[i] = c.Name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := g.Rows(d, cw.Write); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// socrataMetadata is the subset of the Socrata Discovery API result format
+// read by process_metadata.
+type socrataMetadata struct {
+	Resource struct {
+		Name         string `json:"name"`
+		ID           string `json:"id"`
+		Description  string `json:"description"`
+		Attribution  string `json:"attribution"`
+		ContactEmail string `json:"contact_email"`
+		UpdatedAt    string `json:"updatedAt"`
+	} `json:"resource"`
+	Classification struct {
+		Categories     []string `json:"categories"`
+		Tags           []string `json:"tags"`
+		DomainCategory string   `json:"domain_category"`
+		DomainTags     []string `json:"domain_tags"`
+	} `json:"classification"`
+	Permalink string `json:"permalink"`
+}
+
+// WriteMetadata writes the dataset's metadata in the format of the Socrata
+// Discovery API results saved by the crawler.
+func (g *Generator) WriteMetadata(w io.Writer, d *Dataset) error {
+	var m socrataMetadata
+	m.Resource.Name = d.Name
+	m.Resource.ID = d.ID
+	m.Resource.Description = d.Description
+	m.Resource.Attribution = d.Attribution
+	m.Resource.ContactEmail = d.Email
+	m.Resource.UpdatedAt = d.UpdatedAt.Format(time.RFC3339)
+	m.Classification.Categories = d.Categories
+	m.Classification.Tags = d.Tags
+	m.Classification.DomainCategory = d.Categories[0]
+	m.Classification.DomainTags = []string{}
+	m.Permalink = "https://data.example.org/d/" + d.ID
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&m)
+}
+
+// WriteDir writes all datasets to dir in the layout of the crawler
+// (dir/<id>/metadata.json and dir/<id>/rows.csv), so they can be processed by
+// process_metadata and sketch_columns.
+func (g *Generator) WriteDir(dir string) error {
+	for i := 0; i < g.cfg.Datasets; i++ {
+		d := g.Dataset(i)
+		path := filepath.Join(dir, d.ID)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(path, "metadata.json"), d, g.WriteMetadata); err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(path, "rows.csv"), d, g.WriteCSV); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, d *Dataset, write func(io.Writer, *Dataset) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, d); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Vectors returns n random unit vectors of dimension dim, concatenated.
+// The vectors are grouped around clusters centers, as embeddings of related
+// datasets are, so that nearest neighbor queries have meaningful neighbors.
+func Vectors(r *rand.Rand, n, dim, clusters int) []float32 {
+	if clusters < 1 {
+		clusters = 1
+	}
+	centers := make([][]float64, clusters)
+	for i := range centers {
+		centers[i] = make([]float64, dim)
+		for j := range centers[i] {
+			centers[i][j] = r.NormFloat64()
+		}
+	}
+	vecs := make([]float32, n*dim)
+	v := make([]float64, dim)
+
+	for i := 0; i < n; i++ {
+		c := centers[r.Intn(clusters)]
+		var norm float64
+		for j := range v {
+			v[j] = c[j] + 0.5*r.NormFloat64()
+			norm += v[j] * v[j]
+		}
+		norm = math.Sqrt(norm)
+		for j := range v {
+			vecs[i*dim+j] = float32(v[j] / norm)
+		}
+	}
+	return vecs
+}
@@ -0,0 +1,54 @@
+package synth
+
+// Word lists used to generate dataset metadata and column names. The words are
+// common English words, so that they have fastText embeddings.
+
+var topics = []string{
+	"transportation", "public safety", "health", "education", "housing",
+	"environment", "finance", "parks", "water", "energy", "elections",
+	"employment", "transit", "libraries", "agriculture", "tourism",
+	"planning", "sanitation", "police", "fire", "census", "budget",
+	"infrastructure", "recreation", "economy",
+}
+
+var datasetNouns = []string{
+	"data", "report", "inventory", "survey", "statistics", "records",
+	"locations", "permits", "inspections", "incidents", "counts", "summary",
+	"requests", "complaints", "budget", "map",
+}
+
+var measures = []string{
+	"count", "amount", "total", "rate", "percent", "cost", "value",
+	"score", "population", "area", "distance", "duration",
+}
+
+// Column names of the shared value domains.
+var domainNames = []string{
+	"city", "county", "state", "zip code", "street", "neighborhood",
+	"district", "school", "agency", "department", "vendor", "category",
+	"status", "type", "facility", "park", "route", "station", "hospital",
+	"program", "license", "permit", "species", "crime", "violation",
+	"business", "contractor", "fund", "project", "ward",
+}
+
+var vocabulary = []string{
+	"annual", "monthly", "daily", "weekly", "city", "county", "state",
+	"public", "private", "local", "regional", "federal", "community",
+	"service", "services", "program", "programs", "project", "projects",
+	"traffic", "vehicle", "vehicles", "bus", "rail", "bike", "road",
+	"roads", "bridge", "street", "parking", "safety", "crime", "arrests",
+	"calls", "emergency", "response", "hospital", "clinic", "disease",
+	"vaccination", "school", "schools", "student", "students", "teacher",
+	"graduation", "enrollment", "housing", "rent", "building", "buildings",
+	"construction", "permit", "permits", "inspection", "inspections",
+	"air", "quality", "water", "waste", "recycling", "trees", "tree",
+	"energy", "electricity", "gas", "solar", "revenue", "spending",
+	"contracts", "salaries", "employees", "payroll", "tax", "taxes",
+	"property", "land", "zoning", "park", "parks", "library", "visits",
+	"events", "permits", "licenses", "business", "businesses", "restaurant",
+	"food", "farm", "crops", "weather", "rainfall", "temperature",
+	"population", "income", "poverty", "jobs", "unemployment", "election",
+	"votes", "voters", "precinct", "district", "council", "budget",
+	"capital", "operating", "total", "average", "median", "number",
+	"location", "address", "neighborhood", "area", "zone", "boundary",
+}
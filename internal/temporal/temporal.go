@@ -0,0 +1,136 @@
+// Package temporal profiles the time columns of datasets, recording the range
+// of their times and their granularity, so that time series covering the same
+// period can be found and joined after rolling the finer series up to the
+// granularity of the coarser.
+package temporal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/coltype"
+)
+
+// Granularity is the granularity of the times of a column, the finest of its
+// values: e.g. the times of a column of months are the first instants of the
+// months.
+type Granularity int
+
+const (
+	Second Granularity = iota
+	Minute
+	Hour
+	Day
+	Month
+	Year
+)
+
+var granularityNames = []string{"second", "minute", "hour", "day", "month", "year"}
+
+func (g Granularity) String() string {
+	if g < Second || g > Year {
+		return fmt.Sprintf("Granularity(%d)", int(g))
+	}
+	return granularityNames[g]
+}
+
+// ParseGranularity returns the Granularity named s.
+func ParseGranularity(s string) (Granularity, error) {
+	for i, name := range granularityNames {
+		if s == name {
+			return Granularity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown granularity %q (want second, minute, hour, day, month, or year)", s)
+}
+
+// RollsUpTo reports whether times of granularity g can be aggregated to
+// granularity to, i.e. g is at least as fine.
+func (g Granularity) RollsUpTo(to Granularity) bool {
+	return g <= to
+}
+
+// granularityOf returns the granularity of the time t.
+func granularityOf(t time.Time) Granularity {
+	switch {
+	case t.Second() != 0 || t.Nanosecond() != 0:
+		return Second
+	case t.Minute() != 0:
+		return Minute
+	case t.Hour() != 0:
+		return Hour
+	case t.Day() != 1:
+		return Day
+	case t.Month() != time.January:
+		return Month
+	}
+	return Year
+}
+
+// Extent is the range and granularity of the times of a column.
+type Extent struct {
+	Min, Max    time.Time
+	Granularity Granularity
+}
+
+// Overlap returns the fraction of the range from start to end that the extent
+// covers, or 1 if start equals end and the extent covers it.
+func (e *Extent) Overlap(start, end time.Time) float64 {
+	from, to := start, end
+	if e.Min.After(from) {
+		from = e.Min
+	}
+	if e.Max.Before(to) {
+		to = e.Max
+	}
+	if to.Before(from) {
+		return 0
+	}
+	if d := end.Sub(start); d > 0 {
+		return float64(to.Sub(from)) / float64(d)
+	}
+	return 1
+}
+
+// A Profiler computes the Extent of a column from its values, which are
+// parsed as by coltype.ParseDate.
+type Profiler struct {
+	extent Extent
+	times  int64
+}
+
+// Add adds a value of the column. Blank values and values that are not times
+// are ignored.
+func (p *Profiler) Add(v string) {
+	t, ok := coltype.ParseDate(strings.TrimSpace(v))
+	if !ok {
+		return
+	}
+	t = t.UTC()
+	g := granularityOf(t)
+	if p.times == 0 {
+		p.extent = Extent{t, t, g}
+	} else {
+		if t.Before(p.extent.Min) {
+			p.extent.Min = t
+		}
+		if t.After(p.extent.Max) {
+			p.extent.Max = t
+		}
+		if g < p.extent.Granularity {
+			p.extent.Granularity = g
+		}
+	}
+	p.times++
+}
+
+// Extent returns the extent of the times added so far, or nil if there are
+// none.
+func (p *Profiler) Extent() *Extent {
+	if p.times == 0 {
+		return nil
+	}
+	e := p.extent
+	return &e
+}
@@ -0,0 +1,65 @@
+package temporal
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestProfiler(t *testing.T) {
+	tests := []struct {
+		values   []string
+		min, max time.Time
+		want     Granularity
+	}{
+		{[]string{"2019-01-01", "2021-01-01", "2020-01-01"}, date(2019, 1, 1), date(2021, 1, 1), Year},
+		{[]string{"2020-01-01T00:00:00.000", "2020-03-01T00:00:00.000"}, date(2020, 1, 1), date(2020, 3, 1), Month},
+		{[]string{"03/15/2021", "", "n/a", "03/01/2021"}, date(2021, 3, 1), date(2021, 3, 15), Day},
+		{[]string{"2020-01-01 10:00:00", "2020-01-01 10:30:00"}, time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), time.Date(2020, 1, 1, 10, 30, 0, 0, time.UTC), Minute},
+	}
+	for _, tt := range tests {
+		var p Profiler
+		for _, v := range tt.values {
+			p.Add(v)
+		}
+		e := p.Extent()
+		if e == nil {
+			t.Errorf("%q: no extent", tt.values)
+			continue
+		}
+		if !e.Min.Equal(tt.min) || !e.Max.Equal(tt.max) || e.Granularity != tt.want {
+			t.Errorf("%q: got %v to %v by %v, want %v to %v by %v",
+				tt.values, e.Min, e.Max, e.Granularity, tt.min, tt.max, tt.want)
+		}
+	}
+	var p Profiler
+	p.Add("Queens")
+	if e := p.Extent(); e != nil {
+		t.Errorf("got extent %+v of a column without times", e)
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	e := &Extent{date(2020, 1, 1), date(2021, 1, 1), Month}
+	tests := []struct {
+		start, end time.Time
+		want       float64
+	}{
+		{date(2020, 1, 1), date(2021, 1, 1), 1},
+		{date(2019, 1, 1), date(2021, 1, 1), 366.0 / 731},
+		{date(2022, 1, 1), date(2023, 1, 1), 0},
+		{date(2020, 6, 1), date(2020, 6, 1), 1},
+	}
+	for _, tt := range tests {
+		if got := e.Overlap(tt.start, tt.end); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Overlap(%v, %v) = %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+	if !Day.RollsUpTo(Month) || Year.RollsUpTo(Month) {
+		t.Error("days must roll up to months, and years not")
+	}
+}
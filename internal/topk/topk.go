@@ -0,0 +1,102 @@
+// Package topk finds the most frequent items of streams too large, or with
+// too many distinct items, to count them all: a Counter keeps the counts of a
+// fixed number of items with the Space-Saving algorithm (Metwally et al.,
+// 2005), which finds every item more frequent than 1/capacity of the stream,
+// and overestimates the counts of the items it found late by at most the
+// count of the item they replaced.
+package topk
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Item is an item of a stream and its estimated count.
+type Item struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+	// Maximum overestimation of Count.
+	Error int64 `json:"-"`
+}
+
+// Counter counts the most frequent items of a stream.
+type Counter struct {
+	capacity int
+	// Counted items, in a min-heap by count, and their indexes in it.
+	items   []*Item
+	indexes map[string]int
+}
+
+// New returns a Counter keeping the counts of capacity items. It panics if
+// capacity is not positive.
+func New(capacity int) *Counter {
+	if capacity <= 0 {
+		panic("topk: capacity must be positive")
+	}
+	return &Counter{capacity: capacity, indexes: make(map[string]int)}
+}
+
+// Add counts an occurrence of v.
+func (c *Counter) Add(v string) {
+	if i, ok := c.indexes[v]; ok {
+		c.items[i].Count++
+		heap.Fix((*itemHeap)(c), i)
+		return
+	}
+	if len(c.items) < c.capacity {
+		heap.Push((*itemHeap)(c), &Item{Value: v, Count: 1})
+		return
+	}
+	// The least counted item is replaced by v, which may have occurred as
+	// often before.
+	min := c.items[0]
+	delete(c.indexes, min.Value)
+	min.Value, min.Error = v, min.Count
+	min.Count++
+	c.indexes[v] = 0
+	heap.Fix((*itemHeap)(c), 0)
+}
+
+// Top returns at most k of the most frequent items, by decreasing count and
+// then value.
+func (c *Counter) Top(k int) []*Item {
+	items := make([]*Item, len(c.items))
+	for i, it := range c.items {
+		items[i] = &Item{it.Value, it.Count, it.Error}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Value < items[j].Value
+	})
+	if len(items) > k {
+		items = items[:k]
+	}
+	return items
+}
+
+// itemHeap is the heap.Interface of the items of a Counter.
+type itemHeap Counter
+
+func (h *itemHeap) Len() int           { return len(h.items) }
+func (h *itemHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+
+func (h *itemHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.indexes[h.items[i].Value] = i
+	h.indexes[h.items[j].Value] = j
+}
+
+func (h *itemHeap) Push(x interface{}) {
+	it := x.(*Item)
+	h.indexes[it.Value] = len(h.items)
+	h.items = append(h.items, it)
+}
+
+func (h *itemHeap) Pop() interface{} {
+	it := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.indexes, it.Value)
+	return it
+}
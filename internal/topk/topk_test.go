@@ -0,0 +1,50 @@
+package topk
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	// Values more frequent than 1/20 of the stream are found, among many
+	// that occur once.
+	c := New(20)
+	for i := 0; i < 1000; i++ {
+		switch {
+		case i%4 == 0:
+			c.Add("a")
+		case i%8 == 1:
+			c.Add("b")
+		case i%16 == 2:
+			c.Add("c")
+		default:
+			c.Add(fmt.Sprint("rare-", i))
+		}
+	}
+	top := c.Top(3)
+	if len(top) != 3 || top[0].Value != "a" || top[1].Value != "b" || top[2].Value != "c" {
+		t.Fatalf("got top items %v, want a, b, and c", values(top))
+	}
+	for i, want := range []int64{250, 125, 63} {
+		if got := top[i]; got.Count < want || got.Count-got.Error > want {
+			t.Errorf("%v: got count %d with error %d, want %d", got.Value, got.Count, got.Error, want)
+		}
+	}
+
+	c = New(5)
+	for _, v := range []string{"y", "x", "y", "x", "z"} {
+		c.Add(v)
+	}
+	top = c.Top(5)
+	if len(top) != 3 || top[0].Value != "x" || top[0].Count != 2 || top[0].Error != 0 || top[2].Value != "z" {
+		t.Errorf("got exact top items %v, want x and y twice and z once", values(top))
+	}
+}
+
+func values(items []*Item) []string {
+	var vs []string
+	for _, it := range items {
+		vs = append(vs, fmt.Sprintf("%v:%d", it.Value, it.Count))
+	}
+	return vs
+}
@@ -0,0 +1,101 @@
+// Package unionability scores how well the schemas of two tables align, for
+// finding tables whose rows can be appended to those of a query table.
+//
+// Each attribute of the query table is matched with at most one attribute of
+// the candidate table, by the cosine similarity of their attribute name
+// embeddings, and only with attributes of a compatible type; the score is the
+// fraction of the query attributes that are matched, weighted by similarity.
+package unionability
+
+import (
+	"sort"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// Type is the type of the values of an attribute.
+type Type string
+
+const (
+	// TypeUnknown is the type of attributes whose values were not sketched.
+	// It is compatible with every type.
+	TypeUnknown Type = ""
+	// TypeNumeric is the type of attributes whose values are mostly numbers.
+	TypeNumeric Type = "numeric"
+	// TypeText is the type of the other sketched attributes.
+	TypeText Type = "text"
+)
+
+// Compatible reports whether attributes of types a and b can hold the same
+// values.
+func Compatible(a, b Type) bool {
+	return a == TypeUnknown || b == TypeUnknown || a == b
+}
+
+// Attribute is an attribute of a table.
+type Attribute struct {
+	Name string
+	// Attribute embedding vector of the name.
+	Vector []float32
+	Type   Type
+}
+
+// Match is a query attribute matched with a candidate attribute.
+type Match struct {
+	Query, Candidate string
+	// Cosine similarity of the attribute name embeddings.
+	Similarity float32
+}
+
+// Alignment is the alignment of a candidate table with a query table.
+type Alignment struct {
+	// Sum of the similarities of the matches divided by the number of query
+	// attributes, between 0 and 1.
+	Score float64
+	// The matched attributes, sorted by similarity.
+	Matches []*Match
+}
+
+// Align aligns the attributes of the candidate table with those of the query
+// table. The pairs of attributes of compatible types with a similarity of at
+// least minSim are matched greedily, most similar first, so that each
+// attribute is matched at most once.
+func Align(query, candidate []*Attribute, minSim float32) *Alignment {
+	type pair struct {
+		q, c int
+		sim  float32
+	}
+	var pairs []pair
+
+	for i, q := range query {
+		for j, c := range candidate {
+			if !Compatible(q.Type, c.Type) {
+				continue
+			}
+			if sim := vec32.Cosine(q.Vector, c.Vector); sim >= minSim {
+				pairs = append(pairs, pair{i, j, sim})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].sim > pairs[j].sim
+	})
+
+	a := &Alignment{}
+	qMatched := make(map[int]bool)
+	cMatched := make(map[int]bool)
+	var sum float64
+
+	for _, p := range pairs {
+		if qMatched[p.q] || cMatched[p.c] {
+			continue
+		}
+		qMatched[p.q], cMatched[p.c] = true, true
+		a.Matches = append(a.Matches, &Match{query[p.q].Name, candidate[p.c].Name, p.sim})
+		sum += float64(p.sim)
+	}
+	if len(query) > 0 {
+		a.Score = sum / float64(len(query))
+	}
+	return a
+}
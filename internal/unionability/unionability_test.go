@@ -0,0 +1,45 @@
+package unionability
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAlign(t *testing.T) {
+	query := []*Attribute{
+		{"borough", []float32{1, 0, 0}, TypeText},
+		{"population", []float32{0, 1, 0}, TypeNumeric},
+		{"notes", []float32{0, 0, 1}, TypeText},
+	}
+	candidate := []*Attribute{
+		// Similar to both borough and population, so matched once.
+		{"boro", []float32{0.9, 0.1, 0}, TypeText},
+		{"boro_name", []float32{0.8, 0.2, 0}, TypeText},
+		// Same name embedding as notes, but numeric.
+		{"note_count", []float32{0, 0, 1}, TypeNumeric},
+		{"pop", []float32{0, 2, 0}, TypeUnknown},
+	}
+	a := Align(query, candidate, 0.5)
+
+	want := map[string]string{"borough": "boro", "population": "pop"}
+	if len(a.Matches) != len(want) {
+		t.Fatalf("got %d matches, want %d", len(a.Matches), len(want))
+	}
+	var sum float64
+	for _, m := range a.Matches {
+		if want[m.Query] != m.Candidate {
+			t.Errorf("%s matched with %s, want %s", m.Query, m.Candidate, want[m.Query])
+		}
+		sum += float64(m.Similarity)
+	}
+	if a.Matches[0].Query != "population" {
+		t.Errorf("matches not sorted by similarity: first is %s", a.Matches[0].Query)
+	}
+	if math.Abs(a.Score-sum/3) > 1e-6 {
+		t.Errorf("score %v, want %v", a.Score, sum/3)
+	}
+
+	if a := Align(nil, candidate, 0.5); a.Score != 0 || len(a.Matches) != 0 {
+		t.Errorf("empty query: got %+v", a)
+	}
+}
@@ -0,0 +1,67 @@
+// Package valueembedding creates embedding vectors for columns from samples of
+// their values, so that columns can be found by what they contain even if their
+// names are cryptic.
+package valueembedding
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// ErrNoEmb is returned by Vector when none of the values have an embedding.
+var ErrNoEmb = embedding.ErrNoEmb
+
+// Values returns the distinct values of the sample that are embedded by
+// Vector: blank and numeric values, which carry little meaning, are left out.
+func Values(sample []string) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, v := range sample {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			continue
+		}
+		seen[v] = true
+		values = append(values, v)
+	}
+	return values
+}
+
+// Vector creates an embedding vector for a column by averaging the embeddings
+// of the values of its sample (see Values) created with e.
+//
+// Returns a zero vector and ErrNoEmb if e has no embedding for any of the
+// values.
+func Vector(e embedding.Embedder, sample []string) ([]float32, error) {
+	vec := make([]float32, e.Dim())
+	values := Values(sample)
+	if len(values) == 0 {
+		return vec, ErrNoEmb
+	}
+	embs, errs, err := embedding.EmbedAll(e, values)
+	if err != nil {
+		return nil, err
+	}
+	foundEmb := false
+	for i, emb := range embs {
+		if errs[i] == ErrNoEmb {
+			continue
+		}
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		foundEmb = true
+		vec32.Add(vec, emb)
+	}
+	if !foundEmb {
+		return vec, ErrNoEmb
+	}
+	vec32.Normalize(vec)
+	return vec, nil
+}
@@ -0,0 +1,188 @@
+package vec32
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Encoding is a serialization of vectors.
+type Encoding byte
+
+const (
+	// Float32 stores each component as a big-endian float32 (see Bytes).
+	Float32 Encoding = iota
+	// Float16 stores each component as a big-endian IEEE 754 half-precision
+	// float, halving the size of the vectors.
+	Float16
+	// Int8 stores each component as a signed byte scaled by the largest
+	// absolute component, quartering the size of the vectors.
+	Int8
+)
+
+var encodingNames = []string{"float32", "float16", "int8"}
+
+func (enc Encoding) String() string {
+	if int(enc) < len(encodingNames) {
+		return encodingNames[enc]
+	}
+	return fmt.Sprintf("Encoding(%d)", enc)
+}
+
+// ParseEncoding returns the Encoding with the given name: "float32" (the
+// default if empty), "float16", or "int8".
+func ParseEncoding(name string) (Encoding, error) {
+	if name == "" {
+		return Float32, nil
+	}
+	for i, n := range encodingNames {
+		if n == name {
+			return Encoding(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown vector encoding %q (want float32, float16, or int8)", name)
+}
+
+// Length of the header of the quantized encodings.
+const headerLen = 4
+
+// The header of the quantized encodings is these bytes followed by the
+// encoding. Read as the first component of a Float32 vector, the header is a
+// NaN, so it is never mistaken for one.
+var headerMagic = [headerLen - 1]byte{0xff, 0xff, 0xff}
+
+// Encode serializes vec with the encoding enc. Float32 vectors are serialized
+// as by Bytes; the others start with a header recording their encoding, so
+// that FromBytes decodes all encodings transparently.
+func Encode(vec []float32, enc Encoding) []byte {
+	switch enc {
+	case Float16:
+		buf := header(Float16, 2*len(vec))
+		for i, v := range vec {
+			binary.BigEndian.PutUint16(buf[headerLen+2*i:], toFloat16(v))
+		}
+		return buf
+	case Int8:
+		buf := header(Int8, 4+len(vec))
+		max := float32(0)
+		for _, v := range vec {
+			if a := float32(math.Abs(float64(v))); a > max {
+				max = a
+			}
+		}
+		scale := max / 127
+		binary.BigEndian.PutUint32(buf[headerLen:], math.Float32bits(scale))
+		for i, v := range vec {
+			if scale != 0 {
+				buf[headerLen+4+i] = byte(int8(math.Round(float64(v / scale))))
+			}
+		}
+		return buf
+	}
+	return Bytes(vec)
+}
+
+func header(enc Encoding, size int) []byte {
+	buf := make([]byte, headerLen+size)
+	copy(buf, headerMagic[:])
+	buf[headerLen-1] = byte(enc)
+	return buf
+}
+
+// EncodingOf returns the encoding of the serialized vector data.
+func EncodingOf(data []byte) Encoding {
+	if len(data) >= headerLen && data[0] == headerMagic[0] && data[1] == headerMagic[1] &&
+		data[2] == headerMagic[2] {
+		return Encoding(data[headerLen-1])
+	}
+	return Float32
+}
+
+func decode(data []byte) ([]float32, error) {
+	enc := EncodingOf(data)
+	if enc == Float32 {
+		return fromFloat32Bytes(data)
+	}
+	body := data[headerLen:]
+	switch enc {
+	case Float16:
+		if len(body)%2 != 0 {
+			return nil, fmt.Errorf("float16 vector has odd length %d", len(body))
+		}
+		vec := make([]float32, len(body)/2)
+		for i := range vec {
+			vec[i] = fromFloat16(binary.BigEndian.Uint16(body[2*i:]))
+		}
+		return vec, nil
+	case Int8:
+		if len(body) < 4 {
+			return nil, fmt.Errorf("int8 vector has no scale")
+		}
+		scale := math.Float32frombits(binary.BigEndian.Uint32(body))
+		vec := make([]float32, len(body)-4)
+		for i, b := range body[4:] {
+			vec[i] = float32(int8(b)) * scale
+		}
+		return vec, nil
+	}
+	return nil, fmt.Errorf("unknown vector encoding %d", enc)
+}
+
+// toFloat16 returns the half-precision float nearest to f, rounding ties to
+// even.
+func toFloat16(f float32) uint16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int(b>>23&0xff) - 127 + 15
+	mant := b & 0x7fffff
+	switch {
+	case b&0x7fffffff == 0:
+		return sign
+	case b&0x7f800000 == 0x7f800000:
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp >= 0x1f:
+		// Overflows to infinity.
+		return sign | 0x7c00
+	case exp <= 0:
+		// Subnormal half, or underflows to zero.
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		h := mant >> shift
+		rem, mid := mant&(1<<shift-1), uint32(1)<<(shift-1)
+		if rem > mid || rem == mid && h&1 == 1 {
+			h++
+		}
+		return sign | uint16(h)
+	}
+	// Rounding up may carry into the exponent, which is still correct.
+	h := uint32(exp)<<10 | mant>>13
+	if rem := mant & 0x1fff; rem > 0x1000 || rem == 0x1000 && h&1 == 1 {
+		h++
+	}
+	return sign | uint16(h)
+}
+
+// fromFloat16 returns the half-precision float h as a float32.
+func fromFloat16(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch exp {
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case 0:
+		// Zero or subnormal: mant * 2^-24.
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
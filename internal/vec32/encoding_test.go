@@ -0,0 +1,130 @@
+package vec32
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestFloat16(t *testing.T) {
+	for _, tc := range []struct {
+		f float32
+		h uint16
+	}{
+		{0, 0x0000},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3c00},
+		{-2, 0xc000},
+		{65504, 0x7bff},
+		// Halfway between the largest half and 65536, rounded to even.
+		{65520, 0x7c00},
+		{1e10, 0x7c00},
+		{float32(math.Inf(-1)), 0xfc00},
+		// Halfway between 1 and the next half, rounded to even, and above.
+		{1 + 1.0/(1<<11), 0x3c00},
+		{1 + 3.0/(1<<11), 0x3c02},
+		// Smallest normal half, and the subnormals.
+		{1.0 / (1 << 14), 0x0400},
+		{1023.0 / (1 << 24), 0x03ff},
+		{1.0 / (1 << 24), 0x0001},
+		{1.5 / (1 << 25), 0x0001},
+		{1.0 / (1 << 25), 0x0000},
+		{1.0 / (1 << 30), 0x0000},
+	} {
+		if got := toFloat16(tc.f); got != tc.h {
+			t.Errorf("toFloat16(%g) = %#04x, want %#04x", tc.f, got, tc.h)
+		}
+	}
+	if h := toFloat16(float32(math.NaN())); h&0x7c00 != 0x7c00 || h&0x3ff == 0 {
+		t.Errorf("toFloat16(NaN) = %#04x, not a NaN", h)
+	}
+
+	// Every half that is not a NaN is converted back to itself.
+	for h := 0; h <= 0xffff; h++ {
+		if h&0x7c00 == 0x7c00 && h&0x3ff != 0 {
+			continue
+		}
+		if got := toFloat16(fromFloat16(uint16(h))); got != uint16(h) {
+			t.Errorf("toFloat16(fromFloat16(%#04x)) = %#04x", h, got)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	vec := []float32{1, -0.5, 0.25, 0, 3.75, -1e-3}
+	for _, tc := range []struct {
+		enc Encoding
+		// Size of the encoded vector, and maximum error of each component.
+		size int
+		eps  float64
+	}{
+		{Float32, 4 * len(vec), 0},
+		{Float16, headerLen + 2*len(vec), 1e-3},
+		{Int8, headerLen + 4 + len(vec), 3.75 / 127 / 2},
+	} {
+		data := Encode(vec, tc.enc)
+		if len(data) != tc.size {
+			t.Errorf("%v: got %d bytes, want %d", tc.enc, len(data), tc.size)
+		}
+		if enc := EncodingOf(data); enc != tc.enc {
+			t.Errorf("%v: EncodingOf = %v", tc.enc, enc)
+		}
+		got, err := FromBytes(data)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.enc, err)
+		}
+		if len(got) != len(vec) {
+			t.Fatalf("%v: got %d components, want %d", tc.enc, len(got), len(vec))
+		}
+		for i := range vec {
+			if math.Abs(float64(got[i]-vec[i])) > tc.eps {
+				t.Errorf("%v: component %d is %v, want %v", tc.enc, i, got[i], vec[i])
+			}
+		}
+	}
+
+	// A zero vector has no scale in int8.
+	got, err := FromBytes(Encode([]float32{0, 0}, Int8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != 0 || got[1] != 0 {
+		t.Errorf("got int8 zero vector %v", got)
+	}
+
+	if _, err := FromBytes(append(header(Float16, 0), 1)); err == nil {
+		t.Error("float16 vector of odd length decoded")
+	}
+	if _, err := FromBytes(header(Int8, 2)); err == nil {
+		t.Error("int8 vector without scale decoded")
+	}
+}
+
+func TestFromBytesFloat32(t *testing.T) {
+	// Vectors stored before the encodings were added are raw big-endian
+	// float32s, including those of negative first components, whose first
+	// bytes are 0xff only for NaNs.
+	vec := []float32{-1, float32(math.Inf(-1)), 0.5, -3.25e-7}
+	data := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.BigEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	if enc := EncodingOf(data); enc != Float32 {
+		t.Errorf("EncodingOf = %v, want float32", enc)
+	}
+	got, err := FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(vec) {
+		t.Fatalf("got %d components, want %d", len(got), len(vec))
+	}
+	for i := range vec {
+		if got[i] != vec[i] {
+			t.Errorf("component %d is %v, want %v", i, got[i], vec[i])
+		}
+	}
+	if got, err := FromBytes(nil); err != nil || len(got) != 0 {
+		t.Errorf("FromBytes(nil) = %v, %v", got, err)
+	}
+}
@@ -2,7 +2,6 @@
 package vec32
 
 import (
-	"bytes"
 	"encoding/binary"
 	"math"
 )
@@ -38,6 +37,19 @@ func Dot(a, b []float32) float32 {
 	return s
 }
 
+// Cosine returns the cosine similarity of a and b, or 0 if either is a zero
+// vector or their dimensions differ.
+func Cosine(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	n := Norm(a) * Norm(b)
+	if n == 0 {
+		return 0
+	}
+	return Dot(a, b) / n
+}
+
 // Norm returns the Euclidean norm of a.
 func Norm(a []float32) float32 {
 	s := float32(0)
@@ -48,27 +60,32 @@ func Norm(a []float32) float32 {
 }
 
 // Normalize converts a to its unit vector.
+// A zero vector is left unchanged.
 func Normalize(a []float32) {
-	Scale(a, 1/Norm(a))
+	if n := Norm(a); n != 0 {
+		Scale(a, 1/n)
+	}
 }
 
 // Bytes serializes vec into a byte slice.
 func Bytes(vec []float32) []byte {
-	buf := new(bytes.Buffer)
-	for _, v := range vec {
-		binary.Write(buf, binary.BigEndian, v)
+	buf := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.BigEndian.PutUint32(buf[4*i:], math.Float32bits(v))
 	}
-	return buf.Bytes()
+	return buf
 }
 
-// FromBytes converts a byte slice into slice of float32.
+// FromBytes converts a byte slice serialized by Bytes or Encode, in any
+// encoding, into slice of float32.
 func FromBytes(data []byte) ([]float32, error) {
+	return decode(data)
+}
+
+func fromFloat32Bytes(data []byte) ([]float32, error) {
 	vec := make([]float32, len(data)/4)
-	buf := bytes.NewReader(data)
 	for i := range vec {
-		if err := binary.Read(buf, binary.BigEndian, &vec[i]); err != nil {
-			return nil, err
-		}
+		vec[i] = math.Float32frombits(binary.BigEndian.Uint32(data[4*i:]))
 	}
 	return vec, nil
 }
@@ -0,0 +1,24 @@
+package vec32
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCosine(t *testing.T) {
+	for _, tc := range []struct {
+		a, b []float32
+		want float32
+	}{
+		{[]float32{1, 0}, []float32{2, 0}, 1},
+		{[]float32{1, 0}, []float32{0, 3}, 0},
+		{[]float32{1, 1}, []float32{-1, -1}, -1},
+		{[]float32{0, 0}, []float32{1, 1}, 0},
+		// Vectors of models of different dimensions are not comparable.
+		{[]float32{1, 1}, []float32{1, 1, 1}, 0},
+	} {
+		if got := Cosine(tc.a, tc.b); math.Abs(float64(got-tc.want)) > 1e-6 {
+			t.Errorf("Cosine(%v, %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Package opendatalink is the Open Data Link library API.
+//
+// It wires the Open Data Link database, the embedding model (by default,
+// fastText word embeddings), and the metadata index together, so that
+// datasets can be ingested and searched from Go programs without running the
+// command-line tools or the server:
+//
+//	cfg, err := opendatalink.DefaultConfig()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	odl, err := opendatalink.New(cfg)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer odl.Close()
+//
+//	err = odl.Ingest(&opendatalink.Dataset{DatasetID: "abcd-1234", Name: "Bus Stops"})
+//	err = odl.BuildIndex()
+//	results, err := odl.Search("public transit", 10)
+//
+// The database must have been created with sql/create_metadata_tables.sql.
+package opendatalink
+
+import (
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/embedding"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ErrNoEmbedding is returned when the embedding model has no embedding for a
+// query or a dataset's metadata.
+var ErrNoEmbedding = wordemb.ErrNoEmb
+
+// Embedder is a text embedding model.
+type Embedder = embedding.Embedder
+
+// ONNXConfig configures the ONNX sentence-transformer embedding model.
+type ONNXConfig = embedding.ONNXConfig
+
+// EmbeddingCacheConfig configures the cache of the embedding model's
+// embeddings.
+type EmbeddingCacheConfig = embedding.CacheConfig
+
+// NormalizeConfig configures the normalization of texts before they are
+// embedded.
+type NormalizeConfig = embedding.NormalizeConfig
+
+// Dataset is the metadata of a dataset.
+type Dataset = database.Metadata
+
+// Result is a search result.
+type Result struct {
+	Dataset *Dataset
+	// Cosine similarity of the dataset's metadata embedding with the query.
+	Similarity float32
+}
+
+// Config configures an OpenDataLink.
+type Config struct {
+	// Path of the Open Data Link database.
+	DatabasePath string
+	// Embedding model of the metadata embedding vectors. If nil, the model
+	// selected by EmbeddingBackend is opened. It must produce vectors of
+	// dimension EmbeddingDim, and the stored vectors must have been created
+	// with it.
+	Embedder Embedder
+	// Embedding backend used if Embedder is nil: "fasttext" (the default if
+	// empty) or "onnx".
+	EmbeddingBackend string
+	// Path of the fastText database, for the fasttext backend.
+	FasttextPath string
+	// Weighting of the averaged fastText word vectors: "" (equal weights),
+	// "idf", or "sif" (see embedding.Config), using the document frequencies
+	// saved by process_metadata in the database.
+	EmbeddingWeighting string
+	// Configuration of the onnx backend.
+	ONNX ONNXConfig
+	// Cache of the embeddings of the model opened if Embedder is nil. The
+	// embeddings are not cached if it is the zero value.
+	EmbeddingCache EmbeddingCacheConfig
+	// Normalization of the texts embedded by the model opened if Embedder is
+	// nil. It must match the normalization the stored vectors were created
+	// with.
+	Normalize NormalizeConfig
+	// Paths of the fastText databases of the models of dataset categories,
+	// by category, used if Embedder is nil (see embedding.Router). Datasets
+	// are embedded with the model of their first category that has one, and
+	// queries with every model.
+	CategoryModels map[string]string
+	// Version of the model opened if Embedder is nil, recorded with the
+	// vectors of ingested datasets (see embedding.Config.Version).
+	EmbeddingVersion string
+	// Handling of the datasets whose metadata has no embedding: "skip" (the
+	// default if empty), "flag", or "fallback" (see embedding.NoEmbPolicy).
+	NoEmbedding string
+	// Metadata index backend specification, e.g. "flat" or "ivf:1024:32".
+	IndexBackend string
+	// Dimension of the embedding vectors.
+	EmbeddingDim int
+}
+
+// DefaultConfig returns the Config used by the command-line tools, read from
+// the environment variables documented in the README.
+func DefaultConfig() (*Config, error) {
+	params, err := config.IndexParameters()
+	if err != nil {
+		return nil, err
+	}
+	emb, err := config.Embedding()
+	if err != nil {
+		return nil, err
+	}
+	categoryModels, err := config.CategoryModels()
+	if err != nil {
+		return nil, err
+	}
+	noEmb, err := config.NoEmbPolicy()
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		DatabasePath:       config.DatabasePath(),
+		EmbeddingBackend:   emb.Backend,
+		FasttextPath:       emb.FasttextPath,
+		EmbeddingWeighting: emb.Weighting,
+		ONNX:               emb.ONNX,
+		EmbeddingCache:     emb.Cache,
+		Normalize:          emb.Normalize,
+		CategoryModels:     categoryModels,
+		EmbeddingVersion:   emb.Version,
+		NoEmbedding:        string(noEmb),
+		IndexBackend:       config.IndexBackend(),
+		EmbeddingDim:       params.EmbeddingDim,
+	}, nil
+}
+
+// OpenDataLink is an Open Data Link instance. It is safe for concurrent use.
+type OpenDataLink struct {
+	db       *database.DB
+	embedder Embedder
+	// The embedding model opened by New, if any.
+	model embedding.Model
+	// The router of the category models, if any.
+	router *embedding.Router
+	// The version of the vectors of the model opened by New, if any.
+	version     *embedding.Version
+	noEmbPolicy embedding.NoEmbPolicy
+	index       *index.Manager
+}
+
+// New opens the databases in cfg and builds the metadata index over the
+// datasets already in the database.
+func New(cfg *Config) (*OpenDataLink, error) {
+	noEmbPolicy, err := embedding.ParseNoEmbPolicy(cfg.NoEmbedding)
+	if err != nil {
+		return nil, err
+	}
+	db, err := database.New(cfg.DatabasePath)
+	if err != nil {
+		return nil, err
+	}
+	o := &OpenDataLink{db: db, embedder: cfg.Embedder, noEmbPolicy: noEmbPolicy}
+	if o.embedder == nil {
+		ecfg := &embedding.Config{
+			Backend:      cfg.EmbeddingBackend,
+			FasttextPath: cfg.FasttextPath,
+			Weighting:    cfg.EmbeddingWeighting,
+			DatabasePath: cfg.DatabasePath,
+			ONNX:         cfg.ONNX,
+			Cache:        cfg.EmbeddingCache,
+			Normalize:    cfg.Normalize,
+			Version:      cfg.EmbeddingVersion,
+		}
+		if ecfg, err = ecfg.Resolve(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		if len(cfg.CategoryModels) > 0 {
+			o.router, err = embedding.OpenRouter(ecfg, cfg.CategoryModels)
+			o.model = o.router
+		} else {
+			o.model, err = embedding.Open(ecfg)
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		o.embedder = o.model
+		v := ecfg.ModelVersion(o.model.Dim())
+		o.version = &v
+	}
+	if err := embedding.CheckDim(o.embedder, cfg.EmbeddingDim); err != nil {
+		o.close()
+		return nil, err
+	}
+	if o.version != nil {
+		versions := map[string]embedding.Version{"": *o.version}
+		if o.router != nil {
+			versions = o.router.Versions()
+		}
+		if err := index.CheckVersions(db, "metadata_vectors", versions); err != nil {
+			o.close()
+			return nil, err
+		}
+	}
+
+	o.index, err = index.NewManager("metadata", func() (index.Querier, error) {
+		if o.router != nil {
+			return index.BuildRoutedMetadataIndex(db, cfg.IndexBackend, o.router.Dims())
+		}
+		return index.BuildMetadataIndex(db, cfg.IndexBackend, cfg.EmbeddingDim)
+	})
+	if err != nil {
+		o.close()
+		return nil, err
+	}
+	return o, nil
+}
+
+// Close frees the index and closes the databases.
+func (o *OpenDataLink) Close() error {
+	o.index.Delete()
+	return o.close()
+}
+
+// close closes the databases and the embedding model opened by New.
+func (o *OpenDataLink) close() error {
+	if o.model != nil {
+		o.model.Close()
+	}
+	return o.db.Close()
+}
+
+// Ingest stores the metadata of a dataset and its metadata embedding vector,
+// replacing any existing dataset with the same ID.
+//
+// The dataset is searchable after the next BuildIndex. If none of its metadata
+// words have an embedding, the metadata is stored and ErrNoEmbedding is
+// returned, unless Config.NoEmbedding is "fallback" and the metadata is
+// embedded by its spelling instead. With "flag", a zero vector, which is not
+// indexed, is stored for it.
+func (o *OpenDataLink) Ingest(d *Dataset) error {
+	var model string
+	e := o.embedder
+	if o.router != nil {
+		model, e = o.router.Route(d.Categories)
+	}
+	vec, embErr := wordemb.MetadataVector(e, d)
+	if embErr != nil && embErr != wordemb.ErrNoEmb {
+		return embErr
+	}
+	if err := o.db.InsertMetadata(d); err != nil {
+		return err
+	}
+	var noEmb embedding.NoEmbCounts
+	vec, err := o.noEmbPolicy.Apply(vec, embErr, func(e embedding.Embedder) ([]float32, error) {
+		return wordemb.MetadataVector(e, d)
+	}, &noEmb)
+	if err != nil {
+		return err
+	}
+	if vec == nil {
+		// The vector of a replaced dataset is no longer that of its metadata.
+		if err := o.db.DeleteMetadataVector(d.DatasetID); err != nil {
+			return err
+		}
+		return ErrNoEmbedding
+	}
+	if err := o.setVector(d.DatasetID, model, vec); err != nil {
+		return err
+	}
+	if noEmb.Flagged > 0 {
+		return ErrNoEmbedding
+	}
+	return nil
+}
+
+// setVector stores the metadata embedding vector of a dataset, created with
+// the named model, and its version.
+func (o *OpenDataLink) setVector(datasetID, model string, vec []float32) error {
+	if o.router != nil {
+		if err := o.db.SetVectorModel(datasetID, model, len(vec)); err != nil {
+			return err
+		}
+	}
+	if err := o.db.SetMetadataVector(datasetID, vec); err != nil {
+		return err
+	}
+	if o.version == nil {
+		return nil
+	}
+	v := *o.version
+	if o.router != nil {
+		v = o.router.Version(model)
+	}
+	return o.db.SetVectorVersion("metadata_vectors", datasetID, "", v.Model, v.Version, v.Dim)
+}
+
+// BuildIndex rebuilds the metadata index over the datasets in the database.
+// Searches are served from the old index until the new one is built.
+func (o *OpenDataLink) BuildIndex() error {
+	return o.index.Rebuild()
+}
+
+// Search returns the (up to) k datasets whose metadata is most similar to the
+// query, sorted by similarity.
+func (o *OpenDataLink) Search(query string, k int) ([]*Result, error) {
+	var vec []float32
+	var err error
+	if o.router != nil {
+		vec, err = o.router.EmbedRouted(query)
+	} else {
+		vec, err = wordemb.Vector(o.embedder, []string{query})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return o.query(vec, k, "")
+}
+
+// SimilarDatasets returns the (up to) k datasets whose metadata is most similar
+// to that of the given dataset, sorted by similarity.
+func (o *OpenDataLink) SimilarDatasets(datasetID string, k int) ([]*Result, error) {
+	vec, err := o.db.MetadataVector(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	if o.router != nil {
+		// Only the datasets embedded with the same model are comparable.
+		model, err := o.db.VectorModel(datasetID)
+		if err != nil {
+			return nil, err
+		}
+		vec = o.router.RoutedVector(model, vec)
+	}
+	return o.query(vec, k, datasetID)
+}
+
+// query queries the metadata index with vec, excluding the dataset with ID
+// except.
+func (o *OpenDataLink) query(vec []float32, k int, except string) ([]*Result, error) {
+	n := int64(k)
+	if except != "" {
+		n++
+	}
+	ids, sims, err := o.index.Query(vec, n)
+	if err != nil {
+		return nil, err
+	}
+	var results []*Result
+
+	for i, id := range ids {
+		if id == except || len(results) == k {
+			continue
+		}
+		meta, err := o.db.Metadata(id)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &Result{meta, sims[i]})
+	}
+	return results, nil
+}
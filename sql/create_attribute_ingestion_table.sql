@@ -0,0 +1,12 @@
+CREATE TABLE attribute_ingestion (
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL PRIMARY KEY,
+    -- SHA-256 hash of the attribute file (attribute.json, or otherwise the
+    -- dataset's rows file) being or last processed, in hex.
+    content_hash TEXT NOT NULL,
+    -- processing while the attributes of the file are being stored, and done
+    -- once they are.
+    status TEXT NOT NULL,
+    -- Time the status was last set (UTC).
+    processed_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
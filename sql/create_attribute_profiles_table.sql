@@ -0,0 +1,11 @@
+CREATE TABLE attribute_profiles (
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- The attribute (column) name.
+    attribute_name TEXT NOT NULL,
+    -- Type inferred from the sampled values (see coltype.Type).
+    attribute_type TEXT NOT NULL,
+    -- Sampled distinct values (JSON array).
+    sample TEXT NOT NULL,
+    PRIMARY KEY (dataset_id, attribute_name)
+);
@@ -1,8 +1,10 @@
 -- chem
 CREATE TABLE attribute_vectors (
     -- The Socrata dataset four-by-four.
-    dataset_id TEXT NOT NULL PRIMARY KEY,
+    dataset_id TEXT NOT NULL,
+    -- The attribute (column) name.
     attribute_name TEXT NOT NULL,
     -- Embedding vector.
-    emb BLOB NOT NULL
+    emb BLOB NOT NULL,
+    PRIMARY KEY (dataset_id, attribute_name)
 );
@@ -0,0 +1,12 @@
+CREATE TABLE column_domains (
+    -- The column ID of the column in the column_sketches table.
+    column_id TEXT NOT NULL PRIMARY KEY,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- The semantic domain of the column values: email, phone, zip_code,
+    -- latitude, longitude, date, or person_name. Columns in no domain are
+    -- not stored.
+    domain TEXT NOT NULL
+);
+CREATE INDEX column_domains_dataset_idx ON column_domains(dataset_id);
+CREATE INDEX column_domains_domain_idx ON column_domains(domain);
@@ -0,0 +1,10 @@
+CREATE TABLE column_types (
+    -- The column ID of the column in the column_sketches table.
+    column_id TEXT NOT NULL PRIMARY KEY,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- The inferred type of the values: int, float, date, bool, or string, or
+    -- empty if the column has no values.
+    column_type TEXT NOT NULL
+);
+CREATE INDEX column_types_dataset_idx ON column_types(dataset_id);
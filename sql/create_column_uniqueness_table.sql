@@ -0,0 +1,13 @@
+CREATE TABLE column_uniqueness (
+    -- The column ID of the column in the column_sketches table.
+    column_id TEXT NOT NULL PRIMARY KEY,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- Number of rows, including those with a blank value.
+    row_count INT NOT NULL,
+    -- Approximate distinct count of the values divided by the number of rows,
+    -- at most 1. Columns with a uniqueness of at least 0.95 are candidate
+    -- keys.
+    uniqueness REAL NOT NULL
+);
+CREATE INDEX column_uniqueness_dataset_idx ON column_uniqueness(dataset_id);
@@ -0,0 +1,16 @@
+CREATE TABLE correlation_sketches (
+    -- The column IDs of the key column and the numeric column in the
+    -- column_sketches table.
+    key_column_id TEXT NOT NULL,
+    value_column_id TEXT NOT NULL,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- Maximum number of entries of the sketch.
+    size INT NOT NULL,
+    -- JSON array of the entries of the keys with the smallest hashes, sorted
+    -- by hash: {"h": <hash of the key>, "v": <mean of the numeric values>}.
+    entries TEXT NOT NULL,
+    PRIMARY KEY (key_column_id, value_column_id)
+);
+CREATE INDEX correlation_sketches_value_idx ON correlation_sketches(value_column_id);
+CREATE INDEX correlation_sketches_dataset_idx ON correlation_sketches(dataset_id);
@@ -0,0 +1,14 @@
+CREATE TABLE dataset_checksums (
+    -- Name of the ingestion command, such as sketch_columns.
+    command TEXT NOT NULL,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- Conventional name of the dataset file, such as rows.csv, or rows for
+    -- the partitions of a partitioned dataset.
+    file TEXT NOT NULL,
+    -- SHA-256 hash of the file, as read by the command, in hex.
+    checksum TEXT NOT NULL,
+    -- Time the command last processed the file (UTC).
+    checked_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (command, dataset_id, file)
+);
@@ -0,0 +1,20 @@
+CREATE TABLE distribution_downloads (
+    -- The dataset ID of the distribution.
+    dataset_id TEXT NOT NULL PRIMARY KEY,
+    -- URL of the distribution downloaded, from the source_distributions
+    -- table.
+    url TEXT NOT NULL,
+    -- Name of the file of the distribution in the directory of the dataset,
+    -- such as rows.csv or rows.csv.gz.
+    file TEXT NOT NULL,
+    -- SHA-256 hash of the file, in hex, which names it in the download cache,
+    -- and its size, in bytes.
+    sha256 TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    -- ETag and Last-Modified headers of the last response of the file, or
+    -- empty if it had none.
+    etag TEXT NOT NULL,
+    last_modified TEXT NOT NULL,
+    -- Time the file was last downloaded or checked (UTC).
+    downloaded_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
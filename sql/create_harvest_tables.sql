@@ -0,0 +1,43 @@
+CREATE TABLE source_columns (
+    -- The dataset ID, such as a Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- Position of the column in the columns declared by the catalog, from 0.
+    position INTEGER NOT NULL,
+    -- Display name of the column.
+    name TEXT NOT NULL,
+    -- Name of the column in the files of the dataset, or empty if it is the
+    -- display name.
+    field_name TEXT NOT NULL,
+    -- Type of the column, as named by the catalog, such as text or number.
+    type TEXT NOT NULL,
+    -- Description of the column.
+    description TEXT NOT NULL,
+    PRIMARY KEY (dataset_id, position)
+);
+
+CREATE TABLE source_distributions (
+    -- The dataset ID, such as a Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- Position of the file in the files listed by the catalog, from 0.
+    position INTEGER NOT NULL,
+    -- Name of the file.
+    name TEXT NOT NULL,
+    -- URL of the file.
+    url TEXT NOT NULL,
+    -- Format of the file, as named by the catalog, such as CSV.
+    format TEXT NOT NULL,
+    -- Description of the file.
+    description TEXT NOT NULL,
+    PRIMARY KEY (dataset_id, position)
+);
+
+CREATE TABLE harvested_datasets (
+    -- The dataset ID, such as a Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL PRIMARY KEY,
+    -- Catalog the dataset was harvested from, such as
+    -- socrata:data.cityofnewyork.us.
+    source TEXT NOT NULL,
+    -- Time the dataset was last harvested (UTC).
+    harvested_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX harvested_datasets_source_idx ON harvested_datasets(source);
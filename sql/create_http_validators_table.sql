@@ -0,0 +1,8 @@
+CREATE TABLE http_validators (
+    -- URL of the resource.
+    url TEXT NOT NULL PRIMARY KEY,
+    -- ETag and Last-Modified headers of the last response processed, or
+    -- empty if it had none.
+    etag TEXT NOT NULL,
+    last_modified TEXT NOT NULL
+);
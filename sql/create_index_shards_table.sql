@@ -0,0 +1,9 @@
+CREATE TABLE index_shards (
+    -- The name of the sharded index, e.g. "metadata".
+    index_name TEXT NOT NULL,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- The shard the dataset's vector is stored in.
+    shard INT NOT NULL,
+    PRIMARY KEY (index_name, dataset_id)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE index_tuning (
+    -- The name of the tuned index, e.g. "metadata".
+    index_name TEXT PRIMARY KEY,
+    -- The recommended backend specification, e.g. "ivf:1024:32".
+    backend TEXT NOT NULL,
+    -- The measured recall of the exact nearest neighbors.
+    recall REAL NOT NULL,
+    -- The measured mean query latency in microseconds.
+    latency_us INT NOT NULL,
+    -- When the index was tuned, in RFC 3339 format.
+    tuned_at TEXT NOT NULL
+);
@@ -0,0 +1,24 @@
+CREATE TABLE ingest_runs (
+    -- Name of the ingestion command, such as process_attribute.
+    command TEXT NOT NULL,
+    -- Time the run started (UTC).
+    started_at TEXT NOT NULL,
+    elapsed_seconds REAL NOT NULL,
+    -- What the command processes, such as datasets or columns, and how many
+    -- of them it processed, successfully or not, out of the total.
+    unit TEXT NOT NULL,
+    processed INTEGER NOT NULL,
+    total INTEGER NOT NULL,
+    -- Items left alone, e.g. unchanged since the previous run or empty.
+    skipped INTEGER NOT NULL,
+    -- Datasets that could not be processed (see ingestion_errors).
+    failed INTEGER NOT NULL,
+    -- Texts embedded, such as attribute names, and the fraction of them that
+    -- the model had no embedding for, or NULL if nothing was embedded.
+    embedded INTEGER NOT NULL,
+    oov_rate REAL,
+    -- Other counts of the command as a JSON object, e.g. {"vectors": 120}.
+    counts TEXT NOT NULL
+);
+
+CREATE INDEX ingest_runs_command ON ingest_runs (command, started_at);
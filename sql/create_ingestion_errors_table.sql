@@ -0,0 +1,14 @@
+CREATE TABLE ingestion_errors (
+    -- Name of the ingestion command, such as process_attribute.
+    command TEXT NOT NULL,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- Why the dataset could not be processed. A dataset may have several
+    -- errors, e.g. one per column for process_values.
+    error TEXT NOT NULL,
+    -- Time of the failure (UTC). The errors of a command are replaced by those
+    -- of its last run.
+    failed_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX ingestion_errors_command ON ingestion_errors (command);
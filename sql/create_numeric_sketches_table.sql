@@ -0,0 +1,17 @@
+CREATE TABLE numeric_sketches (
+    -- The column ID of the column in the column_sketches table.
+    column_id TEXT NOT NULL PRIMARY KEY,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- Number of numeric values.
+    count INTEGER NOT NULL,
+    -- Minimum, maximum, and mean of the values.
+    min REAL NOT NULL,
+    max REAL NOT NULL,
+    mean REAL NOT NULL,
+    -- Deciles of the values encoded as a JSON array.
+    quantiles TEXT NOT NULL,
+    -- Fractions of the values in 10 equal-width bins between the minimum and
+    -- maximum encoded as a JSON array.
+    histogram TEXT NOT NULL
+);
@@ -0,0 +1,37 @@
+CREATE TABLE dataset_profiles (
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL PRIMARY KEY,
+    -- Number of rows, not counting the header.
+    row_count INTEGER NOT NULL,
+    column_count INTEGER NOT NULL,
+    -- Time the dataset was profiled (UTC).
+    profiled_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE column_profiles (
+    -- The column ID of the column in the column_sketches table.
+    column_id TEXT NOT NULL PRIMARY KEY,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    column_name TEXT NOT NULL,
+    -- The inferred type of the values (see column_types).
+    column_type TEXT NOT NULL,
+    -- Number and fraction of the rows with a null value: blank, or a marker
+    -- such as NULL or N/A.
+    null_count INTEGER NOT NULL,
+    null_rate REAL NOT NULL,
+    -- Approximate number of distinct non-null values.
+    distinct_count INTEGER NOT NULL,
+    -- Most frequent non-null values and their approximate counts, by
+    -- decreasing count, encoded as a JSON array of {"value", "count"}
+    -- objects.
+    top_values TEXT NOT NULL,
+    -- Number of numeric values, and their minimum, maximum, mean, and
+    -- standard deviation, which are NULL if there are none.
+    numeric_count INTEGER NOT NULL,
+    min REAL,
+    max REAL,
+    mean REAL,
+    stddev REAL
+);
+CREATE INDEX column_profiles_dataset_idx ON column_profiles(dataset_id);
@@ -0,0 +1,19 @@
+CREATE TABLE relationships (
+    -- The column ID of the referencing (foreign key) column in the
+    -- column_sketches table.
+    column_id TEXT NOT NULL,
+    -- The Socrata dataset four-by-four of the referencing column.
+    dataset_id TEXT NOT NULL,
+    -- The column ID of the referenced column, a candidate key of its table.
+    key_column_id TEXT NOT NULL,
+    -- The Socrata dataset four-by-four of the referenced column.
+    key_dataset_id TEXT NOT NULL,
+    -- Estimated fraction of the distinct values of the referencing column
+    -- that are values of the referenced column.
+    containment REAL NOT NULL,
+    -- Estimated number of distinct values of both columns.
+    shared_values REAL NOT NULL,
+    PRIMARY KEY (column_id, key_column_id)
+);
+CREATE INDEX relationships_dataset_idx ON relationships(dataset_id);
+CREATE INDEX relationships_key_dataset_idx ON relationships(key_dataset_id);
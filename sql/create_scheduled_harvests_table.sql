@@ -0,0 +1,15 @@
+CREATE TABLE scheduled_harvests (
+    -- Name of the source in the schedule of schedule_harvests.
+    source TEXT NOT NULL PRIMARY KEY,
+    -- Time the last run of the source started (UTC).
+    started_at TEXT NOT NULL,
+    -- ok, or the error of the last run if it failed.
+    status TEXT NOT NULL,
+    -- Datasets the last run changed and removed, or -1 if it did not report
+    -- them.
+    changed INTEGER NOT NULL,
+    removed INTEGER NOT NULL,
+    -- 1 once the datasets of the last run were processed by process_datasets,
+    -- 0 while they are still to be processed.
+    processed INTEGER NOT NULL
+);
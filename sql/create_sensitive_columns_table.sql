@@ -0,0 +1,11 @@
+CREATE TABLE sensitive_columns (
+    -- The column ID of the column in the column_sketches table.
+    column_id TEXT NOT NULL PRIMARY KEY,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- The kinds of personal information found in the sample values of the
+    -- column, separated by commas: email, ssn, phone, or person_name.
+    -- Columns without any are not stored.
+    kinds TEXT NOT NULL
+);
+CREATE INDEX sensitive_columns_dataset_idx ON sensitive_columns(dataset_id);
@@ -0,0 +1,11 @@
+CREATE TABLE sketch_versions (
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL PRIMARY KEY,
+    -- SHA-256 hash of the dataset file (rows.csv) that was sketched, in hex.
+    content_hash TEXT NOT NULL,
+    -- Version of the sketches, incremented each time a changed dataset file
+    -- is sketched.
+    version INTEGER NOT NULL,
+    -- Time the dataset was last sketched (UTC).
+    sketched_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
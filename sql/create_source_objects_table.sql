@@ -0,0 +1,15 @@
+CREATE TABLE source_objects (
+    -- The dataset ID of the object.
+    dataset_id TEXT NOT NULL PRIMARY KEY,
+    -- URL of the bucket of the object, such as s3://bucket.
+    bucket TEXT NOT NULL,
+    -- Key of the object in the bucket.
+    key TEXT NOT NULL,
+    -- Entity tag of the contents of the object when it was harvested.
+    etag TEXT NOT NULL,
+    -- Size of the object, in bytes.
+    size INTEGER NOT NULL,
+    -- Time the object was last modified, in RFC 3339 format.
+    modified TEXT NOT NULL
+);
+CREATE UNIQUE INDEX source_objects_bucket_key_idx ON source_objects(bucket, key);
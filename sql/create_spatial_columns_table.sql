@@ -0,0 +1,19 @@
+CREATE TABLE spatial_columns (
+    -- The column ID of the column in the column_sketches table, or of the
+    -- latitude column of a pair of latitude and longitude columns.
+    column_id TEXT NOT NULL PRIMARY KEY,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- lat_long, point, geohash, zip_code, or fips_code.
+    kind TEXT NOT NULL,
+    -- The column ID of the longitude column of lat_long columns.
+    longitude_column_id TEXT,
+    -- Bounding box in degrees of lat_long, point, and geohash columns.
+    min_lat REAL,
+    min_long REAL,
+    max_lat REAL,
+    max_long REAL,
+    -- JSON array of the regions of zip_code and fips_code columns.
+    regions TEXT
+);
+CREATE INDEX spatial_columns_dataset_idx ON spatial_columns(dataset_id);
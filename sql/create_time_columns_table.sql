@@ -0,0 +1,15 @@
+CREATE TABLE time_columns (
+    -- The column ID of the column in the column_sketches table.
+    column_id TEXT NOT NULL PRIMARY KEY,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- Finest granularity of the times: second, minute, hour, day, month, or
+    -- year.
+    granularity TEXT NOT NULL,
+    -- Earliest and latest times, in UTC and formatted as
+    -- 2006-01-02T15:04:05Z, so that they compare as strings.
+    min_time TEXT NOT NULL,
+    max_time TEXT NOT NULL
+);
+CREATE INDEX time_columns_dataset_idx ON time_columns(dataset_id);
+CREATE INDEX time_columns_range_idx ON time_columns(min_time, max_time);
@@ -0,0 +1,10 @@
+CREATE TABLE value_vectors (
+    -- The column ID of the column in the column_sketches table.
+    column_id TEXT NOT NULL PRIMARY KEY,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- The column name.
+    column_name TEXT NOT NULL,
+    -- Embedding vector of the sampled column values.
+    emb BLOB NOT NULL
+);
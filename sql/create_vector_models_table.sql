@@ -0,0 +1,9 @@
+CREATE TABLE vector_models (
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL PRIMARY KEY,
+    -- Name of the embedding model of the dataset's metadata and attribute
+    -- vectors: the category it was routed by, or '' for the default model.
+    model TEXT NOT NULL,
+    -- Dimension of the model's vectors.
+    dim INTEGER NOT NULL
+);
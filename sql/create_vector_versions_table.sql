@@ -0,0 +1,19 @@
+CREATE TABLE vector_versions (
+    -- Table of the vector: metadata_vectors, attribute_vectors, or
+    -- value_vectors.
+    vectors TEXT NOT NULL,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- Key of the vector within the dataset: the attribute name of attribute
+    -- vectors, the column ID of value vectors, or '' for metadata vectors.
+    name TEXT NOT NULL,
+    -- Name of the embedding model that created the vector.
+    model TEXT NOT NULL,
+    -- Version of the embedding model, or '' if unversioned.
+    version TEXT NOT NULL,
+    -- Dimension of the vector.
+    dim INTEGER NOT NULL,
+    -- Time the vector was created (UTC), set when the version is recorded.
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (vectors, dataset_id, name)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE word_documents (
+    -- A lowercased word of the metadata text, or the empty string for the
+    -- total number of datasets.
+    word TEXT NOT NULL PRIMARY KEY,
+    -- The number of datasets whose metadata text contains the word.
+    documents INT NOT NULL
+);
+CREATE TABLE sif_component (
+    -- The common component removed from the SIF metadata embedding vectors.
+    emb BLOB NOT NULL
+);